@@ -19,6 +19,8 @@ type Account struct {
 	ID string `json:"ID,omitempty"`
 	// The unique identifier for the customer who owns the account
 	CustomerID string `json:"customerID,omitempty"`
+	// The organization which owns the account
+	OrganizationID string `json:"organizationID,omitempty"`
 	// Caller defined label for this account.
 	Name string `json:"name,omitempty"`
 	// A unique Account number at the bank.
@@ -35,10 +37,12 @@ type Account struct {
 	ClosedAt  time.Time `json:"closedAt,omitempty"`
 	// Last time the object was modified except balances
 	LastModified time.Time `json:"lastModified,omitempty"`
-	// Total balance of account in USD cents.
+	// Total balance of account in USD cents. Funds reserved by held transactions are excluded.
 	Balance int32 `json:"balance,omitempty"`
-	// Balance available in USD cents to be drawn
+	// Balance available in USD cents to be drawn, which also excludes the debits of pending transactions
 	BalanceAvailable int32 `json:"balanceAvailable,omitempty"`
-	// Balance of pending transactions in USD cents
+	// Net amount in USD cents of pending transactions and held credits which aren't applied to the balance yet
 	BalancePending int32 `json:"balancePending,omitempty"`
+	// Amount in USD cents the balance can be overdrawn by. Debits which would take the balance below the negative of this limit are rejected for insufficient funds.
+	OverdraftLimit int32 `json:"overdraftLimit,omitempty"`
 }
@@ -0,0 +1,22 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// AccountLimit struct for AccountLimit
+type AccountLimit struct {
+	// Which debits share the limit
+	Class string `json:"class,omitempty"`
+	// Most the account can be debited in the class each day in USD cents
+	Limit int32 `json:"limit,omitempty"`
+	// Debits in the class today in USD cents, including pending and held transactions
+	Used int32 `json:"used,omitempty"`
+	// How much more the account can be debited in the class today in USD cents
+	Remaining int32 `json:"remaining,omitempty"`
+}
@@ -0,0 +1,25 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+import (
+	"time"
+)
+
+// AccountLimits struct for AccountLimits
+type AccountLimits struct {
+	// Account ID
+	AccountId string `json:"accountId,omitempty"`
+	// UTC day the limits are for formatted as YYYY-MM-DD
+	Day string `json:"day,omitempty"`
+	// When the limits reset
+	ResetsAt time.Time      `json:"resetsAt,omitempty"`
+	Limits   []AccountLimit `json:"limits,omitempty"`
+}
@@ -0,0 +1,21 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// AttachmentType Kind of supporting document
+type AttachmentType string
+
+// List of AttachmentType
+const (
+	CHECK_IMAGE AttachmentType = "checkImage"
+	INVOICE     AttachmentType = "invoice"
+	RECEIPT     AttachmentType = "receipt"
+	OTHER       AttachmentType = "other"
+)
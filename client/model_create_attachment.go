@@ -0,0 +1,24 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// CreateAttachment struct for CreateAttachment
+type CreateAttachment struct {
+	Type AttachmentType `json:"type"`
+	// Location of the document. Either url or objectKey is required.
+	Url string `json:"url,omitempty"`
+	// Object storage key of the document. Either url or objectKey is required.
+	ObjectKey string `json:"objectKey,omitempty"`
+	// Hex encoded SHA-256 hash of the document
+	ContentHash string `json:"contentHash"`
+	// MIME type of the document
+	ContentType string `json:"contentType,omitempty"`
+	Description string `json:"description,omitempty"`
+}
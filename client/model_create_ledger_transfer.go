@@ -0,0 +1,22 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// CreateLedgerTransfer struct for CreateLedgerTransfer
+type CreateLedgerTransfer struct {
+	// Name of the Accounts instance to transfer to, as configured in LEDGER_PEERS
+	Peer string `json:"peer"`
+	// Account debited on this instance
+	SourceAccountId string `json:"sourceAccountId"`
+	// Account credited on the peer
+	DestinationAccountId string `json:"destinationAccountId"`
+	// Amount transferred (in USD cents)
+	Amount int32 `json:"amount"`
+}
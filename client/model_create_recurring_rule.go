@@ -0,0 +1,32 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+import (
+	"time"
+)
+
+// CreateRecurringRule struct for CreateRecurringRule
+type CreateRecurringRule struct {
+	// Account on the other side of each transaction
+	CounterpartyAccountID string `json:"counterpartyAccountId,omitempty"`
+	// Amount in cents of each transaction
+	Amount int32 `json:"amount,omitempty"`
+	// Whether the account is debited (such as for a fee) or credited (such as for interest) by each transaction
+	Direction string `json:"direction,omitempty"`
+	// Purpose of the counterparty side when the account is debited, or of the account's side when it is credited. achdebit isn't allowed.
+	Purpose string `json:"purpose,omitempty"`
+	// Description added to each transaction
+	Description string `json:"description,omitempty"`
+	// iCalendar RRULE with FREQ (DAILY, WEEKLY, MONTHLY or YEARLY) and optionally INTERVAL, BYDAY (weekly), BYMONTHDAY (monthly), and one of COUNT or UNTIL
+	Schedule string `json:"schedule,omitempty"`
+	// Time the schedule starts from, which defaults to now. Occurrences before the rule was created aren't posted.
+	Start time.Time `json:"start,omitempty"`
+}
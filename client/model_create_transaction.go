@@ -11,5 +11,14 @@ package openapi
 
 // CreateTransaction struct for CreateTransaction
 type CreateTransaction struct {
-	Lines []TransactionLine `json:"lines,omitempty"`
+	// Optional caller provided UUID for the transaction. A random ID is generated when empty.
+	Id     string            `json:"id,omitempty"`
+	Lines  []TransactionLine `json:"lines,omitempty"`
+	Status TransactionStatus `json:"status,omitempty"`
+	// Optional memo of what the transaction is for
+	Description string `json:"description,omitempty"`
+	// Caller provided key/value pairs, up to 50 keys of 40 characters with values of 500 characters
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Caller provided labels, up to 20 of 64 characters, to find the transaction by
+	Tags []string `json:"tags,omitempty"`
 }
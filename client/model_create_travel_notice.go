@@ -0,0 +1,20 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// CreateTravelNotice struct for CreateTravelNotice
+type CreateTravelNotice struct {
+	// First day of travel (inclusive) formatted as YYYY-MM-DD
+	StartDate string `json:"startDate,omitempty"`
+	// Last day of travel (inclusive) formatted as YYYY-MM-DD
+	EndDate string `json:"endDate,omitempty"`
+	// ISO 3166-1 alpha-2 codes of the countries travelled to
+	Countries []string `json:"countries,omitempty"`
+}
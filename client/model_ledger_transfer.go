@@ -0,0 +1,31 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+import (
+	"time"
+)
+
+// LedgerTransfer struct for LedgerTransfer
+type LedgerTransfer struct {
+	// Unique ID of the transfer, which is also the ID of the held transaction on both ledgers
+	Id                   string `json:"id,omitempty"`
+	Peer                 string `json:"peer,omitempty"`
+	SourceAccountId      string `json:"sourceAccountId,omitempty"`
+	DestinationAccountId string `json:"destinationAccountId,omitempty"`
+	// Amount transferred (in USD cents)
+	Amount int32 `json:"amount,omitempty"`
+	// Prepared and committing transfers are still in progress. Committed and aborted transfers are finished.
+	Status string `json:"status,omitempty"`
+	// When the peer's hold expires, after which an uncommitted transfer is aborted
+	ExpiresAt    time.Time `json:"expiresAt,omitempty"`
+	CreatedAt    time.Time `json:"createdAt,omitempty"`
+	LastModified time.Time `json:"lastModified,omitempty"`
+}
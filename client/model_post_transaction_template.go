@@ -0,0 +1,19 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// PostTransactionTemplate struct for PostTransactionTemplate
+type PostTransactionTemplate struct {
+	// Optional caller provided UUID for the transaction. A random ID is generated when empty.
+	Id     string            `json:"id,omitempty"`
+	Status TransactionStatus `json:"status,omitempty"`
+	// Value for each of the template's placeholders
+	Parameters map[string]string `json:"parameters"`
+}
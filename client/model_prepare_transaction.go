@@ -0,0 +1,19 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// PrepareTransaction struct for PrepareTransaction
+type PrepareTransaction struct {
+	// Optional caller provided UUID for the transaction. A random ID is generated when empty.
+	Id    string            `json:"id,omitempty"`
+	Lines []TransactionLine `json:"lines,omitempty"`
+	// How long funds are held before the transaction is aborted, e.g. 30s or 10m. Defaults to 5m and can be at most 168h.
+	Timeout string `json:"timeout,omitempty"`
+}
@@ -0,0 +1,22 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// ProjectedMonth struct for ProjectedMonth
+type ProjectedMonth struct {
+	// Projected month formatted as YYYY-MM
+	Month          string `json:"month,omitempty"`
+	OpeningBalance int32  `json:"openingBalance,omitempty"`
+	// Annual interest rate applied to the opening balance
+	InterestRate   float32        `json:"interestRate,omitempty"`
+	Interest       int32          `json:"interest,omitempty"`
+	Fees           []ProjectedFee `json:"fees,omitempty"`
+	ClosingBalance int32          `json:"closingBalance,omitempty"`
+}
@@ -0,0 +1,23 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// Projection struct for Projection
+type Projection struct {
+	// Account ID
+	AccountId string `json:"accountId,omitempty"`
+	// Current balance of the account in USD cents
+	Balance int32 `json:"balance,omitempty"`
+	// Interest earned over every projected month in USD cents
+	TotalInterest int32 `json:"totalInterest,omitempty"`
+	// Fees charged over every projected month in USD cents
+	TotalFees int32            `json:"totalFees,omitempty"`
+	Months    []ProjectedMonth `json:"months,omitempty"`
+}
@@ -0,0 +1,51 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+import (
+	"time"
+)
+
+// RecurringRule struct for RecurringRule
+type RecurringRule struct {
+	// Recurring rule ID
+	ID string `json:"id,omitempty"`
+	// Account ID
+	AccountID string `json:"accountId,omitempty"`
+	// Account on the other side of each transaction
+	CounterpartyAccountID string `json:"counterpartyAccountId,omitempty"`
+	// Amount in cents of each transaction
+	Amount int32 `json:"amount,omitempty"`
+	// Whether the account is debited (such as for a fee) or credited (such as for interest) by each transaction
+	Direction string `json:"direction,omitempty"`
+	// Purpose of the counterparty side when the account is debited, or of the account's side when it is credited. achdebit isn't allowed.
+	Purpose string `json:"purpose,omitempty"`
+	// Description added to each transaction
+	Description string `json:"description,omitempty"`
+	// iCalendar RRULE with FREQ (DAILY, WEEKLY, MONTHLY or YEARLY) and optionally INTERVAL, BYDAY (weekly), BYMONTHDAY (monthly), and one of COUNT or UNTIL
+	Schedule string `json:"schedule,omitempty"`
+	// Time the schedule starts from, which also sets the time of day of each occurrence
+	Start time.Time `json:"start,omitempty"`
+	// Rules are completed once their schedule has no further occurrences
+	Status string `json:"status,omitempty"`
+	// Number of occurrences run so far, including skipped ones
+	Occurrences int32 `json:"occurrences,omitempty"`
+	// Time of the next occurrence, omitted once the rule is completed
+	NextRunAt time.Time `json:"nextRunAt,omitempty"`
+	LastRunAt time.Time `json:"lastRunAt,omitempty"`
+	// Transaction posted by the latest successful occurrence
+	LastTransactionID string `json:"lastTransactionId,omitempty"`
+	// Why the latest occurrence was skipped, omitted when it posted
+	LastError string `json:"lastError,omitempty"`
+	// User ID who created the rule
+	CreatedBy    string    `json:"createdBy,omitempty"`
+	CreatedAt    time.Time `json:"createdAt,omitempty"`
+	LastModified time.Time `json:"lastModified,omitempty"`
+}
@@ -0,0 +1,18 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// SaveTransactionTemplate struct for SaveTransactionTemplate
+type SaveTransactionTemplate struct {
+	// Unique name of the template, up to 64 letters, digits, dots, dashes or underscores
+	Name        string                    `json:"name"`
+	Description string                    `json:"description,omitempty"`
+	Lines       []TransactionTemplateLine `json:"lines"`
+}
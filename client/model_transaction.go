@@ -16,7 +16,22 @@ import (
 // Transaction struct for Transaction
 type Transaction struct {
 	// Unique ID of a transaction
-	ID        string            `json:"ID,omitempty"`
-	Timestamp time.Time         `json:"timestamp,omitempty"`
-	Lines     []TransactionLine `json:"lines,omitempty"`
+	ID string `json:"ID,omitempty"`
+	// The organization which owns the transaction
+	OrganizationID string            `json:"organizationId,omitempty"`
+	Timestamp      time.Time         `json:"timestamp,omitempty"`
+	Status         TransactionStatus `json:"status,omitempty"`
+	Lines          []TransactionLine `json:"lines,omitempty"`
+	// When a held transaction is aborted unless it has been committed
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+	// Admin force post which created the transaction without checking balances
+	ForcePostID string `json:"forcePostId,omitempty"`
+	// Only included when requested with expand=attachments
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Optional memo of what the transaction is for
+	Description string `json:"description,omitempty"`
+	// Caller provided key/value pairs, up to 50 keys of 40 characters with values of 500 characters
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Caller provided labels, up to 20 of 64 characters, to find the transaction by
+	Tags []string `json:"tags,omitempty"`
 }
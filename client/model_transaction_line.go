@@ -16,4 +16,16 @@ type TransactionLine struct {
 	Purpose   string `json:"purpose,omitempty"`
 	// Change in account balance (in USD cents)
 	Amount float32 `json:"amount,omitempty"`
+	// Optional department segment the line is allocated to, one of the values configured in LINE_SEGMENT_DEPARTMENTS
+	Department string `json:"department,omitempty"`
+	// Optional product segment the line is allocated to, one of the values configured in LINE_SEGMENT_PRODUCTS
+	Product string `json:"product,omitempty"`
+	// Optional region segment the line is allocated to, one of the values configured in LINE_SEGMENT_REGIONS
+	Region string `json:"region,omitempty"`
+	// Merchant category code (ISO 18245) of the merchant, required on Card lines and not allowed on other lines
+	Mcc string `json:"mcc,omitempty"`
+	// ISO 3166-1 alpha-2 country of the merchant, optional on Card lines and not allowed on other lines
+	MerchantCountry string `json:"merchantCountry,omitempty"`
+	// Optional memo of the line, such as the counterparty or what it paid for
+	Description string `json:"description,omitempty"`
 }
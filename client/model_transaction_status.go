@@ -0,0 +1,23 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// TransactionStatus Lifecycle status of a transaction. Only posted transactions affect account balances, except held transactions reserve their debits until they are committed or aborted.
+type TransactionStatus string
+
+// List of TransactionStatus
+const (
+	PENDING  TransactionStatus = "pending"
+	POSTED   TransactionStatus = "posted"
+	VOIDED   TransactionStatus = "voided"
+	REVERSED TransactionStatus = "reversed"
+	FAILED   TransactionStatus = "failed"
+	HELD     TransactionStatus = "held"
+)
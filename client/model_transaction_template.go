@@ -0,0 +1,25 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+import (
+	"time"
+)
+
+// TransactionTemplate struct for TransactionTemplate
+type TransactionTemplate struct {
+	Name        string                    `json:"name,omitempty"`
+	Description string                    `json:"description,omitempty"`
+	Lines       []TransactionTemplateLine `json:"lines,omitempty"`
+	// Placeholders which must be filled in when posting from the template
+	Parameters   []string  `json:"parameters,omitempty"`
+	CreatedAt    time.Time `json:"createdAt,omitempty"`
+	LastModified time.Time `json:"lastModified,omitempty"`
+}
@@ -0,0 +1,20 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package openapi
+
+// TransactionTemplateLine struct for TransactionTemplateLine
+type TransactionTemplateLine struct {
+	// Account ID or a placeholder such as {{payable}}
+	AccountId string `json:"accountId"`
+	// Transaction line purpose or a placeholder
+	Purpose string `json:"purpose"`
+	// Amount (in USD cents) or a placeholder. Literal amounts can be numbers.
+	Amount string `json:"amount"`
+}
@@ -0,0 +1,23 @@
+/*
+ * Accounts API
+ *
+ * Moov Accounts is an HTTP service which represents both a general ledger and chart of accounts for customers. The service is designed to abstract over various core systems and provide a uniform API for developers.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+
+package openapi
+
+// UpdateAccount struct for UpdateAccount
+type UpdateAccount struct {
+	// Caller defined label for this account.
+	Name string `json:"name,omitempty"`
+	// Status of the account. Frozen accounts can't be debited and suspended accounts can't be posted against until they're reopened. Closing an account sets its closedAt timestamp.
+	Status string `json:"status,omitempty"`
+	// Product type of the account
+	Type string `json:"type,omitempty"`
+	// Amount in USD cents the balance can be overdrawn by
+	OverdraftLimit int32 `json:"overdraftLimit,omitempty"`
+}
@@ -0,0 +1,113 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpResponses = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "http_responses",
+		Help: "Counter of HTTP responses by route group and status code",
+	}, []string{"group", "code"})
+)
+
+// accessLogSampleRate returns the fraction (0.0 to 1.0) of requests which are written to the access log.
+// Slow requests and server errors are always logged.
+func accessLogSampleRate() float64 {
+	if v := os.Getenv("ACCESS_LOG_SAMPLE_RATE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+			return f
+		}
+	}
+	return 1.0
+}
+
+// accessLogSlowThreshold returns how long a request can take before it's always logged.
+func accessLogSlowThreshold() time.Duration {
+	if v := os.Getenv("ACCESS_LOG_SLOW_THRESHOLD"); v != "" {
+		if dur, err := time.ParseDuration(v); err == nil {
+			return dur
+		}
+	}
+	return time.Second
+}
+
+// accessLog wraps an http.Handler and writes a structured log line for sampled requests.
+type accessLog struct {
+	logger log.Logger
+	next   http.Handler
+
+	sampleRate    float64
+	slowThreshold time.Duration
+
+	// sample returns a value in [0.0, 1.0) which is compared against sampleRate
+	sample func() float64
+}
+
+func newAccessLog(logger log.Logger, next http.Handler) *accessLog {
+	return &accessLog{
+		logger:        logger,
+		next:          next,
+		sampleRate:    accessLogSampleRate(),
+		slowThreshold: accessLogSlowThreshold(),
+		sample:        rand.Float64,
+	}
+}
+
+func (l *accessLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	l.next.ServeHTTP(rec, r)
+	latency := time.Since(start)
+	httpResponses.With("group", routeGroup(r), "code", strconv.Itoa(rec.code)).Add(1)
+
+	slow := l.slowThreshold > 0 && latency >= l.slowThreshold
+	if !slow && rec.code < 500 && l.sample() >= l.sampleRate {
+		return
+	}
+	l.logger.Log(
+		"access", r.Method,
+		"path", r.URL.Path,
+		"status", rec.code,
+		"latency", latency.String(),
+		"slow", slow,
+		"caller", remoteAddr(r),
+		"userID", moovhttp.GetUserID(r),
+		"requestID", moovhttp.GetRequestID(r),
+	)
+}
+
+// statusRecorder captures the HTTP status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	w.code = code
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// remoteAddr returns the originating client address, preferring the first X-Forwarded-For hop
+// as we're usually deployed behind a load balancer.
+func remoteAddr(r *http.Request) string {
+	if v := r.Header.Get("X-Forwarded-For"); v != "" {
+		return strings.TrimSpace(strings.Split(v, ",")[0])
+	}
+	return r.RemoteAddr
+}
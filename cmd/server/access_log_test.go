@@ -0,0 +1,90 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestAccessLog__config(t *testing.T) {
+	if v := accessLogSampleRate(); v != 1.0 {
+		t.Errorf("got %v", v)
+	}
+	if v := accessLogSlowThreshold(); v != time.Second {
+		t.Errorf("got %v", v)
+	}
+
+	os.Setenv("ACCESS_LOG_SAMPLE_RATE", "0.25")
+	os.Setenv("ACCESS_LOG_SLOW_THRESHOLD", "250ms")
+	defer os.Unsetenv("ACCESS_LOG_SAMPLE_RATE")
+	defer os.Unsetenv("ACCESS_LOG_SLOW_THRESHOLD")
+
+	if v := accessLogSampleRate(); v != 0.25 {
+		t.Errorf("got %v", v)
+	}
+	if v := accessLogSlowThreshold(); v != 250*time.Millisecond {
+		t.Errorf("got %v", v)
+	}
+
+	os.Setenv("ACCESS_LOG_SAMPLE_RATE", "2")
+	if v := accessLogSampleRate(); v != 1.0 {
+		t.Errorf("got %v", v)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	status := http.StatusOK
+	handler := newAccessLog(log.NewLogfmtLogger(&buf), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	handler.sampleRate = 0.5
+
+	serve := func() {
+		buf.Reset()
+		req := httptest.NewRequest("GET", "/accounts/search", nil)
+		req.Header.Set("X-Forwarded-For", "10.1.2.3, 10.0.0.1")
+		req.Header.Set("X-Request-ID", "request")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// sampled in
+	handler.sample = func() float64 { return 0.1 }
+	serve()
+	if line := buf.String(); !strings.Contains(line, "access=GET path=/accounts/search status=200") ||
+		!strings.Contains(line, "caller=10.1.2.3") || !strings.Contains(line, "requestID=request") {
+		t.Errorf("unexpected log line: %s", line)
+	}
+
+	// sampled out
+	handler.sample = func() float64 { return 0.9 }
+	serve()
+	if buf.Len() != 0 {
+		t.Errorf("unexpected log line: %s", buf.String())
+	}
+
+	// server errors are always logged
+	status = http.StatusInternalServerError
+	serve()
+	if !strings.Contains(buf.String(), "status=500") {
+		t.Errorf("unexpected log line: %s", buf.String())
+	}
+
+	// slow requests are always logged
+	status = http.StatusOK
+	handler.slowThreshold = time.Nanosecond
+	serve()
+	if !strings.Contains(buf.String(), "slow=true") {
+		t.Errorf("unexpected log line: %s", buf.String())
+	}
+}
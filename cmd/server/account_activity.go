@@ -0,0 +1,318 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Activity features are cheap signals derived from postings for our fraud system, so it doesn't need an export of
+// the ledger. Counts over the last hour and day decay exponentially from an account's last posting rather than
+// being exact windows, which lets each posting update them in place.
+const (
+	// activityQueueSize bounds how many postings can wait to be recorded. Postings beyond that aren't recorded,
+	// as the signals are best effort and must never hold up moving money.
+	activityQueueSize = 1000
+
+	// An hour of the day (UTC) is unusual for an account once it has activityMinHistory postings and fewer than
+	// activityUnusualShare of them were in that hour.
+	activityMinHistory   = 20
+	activityUnusualShare = 0.05
+
+	// A feature at or above its saturation point contributes its full weight to the score.
+	activityVelocitySaturation       = 20 // postings in the last hour
+	activityUnusualHourSaturation    = 5  // unusual hour postings in the last day
+	activityCounterpartySaturation   = 10 // new counterparties in the last day
+	activityVelocityWeight           = 40
+	activityUnusualHourWeight        = 30
+	activityNewCounterpartiesWeight  = 30
+	defaultAccountActivityEventScore = 50
+)
+
+// activityFeatures holds an account's activity features as of LastPostedAt.
+type activityFeatures struct {
+	AccountID string `json:"accountId"`
+
+	// Score from 0 to 100 weighs velocity, unusual hours and new counterparties
+	Score int `json:"score"`
+
+	Postings         int64   `json:"postings"`
+	PostingsLastHour float64 `json:"postingsLastHour"`
+	PostingsLastDay  float64 `json:"postingsLastDay"`
+	DebitsLastDay    float64 `json:"debitsLastDay"`
+
+	// UnusualHourPostingsLastDay counts postings in hours of the day the account rarely posts in
+	UnusualHourPostingsLastDay float64 `json:"unusualHourPostingsLastDay"`
+
+	// Counterparties is how many accounts this account has ever transacted with
+	Counterparties           int64   `json:"counterparties"`
+	NewCounterpartiesLastDay float64 `json:"newCounterpartiesLastDay"`
+
+	// Hours counts postings by their hour of the day in UTC
+	Hours [24]int64 `json:"hours"`
+
+	FirstPostedAt time.Time `json:"firstPostedAt"`
+	LastPostedAt  time.Time `json:"lastPostedAt"`
+}
+
+func decay(v float64, elapsed, window time.Duration) float64 {
+	if elapsed <= 0 {
+		return v
+	}
+	return v * math.Exp(-float64(elapsed)/float64(window))
+}
+
+// asOf returns the activity with its counts decayed from LastPostedAt until now.
+func (a activityFeatures) asOf(now time.Time) *activityFeatures {
+	elapsed := now.Sub(a.LastPostedAt)
+	a.PostingsLastHour = decay(a.PostingsLastHour, elapsed, time.Hour)
+	a.PostingsLastDay = decay(a.PostingsLastDay, elapsed, 24*time.Hour)
+	a.DebitsLastDay = decay(a.DebitsLastDay, elapsed, 24*time.Hour)
+	a.UnusualHourPostingsLastDay = decay(a.UnusualHourPostingsLastDay, elapsed, 24*time.Hour)
+	a.NewCounterpartiesLastDay = decay(a.NewCounterpartiesLastDay, elapsed, 24*time.Hour)
+	a.Score = a.score()
+	return &a
+}
+
+// unusualHour returns if the account rarely posts during hour.
+func (a *activityFeatures) unusualHour(hour int) bool {
+	if a.Postings < activityMinHistory {
+		return false
+	}
+	return float64(a.Hours[hour])/float64(a.Postings) < activityUnusualShare
+}
+
+func (a *activityFeatures) score() int {
+	saturate := func(v, max float64) float64 {
+		return math.Min(v/max, 1)
+	}
+	score := activityVelocityWeight*saturate(a.PostingsLastHour, activityVelocitySaturation) +
+		activityUnusualHourWeight*saturate(a.UnusualHourPostingsLastDay, activityUnusualHourSaturation) +
+		activityNewCounterpartiesWeight*saturate(a.NewCounterpartiesLastDay, activityCounterpartySaturation)
+	return int(math.Round(score))
+}
+
+// accountActivityEventScore returns the score at which account.activity events are published, from
+// ACCOUNT_ACTIVITY_EVENT_SCORE.
+func accountActivityEventScore() (int, error) {
+	v := os.Getenv("ACCOUNT_ACTIVITY_EVENT_SCORE")
+	if v == "" {
+		return defaultAccountActivityEventScore, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 || n > 100 {
+		return 0, fmt.Errorf("invalid ACCOUNT_ACTIVITY_EVENT_SCORE %q", v)
+	}
+	return n, nil
+}
+
+// activityRecorder updates the activity of each account in posted transactions, off the posting path.
+type activityRecorder struct {
+	logger     log.Logger
+	repo       accountActivityRepository
+	events     eventPublisher
+	eventScore int
+
+	queue chan transaction
+
+	now func() time.Time
+}
+
+func newActivityRecorder(logger log.Logger, repo accountActivityRepository, events eventPublisher, eventScore int) *activityRecorder {
+	return &activityRecorder{
+		logger:     logger,
+		repo:       repo,
+		events:     events,
+		eventScore: eventScore,
+		queue:      make(chan transaction, activityQueueSize),
+		now:        time.Now,
+	}
+}
+
+// observe queues a posted transaction to be recorded without blocking.
+func (a *activityRecorder) observe(tx transaction) {
+	select {
+	case a.queue <- tx:
+	default:
+		a.logger.Log("activity", fmt.Sprintf("dropped transaction=%s, activity queue is full", tx.ID))
+	}
+}
+
+// run records queued transactions until ctx is cancelled.
+func (a *activityRecorder) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case tx := <-a.queue:
+			if err := a.record(tx); err != nil {
+				a.logger.Log("activity", fmt.Sprintf("problem recording transaction=%s: %v", tx.ID, err))
+			}
+		}
+	}
+}
+
+// record updates the activity of every account in tx and publishes an event for those scoring at least eventScore.
+func (a *activityRecorder) record(tx transaction) error {
+	now := a.now()
+	for _, accountID := range uniqueAccountIDs(tx.Lines) {
+		activity, err := a.repo.getActivity(accountID)
+		if err != nil {
+			return err
+		}
+		if activity == nil {
+			activity = &activityFeatures{AccountID: accountID, FirstPostedAt: now, LastPostedAt: now}
+		}
+		activity = activity.asOf(now)
+
+		hour := now.UTC().Hour()
+		if activity.unusualHour(hour) {
+			activity.UnusualHourPostingsLastDay++
+		}
+		activity.Postings++
+		activity.Hours[hour]++
+		activity.PostingsLastHour++
+		activity.PostingsLastDay++
+		for i := range tx.Lines {
+			if amt := lineAmount(tx.Lines[i]); tx.Lines[i].AccountID == accountID && amt < 0 {
+				activity.DebitsLastDay += float64(-1 * amt)
+			}
+		}
+
+		var counterparties []string
+		for _, id := range uniqueAccountIDs(tx.Lines) {
+			if id != accountID {
+				counterparties = append(counterparties, id)
+			}
+		}
+		added, err := a.repo.addCounterparties(accountID, counterparties, now)
+		if err != nil {
+			return err
+		}
+		activity.Counterparties += int64(added)
+		activity.NewCounterpartiesLastDay += float64(added)
+
+		activity.LastPostedAt = now
+		activity.Score = activity.score()
+		if err := a.repo.saveActivity(activity); err != nil {
+			return err
+		}
+		if activity.Score >= a.eventScore {
+			if err := a.events.publish(newEvent("account.activity", activity)); err != nil {
+				a.logger.Log("activity", fmt.Sprintf("problem publishing account.activity event for account=%s: %v", accountID, err))
+			}
+		}
+	}
+	return nil
+}
+
+func uniqueAccountIDs(lines []transactionLine) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for i := range lines {
+		if id := lines[i].AccountID; !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// activityTransactionRepository records the activity of postings once they're committed.
+type activityTransactionRepository struct {
+	transactionRepository
+
+	activity *activityRecorder
+}
+
+func (r *activityTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	if err := r.transactionRepository.createTransaction(ctx, tx, opts); err != nil {
+		return err
+	}
+	r.activity.observe(tx)
+	return nil
+}
+
+// getAccountActivity is an admin route which returns an account's activity features decayed until now.
+func getAccountActivity(logger log.Logger, recorder *activityRecorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		accountID := getAccountID(w, r)
+		if accountID == "" {
+			return
+		}
+		activity, err := recorder.repo.getActivity(accountID)
+		if err != nil {
+			logger.Log("activity", fmt.Sprintf("problem reading account=%s activity: %v", accountID, err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if activity == nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(activity.asOf(recorder.now()))
+	}
+}
+
+// getActiveAccounts is an admin route which lists recently active accounts scoring at least ?minScore= (default
+// ACCOUNT_ACTIVITY_EVENT_SCORE), most recently active first.
+func getActiveAccounts(logger log.Logger, recorder *activityRecorder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		minScore, limit := recorder.eventScore, 100
+		if v := r.URL.Query().Get("minScore"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				moovhttp.Problem(w, fmt.Errorf("invalid minScore %q", v))
+				return
+			}
+			minScore = n
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > 1000 {
+				moovhttp.Problem(w, fmt.Errorf("invalid limit %q", v))
+				return
+			}
+			limit = n
+		}
+		activities, err := recorder.repo.getActiveAccounts(minScore, limit)
+		if err != nil {
+			logger.Log("activity", fmt.Sprintf("problem reading active accounts: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		// Scores were stored after each account's last posting, so only return those still above minScore
+		out := make([]*activityFeatures, 0, len(activities))
+		for i := range activities {
+			if a := activities[i].asOf(recorder.now()); a.Score >= minScore {
+				out = append(out, a)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+type accountActivityRepository interface {
+	Ping() error
+	Close() error
+
+	// getActivity returns nil if the account hasn't posted a transaction since activity was recorded.
+	getActivity(accountID string) (*activityFeatures, error)
+
+	saveActivity(activity *activityFeatures) error
+
+	// getActiveAccounts returns accounts whose score was at least minScore after their last posting, most recently
+	// active first.
+	getActiveAccounts(minScore int, limit int) ([]*activityFeatures, error)
+
+	// addCounterparties records the accounts accountID has transacted with and returns how many are new.
+	addCounterparties(accountID string, counterpartyIDs []string, at time.Time) (int, error)
+}
+
+type sqlAccountActivityRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlAccountActivityRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlAccountActivityRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlAccountActivityRepository) getActivity(accountID string) (*activityFeatures, error) {
+	activities, err := r.queryActivities(`where account_id = ?`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getActivity: %v", err)
+	}
+	if len(activities) == 0 {
+		return nil, nil
+	}
+	return activities[0], nil
+}
+
+func (r *sqlAccountActivityRepository) getActiveAccounts(minScore int, limit int) ([]*activityFeatures, error) {
+	activities, err := r.queryActivities(`where score >= ? order by last_posted_at desc limit ?`, minScore, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getActiveAccounts: %v", err)
+	}
+	return activities, nil
+}
+
+func (r *sqlAccountActivityRepository) queryActivities(suffix string, args ...interface{}) ([]*activityFeatures, error) {
+	query := fmt.Sprintf(`select account_id, score, postings, postings_hour, postings_day, debits_day, unusual_hour_postings_day, counterparties, new_counterparties_day, hours, first_posted_at, last_posted_at from account_activity %s;`, suffix)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*activityFeatures
+	for rows.Next() {
+		var a activityFeatures
+		var hours string
+		if err := rows.Scan(&a.AccountID, &a.Score, &a.Postings, &a.PostingsLastHour, &a.PostingsLastDay, &a.DebitsLastDay, &a.UnusualHourPostingsLastDay, &a.Counterparties, &a.NewCounterpartiesLastDay, &hours, &a.FirstPostedAt, &a.LastPostedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		for i, v := range strings.Split(hours, ",") {
+			if i < len(a.Hours) {
+				a.Hours[i], _ = strconv.ParseInt(v, 10, 64)
+			}
+		}
+		out = append(out, &a)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlAccountActivityRepository) saveActivity(a *activityFeatures) error {
+	hours := make([]string, len(a.Hours))
+	for i := range a.Hours {
+		hours[i] = strconv.FormatInt(a.Hours[i], 10)
+	}
+	query := `replace into account_activity (account_id, score, postings, postings_hour, postings_day, debits_day, unusual_hour_postings_day, counterparties, new_counterparties_day, hours, first_posted_at, last_posted_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err := r.db.Exec(query, a.AccountID, a.Score, a.Postings, a.PostingsLastHour, a.PostingsLastDay, a.DebitsLastDay, a.UnusualHourPostingsLastDay, a.Counterparties, a.NewCounterpartiesLastDay, strings.Join(hours, ","), a.FirstPostedAt, a.LastPostedAt)
+	if err != nil {
+		return fmt.Errorf("saveActivity: account=%s: %v", a.AccountID, err)
+	}
+	return nil
+}
+
+func (r *sqlAccountActivityRepository) addCounterparties(accountID string, counterpartyIDs []string, at time.Time) (int, error) {
+	var added int
+	for i := range counterpartyIDs {
+		query := `insert into account_counterparties (account_id, counterparty_id, first_seen_at) values (?, ?, ?);`
+		if _, err := r.db.Exec(query, accountID, counterpartyIDs[i], at); err != nil {
+			if database.UniqueViolation(err) {
+				continue
+			}
+			return added, fmt.Errorf("addCounterparties: account=%s: %v", accountID, err)
+		}
+		added++
+	}
+	return added, nil
+}
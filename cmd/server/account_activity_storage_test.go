@@ -0,0 +1,72 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSqlAccountActivityRepository(t *testing.T) {
+	check := func(t *testing.T, repo *sqlAccountActivityRepository) {
+		t.Helper()
+		defer repo.Close()
+
+		if activity, err := repo.getActivity("missing"); err != nil || activity != nil {
+			t.Errorf("activity=%#v error=%v", activity, err)
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		quiet := &activityFeatures{AccountID: "quiet", Score: 10, Postings: 3, PostingsLastHour: 1.5, FirstPostedAt: now.Add(-time.Hour), LastPostedAt: now.Add(-time.Minute)}
+		quiet.Hours[3] = 2
+		busy := &activityFeatures{AccountID: "busy", Score: 80, Postings: 40, PostingsLastDay: 12.25, DebitsLastDay: 5000, FirstPostedAt: now.Add(-time.Hour), LastPostedAt: now}
+		for _, a := range []*activityFeatures{quiet, busy} {
+			if err := repo.saveActivity(a); err != nil {
+				t.Fatal(err)
+			}
+		}
+		quiet.Score = 20
+		if err := repo.saveActivity(quiet); err != nil {
+			t.Fatal(err)
+		}
+		activity, err := repo.getActivity("quiet")
+		if err != nil || activity == nil {
+			t.Fatalf("activity=%#v error=%v", activity, err)
+		}
+		if activity.Score != 20 || activity.PostingsLastHour != 1.5 || activity.Hours[3] != 2 || !activity.LastPostedAt.Equal(quiet.LastPostedAt) {
+			t.Errorf("unexpected activity: %#v", activity)
+		}
+
+		active, err := repo.getActiveAccounts(50, 10)
+		if err != nil || len(active) != 1 || active[0].AccountID != "busy" || active[0].DebitsLastDay != 5000 {
+			t.Errorf("active=%#v error=%v", active, err)
+		}
+		if active, err := repo.getActiveAccounts(0, 1); err != nil || len(active) != 1 || active[0].AccountID != "busy" {
+			t.Errorf("active=%#v error=%v", active, err)
+		}
+
+		if n, err := repo.addCounterparties("busy", []string{"a", "b"}, now); err != nil || n != 2 {
+			t.Errorf("added=%d error=%v", n, err)
+		}
+		if n, err := repo.addCounterparties("busy", []string{"b", "c"}, now); err != nil || n != 1 {
+			t.Errorf("added=%d error=%v", n, err)
+		}
+		if n, err := repo.addCounterparties("quiet", []string{"b"}, now); err != nil || n != 1 {
+			t.Errorf("added=%d error=%v", n, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlAccountActivityRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlAccountActivityRepository{mysqlDB.DB, log.NewNopLogger()})
+}
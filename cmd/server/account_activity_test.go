@@ -0,0 +1,229 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func createTestActivityRecorder(t *testing.T) (*activityRecorder, *mockEventPublisher, func()) {
+	t.Helper()
+	db := database.CreateTestSqliteDB(t)
+	events := &mockEventPublisher{}
+	recorder := newActivityRecorder(log.NewNopLogger(), &sqlAccountActivityRepository{db.DB, log.NewNopLogger()}, events, 50)
+	return recorder, events, func() { db.Close() }
+}
+
+func transfer(from, to string, amount int) transaction {
+	return transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now(),
+		Lines: []transactionLine{
+			{AccountID: from, Purpose: ACHDebit, Amount: amount},
+			{AccountID: to, Purpose: ACHCredit, Amount: amount},
+		},
+	}
+}
+
+func TestAccountActivity__accountActivityEventScore(t *testing.T) {
+	if n, err := accountActivityEventScore(); err != nil || n != defaultAccountActivityEventScore {
+		t.Errorf("score=%d error=%v", n, err)
+	}
+	os.Setenv("ACCOUNT_ACTIVITY_EVENT_SCORE", "75")
+	defer os.Unsetenv("ACCOUNT_ACTIVITY_EVENT_SCORE")
+	if n, err := accountActivityEventScore(); err != nil || n != 75 {
+		t.Errorf("score=%d error=%v", n, err)
+	}
+	os.Setenv("ACCOUNT_ACTIVITY_EVENT_SCORE", "101")
+	if _, err := accountActivityEventScore(); err == nil || !strings.Contains(err.Error(), "ACCOUNT_ACTIVITY_EVENT_SCORE") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAccountActivity__decay(t *testing.T) {
+	now := time.Now()
+	a := activityFeatures{PostingsLastHour: 10, PostingsLastDay: 10, NewCounterpartiesLastDay: 10, LastPostedAt: now}
+	later := a.asOf(now.Add(time.Hour))
+	if math.Abs(later.PostingsLastHour-10/math.E) > 0.001 || later.PostingsLastDay <= 9 || later.PostingsLastDay >= 10 {
+		t.Errorf("unexpected decay: %#v", later)
+	}
+	if a.PostingsLastHour != 10 {
+		t.Error("asOf modified the activity")
+	}
+	if later.Score >= a.score() {
+		t.Errorf("score=%d didn't decay from %d", later.Score, a.score())
+	}
+}
+
+func TestAccountActivity__score(t *testing.T) {
+	if score := (&activityFeatures{}).score(); score != 0 {
+		t.Errorf("score=%d", score)
+	}
+	saturated := &activityFeatures{PostingsLastHour: 50, UnusualHourPostingsLastDay: 10, NewCounterpartiesLastDay: 20}
+	if score := saturated.score(); score != 100 {
+		t.Errorf("score=%d", score)
+	}
+	velocity := &activityFeatures{PostingsLastHour: activityVelocitySaturation / 2}
+	if score := velocity.score(); score != activityVelocityWeight/2 {
+		t.Errorf("score=%d", score)
+	}
+}
+
+func TestAccountActivity__record(t *testing.T) {
+	recorder, events, cleanup := createTestActivityRecorder(t)
+	defer cleanup()
+
+	// an account which has always posted at 14:00 UTC
+	now := time.Date(2020, time.June, 1, 14, 0, 0, 0, time.UTC)
+	for i := 0; i < activityMinHistory; i++ {
+		recorder.now = func() time.Time { return now.AddDate(0, 0, -1*activityMinHistory+i) }
+		if err := recorder.record(transfer("payroll", "employee", 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	activity, err := recorder.repo.getActivity("payroll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity.Postings != activityMinHistory || activity.Counterparties != 1 || activity.Hours[14] != activityMinHistory || activity.UnusualHourPostingsLastDay != 0 {
+		t.Errorf("unexpected activity: %#v", activity)
+	}
+	if len(events.events) != 0 {
+		t.Errorf("unexpected events: %#v", events.events)
+	}
+
+	// a burst at 03:00 to new accounts
+	burst := now.Add(13 * time.Hour)
+	recorder.now = func() time.Time { return burst }
+	for i := 0; i < 15; i++ {
+		if err := recorder.record(transfer("payroll", base.ID(), 250)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	activity, err = recorder.repo.getActivity("payroll")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity.Counterparties != 16 || activity.NewCounterpartiesLastDay < 15 || activity.UnusualHourPostingsLastDay < 1 || activity.DebitsLastDay < 15*250 {
+		t.Errorf("unexpected activity: %#v", activity)
+	}
+	if activity.Score < 50 {
+		t.Errorf("score=%d", activity.Score)
+	}
+	if n := len(events.events); n == 0 || events.events[n-1].Type != "account.activity" {
+		t.Fatalf("unexpected events: %#v", events.events)
+	}
+	if a, ok := events.events[len(events.events)-1].Data.(*activityFeatures); !ok || a.AccountID != "payroll" {
+		t.Errorf("unexpected event: %#v", events.events[len(events.events)-1])
+	}
+
+	// the employee's first credit made it a new counterparty once
+	employee, err := recorder.repo.getActivity("employee")
+	if err != nil || employee.Postings != activityMinHistory || employee.Counterparties != 1 || employee.DebitsLastDay != 0 {
+		t.Errorf("activity=%#v error=%v", employee, err)
+	}
+}
+
+func TestAccountActivity__observe(t *testing.T) {
+	recorder, _, cleanup := createTestActivityRecorder(t)
+	defer cleanup()
+
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"source": 2000, "dest": 0})
+	defer accountRepo.Close()
+	repo := &activityTransactionRepository{transactionRepository: transactionRepo, activity: recorder}
+	if err := repo.createTransaction(context.Background(), transfer("source", "dest", 100), createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	// failed postings aren't recorded
+	if err := repo.createTransaction(context.Background(), transfer("source", "dest", 5000), createTransactionOpts{}); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := len(recorder.queue); n != 1 {
+		t.Fatalf("queued %d transactions", n)
+	}
+
+	// stop the recorder before its database is closed, as it may still be recording the other account
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		recorder.run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for i := 0; i < 100; i++ {
+		if activity, _ := recorder.repo.getActivity("dest"); activity != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("transaction wasn't recorded")
+}
+
+func TestAccountActivity__routes(t *testing.T) {
+	recorder, _, cleanup := createTestActivityRecorder(t)
+	defer cleanup()
+
+	now := time.Now()
+	recorder.repo.saveActivity(&activityFeatures{AccountID: "hot", Score: 90, PostingsLastHour: 20, NewCounterpartiesLastDay: 10, LastPostedAt: now})
+	recorder.repo.saveActivity(&activityFeatures{AccountID: "cooled", Score: 60, PostingsLastHour: 20, LastPostedAt: now.Add(-6 * time.Hour)})
+	recorder.repo.saveActivity(&activityFeatures{AccountID: "quiet", Score: 5, PostingsLastHour: 2, LastPostedAt: now})
+
+	router := mux.NewRouter()
+	router.Handle("/accounts/activity", getActiveAccounts(log.NewNopLogger(), recorder))
+	router.Handle("/accounts/{accountId}/activity", getAccountActivity(log.NewNopLogger(), recorder))
+	call := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := call("/accounts/hot/activity")
+	var activity activityFeatures
+	if err := json.NewDecoder(w.Body).Decode(&activity); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("got %d error=%v", w.Code, err)
+	}
+	if activity.AccountID != "hot" || activity.Score < 60 {
+		t.Errorf("unexpected activity: %#v", activity)
+	}
+	if w := call("/accounts/missing/activity"); w.Code != http.StatusNotFound {
+		t.Errorf("got %d", w.Code)
+	}
+
+	// accounts whose score has decayed below minScore are left out
+	w = call("/accounts/activity")
+	var active []activityFeatures
+	if err := json.NewDecoder(w.Body).Decode(&active); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("got %d error=%v", w.Code, err)
+	}
+	if len(active) != 1 || active[0].AccountID != "hot" {
+		t.Errorf("unexpected accounts: %#v", active)
+	}
+	w = call("/accounts/activity?minScore=0&limit=10")
+	if err := json.NewDecoder(w.Body).Decode(&active); err != nil || len(active) != 3 {
+		t.Errorf("accounts=%#v error=%v", active, err)
+	}
+	for _, path := range []string{"/accounts/activity?minScore=high", "/accounts/activity?limit=0"} {
+		if w := call(path); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+var (
+	errAccountClosed = errors.New("account is closed")
+)
+
+func addAccountClosureRoutes(logger log.Logger, r *mux.Router, svc *accountClosureService) {
+	r.Methods("POST").Path("/accounts/{accountId}/close").HandlerFunc(closeAccount(logger, svc))
+}
+
+func accountClosed(acct *accounts.Account) bool {
+	return strings.EqualFold(acct.Status, string(accountStatusClosed))
+}
+
+// checkClosable returns an error unless the account can be closed as it is, which requires a zero balance and no
+// pending or held transactions.
+func checkClosable(acct *accounts.Account) error {
+	if accountClosed(acct) {
+		return fmt.Errorf("account=%s: %v", acct.ID, errAccountClosed)
+	}
+	if acct.BalancePending != 0 || acct.BalanceAvailable != acct.Balance {
+		return fmt.Errorf("account=%s has pending or held transactions", acct.ID)
+	}
+	if acct.Balance != 0 {
+		return fmt.Errorf("account=%s has a balance of %d, it must be zero or swept to another account", acct.ID, acct.Balance)
+	}
+	return nil
+}
+
+type closeAccountRequest struct {
+	// SweepAccountID is an optional account which receives the remaining balance, or covers it when negative,
+	// before the account is closed.
+	SweepAccountID string `json:"sweepAccountId"`
+}
+
+type accountClosureService struct {
+	logger       log.Logger
+	accounts     accountRepository
+	transactions *transactionService
+
+	// promotions, when set, claws back promotions whose conditions are met by closing the account
+	promotions *promotionService
+}
+
+// CloseAccount claws back promotions, sweeps any remaining balance to req.SweepAccountID and closes the account.
+// Postings against the account are rejected once it's closed. A posting which lands between the sweep and closing
+// leaves a balance which causes the closure to fail, so it can be retried.
+func (s *accountClosureService) CloseAccount(ctx context.Context, accountID string, req closeAccountRequest) (*accounts.Account, error) {
+	acct, err := s.getAccount(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if s.promotions != nil {
+		reversed, err := s.promotions.clawbackOnClosure(ctx, acct, req.SweepAccountID != "")
+		if err != nil {
+			return nil, err
+		}
+		if reversed {
+			if acct, err = s.getAccount(ctx, accountID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if acct.Balance != 0 && req.SweepAccountID != "" {
+		if err := s.sweep(ctx, acct, req.SweepAccountID); err != nil {
+			return nil, err
+		}
+		if acct, err = s.getAccount(ctx, accountID); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkClosable(acct); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	acct.Status, acct.ClosedAt, acct.LastModified = string(accountStatusClosed), now, now
+	if err := s.accounts.UpdateAccount(acct); err != nil {
+		return nil, err
+	}
+	s.logger.Log("accounts", fmt.Sprintf("closed account=%s", acct.ID), "requestID", requestIDFrom(ctx))
+	return acct, nil
+}
+
+func (s *accountClosureService) getAccount(ctx context.Context, accountID string) (*accounts.Account, error) {
+	accts, err := s.accounts.GetAccounts(ctx, []string{accountID})
+	if err != nil {
+		return nil, err
+	}
+	if len(accts) != 1 {
+		return nil, errAccountNotFound
+	}
+	return accts[0], nil
+}
+
+// sweep moves the account's balance to or from sweepAccountID so the account is left with nothing. Sweeps aren't
+// checked for sufficient funds as they empty the account exactly.
+func (s *accountClosureService) sweep(ctx context.Context, acct *accounts.Account, sweepAccountID string) error {
+	if sweepAccountID == acct.ID {
+		return fmt.Errorf("account=%s can't be swept into itself", acct.ID)
+	}
+	accts, err := s.accounts.GetAccounts(ctx, []string{sweepAccountID})
+	if err != nil {
+		return err
+	}
+	if len(accts) != 1 {
+		return fmt.Errorf("sweep account=%s not found", sweepAccountID)
+	}
+	if accountClosed(accts[0]) {
+		return fmt.Errorf("sweep account=%s: %v", sweepAccountID, errAccountClosed)
+	}
+
+	from, to, amount := acct.ID, sweepAccountID, int(acct.Balance)
+	if amount < 0 {
+		from, to, amount = to, from, -amount
+	}
+	tx := transaction{
+		ID:          newID(),
+		Timestamp:   time.Now(),
+		Status:      TransactionPosted,
+		Description: "Closing balance sweep",
+		Lines: []transactionLine{
+			{AccountID: from, Purpose: ACHDebit, Amount: amount},
+			{AccountID: to, Purpose: ACHCredit, Amount: amount},
+		},
+	}
+	if err := s.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		return fmt.Errorf("sweep: account=%s: %v", acct.ID, err)
+	}
+	s.logger.Log("accounts", fmt.Sprintf("swept %d from account=%s to account=%s in transaction=%s", amount, from, to, tx.ID), "requestID", requestIDFrom(ctx))
+	s.transactions.publish(ctx, tx.Status, &tx)
+	return nil
+}
+
+func closeAccount(logger log.Logger, svc *accountClosureService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		accountID := getAccountID(w, r)
+		if accountID == "" {
+			return
+		}
+
+		// The request body is optional
+		var req closeAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			moovhttp.Problem(w, err)
+			return
+		}
+		acct, err := svc.CloseAccount(requestContext(r), accountID, req)
+		if err != nil {
+			if err == errAccountNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(acct)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/moov-io/base"
+)
+
+func TestAccountClosure(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"checking": 1000, "sweep": 500, "overdrawn": 0})
+	events := &mockEventPublisher{}
+	svc := &accountClosureService{
+		logger:       log.NewNopLogger(),
+		accounts:     accountRepo,
+		transactions: &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: events},
+	}
+	router := mux.NewRouter()
+	addAccountClosureRoutes(log.NewNopLogger(), router, svc)
+	closeAcct := func(accountID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/accounts/"+accountID+"/close", strings.NewReader(body))
+		req.Header.Set("x-user-id", "test")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	// accounts with a balance need somewhere to sweep it
+	for _, body := range []string{"", `{"sweepAccountId": "missing"}`, `{"sweepAccountId": "checking"}`} {
+		if w := closeAcct("checking", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%q: bogus status code: %d", body, w.Code)
+		}
+	}
+	w := closeAcct("checking", `{"sweepAccountId": "sweep"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus status code: %d: %s", w.Code, w.Body.String())
+	}
+	var acct accounts.Account
+	if err := json.NewDecoder(w.Body).Decode(&acct); err != nil {
+		t.Fatal(err)
+	}
+	if acct.Status != "closed" || acct.ClosedAt.IsZero() || acct.Balance != 0 {
+		t.Errorf("unexpected account: %#v", acct)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"checking": 0, "sweep": 1500})
+	if len(events.events) != 1 {
+		t.Errorf("expected the sweep to be published: %#v", events.events)
+	}
+
+	// closed accounts can't be posted against or closed again
+	tx := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+		{AccountID: "sweep", Purpose: ACHDebit, Amount: 100},
+		{AccountID: "checking", Purpose: ACHCredit, Amount: 100},
+	}}
+	if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err == nil || !strings.Contains(err.Error(), errAccountClosed.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if w := closeAcct("checking", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus status code: %d", w.Code)
+	}
+	if w := closeAcct("sweep", `{"sweepAccountId": "checking"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus status code: %d", w.Code)
+	}
+	if w := closeAcct("missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus status code: %d", w.Code)
+	}
+
+	// negative balances are covered by the sweep account
+	tx = transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+		{AccountID: "overdrawn", Purpose: ACHDebit, Amount: 300},
+		{AccountID: "sweep", Purpose: ACHCredit, Amount: 300},
+	}}
+	if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		t.Fatal(err)
+	}
+	if w := closeAcct("overdrawn", `{"sweepAccountId": "sweep"}`); w.Code != http.StatusOK {
+		t.Errorf("bogus status code: %d: %s", w.Code, w.Body.String())
+	}
+	checkBalances(t, accountRepo, map[string]int32{"overdrawn": 0, "sweep": 1500})
+}
+
+func TestSqlTransactions__closedAccounts(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	accountRepo := createTestSqlAccountRepository(t, db.DB)
+
+	now := time.Now()
+	for _, id := range []string{"open", "closed"} {
+		acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: id, RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking", CreatedAt: now, LastModified: now}
+		if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	acct := &accounts.Account{ID: "closed", Status: "closed", Type: "checking", ClosedAt: now, LastModified: now}
+	if err := accountRepo.UpdateAccount(acct); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := transaction{ID: base.ID(), Timestamp: now, Lines: []transactionLine{
+		{AccountID: "open", Purpose: ACHDebit, Amount: 100},
+		{AccountID: "closed", Purpose: ACHCredit, Amount: 100},
+	}}
+	if err := accountRepo.transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err == nil || !strings.Contains(err.Error(), errAccountClosed.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
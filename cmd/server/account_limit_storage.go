@@ -0,0 +1,99 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type accountLimitRepository interface {
+	Ping() error
+	Close() error
+
+	// getAccountLimits returns the account's overrides of the default daily limits.
+	getAccountLimits(accountID string) (map[limitClass]int64, error)
+
+	// setAccountLimit creates or replaces the account's daily limit in a class.
+	setAccountLimit(accountID string, class limitClass, amount int64, at time.Time) error
+
+	// deleteAccountLimit returns false if the account doesn't have its own limit in the class.
+	deleteAccountLimit(accountID string, class limitClass) (bool, error)
+}
+
+type sqlAccountLimitRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlAccountLimitRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlAccountLimitRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlAccountLimitRepository) getAccountLimits(accountID string) (map[limitClass]int64, error) {
+	query := `select limit_class, amount from account_limits where account_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("getAccountLimits: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getAccountLimits: account=%s: %v", accountID, err)
+	}
+	defer rows.Close()
+
+	out := make(map[limitClass]int64)
+	for rows.Next() {
+		var class limitClass
+		var amount int64
+		if err := rows.Scan(&class, &amount); err != nil {
+			return nil, fmt.Errorf("getAccountLimits: scan: %v", err)
+		}
+		out[class] = amount
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlAccountLimitRepository) setAccountLimit(accountID string, class limitClass, amount int64, at time.Time) error {
+	query := `replace into account_limits (account_id, limit_class, amount, updated_at) values (?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("setAccountLimit: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(accountID, class, amount, at); err != nil {
+		return fmt.Errorf("setAccountLimit: account=%s class=%s: %v", accountID, class, err)
+	}
+	return nil
+}
+
+func (r *sqlAccountLimitRepository) deleteAccountLimit(accountID string, class limitClass) (bool, error) {
+	query := `delete from account_limits where account_id = ? and limit_class = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, fmt.Errorf("deleteAccountLimit: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(accountID, class)
+	if err != nil {
+		return false, fmt.Errorf("deleteAccountLimit: account=%s class=%s: %v", accountID, class, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("deleteAccountLimit: account=%s class=%s: %v", accountID, class, err)
+	}
+	return n == 1, nil
+}
@@ -0,0 +1,383 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+var (
+	errDailyLimitExceeded = errors.New("daily limit exceeded")
+)
+
+// limitClass groups an account's debits which share a daily limit, so cash withdrawals can be limited separately
+// from card purchases and ACH debits.
+type limitClass string
+
+const (
+	// limitATM is card transactions with a cash disbursement MCC
+	limitATM limitClass = "atm"
+
+	// limitPOS is every other card transaction
+	limitPOS limitClass = "pos"
+
+	// limitACHDebit is debits outside of card transactions
+	limitACHDebit limitClass = "achdebit"
+)
+
+var limitClasses = []limitClass{limitATM, limitPOS, limitACHDebit}
+
+var dailyLimitEnvVars = map[limitClass]string{
+	limitATM:      "DAILY_LIMIT_ATM",
+	limitPOS:      "DAILY_LIMIT_POS",
+	limitACHDebit: "DAILY_LIMIT_ACHDEBIT",
+}
+
+func (c limitClass) validate() error {
+	if _, ok := dailyLimitEnvVars[c]; !ok {
+		return fmt.Errorf("unknown limit class %q, expected atm, pos or achdebit", c)
+	}
+	return nil
+}
+
+// cashMCCs are the MCCs of cash disbursements, which are ATM withdrawals.
+var cashMCCs = map[string]bool{"6010": true, "6011": true}
+
+// limitClassOf returns which class a transaction's debits are in.
+func limitClassOf(tx transaction) limitClass {
+	switch mcc := tx.mcc(); {
+	case cashMCCs[mcc]:
+		return limitATM
+	case mcc != "":
+		return limitPOS
+	}
+	return limitACHDebit
+}
+
+// readDailyLimits reads the daily limit (in cents) of every account's debits in each class from DAILY_LIMIT_ATM,
+// DAILY_LIMIT_POS and DAILY_LIMIT_ACHDEBIT. Classes without a limit aren't limited unless an account has an override.
+func readDailyLimits() (map[limitClass]int64, error) {
+	out := make(map[limitClass]int64)
+	for class, key := range dailyLimitEnvVars {
+		if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", key, v)
+			}
+			out[class] = n
+		}
+	}
+	return out, nil
+}
+
+// accountLimits enforces daily limits on each account's debits. Accounts get the default limits, replaced by those
+// of their product, unless they have an override.
+type accountLimits struct {
+	repo     accountLimitRepository
+	defaults map[limitClass]int64
+	products *productCatalog
+}
+
+// limitsOf returns the account's limit in each class which is limited.
+func (l *accountLimits) limitsOf(accountID string) (map[limitClass]int64, error) {
+	overrides, err := l.repo.getAccountLimits(accountID)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := l.products.settingsOf(accountID)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[limitClass]int64)
+	for class, amount := range l.defaults {
+		out[class] = amount
+	}
+	if settings != nil {
+		for class, amount := range settings.DailyLimits {
+			out[class] = amount
+		}
+	}
+	for class, amount := range overrides {
+		out[class] = amount
+	}
+	return out, nil
+}
+
+// dailyLimitDay returns the start and (exclusive) end of the day containing when in the account's time zone, which
+// is when its limits reset.
+func (l *accountLimits) dailyLimitDay(accountID string, when time.Time) (time.Time, time.Time, error) {
+	loc, err := l.products.locationOf(accountID)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	start, end := dayIn(when, loc)
+	return start, end, nil
+}
+
+// used returns the account's debits in each class over the day containing when in the account's time zone.
+func (l *accountLimits) used(ctx context.Context, repo accountTransactionReader, accountID string, when time.Time) (map[limitClass]int64, error) {
+	start, end, err := l.dailyLimitDay(accountID, when)
+	if err != nil {
+		return nil, err
+	}
+	return usedBetween(ctx, repo, accountID, start, end)
+}
+
+// usedBetween returns the account's debits in each class from start until end. Pending and held transactions count
+// towards limits along with posted ones so they can't be used to get around them.
+func usedBetween(ctx context.Context, repo accountTransactionReader, accountID string, start, end time.Time) (map[limitClass]int64, error) {
+	transactions, _, err := repo.getAccountTransactions(ctx, accountID, transactionPage{StartDate: start, EndDate: end})
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[limitClass]int64)
+	for i := range transactions {
+		switch transactions[i].Status {
+		case TransactionPosted, TransactionPending, TransactionHeld:
+			out[limitClassOf(transactions[i])] += accountDebits(transactions[i], accountID)
+		}
+	}
+	return out, nil
+}
+
+// accountDebits returns how much tx debits the account.
+func accountDebits(tx transaction, accountID string) int64 {
+	var total int64
+	for i := range tx.Lines {
+		if tx.Lines[i].AccountID == accountID && tx.Lines[i].Purpose == ACHDebit {
+			total += int64(tx.Lines[i].Amount)
+		}
+	}
+	return total
+}
+
+// limitingTransactionRepository checks each debited account's daily limits as a transaction is posted. The check
+// runs inside the posting once the debited accounts are locked, so concurrent debits can't both fit under a limit.
+// Force posts, initial deposits and reversals aren't checked.
+type limitingTransactionRepository struct {
+	transactionRepository
+
+	limits *accountLimits
+}
+
+func (r *limitingTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	if !opts.AllowOverdraft && !opts.InitialDeposit && !opts.Reversal {
+		limits, err := r.dailyLimitsOf(tx)
+		if err != nil {
+			return err
+		}
+		if len(limits) > 0 {
+			check := opts.Check
+			opts.Check = func(ctx context.Context, postings accountTransactionReader) error {
+				if check != nil {
+					if err := check(ctx, postings); err != nil {
+						return err
+					}
+				}
+				return r.checkLimits(ctx, tx, limits, postings)
+			}
+		}
+	}
+	return r.transactionRepository.createTransaction(ctx, tx, opts)
+}
+
+// dailyLimit is a debited account's limit in a transaction's class over the day it's posted on.
+type dailyLimit struct {
+	accountID  string
+	limit      int64
+	start, end time.Time
+}
+
+// dailyLimitsOf reads the limits of each account tx debits ahead of posting, so the posting's locks aren't held
+// while reading overrides and products.
+func (r *limitingTransactionRepository) dailyLimitsOf(tx transaction) ([]dailyLimit, error) {
+	class := limitClassOf(tx)
+	checked := make(map[string]bool)
+	var out []dailyLimit
+	for i := range tx.Lines {
+		accountID := tx.Lines[i].AccountID
+		if tx.Lines[i].Purpose != ACHDebit || checked[accountID] {
+			continue
+		}
+		checked[accountID] = true
+
+		limits, err := r.limits.limitsOf(accountID)
+		if err != nil {
+			return nil, fmt.Errorf("checkLimits: account=%s: %v", accountID, err)
+		}
+		limit, ok := limits[class]
+		if !ok {
+			continue
+		}
+		start, end, err := r.limits.dailyLimitDay(accountID, tx.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("checkLimits: account=%s: %v", accountID, err)
+		}
+		out = append(out, dailyLimit{accountID: accountID, limit: limit, start: start, end: end})
+	}
+	return out, nil
+}
+
+// checkLimits rejects tx if it takes any debited account over its limit, reading the account's earlier debits from
+// postings.
+func (r *limitingTransactionRepository) checkLimits(ctx context.Context, tx transaction, limits []dailyLimit, postings accountTransactionReader) error {
+	class := limitClassOf(tx)
+	for _, l := range limits {
+		used, err := usedBetween(unscoped(ctx), postings, l.accountID, l.start, l.end)
+		if err != nil {
+			return fmt.Errorf("checkLimits: account=%s: %v", l.accountID, err)
+		}
+		if total := used[class] + accountDebits(tx, l.accountID); total > l.limit {
+			return fmt.Errorf("transaction=%s: %v: account=%s %s debits of %d are over its limit of %d", tx.ID, errDailyLimitExceeded, l.accountID, class, total, l.limit)
+		}
+	}
+	return nil
+}
+
+// remainingLimit is how much more an account can be debited today in one class.
+type remainingLimit struct {
+	Class     limitClass `json:"class"`
+	Limit     int64      `json:"limit"`
+	Used      int64      `json:"used"`
+	Remaining int64      `json:"remaining"`
+}
+
+type remainingLimits struct {
+	AccountID string           `json:"accountId"`
+	Day       string           `json:"day"`
+	ResetsAt  time.Time        `json:"resetsAt"`
+	Limits    []remainingLimit `json:"limits"`
+}
+
+// remaining returns the account's remaining limits over the day containing when in the account's time zone. Classes
+// which aren't limited are left out.
+func (l *accountLimits) remaining(ctx context.Context, repo transactionRepository, accountID string, when time.Time) (*remainingLimits, error) {
+	limits, err := l.limitsOf(accountID)
+	if err != nil {
+		return nil, err
+	}
+	used, err := l.used(ctx, repo, accountID, when)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := l.dailyLimitDay(accountID, when)
+	if err != nil {
+		return nil, err
+	}
+	out := &remainingLimits{AccountID: accountID, Day: start.Format("2006-01-02"), ResetsAt: end, Limits: make([]remainingLimit, 0)}
+	for _, class := range limitClasses {
+		limit, ok := limits[class]
+		if !ok {
+			continue
+		}
+		remaining := limit - used[class]
+		if remaining < 0 {
+			remaining = 0
+		}
+		out.Limits = append(out.Limits, remainingLimit{Class: class, Limit: limit, Used: used[class], Remaining: remaining})
+	}
+	return out, nil
+}
+
+func addAccountLimitRoutes(logger log.Logger, router *mux.Router, accountRepo accountRepository, transactionRepo transactionRepository, limits *accountLimits) {
+	router.Methods("GET").Path("/accounts/{accountId}/limits").HandlerFunc(getAccountLimits(logger, accountRepo, transactionRepo, limits))
+}
+
+// getAccountLimits returns how much more an account can be debited today in each limit class, for display in
+// customer apps.
+func getAccountLimits(logger log.Logger, accountRepo accountRepository, transactionRepo transactionRepository, limits *accountLimits) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		accountID := getAccountID(w, r)
+		if accountID == "" {
+			return
+		}
+		accts, err := accountRepo.GetAccounts(r.Context(), []string{accountID})
+		if err != nil {
+			logger.Log("limits", fmt.Sprintf("problem reading account=%s: %v", accountID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if len(accts) != 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		remaining, err := limits.remaining(r.Context(), transactionRepo, accountID, time.Now())
+		if err != nil {
+			logger.Log("limits", fmt.Sprintf("problem reading limits of account=%s: %v", accountID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(remaining)
+	}
+}
+
+// accountLimitOverride is an admin route which sets (PUT) or removes (DELETE) an account's own daily limit in a
+// class, replacing the default limit.
+func accountLimitOverride(logger log.Logger, repo accountLimitRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID, class := mux.Vars(r)["accountId"], limitClass(strings.ToLower(mux.Vars(r)["class"]))
+		if err := class.validate(); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		switch r.Method {
+		case "PUT":
+			var req struct {
+				Amount *int64 `json:"amount"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if req.Amount == nil || *req.Amount < 0 {
+				moovhttp.Problem(w, errors.New("amount must be zero or more cents"))
+				return
+			}
+			if err := repo.setAccountLimit(accountID, class, *req.Amount, time.Now()); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("limits", fmt.Sprintf("set account=%s daily %s limit to %d", accountID, class, *req.Amount), "userID", moovhttp.GetUserID(r))
+			w.WriteHeader(http.StatusOK)
+
+		case "DELETE":
+			found, err := repo.deleteAccountLimit(accountID, class)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			logger.Log("limits", fmt.Sprintf("removed account=%s daily %s limit override", accountID, class), "userID", moovhttp.GetUserID(r))
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
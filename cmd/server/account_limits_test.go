@@ -0,0 +1,325 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestAccountLimits__read(t *testing.T) {
+	defer os.Unsetenv("DAILY_LIMIT_ATM")
+	defer os.Unsetenv("DAILY_LIMIT_ACHDEBIT")
+
+	os.Setenv("DAILY_LIMIT_ATM", "50000")
+	os.Setenv("DAILY_LIMIT_ACHDEBIT", " 0 ")
+	limits, err := readDailyLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[limitClass]int64{limitATM: 50000, limitACHDebit: 0}; !reflect.DeepEqual(limits, expected) {
+		t.Errorf("limits=%#v", limits)
+	}
+	for _, v := range []string{"-1", "100.50", "lots"} {
+		os.Setenv("DAILY_LIMIT_ATM", v)
+		if _, err := readDailyLimits(); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
+
+func TestAccountLimits__class(t *testing.T) {
+	tx := func(mcc string) transaction {
+		lines := []transactionLine{{AccountID: "alice", Purpose: ACHDebit, Amount: 100}}
+		if mcc != "" {
+			lines = append(lines, transactionLine{AccountID: "settlement", Purpose: Card, Amount: 100, MCC: mcc})
+		} else {
+			lines = append(lines, transactionLine{AccountID: "bob", Purpose: ACHCredit, Amount: 100})
+		}
+		return transaction{Lines: lines}
+	}
+	cases := map[string]limitClass{"6011": limitATM, "6010": limitATM, "5411": limitPOS, "": limitACHDebit}
+	for mcc, expected := range cases {
+		if class := limitClassOf(tx(mcc)); class != expected {
+			t.Errorf("mcc=%q: got %s", mcc, class)
+		}
+	}
+	if err := limitClass("wire").validate(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestAccountLimitRepository(t *testing.T) {
+	check := func(t *testing.T, repo accountLimitRepository) {
+		if err := repo.setAccountLimit("alice", limitATM, 500, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.setAccountLimit("alice", limitATM, 800, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.setAccountLimit("alice", limitPOS, 0, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		limits, err := repo.getAccountLimits("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := map[limitClass]int64{limitATM: 800, limitPOS: 0}; !reflect.DeepEqual(limits, expected) {
+			t.Errorf("limits=%#v", limits)
+		}
+
+		if found, err := repo.deleteAccountLimit("alice", limitATM); !found || err != nil {
+			t.Errorf("found=%v error=%v", found, err)
+		}
+		if found, err := repo.deleteAccountLimit("alice", limitATM); found || err != nil {
+			t.Errorf("found=%v error=%v", found, err)
+		}
+		if limits, err := repo.getAccountLimits("bob"); len(limits) != 0 || err != nil {
+			t.Errorf("limits=%#v error=%v", limits, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlAccountLimitRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlAccountLimitRepository{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestAccountLimits(t *testing.T) {
+	accountRepo, memoryRepo := createTestLedger(t, map[string]int{"alice": 100000, "settlement": 0, "bob": 0})
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	limitRepo := &sqlAccountLimitRepository{db.DB, log.NewNopLogger()}
+	limits := &accountLimits{repo: limitRepo, defaults: map[limitClass]int64{limitATM: 20000, limitACHDebit: 1000}}
+	transactionRepo := &limitingTransactionRepository{transactionRepository: memoryRepo, limits: limits}
+
+	post := func(amount int, mcc string, when time.Time, opts createTransactionOpts) error {
+		credit := transactionLine{AccountID: "bob", Purpose: ACHCredit, Amount: amount}
+		if mcc != "" {
+			credit = transactionLine{AccountID: "settlement", Purpose: Card, Amount: amount, MCC: mcc}
+		}
+		return transactionRepo.createTransaction(context.Background(), transaction{
+			ID:        base.ID(),
+			Timestamp: when,
+			Status:    TransactionPosted,
+			Lines:     []transactionLine{{AccountID: "alice", Purpose: ACHDebit, Amount: amount}, credit},
+		}, opts)
+	}
+	now := time.Now()
+
+	// yesterday's withdrawals don't count towards today's limit
+	if err := post(20000, "6011", now.Add(-24*time.Hour), createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := post(15000, "6011", now, createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := post(6000, "6011", now, createTransactionOpts{}); err == nil || !strings.Contains(err.Error(), errDailyLimitExceeded.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// classes are limited separately and POS isn't limited by default
+	if err := post(30000, "5411", now, createTransactionOpts{}); err != nil {
+		t.Error(err)
+	}
+	if err := post(1000, "", now, createTransactionOpts{}); err != nil {
+		t.Error(err)
+	}
+	if err := post(1, "", now, createTransactionOpts{}); err == nil || !strings.Contains(err.Error(), "achdebit debits of 1001 are over its limit of 1000") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// force posts and reversals aren't limited
+	if err := post(100, "", now, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		t.Error(err)
+	}
+	if err := post(100, "", now, createTransactionOpts{Reversal: true}); err != nil {
+		t.Error(err)
+	}
+
+	// overrides replace the default limit
+	if err := limitRepo.setAccountLimit("alice", limitATM, 25000, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := limitRepo.setAccountLimit("alice", limitPOS, 50000, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := post(6000, "6011", now, createTransactionOpts{}); err != nil {
+		t.Error(err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 27800, "settlement": 71000, "bob": 1200})
+
+	remaining, err := limits.remaining(context.Background(), transactionRepo, "alice", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []remainingLimit{
+		{Class: limitATM, Limit: 25000, Used: 21000, Remaining: 4000},
+		{Class: limitPOS, Limit: 50000, Used: 30000, Remaining: 20000},
+		{Class: limitACHDebit, Limit: 1000, Used: 1200, Remaining: 0},
+	}
+	if !reflect.DeepEqual(remaining.Limits, expected) {
+		t.Errorf("limits=%#v", remaining.Limits)
+	}
+	if remaining.Day != now.UTC().Format("2006-01-02") {
+		t.Errorf("day=%s", remaining.Day)
+	}
+}
+
+func TestAccountLimits__concurrent(t *testing.T) {
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		account1, account2 := base.ID(), base.ID()
+		repo.accountRepo = &testAccountRepository{
+			accounts: []*accounts.Account{
+				{ID: account1, AccountNumber: "123", RoutingNumber: defaultRoutingNumber},
+				{ID: account2, AccountNumber: "432", RoutingNumber: defaultRoutingNumber},
+			},
+		}
+		deposit := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Lines:     []transactionLine{{AccountID: account1, Purpose: ACHCredit, Amount: 100000}},
+		}
+		if err := repo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+		limitRepo := &sqlAccountLimitRepository{database.CreateTestSqliteDB(t).DB, log.NewNopLogger()}
+		defer limitRepo.db.Close()
+		limits := &accountLimits{repo: limitRepo, defaults: map[limitClass]int64{limitACHDebit: 1000}}
+		transactionRepo := &limitingTransactionRepository{transactionRepository: repo, limits: limits}
+
+		// Race more debits than the limit covers, none of which can take the account over its limit.
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		posted := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tx := transaction{
+					ID:        base.ID(),
+					Timestamp: time.Now(),
+					Lines: []transactionLine{
+						{AccountID: account1, Purpose: ACHDebit, Amount: 300},
+						{AccountID: account2, Purpose: ACHCredit, Amount: 300},
+					},
+				}
+				if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{}); err == nil {
+					mu.Lock()
+					posted++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		used, err := limits.used(context.Background(), repo, account1, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if posted > 3 || used[limitACHDebit] != int64(300*posted) {
+			t.Errorf("used=%d after %d debits posted", used[limitACHDebit], posted)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestAccountLimits__routes(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"alice": 1000, "bob": 0})
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	limitRepo := &sqlAccountLimitRepository{db.DB, log.NewNopLogger()}
+	limits := &accountLimits{repo: limitRepo, defaults: map[limitClass]int64{limitACHDebit: 500}}
+
+	if err := transactionRepo.createTransaction(context.Background(), transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now(),
+		Status:    TransactionPosted,
+		Lines: []transactionLine{
+			{AccountID: "alice", Purpose: ACHDebit, Amount: 200},
+			{AccountID: "bob", Purpose: ACHCredit, Amount: 200},
+		},
+	}, createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	addAccountLimitRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, limits)
+	router.HandleFunc("/accounts/{accountId}/limits/{class}", accountLimitOverride(log.NewNopLogger(), limitRepo))
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", "ops")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	if w := serve("PUT", "/accounts/alice/limits/ATM", `{"amount": 30000}`); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	for _, req := range []struct{ path, body string }{
+		{"/accounts/alice/limits/wire", `{"amount": 100}`},
+		{"/accounts/alice/limits/pos", `{"amount": -1}`},
+		{"/accounts/alice/limits/pos", `{}`},
+	} {
+		if w := serve("PUT", req.path, req.body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: bogus HTTP status: %d", req.path, req.body, w.Code)
+		}
+	}
+
+	w := serve("GET", "/accounts/alice/limits", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var remaining remainingLimits
+	if err := json.NewDecoder(w.Body).Decode(&remaining); err != nil {
+		t.Fatal(err)
+	}
+	expected := []remainingLimit{
+		{Class: limitATM, Limit: 30000, Used: 0, Remaining: 30000},
+		{Class: limitACHDebit, Limit: 500, Used: 200, Remaining: 300},
+	}
+	if !reflect.DeepEqual(remaining.Limits, expected) {
+		t.Errorf("limits=%#v", remaining.Limits)
+	}
+
+	if w := serve("DELETE", "/accounts/alice/limits/atm", ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("DELETE", "/accounts/alice/limits/atm", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("GET", "/accounts/unknown/limits", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	accounts "github.com/moov-io/accounts/client"
+)
+
+var (
+	errAccountFrozen    = errors.New("account is frozen")
+	errAccountSuspended = errors.New("account is suspended")
+)
+
+// AccountStatus restricts the postings of an account. Frozen accounts can still receive credits (such as incoming
+// payments) but can't be debited, while suspended accounts can't be posted against at all until they're reopened.
+type AccountStatus string
+
+const (
+	accountStatusOpen      AccountStatus = "open"
+	accountStatusFrozen    AccountStatus = "frozen"
+	accountStatusSuspended AccountStatus = "suspended"
+	accountStatusClosed    AccountStatus = "closed"
+)
+
+func (s *AccountStatus) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = AccountStatus(strings.ToLower(v))
+	if err := s.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s AccountStatus) validate() error {
+	switch s {
+	case accountStatusOpen, accountStatusFrozen, accountStatusSuspended, accountStatusClosed:
+		return nil
+	default:
+		return fmt.Errorf("unknown AccountStatus %q", s)
+	}
+}
+
+// validateAccount returns an error if the account's type or status is unknown. Accounts created before requests
+// were lowercased can have capitalized types, so both are compared without case. System accounts are stored like
+// any other, even though customers can't request their type.
+func validateAccount(acct *accounts.Account) error {
+	if t := AccountType(strings.ToLower(acct.Type)); t != System {
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("account=%s: %v", acct.ID, err)
+		}
+	}
+	if err := AccountStatus(strings.ToLower(acct.Status)).validate(); err != nil {
+		return fmt.Errorf("account=%s: %v", acct.ID, err)
+	}
+	return nil
+}
+
+// checkAccountStatuses rejects lines which the status of their account doesn't allow. accts are the accounts of
+// the lines, accounts which aren't found are left for balance checks to reject.
+func checkAccountStatuses(accts []*accounts.Account, lines []transactionLine) error {
+	statuses := make(map[string]AccountStatus)
+	for i := range accts {
+		statuses[accts[i].ID] = AccountStatus(strings.ToLower(accts[i].Status))
+	}
+	for i := range lines {
+		switch statuses[lines[i].AccountID] {
+		case accountStatusClosed:
+			return fmt.Errorf("account=%q: %v", lines[i].AccountID, errAccountClosed)
+		case accountStatusSuspended:
+			return fmt.Errorf("account=%q: %v", lines[i].AccountID, errAccountSuspended)
+		case accountStatusFrozen:
+			if lines[i].Purpose == ACHDebit {
+				return fmt.Errorf("account=%q: %v, it can't be debited", lines[i].AccountID, errAccountFrozen)
+			}
+		}
+	}
+	return nil
+}
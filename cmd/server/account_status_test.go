@@ -0,0 +1,88 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/moov-io/base"
+)
+
+func TestAccountStatus__checkAccountStatuses(t *testing.T) {
+	accts := []*accounts.Account{
+		{ID: "open", Status: "Open"},
+		{ID: "frozen", Status: "Frozen"},
+		{ID: "suspended", Status: "suspended"},
+		{ID: "closed", Status: "closed"},
+	}
+	cases := []struct {
+		lines    []transactionLine
+		expected error
+	}{
+		{[]transactionLine{{AccountID: "open", Purpose: ACHDebit}, {AccountID: "frozen", Purpose: ACHCredit}}, nil},
+		{[]transactionLine{{AccountID: "frozen", Purpose: ACHDebit}, {AccountID: "open", Purpose: ACHCredit}}, errAccountFrozen},
+		{[]transactionLine{{AccountID: "open", Purpose: ACHDebit}, {AccountID: "suspended", Purpose: ACHCredit}}, errAccountSuspended},
+		{[]transactionLine{{AccountID: "open", Purpose: ACHDebit}, {AccountID: "closed", Purpose: ACHCredit}}, errAccountClosed},
+		{[]transactionLine{{AccountID: "missing", Purpose: ACHDebit}}, nil},
+	}
+	for i := range cases {
+		err := checkAccountStatuses(accts, cases[i].lines)
+		if cases[i].expected == nil && err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+		}
+		if cases[i].expected != nil && (err == nil || !strings.Contains(err.Error(), cases[i].expected.Error())) {
+			t.Errorf("#%d: expected %v, got %v", i, cases[i].expected, err)
+		}
+	}
+}
+
+func TestAccountStatus__createTransaction(t *testing.T) {
+	check := func(t *testing.T, accountRepo accountRepository, repo transactionRepository) {
+		now := time.Now()
+		for _, id := range []string{"frozen", "suspended"} {
+			acct := &accounts.Account{ID: id, Status: id, Type: "checking", LastModified: now}
+			if err := accountRepo.UpdateAccount(acct); err != nil {
+				t.Fatal(err)
+			}
+		}
+		post := func(from, to string) error {
+			tx := transaction{ID: base.ID(), Timestamp: now, Lines: []transactionLine{
+				{AccountID: from, Purpose: ACHDebit, Amount: 100},
+				{AccountID: to, Purpose: ACHCredit, Amount: 100},
+			}}
+			return repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true})
+		}
+		if err := post("open", "frozen"); err != nil {
+			t.Errorf("frozen accounts can be credited: %v", err)
+		}
+		if err := post("frozen", "open"); err == nil || !strings.Contains(err.Error(), errAccountFrozen.Error()) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if err := post("open", "suspended"); err == nil || !strings.Contains(err.Error(), errAccountSuspended.Error()) {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"open": 1000, "frozen": 1000, "suspended": 1000})
+	check(t, accountRepo, transactionRepo)
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	sqlAccountRepo := createTestSqlAccountRepository(t, db.DB)
+	now := time.Now()
+	for _, id := range []string{"open", "frozen", "suspended"} {
+		acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: id, RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking", CreatedAt: now, LastModified: now}
+		if err := sqlAccountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(t, sqlAccountRepo, sqlAccountRepo.transactionRepo)
+}
@@ -5,6 +5,7 @@
 package main
 
 import (
+	"context"
 	accounts "github.com/moov-io/accounts/client"
 )
 
@@ -12,9 +13,19 @@ type accountRepository interface {
 	Ping() error
 	Close() error
 
-	GetAccounts(accountIDs []string) ([]*accounts.Account, error)
-	CreateAccount(customerID string, account *accounts.Account) error // TODO(adam): acctType needs strong type, we can drop customerID as it's on accounts.Account
+	GetAccounts(ctx context.Context, accountIDs []string) ([]*accounts.Account, error)
+	// CreateAccount returns errAccountExists if the account number is already used with the routing number, and an
+	// error if the account's type or status is unknown.
+	CreateAccount(customerID string, account *accounts.Account) error // TODO(adam): we can drop customerID as it's on accounts.Account
 
-	SearchAccountsByCustomerID(customerID string) ([]*accounts.Account, error)
-	SearchAccountsByRoutingNumber(accountNumber, routingNumber, acctType string) (*accounts.Account, error)
+	// UpdateAccount saves the account's name, status, type, closedAt and lastModified fields. errAccountNotFound is
+	// returned if the account doesn't exist.
+	UpdateAccount(account *accounts.Account) error
+
+	SearchAccountsByCustomerID(ctx context.Context, customerID string) ([]*accounts.Account, error)
+	SearchAccountsByRoutingNumber(ctx context.Context, accountNumber, routingNumber, acctType string) (*accounts.Account, error)
+
+	// ListAccounts returns up to limit accounts ordered by their ID, starting after the account ID given.
+	// Pass an empty string to start from the first account.
+	ListAccounts(after string, limit int) ([]*accounts.Account, error)
 }
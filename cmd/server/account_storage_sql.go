@@ -8,9 +8,11 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sort"
 	"strings"
 
 	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
 
 	"github.com/go-kit/kit/log"
 )
@@ -39,18 +41,58 @@ func (r *sqlAccountRepository) Close() error {
 	return r.db.Close()
 }
 
-func (r *sqlAccountRepository) GetAccounts(accountIDs []string) ([]*accounts.Account, error) {
+func (r *sqlAccountRepository) GetAccounts(ctx context.Context, accountIDs []string) ([]*accounts.Account, error) {
 	if len(accountIDs) == 0 {
 		return nil, nil // no accountIDs to find
 	}
 
+	_, span := startSQLSpan(ctx, "begin")
 	tx, err := r.db.Begin()
+	endSpan(span, err)
 	if err != nil {
-		return nil, fmt.Errorf("GetAccounts: tx.Begin: error=%v rollback=%v", err, tx.Rollback())
+		return nil, fmt.Errorf("GetAccounts: tx.Begin: error=%v", err)
 	}
 
-	query := fmt.Sprintf(`select account_id, customer_id, name, account_number, routing_number, status, type, created_at, closed_at, last_modified
-from accounts where account_id in (?%s) and deleted_at is null;`, strings.Repeat(",?", len(accountIDs)-1))
+	_, span = startSQLSpan(ctx, "selectAccounts")
+	out, err := r.selectAccounts(ctx, tx, accountIDs)
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	// Balances are summed from their stripes and pending transactions, which is the slow part of reading accounts
+	_, span = startSQLSpan(ctx, "readBalances")
+	for i := range out {
+		balance, err := r.transactionRepo.getAccountBalance(tx, out[i].ID)
+		if err != nil {
+			endSpan(span, err)
+			return nil, fmt.Errorf("GetAccounts: getAccountBalance: account=%q error=%v rollback=%v", out[i].ID, err, tx.Rollback())
+		}
+		out[i].Balance = balance
+
+		pending, err := r.transactionRepo.getPendingBalance(tx, out[i].ID)
+		if err != nil {
+			endSpan(span, err)
+			return nil, fmt.Errorf("GetAccounts: getPendingBalance: account=%q error=%v rollback=%v", out[i].ID, err, tx.Rollback())
+		}
+		pending.apply(out[i])
+	}
+	span.End()
+
+	_, span = startSQLSpan(ctx, "commit")
+	err = tx.Commit()
+	endSpan(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("GetAccounts: commit error=%v rollback=%v", err, tx.Rollback())
+	}
+	return out, nil
+}
+
+// selectAccounts reads the accounts in ctx's organization, without their balances, rolling tx back on an error.
+func (r *sqlAccountRepository) selectAccounts(ctx context.Context, tx *sql.Tx, accountIDs []string) ([]*accounts.Account, error) {
+	organization, organizationArgs := organizationFilter(ctx, "organization_id")
+	query := fmt.Sprintf(`select account_id, customer_id, organization_id, name, account_number, routing_number, status, type, overdraft_limit, created_at, closed_at, last_modified
+from accounts where account_id in (?%s) and deleted_at is null%s;`, strings.Repeat(",?", len(accountIDs)-1), organization)
 	stmt, err := tx.Prepare(query)
 	if err != nil {
 		return nil, fmt.Errorf("GetAccounts: tx.Prepare error=%v rollback=%v", err, tx.Rollback())
@@ -61,7 +103,7 @@ from accounts where account_id in (?%s) and deleted_at is null;`, strings.Repeat
 	for i := range accountIDs {
 		ids = append(ids, accountIDs[i])
 	}
-	rows, err := stmt.Query(ids...)
+	rows, err := stmt.Query(append(ids, organizationArgs...)...)
 	if err != nil {
 		return nil, fmt.Errorf("GetAccounts: stmt query error=%v rollback=%v", err, tx.Rollback())
 	}
@@ -69,7 +111,7 @@ from accounts where account_id in (?%s) and deleted_at is null;`, strings.Repeat
 	var out []*accounts.Account
 	for rows.Next() {
 		var a accounts.Account
-		err := rows.Scan(&a.ID, &a.CustomerID, &a.Name, &a.AccountNumber, &a.RoutingNumber, &a.Status, &a.Type, &a.CreatedAt, &a.ClosedAt, &a.LastModified)
+		err := rows.Scan(&a.ID, &a.CustomerID, &a.OrganizationID, &a.Name, &a.AccountNumber, &a.RoutingNumber, &a.Status, &a.Type, &a.OverdraftLimit, &a.CreatedAt, &a.ClosedAt, &a.LastModified)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				continue
@@ -77,49 +119,65 @@ from accounts where account_id in (?%s) and deleted_at is null;`, strings.Repeat
 			rows.Close()
 			return nil, fmt.Errorf("GetAccounts: account=%q error=%v rollback=%v", a.ID, err, tx.Rollback())
 		}
+		if err := validateAccount(&a); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("GetAccounts: %v rollback=%v", err, tx.Rollback())
+		}
 		out = append(out, &a)
 	}
 	rows.Close()
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("GetAccounts: scan error=%v rollback=%v", err, tx.Rollback())
 	}
-
-	for i := range out {
-		balance, err := r.transactionRepo.getAccountBalance(tx, out[i].ID)
-		if err != nil {
-			return nil, fmt.Errorf("GetAccounts: getAccountBalance: account=%q error=%v rollback=%v", out[i].ID, err, tx.Rollback())
-		}
-		// TODO(adam): need Balance, BalanceAvailable, and BalancePending
-		out[i].Balance = balance
-	}
-
-	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("GetAccounts: commit error=%v rollback=%v", err, tx.Rollback())
-	}
 	return out, nil
 }
 
 func (r *sqlAccountRepository) CreateAccount(customerID string, a *accounts.Account) error {
-	query := `insert into accounts (account_id, customer_id, name, account_number, routing_number, status, type, created_at, closed_at, last_modified) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	if err := validateAccount(a); err != nil {
+		return fmt.Errorf("CreateAccount: %v", err)
+	}
+	query := `insert into accounts (account_id, customer_id, organization_id, name, account_number, routing_number, status, type, overdraft_limit, created_at, closed_at, last_modified) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
 	stmt, err := r.db.Prepare(query)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(a.ID, a.CustomerID, a.Name, a.AccountNumber, a.RoutingNumber, a.Status, a.Type, a.CreatedAt, a.ClosedAt, a.LastModified)
+	_, err = stmt.Exec(a.ID, a.CustomerID, a.OrganizationID, a.Name, a.AccountNumber, a.RoutingNumber, a.Status, a.Type, a.OverdraftLimit, a.CreatedAt, a.ClosedAt, a.LastModified)
+	if err != nil && database.UniqueViolation(err) {
+		return errAccountExists
+	}
 	return err
 }
 
-func (r *sqlAccountRepository) SearchAccountsByRoutingNumber(accountNumber, routingNumber, acctType string) (*accounts.Account, error) {
-	query := `select account_id from accounts where account_number = ? and routing_number = ? and lower(type) = lower(?) and deleted_at is null limit 1;`
+func (r *sqlAccountRepository) UpdateAccount(a *accounts.Account) error {
+	query := `update accounts set name = ?, status = ?, type = ?, overdraft_limit = ?, closed_at = ?, last_modified = ? where account_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("UpdateAccount: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(a.Name, a.Status, a.Type, a.OverdraftLimit, a.ClosedAt, a.LastModified, a.ID)
+	if err != nil {
+		return fmt.Errorf("UpdateAccount: account=%q: %v", a.ID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errAccountNotFound
+	}
+	return nil
+}
+
+func (r *sqlAccountRepository) SearchAccountsByRoutingNumber(ctx context.Context, accountNumber, routingNumber, acctType string) (*accounts.Account, error) {
+	organization, organizationArgs := organizationFilter(ctx, "organization_id")
+	query := fmt.Sprintf(`select account_id from accounts where account_number = ? and routing_number = ? and lower(type) = lower(?) and type <> 'system' and deleted_at is null%s limit 1;`, organization)
 	stmt, err := r.db.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	row := stmt.QueryRow(accountNumber, routingNumber, acctType)
+	row := stmt.QueryRow(append([]interface{}{accountNumber, routingNumber, acctType}, organizationArgs...)...)
 	var id string
 	if err := row.Scan(&id); err != nil || id == "" {
 		if err == sql.ErrNoRows {
@@ -129,22 +187,23 @@ func (r *sqlAccountRepository) SearchAccountsByRoutingNumber(accountNumber, rout
 	}
 
 	// Grab out account by its ID
-	accounts, err := r.GetAccounts([]string{id})
+	accounts, err := r.GetAccounts(ctx, []string{id})
 	if err != nil || len(accounts) == 0 {
 		return nil, fmt.Errorf("SearchAccounts: no accounts: %v", err)
 	}
 	return accounts[0], nil
 }
 
-func (r *sqlAccountRepository) SearchAccountsByCustomerID(customerID string) ([]*accounts.Account, error) {
-	query := `select account_id from accounts where customer_id = ? and deleted_at is null;`
+func (r *sqlAccountRepository) SearchAccountsByCustomerID(ctx context.Context, customerID string) ([]*accounts.Account, error) {
+	organization, organizationArgs := organizationFilter(ctx, "organization_id")
+	query := fmt.Sprintf(`select account_id from accounts where customer_id = ? and type <> 'system' and deleted_at is null%s;`, organization)
 	stmt, err := r.db.Prepare(query)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(customerID)
+	rows, err := stmt.Query(append([]interface{}{customerID}, organizationArgs...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -164,5 +223,38 @@ func (r *sqlAccountRepository) SearchAccountsByCustomerID(customerID string) ([]
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
-	return r.GetAccounts(accountIDs)
+	return r.GetAccounts(ctx, accountIDs)
+}
+
+func (r *sqlAccountRepository) ListAccounts(after string, limit int) ([]*accounts.Account, error) {
+	query := `select account_id from accounts where account_id > ? and deleted_at is null order by account_id limit ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("ListAccounts: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("ListAccounts: query: %v", err)
+	}
+	defer rows.Close()
+
+	var accountIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("ListAccounts: scan: %v", err)
+		}
+		accountIDs = append(accountIDs, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ListAccounts: %v", err)
+	}
+	accts, err := r.GetAccounts(context.Background(), accountIDs)
+	if err != nil {
+		return nil, fmt.Errorf("ListAccounts: %v", err)
+	}
+	sort.Slice(accts, func(i, j int) bool { return accts[i].ID < accts[j].ID })
+	return accts, nil
 }
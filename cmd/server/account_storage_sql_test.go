@@ -7,6 +7,9 @@ package main
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -87,7 +90,7 @@ func TestSqlAccountRepository(t *testing.T) {
 		}
 
 		// read via one method
-		accounts, err := repo.GetAccounts([]string{account.ID})
+		accounts, err := repo.GetAccounts(context.Background(), []string{account.ID})
 		if err != nil {
 			t.Error(err)
 		}
@@ -99,7 +102,7 @@ func TestSqlAccountRepository(t *testing.T) {
 		}
 
 		// and read via another
-		accounts, err = repo.SearchAccountsByCustomerID(account.CustomerID)
+		accounts, err = repo.SearchAccountsByCustomerID(context.Background(), account.CustomerID)
 		if err != nil {
 			t.Error(err)
 		}
@@ -111,7 +114,7 @@ func TestSqlAccountRepository(t *testing.T) {
 		}
 
 		// finally via a third method
-		acct, err := repo.SearchAccountsByRoutingNumber(otherAccount.AccountNumber, otherAccount.RoutingNumber, otherAccount.Type)
+		acct, err := repo.SearchAccountsByRoutingNumber(context.Background(), otherAccount.AccountNumber, otherAccount.RoutingNumber, otherAccount.Type)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -120,7 +123,7 @@ func TestSqlAccountRepository(t *testing.T) {
 		}
 
 		// Change the case of otherAccount.Type
-		acct, err = repo.SearchAccountsByRoutingNumber(otherAccount.AccountNumber, otherAccount.RoutingNumber, "checKIng")
+		acct, err = repo.SearchAccountsByRoutingNumber(context.Background(), otherAccount.AccountNumber, otherAccount.RoutingNumber, "checKIng")
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -144,7 +147,7 @@ func TestSqlAccounts__GetAccounts(t *testing.T) {
 	check := func(t *testing.T, repo *sqlAccountRepository) {
 		defer repo.Close()
 
-		accounts, err := repo.GetAccounts(nil)
+		accounts, err := repo.GetAccounts(context.Background(), nil)
 		if err != nil {
 			t.Errorf("unexpected error: %v", err)
 		}
@@ -162,6 +165,58 @@ func TestSqlAccounts__GetAccounts(t *testing.T) {
 	check(t, createTestSqlAccountRepository(t, mysqlDB.DB))
 }
 
+func TestSqlAccountRepository_ListAccounts(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlAccountRepository) {
+		defer repo.Close()
+
+		customerID, now := base.ID(), time.Now()
+		var accountIDs []string
+		for i := 0; i < 3; i++ {
+			account := &accounts.Account{
+				ID:            base.ID(),
+				CustomerID:    customerID,
+				Name:          "test account",
+				AccountNumber: fmt.Sprintf("1241%d", i),
+				RoutingNumber: "219871289",
+				Status:        "open",
+				Type:          "Savings",
+				CreatedAt:     now,
+				LastModified:  now,
+			}
+			if err := repo.CreateAccount(customerID, account); err != nil {
+				t.Fatal(err)
+			}
+			accountIDs = append(accountIDs, account.ID)
+		}
+		sort.Strings(accountIDs)
+
+		accts, err := repo.ListAccounts("", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accts) != 2 || accts[0].ID != accountIDs[0] || accts[1].ID != accountIDs[1] {
+			t.Errorf("unexpected accounts: %#v", accts)
+		}
+		accts, err = repo.ListAccounts(accts[1].ID, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accts) != 1 || accts[0].ID != accountIDs[2] {
+			t.Errorf("unexpected accounts: %#v", accts)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlAccountRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlAccountRepository(t, mysqlDB.DB))
+}
+
 // TestSqlAccountRepository_unique will ensure we can't insert multiple accounts
 // with the same account and routing numbers.
 func TestSqlAccountRepository_unique(t *testing.T) {
@@ -190,12 +245,104 @@ func TestSqlAccountRepository_unique(t *testing.T) {
 
 		// attempt again
 		account.ID = base.ID()
-		if err := repo.CreateAccount(customerID, account); err == nil {
-			t.Error("expected error")
-		} else {
-			if !database.UniqueViolation(err) {
-				t.Errorf("unknown error: %v", err)
-			}
+		if err := repo.CreateAccount(customerID, account); err != errAccountExists {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlAccountRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlAccountRepository(t, mysqlDB.DB))
+}
+
+func TestSqlAccountRepository_UpdateAccount(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlAccountRepository) {
+		defer repo.Close()
+
+		now := time.Now().Truncate(time.Second)
+		account := &accounts.Account{
+			ID:            base.ID(),
+			CustomerID:    base.ID(),
+			Name:          "test account",
+			AccountNumber: "12411",
+			RoutingNumber: "219871289",
+			Status:        "open",
+			Type:          "savings",
+			CreatedAt:     now,
+			LastModified:  now,
+		}
+		if err := repo.CreateAccount(account.CustomerID, account); err != nil {
+			t.Fatal(err)
+		}
+
+		account.Name, account.Status, account.Type, account.OverdraftLimit = "renamed", "closed", "checking", 2500
+		account.ClosedAt, account.LastModified = now.Add(time.Hour), now.Add(time.Hour)
+		if err := repo.UpdateAccount(account); err != nil {
+			t.Fatal(err)
+		}
+		accts, err := repo.GetAccounts(context.Background(), []string{account.ID})
+		if err != nil || len(accts) != 1 {
+			t.Fatalf("accounts=%#v error=%v", accts, err)
+		}
+		if a := accts[0]; a.Name != "renamed" || a.Status != "closed" || a.Type != "checking" || a.OverdraftLimit != 2500 || !a.ClosedAt.Equal(account.ClosedAt) || !a.LastModified.Equal(account.LastModified) {
+			t.Errorf("unexpected account: %#v", a)
+		}
+
+		if err := repo.UpdateAccount(&accounts.Account{ID: base.ID()}); err != errAccountNotFound {
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlAccountRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlAccountRepository(t, mysqlDB.DB))
+}
+
+func TestSqlAccountRepository__typesAndStatuses(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlAccountRepository) {
+		defer repo.Close()
+
+		now := time.Now().Truncate(time.Second)
+		account := &accounts.Account{
+			ID:            base.ID(),
+			CustomerID:    base.ID(),
+			AccountNumber: base.ID()[:8],
+			RoutingNumber: "219871289",
+			Status:        "open",
+			Type:          "brokerage",
+			CreatedAt:     now,
+			LastModified:  now,
+		}
+		if err := repo.CreateAccount(account.CustomerID, account); err == nil || !strings.Contains(err.Error(), `unknown AccountType "brokerage"`) {
+			t.Errorf("expected error: %v", err)
+		}
+		account.Status, account.Type = "dormant", "fbo"
+		if err := repo.CreateAccount(account.CustomerID, account); err == nil || !strings.Contains(err.Error(), `unknown AccountStatus "dormant"`) {
+			t.Errorf("expected error: %v", err)
+		}
+		account.Status = "Open"
+		if err := repo.CreateAccount(account.CustomerID, account); err != nil {
+			t.Fatal(err)
+		}
+
+		// accounts stored with an unknown type aren't read
+		if _, err := repo.db.Exec(`update accounts set type = ? where account_id = ?;`, "brokerage", account.ID); err != nil {
+			t.Fatal(err)
+		}
+		if accts, err := repo.GetAccounts(context.Background(), []string{account.ID}); err == nil || !strings.Contains(err.Error(), "unknown AccountType") {
+			t.Errorf("accounts=%#v error=%v", accts, err)
 		}
 	}
 
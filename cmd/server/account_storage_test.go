@@ -5,6 +5,7 @@
 package main
 
 import (
+	"context"
 	accounts "github.com/moov-io/accounts/client"
 )
 
@@ -24,7 +25,7 @@ func (r *testAccountRepository) Close() error {
 	return r.err
 }
 
-func (r *testAccountRepository) GetAccounts(accountIDs []string) ([]*accounts.Account, error) {
+func (r *testAccountRepository) GetAccounts(ctx context.Context, accountIDs []string) ([]*accounts.Account, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
@@ -42,7 +43,11 @@ func (r *testAccountRepository) CreateAccount(customerID string, account *accoun
 	return r.err
 }
 
-func (r *testAccountRepository) SearchAccountsByRoutingNumber(accountNumber, routingNumber, acctType string) (*accounts.Account, error) {
+func (r *testAccountRepository) UpdateAccount(account *accounts.Account) error {
+	return r.err
+}
+
+func (r *testAccountRepository) SearchAccountsByRoutingNumber(ctx context.Context, accountNumber, routingNumber, acctType string) (*accounts.Account, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
@@ -52,9 +57,22 @@ func (r *testAccountRepository) SearchAccountsByRoutingNumber(accountNumber, rou
 	return nil, nil
 }
 
-func (r *testAccountRepository) SearchAccountsByCustomerID(customerID string) ([]*accounts.Account, error) {
+func (r *testAccountRepository) SearchAccountsByCustomerID(ctx context.Context, customerID string) ([]*accounts.Account, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
 	return r.accounts, nil
 }
+
+func (r *testAccountRepository) ListAccounts(after string, limit int) ([]*accounts.Account, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	var out []*accounts.Account
+	for i := range r.accounts {
+		if r.accounts[i].ID > after && len(out) < limit {
+			out = append(out, r.accounts[i])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// accountWebhook is a customer's subscription to the events of one of their accounts.
+type accountWebhook struct {
+	ID         string `json:"id"`
+	AccountID  string `json:"accountId"`
+	CustomerID string `json:"customerId"`
+	URL        string `json:"url"`
+
+	// Secret signs each delivery. It's only returned when the subscription is created or the secret is rotated.
+	Secret string `json:"secret,omitempty"`
+
+	CreatedAt    time.Time `json:"createdAt"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+type accountWebhookRepository interface {
+	Ping() error
+	Close() error
+
+	createAccountWebhook(hook *accountWebhook) error
+	updateAccountWebhook(hook *accountWebhook) error
+	deleteAccountWebhook(accountID, subscriptionID string) error
+
+	// getAccountWebhook returns nil if the subscription doesn't exist on accountID.
+	getAccountWebhook(accountID, subscriptionID string) (*accountWebhook, error)
+	getAccountWebhooks(accountID string) ([]*accountWebhook, error)
+}
+
+type sqlAccountWebhookRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlAccountWebhookRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlAccountWebhookRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlAccountWebhookRepository) createAccountWebhook(hook *accountWebhook) error {
+	query := `insert into account_webhooks (subscription_id, account_id, customer_id, url, secret, created_at, last_modified) values (?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createAccountWebhook: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(hook.ID, hook.AccountID, hook.CustomerID, hook.URL, hook.Secret, hook.CreatedAt, hook.LastModified); err != nil {
+		return fmt.Errorf("createAccountWebhook: subscription=%s: %v", hook.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlAccountWebhookRepository) updateAccountWebhook(hook *accountWebhook) error {
+	query := `update account_webhooks set url = ?, secret = ?, last_modified = ? where subscription_id = ? and account_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("updateAccountWebhook: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(hook.URL, hook.Secret, hook.LastModified, hook.ID, hook.AccountID); err != nil {
+		return fmt.Errorf("updateAccountWebhook: subscription=%s: %v", hook.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlAccountWebhookRepository) deleteAccountWebhook(accountID, subscriptionID string) error {
+	query := `update account_webhooks set deleted_at = ? where subscription_id = ? and account_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("deleteAccountWebhook: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(time.Now(), subscriptionID, accountID); err != nil {
+		return fmt.Errorf("deleteAccountWebhook: subscription=%s: %v", subscriptionID, err)
+	}
+	return nil
+}
+
+func (r *sqlAccountWebhookRepository) getAccountWebhook(accountID, subscriptionID string) (*accountWebhook, error) {
+	hooks, err := r.queryAccountWebhooks(`subscription_id = ? and account_id = ?`, subscriptionID, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getAccountWebhook: %v", err)
+	}
+	if len(hooks) == 0 {
+		return nil, nil
+	}
+	return hooks[0], nil
+}
+
+func (r *sqlAccountWebhookRepository) getAccountWebhooks(accountID string) ([]*accountWebhook, error) {
+	hooks, err := r.queryAccountWebhooks(`account_id = ?`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getAccountWebhooks: %v", err)
+	}
+	return hooks, nil
+}
+
+func (r *sqlAccountWebhookRepository) queryAccountWebhooks(where string, args ...interface{}) ([]*accountWebhook, error) {
+	query := fmt.Sprintf(`select subscription_id, account_id, customer_id, url, secret, created_at, last_modified
+from account_webhooks where %s and deleted_at is null order by created_at;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*accountWebhook
+	for rows.Next() {
+		var hook accountWebhook
+		if err := rows.Scan(&hook.ID, &hook.AccountID, &hook.CustomerID, &hook.URL, &hook.Secret, &hook.CreatedAt, &hook.LastModified); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		out = append(out, &hook)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSqlAccountWebhookRepository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlAccountWebhookRepository) {
+		defer repo.Close()
+
+		now := time.Now().UTC().Truncate(time.Second)
+		hook := &accountWebhook{
+			ID:           base.ID(),
+			AccountID:    base.ID(),
+			CustomerID:   base.ID(),
+			URL:          "https://erp.example.com/webhook",
+			Secret:       createWebhookSecret(),
+			CreatedAt:    now,
+			LastModified: now,
+		}
+		if err := repo.createAccountWebhook(hook); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := repo.getAccountWebhook(hook.AccountID, hook.ID)
+		if err != nil || found == nil {
+			t.Fatalf("found=%#v error=%v", found, err)
+		}
+		if found.URL != hook.URL || found.Secret != hook.Secret || found.CustomerID != hook.CustomerID {
+			t.Errorf("unexpected subscription: %#v", found)
+		}
+		// subscriptions are scoped to their account
+		if found, err := repo.getAccountWebhook(base.ID(), hook.ID); err != nil || found != nil {
+			t.Errorf("found=%#v error=%v", found, err)
+		}
+
+		hook.URL = "https://erp.example.com/v2/webhook"
+		if err := repo.updateAccountWebhook(hook); err != nil {
+			t.Fatal(err)
+		}
+		hooks, err := repo.getAccountWebhooks(hook.AccountID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hooks) != 1 || hooks[0].URL != hook.URL {
+			t.Errorf("unexpected subscriptions: %#v", hooks)
+		}
+
+		if err := repo.deleteAccountWebhook(hook.AccountID, hook.ID); err != nil {
+			t.Fatal(err)
+		}
+		hooks, err = repo.getAccountWebhooks(hook.AccountID)
+		if err != nil || len(hooks) != 0 {
+			t.Errorf("subscriptions=%#v error=%v", hooks, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlAccountWebhookRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlAccountWebhookRepository{mysqlDB.DB, log.NewNopLogger()})
+}
@@ -0,0 +1,345 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func addAccountWebhookRoutes(logger log.Logger, router *mux.Router, accountRepo accountRepository, repo accountWebhookRepository) {
+	router.Methods("GET").Path("/accounts/{accountId}/webhooks").HandlerFunc(getAccountWebhooks(logger, accountRepo, repo))
+	router.Methods("POST").Path("/accounts/{accountId}/webhooks").HandlerFunc(createAccountWebhook(logger, accountRepo, repo))
+	router.Methods("GET").Path("/accounts/{accountId}/webhooks/{subscriptionId}").HandlerFunc(getAccountWebhook(logger, accountRepo, repo))
+	router.Methods("PUT").Path("/accounts/{accountId}/webhooks/{subscriptionId}").HandlerFunc(updateAccountWebhook(logger, accountRepo, repo))
+	router.Methods("DELETE").Path("/accounts/{accountId}/webhooks/{subscriptionId}").HandlerFunc(deleteAccountWebhook(logger, accountRepo, repo))
+}
+
+// customerAccount returns the account from the route when it belongs to the calling customer, which our auth
+// gateway sets in the X-Customer-ID header. Otherwise a 404 is written so other customers' account IDs aren't revealed.
+func customerAccount(w http.ResponseWriter, r *http.Request, accountRepo accountRepository) *accounts.Account {
+	accountID := getAccountID(w, r)
+	if accountID == "" {
+		return nil
+	}
+	customerID := r.Header.Get("X-Customer-ID")
+	if customerID == "" {
+		moovhttp.Problem(w, errors.New("missing X-Customer-ID header"))
+		return nil
+	}
+	accts, err := accountRepo.GetAccounts(r.Context(), []string{accountID})
+	if err != nil {
+		moovhttp.Problem(w, err)
+		return nil
+	}
+	if len(accts) != 1 || accts[0].CustomerID != customerID {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	return accts[0]
+}
+
+type accountWebhookRequest struct {
+	URL string `json:"url"`
+
+	// RotateSecret replaces the subscription's secret when updating it
+	RotateSecret bool `json:"rotateSecret"`
+}
+
+func (req accountWebhookRequest) validate() error {
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook url: %v", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return errors.New("webhook url must be an absolute https URL")
+	}
+	return nil
+}
+
+func createWebhookSecret() string {
+	bs := make([]byte, 32)
+	rand.Read(bs)
+	return hex.EncodeToString(bs)
+}
+
+// withoutSecret hides the secret of hook for responses other than create and rotate
+func withoutSecret(hook *accountWebhook) *accountWebhook {
+	out := *hook
+	out.Secret = ""
+	return &out
+}
+
+func getAccountWebhooks(logger log.Logger, accountRepo accountRepository, repo accountWebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		account := customerAccount(w, r, accountRepo)
+		if account == nil {
+			return
+		}
+
+		hooks, err := repo.getAccountWebhooks(account.ID)
+		if err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem reading account=%s webhooks: %v", account.ID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		out := make([]*accountWebhook, 0, len(hooks))
+		for i := range hooks {
+			out = append(out, withoutSecret(hooks[i]))
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func createAccountWebhook(logger log.Logger, accountRepo accountRepository, repo accountWebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		account := customerAccount(w, r, accountRepo)
+		if account == nil {
+			return
+		}
+
+		var req accountWebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := req.validate(); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		now := time.Now()
+		hook := &accountWebhook{
+			ID:           newID(),
+			AccountID:    account.ID,
+			CustomerID:   account.CustomerID,
+			URL:          req.URL,
+			Secret:       createWebhookSecret(),
+			CreatedAt:    now,
+			LastModified: now,
+		}
+		if err := repo.createAccountWebhook(hook); err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem creating account=%s webhook: %v", account.ID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("webhooks", fmt.Sprintf("created webhook subscription=%s for account=%s", hook.ID, account.ID), "requestID", moovhttp.GetRequestID(r))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(hook)
+	}
+}
+
+// readAccountWebhook returns the subscription from the route if it's on an account of the calling customer.
+func readAccountWebhook(w http.ResponseWriter, r *http.Request, accountRepo accountRepository, repo accountWebhookRepository) *accountWebhook {
+	account := customerAccount(w, r, accountRepo)
+	if account == nil {
+		return nil
+	}
+	hook, err := repo.getAccountWebhook(account.ID, mux.Vars(r)["subscriptionId"])
+	if err != nil {
+		moovhttp.Problem(w, err)
+		return nil
+	}
+	if hook == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	return hook
+}
+
+func getAccountWebhook(logger log.Logger, accountRepo accountRepository, repo accountWebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		hook := readAccountWebhook(w, r, accountRepo, repo)
+		if hook == nil {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(withoutSecret(hook))
+	}
+}
+
+func updateAccountWebhook(logger log.Logger, accountRepo accountRepository, repo accountWebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		hook := readAccountWebhook(w, r, accountRepo, repo)
+		if hook == nil {
+			return
+		}
+
+		var req accountWebhookRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if req.URL == "" {
+			req.URL = hook.URL
+		}
+		if err := req.validate(); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		hook.URL = req.URL
+		if req.RotateSecret {
+			hook.Secret = createWebhookSecret()
+		}
+		hook.LastModified = time.Now()
+		if err := repo.updateAccountWebhook(hook); err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem updating webhook subscription=%s: %v", hook.ID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if !req.RotateSecret {
+			hook = withoutSecret(hook)
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(hook)
+	}
+}
+
+func deleteAccountWebhook(logger log.Logger, accountRepo accountRepository, repo accountWebhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		hook := readAccountWebhook(w, r, accountRepo, repo)
+		if hook == nil {
+			return
+		}
+		if err := repo.deleteAccountWebhook(hook.AccountID, hook.ID); err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem deleting webhook subscription=%s: %v", hook.ID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// accountWebhookPublisher sends transaction events to the webhook subscriptions of each account in the transaction.
+// Subscribers only receive the transaction lines of their own account. Each delivery is signed with the subscription's
+// secret as a hex encoded HMAC-SHA256 of the body in the X-Webhook-Signature header.
+type accountWebhookPublisher struct {
+	logger   log.Logger
+	client   *http.Client
+	repo     accountWebhookRepository
+	encoding *eventEncoding
+}
+
+func newAccountWebhookPublisher(logger log.Logger, repo accountWebhookRepository, encoding *eventEncoding) *accountWebhookPublisher {
+	return &accountWebhookPublisher{
+		logger:   logger,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		repo:     repo,
+		encoding: encoding,
+	}
+}
+
+func (p *accountWebhookPublisher) publish(evt event) error {
+	tx, ok := evt.Data.(*transaction)
+	if !ok {
+		return nil
+	}
+	var errs []string
+	for _, accountID := range grabAccountIDs(tx.Lines) {
+		hooks, err := p.repo.getAccountWebhooks(accountID)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if len(hooks) == 0 {
+			continue
+		}
+
+		scoped := *tx
+		scoped.Lines = nil
+		for i := range tx.Lines {
+			if tx.Lines[i].AccountID == accountID {
+				scoped.Lines = append(scoped.Lines, tx.Lines[i])
+			}
+		}
+		bs, err := p.encoding.marshal(event{ID: evt.ID, Type: evt.Type, Timestamp: evt.Timestamp, Data: &scoped})
+		if err != nil {
+			return fmt.Errorf("publish: event=%s: %v", evt.ID, err)
+		}
+		for i := range hooks {
+			if err := p.deliver(hooks[i], bs); err != nil {
+				errs = append(errs, fmt.Sprintf("subscription=%s: %v", hooks[i].ID, err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("publish: event=%s: %s", evt.ID, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (p *accountWebhookPublisher) deliver(hook *accountWebhook, body []byte) error {
+	req, err := http.NewRequest("POST", hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", p.encoding.contentType)
+	req.Header.Set("X-Webhook-ID", hook.ID)
+	req.Header.Set("X-Webhook-Signature", signWebhook(hook.Secret, body))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestAccountWebhooks__routes(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlAccountWebhookRepository{db.DB, log.NewNopLogger()}
+
+	account := &accounts.Account{ID: base.ID(), CustomerID: "customer"}
+	router := mux.NewRouter()
+	addAccountWebhookRoutes(log.NewNopLogger(), router, &testAccountRepository{accounts: []*accounts.Account{account}}, repo)
+
+	do := func(method, path, customerID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", "test")
+		req.Header.Set("X-Customer-ID", customerID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	// other customers can't manage the account's subscriptions
+	if w := do("POST", "/accounts/"+account.ID+"/webhooks", "other", `{"url":"https://erp.example.com/hook"}`); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := do("POST", "/accounts/"+account.ID+"/webhooks", "customer", `{"url":"http://erp.example.com/hook"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected https to be required: %d", w.Code)
+	}
+
+	w := do("POST", "/accounts/"+account.ID+"/webhooks", "customer", `{"url":"https://erp.example.com/hook"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var created accountWebhook
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.AccountID != account.ID || len(created.Secret) != 64 {
+		t.Fatalf("unexpected subscription: %#v", created)
+	}
+
+	// the secret isn't returned again
+	w = do("GET", "/accounts/"+account.ID+"/webhooks", "customer", "")
+	var hooks []accountWebhook
+	if err := json.NewDecoder(w.Body).Decode(&hooks); err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 1 || hooks[0].ID != created.ID || hooks[0].Secret != "" {
+		t.Errorf("unexpected subscriptions: %#v", hooks)
+	}
+
+	w = do("PUT", "/accounts/"+account.ID+"/webhooks/"+created.ID, "customer", `{"rotateSecret":true}`)
+	var rotated accountWebhook
+	if err := json.NewDecoder(w.Body).Decode(&rotated); err != nil {
+		t.Fatal(err)
+	}
+	if rotated.URL != created.URL || rotated.Secret == "" || rotated.Secret == created.Secret {
+		t.Errorf("unexpected subscription: %#v", rotated)
+	}
+
+	if w := do("DELETE", "/accounts/"+account.ID+"/webhooks/"+created.ID, "customer", ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := do("GET", "/accounts/"+account.ID+"/webhooks/"+created.ID, "customer", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
+
+func TestAccountWebhookPublisher(t *testing.T) {
+	var received []*http.Request
+	var bodies [][]byte
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bs, _ := ioutil.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, bs)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlAccountWebhookRepository{db.DB, log.NewNopLogger()}
+
+	hook := &accountWebhook{
+		ID:           base.ID(),
+		AccountID:    base.ID(),
+		CustomerID:   "customer",
+		URL:          server.URL,
+		Secret:       createWebhookSecret(),
+		CreatedAt:    time.Now(),
+		LastModified: time.Now(),
+	}
+	if err := repo.createAccountWebhook(hook); err != nil {
+		t.Fatal(err)
+	}
+
+	publisher := newAccountWebhookPublisher(log.NewNopLogger(), repo, jsonEventEncoding)
+	publisher.client = server.Client()
+
+	tx := &transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now(),
+		Status:    TransactionPosted,
+		Lines: []transactionLine{
+			{AccountID: hook.AccountID, Purpose: ACHCredit, Amount: 100},
+			{AccountID: base.ID(), Purpose: ACHDebit, Amount: 100},
+		},
+	}
+	if err := publisher.publish(newEvent("transaction.posted", tx)); err != nil {
+		t.Fatal(err)
+	}
+	// events without a transaction are ignored
+	if err := publisher.publish(newEvent("transaction.rejected", rejectedCommand{Error: "bad"})); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(received) != 1 {
+		t.Fatalf("received %d deliveries", len(received))
+	}
+	if sig := received[0].Header.Get("X-Webhook-Signature"); sig != signWebhook(hook.Secret, bodies[0]) {
+		t.Errorf("unexpected signature %q", sig)
+	}
+	var evt struct {
+		Data transaction `json:"data"`
+	}
+	if err := json.Unmarshal(bodies[0], &evt); err != nil {
+		t.Fatal(err)
+	}
+	if evt.Data.ID != tx.ID || len(evt.Data.Lines) != 1 || evt.Data.Lines[0].AccountID != hook.AccountID {
+		t.Errorf("unexpected transaction: %#v", evt.Data)
+	}
+}
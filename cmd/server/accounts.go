@@ -5,6 +5,7 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/json"
 	"errors"
@@ -12,11 +13,11 @@ import (
 	"math/big"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
 	accounts "github.com/moov-io/accounts/client"
-	"github.com/moov-io/base"
 	moovhttp "github.com/moov-io/base/http"
 
 	"github.com/go-kit/kit/log"
@@ -25,12 +26,16 @@ import (
 
 var (
 	defaultRoutingNumber = os.Getenv("DEFAULT_ROUTING_NUMBER")
+
+	errAccountNotFound = errors.New("account not found")
+	errAccountExists   = errors.New("account number already exists for routing number")
 )
 
 func addAccountRoutes(logger log.Logger, r *mux.Router, accountRepo accountRepository, transactionRepo transactionRepository) {
 	r.Methods("GET").Path("/accounts/search").HandlerFunc(searchAccounts(logger, accountRepo))
 
 	r.Methods("POST").Path("/accounts").HandlerFunc(createAccount(logger, accountRepo, transactionRepo))
+	r.Methods("PATCH").Path("/accounts/{accountId}").HandlerFunc(updateAccount(logger, accountRepo))
 }
 
 // searchAccounts will attempt to find Accounts which match all query parameters. Searching with an account number will only
@@ -48,7 +53,7 @@ func searchAccounts(logger log.Logger, repo accountRepository) http.HandlerFunc
 		reqAcctNumber, reqRoutingNumber, reqAcctType := q.Get("number"), q.Get("routingNumber"), q.Get("type")
 		if reqAcctNumber != "" && reqRoutingNumber != "" && reqAcctType != "" {
 			// Grab and return accounts
-			account, err := repo.SearchAccountsByRoutingNumber(reqAcctNumber, reqRoutingNumber, reqAcctType)
+			account, err := repo.SearchAccountsByRoutingNumber(r.Context(), reqAcctNumber, reqRoutingNumber, reqAcctType)
 			if err != nil {
 				logger.Log("accounts", fmt.Sprintf("error searching accounts: %v", err), "requestID", moovhttp.GetRequestID(r))
 				moovhttp.Problem(w, fmt.Errorf("account not found, err=%v", err))
@@ -61,13 +66,13 @@ func searchAccounts(logger log.Logger, repo accountRepository) http.HandlerFunc
 			if account != nil {
 				accounts = append(accounts, account)
 			}
-			json.NewEncoder(w).Encode(accounts)
+			json.NewEncoder(w).Encode(formatAccounts(r, accounts))
 			return
 		}
 
 		// Search based on CustomerId
 		if customerID := or(q.Get("customerId"), q.Get("customerID")); customerID != "" {
-			accounts, err := repo.SearchAccountsByCustomerID(customerID)
+			accounts, err := repo.SearchAccountsByCustomerID(r.Context(), customerID)
 			if err != nil {
 				logger.Log("accounts", fmt.Sprintf("error getting customer accounts: %v", err), "requestID", moovhttp.GetRequestID(r))
 				moovhttp.Problem(w, fmt.Errorf("account not found, err=%v", err))
@@ -75,7 +80,7 @@ func searchAccounts(logger log.Logger, repo accountRepository) http.HandlerFunc
 			}
 			w.Header().Set("Content-Type", "application/json; charset=utf-8")
 			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(accounts)
+			json.NewEncoder(w).Encode(formatAccounts(r, accounts))
 		} else {
 			// Error if we didn't quit early from query params
 			moovhttp.Problem(w, errors.New("missing account search query parameters"))
@@ -83,12 +88,48 @@ func searchAccounts(logger log.Logger, repo accountRepository) http.HandlerFunc
 	}
 }
 
+// AccountType is the product type of an account.
+type AccountType string
+
+var (
+	Checking AccountType = "checking"
+	Savings  AccountType = "savings"
+	FBO      AccountType = "fbo" // for benefit of, holding funds owned by someone else
+	Loan     AccountType = "loan"
+	Rewards  AccountType = "rewards" // cash-back owed to the customer, see rewards.go
+
+	// System accounts are owned by the bank (fees revenue, suspense, etc) and only created on startup, so they're
+	// not a type customers can request. See system_accounts.go
+	System AccountType = "system"
+)
+
+func (t *AccountType) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	*t = AccountType(strings.ToLower(s))
+	if err := t.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t AccountType) validate() error {
+	switch t {
+	case Checking, Savings, FBO, Loan, Rewards:
+		return nil
+	default:
+		return fmt.Errorf("unknown AccountType %q", t)
+	}
+}
+
 type createAccountRequest struct {
-	CustomerID string `json:"customerId"`
-	Balance    int    `json:"balance"`
-	Name       string `json:"name"`
-	Number     string `json:"number"`
-	Type       string `json:"type"`
+	CustomerID string      `json:"customerId"`
+	Balance    int         `json:"balance"`
+	Name       string      `json:"name"`
+	Number     string      `json:"number"`
+	Type       AccountType `json:"type"`
 }
 
 func (r createAccountRequest) validate() error {
@@ -101,11 +142,8 @@ func (r createAccountRequest) validate() error {
 	if r.Name == "" {
 		return errors.New("createAccountRequest: missing Name")
 	}
-	r.Type = strings.ToLower(r.Type)
-	switch r.Type {
-	case "checking", "savings":
-	default:
-		return fmt.Errorf("createAccountRequest: unknown Type: %q", r.Type)
+	if err := AccountType(strings.ToLower(string(r.Type))).validate(); err != nil {
+		return fmt.Errorf("createAccountRequest: %v", err)
 	}
 	return nil
 }
@@ -123,7 +161,7 @@ func createAccount(logger log.Logger, accountRepo accountRepository, transaction
 		}
 		requestID := moovhttp.GetRequestID(r)
 		if requestID == "" {
-			requestID = base.ID()
+			requestID = newID()
 		}
 
 		var req createAccountRequest
@@ -139,28 +177,37 @@ func createAccount(logger log.Logger, accountRepo accountRepository, transaction
 		}
 
 		now := time.Now()
+		organization, _ := organizationFrom(r.Context())
 		account := &accounts.Account{
-			ID:            base.ID(),
-			CustomerID:    req.CustomerID,
-			Name:          req.Name,
-			AccountNumber: req.Number,
-			RoutingNumber: defaultRoutingNumber,
-			Status:        "open",
-			Type:          req.Type,
-			CreatedAt:     now,
-			LastModified:  now,
+			ID:             newID(),
+			CustomerID:     req.CustomerID,
+			OrganizationID: organization,
+			Name:           req.Name,
+			AccountNumber:  req.Number,
+			RoutingNumber:  defaultRoutingNumber,
+			Status:         string(accountStatusOpen),
+			Type:           string(req.Type),
+			CreatedAt:      now,
+			LastModified:   now,
 		}
 		// We need to generate a unique account number for this routing number. Right now
 		// this involves network calls, but I hope to improve this to something like twitter
-		// snowflake or UUID -> number conversion.
-		if number, err := generateAccountNumber(account, accountRepo); number != "" {
-			account.AccountNumber = number
-		} else {
-			logger.Log("accounts", fmt.Sprintf("error creating account number: %v", err), "requestID", requestID)
-			moovhttp.Problem(w, err)
-			return
+		// snowflake or UUID -> number conversion. Requested numbers are left to the unique
+		// constraint on (account_number, routing_number) so retries can be detected.
+		if account.AccountNumber == "" {
+			if number, err := generateAccountNumber(account, accountRepo); number != "" {
+				account.AccountNumber = number
+			} else {
+				logger.Log("accounts", fmt.Sprintf("error creating account number: %v", err), "requestID", requestID)
+				moovhttp.Problem(w, err)
+				return
+			}
 		}
 		if err := accountRepo.CreateAccount(req.CustomerID, account); err != nil {
+			if err == errAccountExists {
+				existingAccount(logger, w, r, accountRepo, req, account)
+				return
+			}
 			logger.Log("accounts", fmt.Sprintf("error creating account: %v", err), "requestID", requestID)
 			moovhttp.Problem(w, err)
 			return
@@ -168,6 +215,7 @@ func createAccount(logger log.Logger, accountRepo accountRepository, transaction
 
 		// Submit a transaction of the initial amount (where does the exteranl ABA come from)?
 		tx := (&createTransactionRequest{
+			Description: "Initial deposit",
 			Lines: []transactionLine{
 				{
 					AccountID: account.ID,
@@ -175,8 +223,8 @@ func createAccount(logger log.Logger, accountRepo accountRepository, transaction
 					Amount:    req.Balance,
 				},
 			},
-		}).asTransaction(base.ID())
-		if err := transactionRepo.createTransaction(tx, createTransactionOpts{InitialDeposit: true}); err != nil {
+		}).asTransaction(newID())
+		if err := transactionRepo.createTransaction(r.Context(), tx, createTransactionOpts{InitialDeposit: true}); err != nil {
 			logger.Log("accounts", fmt.Errorf("problem creating initial balance transaction: %v", err), "requestID", requestID)
 			moovhttp.Problem(w, err)
 			return
@@ -184,19 +232,166 @@ func createAccount(logger log.Logger, accountRepo accountRepository, transaction
 
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(account)
+		json.NewEncoder(w).Encode(formatAccount(r, account))
 	}
 }
 
+// existingAccount responds to a request creating an account whose number is already used with the routing number.
+// With ?allowExisting=true the existing account is returned when the request could have created it (same customer,
+// type and organization) so clients can safely retry creating an account. Otherwise it's a 409 Conflict.
+func existingAccount(logger log.Logger, w http.ResponseWriter, r *http.Request, repo accountRepository, req createAccountRequest, account *accounts.Account) {
+	requestID := moovhttp.GetRequestID(r)
+	if allow, _ := strconv.ParseBool(r.URL.Query().Get("allowExisting")); allow {
+		existing, err := repo.SearchAccountsByRoutingNumber(r.Context(), account.AccountNumber, account.RoutingNumber, account.Type)
+		if err != nil {
+			logger.Log("accounts", fmt.Sprintf("error reading existing account: %v", err), "requestID", requestID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if existing != nil && existing.CustomerID == req.CustomerID {
+			logger.Log("accounts", fmt.Sprintf("returning existing account=%s", existing.ID), "requestID", requestID)
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(formatAccount(r, existing))
+			return
+		}
+	}
+	logger.Log("accounts", fmt.Sprintf("rejected account number already used with routing number %s", account.RoutingNumber), "requestID", requestID)
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":         errAccountExists.Error(),
+		"code":          "account_exists",
+		"accountNumber": account.AccountNumber,
+		"routingNumber": account.RoutingNumber,
+	})
+}
+
 func generateAccountNumber(account *accounts.Account, repo accountRepository) (string, error) {
 	number := account.AccountNumber
 	if number == "" {
 		number = createAccountNumber()
 	}
 	for i := 0; i < 10; i++ {
-		if acct, _ := repo.SearchAccountsByRoutingNumber(number, account.RoutingNumber, account.Type); acct == nil {
+		// Account numbers are unique at the bank, so check every organization's accounts
+		if acct, _ := repo.SearchAccountsByRoutingNumber(context.Background(), number, account.RoutingNumber, account.Type); acct == nil {
 			return number, nil
 		}
 	}
 	return "", fmt.Errorf("unable to generate account number for account=%s", account.ID)
 }
+
+// updateAccountRequest holds the fields of an account which can be changed after it's created. Fields which are
+// left out are unchanged.
+type updateAccountRequest struct {
+	Name   *string        `json:"name"`
+	Status *AccountStatus `json:"status"`
+	Type   *AccountType   `json:"type"`
+
+	// OverdraftLimit is how far in USD cents the account's balance can go negative
+	OverdraftLimit *int32 `json:"overdraftLimit"`
+}
+
+// apply validates and applies the request to acct, returning true if anything changed. Accounts can be renamed,
+// change type, have their overdraft limit set, be frozen, suspended or reopened, and be closed once their balance is
+// zero. Closed accounts can't be changed or reopened.
+func (req updateAccountRequest) apply(acct *accounts.Account, now time.Time) (bool, error) {
+	if accountClosed(acct) {
+		return false, fmt.Errorf("account=%s: %v", acct.ID, errAccountClosed)
+	}
+	changed := false
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			return false, errors.New("updateAccountRequest: missing Name")
+		}
+		changed = changed || name != acct.Name
+		acct.Name = name
+	}
+	if req.Type != nil {
+		if err := req.Type.validate(); err != nil {
+			return false, fmt.Errorf("updateAccountRequest: %v", err)
+		}
+		changed = changed || !strings.EqualFold(string(*req.Type), acct.Type)
+		acct.Type = string(*req.Type)
+	}
+	if req.OverdraftLimit != nil {
+		if *req.OverdraftLimit < 0 {
+			return false, fmt.Errorf("updateAccountRequest: invalid overdraft limit %d USD cents", *req.OverdraftLimit)
+		}
+		changed = changed || *req.OverdraftLimit != acct.OverdraftLimit
+		acct.OverdraftLimit = *req.OverdraftLimit
+	}
+	if req.Status != nil {
+		switch status := *req.Status; status {
+		case accountStatusOpen, accountStatusFrozen, accountStatusSuspended:
+			changed = changed || !strings.EqualFold(string(status), acct.Status)
+			acct.Status = string(status)
+		case accountStatusClosed:
+			if err := checkClosable(acct); err != nil {
+				return false, err
+			}
+			acct.Status, acct.ClosedAt = string(status), now
+			changed = true
+		default:
+			return false, fmt.Errorf("updateAccountRequest: %v", status.validate())
+		}
+	}
+	if changed {
+		acct.LastModified = now
+	}
+	return changed, nil
+}
+
+func updateAccount(logger log.Logger, accountRepo accountRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		accountID := getAccountID(w, r)
+		if accountID == "" {
+			return
+		}
+		requestID := moovhttp.GetRequestID(r)
+
+		var req updateAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			logger.Log("accounts", fmt.Sprintf("error reading JSON request: %v", err), "requestID", requestID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		accts, err := accountRepo.GetAccounts(r.Context(), []string{accountID})
+		if err != nil {
+			logger.Log("accounts", fmt.Sprintf("problem reading account=%s: %v", accountID, err), "requestID", requestID)
+			moovhttp.Problem(w, err)
+			return
+		}
+		if len(accts) != 1 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		account := accts[0]
+		changed, err := req.apply(account, time.Now())
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if changed {
+			if err := accountRepo.UpdateAccount(account); err != nil {
+				if err == errAccountNotFound {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				logger.Log("accounts", fmt.Sprintf("problem updating account=%s: %v", accountID, err), "requestID", requestID)
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("accounts", fmt.Sprintf("updated account=%s", accountID), "requestID", requestID, "userID", moovhttp.GetUserID(r))
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(formatAccount(r, account))
+	}
+}
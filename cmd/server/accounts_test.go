@@ -5,11 +5,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	accounts "github.com/moov-io/accounts/client"
 	"github.com/moov-io/base"
@@ -60,10 +63,24 @@ func TestAccounts__createAccountRequest(t *testing.T) {
 		t.Error(err)
 	}
 
+	for _, acctType := range []AccountType{FBO, Loan} {
+		req.Type = acctType
+		if err := req.validate(); err != nil {
+			t.Error(err)
+		}
+	}
+
 	req.Type = "other" // invalid
 	if err := req.validate(); err == nil {
 		t.Error("expected error")
 	}
+
+	if err := json.Unmarshal([]byte(`{"type": "brokerage"}`), &req); err == nil || !strings.Contains(err.Error(), `unknown AccountType "brokerage"`) {
+		t.Errorf("expected error: %v", err)
+	}
+	if err := json.Unmarshal([]byte(`{"type": "Loan"}`), &req); err != nil || req.Type != Loan {
+		t.Errorf("type=%q error=%v", req.Type, err)
+	}
 }
 
 func TestAccounts__CreateAccount(t *testing.T) {
@@ -94,6 +111,59 @@ func TestAccounts__CreateAccount(t *testing.T) {
 	}
 }
 
+func TestAccounts__CreateAccountExists(t *testing.T) {
+	accountRepo, transactionRepo := newInMemoryRepositories()
+	router := mux.NewRouter()
+	addAccountRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo)
+	create := func(path, customerID, organization string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"customerId": %q, "balance": 1000, "name": "Money", "number": "12345", "type": "checking"}`, customerID)
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("x-user-id", "test")
+		req = req.WithContext(withOrganization(req.Context(), organization))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	w := create("/accounts", "customer", "acme")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus status code: %d: %s", w.Code, w.Body.String())
+	}
+	var acct accounts.Account
+	if err := json.NewDecoder(w.Body).Decode(&acct); err != nil {
+		t.Fatal(err)
+	}
+
+	w = create("/accounts", "customer", "acme")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("bogus status code: %d: %s", w.Code, w.Body.String())
+	}
+	var problem map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil || problem["code"] != "account_exists" || problem["accountNumber"] != "12345" {
+		t.Errorf("problem=%#v error=%v", problem, err)
+	}
+
+	// retries return the existing account without another initial deposit
+	w = create("/accounts?allowExisting=true", "customer", "acme")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus status code: %d: %s", w.Code, w.Body.String())
+	}
+	var existing accounts.Account
+	if err := json.NewDecoder(w.Body).Decode(&existing); err != nil || existing.ID != acct.ID {
+		t.Errorf("account=%#v error=%v", existing, err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{acct.ID: 1000})
+
+	// other customers and organizations can't read the existing account
+	if w := create("/accounts?allowExisting=true", "other", "acme"); w.Code != http.StatusConflict {
+		t.Errorf("bogus status code: %d", w.Code)
+	}
+	if w := create("/accounts?allowExisting=true", "customer", "other"); w.Code != http.StatusConflict {
+		t.Errorf("bogus status code: %d", w.Code)
+	}
+}
+
 func TestAccounts__GetCustomerAccounts(t *testing.T) {
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest("GET", "/accounts/search?customerId=customerID", nil)
@@ -149,6 +219,86 @@ func TestAccounts__SearchAccounts(t *testing.T) {
 	}
 }
 
+func TestAccounts__UpdateAccount(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"savings": 1000, "empty": 0, "other": 0})
+
+	router := mux.NewRouter()
+	addAccountRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo)
+	patch := func(accountID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("PATCH", "/accounts/"+accountID, strings.NewReader(body))
+		req.Header.Set("x-user-id", "test")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+	read := func(w *httptest.ResponseRecorder) *accounts.Account {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus status code: %d: %s", w.Code, w.Body.String())
+		}
+		var acct accounts.Account
+		if err := json.NewDecoder(w.Body).Decode(&acct); err != nil {
+			t.Fatal(err)
+		}
+		return &acct
+	}
+
+	acct := read(patch("savings", `{"name": " Rainy Day ", "type": "Savings"}`))
+	if acct.Name != "Rainy Day" || acct.Type != "savings" || acct.Balance != 1000 || acct.LastModified.IsZero() {
+		t.Errorf("unexpected account: %#v", acct)
+	}
+	for _, body := range []string{`{"name": ""}`, `{"type": "brokerage"}`, `{"status": "dormant"}`, `{"status": "closed"}`} {
+		if w := patch("savings", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: bogus status code: %d", body, w.Code)
+		}
+	}
+	// accounts can be frozen and reopened
+	if acct = read(patch("savings", `{"status": "Frozen"}`)); acct.Status != "frozen" {
+		t.Errorf("unexpected account: %#v", acct)
+	}
+	if acct = read(patch("savings", `{"status": "open"}`)); acct.Status != "open" {
+		t.Errorf("unexpected account: %#v", acct)
+	}
+
+	// accounts with an overdraft limit can be overdrawn by up to it
+	if acct = read(patch("savings", `{"overdraftLimit": 500}`)); acct.OverdraftLimit != 500 {
+		t.Errorf("unexpected account: %#v", acct)
+	}
+	if w := patch("savings", `{"overdraftLimit": -1}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus status code: %d", w.Code)
+	}
+	withdraw := func(amount int) error {
+		tx := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+			{AccountID: "savings", Purpose: ACHDebit, Amount: amount},
+			{AccountID: "other", Purpose: ACHCredit, Amount: amount},
+		}}
+		return transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{})
+	}
+	if err := withdraw(1500); err != nil {
+		t.Fatal(err)
+	}
+	if err := withdraw(1); err == nil {
+		t.Error("expected insufficient funds")
+	}
+	checkBalances(t, accountRepo, map[string]int32{"savings": -500, "other": 1500})
+
+	// only accounts without a balance can be closed
+	acct = read(patch("empty", `{"status": "Closed"}`))
+	if acct.Status != "closed" || acct.ClosedAt.IsZero() {
+		t.Errorf("unexpected account: %#v", acct)
+	}
+	// closed accounts can't be reopened or changed
+	for _, body := range []string{`{"status": "open"}`, `{"name": "Other"}`} {
+		if w := patch("empty", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: bogus status code: %d", body, w.Code)
+		}
+	}
+	if w := patch("missing", `{"name": "Other"}`); w.Code != http.StatusNotFound {
+		t.Errorf("bogus status code: %d", w.Code)
+	}
+}
+
 func TestAccounts__generateAccountNumber(t *testing.T) {
 	repo := &testAccountRepository{}
 	id, err := generateAccountNumber(&accounts.Account{}, repo)
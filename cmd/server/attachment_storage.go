@@ -0,0 +1,198 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// attachmentType is the kind of supporting document an attachment references.
+type attachmentType string
+
+const (
+	attachmentCheckImage attachmentType = "checkImage"
+	attachmentInvoice    attachmentType = "invoice"
+	attachmentReceipt    attachmentType = "receipt"
+	attachmentOther      attachmentType = "other"
+)
+
+// attachment is a reference to a supporting document of a transaction, such as a check image. Documents are
+// stored elsewhere (e.g. in object storage) and we only keep where to find them and a hash of their contents.
+type attachment struct {
+	ID            string         `json:"id"`
+	TransactionID string         `json:"transactionId"`
+	Type          attachmentType `json:"type"`
+
+	// URL or ObjectKey locate the document, at least one is required
+	URL       string `json:"url,omitempty"`
+	ObjectKey string `json:"objectKey,omitempty"`
+
+	// ContentHash is the hex encoded SHA-256 hash of the document
+	ContentHash string `json:"contentHash"`
+	ContentType string `json:"contentType,omitempty"`
+	Description string `json:"description,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+
+	// AnonymizedAt is when URL, ObjectKey and Description were cleared because the attachment outlived
+	// TRANSACTION_PII_RETENTION_YEARS (see transaction_anonymization.go)
+	AnonymizedAt *time.Time `json:"anonymizedAt,omitempty"`
+}
+
+func (a attachment) validate() error {
+	switch a.Type {
+	case attachmentCheckImage, attachmentInvoice, attachmentReceipt, attachmentOther:
+	default:
+		return fmt.Errorf("attachment: unknown type %q", a.Type)
+	}
+	if a.URL == "" && a.ObjectKey == "" {
+		return errors.New("attachment: url or objectKey is required")
+	}
+	if len(a.ContentHash) != 64 || strings.Trim(strings.ToLower(a.ContentHash), "0123456789abcdef") != "" {
+		return errors.New("attachment: contentHash must be a hex encoded SHA-256 hash")
+	}
+	return nil
+}
+
+type attachmentRepository interface {
+	Ping() error
+	Close() error
+
+	createAttachment(a *attachment) error
+	deleteAttachment(transactionID, attachmentID string) error
+
+	// getAttachments returns the attachments of each transaction, oldest first.
+	getAttachments(transactionIDs []string) ([]*attachment, error)
+
+	// getIdentifiableAttachments returns attachments created before the cutoff which haven't been anonymized.
+	getIdentifiableAttachments(before time.Time) ([]*attachment, error)
+
+	// anonymizeAttachments clears the URL, object key and description of each attachment, returning how many
+	// were changed.
+	anonymizeAttachments(attachmentIDs []string, now time.Time) (int64, error)
+}
+
+type sqlAttachmentRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlAttachmentRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlAttachmentRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlAttachmentRepository) createAttachment(a *attachment) error {
+	query := `insert into transaction_attachments (attachment_id, transaction_id, type, url, object_key, content_hash, content_type, description, created_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createAttachment: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(a.ID, a.TransactionID, a.Type, a.URL, a.ObjectKey, a.ContentHash, a.ContentType, a.Description, a.CreatedAt); err != nil {
+		return fmt.Errorf("createAttachment: attachment=%s: %v", a.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlAttachmentRepository) deleteAttachment(transactionID, attachmentID string) error {
+	query := `update transaction_attachments set deleted_at = ? where attachment_id = ? and transaction_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("deleteAttachment: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(time.Now(), attachmentID, transactionID)
+	if err != nil {
+		return fmt.Errorf("deleteAttachment: attachment=%s: %v", attachmentID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("deleteAttachment: attachment=%s not found on transaction=%s", attachmentID, transactionID)
+	}
+	return nil
+}
+
+func (r *sqlAttachmentRepository) getAttachments(transactionIDs []string) ([]*attachment, error) {
+	if len(transactionIDs) == 0 {
+		return nil, nil
+	}
+	where := fmt.Sprintf(`transaction_id in (?%s) and deleted_at is null`, strings.Repeat(",?", len(transactionIDs)-1))
+	args := make([]interface{}, len(transactionIDs))
+	for i := range transactionIDs {
+		args[i] = transactionIDs[i]
+	}
+	out, err := r.queryAttachments(where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getAttachments: %v", err)
+	}
+	return out, nil
+}
+
+func (r *sqlAttachmentRepository) getIdentifiableAttachments(before time.Time) ([]*attachment, error) {
+	// Deleted attachments are included as their rows still hold the details
+	out, err := r.queryAttachments(`created_at < ? and anonymized_at is null`, before)
+	if err != nil {
+		return nil, fmt.Errorf("getIdentifiableAttachments: %v", err)
+	}
+	return out, nil
+}
+
+func (r *sqlAttachmentRepository) queryAttachments(where string, args ...interface{}) ([]*attachment, error) {
+	query := fmt.Sprintf(`select attachment_id, transaction_id, type, url, object_key, content_hash, content_type, description, created_at, anonymized_at
+from transaction_attachments where %s order by created_at;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*attachment
+	for rows.Next() {
+		var a attachment
+		if err := rows.Scan(&a.ID, &a.TransactionID, &a.Type, &a.URL, &a.ObjectKey, &a.ContentHash, &a.ContentType, &a.Description, &a.CreatedAt, &a.AnonymizedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		out = append(out, &a)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlAttachmentRepository) anonymizeAttachments(attachmentIDs []string, now time.Time) (int64, error) {
+	query := `update transaction_attachments set url = '', object_key = '', description = '', anonymized_at = ? where attachment_id = ? and anonymized_at is null;`
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("anonymizeAttachments: %v", err)
+	}
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return 0, fmt.Errorf("anonymizeAttachments: prepare: error=%v rollback=%v", err, tx.Rollback())
+	}
+	defer stmt.Close()
+
+	var n int64
+	for i := range attachmentIDs {
+		res, err := stmt.Exec(now, attachmentIDs[i])
+		if err != nil {
+			return 0, fmt.Errorf("anonymizeAttachments: attachment=%s: error=%v rollback=%v", attachmentIDs[i], err, tx.Rollback())
+		}
+		affected, _ := res.RowsAffected()
+		n += affected
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("anonymizeAttachments: commit: %v", err)
+	}
+	return n, nil
+}
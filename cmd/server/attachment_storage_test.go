@@ -0,0 +1,121 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestAttachment__validate(t *testing.T) {
+	a := attachment{
+		Type:        attachmentCheckImage,
+		ObjectKey:   "checks/front.png",
+		ContentHash: strings.Repeat("ab", 32),
+	}
+	if err := a.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := a
+	bad.Type = "photo"
+	if err := bad.validate(); err == nil {
+		t.Error("expected error")
+	}
+	bad = a
+	bad.ObjectKey = ""
+	if err := bad.validate(); err == nil {
+		t.Error("expected error")
+	}
+	bad = a
+	bad.ContentHash = strings.Repeat("zz", 32)
+	if err := bad.validate(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSqlAttachmentRepository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlAttachmentRepository) {
+		defer repo.Close()
+
+		now := time.Now().UTC().Truncate(time.Second)
+		front := &attachment{
+			ID:            base.ID(),
+			TransactionID: base.ID(),
+			Type:          attachmentCheckImage,
+			ObjectKey:     "checks/front.png",
+			ContentHash:   strings.Repeat("ab", 32),
+			ContentType:   "image/png",
+			CreatedAt:     now.Add(-1 * time.Minute),
+		}
+		invoice := &attachment{
+			ID:            base.ID(),
+			TransactionID: base.ID(),
+			Type:          attachmentInvoice,
+			URL:           "https://erp.example.com/invoices/123.pdf",
+			ContentHash:   strings.Repeat("cd", 32),
+			CreatedAt:     now,
+		}
+		for _, a := range []*attachment{front, invoice} {
+			if err := repo.createAttachment(a); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		attachments, err := repo.getAttachments([]string{front.TransactionID, invoice.TransactionID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(attachments) != 2 || attachments[0].ID != front.ID || attachments[0].ObjectKey != front.ObjectKey || attachments[1].URL != invoice.URL {
+			t.Errorf("unexpected attachments: %#v", attachments)
+		}
+
+		if err := repo.deleteAttachment(invoice.TransactionID, front.ID); err == nil {
+			t.Error("expected error deleting another transaction's attachment")
+		}
+		if err := repo.deleteAttachment(front.TransactionID, front.ID); err != nil {
+			t.Fatal(err)
+		}
+		attachments, err = repo.getAttachments([]string{front.TransactionID})
+		if err != nil || len(attachments) != 0 {
+			t.Errorf("attachments=%#v error=%v", attachments, err)
+		}
+
+		// deleted attachments are still anonymized as their rows keep the details
+		old, err := repo.getIdentifiableAttachments(now)
+		if err != nil || len(old) != 1 || old[0].ID != front.ID {
+			t.Fatalf("attachments=%#v error=%v", old, err)
+		}
+		if n, err := repo.anonymizeAttachments([]string{front.ID, invoice.ID}, now); err != nil || n != 2 {
+			t.Errorf("anonymized=%d error=%v", n, err)
+		}
+		if n, err := repo.anonymizeAttachments([]string{invoice.ID}, now); err != nil || n != 0 {
+			t.Errorf("anonymized=%d error=%v", n, err)
+		}
+		attachments, err = repo.getAttachments([]string{invoice.TransactionID})
+		if err != nil || len(attachments) != 1 || attachments[0].URL != "" || attachments[0].AnonymizedAt == nil || attachments[0].ContentHash != invoice.ContentHash {
+			t.Errorf("attachments=%#v error=%v", attachments, err)
+		}
+		if old, err := repo.getIdentifiableAttachments(now.Add(time.Hour)); err != nil || len(old) != 0 {
+			t.Errorf("attachments=%#v error=%v", old, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlAttachmentRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlAttachmentRepository{mysqlDB.DB, log.NewNopLogger()})
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+var errNoAttachments = errors.New("transaction attachments are not configured")
+
+func addAttachmentRoutes(logger log.Logger, router *mux.Router, svc *transactionService) {
+	router.Methods("GET").Path("/accounts/transactions/{transactionID}/attachments").HandlerFunc(getAttachments(logger, svc))
+	router.Methods("POST").Path("/accounts/transactions/{transactionID}/attachments").HandlerFunc(createAttachment(logger, svc))
+	router.Methods("DELETE").Path("/accounts/transactions/{transactionID}/attachments/{attachmentId}").HandlerFunc(deleteAttachment(logger, svc))
+}
+
+// expandAttachments returns true when the caller asked for attachments with ?expand=attachments
+func expandAttachments(r *http.Request) bool {
+	for _, v := range strings.Split(r.URL.Query().Get("expand"), ",") {
+		if strings.EqualFold(strings.TrimSpace(v), "attachments") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *transactionService) GetAttachments(ctx context.Context, transactionID string) ([]*attachment, error) {
+	if s.attachments == nil {
+		return nil, errNoAttachments
+	}
+	if _, err := s.GetTransaction(ctx, transactionID); err != nil {
+		return nil, err
+	}
+	return s.attachments.getAttachments([]string{transactionID})
+}
+
+func (s *transactionService) CreateAttachment(ctx context.Context, transactionID string, a attachment) (*attachment, error) {
+	if s.attachments == nil {
+		return nil, errNoAttachments
+	}
+	if _, err := s.GetTransaction(ctx, transactionID); err != nil {
+		return nil, err
+	}
+	a.ID = newID()
+	a.TransactionID = transactionID
+	a.ContentHash = strings.ToLower(a.ContentHash)
+	a.CreatedAt = time.Now()
+	if err := a.validate(); err != nil {
+		return nil, err
+	}
+	if err := s.attachments.createAttachment(&a); err != nil {
+		return nil, err
+	}
+	s.logger.Log("transactions", fmt.Sprintf("added %s attachment=%s to transaction=%s", a.Type, a.ID, transactionID), "requestID", requestIDFrom(ctx))
+	return &a, nil
+}
+
+func (s *transactionService) DeleteAttachment(ctx context.Context, transactionID, attachmentID string) error {
+	if s.attachments == nil {
+		return errNoAttachments
+	}
+	if _, err := s.GetTransaction(ctx, transactionID); err != nil {
+		return err
+	}
+	return s.attachments.deleteAttachment(transactionID, attachmentID)
+}
+
+// ExpandAttachments fills in the Attachments of each transaction.
+func (s *transactionService) ExpandAttachments(ctx context.Context, transactions []*transaction) error {
+	if s.attachments == nil {
+		return errNoAttachments
+	}
+	var transactionIDs []string
+	for i := range transactions {
+		transactions[i].Attachments = nil
+		transactionIDs = append(transactionIDs, transactions[i].ID)
+	}
+	attachments, err := s.attachments.getAttachments(transactionIDs)
+	if err != nil {
+		return err
+	}
+	for i := range attachments {
+		for j := range transactions {
+			if transactions[j].ID == attachments[i].TransactionID {
+				transactions[j].Attachments = append(transactions[j].Attachments, attachments[i])
+			}
+		}
+	}
+	return nil
+}
+
+func getAttachments(logger log.Logger, svc *transactionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		transactionID := getTransactionID(w, r)
+		if transactionID == "" {
+			return
+		}
+
+		attachments, err := svc.GetAttachments(requestContext(r), transactionID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if attachments == nil {
+			attachments = []*attachment{}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(attachments)
+	}
+}
+
+func createAttachment(logger log.Logger, svc *transactionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		transactionID := getTransactionID(w, r)
+		if transactionID == "" {
+			return
+		}
+
+		var req attachment
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		a, err := svc.CreateAttachment(requestContext(r), transactionID, req)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(a)
+	}
+}
+
+func deleteAttachment(logger log.Logger, svc *transactionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		transactionID := getTransactionID(w, r)
+		if transactionID == "" {
+			return
+		}
+
+		if err := svc.DeleteAttachment(requestContext(r), transactionID, mux.Vars(r)["attachmentId"]); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestAttachments__routes(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	attachmentRepo := &sqlAttachmentRepository{db.DB, log.NewNopLogger()}
+
+	accountID := base.ID()
+	transactionRepo := &mockTransactionRepository{
+		transactions: []transaction{
+			{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Status:    TransactionPosted,
+				Lines: []transactionLine{
+					{AccountID: accountID, Purpose: ACHDebit, Amount: 1000},
+					{AccountID: base.ID(), Purpose: ACHCredit, Amount: 1000},
+				},
+			},
+		},
+	}
+	transactionID := transactionRepo.transactions[0].ID
+
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, &testAccountRepository{}, transactionRepo, attachmentRepo, &loggingEventPublisher{log.NewNopLogger()}, nil, time.Now)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", base.ID())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	path := fmt.Sprintf("/accounts/transactions/%s/attachments", transactionID)
+	if w := do("POST", path, `{"type": "checkImage", "contentHash": "abc"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	body := fmt.Sprintf(`{"type": "checkImage", "objectKey": "checks/front.png", "contentHash": %q, "contentType": "image/png"}`, strings.Repeat("AB", 32))
+	w := do("POST", path, body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var created attachment
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.TransactionID != transactionID || created.ContentHash != strings.Repeat("ab", 32) {
+		t.Errorf("unexpected attachment: %#v", created)
+	}
+
+	// attachments are only included when expanded
+	var tx transaction
+	json.NewDecoder(do("GET", "/accounts/transactions/"+transactionID, "").Body).Decode(&tx)
+	if len(tx.Attachments) != 0 {
+		t.Errorf("unexpected attachments: %#v", tx.Attachments)
+	}
+	json.NewDecoder(do("GET", "/accounts/transactions/"+transactionID+"?expand=attachments", "").Body).Decode(&tx)
+	if len(tx.Attachments) != 1 || tx.Attachments[0].ID != created.ID {
+		t.Errorf("unexpected attachments: %#v", tx.Attachments)
+	}
+	var page []transaction
+	json.NewDecoder(do("GET", fmt.Sprintf("/accounts/%s/transactions?expand=attachments", accountID), "").Body).Decode(&page)
+	if len(page) != 1 || len(page[0].Attachments) != 1 {
+		t.Errorf("unexpected transactions: %#v", page)
+	}
+
+	if w := do("DELETE", path+"/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var attachments []attachment
+	json.NewDecoder(do("GET", path, "").Body).Decode(&attachments)
+	if len(attachments) != 0 {
+		t.Errorf("unexpected attachments: %#v", attachments)
+	}
+}
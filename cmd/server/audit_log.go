@@ -0,0 +1,171 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var auditLogFailures = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Name: "audit_log_failures",
+	Help: "Counter of mutating requests which couldn't be written to the audit log",
+}, nil)
+
+// auditEntry records who made a mutating request on the HTTP server, when and what it changed. Request bodies aren't
+// stored (they can hold PII), only their SHA-256 hash so a copy of the payload can be matched to its entry.
+type auditEntry struct {
+	ID           string    `json:"id"`
+	Timestamp    time.Time `json:"timestamp"`
+	Actor        string    `json:"actor"`
+	Organization string    `json:"organization"`
+	RequestID    string    `json:"requestId"`
+	Method       string    `json:"method"`
+	Path         string    `json:"path"`
+	Status       int       `json:"status"`
+	PayloadHash  string    `json:"payloadHash"`
+}
+
+// auditQuery filters the audit log. Empty fields match every entry.
+type auditQuery struct {
+	Actor        string
+	Organization string
+	RequestID    string
+	Since, Until time.Time
+	Limit        int
+}
+
+// mutatingRequest returns true for requests which can change accounts or transactions.
+func mutatingRequest(r *http.Request) bool {
+	switch r.Method {
+	case "POST", "PUT", "PATCH", "DELETE":
+		return true
+	}
+	return false
+}
+
+// hashingBody hashes a request body as it's read. Closing it is left to the auditLog, which reads whatever the
+// handler didn't so the hash covers the whole payload.
+type hashingBody struct {
+	io.ReadCloser
+	hash hash.Hash
+}
+
+func (b *hashingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	return n, err
+}
+
+func (b *hashingBody) Close() error {
+	return nil
+}
+
+// auditLog writes an entry to the audit log for every mutating request, including rejected ones, after it's served.
+// The actor is the authenticated caller from X-User-ID, so it must run inside the authenticators.
+type auditLog struct {
+	logger log.Logger
+	repo   auditRepository
+	next   http.Handler
+}
+
+func newAuditLog(logger log.Logger, repo auditRepository, next http.Handler) *auditLog {
+	return &auditLog{logger: logger, repo: repo, next: next}
+}
+
+func (l *auditLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !mutatingRequest(r) {
+		l.next.ServeHTTP(w, r)
+		return
+	}
+	if r.Body == nil {
+		r.Body = http.NoBody
+	}
+	body := &hashingBody{ReadCloser: r.Body, hash: sha256.New()}
+	r.Body = body
+	rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+	l.next.ServeHTTP(rec, r)
+
+	io.Copy(ioutil.Discard, body)
+	body.ReadCloser.Close()
+
+	organization, _ := organizationFrom(r.Context())
+	entry := &auditEntry{
+		ID:           newID(),
+		Timestamp:    time.Now(),
+		Actor:        moovhttp.GetUserID(r),
+		Organization: organization,
+		RequestID:    moovhttp.GetRequestID(r),
+		Method:       r.Method,
+		Path:         r.URL.Path,
+		Status:       rec.code,
+		PayloadHash:  hex.EncodeToString(body.hash.Sum(nil)),
+	}
+	if err := l.repo.writeAuditEntry(entry); err != nil {
+		auditLogFailures.Add(1)
+		l.logger.Log("audit", fmt.Sprintf("problem writing audit log entry for %s %s: %v", r.Method, r.URL.Path, err), "userID", entry.Actor, "requestID", entry.RequestID)
+	}
+}
+
+// getAuditLog is an admin route which lists audit log entries newest first, optionally filtered by ?actor,
+// ?organization, ?requestId and between ?since and ?until (RFC 3339 timestamps).
+func getAuditLog(logger log.Logger, repo auditRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q := auditQuery{
+			Actor:        r.URL.Query().Get("actor"),
+			Organization: r.URL.Query().Get("organization"),
+			RequestID:    r.URL.Query().Get("requestId"),
+			Limit:        100,
+		}
+		for param, when := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+			if v := r.URL.Query().Get(param); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					moovhttp.Problem(w, fmt.Errorf("invalid %s: %v", param, err))
+					return
+				}
+				*when = t
+			}
+		}
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				moovhttp.Problem(w, fmt.Errorf("invalid limit %q", v))
+				return
+			}
+			q.Limit = n
+		}
+		entries, err := repo.getAuditEntries(q)
+		if err != nil {
+			logger.Log("audit", fmt.Sprintf("problem reading audit log: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if entries == nil {
+			entries = []*auditEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(entries)
+	}
+}
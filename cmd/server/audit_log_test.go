@@ -0,0 +1,153 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestAuditLog(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlAuditRepository{db.DB, log.NewNopLogger()}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/accounts":
+			ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusOK)
+		case "/accounts/transactions":
+			// handlers which reject a request without reading all of it are still hashed in full
+			r.Body.Read(make([]byte, 4))
+			r.Body.Close()
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	})
+	handler := newAuditLog(log.NewNopLogger(), repo, next)
+	serve := func(method, path, body, organization string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-User-ID", "alice")
+		req.Header.Set("X-Request-ID", "req-"+method)
+		if organization != "" {
+			req = req.WithContext(withOrganization(req.Context(), organization))
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	serve("POST", "/accounts", `{"customerId": "foo"}`, "acme")
+	serve("GET", "/accounts/search", "", "acme")
+	serve("PUT", "/accounts/transactions", `{"lines": []}`, "")
+
+	entries, err := repo.getAuditEntries(auditQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries", len(entries))
+	}
+	sum := func(body string) string {
+		hash := sha256.Sum256([]byte(body))
+		return hex.EncodeToString(hash[:])
+	}
+	if e := entries[0]; e.Method != "PUT" || e.Path != "/accounts/transactions" || e.Status != http.StatusBadRequest || e.PayloadHash != sum(`{"lines": []}`) || e.Organization != "" {
+		t.Errorf("entry=%#v", e)
+	}
+	if e := entries[1]; e.Actor != "alice" || e.Organization != "acme" || e.RequestID != "req-POST" || e.Status != http.StatusOK || e.PayloadHash != sum(`{"customerId": "foo"}`) {
+		t.Errorf("entry=%#v", e)
+	}
+}
+
+func TestAuditLog__storage(t *testing.T) {
+	check := func(t *testing.T, repo auditRepository) {
+		now := time.Now().Truncate(time.Second)
+		for i, e := range []*auditEntry{
+			{ID: "1", Timestamp: now.Add(-2 * time.Hour), Actor: "alice", Organization: "acme", RequestID: "a", Method: "POST", Path: "/accounts", Status: 200, PayloadHash: "x"},
+			{ID: "2", Timestamp: now.Add(-time.Hour), Actor: "bob", Organization: "acme", RequestID: "b", Method: "PATCH", Path: "/accounts/1", Status: 200, PayloadHash: "y"},
+			{ID: "3", Timestamp: now, Actor: "alice", Organization: "other", RequestID: "c", Method: "POST", Path: "/accounts/transactions", Status: 400, PayloadHash: "z"},
+		} {
+			if err := repo.writeAuditEntry(e); err != nil {
+				t.Fatalf("entry %d: %v", i, err)
+			}
+		}
+		ids := func(q auditQuery) string {
+			t.Helper()
+			q.Limit = 10
+			entries, err := repo.getAuditEntries(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out []string
+			for i := range entries {
+				out = append(out, entries[i].ID)
+			}
+			return strings.Join(out, ",")
+		}
+		cases := map[string]auditQuery{
+			"3,2,1": {},
+			"3,1":   {Actor: "alice"},
+			"2,1":   {Organization: "acme"},
+			"2":     {RequestID: "b"},
+			"3,2":   {Since: now.Add(-90 * time.Minute)},
+			"1":     {Until: now.Add(-90 * time.Minute)},
+		}
+		for expected, q := range cases {
+			if got := ids(q); got != expected {
+				t.Errorf("%#v: got %s", q, got)
+			}
+		}
+		entries, err := repo.getAuditEntries(auditQuery{RequestID: "c", Limit: 1})
+		if err != nil || len(entries) != 1 || entries[0].Status != 400 || entries[0].Path != "/accounts/transactions" || !entries[0].Timestamp.Equal(now) {
+			t.Errorf("entries=%#v error=%v", entries, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlAuditRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlAuditRepository{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestAuditLog__route(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlAuditRepository{db.DB, log.NewNopLogger()}
+	if err := repo.writeAuditEntry(&auditEntry{ID: "1", Timestamp: time.Now(), Actor: "alice", Method: "POST", Path: "/accounts", Status: 200}); err != nil {
+		t.Fatal(err)
+	}
+	handler := getAuditLog(log.NewNopLogger(), repo)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/audit?actor=alice", nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var entries []auditEntry
+	if err := json.NewDecoder(w.Body).Decode(&entries); err != nil || len(entries) != 1 || entries[0].Actor != "alice" {
+		t.Errorf("entries=%#v error=%v", entries, err)
+	}
+
+	for _, path := range []string{"/audit?since=yesterday", "/audit?limit=0"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: bogus HTTP status: %d", path, w.Code)
+		}
+	}
+}
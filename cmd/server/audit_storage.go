@@ -0,0 +1,82 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+)
+
+type auditRepository interface {
+	Ping() error
+	Close() error
+
+	writeAuditEntry(e *auditEntry) error
+
+	// getAuditEntries returns entries matching q, newest first.
+	getAuditEntries(q auditQuery) ([]*auditEntry, error)
+}
+
+type sqlAuditRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlAuditRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlAuditRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlAuditRepository) writeAuditEntry(e *auditEntry) error {
+	query := `insert into audit_log (audit_id, occurred_at, actor, organization_id, request_id, method, path, status, payload_hash) values (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("writeAuditEntry: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(e.ID, e.Timestamp, e.Actor, e.Organization, e.RequestID, e.Method, e.Path, e.Status, e.PayloadHash); err != nil {
+		return fmt.Errorf("writeAuditEntry: entry=%s: %v", e.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlAuditRepository) getAuditEntries(q auditQuery) ([]*auditEntry, error) {
+	where, args := []string{"1 = 1"}, []interface{}{}
+	for column, v := range map[string]string{"actor": q.Actor, "organization_id": q.Organization, "request_id": q.RequestID} {
+		if v != "" {
+			where, args = append(where, column+` = ?`), append(args, v)
+		}
+	}
+	if !q.Since.IsZero() {
+		where, args = append(where, `occurred_at >= ?`), append(args, q.Since)
+	}
+	if !q.Until.IsZero() {
+		where, args = append(where, `occurred_at < ?`), append(args, q.Until)
+	}
+	query := fmt.Sprintf(`select audit_id, occurred_at, actor, organization_id, request_id, method, path, status, payload_hash from audit_log
+where %s order by occurred_at desc, audit_id desc limit %d;`, strings.Join(where, " and "), q.Limit)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getAuditEntries: %v", err)
+	}
+	defer rows.Close()
+
+	var out []*auditEntry
+	for rows.Next() {
+		var e auditEntry
+		if err := rows.Scan(&e.ID, &e.Timestamp, &e.Actor, &e.Organization, &e.RequestID, &e.Method, &e.Path, &e.Status, &e.PayloadHash); err != nil {
+			return nil, fmt.Errorf("getAuditEntries: scan: %v", err)
+		}
+		out = append(out, &e)
+	}
+	return out, rows.Err()
+}
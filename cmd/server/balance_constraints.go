@@ -0,0 +1,67 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// balanceConstraint has the database guard the balances of accounts of one type, beneath the insufficient funds
+// checks made while posting. Debits can't take a balance below -overdraftLimit and, with a Ceiling, credits can't
+// take it above the Ceiling.
+type balanceConstraint struct {
+	AccountType AccountType
+	Ceiling     *int64
+}
+
+// readBalanceConstraints reads BALANCE_CONSTRAINTS, a comma separated list of account types each optionally followed
+// by a ceiling in cents, such as "checking,savings:25000000".
+func readBalanceConstraints() ([]balanceConstraint, error) {
+	var out []balanceConstraint
+	for _, entry := range strings.Split(os.Getenv("BALANCE_CONSTRAINTS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		constraint := balanceConstraint{AccountType: AccountType(strings.ToLower(parts[0]))}
+		if err := constraint.AccountType.validate(); err != nil {
+			return nil, fmt.Errorf("BALANCE_CONSTRAINTS: %v", err)
+		}
+		if len(parts) == 2 {
+			ceiling, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil || ceiling < 0 {
+				return nil, fmt.Errorf("BALANCE_CONSTRAINTS: invalid ceiling %q for %s", parts[1], constraint.AccountType)
+			}
+			constraint.Ceiling = &ceiling
+		}
+		out = append(out, constraint)
+	}
+	return out, nil
+}
+
+// setBalanceConstraints replaces the constraints the database enforces on account balances. Passing none turns them off.
+func (r *sqlTransactionRepository) setBalanceConstraints(constraints []balanceConstraint) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("setBalanceConstraints: begin: %v", err)
+	}
+	if _, err := tx.Exec(`delete from balance_constraints;`); err != nil {
+		return fmt.Errorf("setBalanceConstraints: delete: error=%v rollback=%v", err, tx.Rollback())
+	}
+	for i := range constraints {
+		query := `insert into balance_constraints(account_type, ceiling) values (?, ?);`
+		if _, err := tx.Exec(query, string(constraints[i].AccountType), constraints[i].Ceiling); err != nil {
+			return fmt.Errorf("setBalanceConstraints: account type %s: error=%v rollback=%v", constraints[i].AccountType, err, tx.Rollback())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("setBalanceConstraints: commit: %v", err)
+	}
+	return nil
+}
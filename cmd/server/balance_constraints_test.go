@@ -0,0 +1,117 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestBalanceConstraints__read(t *testing.T) {
+	if constraints, err := readBalanceConstraints(); err != nil || len(constraints) != 0 {
+		t.Errorf("constraints=%#v error=%v", constraints, err)
+	}
+
+	os.Setenv("BALANCE_CONSTRAINTS", "Checking, savings:25000")
+	defer os.Unsetenv("BALANCE_CONSTRAINTS")
+	constraints, err := readBalanceConstraints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(constraints) != 2 || constraints[0].AccountType != Checking || constraints[0].Ceiling != nil || constraints[1].AccountType != Savings || *constraints[1].Ceiling != 25000 {
+		t.Errorf("constraints=%#v", constraints)
+	}
+
+	for _, v := range []string{"gl", "savings:lots", "savings:-1"} {
+		os.Setenv("BALANCE_CONSTRAINTS", v)
+		if _, err := readBalanceConstraints(); err == nil {
+			t.Errorf("%s: expected error", v)
+		}
+	}
+}
+
+func TestBalanceConstraints(t *testing.T) {
+	ctx := context.Background()
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	accountRepo := createTestSqlAccountRepository(t, db.DB)
+	repo, err := setupSqlTransactionStorage(ctx, log.NewNopLogger(), db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.accountRepo = accountRepo
+	for _, acct := range []*accounts.Account{
+		{ID: "alice", CustomerID: "alice", AccountNumber: "1", RoutingNumber: defaultRoutingNumber, Status: "open", Type: string(Checking), OverdraftLimit: 50},
+		{ID: "funding", CustomerID: "bank", AccountNumber: "2", RoutingNumber: defaultRoutingNumber, Status: "open", Type: string(FBO)},
+	} {
+		if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transfer := func(from, to string, amount int) error {
+		tx := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Lines: []transactionLine{
+				{AccountID: from, Purpose: ACHDebit, Amount: amount},
+				{AccountID: to, Purpose: ACHCredit, Amount: amount},
+			},
+		}
+		return repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true})
+	}
+
+	ceiling := int64(1000)
+	if err := repo.setBalanceConstraints([]balanceConstraint{{AccountType: Checking, Ceiling: &ceiling}}); err != nil {
+		t.Fatal(err)
+	}
+	// accounts of other types aren't guarded, so funding goes negative
+	if err := transfer("funding", "alice", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer("funding", "alice", 1); !database.BalanceConstraintViolation(err) || !strings.Contains(err.Error(), "ceiling") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// debits down to -overdraftLimit are allowed even when the application skips its checks
+	if err := transfer("alice", "funding", 1050); err != nil {
+		t.Fatal(err)
+	}
+	err = transfer("alice", "funding", 1)
+	if !database.BalanceConstraintViolation(err) || !strings.Contains(err.Error(), errInsufficientFunds.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if kind := classifyStorageError(err); kind != storageErrorConstraint {
+		t.Errorf("kind=%q", kind)
+	}
+	// credits are posted to accounts at their floor
+	if err := transfer("funding", "alice", 10); err != nil {
+		t.Fatal(err)
+	}
+
+	// removing the constraints turns them off
+	if err := repo.setBalanceConstraints(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := transfer("alice", "funding", 500); err != nil {
+		t.Fatal(err)
+	}
+
+	dbtx, _ := repo.db.Begin()
+	defer dbtx.Rollback()
+	for id, expected := range map[string]int32{"alice": -540, "funding": 540} {
+		if balance, err := repo.getAccountBalance(dbtx, id); err != nil || balance != expected {
+			t.Errorf("account=%s balance=%d error=%v", id, balance, err)
+		}
+	}
+}
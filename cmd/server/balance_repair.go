@@ -0,0 +1,67 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// balanceRepair describes the difference between an account's stored balance checkpoint and the balance
+// recomputed from its transaction lines.
+type balanceRepair struct {
+	AccountID string `json:"accountId"`
+
+	// Expected is the balance recomputed from transaction lines and daily summaries
+	Expected int64 `json:"expected"`
+
+	// Actual is the balance summed from the account's checkpoint stripes
+	Actual int64 `json:"actual"`
+
+	// Drift is Actual minus Expected, so zero means the checkpoint is correct
+	Drift int64 `json:"drift"`
+
+	Repaired bool `json:"repaired"`
+}
+
+// repairAccountBalance is an admin route which reports drift between an account's balance checkpoint and
+// its transaction lines. GET requests only report the drift while POST requests also correct it.
+func repairAccountBalance(logger log.Logger, transactionRepo transactionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID := getAccountID(w, r)
+		if accountID == "" {
+			return
+		}
+
+		var repair bool
+		switch r.Method {
+		case "GET":
+		case "POST":
+			repair = true
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		result, err := transactionRepo.repairAccountBalance(accountID, repair)
+		if err != nil {
+			logger.Log("balances", fmt.Sprintf("problem checking account=%s balance: %v", accountID, err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if result.Drift != 0 {
+			logger.Log("balances", fmt.Sprintf("account=%s balance drift=%d repaired=%v", accountID, result.Drift, result.Repaired))
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestBalanceRepair__route(t *testing.T) {
+	transactionRepo := &mockTransactionRepository{}
+
+	router := mux.NewRouter()
+	router.Handle("/accounts/{accountId}/balance/repair", repairAccountBalance(log.NewNopLogger(), transactionRepo))
+
+	accountID := base.ID()
+	for _, method := range []string{"GET", "POST"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, fmt.Sprintf("/accounts/%s/balance/repair", accountID), nil))
+		w.Flush()
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: bogus status code: %d", method, w.Code)
+		}
+		var result balanceRepair
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		if result.AccountID != accountID || result.Repaired != (method == "POST") {
+			t.Errorf("%s: %#v", method, result)
+		}
+	}
+
+	// unsupported method
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", fmt.Sprintf("/accounts/%s/balance/repair", accountID), nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("bogus status code: %d", w.Code)
+	}
+
+	// repository error
+	transactionRepo.err = errors.New("bad thing")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/accounts/%s/balance/repair", accountID), nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus status code: %d", w.Code)
+	}
+}
+
+func TestSqlTransactionRepository__repairAccountBalance(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		accountID := base.ID()
+		repo.accountRepo = &testAccountRepository{
+			accounts: []*accounts.Account{{ID: accountID, RoutingNumber: defaultRoutingNumber}},
+		}
+		for i := 0; i < 2; i++ {
+			tx := transaction{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Lines:     []transactionLine{{AccountID: accountID, Purpose: ACHCredit, Amount: 1000}},
+			}
+			if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{InitialDeposit: true}); err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				// compact the first deposit so it's only counted through daily summaries
+				if _, err := repo.compactTransactionLines(time.Now().Add(time.Minute)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		result, err := repo.repairAccountBalance(accountID, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Expected != 2000 || result.Actual != 2000 || result.Drift != 0 {
+			t.Errorf("%#v", result)
+		}
+
+		// corrupt the checkpoint
+		if _, err := repo.db.Exec(`update account_balances set balance = balance + 15 where account_id = ?`, accountID); err != nil {
+			t.Fatal(err)
+		}
+		result, err = repo.repairAccountBalance(accountID, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Drift != 15 || result.Repaired {
+			t.Errorf("%#v", result)
+		}
+
+		result, err = repo.repairAccountBalance(accountID, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Drift != 15 || !result.Repaired {
+			t.Errorf("%#v", result)
+		}
+		result, err = repo.repairAccountBalance(accountID, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Actual != 2000 || result.Drift != 0 {
+			t.Errorf("%#v", result)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
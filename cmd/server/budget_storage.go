@@ -0,0 +1,97 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+type budgetRepository interface {
+	Ping() error
+	Close() error
+
+	// createBudget returns errBudgetExists if the segment value already has a budget for the period.
+	createBudget(b *budget) error
+
+	// deleteBudget returns false if the budget doesn't exist.
+	deleteBudget(budgetID string) (bool, error)
+
+	// getBudgets returns every budget ordered by segment, value and period.
+	getBudgets() ([]*budget, error)
+}
+
+type sqlBudgetRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlBudgetRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlBudgetRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlBudgetRepository) createBudget(b *budget) error {
+	query := `insert into budgets (budget_id, segment, segment_value, budget_period, amount, enforcement, created_at) values (?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createBudget: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(b.ID, b.Segment, b.Value, b.Period, b.Amount, b.Enforcement, b.CreatedAt); err != nil {
+		if database.UniqueViolation(err) {
+			return errBudgetExists
+		}
+		return fmt.Errorf("createBudget: budget=%s: %v", b.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlBudgetRepository) deleteBudget(budgetID string) (bool, error) {
+	query := `delete from budgets where budget_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, fmt.Errorf("deleteBudget: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(budgetID)
+	if err != nil {
+		return false, fmt.Errorf("deleteBudget: budget=%s: %v", budgetID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("deleteBudget: budget=%s: %v", budgetID, err)
+	}
+	return n == 1, nil
+}
+
+func (r *sqlBudgetRepository) getBudgets() ([]*budget, error) {
+	query := `select budget_id, segment, segment_value, budget_period, amount, enforcement, created_at from budgets
+order by segment, segment_value, budget_period;`
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("getBudgets: %v", err)
+	}
+	defer rows.Close()
+
+	var out []*budget
+	for rows.Next() {
+		var b budget
+		if err := rows.Scan(&b.ID, &b.Segment, &b.Value, &b.Period, &b.Amount, &b.Enforcement, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("getBudgets: scan: %v", err)
+		}
+		out = append(out, &b)
+	}
+	return out, rows.Err()
+}
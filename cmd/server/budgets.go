@@ -0,0 +1,301 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+var (
+	errBudgetExists = errors.New("the segment value already has a budget for the period")
+)
+
+// budgetPeriod is the calendar period (in UTC) a budget's spend is totaled over.
+type budgetPeriod string
+
+const (
+	budgetMonthly   budgetPeriod = "monthly"
+	budgetQuarterly budgetPeriod = "quarterly"
+	budgetYearly    budgetPeriod = "yearly"
+)
+
+// bounds returns the start and (exclusive) end of the period containing when.
+func (p budgetPeriod) bounds(when time.Time) (time.Time, time.Time) {
+	when = when.UTC()
+	switch p {
+	case budgetQuarterly:
+		start := time.Date(when.Year(), when.Month()-(when.Month()-1)%3, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 3, 0)
+	case budgetYearly:
+		start := time.Date(when.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0)
+	default:
+		start := time.Date(when.Year(), when.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+}
+
+// budgetEnforcement is what happens to a posting which would take a segment over its budget.
+type budgetEnforcement string
+
+const (
+	// budgetWarn posts the transaction and logs a warning
+	budgetWarn budgetEnforcement = "warn"
+
+	// budgetBlock rejects the transaction
+	budgetBlock budgetEnforcement = "block"
+)
+
+// budget caps the spend, the debits of posted lines, of one segment value over each period.
+type budget struct {
+	ID          string            `json:"id"`
+	Segment     lineSegment       `json:"segment"`
+	Value       string            `json:"value"`
+	Period      budgetPeriod      `json:"period"`
+	Amount      int64             `json:"amount"`
+	Enforcement budgetEnforcement `json:"enforcement"`
+	CreatedAt   time.Time         `json:"createdAt"`
+}
+
+func (b *budget) validate() error {
+	if err := b.Segment.validate(); err != nil {
+		return err
+	}
+	if b.Value == "" {
+		return fmt.Errorf("budget: missing %s value", b.Segment)
+	}
+	if !configuredSegments[b.Segment][b.Value] {
+		return fmt.Errorf("budget: %s=%q isn't configured in %s", b.Segment, b.Value, lineSegmentEnvVars[b.Segment])
+	}
+	switch b.Period {
+	case budgetMonthly, budgetQuarterly, budgetYearly:
+	default:
+		return fmt.Errorf("budget: unknown period %q, expected monthly, quarterly or yearly", b.Period)
+	}
+	if b.Amount <= 0 {
+		return fmt.Errorf("budget: amount must be positive, got %d", b.Amount)
+	}
+	switch b.Enforcement {
+	case budgetWarn, budgetBlock:
+	default:
+		return fmt.Errorf("budget: unknown enforcement %q, expected warn or block", b.Enforcement)
+	}
+	return nil
+}
+
+// debits returns the amount tx spends against the budget.
+func (b *budget) debits(tx transaction) int64 {
+	var total int64
+	for i := range tx.Lines {
+		if tx.Lines[i].Purpose == ACHDebit && b.Segment.value(tx.Lines[i]) == b.Value {
+			total += int64(tx.Lines[i].Amount)
+		}
+	}
+	return total
+}
+
+// spent returns the debits posted against the budget in the period containing when.
+func (b *budget) spent(repo transactionRepository, when time.Time) (int64, error) {
+	start, end := b.Period.bounds(when)
+	totals, err := repo.getSegmentTotals(segmentTotalsQuery{Segment: b.Segment, Since: start, Until: end})
+	if err != nil {
+		return 0, err
+	}
+	for i := range totals {
+		if totals[i].Value == b.Value {
+			return totals[i].Debits, nil
+		}
+	}
+	return 0, nil
+}
+
+// budgetingTransactionRepository checks new transactions against budgets before posting them. Force posts and
+// initial deposits aren't checked. The check isn't atomic with posting, so concurrent postings can each fit under
+// a budget which they exceed together.
+type budgetingTransactionRepository struct {
+	transactionRepository
+
+	budgets budgetRepository
+	logger  log.Logger
+}
+
+func (r *budgetingTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	if !opts.AllowOverdraft && !opts.InitialDeposit {
+		if err := r.checkBudgets(tx); err != nil {
+			return err
+		}
+	}
+	return r.transactionRepository.createTransaction(ctx, tx, opts)
+}
+
+func (r *budgetingTransactionRepository) checkBudgets(tx transaction) error {
+	budgets, err := r.budgets.getBudgets()
+	if err != nil {
+		return fmt.Errorf("checkBudgets: %v", err)
+	}
+	for _, b := range budgets {
+		debits := b.debits(tx)
+		if debits == 0 {
+			continue
+		}
+		spent, err := b.spent(r.transactionRepository, tx.Timestamp)
+		if err != nil {
+			return fmt.Errorf("checkBudgets: budget=%s: %v", b.ID, err)
+		}
+		if spent+debits <= b.Amount {
+			continue
+		}
+		err = fmt.Errorf("transaction=%s would exceed the %s budget of %s=%s, spent %d of %d", tx.ID, b.Period, b.Segment, b.Value, spent+debits, b.Amount)
+		if b.Enforcement == budgetBlock {
+			return err
+		}
+		r.logger.Log("budgets", err.Error(), "budgetID", b.ID)
+	}
+	return nil
+}
+
+// budgetActual compares a budget to what's been spent in one of its periods.
+type budgetActual struct {
+	Budget      *budget   `json:"budget"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+	Spent       int64     `json:"spent"`
+	Remaining   int64     `json:"remaining"`
+	Exceeded    bool      `json:"exceeded"`
+}
+
+func getBudgetActuals(budgets []*budget, repo transactionRepository, when time.Time) ([]budgetActual, error) {
+	out := make([]budgetActual, 0, len(budgets))
+	for _, b := range budgets {
+		spent, err := b.spent(repo, when)
+		if err != nil {
+			return nil, fmt.Errorf("getBudgetActuals: budget=%s: %v", b.ID, err)
+		}
+		start, end := b.Period.bounds(when)
+		out = append(out, budgetActual{
+			Budget:      b,
+			PeriodStart: start,
+			PeriodEnd:   end,
+			Spent:       spent,
+			Remaining:   b.Amount - spent,
+			Exceeded:    spent > b.Amount,
+		})
+	}
+	return out, nil
+}
+
+// budgets is an admin route which lists budgets (GET) or creates one (POST).
+func budgets(logger log.Logger, repo budgetRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			budgets, err := repo.getBudgets()
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if budgets == nil {
+				budgets = []*budget{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(budgets)
+
+		case "POST":
+			var b budget
+			if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			b.ID, b.CreatedAt = newID(), time.Now()
+			b.Segment = lineSegment(strings.ToLower(string(b.Segment)))
+			if b.Enforcement == "" {
+				b.Enforcement = budgetWarn
+			}
+			if err := b.validate(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if err := repo.createBudget(&b); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("budgets", fmt.Sprintf("created %s budget=%s of %d for %s=%s", b.Period, b.ID, b.Amount, b.Segment, b.Value), "userID", moovhttp.GetUserID(r))
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(b)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// deleteBudget is an admin route which removes a budget.
+func deleteBudget(logger log.Logger, repo budgetRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		budgetID := mux.Vars(r)["budgetId"]
+		found, err := repo.deleteBudget(budgetID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logger.Log("budgets", fmt.Sprintf("deleted budget=%s", budgetID), "userID", moovhttp.GetUserID(r))
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// budgetReport is an admin route which compares every budget to its spend in the period containing ?at (an RFC 3339
+// timestamp), or the current period.
+func budgetReport(logger log.Logger, repo budgetRepository, transactionRepo transactionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		when := time.Now()
+		if v := r.URL.Query().Get("at"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				moovhttp.Problem(w, fmt.Errorf("invalid at: %v", err))
+				return
+			}
+			when = t
+		}
+		budgets, err := repo.getBudgets()
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		actuals, err := getBudgetActuals(budgets, transactionRepo, when)
+		if err != nil {
+			logger.Log("budgets", fmt.Sprintf("problem reporting budgets: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(actuals)
+	}
+}
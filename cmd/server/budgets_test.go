@@ -0,0 +1,181 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/moov-io/base"
+)
+
+func TestBudgets__bounds(t *testing.T) {
+	when := time.Date(2020, time.August, 14, 23, 0, 0, 0, time.FixedZone("PDT", -7*60*60))
+	cases := map[budgetPeriod][2]string{
+		budgetMonthly:   {"2020-08-01", "2020-09-01"},
+		budgetQuarterly: {"2020-07-01", "2020-10-01"},
+		budgetYearly:    {"2020-01-01", "2021-01-01"},
+	}
+	for period, expected := range cases {
+		start, end := period.bounds(when)
+		if start.Format("2006-01-02") != expected[0] || end.Format("2006-01-02") != expected[1] {
+			t.Errorf("%s: start=%v end=%v", period, start, end)
+		}
+	}
+	if start, _ := budgetQuarterly.bounds(time.Date(2020, time.March, 31, 0, 0, 0, 0, time.UTC)); start.Month() != time.January {
+		t.Errorf("start=%v", start)
+	}
+}
+
+func TestBudgets__validate(t *testing.T) {
+	defer func(values segmentValues) { configuredSegments = values }(configuredSegments)
+	configuredSegments = segmentValues{segmentDepartment: {"sales": true}}
+
+	b := budget{Segment: segmentDepartment, Value: "sales", Period: budgetMonthly, Amount: 100, Enforcement: budgetBlock}
+	if err := b.validate(); err != nil {
+		t.Error(err)
+	}
+	for _, bad := range []budget{
+		{Segment: "team", Value: "sales", Period: budgetMonthly, Amount: 100, Enforcement: budgetBlock},
+		{Segment: segmentDepartment, Value: "ops", Period: budgetMonthly, Amount: 100, Enforcement: budgetBlock},
+		{Segment: segmentDepartment, Value: "sales", Period: "weekly", Amount: 100, Enforcement: budgetBlock},
+		{Segment: segmentDepartment, Value: "sales", Period: budgetMonthly, Amount: 0, Enforcement: budgetBlock},
+		{Segment: segmentDepartment, Value: "sales", Period: budgetMonthly, Amount: 100, Enforcement: "stop"},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("expected error: %#v", bad)
+		}
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	defer func(values segmentValues) { configuredSegments = values }(configuredSegments)
+	configuredSegments = segmentValues{
+		segmentDepartment: {"sales": true, "ops": true},
+		segmentProduct:    {"cards": true},
+	}
+
+	check := func(t *testing.T, budgetRepo *sqlBudgetRepository) {
+		accountRepo, memoryRepo := createTestLedger(t, map[string]int{"expenses": 10000, "cash": 10000})
+		transactionRepo := &budgetingTransactionRepository{transactionRepository: memoryRepo, budgets: budgetRepo, logger: log.NewNopLogger()}
+
+		router := mux.NewRouter()
+		router.HandleFunc("/budgets", budgets(log.NewNopLogger(), budgetRepo))
+		router.HandleFunc("/budgets/report", budgetReport(log.NewNopLogger(), budgetRepo, transactionRepo))
+		router.HandleFunc("/budgets/{budgetId}", deleteBudget(log.NewNopLogger(), budgetRepo))
+		serve := func(method, path, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set("x-user-id", "finance")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			w.Flush()
+			return w
+		}
+		create := func(body string) *budget {
+			t.Helper()
+			w := serve("POST", "/budgets", body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+			}
+			var b budget
+			if err := json.NewDecoder(w.Body).Decode(&b); err != nil {
+				t.Fatal(err)
+			}
+			return &b
+		}
+		sales := create(`{"segment": "Department", "value": "sales", "period": "monthly", "amount": 1000, "enforcement": "block"}`)
+		cards := create(`{"segment": "product", "value": "cards", "period": "yearly", "amount": 100}`)
+		if sales.Segment != segmentDepartment || cards.Enforcement != budgetWarn {
+			t.Errorf("sales=%#v cards=%#v", sales, cards)
+		}
+		if w := serve("POST", "/budgets", `{"segment": "department", "value": "sales", "period": "monthly", "amount": 50}`); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+
+		post := func(amount int, department, product string) error {
+			return transactionRepo.createTransaction(context.Background(), transaction{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Status:    TransactionPosted,
+				Lines: []transactionLine{
+					{AccountID: "expenses", Purpose: ACHDebit, Amount: amount, Department: department, Product: product},
+					{AccountID: "cash", Purpose: ACHCredit, Amount: amount, Department: department},
+				},
+			}, createTransactionOpts{})
+		}
+		if err := post(600, "sales", "cards"); err != nil {
+			t.Fatal(err)
+		}
+		// blocked budgets reject postings over the budget while warnings still post
+		if err := post(500, "sales", ""); err == nil || !strings.Contains(err.Error(), "monthly budget of department=sales") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if err := post(400, "sales", "cards"); err != nil {
+			t.Error(err)
+		}
+		if err := post(3000, "ops", ""); err != nil {
+			t.Error(err)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"expenses": 6000, "cash": 14000})
+
+		// force posts aren't checked
+		tx := transaction{ID: base.ID(), Timestamp: time.Now(), Status: TransactionPosted, Lines: []transactionLine{
+			{AccountID: "expenses", Purpose: ACHDebit, Amount: 100, Department: "sales"},
+			{AccountID: "cash", Purpose: ACHCredit, Amount: 100},
+		}}
+		if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Error(err)
+		}
+
+		w := serve("GET", "/budgets/report", "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		var actuals []budgetActual
+		if err := json.NewDecoder(w.Body).Decode(&actuals); err != nil || len(actuals) != 2 {
+			t.Fatalf("actuals=%#v error=%v", actuals, err)
+		}
+		if a := actuals[0]; a.Budget.ID != sales.ID || a.Spent != 1100 || a.Remaining != -100 || !a.Exceeded {
+			t.Errorf("unexpected actual: %#v", a)
+		}
+		if a := actuals[1]; a.Budget.ID != cards.ID || a.Spent != 1000 || !a.Exceeded || a.PeriodStart.Month() != time.January {
+			t.Errorf("unexpected actual: %#v", a)
+		}
+		if err := json.NewDecoder(serve("GET", "/budgets/report?at=2019-01-01T00:00:00Z", "").Body).Decode(&actuals); err != nil || actuals[0].Spent != 0 {
+			t.Errorf("actuals=%#v error=%v", actuals, err)
+		}
+
+		if w := serve("DELETE", "/budgets/"+sales.ID, ""); w.Code != http.StatusOK {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if w := serve("DELETE", "/budgets/"+sales.ID, ""); w.Code != http.StatusNotFound {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		var remaining []*budget
+		if err := json.NewDecoder(serve("GET", "/budgets", "").Body).Decode(&remaining); err != nil || len(remaining) != 1 {
+			t.Errorf("budgets=%#v error=%v", remaining, err)
+		}
+		if err := post(500, "sales", ""); err != nil {
+			t.Error(err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlBudgetRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlBudgetRepository{mysqlDB.DB, log.NewNopLogger()})
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	app "github.com/moov-io/accounts"
+)
+
+// buildInfo describes the running server so operators can verify what's deployed. It's served from the admin
+// port's /version route.
+type buildInfo struct {
+	mu sync.RWMutex
+
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit,omitempty"`
+	BuildTime string `json:"buildTime,omitempty"`
+	GoVersion string `json:"goVersion"`
+
+	Storage  storageInfo `json:"storage"`
+	Features []string    `json:"features"`
+}
+
+// storageInfo names the storage backends of accounts and transactions.
+type storageInfo struct {
+	Accounts     string `json:"accounts"`
+	Transactions string `json:"transactions"`
+	Shards       int    `json:"shards"`
+
+	// Shadow backends receive a copy of each write while migrating between storage backends
+	AccountShadow     string `json:"accountShadow,omitempty"`
+	TransactionShadow string `json:"transactionShadow,omitempty"`
+}
+
+func newBuildInfo() *buildInfo {
+	return &buildInfo{
+		Version:   app.Version,
+		GitCommit: app.GitCommit,
+		BuildTime: app.BuildTime,
+		GoVersion: runtime.Version(),
+		Storage:   readStorageInfo(),
+		Features:  []string{},
+	}
+}
+
+func readStorageInfo() storageInfo {
+	return storageInfo{
+		Accounts:          strings.ToLower(or(os.Getenv("ACCOUNT_STORAGE_TYPE"), "sqlite")),
+		Transactions:      strings.ToLower(or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite")),
+		Shards:            storageShards(),
+		AccountShadow:     strings.ToLower(os.Getenv("ACCOUNT_SHADOW_STORAGE_TYPE")),
+		TransactionShadow: strings.ToLower(os.Getenv("TRANSACTION_SHADOW_STORAGE_TYPE")),
+	}
+}
+
+// enable records an optional feature which was configured at startup.
+func (info *buildInfo) enable(feature string) {
+	info.mu.Lock()
+	defer info.mu.Unlock()
+
+	if !containsString(info.Features, feature) {
+		info.Features = append(info.Features, feature)
+		sort.Strings(info.Features)
+	}
+}
+
+// ServeHTTP returns the build info as JSON on GET /version.
+func (info *buildInfo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	info.mu.RLock()
+	defer info.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(info)
+}
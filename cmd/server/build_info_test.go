@@ -0,0 +1,61 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	app "github.com/moov-io/accounts"
+)
+
+func TestBuildInfo(t *testing.T) {
+	os.Setenv("TRANSACTION_STORAGE_TYPE", "MySQL")
+	defer os.Setenv("TRANSACTION_STORAGE_TYPE", "")
+
+	info := newBuildInfo()
+	info.enable("webhooks")
+	info.enable("fees")
+	info.enable("webhooks")
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/version", nil)
+	info.ServeHTTP(w, req)
+	w.Flush()
+
+	if w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	var resp struct {
+		Version  string      `json:"version"`
+		Storage  storageInfo `json:"storage"`
+		Features []string    `json:"features"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != app.Version {
+		t.Errorf("version=%s", resp.Version)
+	}
+	if resp.Storage.Accounts != "sqlite" || resp.Storage.Transactions != "mysql" || resp.Storage.Shards != 1 {
+		t.Errorf("storage: %#v", resp.Storage)
+	}
+	if len(resp.Features) != 2 || resp.Features[0] != "fees" || resp.Features[1] != "webhooks" {
+		t.Errorf("features: %v", resp.Features)
+	}
+
+	// only GET is allowed
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest("POST", "/version", nil)
+	info.ServeHTTP(w, req)
+	w.Flush()
+
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	bulkheadInFlight = prometheus.NewGaugeFrom(stdprometheus.GaugeOpts{
+		Name: "http_bulkhead_in_flight",
+		Help: "How many HTTP requests are being served in each route group",
+	}, []string{"group"})
+
+	bulkheadShed = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "http_bulkhead_shed",
+		Help: "Counter of HTTP requests rejected with a 503 because their route group was at its concurrency limit",
+	}, []string{"group"})
+)
+
+// Route groups which are limited separately so one kind of traffic can't starve another.
+const (
+	routeGroupPostings = "postings"
+	routeGroupReads    = "reads"
+	routeGroupReports  = "reports"
+)
+
+// routeGroup returns which group a request belongs to. Any write is a posting, listing an account's
+// transaction history is a report and everything else is a read.
+func routeGroup(r *http.Request) string {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+	default:
+		return routeGroupPostings
+	}
+	if strings.HasPrefix(r.URL.Path, "/accounts/") && strings.HasSuffix(r.URL.Path, "/transactions") {
+		return routeGroupReports
+	}
+	return routeGroupReads
+}
+
+// bulkheadLimits reads the concurrency limit of each route group from CONCURRENCY_LIMIT_POSTINGS,
+// CONCURRENCY_LIMIT_READS and CONCURRENCY_LIMIT_REPORTS. Groups without a limit aren't restricted.
+func bulkheadLimits() (map[string]int, error) {
+	limits := make(map[string]int)
+	for _, group := range []string{routeGroupPostings, routeGroupReads, routeGroupReports} {
+		name := "CONCURRENCY_LIMIT_" + strings.ToUpper(group)
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", name, v)
+			}
+			if n > 0 {
+				limits[group] = n
+			}
+		}
+	}
+	return limits, nil
+}
+
+// bulkheadQueueTimeout returns how long a request waits for its route group to have capacity before it's shed.
+func bulkheadQueueTimeout() time.Duration {
+	if v := os.Getenv("CONCURRENCY_QUEUE_TIMEOUT"); v != "" {
+		if dur, err := time.ParseDuration(v); err == nil && dur >= 0 {
+			return dur
+		}
+	}
+	return 250 * time.Millisecond
+}
+
+// bulkhead caps how many requests of each route group are served at once. Requests over the cap wait up to
+// queueTimeout for another request in their group to finish and are then rejected with a 503.
+type bulkhead struct {
+	logger log.Logger
+	next   http.Handler
+
+	slots        map[string]chan struct{}
+	queueTimeout time.Duration
+}
+
+func newBulkhead(logger log.Logger, next http.Handler, limits map[string]int, queueTimeout time.Duration) *bulkhead {
+	b := &bulkhead{
+		logger:       logger,
+		next:         next,
+		slots:        make(map[string]chan struct{}),
+		queueTimeout: queueTimeout,
+	}
+	for group, limit := range limits {
+		b.slots[group] = make(chan struct{}, limit)
+	}
+	return b
+}
+
+func (b *bulkhead) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	group := routeGroup(r)
+	slots, ok := b.slots[group]
+	if !ok {
+		b.next.ServeHTTP(w, r)
+		return
+	}
+
+	if !b.acquire(r, slots) {
+		bulkheadShed.With("group", group).Add(1)
+		b.logger.Log("bulkhead", fmt.Sprintf("shed %s %s: %s requests at capacity", r.Method, r.URL.Path, group))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error":"server is busy, please retry"}`))
+		return
+	}
+	bulkheadInFlight.With("group", group).Add(1)
+	defer func() {
+		bulkheadInFlight.With("group", group).Add(-1)
+		<-slots
+	}()
+
+	b.next.ServeHTTP(w, r)
+}
+
+func (b *bulkhead) acquire(r *http.Request, slots chan struct{}) bool {
+	select {
+	case slots <- struct{}{}:
+		return true
+	default:
+	}
+	if b.queueTimeout <= 0 {
+		return false
+	}
+
+	timer := time.NewTimer(b.queueTimeout)
+	defer timer.Stop()
+	select {
+	case slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRouteGroup(t *testing.T) {
+	cases := map[string]string{
+		"POST /accounts":                      routeGroupPostings,
+		"POST /accounts/transactions":         routeGroupPostings,
+		"PUT /accounts/transactions/1/status": routeGroupPostings,
+		"GET /accounts/1/transactions":        routeGroupReports,
+		"GET /accounts/search":                routeGroupReads,
+		"GET /accounts/transactions/1":        routeGroupReads,
+		"GET /ping":                           routeGroupReads,
+	}
+	for route, expected := range cases {
+		parts := strings.SplitN(route, " ", 2)
+		if group := routeGroup(httptest.NewRequest(parts[0], parts[1], nil)); group != expected {
+			t.Errorf("%s: got %s", route, group)
+		}
+	}
+}
+
+func TestBulkheadLimits(t *testing.T) {
+	defer os.Unsetenv("CONCURRENCY_LIMIT_REPORTS")
+
+	if limits, err := bulkheadLimits(); err != nil || len(limits) != 0 {
+		t.Errorf("limits=%v error=%v", limits, err)
+	}
+	os.Setenv("CONCURRENCY_LIMIT_REPORTS", "4")
+	if limits, err := bulkheadLimits(); err != nil || limits[routeGroupReports] != 4 || len(limits) != 1 {
+		t.Errorf("limits=%v error=%v", limits, err)
+	}
+	os.Setenv("CONCURRENCY_LIMIT_REPORTS", "-1")
+	if _, err := bulkheadLimits(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestBulkhead(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	handler := newBulkhead(log.NewNopLogger(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/accounts/1/transactions" {
+			started <- struct{}{}
+			<-release
+		}
+		w.WriteHeader(http.StatusOK)
+	}), map[string]int{routeGroupReports: 1}, 10*time.Millisecond)
+
+	// Occupy the only report slot
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/accounts/1/transactions", nil))
+		done <- w.Code
+	}()
+	<-started
+
+	// Another report is shed after waiting
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/accounts/2/transactions", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+		t.Errorf("got %d", w.Code)
+	}
+
+	// Postings aren't affected
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/accounts/transactions", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("got %d", code)
+	}
+}
@@ -0,0 +1,315 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+var (
+	errChartNodeNotFound    = errors.New("chart node not found")
+	errChartAccountAssigned = errors.New("an account is already under another chart node")
+)
+
+// chartNode groups internal (GL) accounts in a chart of accounts. Nodes can be nested under a parent node, such as
+// "Operating Expenses" under "Expenses", and an account sits under at most one node so roll-ups never count it twice.
+type chartNode struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	ParentID     string    `json:"parentId,omitempty"`
+	AccountIDs   []string  `json:"accountIds"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+type saveChartNodeRequest struct {
+	Name       string   `json:"name"`
+	ParentID   string   `json:"parentId"`
+	AccountIDs []string `json:"accountIds"`
+}
+
+// chartAccountBalance is an account's balances in a roll-up.
+type chartAccountBalance struct {
+	AccountID        string `json:"accountId"`
+	Name             string `json:"name"`
+	Balance          int64  `json:"balance"`
+	BalanceAvailable int64  `json:"balanceAvailable"`
+	BalancePending   int64  `json:"balancePending"`
+}
+
+// chartRollup totals the balances of a node's accounts and every node nested under it.
+type chartRollup struct {
+	NodeID           string                `json:"nodeId"`
+	Name             string                `json:"name"`
+	Balance          int64                 `json:"balance"`
+	BalanceAvailable int64                 `json:"balanceAvailable"`
+	BalancePending   int64                 `json:"balancePending"`
+	Accounts         []chartAccountBalance `json:"accounts"`
+	Children         []*chartRollup        `json:"children"`
+}
+
+type chartService struct {
+	logger   log.Logger
+	repo     chartRepository
+	accounts accountRepository
+}
+
+// SaveNode creates a node when nodeID is empty, otherwise it replaces the node's name, parent and accounts.
+func (s *chartService) SaveNode(ctx context.Context, nodeID string, req saveChartNodeRequest) (*chartNode, error) {
+	if req.Name = strings.TrimSpace(req.Name); req.Name == "" {
+		return nil, errors.New("chart node: missing name")
+	}
+	accountIDs, seen := []string{}, make(map[string]bool)
+	for _, id := range req.AccountIDs {
+		if id != "" && !seen[id] {
+			accountIDs, seen[id] = append(accountIDs, id), true
+		}
+	}
+	found, err := s.accounts.GetAccounts(ctx, accountIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, acct := range found {
+		delete(seen, acct.ID)
+	}
+	for _, id := range accountIDs {
+		if seen[id] {
+			return nil, fmt.Errorf("chart node: account=%s not found", id)
+		}
+	}
+
+	nodes, err := s.repo.getNodes()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]*chartNode)
+	for _, n := range nodes {
+		byID[n.ID] = n
+	}
+	// Walk up from the new parent to make sure the node wouldn't be nested under itself.
+	for parentID := req.ParentID; parentID != ""; parentID = byID[parentID].ParentID {
+		if parentID == nodeID {
+			return nil, errors.New("chart node: can't be nested under itself")
+		}
+		if byID[parentID] == nil {
+			return nil, fmt.Errorf("chart node: parent=%s not found", parentID)
+		}
+	}
+
+	now := time.Now()
+	node := &chartNode{ID: nodeID, Name: req.Name, ParentID: req.ParentID, AccountIDs: accountIDs, CreatedAt: now, LastModified: now}
+	if nodeID == "" {
+		node.ID = newID()
+		if err := s.repo.createNode(node); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	existing := byID[nodeID]
+	if existing == nil {
+		return nil, errChartNodeNotFound
+	}
+	node.CreatedAt = existing.CreatedAt
+	if ok, err := s.repo.updateNode(node); err != nil || !ok {
+		if err == nil {
+			err = errChartNodeNotFound
+		}
+		return nil, err
+	}
+	return node, nil
+}
+
+// DeleteNode removes a node which doesn't have any nodes nested under it. Its accounts are left alone.
+func (s *chartService) DeleteNode(ctx context.Context, nodeID string) error {
+	nodes, err := s.repo.getNodes()
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		if n.ParentID == nodeID {
+			return fmt.Errorf("chart node: node=%s has nested nodes", nodeID)
+		}
+	}
+	found, err := s.repo.deleteNode(nodeID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errChartNodeNotFound
+	}
+	return nil
+}
+
+// Rollup returns the balances of a node's accounts and nested nodes with subtotals at every level.
+func (s *chartService) Rollup(ctx context.Context, nodeID string) (*chartRollup, error) {
+	nodes, err := s.repo.getNodes()
+	if err != nil {
+		return nil, err
+	}
+	var root *chartNode
+	children := make(map[string][]*chartNode)
+	for _, n := range nodes {
+		if n.ID == nodeID {
+			root = n
+		}
+		children[n.ParentID] = append(children[n.ParentID], n)
+	}
+	if root == nil {
+		return nil, errChartNodeNotFound
+	}
+
+	// Read every account under the node at once
+	var accountIDs []string
+	var collect func(n *chartNode)
+	collect = func(n *chartNode) {
+		accountIDs = append(accountIDs, n.AccountIDs...)
+		for _, child := range children[n.ID] {
+			collect(child)
+		}
+	}
+	collect(root)
+	found, err := s.accounts.GetAccounts(ctx, accountIDs)
+	if err != nil {
+		return nil, err
+	}
+	balances := make(map[string]chartAccountBalance)
+	for _, acct := range found {
+		balances[acct.ID] = chartAccountBalance{
+			AccountID:        acct.ID,
+			Name:             acct.Name,
+			Balance:          int64(acct.Balance),
+			BalanceAvailable: int64(acct.BalanceAvailable),
+			BalancePending:   int64(acct.BalancePending),
+		}
+	}
+
+	var rollup func(n *chartNode) *chartRollup
+	rollup = func(n *chartNode) *chartRollup {
+		out := &chartRollup{NodeID: n.ID, Name: n.Name, Accounts: []chartAccountBalance{}, Children: []*chartRollup{}}
+		for _, id := range n.AccountIDs {
+			if b, ok := balances[id]; ok { // deleted accounts are skipped
+				out.Accounts = append(out.Accounts, b)
+				out.Balance += b.Balance
+				out.BalanceAvailable += b.BalanceAvailable
+				out.BalancePending += b.BalancePending
+			}
+		}
+		for _, child := range children[n.ID] {
+			sub := rollup(child)
+			out.Children = append(out.Children, sub)
+			out.Balance += sub.Balance
+			out.BalanceAvailable += sub.BalanceAvailable
+			out.BalancePending += sub.BalancePending
+		}
+		return out
+	}
+	return rollup(root), nil
+}
+
+// chartNodes is an admin route which lists the chart of accounts (GET) or adds a node to it (POST).
+func chartNodes(logger log.Logger, svc *chartService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			nodes, err := svc.repo.getNodes()
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if nodes == nil {
+				nodes = []*chartNode{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(nodes)
+
+		case "POST":
+			saveChartNode(logger, svc, w, r, "")
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// chartNodeRoute is an admin route which replaces (PUT) or removes (DELETE) a node.
+func chartNodeRoute(logger log.Logger, svc *chartService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		nodeID := mux.Vars(r)["nodeId"]
+		switch r.Method {
+		case "PUT":
+			saveChartNode(logger, svc, w, r, nodeID)
+
+		case "DELETE":
+			if err := svc.DeleteNode(requestContext(r), nodeID); err != nil {
+				if err == errChartNodeNotFound {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("chart", fmt.Sprintf("deleted node=%s", nodeID), "userID", moovhttp.GetUserID(r))
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func saveChartNode(logger log.Logger, svc *chartService, w http.ResponseWriter, r *http.Request, nodeID string) {
+	var req saveChartNodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		moovhttp.Problem(w, err)
+		return
+	}
+	node, err := svc.SaveNode(requestContext(r), nodeID, req)
+	if err != nil {
+		if err == errChartNodeNotFound {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		moovhttp.Problem(w, err)
+		return
+	}
+	logger.Log("chart", fmt.Sprintf("saved node=%s with %d accounts", node.ID, len(node.AccountIDs)), "userID", moovhttp.GetUserID(r))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(node)
+}
+
+// chartNodeBalances is an admin route which returns a node's rolled-up balances.
+func chartNodeBalances(logger log.Logger, svc *chartService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		rollup, err := svc.Rollup(requestContext(r), mux.Vars(r)["nodeId"])
+		if err != nil {
+			if err == errChartNodeNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(rollup)
+	}
+}
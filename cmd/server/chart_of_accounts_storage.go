@@ -0,0 +1,173 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+type chartRepository interface {
+	Ping() error
+	Close() error
+
+	// createNode returns errChartAccountAssigned if one of the node's accounts is already under another node.
+	createNode(node *chartNode) error
+
+	// updateNode replaces a node's name, parent and accounts, returning false if it doesn't exist.
+	updateNode(node *chartNode) (bool, error)
+
+	// deleteNode returns false if the node doesn't exist.
+	deleteNode(nodeID string) (bool, error)
+
+	// getNodes returns every node and its accounts ordered by name.
+	getNodes() ([]*chartNode, error)
+}
+
+type sqlChartRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlChartRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlChartRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlChartRepository) createNode(node *chartNode) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("createNode: begin: %v", err)
+	}
+	var parentID *string
+	if node.ParentID != "" {
+		parentID = &node.ParentID
+	}
+	query := `insert into chart_nodes (node_id, name, parent_id, created_at, last_modified) values (?, ?, ?, ?, ?);`
+	if _, err := tx.Exec(query, node.ID, node.Name, parentID, node.CreatedAt, node.LastModified); err != nil {
+		return fmt.Errorf("createNode: node=%s: %v rollback=%v", node.ID, err, tx.Rollback())
+	}
+	if err := r.insertNodeAccounts(tx, node); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("createNode: commit: %v", err)
+	}
+	return nil
+}
+
+func (r *sqlChartRepository) updateNode(node *chartNode) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("updateNode: begin: %v", err)
+	}
+	var parentID *string
+	if node.ParentID != "" {
+		parentID = &node.ParentID
+	}
+	query := `update chart_nodes set name = ?, parent_id = ?, last_modified = ? where node_id = ?;`
+	res, err := tx.Exec(query, node.Name, parentID, node.LastModified, node.ID)
+	if err != nil {
+		return false, fmt.Errorf("updateNode: node=%s: %v rollback=%v", node.ID, err, tx.Rollback())
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return false, tx.Rollback()
+	}
+	if _, err := tx.Exec(`delete from chart_node_accounts where node_id = ?;`, node.ID); err != nil {
+		return false, fmt.Errorf("updateNode: node=%s: %v rollback=%v", node.ID, err, tx.Rollback())
+	}
+	if err := r.insertNodeAccounts(tx, node); err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("updateNode: commit: %v", err)
+	}
+	return true, nil
+}
+
+// insertNodeAccounts saves the node's accounts, rolling back tx on errors.
+func (r *sqlChartRepository) insertNodeAccounts(tx *sql.Tx, node *chartNode) error {
+	for _, accountID := range node.AccountIDs {
+		if _, err := tx.Exec(`insert into chart_node_accounts (node_id, account_id) values (?, ?);`, node.ID, accountID); err != nil {
+			if database.UniqueViolation(err) {
+				tx.Rollback()
+				return errChartAccountAssigned
+			}
+			return fmt.Errorf("insertNodeAccounts: node=%s account=%s: %v rollback=%v", node.ID, accountID, err, tx.Rollback())
+		}
+	}
+	return nil
+}
+
+func (r *sqlChartRepository) deleteNode(nodeID string) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("deleteNode: begin: %v", err)
+	}
+	res, err := tx.Exec(`delete from chart_nodes where node_id = ?;`, nodeID)
+	if err != nil {
+		return false, fmt.Errorf("deleteNode: node=%s: %v rollback=%v", nodeID, err, tx.Rollback())
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return false, tx.Rollback()
+	}
+	if _, err := tx.Exec(`delete from chart_node_accounts where node_id = ?;`, nodeID); err != nil {
+		return false, fmt.Errorf("deleteNode: node=%s: %v rollback=%v", nodeID, err, tx.Rollback())
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("deleteNode: commit: %v", err)
+	}
+	return true, nil
+}
+
+func (r *sqlChartRepository) getNodes() ([]*chartNode, error) {
+	rows, err := r.db.Query(`select node_id, name, parent_id, created_at, last_modified from chart_nodes order by name, node_id;`)
+	if err != nil {
+		return nil, fmt.Errorf("getNodes: %v", err)
+	}
+	defer rows.Close()
+
+	var out []*chartNode
+	byID := make(map[string]*chartNode)
+	for rows.Next() {
+		var node chartNode
+		var parentID *string
+		if err := rows.Scan(&node.ID, &node.Name, &parentID, &node.CreatedAt, &node.LastModified); err != nil {
+			return nil, fmt.Errorf("getNodes: scan: %v", err)
+		}
+		if parentID != nil {
+			node.ParentID = *parentID
+		}
+		node.AccountIDs = []string{}
+		out = append(out, &node)
+		byID[node.ID] = &node
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("getNodes: %v", err)
+	}
+
+	accountRows, err := r.db.Query(`select node_id, account_id from chart_node_accounts order by account_id;`)
+	if err != nil {
+		return nil, fmt.Errorf("getNodes: accounts: %v", err)
+	}
+	defer accountRows.Close()
+	for accountRows.Next() {
+		var nodeID, accountID string
+		if err := accountRows.Scan(&nodeID, &accountID); err != nil {
+			return nil, fmt.Errorf("getNodes: accounts scan: %v", err)
+		}
+		if node, ok := byID[nodeID]; ok {
+			node.AccountIDs = append(node.AccountIDs, accountID)
+		}
+	}
+	return out, accountRows.Err()
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestChartOfAccounts(t *testing.T) {
+	check := func(t *testing.T, repo *sqlChartRepository) {
+		accountRepo, _ := createTestLedger(t, map[string]int{"rent": 1200, "payroll": 5000, "software": 300, "cash": 9000})
+
+		svc := &chartService{logger: log.NewNopLogger(), repo: repo, accounts: accountRepo}
+		router := mux.NewRouter()
+		router.HandleFunc("/chart/nodes", chartNodes(log.NewNopLogger(), svc))
+		router.HandleFunc("/chart/nodes/{nodeId}", chartNodeRoute(log.NewNopLogger(), svc))
+		router.HandleFunc("/chart/nodes/{nodeId}/balances", chartNodeBalances(log.NewNopLogger(), svc))
+		serve := func(method, path, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set("x-user-id", "controller")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			w.Flush()
+			return w
+		}
+		save := func(method, path, body string) *chartNode {
+			t.Helper()
+			w := serve(method, path, body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+			}
+			var node chartNode
+			if err := json.NewDecoder(w.Body).Decode(&node); err != nil {
+				t.Fatal(err)
+			}
+			return &node
+		}
+
+		expenses := save("POST", "/chart/nodes", `{"name": "Expenses"}`)
+		operating := save("POST", "/chart/nodes", fmt.Sprintf(`{"name": "Operating", "parentId": %q, "accountIds": ["rent", "software", "rent"]}`, expenses.ID))
+		people := save("POST", "/chart/nodes", fmt.Sprintf(`{"name": "People", "parentId": %q, "accountIds": ["payroll"]}`, expenses.ID))
+		if len(operating.AccountIDs) != 2 {
+			t.Errorf("unexpected node: %#v", operating)
+		}
+
+		// accounts sit under one node, parents must exist and nodes can't be nested under themselves
+		for _, body := range []string{
+			`{"name": "Facilities", "accountIds": ["rent"]}`,
+			`{"name": "Facilities", "accountIds": ["missing"]}`,
+			`{"name": "Facilities", "parentId": "missing"}`,
+			`{"name": " "}`,
+		} {
+			if w := serve("POST", "/chart/nodes", body); w.Code != http.StatusBadRequest {
+				t.Errorf("%s: bogus HTTP status: %d", body, w.Code)
+			}
+		}
+		if w := serve("PUT", "/chart/nodes/"+expenses.ID, fmt.Sprintf(`{"name": "Expenses", "parentId": %q}`, operating.ID)); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+
+		var rollup chartRollup
+		if err := json.NewDecoder(serve("GET", "/chart/nodes/"+expenses.ID+"/balances", "").Body).Decode(&rollup); err != nil {
+			t.Fatal(err)
+		}
+		if rollup.Balance != 6500 || len(rollup.Children) != 2 || len(rollup.Accounts) != 0 {
+			t.Errorf("unexpected rollup: %#v", rollup)
+		}
+		if sub := rollup.Children[0]; sub.NodeID != operating.ID || sub.Balance != 1500 || len(sub.Accounts) != 2 {
+			t.Errorf("unexpected rollup: %#v", sub)
+		}
+
+		// moving software under People changes the subtotals but not the total
+		save("PUT", "/chart/nodes/"+operating.ID, fmt.Sprintf(`{"name": "Operating", "parentId": %q, "accountIds": ["rent"]}`, expenses.ID))
+		save("PUT", "/chart/nodes/"+people.ID, fmt.Sprintf(`{"name": "People", "parentId": %q, "accountIds": ["payroll", "software"]}`, expenses.ID))
+		if err := json.NewDecoder(serve("GET", "/chart/nodes/"+people.ID+"/balances", "").Body).Decode(&rollup); err != nil {
+			t.Fatal(err)
+		}
+		if rollup.Balance != 5300 || len(rollup.Accounts) != 2 {
+			t.Errorf("unexpected rollup: %#v", rollup)
+		}
+
+		if w := serve("DELETE", "/chart/nodes/"+expenses.ID, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if w := serve("DELETE", "/chart/nodes/"+operating.ID, ""); w.Code != http.StatusOK {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		for _, path := range []string{"/chart/nodes/" + operating.ID, "/chart/nodes/" + operating.ID + "/balances"} {
+			method := "DELETE"
+			if strings.HasSuffix(path, "balances") {
+				method = "GET"
+			}
+			if w := serve(method, path, ""); w.Code != http.StatusNotFound {
+				t.Errorf("%s %s: bogus HTTP status: %d", method, path, w.Code)
+			}
+		}
+		var nodes []*chartNode
+		if err := json.NewDecoder(serve("GET", "/chart/nodes", "").Body).Decode(&nodes); err != nil || len(nodes) != 2 {
+			t.Errorf("nodes=%#v error=%v", nodes, err)
+		}
+		// rent can be placed under another node once its node is deleted
+		save("POST", "/chart/nodes", `{"name": "Facilities", "accountIds": ["rent"]}`)
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlChartRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlChartRepository{mysqlDB.DB, log.NewNopLogger()})
+}
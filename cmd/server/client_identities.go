@@ -0,0 +1,157 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// clientIdentity is a partner connecting with a client certificate. The certificate's subject alternative names
+// (SANs) identify the partner, which is isolated to its tenant and the route groups it's permitted.
+type clientIdentity struct {
+	SAN         string
+	Tenant      string
+	Permissions map[string]bool
+}
+
+func (id *clientIdentity) allows(group string) bool {
+	return id.Permissions["*"] || id.Permissions[group]
+}
+
+// readClientIdentities parses MTLS_CLIENT_IDENTITIES, a comma separated list of san=tenant:permissions where the SAN
+// is a DNS name, URI (such as a SPIFFE ID), email address or IP address from the client certificate and permissions
+// are written as they are for REQUEST_SIGNING_KEYS (e.g. partner.example.com=acme:postings+reads).
+func readClientIdentities() (map[string]*clientIdentity, error) {
+	identities := make(map[string]*clientIdentity)
+	for _, v := range strings.Split(os.Getenv("MTLS_CLIENT_IDENTITIES"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		eq := strings.LastIndex(v, "=")
+		colon := strings.LastIndex(v, ":")
+		if eq <= 0 || colon < eq+2 || colon == len(v)-1 {
+			return nil, fmt.Errorf("MTLS_CLIENT_IDENTITIES: invalid identity %q, expected san=tenant:permissions", v)
+		}
+		id := &clientIdentity{SAN: v[:eq], Tenant: v[eq+1 : colon], Permissions: make(map[string]bool)}
+		for _, perm := range strings.Split(v[colon+1:], "+") {
+			switch perm {
+			case "*", routeGroupPostings, routeGroupReads, routeGroupReports:
+				id.Permissions[perm] = true
+			default:
+				return nil, fmt.Errorf("MTLS_CLIENT_IDENTITIES: %s has unknown permission %q", id.SAN, perm)
+			}
+		}
+		if _, exists := identities[id.SAN]; exists {
+			return nil, fmt.Errorf("MTLS_CLIENT_IDENTITIES: duplicate SAN %q", id.SAN)
+		}
+		identities[id.SAN] = id
+	}
+	return identities, nil
+}
+
+// setupClientAuth requires callers of the HTTP server to present a client certificate signed by one of the CAs in
+// HTTPS_CLIENT_CA_FILE. mTLS is only enabled alongside HTTPS_CERT_FILE and HTTPS_KEY_FILE.
+func setupClientAuth(cfg *tls.Config, identities map[string]*clientIdentity) error {
+	path := os.Getenv("HTTPS_CLIENT_CA_FILE")
+	if path == "" {
+		if len(identities) > 0 {
+			return errors.New("MTLS_CLIENT_IDENTITIES requires HTTPS_CLIENT_CA_FILE")
+		}
+		return nil
+	}
+	if len(identities) == 0 {
+		return errors.New("HTTPS_CLIENT_CA_FILE requires MTLS_CLIENT_IDENTITIES")
+	}
+	bs, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("HTTPS_CLIENT_CA_FILE: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bs) {
+		return fmt.Errorf("HTTPS_CLIENT_CA_FILE: no certificates found in %s", path)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return nil
+}
+
+// certificateSANs returns every subject alternative name of a certificate.
+func certificateSANs(cert *x509.Certificate) []string {
+	var out []string
+	out = append(out, cert.DNSNames...)
+	for i := range cert.URIs {
+		out = append(out, cert.URIs[i].String())
+	}
+	out = append(out, cert.EmailAddresses...)
+	for i := range cert.IPAddresses {
+		out = append(out, cert.IPAddresses[i].String())
+	}
+	return out
+}
+
+// clientIdentities maps verified client certificates to their tenant before passing requests to next. The
+// certificate's identity replaces any X-User-ID and X-Tenant-ID sent by the caller, so a partner can't reach
+// another tenant's data by setting headers. Requests without a verified certificate are passed through untouched,
+// while certificates which aren't mapped to a tenant (including every certificate when none are) are rejected.
+type clientIdentities struct {
+	logger     log.Logger
+	identities map[string]*clientIdentity
+	next       http.Handler
+}
+
+func newClientIdentities(logger log.Logger, identities map[string]*clientIdentity, next http.Handler) http.Handler {
+	return &clientIdentities{logger: logger, identities: identities, next: next}
+}
+
+func (c *clientIdentities) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+
+	var id *clientIdentity
+	sans := certificateSANs(cert)
+	for i := range sans {
+		if id = c.identities[sans[i]]; id != nil {
+			break
+		}
+	}
+	if id == nil {
+		c.reject(r, fmt.Sprintf("certificate isn't mapped to a tenant: subject=%q sans=%v", cert.Subject.String(), sans))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if group := routeGroup(r); !id.allows(group) {
+		c.reject(r, fmt.Sprintf("%s isn't permitted to call %s routes", id.SAN, group))
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	r.Header.Set("X-User-ID", id.SAN)
+	r.Header.Set("X-Tenant-ID", id.Tenant)
+	c.next.ServeHTTP(w, r)
+}
+
+func (c *clientIdentities) reject(r *http.Request, reason string) {
+	c.logger.Log(
+		"audit", fmt.Sprintf("rejected client certificate: %s", reason),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"remoteAddr", r.RemoteAddr,
+		"requestID", moovhttp.GetRequestID(r),
+	)
+}
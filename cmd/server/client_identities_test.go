@@ -0,0 +1,201 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestClientIdentities__readClientIdentities(t *testing.T) {
+	os.Setenv("MTLS_CLIENT_IDENTITIES", "partner.example.com=acme:postings+reads, spiffe://example.com/ns/payroll=globex:*")
+	defer os.Unsetenv("MTLS_CLIENT_IDENTITIES")
+
+	identities, err := readClientIdentities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := identities["partner.example.com"]; id == nil || id.Tenant != "acme" || !id.allows(routeGroupPostings) || id.allows(routeGroupReports) {
+		t.Errorf("unexpected identity: %#v", id)
+	}
+	if id := identities["spiffe://example.com/ns/payroll"]; id == nil || id.Tenant != "globex" || !id.allows(routeGroupReports) {
+		t.Errorf("unexpected identity: %#v", id)
+	}
+
+	for _, v := range []string{"partner.example.com", "partner.example.com=acme", "partner.example.com=:reads", "=acme:reads", "partner.example.com=acme:writes", "a=b:reads,a=c:reads"} {
+		os.Setenv("MTLS_CLIENT_IDENTITIES", v)
+		if _, err := readClientIdentities(); err == nil || !strings.Contains(err.Error(), "MTLS_CLIENT_IDENTITIES") {
+			t.Errorf("%s: unexpected error: %v", v, err)
+		}
+	}
+}
+
+type testCertificateAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCertificateAuthority(t *testing.T) *testCertificateAuthority {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCertificateAuthority{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue returns a client certificate for the DNS and URI SANs given.
+func (ca *testCertificateAuthority) issue(t *testing.T, dnsNames []string, uris ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		DNSNames:     dnsNames,
+	}
+	for i := range uris {
+		u, _ := url.Parse(uris[i])
+		tpl.URIs = append(tpl.URIs, u)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientIdentities__mTLS(t *testing.T) {
+	ca := newTestCertificateAuthority(t)
+	f, err := ioutil.TempFile("", "accounts-client-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(ca.pem)
+	f.Close()
+
+	os.Setenv("HTTPS_CLIENT_CA_FILE", f.Name())
+	defer os.Unsetenv("HTTPS_CLIENT_CA_FILE")
+
+	identities := map[string]*clientIdentity{
+		"partner.example.com":             {SAN: "partner.example.com", Tenant: "acme", Permissions: map[string]bool{routeGroupReads: true}},
+		"spiffe://example.com/ns/payroll": {SAN: "spiffe://example.com/ns/payroll", Tenant: "globex", Permissions: map[string]bool{"*": true}},
+	}
+	var buf bytes.Buffer
+	server := httptest.NewUnstartedServer(newClientIdentities(log.NewLogfmtLogger(&buf), identities, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-User-ID", r.Header.Get("X-User-ID"))
+		w.Header().Set("X-Tenant-ID", r.Header.Get("X-Tenant-ID"))
+	})))
+	server.TLS = &tls.Config{}
+	if err := setupClientAuth(server.TLS, identities); err != nil {
+		t.Fatal(err)
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	call := func(cert *tls.Certificate, method, path string) (*http.Response, error) {
+		transport := server.Client().Transport.(*http.Transport).Clone()
+		if cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		req.Header.Set("X-Tenant-ID", "spoofed")
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		return resp, err
+	}
+
+	// partners are identified by their certificate's SANs and isolated to their tenant
+	partner := ca.issue(t, []string{"partner.example.com"})
+	resp, err := call(&partner, "GET", "/accounts/search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Tenant-ID") != "acme" || resp.Header.Get("X-User-ID") != "partner.example.com" {
+		t.Errorf("got %d tenant=%q userID=%q", resp.StatusCode, resp.Header.Get("X-Tenant-ID"), resp.Header.Get("X-User-ID"))
+	}
+	if resp, err := call(&partner, "POST", "/accounts/transactions"); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("posting: resp=%v error=%v", resp, err)
+	}
+
+	payroll := ca.issue(t, nil, "spiffe://example.com/ns/payroll")
+	if resp, err := call(&payroll, "POST", "/accounts/transactions"); err != nil || resp.StatusCode != http.StatusOK || resp.Header.Get("X-Tenant-ID") != "globex" {
+		t.Errorf("payroll: resp=%v error=%v", resp, err)
+	}
+
+	// certificates without a mapped SAN are rejected
+	unknown := ca.issue(t, []string{"other.example.com"})
+	if resp, err := call(&unknown, "GET", "/accounts/search"); err != nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unknown: resp=%v error=%v", resp, err)
+	}
+	if !strings.Contains(buf.String(), "certificate isn't mapped to a tenant") {
+		t.Errorf("unexpected audit log: %s", buf.String())
+	}
+
+	// connections without a certificate, or one from another CA, fail the handshake
+	if _, err := call(nil, "GET", "/accounts/search"); err == nil {
+		t.Error("expected handshake error without a client certificate")
+	}
+	other := newTestCertificateAuthority(t).issue(t, []string{"partner.example.com"})
+	if _, err := call(&other, "GET", "/accounts/search"); err == nil {
+		t.Error("expected handshake error with an untrusted certificate")
+	}
+}
+
+func TestClientIdentities__setupClientAuth(t *testing.T) {
+	identities := map[string]*clientIdentity{"partner.example.com": {SAN: "partner.example.com", Tenant: "acme"}}
+	if err := setupClientAuth(&tls.Config{}, identities); err == nil || !strings.Contains(err.Error(), "HTTPS_CLIENT_CA_FILE") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Setenv("HTTPS_CLIENT_CA_FILE", "/does/not/exist.pem")
+	defer os.Unsetenv("HTTPS_CLIENT_CA_FILE")
+	if err := setupClientAuth(&tls.Config{}, nil); err == nil || !strings.Contains(err.Error(), "MTLS_CLIENT_IDENTITIES") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := setupClientAuth(&tls.Config{}, identities); err == nil {
+		t.Error("expected error")
+	}
+}
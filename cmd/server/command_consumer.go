@@ -0,0 +1,152 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/go-kit/kit/log"
+)
+
+// commandResult is what happened to a posting command read from the command queue.
+type commandResult string
+
+const (
+	// commandPosted means the transaction was created, or already existed from an earlier delivery
+	commandPosted commandResult = "posted"
+
+	// commandRejected means the command can never succeed (e.g. it's malformed or the account lacks funds)
+	commandRejected commandResult = "rejected"
+
+	// commandRetry means posting failed for a reason which might clear up, so the command is left on the queue
+	commandRetry commandResult = "retry"
+)
+
+// rejectedCommand is the data of the transaction.rejected event emitted for commands which can't be posted.
+type rejectedCommand struct {
+	TransactionID string `json:"transactionId,omitempty"`
+	Error         string `json:"error"`
+}
+
+// sqsCommandConsumer reads posting commands from an SQS queue and posts them as transactions. Each message body is
+// a createTransactionRequest which must include its ID, so a command delivered more than once is only posted once.
+//
+// Posted commands emit the usual transaction events and rejected commands emit a transaction.rejected event. Both are
+// then deleted from the queue. Commands which fail because of storage problems are left on the queue to be received
+// again, so a redrive policy on the queue decides when they're moved to a dead-letter queue.
+type sqsCommandConsumer struct {
+	logger   log.Logger
+	client   sqsiface.SQSAPI
+	queueURL string
+
+	svc    *transactionService
+	events eventPublisher
+}
+
+// setupCommandConsumer returns a consumer of COMMAND_QUEUE_URL, or nil if it isn't set.
+func setupCommandConsumer(logger log.Logger, svc *transactionService, events eventPublisher) (*sqsCommandConsumer, error) {
+	queueURL := os.Getenv("COMMAND_QUEUE_URL")
+	if queueURL == "" {
+		return nil, nil
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, fmt.Errorf("commands: aws session: %v", err)
+	}
+	return &sqsCommandConsumer{
+		logger:   logger,
+		client:   sqs.New(sess),
+		queueURL: queueURL,
+		svc:      svc,
+		events:   events,
+	}, nil
+}
+
+func (c *sqsCommandConsumer) run(ctx context.Context) {
+	c.logger.Log("commands", fmt.Sprintf("consuming posting commands from %s", c.queueURL))
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		out, err := c.client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(c.queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Log("commands", fmt.Sprintf("problem receiving commands: %v", err))
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		for _, msg := range out.Messages {
+			if c.handle(ctx, aws.StringValue(msg.Body)) == commandRetry {
+				continue
+			}
+			_, err := c.client.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(c.queueURL),
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+			if err != nil {
+				c.logger.Log("commands", fmt.Sprintf("problem deleting message=%s: %v", aws.StringValue(msg.MessageId), err))
+			}
+		}
+	}
+}
+
+// handle posts the transaction described by body.
+func (c *sqsCommandConsumer) handle(ctx context.Context, body string) commandResult {
+	var req createTransactionRequest
+	if err := json.NewDecoder(strings.NewReader(body)).Decode(&req); err != nil {
+		return c.reject("", fmt.Errorf("invalid command: %v", err))
+	}
+	if req.ID == "" {
+		return c.reject("", errors.New("commands require an id"))
+	}
+	transactionID, err := req.transactionID()
+	if err != nil {
+		return c.reject(req.ID, err)
+	}
+	if err := req.asTransaction(transactionID).validate(); err != nil {
+		return c.reject(transactionID, err)
+	}
+
+	// Skip commands which were already posted
+	if tx, err := c.svc.GetTransaction(ctx, transactionID); err == nil && tx != nil {
+		return commandPosted
+	}
+
+	if _, err := c.svc.CreateTransaction(ctx, req); err != nil {
+		switch {
+		case strings.Contains(err.Error(), errDuplicateTransactionID.Error()):
+			return commandPosted
+		case classifyStorageError(err) == storageErrorCommit, c.svc.repo.Ping() != nil:
+			c.logger.Log("commands", fmt.Sprintf("retrying transaction=%s: %v", transactionID, err))
+			return commandRetry
+		}
+		return c.reject(transactionID, err)
+	}
+	return commandPosted
+}
+
+func (c *sqsCommandConsumer) reject(transactionID string, err error) commandResult {
+	c.logger.Log("commands", fmt.Sprintf("rejected transaction=%s: %v", transactionID, err))
+	evt := newEvent("transaction.rejected", rejectedCommand{TransactionID: transactionID, Error: err.Error()})
+	if err := c.events.publish(evt); err != nil {
+		c.logger.Log("commands", fmt.Sprintf("problem publishing rejection of transaction=%s: %v", transactionID, err))
+	}
+	return commandRejected
+}
@@ -0,0 +1,139 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/go-kit/kit/log"
+)
+
+// testUUID returns a random ID formatted as a UUID, which posting commands require.
+func testUUID() string {
+	id := base.ID()
+	return fmt.Sprintf("%s-%s-%s-%s-%s", id[:8], id[8:12], id[12:16], id[16:20], id[20:32])
+}
+
+func TestSQSCommandConsumer__handle(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	repo := createTestSqlTransactionRepository(t, db.DB)
+	external, internal := base.ID(), base.ID()
+	repo.accountRepo = &testAccountRepository{
+		accounts: []*accounts.Account{
+			{ID: external, AccountNumber: "123", RoutingNumber: "121042882"},
+			{ID: internal, AccountNumber: "432", RoutingNumber: defaultRoutingNumber},
+		},
+	}
+	events := &mockEventPublisher{}
+	consumer := &sqsCommandConsumer{
+		logger: log.NewNopLogger(),
+		svc:    &transactionService{logger: log.NewNopLogger(), repo: repo, events: events},
+		events: events,
+	}
+	command := func(transactionID, debit, credit string) string {
+		return fmt.Sprintf(`{"id": %q, "lines": [{"accountId": %q, "purpose": "ACHDebit", "amount": 500}, {"accountId": %q, "purpose": "ACHCredit", "amount": 500}]}`,
+			transactionID, debit, credit)
+	}
+	ctx := context.Background()
+
+	// post a transaction, then receive it again
+	transactionID := testUUID()
+	for i := 0; i < 2; i++ {
+		if result := consumer.handle(ctx, command(transactionID, external, internal)); result != commandPosted {
+			t.Fatalf("attempt %d: got %s", i, result)
+		}
+	}
+	if transactions, _, err := repo.getAccountTransactions(context.Background(), internal, transactionPage{}); err != nil || len(transactions) != 1 {
+		t.Errorf("transactions=%#v error=%v", transactions, err)
+	}
+	if len(events.events) != 1 || events.events[0].Type != "transaction.posted" {
+		t.Errorf("unexpected events: %#v", events.events)
+	}
+
+	// rejected commands
+	for _, body := range []string{
+		`{"lines": []`, // malformed
+		command("", external, internal),
+		command("not-a-uuid", external, internal),
+		command(testUUID(), internal, external), // insufficient funds
+	} {
+		if result := consumer.handle(ctx, body); result != commandRejected {
+			t.Errorf("got %s for %s", result, body)
+		}
+	}
+	if len(events.events) != 5 || events.events[4].Type != "transaction.rejected" {
+		t.Errorf("unexpected events: %#v", events.events)
+	}
+
+	// storage problems are retried
+	repo.db.Close()
+	if result := consumer.handle(ctx, command(testUUID(), external, internal)); result != commandRetry {
+		t.Errorf("got %s", result)
+	}
+}
+
+type mockCommandQueue struct {
+	mockSQS
+
+	cancel  func()
+	bodies  []string
+	deleted []string
+}
+
+func (q *mockCommandQueue) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	if len(q.bodies) == 0 {
+		q.cancel()
+		return nil, ctx.Err()
+	}
+	out := &sqs.ReceiveMessageOutput{}
+	for i := range q.bodies {
+		out.Messages = append(out.Messages, &sqs.Message{
+			MessageId:     aws.String(fmt.Sprintf("%d", i)),
+			ReceiptHandle: aws.String(fmt.Sprintf("receipt-%d", i)),
+			Body:          aws.String(q.bodies[i]),
+		})
+	}
+	q.bodies = nil
+	return out, nil
+}
+
+func (q *mockCommandQueue) DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	q.deleted = append(q.deleted, *input.ReceiptHandle)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func TestSQSCommandConsumer__run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	queue := &mockCommandQueue{cancel: cancel, bodies: []string{`{"lines": []`}}
+	events := &mockEventPublisher{}
+	consumer := &sqsCommandConsumer{
+		logger:   log.NewNopLogger(),
+		client:   queue,
+		queueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/commands",
+		svc:      &transactionService{logger: log.NewNopLogger(), repo: &mockTransactionRepository{}, events: events},
+		events:   events,
+	}
+	consumer.run(ctx)
+
+	if len(queue.deleted) != 1 || queue.deleted[0] != "receipt-0" {
+		t.Errorf("unexpected deletes: %v", queue.deleted)
+	}
+	if len(events.events) != 1 || events.events[0].Type != "transaction.rejected" {
+		t.Errorf("unexpected events: %#v", events.events)
+	}
+}
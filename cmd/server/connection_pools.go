@@ -0,0 +1,55 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+// connectionPoolSize reads the maximum open connections from name, returning zero when it's unset.
+func connectionPoolSize(name string) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n, nil
+}
+
+// setupConnectionPools sizes the general connection pool of repo from DB_POOL_SIZE and, when DB_POSTING_POOL_SIZE
+// is set, opens a separate pool of that size reserved for posting transactions. This way heavy reads and reports
+// never hold every connection while money needs to move.
+func setupConnectionPools(ctx context.Context, logger log.Logger, _type string, repo *sqlTransactionRepository) error {
+	size, err := connectionPoolSize("DB_POOL_SIZE")
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		repo.db.SetMaxOpenConns(size)
+	}
+
+	size, err = connectionPoolSize("DB_POSTING_POOL_SIZE")
+	if err != nil || size == 0 {
+		return err
+	}
+	db, err := database.New(ctx, logger, _type)
+	if err != nil {
+		return fmt.Errorf("posting connection pool: %v", err)
+	}
+	db.SetMaxOpenConns(size)
+	repo.usePostingPool(db)
+	logger.Log("main", fmt.Sprintf("reserved %d database connections for postings", size))
+	return nil
+}
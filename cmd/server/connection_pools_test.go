@@ -0,0 +1,78 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestConnectionPools(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounts-pools")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("SQLITE_DB_PATH", filepath.Join(dir, "accounts.db"))
+	os.Setenv("DB_POOL_SIZE", "1")
+	os.Setenv("DB_POSTING_POOL_SIZE", "2")
+	os.Setenv("DATABASE_FOREIGN_KEYS", "false") // lines are of accounts which aren't stored
+	defer os.Unsetenv("SQLITE_DB_PATH")
+	defer os.Unsetenv("DB_POOL_SIZE")
+	defer os.Unsetenv("DB_POSTING_POOL_SIZE")
+	defer os.Unsetenv("DATABASE_FOREIGN_KEYS")
+
+	ctx := context.Background()
+	db, err := database.New(ctx, log.NewNopLogger(), "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := createTestSqlTransactionRepository(t, db)
+	defer repo.Close()
+
+	if err := setupConnectionPools(ctx, log.NewNopLogger(), "sqlite", repo); err != nil {
+		t.Fatal(err)
+	}
+	if repo.postingDB == repo.db || repo.db.Stats().MaxOpenConnections != 1 || repo.postingDB.Stats().MaxOpenConnections != 2 {
+		t.Fatalf("db=%#v postingDB=%#v", repo.db.Stats(), repo.postingDB.Stats())
+	}
+
+	// Hold the only general connection, postings still go through
+	reader, err := repo.db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Rollback()
+
+	tx := transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now(),
+		Lines: []transactionLine{
+			{AccountID: base.ID(), Purpose: ACHDebit, Amount: 500},
+			{AccountID: base.ID(), Purpose: ACHCredit, Amount: 500},
+		},
+	}
+	if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.updateTransactionStatus(tx.ID, TransactionReversed); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("DB_POSTING_POOL_SIZE", "many")
+	if err := setupConnectionPools(ctx, log.NewNopLogger(), "sqlite", repo); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	accounts "github.com/moov-io/accounts/client"
+)
+
+// Amounts are stored as integers in the minor unit of the ledger's currency, such as USD cents. Balance and
+// transaction responses include formatting hints for the currency so clients in each region render amounts the
+// same way without keeping their own tables of currencies.
+
+// currency describes an ISO 4217 currency.
+type currency struct {
+	// Exponent is how many digits of the minor unit follow the decimal separator, such as 2 for USD cents
+	Exponent int
+
+	Symbol string
+
+	// Locale formats the currency when the request doesn't name a supported locale
+	Locale string
+}
+
+var currencies = map[string]currency{
+	"AUD": {Exponent: 2, Symbol: "A$", Locale: "en-AU"},
+	"BRL": {Exponent: 2, Symbol: "R$", Locale: "pt-BR"},
+	"CAD": {Exponent: 2, Symbol: "CA$", Locale: "en-CA"},
+	"CHF": {Exponent: 2, Symbol: "CHF", Locale: "de-CH"},
+	"EUR": {Exponent: 2, Symbol: "€", Locale: "de-DE"},
+	"GBP": {Exponent: 2, Symbol: "£", Locale: "en-GB"},
+	"INR": {Exponent: 2, Symbol: "₹", Locale: "en-IN"},
+	"JPY": {Exponent: 0, Symbol: "¥", Locale: "ja-JP"},
+	"KWD": {Exponent: 3, Symbol: "KD", Locale: "ar-KW"},
+	"MXN": {Exponent: 2, Symbol: "MX$", Locale: "es-MX"},
+	"USD": {Exponent: 2, Symbol: "$", Locale: "en-US"},
+}
+
+// localeFormat is how a locale writes amounts of money.
+type localeFormat struct {
+	DecimalSeparator string
+	GroupSeparator   string
+
+	// SymbolAfter places the currency symbol after the amount, separated by a space
+	SymbolAfter bool
+}
+
+var localeFormats = map[string]localeFormat{
+	"ar-KW": {DecimalSeparator: ".", GroupSeparator: ","},
+	"de-CH": {DecimalSeparator: ".", GroupSeparator: "’"},
+	"de-DE": {DecimalSeparator: ",", GroupSeparator: ".", SymbolAfter: true},
+	"en-AU": {DecimalSeparator: ".", GroupSeparator: ","},
+	"en-CA": {DecimalSeparator: ".", GroupSeparator: ","},
+	"en-GB": {DecimalSeparator: ".", GroupSeparator: ","},
+	"en-IN": {DecimalSeparator: ".", GroupSeparator: ","},
+	"en-US": {DecimalSeparator: ".", GroupSeparator: ","},
+	"es-ES": {DecimalSeparator: ",", GroupSeparator: ".", SymbolAfter: true},
+	"es-MX": {DecimalSeparator: ".", GroupSeparator: ","},
+	"fr-CA": {DecimalSeparator: ",", GroupSeparator: " ", SymbolAfter: true},
+	"fr-FR": {DecimalSeparator: ",", GroupSeparator: " ", SymbolAfter: true},
+	"it-IT": {DecimalSeparator: ",", GroupSeparator: ".", SymbolAfter: true},
+	"ja-JP": {DecimalSeparator: ".", GroupSeparator: ","},
+	"nl-NL": {DecimalSeparator: ",", GroupSeparator: "."},
+	"pt-BR": {DecimalSeparator: ",", GroupSeparator: "."},
+}
+
+// ledgerCurrency is the currency of every account's amounts, read from LEDGER_CURRENCY.
+var ledgerCurrency = "USD"
+
+// readLedgerCurrency reads LEDGER_CURRENCY, which defaults to USD.
+func readLedgerCurrency() (string, error) {
+	v := strings.ToUpper(os.Getenv("LEDGER_CURRENCY"))
+	if v == "" {
+		return "USD", nil
+	}
+	if _, ok := currencies[v]; !ok {
+		return "", fmt.Errorf("unsupported LEDGER_CURRENCY %q", v)
+	}
+	return v, nil
+}
+
+// accountCurrency returns the currency of an account's amounts. Accounts don't have their own currency yet, so
+// it's always the ledger's.
+func accountCurrency(acct *accounts.Account) string {
+	return ledgerCurrency
+}
+
+// amountFormatting are the hints for rendering amounts included in balance and transaction responses.
+type amountFormatting struct {
+	Currency         string `json:"currency"`
+	Exponent         int    `json:"exponent"`
+	Symbol           string `json:"symbol"`
+	SymbolPosition   string `json:"symbolPosition"` // before or after the amount
+	Locale           string `json:"locale"`
+	DecimalSeparator string `json:"decimalSeparator"`
+	GroupSeparator   string `json:"groupSeparator"`
+
+	// Pattern is the equivalent number pattern, such as ¤#,##0.00, for clients with an ICU or CLDR formatter
+	Pattern string `json:"pattern"`
+}
+
+// requestAmountFormatting returns the formatting of code in the locale r asks for with ?locale or Accept-Language,
+// falling back to the currency's own locale.
+func requestAmountFormatting(r *http.Request, code string) *amountFormatting {
+	cur, ok := currencies[code]
+	if !ok {
+		return nil
+	}
+	locale := cur.Locale
+	if v := requestLocale(r, cur.Locale); v != "" {
+		locale = v
+	}
+	lf := localeFormats[locale]
+
+	out := &amountFormatting{
+		Currency:         code,
+		Exponent:         cur.Exponent,
+		Symbol:           cur.Symbol,
+		SymbolPosition:   "before",
+		Locale:           locale,
+		DecimalSeparator: lf.DecimalSeparator,
+		GroupSeparator:   lf.GroupSeparator,
+		Pattern:          "¤#,##0",
+	}
+	if cur.Exponent > 0 {
+		out.Pattern += "." + strings.Repeat("0", cur.Exponent)
+	}
+	if lf.SymbolAfter {
+		out.SymbolPosition = "after"
+		out.Pattern = strings.TrimPrefix(out.Pattern, "¤") + " ¤"
+	}
+	return out
+}
+
+// requestLocale returns the first supported locale of ?locale or Accept-Language. Languages without a region (such
+// as fr) match the preferred locale if it's of that language, otherwise the first supported locale of it.
+func requestLocale(r *http.Request, preferred string) string {
+	var candidates []string
+	if v := r.URL.Query().Get("locale"); v != "" {
+		candidates = append(candidates, v)
+	}
+	for _, v := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		if tag := strings.TrimSpace(strings.Split(v, ";")[0]); tag != "" && tag != "*" {
+			candidates = append(candidates, tag)
+		}
+	}
+	for _, tag := range candidates {
+		tag = strings.Replace(tag, "_", "-", -1)
+		parts := strings.SplitN(tag, "-", 2)
+		language := strings.ToLower(parts[0])
+		if len(parts) == 2 {
+			if locale := language + "-" + strings.ToUpper(parts[1]); localeFormats[locale] != (localeFormat{}) {
+				return locale
+			}
+		}
+		if strings.HasPrefix(preferred, language+"-") {
+			return preferred
+		}
+		var match string
+		for locale := range localeFormats {
+			if strings.HasPrefix(locale, language+"-") && (match == "" || locale < match) {
+				match = locale
+			}
+		}
+		if match != "" {
+			return match
+		}
+	}
+	return ""
+}
+
+// formattedAccount is an account along with the formatting of its amounts.
+type formattedAccount struct {
+	*accounts.Account
+
+	Formatting *amountFormatting `json:"formatting,omitempty"`
+}
+
+func formatAccount(r *http.Request, acct *accounts.Account) formattedAccount {
+	return formattedAccount{Account: acct, Formatting: requestAmountFormatting(r, accountCurrency(acct))}
+}
+
+// formatAccounts formats each account, keeping a nil slice nil.
+func formatAccounts(r *http.Request, accts []*accounts.Account) []formattedAccount {
+	if accts == nil {
+		return nil
+	}
+	out := make([]formattedAccount, len(accts))
+	for i := range accts {
+		out[i] = formatAccount(r, accts[i])
+	}
+	return out
+}
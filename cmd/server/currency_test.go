@@ -0,0 +1,113 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestCurrency__readLedgerCurrency(t *testing.T) {
+	if code, err := readLedgerCurrency(); err != nil || code != "USD" {
+		t.Errorf("currency=%q error=%v", code, err)
+	}
+	os.Setenv("LEDGER_CURRENCY", "eur")
+	defer os.Unsetenv("LEDGER_CURRENCY")
+	if code, err := readLedgerCurrency(); err != nil || code != "EUR" {
+		t.Errorf("currency=%q error=%v", code, err)
+	}
+	os.Setenv("LEDGER_CURRENCY", "XYZ")
+	if _, err := readLedgerCurrency(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestCurrency__requestLocale(t *testing.T) {
+	for _, tc := range []struct {
+		query, acceptLanguage, preferred, expected string
+	}{
+		{"", "", "en-US", ""},
+		{"fr_fr", "de-DE", "en-US", "fr-FR"},
+		{"", "xx-YY, de;q=0.8", "en-US", "de-CH"},
+		{"", "de", "de-DE", "de-DE"},
+		{"", "en-NZ,en;q=0.9", "en-GB", "en-GB"},
+		{"", "*", "en-US", ""},
+	} {
+		req := httptest.NewRequest("GET", "/?locale="+tc.query, nil)
+		req.Header.Set("Accept-Language", tc.acceptLanguage)
+		if v := requestLocale(req, tc.preferred); v != tc.expected {
+			t.Errorf("locale=%q Accept-Language=%q: got %q", tc.query, tc.acceptLanguage, v)
+		}
+	}
+}
+
+func TestCurrency__requestAmountFormatting(t *testing.T) {
+	req := httptest.NewRequest("GET", "/", nil)
+	expected := &amountFormatting{
+		Currency:         "EUR",
+		Exponent:         2,
+		Symbol:           "€",
+		SymbolPosition:   "after",
+		Locale:           "de-DE",
+		DecimalSeparator: ",",
+		GroupSeparator:   ".",
+		Pattern:          "#,##0.00 ¤",
+	}
+	if f := requestAmountFormatting(req, "EUR"); !reflect.DeepEqual(f, expected) {
+		t.Errorf("formatting=%#v", f)
+	}
+
+	req.Header.Set("Accept-Language", "en-US")
+	if f := requestAmountFormatting(req, "JPY"); f.Pattern != "¤#,##0" || f.Exponent != 0 || f.Locale != "en-US" {
+		t.Errorf("formatting=%#v", f)
+	}
+	if f := requestAmountFormatting(req, "XYZ"); f != nil {
+		t.Errorf("formatting=%#v", f)
+	}
+}
+
+func TestCurrency__responses(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"a": 1000})
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &mockEventPublisher{}, nil, time.Now)
+
+	req := httptest.NewRequest("GET", "/accounts/a/transactions?locale=fr-FR", nil)
+	req.Header.Set("x-user-id", base.ID())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	w.Flush()
+
+	var resp []transaction
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status=%d error=%v", w.Code, err)
+	}
+	if len(resp) != 1 {
+		t.Fatalf("transactions=%#v", resp)
+	}
+	if f := resp[0].Formatting; f == nil || f.Currency != "USD" || f.Locale != "fr-FR" || f.Symbol != "$" || f.DecimalSeparator != "," {
+		t.Errorf("formatting=%#v", f)
+	}
+
+	// accounts keep their fields alongside the formatting
+	bs, _ := json.Marshal(formatAccounts(req, []*accounts.Account{{ID: "a", Balance: 1000}}))
+	if !strings.HasPrefix(string(bs), `[{"ID":"a",`) || !strings.Contains(string(bs), `"balance":1000,"formatting":{"currency":"USD",`) {
+		t.Errorf("unexpected accounts: %s", bs)
+	}
+	if formatAccounts(req, nil) != nil {
+		t.Error("expected nil accounts")
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type customerExportStatus string
+
+const (
+	customerExportPending customerExportStatus = "pending"
+	customerExportReady   customerExportStatus = "ready"
+	customerExportFailed  customerExportStatus = "failed"
+)
+
+// customerExport is an archive of everything we hold on a customer's accounts, generated for a data portability
+// request. The archive itself is only read when it's downloaded.
+type customerExport struct {
+	ID         string               `json:"id"`
+	CustomerID string               `json:"customerId"`
+	Status     customerExportStatus `json:"status"`
+
+	// OrganizationID is the organization whose accounts are exported, see organization.go
+	OrganizationID string `json:"organizationId,omitempty"`
+
+	// Error is why the archive couldn't be generated
+	Error string `json:"error,omitempty"`
+
+	// Size and SHA256 describe the zip archive once it's ready
+	Size   int    `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+
+	// DownloadURL is a signed link to the archive which is valid until ExpiresAt. It's not stored.
+	DownloadURL string `json:"downloadUrl,omitempty"`
+
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+}
+
+type customerExportRepository interface {
+	Ping() error
+	Close() error
+
+	createExport(export *customerExport) error
+
+	// finishExport records the outcome of generating an export along with its archive, which is nil if it failed.
+	finishExport(export *customerExport, archive []byte) error
+
+	// getExport returns nil if the export doesn't exist.
+	getExport(exportID string) (*customerExport, error)
+
+	// getLatestExport returns the customer's newest export in ctx's organization, or nil if they haven't requested one.
+	getLatestExport(ctx context.Context, customerID string) (*customerExport, error)
+
+	getArchive(exportID string) ([]byte, error)
+
+	// deleteExpiredArchives removes the archives of exports which expired before now, returning how many were deleted.
+	deleteExpiredArchives(now time.Time) (int64, error)
+}
+
+type sqlCustomerExportRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlCustomerExportRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlCustomerExportRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlCustomerExportRepository) createExport(export *customerExport) error {
+	query := `insert into customer_exports (export_id, customer_id, organization_id, status, error, size, sha256, created_at) values (?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err := r.db.Exec(query, export.ID, export.CustomerID, export.OrganizationID, export.Status, export.Error, export.Size, export.SHA256, export.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("createExport: customer=%s: %v", export.CustomerID, err)
+	}
+	return nil
+}
+
+func (r *sqlCustomerExportRepository) finishExport(export *customerExport, archive []byte) error {
+	query := `update customer_exports set status = ?, error = ?, size = ?, sha256 = ?, archive = ?, completed_at = ?, expires_at = ? where export_id = ?;`
+	_, err := r.db.Exec(query, export.Status, export.Error, export.Size, export.SHA256, archive, export.CompletedAt, export.ExpiresAt, export.ID)
+	if err != nil {
+		return fmt.Errorf("finishExport: export=%s: %v", export.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlCustomerExportRepository) getExport(exportID string) (*customerExport, error) {
+	exports, err := r.queryExports(`where export_id = ?`, exportID)
+	if err != nil {
+		return nil, fmt.Errorf("getExport: %v", err)
+	}
+	if len(exports) == 0 {
+		return nil, nil
+	}
+	return exports[0], nil
+}
+
+func (r *sqlCustomerExportRepository) getLatestExport(ctx context.Context, customerID string) (*customerExport, error) {
+	organization, organizationArgs := organizationFilter(ctx, "organization_id")
+	exports, err := r.queryExports(fmt.Sprintf(`where customer_id = ?%s order by created_at desc limit 1`, organization), append([]interface{}{customerID}, organizationArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("getLatestExport: %v", err)
+	}
+	if len(exports) == 0 {
+		return nil, nil
+	}
+	return exports[0], nil
+}
+
+func (r *sqlCustomerExportRepository) queryExports(suffix string, args ...interface{}) ([]*customerExport, error) {
+	query := fmt.Sprintf(`select export_id, customer_id, organization_id, status, error, size, sha256, created_at, completed_at, expires_at from customer_exports %s;`, suffix)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*customerExport
+	for rows.Next() {
+		var export customerExport
+		if err := rows.Scan(&export.ID, &export.CustomerID, &export.OrganizationID, &export.Status, &export.Error, &export.Size, &export.SHA256, &export.CreatedAt, &export.CompletedAt, &export.ExpiresAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		out = append(out, &export)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlCustomerExportRepository) getArchive(exportID string) ([]byte, error) {
+	var archive []byte
+	if err := r.db.QueryRow(`select archive from customer_exports where export_id = ?;`, exportID).Scan(&archive); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getArchive: export=%s: %v", exportID, err)
+	}
+	return archive, nil
+}
+
+func (r *sqlCustomerExportRepository) deleteExpiredArchives(now time.Time) (int64, error) {
+	res, err := r.db.Exec(`update customer_exports set archive = null where archive is not null and expires_at < ?;`, now)
+	if err != nil {
+		return 0, fmt.Errorf("deleteExpiredArchives: %v", err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSqlCustomerExportRepository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlCustomerExportRepository) {
+		defer repo.Close()
+
+		customerID := base.ID()
+		if export, err := repo.getLatestExport(context.Background(), customerID); err != nil || export != nil {
+			t.Fatalf("export=%#v error=%v", export, err)
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		older := &customerExport{ID: base.ID(), CustomerID: customerID, Status: customerExportPending, CreatedAt: now.Add(-time.Hour)}
+		export := &customerExport{ID: base.ID(), CustomerID: customerID, Status: customerExportPending, CreatedAt: now}
+		for _, e := range []*customerExport{older, export} {
+			if err := repo.createExport(e); err != nil {
+				t.Fatal(err)
+			}
+		}
+		latest, err := repo.getLatestExport(context.Background(), customerID)
+		if err != nil || latest == nil || latest.ID != export.ID || latest.Status != customerExportPending || latest.ExpiresAt != nil {
+			t.Fatalf("export=%#v error=%v", latest, err)
+		}
+
+		archive := []byte("PK archive")
+		expires := now.Add(time.Hour)
+		export.Status, export.Size, export.SHA256 = customerExportReady, len(archive), "abc"
+		export.CompletedAt, export.ExpiresAt = &now, &expires
+		if err := repo.finishExport(export, archive); err != nil {
+			t.Fatal(err)
+		}
+		found, err := repo.getExport(export.ID)
+		if err != nil || found == nil {
+			t.Fatalf("export=%#v error=%v", found, err)
+		}
+		if found.Status != customerExportReady || found.Size != len(archive) || found.ExpiresAt == nil || !found.ExpiresAt.Equal(expires) {
+			t.Errorf("unexpected export: %#v", found)
+		}
+		if bs, err := repo.getArchive(export.ID); err != nil || !bytes.Equal(bs, archive) {
+			t.Errorf("archive=%q error=%v", bs, err)
+		}
+		if bs, err := repo.getArchive(base.ID()); err != nil || bs != nil {
+			t.Errorf("archive=%q error=%v", bs, err)
+		}
+		if found, err := repo.getExport(base.ID()); err != nil || found != nil {
+			t.Errorf("export=%#v error=%v", found, err)
+		}
+
+		// archives are only deleted once they've expired
+		if n, err := repo.deleteExpiredArchives(now); err != nil || n != 0 {
+			t.Errorf("deleted=%d error=%v", n, err)
+		}
+		if n, err := repo.deleteExpiredArchives(expires.Add(time.Second)); err != nil || n != 1 {
+			t.Errorf("deleted=%d error=%v", n, err)
+		}
+		if bs, err := repo.getArchive(export.ID); err != nil || len(bs) != 0 {
+			t.Errorf("archive=%q error=%v", bs, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlCustomerExportRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlCustomerExportRepository{mysqlDB.DB, log.NewNopLogger()})
+}
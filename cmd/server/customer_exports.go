@@ -0,0 +1,478 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	app "github.com/moov-io/accounts"
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// customerExportTimeout is how long an export can stay pending before it's considered abandoned, such as when the
+// instance generating it restarted, and a new one is started for the customer.
+const customerExportTimeout = 30 * time.Minute
+
+// customerExportManifest describes a customer's export archive. Each file's checksum lets the customer (or whoever
+// they hand the archive to) check nothing is missing or changed.
+type customerExportManifest struct {
+	CustomerID  string         `json:"customerId"`
+	GeneratedAt time.Time      `json:"generatedAt"`
+	Version     string         `json:"version"`
+	Files       []evidenceFile `json:"files"`
+}
+
+// exportedStatement is a statement without its transactions, which are exported in full for each account.
+type exportedStatement struct {
+	ID             string    `json:"id"`
+	AccountID      string    `json:"accountId"`
+	Cycle          string    `json:"cycle"`
+	PeriodStart    time.Time `json:"periodStart"`
+	PeriodEnd      time.Time `json:"periodEnd"`
+	OpeningBalance int64     `json:"openingBalance"`
+	ClosingBalance int64     `json:"closingBalance"`
+	Credits        int64     `json:"credits"`
+	Debits         int64     `json:"debits"`
+	CreatedAt      time.Time `json:"createdAt"`
+
+	Disputes      []statementDispute `json:"disputes,omitempty"`
+	RegeneratedAt *time.Time         `json:"regeneratedAt,omitempty"`
+}
+
+// customerExporter generates data portability archives of a customer's accounts, transactions and statements in
+// the background. Finished archives are downloaded with a signed link until they expire.
+type customerExporter struct {
+	logger          log.Logger
+	accountRepo     accountRepository
+	transactionRepo transactionRepository
+	statementRepo   statementRepository
+	repo            customerExportRepository
+
+	// signingKey signs download links with HMAC-SHA256, read from CUSTOMER_EXPORT_SIGNING_KEY.
+	signingKey []byte
+
+	// linkTTL is how long an archive can be downloaded after it's generated, read from CUSTOMER_EXPORT_LINK_TTL.
+	linkTTL time.Duration
+
+	mu     sync.Mutex
+	active map[string]bool // organization and customer IDs being exported, see exportKey
+	wg     sync.WaitGroup
+
+	now func() time.Time
+}
+
+func newCustomerExporter(logger log.Logger, accountRepo accountRepository, transactionRepo transactionRepository, statementRepo statementRepository, repo customerExportRepository) *customerExporter {
+	key := []byte(os.Getenv("CUSTOMER_EXPORT_SIGNING_KEY"))
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		rand.Read(key)
+		logger.Log("exports", "CUSTOMER_EXPORT_SIGNING_KEY isn't set, so export download links only work on this instance until it restarts")
+	}
+	return &customerExporter{
+		logger:          logger,
+		accountRepo:     accountRepo,
+		transactionRepo: transactionRepo,
+		statementRepo:   statementRepo,
+		repo:            repo,
+		signingKey:      key,
+		linkTTL:         customerExportLinkTTL(),
+		active:          make(map[string]bool),
+		now:             time.Now,
+	}
+}
+
+// customerExportLinkTTL returns how long export download links are valid, read from CUSTOMER_EXPORT_LINK_TTL.
+func customerExportLinkTTL() time.Duration {
+	if v := os.Getenv("CUSTOMER_EXPORT_LINK_TTL"); v != "" {
+		if dur, err := time.ParseDuration(v); err == nil && dur > 0 {
+			return dur
+		}
+	}
+	return 24 * time.Hour
+}
+
+// request returns the customer's current export, starting a new one if they don't have one which is pending or
+// can still be downloaded. Ready exports include a signed DownloadURL.
+func (e *customerExporter) request(ctx context.Context, customerID string) (*customerExport, error) {
+	now := e.now()
+	latest, err := e.repo.getLatestExport(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if latest != nil {
+		switch {
+		case latest.Status == customerExportPending && (e.isActive(exportKey(ctx, customerID)) || now.Sub(latest.CreatedAt) < customerExportTimeout):
+			return latest, nil
+		case latest.Status == customerExportReady && latest.ExpiresAt != nil && now.Before(*latest.ExpiresAt):
+			latest.DownloadURL = e.downloadURL(latest)
+			return latest, nil
+		}
+	}
+	return e.start(ctx, customerID)
+}
+
+// exportKey identifies a customer's exports in ctx's organization, as customer IDs are only unique within one.
+func exportKey(ctx context.Context, customerID string) string {
+	organization, _ := organizationFrom(ctx)
+	return organization + "/" + customerID
+}
+
+func (e *customerExporter) isActive(key string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.active[key]
+}
+
+// start begins generating an export of the customer's data in the background.
+func (e *customerExporter) start(ctx context.Context, customerID string) (*customerExport, error) {
+	key := exportKey(ctx, customerID)
+	e.mu.Lock()
+	if e.active[key] {
+		e.mu.Unlock()
+		return nil, fmt.Errorf("an export of customer=%s is already being generated", customerID)
+	}
+	e.active[key] = true
+	e.mu.Unlock()
+
+	organization, _ := organizationFrom(ctx)
+	export := &customerExport{
+		ID:             newID(),
+		CustomerID:     customerID,
+		OrganizationID: organization,
+		Status:         customerExportPending,
+		CreatedAt:      e.now(),
+	}
+	if err := e.repo.createExport(export); err != nil {
+		e.mu.Lock()
+		delete(e.active, key)
+		e.mu.Unlock()
+		return nil, err
+	}
+	e.logger.Log("exports", fmt.Sprintf("started export=%s of customer=%s", export.ID, customerID), "requestID", requestIDFrom(ctx))
+
+	// process a copy as callers still hold export
+	progress := *export
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.process(&progress)
+
+		e.mu.Lock()
+		delete(e.active, key)
+		e.mu.Unlock()
+	}()
+	return export, nil
+}
+
+func (e *customerExporter) process(export *customerExport) {
+	// Archives hold the customer's data, so don't keep any longer than their links are valid
+	if n, err := e.repo.deleteExpiredArchives(e.now()); err != nil {
+		e.logger.Log("exports", fmt.Sprintf("problem deleting expired archives: %v", err))
+	} else if n > 0 {
+		e.logger.Log("exports", fmt.Sprintf("deleted %d expired archives", n))
+	}
+
+	archive, err := e.generate(withOrganization(context.Background(), export.OrganizationID), export.CustomerID)
+	now := e.now()
+	export.CompletedAt = &now
+	if err != nil {
+		export.Status = customerExportFailed
+		export.Error = err.Error()
+		archive = nil
+		e.logger.Log("exports", fmt.Sprintf("problem generating export=%s of customer=%s: %v", export.ID, export.CustomerID, err))
+	} else {
+		expires := now.Add(e.linkTTL)
+		sum := sha256.Sum256(archive)
+		export.Status = customerExportReady
+		export.Size = len(archive)
+		export.SHA256 = hex.EncodeToString(sum[:])
+		export.ExpiresAt = &expires
+		e.logger.Log("exports", fmt.Sprintf("generated export=%s of customer=%s (%d bytes)", export.ID, export.CustomerID, export.Size))
+	}
+	if err := e.repo.finishExport(export, archive); err != nil {
+		e.logger.Log("exports", fmt.Sprintf("problem saving export=%s: %v", export.ID, err))
+	}
+}
+
+// generate writes a zip archive of the customer's accounts in ctx's organization and, for each account, its
+// transactions and statements. manifest.json lists every other file with its checksum.
+func (e *customerExporter) generate(ctx context.Context, customerID string) ([]byte, error) {
+	accts, err := e.accountRepo.SearchAccountsByCustomerID(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if accts == nil {
+		accts = []*accounts.Account{}
+	}
+
+	manifest := customerExportManifest{
+		CustomerID:  customerID,
+		GeneratedAt: e.now(),
+		Version:     app.Version,
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, v interface{}) error {
+		bs, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("export: %s: %v", name, err)
+		}
+		sum := sha256.Sum256(bs)
+		manifest.Files = append(manifest.Files, evidenceFile{Name: name, SHA256: hex.EncodeToString(sum[:]), Size: len(bs)})
+		return writeEvidenceFile(zw, name, bs)
+	}
+	if err := add("accounts.json", accts); err != nil {
+		return nil, err
+	}
+	for _, acct := range accts {
+		file, err := e.writeAccountTransactions(ctx, zw, acct.ID)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Files = append(manifest.Files, *file)
+
+		stmts, err := e.statementRepo.getAccountStatements(acct.ID)
+		if err != nil {
+			return nil, fmt.Errorf("export: account=%s statements: %v", acct.ID, err)
+		}
+		exported := make([]exportedStatement, 0, len(stmts))
+		for _, s := range stmts {
+			exported = append(exported, exportedStatement{
+				ID:             s.ID,
+				AccountID:      s.AccountID,
+				Cycle:          s.Cycle,
+				PeriodStart:    s.PeriodStart,
+				PeriodEnd:      s.PeriodEnd,
+				OpeningBalance: s.OpeningBalance,
+				ClosingBalance: s.ClosingBalance,
+				Credits:        s.Credits,
+				Debits:         s.Debits,
+				CreatedAt:      s.CreatedAt,
+				Disputes:       s.Disputes,
+				RegeneratedAt:  s.RegeneratedAt,
+			})
+		}
+		if err := add(fmt.Sprintf("accounts/%s/statements.json", acct.ID), exported); err != nil {
+			return nil, err
+		}
+	}
+
+	bs, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("export: manifest.json: %v", err)
+	}
+	if err := writeEvidenceFile(zw, "manifest.json", bs); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("export: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeAccountTransactions writes accounts/<id>/transactions.json into the archive a page of transactions at a time,
+// so exporting a large history doesn't hold every transaction in memory. The file is indented like the others.
+func (e *customerExporter) writeAccountTransactions(ctx context.Context, zw *zip.Writer, accountID string) (*evidenceFile, error) {
+	name := fmt.Sprintf("accounts/%s/transactions.json", accountID)
+	w, err := zw.Create(name)
+	if err != nil {
+		return nil, fmt.Errorf("export: %s: %v", name, err)
+	}
+	sum := sha256.New()
+	out := &countingWriter{w: io.MultiWriter(w, sum)}
+
+	written := 0
+	err = eachAccountTransactionPage(ctx, e.transactionRepo, accountID, transactionPage{}, func(transactions []transaction) error {
+		for _, tx := range accountLines(accountID, transactions) {
+			bs, err := json.MarshalIndent(tx, "  ", "  ")
+			if err != nil {
+				return err
+			}
+			sep := ",\n  "
+			if written == 0 {
+				sep = "[\n  "
+			}
+			if _, err := out.Write(append([]byte(sep), bs...)); err != nil {
+				return err
+			}
+			written++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("export: account=%s transactions: %v", accountID, err)
+	}
+	end := "\n]"
+	if written == 0 {
+		end = "[]"
+	}
+	if _, err := out.Write([]byte(end)); err != nil {
+		return nil, fmt.Errorf("export: %s: %v", name, err)
+	}
+	return &evidenceFile{Name: name, SHA256: hex.EncodeToString(sum.Sum(nil)), Size: out.n}, nil
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += n
+	return n, err
+}
+
+// accountLines returns transactions with only the lines of accountID, so an export doesn't include the accounts
+// of other customers.
+func accountLines(accountID string, transactions []transaction) []transaction {
+	out := make([]transaction, 0, len(transactions))
+	for i := range transactions {
+		tx := transactions[i]
+		tx.Lines = nil
+		for _, line := range transactions[i].Lines {
+			if line.AccountID == accountID {
+				tx.Lines = append(tx.Lines, line)
+			}
+		}
+		out = append(out, tx)
+	}
+	return out
+}
+
+// signExportDownload returns the hex encoded HMAC-SHA256 which authorizes downloading an export until expires.
+func (e *customerExporter) signExportDownload(customerID, exportID string, expires int64) string {
+	mac := hmac.New(sha256.New, e.signingKey)
+	fmt.Fprintf(mac, "%s\n%s\n%d", customerID, exportID, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// downloadURL returns the signed path an export's archive can be downloaded from until it expires.
+func (e *customerExporter) downloadURL(export *customerExport) string {
+	expires := export.ExpiresAt.Unix()
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("signature", e.signExportDownload(export.CustomerID, export.ID, expires))
+	return fmt.Sprintf("/customers/%s/exports/%s/download?%s", url.PathEscape(export.CustomerID), export.ID, q.Encode())
+}
+
+// verifyDownload checks the expires and signature query parameters of a download link.
+func (e *customerExporter) verifyDownload(customerID, exportID string, query url.Values) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil {
+		return errors.New("invalid expires")
+	}
+	expected := e.signExportDownload(customerID, exportID, expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return errors.New("invalid signature")
+	}
+	if !e.now().Before(time.Unix(expires, 0)) {
+		return errExportLinkExpired
+	}
+	return nil
+}
+
+var errExportLinkExpired = errors.New("download link has expired")
+
+func addCustomerExportRoutes(logger log.Logger, router *mux.Router, exporter *customerExporter) {
+	router.Methods("GET").Path("/customers/{customerId}/export").HandlerFunc(requestCustomerExport(logger, exporter))
+	router.Methods("GET").Path("/customers/{customerId}/exports/{exportId}/download").HandlerFunc(downloadCustomerExport(logger, exporter))
+}
+
+// requestCustomerExport returns the customer's export, starting one if needed. Pending exports are returned with a
+// 202 and should be polled until they're ready, when the response includes a signed downloadUrl.
+func requestCustomerExport(logger log.Logger, exporter *customerExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		customerID := mux.Vars(r)["customerId"]
+		if caller := r.Header.Get("X-Customer-ID"); caller == "" {
+			moovhttp.Problem(w, errors.New("missing X-Customer-ID header"))
+			return
+		} else if caller != customerID {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		export, err := exporter.request(requestContext(r), customerID)
+		if err != nil {
+			logger.Log("exports", fmt.Sprintf("problem requesting export of customer=%s: %v", customerID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if export.Status == customerExportReady {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		json.NewEncoder(w).Encode(export)
+	}
+}
+
+// downloadCustomerExport serves an export's archive to holders of its signed link. The link is the authorization,
+// so the customer can open it in a browser or hand it to another provider.
+func downloadCustomerExport(logger log.Logger, exporter *customerExporter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		customerID, exportID := mux.Vars(r)["customerId"], mux.Vars(r)["exportId"]
+		if err := exporter.verifyDownload(customerID, exportID, r.URL.Query()); err != nil {
+			logger.Log("audit", fmt.Sprintf("rejected download of export=%s: %v", exportID, err), "customerID", customerID, "requestID", moovhttp.GetRequestID(r))
+			if err == errExportLinkExpired {
+				w.WriteHeader(http.StatusGone)
+				return
+			}
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		export, err := exporter.repo.getExport(exportID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if export == nil || export.CustomerID != customerID || export.Status != customerExportReady {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		archive, err := exporter.repo.getArchive(exportID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if len(archive) == 0 {
+			w.WriteHeader(http.StatusGone) // deleted after it expired
+			return
+		}
+		logger.Log("audit", fmt.Sprintf("downloaded export=%s", exportID), "customerID", customerID, "requestID", moovhttp.GetRequestID(r))
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", fmt.Sprintf("export-%s.zip", exportID)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(archive)
+	}
+}
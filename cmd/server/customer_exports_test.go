@@ -0,0 +1,189 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestCustomerExports__routes(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"checking": 0, "savings": 0})
+	other := &accounts.Account{ID: "other", CustomerID: "someone-else", AccountNumber: "other", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+	if err := accountRepo.CreateAccount(other.CustomerID, other); err != nil {
+		t.Fatal(err)
+	}
+	deposit := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{{AccountID: "other", Purpose: ACHCredit, Amount: 2000}}}
+	if err := transactionRepo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+		t.Fatal(err)
+	}
+	// a transfer from another customer's account shouldn't reveal their lines
+	tx := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+		{AccountID: "other", Purpose: ACHDebit, Amount: 500},
+		{AccountID: "checking", Purpose: ACHCredit, Amount: 500},
+	}}
+	if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	statementRepo := &sqlStatementRepository{db.DB, log.NewNopLogger()}
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stmt := &statement{ID: base.ID(), AccountID: "checking", Cycle: "2020-01", PeriodStart: start, PeriodEnd: start.AddDate(0, 1, 0), ClosingBalance: 500, Transactions: []transaction{tx}, CreatedAt: time.Now()}
+	if err := statementRepo.createStatement(stmt); err != nil {
+		t.Fatal(err)
+	}
+
+	exporter := newCustomerExporter(log.NewNopLogger(), accountRepo, transactionRepo, statementRepo, &sqlCustomerExportRepository{db.DB, log.NewNopLogger()})
+	router := mux.NewRouter()
+	addCustomerExportRoutes(log.NewNopLogger(), router, exporter)
+
+	do := func(path, customerID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("x-user-id", "test")
+		if customerID != "" {
+			req.Header.Set("X-Customer-ID", customerID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+	read := func(w *httptest.ResponseRecorder) *customerExport {
+		t.Helper()
+		var export customerExport
+		if err := json.NewDecoder(w.Body).Decode(&export); err != nil {
+			t.Fatal(err)
+		}
+		return &export
+	}
+
+	// customers can only export their own data
+	if w := do("/customers/customer/export", "someone-else"); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	w := do("/customers/customer/export", "customer")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	pending := read(w)
+	if pending.Status != customerExportPending || pending.DownloadURL != "" {
+		t.Fatalf("unexpected export: %#v", pending)
+	}
+	exporter.wg.Wait()
+
+	w = do("/customers/customer/export", "customer")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	ready := read(w)
+	if ready.ID != pending.ID || ready.Status != customerExportReady || ready.ExpiresAt == nil || !strings.HasPrefix(ready.DownloadURL, "/customers/customer/exports/"+ready.ID+"/download?") {
+		t.Fatalf("unexpected export: %#v", ready)
+	}
+
+	// the signed link is the only authorization needed to download
+	w = do(ready.DownloadURL, "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	sum := sha256.Sum256(w.Body.Bytes())
+	if hex.EncodeToString(sum[:]) != ready.SHA256 || w.Body.Len() != ready.Size {
+		t.Errorf("archive doesn't match export: %#v", ready)
+	}
+	files := readTestZip(t, w.Body.Bytes())
+
+	var manifest customerExportManifest
+	json.Unmarshal(files["manifest.json"], &manifest)
+	if manifest.CustomerID != "customer" || len(manifest.Files) != 5 {
+		t.Errorf("unexpected manifest: %#v", manifest)
+	}
+	for _, f := range manifest.Files {
+		sum := sha256.Sum256(files[f.Name])
+		if hex.EncodeToString(sum[:]) != f.SHA256 {
+			t.Errorf("%s checksum mismatch", f.Name)
+		}
+	}
+	var accts []*accounts.Account
+	json.Unmarshal(files["accounts.json"], &accts)
+	if len(accts) != 2 {
+		t.Errorf("unexpected accounts: %s", files["accounts.json"])
+	}
+	var transactions []transaction
+	json.Unmarshal(files["accounts/checking/transactions.json"], &transactions)
+	if len(transactions) != 1 || len(transactions[0].Lines) != 1 || transactions[0].Lines[0].AccountID != "checking" {
+		t.Errorf("unexpected transactions: %s", files["accounts/checking/transactions.json"])
+	}
+	if strings.Contains(string(files["accounts/checking/transactions.json"]), `"other"`) {
+		t.Error("export includes another customer's account")
+	}
+	// transactions are streamed into the archive but read the same as the other files
+	if bs, _ := json.MarshalIndent(transactions, "", "  "); !bytes.Equal(bs, files["accounts/checking/transactions.json"]) {
+		t.Errorf("unexpected formatting: %s", files["accounts/checking/transactions.json"])
+	}
+	if bs := files["accounts/savings/transactions.json"]; string(bs) != "[]" {
+		t.Errorf("unexpected transactions: %s", bs)
+	}
+	var stmts []exportedStatement
+	json.Unmarshal(files["accounts/checking/statements.json"], &stmts)
+	if len(stmts) != 1 || stmts[0].ID != stmt.ID || stmts[0].ClosingBalance != 500 {
+		t.Errorf("unexpected statements: %s", files["accounts/checking/statements.json"])
+	}
+
+	// tampered and expired links are rejected
+	if w := do(strings.Replace(ready.DownloadURL, "/customers/customer/", "/customers/someone-else/", 1), ""); w.Code != http.StatusForbidden {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := do(strings.Replace(ready.DownloadURL, "signature=", "signature=00", 1), ""); w.Code != http.StatusForbidden {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	exporter.now = func() time.Time { return ready.ExpiresAt.Add(time.Second) }
+	if w := do(ready.DownloadURL, ""); w.Code != http.StatusGone {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// once expired, requesting an export starts a new one
+	w = do("/customers/customer/export", "customer")
+	if export := read(w); w.Code != http.StatusAccepted || export.ID == ready.ID {
+		t.Errorf("unexpected export: %d %#v", w.Code, export)
+	}
+	exporter.wg.Wait()
+}
+
+func readTestZip(t *testing.T, bs []byte) map[string][]byte {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(bs), int64(len(bs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = ioutil.ReadAll(rc)
+		rc.Close()
+	}
+	return files
+}
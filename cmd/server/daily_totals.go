@@ -0,0 +1,341 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// dailyTotal sums an account's posted (and later reversed) lines over one UTC day, by the timestamp of their
+// transactions. Statements, interest and balance history read these rather than summing every line they need.
+type dailyTotal struct {
+	Day     string `json:"day"` // YYYY-MM-DD
+	Credits int64  `json:"credits"`
+	Debits  int64  `json:"debits"`
+	Lines   int    `json:"lines"`
+}
+
+// dailyTotalsDay returns the day of end which getDailyTotals stops before. A zero end includes every day.
+func dailyTotalsDay(end time.Time) string {
+	if end.IsZero() {
+		return "9999-12-31"
+	}
+	return end.UTC().Format("2006-01-02")
+}
+
+// sumDailyTotals returns the balance the totals add up to.
+func sumDailyTotals(totals []dailyTotal) int64 {
+	var balance int64
+	for i := range totals {
+		balance += totals[i].Credits - totals[i].Debits
+	}
+	return balance
+}
+
+// balanceAt returns the account's balance at a moment, adding the lines posted earlier that UTC day to its daily
+// totals.
+func balanceAt(ctx context.Context, repo transactionRepository, accountID string, at time.Time) (int64, error) {
+	totals, err := repo.getDailyTotals(accountID, at)
+	if err != nil {
+		return 0, err
+	}
+	balance := sumDailyTotals(totals)
+	day, _ := dayIn(at, time.UTC)
+	err = eachAccountTransactionPage(ctx, repo, accountID, transactionPage{StartDate: day, EndDate: at}, func(transactions []transaction) error {
+		balance += buildStatement(accountID, at, at, transactions).OpeningBalance
+		return nil
+	})
+	return balance, err
+}
+
+// dailyTotalsOf totals the account's lines in transactions on each day before end, for repositories which keep
+// every transaction at hand.
+func dailyTotalsOf(accountID string, end time.Time, transactions []*transaction) []dailyTotal {
+	before := dailyTotalsDay(end)
+	days := make(map[string]*dailyTotal)
+	for _, tx := range transactions {
+		if tx.Status != TransactionPosted && tx.Status != TransactionReversed {
+			continue
+		}
+		day := tx.Timestamp.UTC().Format("2006-01-02")
+		if day >= before {
+			continue
+		}
+		for _, line := range tx.Lines {
+			if line.AccountID != accountID {
+				continue
+			}
+			if days[day] == nil {
+				days[day] = &dailyTotal{Day: day}
+			}
+			if amt := int64(lineAmount(line)); amt < 0 {
+				days[day].Debits += -1 * amt
+			} else {
+				days[day].Credits += amt
+			}
+			days[day].Lines++
+		}
+	}
+	var out []dailyTotal
+	for _, total := range days {
+		out = append(out, *total)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Day < out[j].Day })
+	return out
+}
+
+// readDailyTotalsInterval reads DAILY_TOTALS_REFRESH_INTERVAL, how often changed daily totals are recomputed in the
+// background. Zero leaves them to be recomputed when they're read.
+func readDailyTotalsInterval() (time.Duration, error) {
+	v := os.Getenv("DAILY_TOTALS_REFRESH_INTERVAL")
+	if v == "" {
+		return time.Minute, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("DAILY_TOTALS_REFRESH_INTERVAL: invalid duration %q", v)
+	}
+	return d, nil
+}
+
+// setupDailyTotalsJob recomputes the daily totals which transactions changed on each ledger every interval until
+// ctx is cancelled, so reads rarely have any left to recompute.
+func setupDailyTotalsJob(ctx context.Context, logger log.Logger, ledgers []*sqlTransactionRepository, interval time.Duration) {
+	if interval <= 0 || len(ledgers) == 0 {
+		return
+	}
+	logger.Log("transactions", fmt.Sprintf("refreshing account daily totals every %v", interval))
+
+	refresh := func() {
+		for i := range ledgers {
+			n, err := ledgers[i].refreshDailyTotals("")
+			if err != nil {
+				logger.Log("transactions", fmt.Sprintf("problem refreshing account daily totals: %v", err))
+				continue
+			}
+			if n > 0 {
+				logger.Log("transactions", fmt.Sprintf("refreshed daily totals of %d accounts", n))
+			}
+		}
+	}
+	go func() {
+		refresh()
+
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				refresh()
+			}
+		}
+	}()
+}
+
+// balanceHistoryDay is an account's totals on a day along with its balance at the end of the day.
+type balanceHistoryDay struct {
+	dailyTotal
+	Balance int64 `json:"balance"`
+}
+
+// balanceHistory is an account's balance over the days it changed between two dates.
+type balanceHistory struct {
+	AccountID      string              `json:"accountId"`
+	OpeningBalance int64               `json:"openingBalance"`
+	Days           []balanceHistoryDay `json:"days"`
+}
+
+// getBalanceHistory is an admin route which returns an account's balance at the end of each day it changed, from
+// ?startDate through ?endDate (YYYY-MM-DD days in UTC, both optional).
+func getBalanceHistory(logger log.Logger, repo transactionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		accountID := mux.Vars(r)["accountId"]
+		var start, end time.Time
+		for param, when := range map[string]*time.Time{"startDate": &start, "endDate": &end} {
+			if v := r.URL.Query().Get(param); v != "" {
+				t, err := time.Parse("2006-01-02", v)
+				if err != nil {
+					moovhttp.Problem(w, fmt.Errorf("invalid %s %q, expected YYYY-MM-DD", param, v))
+					return
+				}
+				*when = t
+			}
+		}
+		if !end.IsZero() {
+			end = end.AddDate(0, 0, 1)
+		}
+
+		totals, err := repo.getDailyTotals(accountID, end)
+		if err != nil {
+			logger.Log("transactions", fmt.Sprintf("problem reading account=%s daily totals: %v", accountID, err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		history := balanceHistory{AccountID: accountID, Days: []balanceHistoryDay{}}
+		first := dailyTotalsDay(start)
+		balance := int64(0)
+		for i := range totals {
+			if start.IsZero() || totals[i].Day >= first {
+				history.Days = append(history.Days, balanceHistoryDay{dailyTotal: totals[i]})
+			}
+			balance += totals[i].Credits - totals[i].Debits
+			if n := len(history.Days); n > 0 {
+				history.Days[n-1].Balance = balance
+			} else {
+				history.OpeningBalance = balance
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(history)
+	}
+}
+
+// getDailyTotals returns the account's totals on each day before end's UTC day, oldest first. Totals the account's
+// transactions changed since they were last refreshed are recomputed first.
+func (r *sqlTransactionRepository) getDailyTotals(accountID string, end time.Time) ([]dailyTotal, error) {
+	if _, err := r.refreshDailyTotals(accountID); err != nil {
+		return nil, err
+	}
+	query := `select day, credits, debits, line_count from account_daily_totals where account_id = ? and day < ? order by day;`
+	rows, err := r.db.Query(query, accountID, dailyTotalsDay(end))
+	if err != nil {
+		return nil, fmt.Errorf("getDailyTotals: account=%s: %v", accountID, err)
+	}
+	defer rows.Close()
+	var out []dailyTotal
+	for rows.Next() {
+		var total dailyTotal
+		if err := rows.Scan(&total.Day, &total.Credits, &total.Debits, &total.Lines); err != nil {
+			return nil, fmt.Errorf("getDailyTotals: account=%s: scan: %v", accountID, err)
+		}
+		out = append(out, total)
+	}
+	return out, rows.Err()
+}
+
+// refreshDailyTotals recomputes the daily totals of the account (or, when empty, of every account) which triggers
+// recorded changes to in account_daily_total_changes. It returns how many accounts were refreshed.
+func (r *sqlTransactionRepository) refreshDailyTotals(accountID string) (int, error) {
+	accountIDs := []string{accountID}
+	if accountID == "" {
+		rows, err := r.db.Query(`select distinct account_id from account_daily_total_changes;`)
+		if err != nil {
+			return 0, fmt.Errorf("refreshDailyTotals: %v", err)
+		}
+		accountIDs = nil
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("refreshDailyTotals: scan: %v", err)
+			}
+			accountIDs = append(accountIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return 0, fmt.Errorf("refreshDailyTotals: rows: %v", err)
+		}
+	}
+	refreshed := 0
+	for i := range accountIDs {
+		changed, err := r.refreshAccountDailyTotals(accountIDs[i])
+		if err != nil {
+			return refreshed, err
+		}
+		if changed {
+			refreshed++
+		}
+	}
+	return refreshed, nil
+}
+
+// refreshAccountDailyTotals recomputes the account's totals on each day with a changed transaction. Changes recorded
+// while it runs are left for the next refresh.
+func (r *sqlTransactionRepository) refreshAccountDailyTotals(accountID string) (bool, error) {
+	var last sql.NullInt64
+	if err := r.db.QueryRow(`select max(change_id) from account_daily_total_changes where account_id = ?;`, accountID).Scan(&last); err != nil {
+		return false, fmt.Errorf("refreshDailyTotals: account=%s: %v", accountID, err)
+	}
+	if !last.Valid {
+		return false, nil
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("refreshDailyTotals: account=%s: begin: %v", accountID, err)
+	}
+	query := `select timestamp from transactions where transaction_id in
+(select transaction_id from account_daily_total_changes where account_id = ? and change_id <= ?);`
+	rows, err := tx.Query(query, accountID, last.Int64)
+	if err != nil {
+		return false, fmt.Errorf("refreshDailyTotals: account=%s: error=%v rollback=%v", accountID, err, tx.Rollback())
+	}
+	days := make(map[string]time.Time)
+	for rows.Next() {
+		var timestamp time.Time
+		if err := rows.Scan(&timestamp); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("refreshDailyTotals: account=%s: scan: error=%v rollback=%v", accountID, err, tx.Rollback())
+		}
+		day := timestamp.UTC().Truncate(24 * time.Hour)
+		days[day.Format("2006-01-02")] = day
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("refreshDailyTotals: account=%s: rows: error=%v rollback=%v", accountID, err, tx.Rollback())
+	}
+
+	// Compaction moves old lines into transaction_lines_archive, so both are summed
+	query = `select coalesce(sum(case when lower(l.purpose) = 'achdebit' then 0 else l.amount end), 0),
+coalesce(sum(case when lower(l.purpose) = 'achdebit' then l.amount else 0 end), 0), count(*)
+from (select transaction_id, account_id, purpose, amount, deleted_at from transaction_lines
+union all select transaction_id, account_id, purpose, amount, deleted_at from transaction_lines_archive) l
+inner join transactions t on t.transaction_id = l.transaction_id
+where l.account_id = ? and l.deleted_at is null and t.status in ('posted', 'reversed') and t.timestamp >= ? and t.timestamp < ?;`
+	now := time.Now()
+	for day, start := range days {
+		var total dailyTotal
+		if err := tx.QueryRow(query, accountID, start, start.AddDate(0, 0, 1)).Scan(&total.Credits, &total.Debits, &total.Lines); err != nil {
+			return false, fmt.Errorf("refreshDailyTotals: account=%s day=%s: error=%v rollback=%v", accountID, day, err, tx.Rollback())
+		}
+		if _, err := tx.Exec(`delete from account_daily_totals where account_id = ? and day = ?;`, accountID, day); err != nil {
+			return false, fmt.Errorf("refreshDailyTotals: account=%s day=%s: delete: error=%v rollback=%v", accountID, day, err, tx.Rollback())
+		}
+		if total.Lines == 0 {
+			continue
+		}
+		insert := `insert into account_daily_totals(account_id, day, credits, debits, line_count, refreshed_at) values (?, ?, ?, ?, ?, ?);`
+		if _, err := tx.Exec(insert, accountID, day, total.Credits, total.Debits, total.Lines, now); err != nil {
+			return false, fmt.Errorf("refreshDailyTotals: account=%s day=%s: insert: error=%v rollback=%v", accountID, day, err, tx.Rollback())
+		}
+	}
+	if _, err := tx.Exec(`delete from account_daily_total_changes where account_id = ? and change_id <= ?;`, accountID, last.Int64); err != nil {
+		return false, fmt.Errorf("refreshDailyTotals: account=%s: delete changes: error=%v rollback=%v", accountID, err, tx.Rollback())
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("refreshDailyTotals: account=%s: commit: %v", accountID, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestDailyTotals__readInterval(t *testing.T) {
+	if d, err := readDailyTotalsInterval(); err != nil || d != time.Minute {
+		t.Errorf("interval=%v error=%v", d, err)
+	}
+	os.Setenv("DAILY_TOTALS_REFRESH_INTERVAL", "0s")
+	defer os.Unsetenv("DAILY_TOTALS_REFRESH_INTERVAL")
+	if d, err := readDailyTotalsInterval(); err != nil || d != 0 {
+		t.Errorf("interval=%v error=%v", d, err)
+	}
+	os.Setenv("DAILY_TOTALS_REFRESH_INTERVAL", "often")
+	if _, err := readDailyTotalsInterval(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestDailyTotals(t *testing.T) {
+	ctx := context.Background()
+	march := time.Date(2020, time.March, 4, 12, 0, 0, 0, time.UTC)
+
+	check := func(t *testing.T, db *sql.DB) {
+		accountRepo := createTestSqlAccountRepository(t, db)
+		repo, err := setupSqlTransactionStorage(ctx, log.NewNopLogger(), db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo.accountRepo = accountRepo
+		for _, acct := range []*accounts.Account{
+			{ID: "alice", CustomerID: "alice", AccountNumber: "1", RoutingNumber: defaultRoutingNumber, Status: "open", Type: string(Checking)},
+			{ID: "funding", CustomerID: "bank", AccountNumber: "2", RoutingNumber: defaultRoutingNumber, Status: "open", Type: string(FBO)},
+		} {
+			if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+				t.Fatal(err)
+			}
+		}
+		transfer := func(from, to string, amount int, at time.Time, status TransactionStatus) string {
+			tx := transaction{
+				ID:        base.ID(),
+				Timestamp: at,
+				Status:    status,
+				Lines: []transactionLine{
+					{AccountID: from, Purpose: ACHDebit, Amount: amount},
+					{AccountID: to, Purpose: ACHCredit, Amount: amount},
+				},
+			}
+			if err := repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+				t.Fatal(err)
+			}
+			return tx.ID
+		}
+		deposit := transaction{ID: base.ID(), Timestamp: march.AddDate(0, 0, -3), Lines: []transactionLine{{AccountID: "funding", Purpose: ACHCredit, Amount: 5000}}}
+		if err := repo.createTransaction(ctx, deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+		transfer("funding", "alice", 1000, march, TransactionPosted)
+		transfer("alice", "funding", 250, march.Add(time.Hour), TransactionPosted)
+		pending := transfer("alice", "funding", 100, march.AddDate(0, 0, 2), TransactionPending)
+
+		totals, err := repo.getDailyTotals("alice", time.Time{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(totals, []dailyTotal{{Day: "2020-03-04", Credits: 1000, Debits: 250, Lines: 2}}) {
+			t.Errorf("totals=%#v", totals)
+		}
+
+		// posting the pending transaction changes its day, which the background job picks up
+		if err := repo.updateTransactionStatus(pending, TransactionPosted); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := repo.refreshDailyTotals(""); err != nil || n != 2 {
+			t.Errorf("refreshed=%d error=%v", n, err)
+		}
+		if n, err := repo.refreshDailyTotals(""); err != nil || n != 0 {
+			t.Errorf("refreshed=%d error=%v", n, err)
+		}
+		totals, err = repo.getDailyTotals("alice", time.Time{})
+		if err != nil || len(totals) != 2 || totals[1] != (dailyTotal{Day: "2020-03-06", Debits: 100, Lines: 1}) {
+			t.Errorf("totals=%#v error=%v", totals, err)
+		}
+		if balance := sumDailyTotals(totals); balance != 650 {
+			t.Errorf("balance=%d", balance)
+		}
+
+		// end excludes its own day
+		if totals, err := repo.getDailyTotals("funding", march.AddDate(0, 0, 2)); err != nil || len(totals) != 2 || sumDailyTotals(totals) != 4250 {
+			t.Errorf("totals=%#v error=%v", totals, err)
+		}
+
+		// compacted lines are still totalled
+		if _, err := repo.compactTransactionLines(time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`insert into account_daily_total_changes(account_id, transaction_id) values ('alice', ?);`, pending); err != nil {
+			t.Fatal(err)
+		}
+		if again, err := repo.getDailyTotals("alice", time.Time{}); err != nil || !reflect.DeepEqual(again, totals) {
+			t.Errorf("totals=%#v error=%v", again, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, sqliteDB.DB)
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, mysqlDB.DB)
+}
+
+func TestDailyTotals__balanceHistory(t *testing.T) {
+	_, transactionRepo := createTestLedger(t, map[string]int{"a": 0})
+	for i, amount := range []int{100, 250, 40} {
+		tx := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Date(2020, time.March, 1+i, 12, 0, 0, 0, time.UTC),
+			Lines:     []transactionLine{{AccountID: "a", Purpose: ACHCredit, Amount: amount}},
+		}
+		if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := mux.NewRouter()
+	router.Handle("/accounts/{accountId}/balance-history", getBalanceHistory(log.NewNopLogger(), transactionRepo))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/accounts/a/balance-history?startDate=2020-03-02&endDate=2020-03-02", nil))
+	var history balanceHistory
+	if err := json.NewDecoder(w.Body).Decode(&history); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("status=%d error=%v", w.Code, err)
+	}
+	if history.OpeningBalance != 100 || len(history.Days) != 1 || history.Days[0].Day != "2020-03-02" || history.Days[0].Balance != 350 {
+		t.Errorf("history=%#v", history)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/accounts/a/balance-history?startDate=March", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// sqliteTable is the definition of a table which replaces its existing table. Create names the new table with a
+// _rebuild suffix and Copy selects the existing rows in the order of its columns, filling in values for columns which
+// are now NOT NULL.
+type sqliteTable struct {
+	Name   string
+	Create string
+	Copy   string
+}
+
+// sqliteLedgerTables declare column types, NOT NULL and primary keys for the tables holding accounts, transactions
+// and balances, which were created with typeless and nullable columns. SQLite allows NULL in a primary key which isn't
+// an integer unless the column is declared NOT NULL.
+var sqliteLedgerTables = []sqliteTable{
+	{
+		Name:   "accounts",
+		Create: `create table accounts_rebuild(account_id text not null primary key, customer_id text not null default '', organization_id text not null default '', name text not null default '', account_number text not null, routing_number text not null, status text not null default '', type text not null default '', overdraft_limit integer not null default 0, created_at datetime not null, closed_at datetime, last_modified datetime, deleted_at datetime, unique(account_number, routing_number));`,
+		Copy:   `select account_id, coalesce(customer_id, ''), coalesce(organization_id, ''), coalesce(name, ''), account_number, routing_number, coalesce(status, ''), coalesce(type, ''), coalesce(overdraft_limit, 0), coalesce(created_at, last_modified, current_timestamp), closed_at, last_modified, deleted_at from accounts;`,
+	},
+	{
+		Name:   "transactions",
+		Create: `create table transactions_rebuild(transaction_id text not null primary key, organization_id text not null default '', timestamp datetime not null, created_at datetime not null, deleted_at datetime, status text not null default 'posted', expires_at datetime, idempotency_key text, force_post_id text, metadata text, description text);`,
+		Copy:   `select transaction_id, coalesce(organization_id, ''), coalesce(timestamp, created_at, current_timestamp), coalesce(created_at, timestamp, current_timestamp), deleted_at, coalesce(status, 'posted'), expires_at, idempotency_key, force_post_id, metadata, description from transactions;`,
+	},
+	{
+		Name:   "transaction_lines",
+		Create: `create table transaction_lines_rebuild(transaction_id text not null, account_id text not null, organization_id text not null default '', purpose text not null, amount integer not null, created_at datetime not null, deleted_at datetime, department text, product text, region text, mcc text, merchant_country text, description text, primary key(transaction_id, account_id), foreign key (transaction_id) references transactions(transaction_id), foreign key (account_id) references accounts(account_id));`,
+		Copy:   `select transaction_id, account_id, coalesce(organization_id, ''), coalesce(purpose, ''), coalesce(amount, 0), coalesce(created_at, current_timestamp), deleted_at, department, product, region, mcc, merchant_country, description from transaction_lines;`,
+	},
+	{
+		Name:   "account_balances",
+		Create: `create table account_balances_rebuild(account_id text not null, stripe integer not null default 0, balance integer not null default 0, last_modified datetime, primary key(account_id, stripe));`,
+		Copy:   `select account_id, coalesce(stripe, 0), coalesce(balance, 0), last_modified from account_balances;`,
+	},
+}
+
+// sqliteUntypedLedgerTables are the definitions sqliteLedgerTables replaced, with the columns added to them since
+// they were created, which rolling back restores.
+var sqliteUntypedLedgerTables = []sqliteTable{
+	{
+		Name:   "accounts",
+		Create: `create table accounts_rebuild(account_id primary key, customer_id, name, account_number, routing_number, status, type, created_at datetime, closed_at datetime, last_modified datetime, deleted_at datetime, organization_id not null default '', overdraft_limit integer not null default 0, unique(account_number, routing_number));`,
+		Copy:   `select account_id, customer_id, name, account_number, routing_number, status, type, created_at, closed_at, last_modified, deleted_at, organization_id, overdraft_limit from accounts;`,
+	},
+	{
+		Name:   "transactions",
+		Create: `create table transactions_rebuild(transaction_id primary key, timestamp datetime, created_at datetime, deleted_at datetime, status default 'posted', expires_at datetime, idempotency_key, force_post_id, organization_id not null default '', metadata, description);`,
+		Copy:   `select transaction_id, timestamp, created_at, deleted_at, status, expires_at, idempotency_key, force_post_id, organization_id, metadata, description from transactions;`,
+	},
+	{
+		Name:   "transaction_lines",
+		Create: `create table transaction_lines_rebuild(transaction_id, account_id, purpose, amount integer, created_at datetime, deleted_at datetime, department, product, region, organization_id not null default '', mcc, merchant_country, description, unique(transaction_id, account_id), foreign key (transaction_id) references transactions(transaction_id), foreign key (account_id) references accounts(account_id));`,
+		Copy:   `select transaction_id, account_id, purpose, amount, created_at, deleted_at, department, product, region, organization_id, mcc, merchant_country, description from transaction_lines;`,
+	},
+	{
+		Name:   "account_balances",
+		Create: `create table account_balances_rebuild(account_id, stripe integer, balance integer, last_modified datetime, primary key(account_id, stripe));`,
+		Copy:   `select account_id, stripe, balance, last_modified from account_balances;`,
+	},
+}
+
+// sqliteRebuild replaces each table with its definition in up, or in down when rolling back, as SQLite can't change
+// the columns of an existing table. Rows missing a key fail the migration rather than being dropped.
+func sqliteRebuild(name string, up, down []sqliteTable) migration {
+	rebuild := func(tables []sqliteTable) migrationStep {
+		return migrationStep{Func: func(db *sql.DB) error {
+			return withSQLiteRebuild(db, func(ctx context.Context, tx *sql.Tx) error {
+				for _, table := range tables {
+					if err := rebuildSQLiteTable(ctx, tx, table); err != nil {
+						return fmt.Errorf("%s: %v", table.Name, err)
+					}
+				}
+				return nil
+			})
+		}}
+	}
+	return migration{Name: name, Up: rebuild(up), Down: rebuild(down)}
+}
+
+// withSQLiteRebuild calls fn in a transaction on a connection which allows tables to be dropped and replaced, and
+// commits the transaction when fn succeeds.
+func withSQLiteRebuild(db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Foreign keys can only be toggled outside of a transaction and must be off while tables they reference are
+	// swapped
+	var enabled int
+	if err := conn.QueryRowContext(ctx, `pragma foreign_keys;`).Scan(&enabled); err != nil {
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, `pragma foreign_keys = off;`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, fmt.Sprintf(`pragma foreign_keys = %d;`, enabled))
+
+	// Triggers on other tables can refer to the tables being swapped, which are missing between dropping
+	// them and renaming their replacements.
+	if _, err := conn.ExecContext(ctx, `pragma legacy_alter_table = on;`); err != nil {
+		return err
+	}
+	defer conn.ExecContext(ctx, `pragma legacy_alter_table = off;`)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(ctx, tx); err != nil {
+		return fmt.Errorf("%v rollback=%v", err, tx.Rollback())
+	}
+	return tx.Commit()
+}
+
+// rebuildSQLiteTable copies the rows of a table into its replacement, drops it and creates its indexes and triggers
+// again on the replacement.
+func rebuildSQLiteTable(ctx context.Context, tx *sql.Tx, table sqliteTable) error {
+	rows, err := tx.QueryContext(ctx, `select sql from sqlite_master where type in ('index', 'trigger') and tbl_name = ? and sql is not null;`, table.Name)
+	if err != nil {
+		return err
+	}
+	var schema []string
+	for rows.Next() {
+		var definition string
+		if err := rows.Scan(&definition); err != nil {
+			rows.Close()
+			return err
+		}
+		schema = append(schema, definition)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	statements := []string{
+		table.Create,
+		fmt.Sprintf(`insert into %s_rebuild %s`, table.Name, table.Copy),
+		fmt.Sprintf(`drop table %s;`, table.Name),
+		fmt.Sprintf(`alter table %s_rebuild rename to %s;`, table.Name, table.Name),
+	}
+	for _, statement := range append(statements, schema...) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var createTableName = regexp.MustCompile(`(?i)^create table (?:if not exists )?"?\w+"?\s*\(`)
+
+// sqliteAddColumn adds a column to a table, which is reverted by rebuilding the table without it as SQLite can't drop
+// columns. Indexes and triggers on the column must be reverted first.
+func sqliteAddColumn(name, table, definition string) migration {
+	column := strings.Fields(definition)[0]
+	return migration{
+		Name: name,
+		Up:   migrationStep{SQL: fmt.Sprintf(`alter table %s add column %s;`, table, definition)},
+		Down: migrationStep{Func: func(db *sql.DB) error {
+			return withSQLiteRebuild(db, func(ctx context.Context, tx *sql.Tx) error {
+				t, err := sqliteTableWithout(ctx, tx, table, column)
+				if err != nil {
+					return err
+				}
+				return rebuildSQLiteTable(ctx, tx, t)
+			})
+		}},
+	}
+}
+
+// sqliteTableWithout returns the definition of a table without one of its columns, which SQLite adds to the end of
+// the column definitions.
+func sqliteTableWithout(ctx context.Context, tx *sql.Tx, table, column string) (sqliteTable, error) {
+	var create string
+	if err := tx.QueryRowContext(ctx, `select sql from sqlite_master where type = 'table' and name = ?;`, table).Scan(&create); err != nil {
+		return sqliteTable{}, err
+	}
+	if !createTableName.MatchString(create) {
+		return sqliteTable{}, fmt.Errorf("unexpected definition of %s: %s", table, create)
+	}
+	create = createTableName.ReplaceAllString(create, fmt.Sprintf("create table %s_rebuild(", table))
+
+	// Column definitions never contain commas or parentheses, which separate them from the next definition
+	definition := regexp.MustCompile(fmt.Sprintf(`(?i),\s*"?%s"?(\s[^,()]*)?[,)]`, regexp.QuoteMeta(column)))
+	loc := definition.FindStringIndex(create)
+	if loc == nil {
+		return sqliteTable{}, fmt.Errorf("column %s not found in %s", column, table)
+	}
+	create = create[:loc[0]] + create[loc[1]-1:]
+
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`pragma table_info(%s);`, table))
+	if err != nil {
+		return sqliteTable{}, err
+	}
+	defer rows.Close()
+	var columns []string
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name             string
+			_type, dflt      sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &_type, &notNull, &dflt, &pk); err != nil {
+			return sqliteTable{}, err
+		}
+		if !strings.EqualFold(name, column) {
+			columns = append(columns, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return sqliteTable{}, err
+	}
+	return sqliteTable{
+		Name:   table,
+		Create: create + ";",
+		Copy:   fmt.Sprintf(`select %s from %s;`, strings.Join(columns, ", "), table),
+	}, nil
+}
+
+// mysqlNotNull fills in the NULL values of a column before making it NOT NULL, as MySQL would otherwise replace them
+// with the type's implicit default. Columns without a fill must not have NULL values.
+func mysqlNotNull(table, column, definition, fill string) []string {
+	var out []string
+	if fill != "" {
+		out = append(out, fmt.Sprintf(`update %s set %s = %s where %s is null;`, table, column, fill, column))
+	}
+	return append(out, fmt.Sprintf(`alter table %s modify %s %s not null;`, table, column, definition))
+}
+
+// mysqlLedgerNotNull are the columns of the tables holding accounts, transactions and balances which are NOT NULL,
+// with their definitions and the values filling in their NULLs.
+var mysqlLedgerNotNull = []struct {
+	table, column, definition, fill string
+}{
+	{"accounts", "customer_id", "varchar(40) default ''", "''"},
+	{"accounts", "name", "varchar(50) default ''", "''"},
+	{"accounts", "status", "varchar(15) default ''", "''"},
+	{"accounts", "type", "varchar(12) default ''", "''"},
+	{"accounts", "account_number", "varchar(15)", ""},
+	{"accounts", "routing_number", "varchar(10)", ""},
+	{"accounts", "created_at", "datetime", "coalesce(last_modified, now())"},
+	{"transactions", "timestamp", "datetime", "coalesce(created_at, now())"},
+	{"transactions", "created_at", "datetime", "coalesce(timestamp, now())"},
+	{"transaction_lines", "purpose", "varchar(12)", "''"},
+	{"transaction_lines", "amount", "integer", "0"},
+	{"transaction_lines", "created_at", "datetime", "now()"},
+	{"account_balances", "balance", "bigint default 0", "0"},
+}
+
+// mysqlLedgerColumns declares NOT NULL and primary keys for the tables holding accounts, transactions and balances.
+// Rolling back makes the columns nullable again, though the NULLs filled in are kept.
+func mysqlLedgerColumns(name string) migration {
+	var up, down []string
+	for _, c := range mysqlLedgerNotNull {
+		up = append(up, mysqlNotNull(c.table, c.column, c.definition, c.fill)...)
+		down = append([]string{fmt.Sprintf(`alter table %s modify %s %s null;`, c.table, c.column, c.definition)}, down...)
+	}
+	up = append(up, `alter table transaction_lines add primary key (transaction_id, account_id);`)
+	down = append([]string{`alter table transaction_lines drop primary key;`}, down...)
+	return migration{Name: name, Up: mysqlStatements(up...), Down: mysqlStatements(down...)}
+}
+
+// mysqlStatements is a migration step of several statements, as MySQL connections run one statement at a time.
+func mysqlStatements(statements ...string) migrationStep {
+	return migrationStep{Func: func(db *sql.DB) error {
+		for i := range statements {
+			if _, err := db.Exec(statements[i]); err != nil {
+				return fmt.Errorf("%s: %v", statements[i], err)
+			}
+		}
+		return nil
+	}}
+}
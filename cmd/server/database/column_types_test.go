@@ -0,0 +1,118 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLite__ledgerColumnTypes(t *testing.T) {
+	db := CreateTestSqliteDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	if _, err := db.DB.Exec(`insert into accounts(account_id, customer_id, account_number, routing_number, created_at) values ('alice', null, '1234', '987654320', ?);`, now); err == nil {
+		t.Error("expected NOT NULL error")
+	}
+	if _, err := db.DB.Exec(`insert into accounts(account_id, account_number, routing_number, created_at) values (null, '1234', '987654320', ?);`, now); err == nil {
+		t.Error("expected NOT NULL error for primary key")
+	}
+	if _, err := db.DB.Exec(`insert into transactions(transaction_id, timestamp, created_at) values ('deposit', ?, ?);`, now, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB.Exec(`insert into transaction_lines(transaction_id, account_id, purpose, amount, created_at) values ('deposit', null, 'achcredit', 100, ?);`, now); err == nil {
+		t.Error("expected NOT NULL error")
+	}
+	if _, err := db.DB.Exec(`insert into transaction_lines(transaction_id, account_id, purpose, amount, created_at) values ('deposit', 'alice', 'achcredit', 100, ?);`, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rebuilding keeps rows and indexes
+	rebuild := sqliteRebuild("test", sqliteLedgerTables, sqliteUntypedLedgerTables)
+	if err := rebuild.Up.Func(db.DB); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.DB.QueryRow(`select count(*) from transaction_lines where transaction_id = 'deposit';`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+	var indexes []string
+	rows, err := db.DB.Query(`select name from sqlite_master where type = 'index' and tbl_name = 'transactions' and sql is not null order by name;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+	if !strings.Contains(strings.Join(indexes, ","), "transactions_organization_idempotency_key_index") {
+		t.Errorf("indexes: %v", indexes)
+	}
+
+	var columnType string
+	var notNull int
+	if err := db.DB.QueryRow(`select type, "notnull" from pragma_table_info('transaction_lines') where name = 'amount';`).Scan(&columnType, &notNull); err != nil || !strings.EqualFold(columnType, "integer") || notNull != 1 {
+		t.Errorf("type=%s notnull=%d error=%v", columnType, notNull, err)
+	}
+
+	// Rolling back restores the untyped columns and keeps the rows
+	if err := rebuild.Down.Func(db.DB); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB.QueryRow(`select type, "notnull" from pragma_table_info('transaction_lines') where name = 'amount';`).Scan(&columnType, &notNull); err != nil || notNull != 0 {
+		t.Errorf("type=%s notnull=%d error=%v", columnType, notNull, err)
+	}
+	if err := db.DB.QueryRow(`select count(*) from transaction_lines where transaction_id = 'deposit';`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+}
+
+func TestSQLite__addColumn(t *testing.T) {
+	db := CreateTestSqliteDB(t)
+	defer db.Close()
+
+	if _, err := db.DB.Exec(`create table add_column_tests(id text primary key, name, unique(name));`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB.Exec(`create index add_column_tests_name_index on add_column_tests(name);`); err != nil {
+		t.Fatal(err)
+	}
+	m := sqliteAddColumn("test", "add_column_tests", "status not null default 'new'")
+	if _, err := db.DB.Exec(m.Up.SQL); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB.Exec(`insert into add_column_tests(id, name, status) values ('a', 'alice', 'old');`); err != nil {
+		t.Fatal(err)
+	}
+
+	// dropping the column keeps the other columns, constraints and indexes
+	if err := m.Down.Func(db.DB); err != nil {
+		t.Fatal(err)
+	}
+	var name string
+	if err := db.DB.QueryRow(`select name from add_column_tests where id = 'a';`).Scan(&name); err != nil || name != "alice" {
+		t.Errorf("name=%q error=%v", name, err)
+	}
+	if _, err := db.DB.Exec(`select status from add_column_tests;`); err == nil {
+		t.Error("expected status to be dropped")
+	}
+	if _, err := db.DB.Exec(`insert into add_column_tests(id, name) values ('b', 'alice');`); err == nil {
+		t.Error("expected unique constraint error")
+	}
+	var n int
+	if err := db.DB.QueryRow(`select count(*) from sqlite_master where type = 'index' and name = 'add_column_tests_name_index';`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+
+	if err := sqliteAddColumn("test", "add_column_tests", "missing").Down.Func(db.DB); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -13,7 +13,7 @@ import (
 
 	"github.com/go-kit/kit/log"
 	kitprom "github.com/go-kit/kit/metrics/prometheus"
-	"github.com/lopezator/migrator"
+	gomysql "github.com/go-sql-driver/mysql"
 )
 
 func New(ctx context.Context, logger log.Logger, _type string) (*sql.DB, error) {
@@ -23,18 +23,99 @@ func New(ctx context.Context, logger log.Logger, _type string) (*sql.DB, error)
 		return SQLiteConnection(logger, SQLitePath()).Connect(ctx)
 	case "mysql":
 		return mysqlConnection(logger, os.Getenv("MYSQL_USER"), os.Getenv("MYSQL_PASSWORD"), os.Getenv("MYSQL_ADDRESS"), os.Getenv("MYSQL_DATABASE")).Connect(ctx)
+	case "memory":
+		// Accounts and transactions are kept in Go maps with memory storage, but attachments, statements
+		// and webhooks are stored in a private SQLite database which is never written to disk.
+		return SQLiteUnreplicatedConnection(logger, SQLiteMemoryPath).Connect(ctx)
 	}
 	return nil, fmt.Errorf("unknown database type %q", _type)
 }
 
-func execsql(name, raw string) *migrator.MigrationNoTx {
-	return &migrator.MigrationNoTx{
-		Name: name,
-		Func: func(db *sql.DB) error {
-			_, err := db.Exec(raw)
-			return err
-		},
+// NewWithoutMigrations returns a connection to the database New connects to without migrating it, so its migrations
+// can be listed or rolled back. SQLite databases are opened without restoring or replicating them through litestream.
+func NewWithoutMigrations(ctx context.Context, logger log.Logger, _type string) (*sql.DB, error) {
+	switch strings.ToLower(_type) {
+	case "sqlite", "":
+		s := SQLiteUnreplicatedConnection(logger, SQLitePath())
+		s.skipMigrations = true
+		return s.Connect(ctx)
+	case "mysql":
+		my := mysqlConnection(logger, os.Getenv("MYSQL_USER"), os.Getenv("MYSQL_PASSWORD"), os.Getenv("MYSQL_ADDRESS"), os.Getenv("MYSQL_DATABASE"))
+		my.skipMigrations = true
+		return my.Connect(ctx)
+	}
+	return nil, fmt.Errorf("unknown database type %q", _type)
+}
+
+// NewShadow returns a database connection used as the shadow copy of a primary during storage
+// migrations. SQLite shadows are stored at SQLITE_SHADOW_DB_PATH so they never share a file
+// with the primary.
+//
+// Shadows never enforce foreign keys as accounts and transactions are copied into them separately.
+func NewShadow(ctx context.Context, logger log.Logger, _type string) (*sql.DB, error) {
+	switch strings.ToLower(_type) {
+	case "sqlite":
+		s := SQLiteConnection(logger, SQLiteShadowPath())
+		s.foreignKeys = false
+		return s.Connect(ctx)
+	case "mysql":
+		my := mysqlConnection(logger, os.Getenv("MYSQL_USER"), os.Getenv("MYSQL_PASSWORD"), os.Getenv("MYSQL_ADDRESS"), os.Getenv("MYSQL_DATABASE"))
+		my.foreignKeys = false
+		return my.Connect(ctx)
+	}
+	return New(ctx, logger, _type)
+}
+
+// NewShard returns a connection to one shard of a horizontally partitioned deployment.
+//
+// SQLite shards are stored next to SQLITE_DB_PATH (accounts.db becomes accounts-shard1.db) and
+// MySQL shards are read from the comma separated MYSQL_SHARD_ADDRESSES, one address per shard.
+func NewShard(ctx context.Context, logger log.Logger, _type string, shard int) (*sql.DB, error) {
+	switch strings.ToLower(_type) {
+	case "sqlite", "":
+		return SQLiteConnection(logger, SQLiteShardPath(shard)).Connect(ctx)
+	case "mysql":
+		address, err := mysqlShardAddress(shard)
+		if err != nil {
+			return nil, err
+		}
+		return mysqlConnection(logger, os.Getenv("MYSQL_USER"), os.Getenv("MYSQL_PASSWORD"), address, os.Getenv("MYSQL_DATABASE")).Connect(ctx)
 	}
+	return nil, fmt.Errorf("unknown database type %q", _type)
+}
+
+// NewShardWithoutMigrations returns a connection to the shard NewShard connects to without migrating it, like
+// NewWithoutMigrations.
+func NewShardWithoutMigrations(ctx context.Context, logger log.Logger, _type string, shard int) (*sql.DB, error) {
+	switch strings.ToLower(_type) {
+	case "sqlite", "":
+		s := SQLiteUnreplicatedConnection(logger, SQLiteShardPath(shard))
+		s.skipMigrations = true
+		return s.Connect(ctx)
+	case "mysql":
+		address, err := mysqlShardAddress(shard)
+		if err != nil {
+			return nil, err
+		}
+		my := mysqlConnection(logger, os.Getenv("MYSQL_USER"), os.Getenv("MYSQL_PASSWORD"), address, os.Getenv("MYSQL_DATABASE"))
+		my.skipMigrations = true
+		return my.Connect(ctx)
+	}
+	return nil, fmt.Errorf("unknown database type %q", _type)
+}
+
+func mysqlShardAddress(shard int) (string, error) {
+	addresses := strings.Split(os.Getenv("MYSQL_SHARD_ADDRESSES"), ",")
+	if shard >= len(addresses) || strings.TrimSpace(addresses[shard]) == "" {
+		return "", fmt.Errorf("no MYSQL_SHARD_ADDRESSES entry for shard %d", shard)
+	}
+	return strings.TrimSpace(addresses[shard]), nil
+}
+
+// BalanceConstraintViolation returns true when the provided error is from the database rejecting a change to an
+// account's balance which takes it past the floor or ceiling of its balance_constraints.
+func BalanceConstraintViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "balance constraint: ")
 }
 
 // UniqueViolation returns true when the provided error matches a database error
@@ -43,6 +124,16 @@ func UniqueViolation(err error) bool {
 	return MySQLUniqueViolation(err) || SqliteUniqueViolation(err)
 }
 
+// ForShare returns the clause which makes a select inside a transaction hold a shared lock on the rows it read
+// until the transaction ends, so they can't be changed underneath it. SQLite doesn't need one as it locks the
+// whole database for writes.
+func ForShare(db *sql.DB) string {
+	if _, ok := db.Driver().(*gomysql.MySQLDriver); ok {
+		return " lock in share mode"
+	}
+	return ""
+}
+
 func recordStatus(metric *kitprom.Gauge, db *sql.DB) {
 	stats := db.Stats()
 	metric.With("state", "idle").Set(float64(stats.Idle))
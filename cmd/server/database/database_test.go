@@ -6,6 +6,8 @@ package database
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/go-kit/kit/log"
@@ -28,10 +30,25 @@ func TestDatabase(t *testing.T) {
 		t.Error("expected error")
 	}
 
+	// keep the database out of the package directory
+	os.Setenv("SQLITE_DB_PATH", filepath.Join(t.TempDir(), "accounts.db"))
+	defer os.Unsetenv("SQLITE_DB_PATH")
+
 	if db, err := New(ctx, logger, "sqlite"); err != nil {
 		t.Fatal(err)
 	} else {
 		recordStatus(connections, db)
 		db.Close()
 	}
+
+	// memory databases are migrated and keep their single connection open
+	if db, err := New(ctx, logger, "memory"); err != nil {
+		t.Fatal(err)
+	} else {
+		var n int
+		if err := db.QueryRow(`select count(*) from schema_migrations;`).Scan(&n); err != nil || n == 0 {
+			t.Errorf("n=%d error=%v", n, err)
+		}
+		db.Close()
+	}
 }
@@ -0,0 +1,125 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ForeignKeys returns true when foreign keys from transaction lines to their transactions and accounts are enforced,
+// which DATABASE_FOREIGN_KEYS controls. They're enforced by default unless accounts and transactions are stored in
+// different databases, as the accounts table next to transactions would then be empty.
+func ForeignKeys() (bool, error) {
+	if v := os.Getenv("DATABASE_FOREIGN_KEYS"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("invalid DATABASE_FOREIGN_KEYS=%q: %v", v, err)
+		}
+		return enabled, nil
+	}
+	return strings.EqualFold(storageType("ACCOUNT_STORAGE_TYPE"), storageType("TRANSACTION_STORAGE_TYPE")), nil
+}
+
+func storageType(key string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return "sqlite"
+}
+
+// transactionLineViolations records the transaction lines which broke a foreign key when the keys were added.
+// The lines themselves are kept, the /orphans admin route reports and repairs them.
+const transactionLineViolations = `insert into transaction_line_violations(transaction_id, account_id, reason, found_at)
+select l.transaction_id, l.account_id, 'transaction_missing', current_timestamp from transaction_lines l
+where not exists (select 1 from transactions t where t.transaction_id = l.transaction_id)
+union all
+select l.transaction_id, l.account_id, 'account_missing', current_timestamp from transaction_lines l
+where exists (select 1 from accounts) and not exists (select 1 from accounts a where a.account_id = l.account_id);`
+
+// sqliteForeignKeys rebuilds transaction_lines with foreign keys, as SQLite can't add them to an existing table.
+// Lines which already violate them are recorded in transaction_line_violations and copied over unchanged.
+func sqliteForeignKeys(name string) migration {
+	return migration{
+		Name: name,
+		Up: migrationStep{Func: func(db *sql.DB) error {
+			return withSQLiteRebuild(db, func(ctx context.Context, tx *sql.Tx) error {
+				statements := []string{
+					`create table if not exists transaction_line_violations(transaction_id, account_id, reason, found_at datetime);`,
+					transactionLineViolations,
+				}
+				for i := range statements {
+					if _, err := tx.ExecContext(ctx, statements[i]); err != nil {
+						return err
+					}
+				}
+				return rebuildSQLiteTable(ctx, tx, sqliteTable{
+					Name:   "transaction_lines",
+					Create: `create table transaction_lines_rebuild(transaction_id, account_id, purpose, amount integer, created_at datetime, deleted_at datetime, department, product, region, organization_id not null default '', mcc, merchant_country, description, unique(transaction_id, account_id), foreign key (transaction_id) references transactions(transaction_id), foreign key (account_id) references accounts(account_id));`,
+					Copy:   `select transaction_id, account_id, purpose, amount, created_at, deleted_at, department, product, region, organization_id, mcc, merchant_country, description from transaction_lines;`,
+				})
+			})
+		}},
+		Down: migrationStep{Func: func(db *sql.DB) error {
+			return withSQLiteRebuild(db, func(ctx context.Context, tx *sql.Tx) error {
+				err := rebuildSQLiteTable(ctx, tx, sqliteTable{
+					Name:   "transaction_lines",
+					Create: `create table transaction_lines_rebuild(transaction_id, account_id, purpose, amount integer, created_at datetime, deleted_at datetime, department, product, region, organization_id not null default '', mcc, merchant_country, description, unique(transaction_id, account_id));`,
+					Copy:   `select transaction_id, account_id, purpose, amount, created_at, deleted_at, department, product, region, organization_id, mcc, merchant_country, description from transaction_lines;`,
+				})
+				if err != nil {
+					return err
+				}
+				_, err = tx.ExecContext(ctx, `drop table if exists transaction_line_violations;`)
+				return err
+			})
+		}},
+	}
+}
+
+// mysqlForeignKeys adds foreign keys to transaction_lines without checking the lines already stored, which are
+// recorded in transaction_line_violations when they violate them.
+func mysqlForeignKeys(name string) migration {
+	return migration{
+		Name: name,
+		Up: migrationStep{Func: func(db *sql.DB) error {
+			ctx := context.Background()
+			conn, err := db.Conn(ctx)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			var checks int
+			if err := conn.QueryRowContext(ctx, `select @@foreign_key_checks;`).Scan(&checks); err != nil {
+				return err
+			}
+			defer conn.ExecContext(ctx, fmt.Sprintf(`set foreign_key_checks = %d;`, checks))
+
+			statements := []string{
+				`create table if not exists transaction_line_violations(transaction_id varchar(40), account_id varchar(40), reason varchar(20), found_at datetime);`,
+				transactionLineViolations,
+				`set foreign_key_checks = 0;`,
+				`alter table transaction_lines add constraint transaction_lines_transaction_fk foreign key (transaction_id) references transactions(transaction_id);`,
+				`alter table transaction_lines add constraint transaction_lines_account_fk foreign key (account_id) references accounts(account_id);`,
+			}
+			for i := range statements {
+				if _, err := conn.ExecContext(ctx, statements[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}},
+		Down: mysqlStatements(
+			`alter table transaction_lines drop foreign key transaction_lines_account_fk;`,
+			`alter table transaction_lines drop foreign key transaction_lines_transaction_fk;`,
+			`drop table if exists transaction_line_violations;`,
+		),
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestForeignKeys(t *testing.T) {
+	if enabled, err := ForeignKeys(); !enabled || err != nil {
+		t.Errorf("enabled=%v error=%v", enabled, err)
+	}
+
+	os.Setenv("TRANSACTION_STORAGE_TYPE", "mysql")
+	defer os.Unsetenv("TRANSACTION_STORAGE_TYPE")
+	if enabled, err := ForeignKeys(); enabled || err != nil {
+		t.Errorf("enabled=%v error=%v", enabled, err)
+	}
+
+	os.Setenv("DATABASE_FOREIGN_KEYS", "true")
+	defer os.Unsetenv("DATABASE_FOREIGN_KEYS")
+	if enabled, err := ForeignKeys(); !enabled || err != nil {
+		t.Errorf("enabled=%v error=%v", enabled, err)
+	}
+
+	os.Setenv("DATABASE_FOREIGN_KEYS", "sometimes")
+	if _, err := ForeignKeys(); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := SQLiteConnection(log.NewNopLogger(), SQLiteMemoryPath).Connect(context.Background()); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSQLite__foreignKeys(t *testing.T) {
+	db := CreateTestSqliteDB(t)
+	defer db.Close()
+
+	now := time.Now()
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := db.DB.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	exec(`insert into accounts(account_id, account_number, routing_number, created_at) values ('alice', '1234', '987654320', ?);`, now)
+	exec(`insert into transactions(transaction_id, timestamp, created_at) values ('deposit', ?, ?);`, now, now)
+	exec(`insert into transaction_lines(transaction_id, account_id, purpose, amount, created_at) values ('deposit', 'alice', 'achcredit', 100, ?);`, now)
+	exec(`insert into transaction_lines(transaction_id, account_id, purpose, amount, created_at) values ('missing', 'alice', 'achdebit', 100, ?);`, now)
+	exec(`insert into transaction_lines(transaction_id, account_id, purpose, amount, created_at) values ('deposit', 'ghost', 'achdebit', 100, ?);`, now)
+
+	// Rebuilding the table keeps lines which violate the keys and records them
+	if err := sqliteForeignKeys("test").Up.Func(db.DB); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.DB.QueryRow(`select count(*) from transaction_lines;`).Scan(&n); err != nil || n != 3 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+	rows, err := db.DB.Query(`select transaction_id, account_id, reason from transaction_line_violations order by reason;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var violations []string
+	for rows.Next() {
+		var transactionID, accountID, reason string
+		if err := rows.Scan(&transactionID, &accountID, &reason); err != nil {
+			t.Fatal(err)
+		}
+		violations = append(violations, strings.Join([]string{transactionID, accountID, reason}, "/"))
+	}
+	rows.Close()
+	if strings.Join(violations, ",") != "deposit/ghost/account_missing,missing/alice/transaction_missing" {
+		t.Errorf("violations: %v", violations)
+	}
+
+	// Open the database again with foreign keys enforced
+	s := SQLiteConnection(log.NewNopLogger(), filepath.Join(db.Dir, "accounts.db"))
+	enforced, err := s.Connect(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer enforced.Close()
+
+	if _, err := enforced.Exec(`insert into transaction_lines(transaction_id, account_id, purpose, amount, created_at) values ('deposit', 'bob', 'achcredit', 100, ?);`, now); err == nil {
+		t.Error("expected error for missing account")
+	}
+	if _, err := enforced.Exec(`insert into transaction_lines(transaction_id, account_id, purpose, amount, created_at) values ('other', 'alice', 'achcredit', 100, ?);`, now); err == nil {
+		t.Error("expected error for missing transaction")
+	}
+	// lines which violated the keys before they were added can still be deleted
+	if _, err := enforced.Exec(`update transaction_lines set deleted_at = ? where account_id = 'ghost';`, now); err != nil {
+		t.Error(err)
+	}
+}
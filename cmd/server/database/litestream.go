@@ -0,0 +1,114 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+)
+
+// LitestreamReplicaURL returns the location (e.g. s3://bucket/accounts) SQLite databases are continuously
+// replicated to with litestream. Each database file is replicated under its own name beneath this URL.
+func LitestreamReplicaURL() string {
+	return strings.TrimSuffix(strings.TrimSpace(os.Getenv("LITESTREAM_REPLICA_URL")), "/")
+}
+
+func litestreamBinary() string {
+	if v := os.Getenv("LITESTREAM_PATH"); v != "" {
+		return v
+	}
+	return "litestream"
+}
+
+// litestream manages restoring SQLite databases from their replica on startup and running a
+// `litestream replicate` process for each database file.
+type litestream struct {
+	binary     string
+	replicaURL string
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+var replicator = &litestream{running: make(map[string]bool)}
+
+func (l *litestream) replicaFor(path string) string {
+	return fmt.Sprintf("%s/%s", l.replicaURL, filepath.Base(path))
+}
+
+// restore copies the latest replica of path into place when path doesn't exist locally, which
+// lets a fresh host recover the database without any manual steps.
+func (l *litestream) restore(ctx context.Context, logger log.Logger, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return l.restoreFrom(ctx, logger, l.replicaFor(path), path, "-if-replica-exists")
+}
+
+func (l *litestream) restoreFrom(ctx context.Context, logger log.Logger, replica, path string, flags ...string) error {
+	logger.Log("litestream", fmt.Sprintf("restoring %s from %s", path, replica))
+
+	args := append([]string{"restore"}, flags...)
+	cmd := exec.CommandContext(ctx, l.binary, append(args, "-o", path, replica)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("litestream restore of %s: %v: %s", path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RestoreSQLiteReplica restores the latest replica of the database file name (e.g. accounts.db) into path.
+// An error is returned if replication isn't configured or no replica exists.
+func RestoreSQLiteReplica(ctx context.Context, logger log.Logger, name, path string) error {
+	l := &litestream{binary: litestreamBinary(), replicaURL: LitestreamReplicaURL()}
+	if l.replicaURL == "" {
+		return errors.New("LITESTREAM_REPLICA_URL is not set")
+	}
+	return l.restoreFrom(ctx, logger, l.replicaFor(name), path)
+}
+
+// replicate starts a background process which continuously copies changes of path to its replica
+// until ctx is cancelled. Calling replicate multiple times for the same path starts only one process.
+func (l *litestream) replicate(ctx context.Context, logger log.Logger, db *sql.DB, path string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.running[path] {
+		return nil
+	}
+
+	// litestream reads changes from the write-ahead log
+	if _, err := db.Exec("PRAGMA journal_mode = wal;"); err != nil {
+		return fmt.Errorf("litestream: enabling WAL on %s: %v", path, err)
+	}
+
+	cmd := exec.CommandContext(ctx, l.binary, "replicate", path, l.replicaFor(path))
+	cmd.Stdout = log.NewStdlibAdapter(log.With(logger, "litestream", path))
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("litestream replicate of %s: %v", path, err)
+	}
+	l.running[path] = true
+	logger.Log("litestream", fmt.Sprintf("replicating %s to %s", path, l.replicaFor(path)))
+
+	go func() {
+		err := cmd.Wait()
+		if ctx.Err() == nil {
+			logger.Log("litestream", fmt.Sprintf("replication of %s stopped: %v", path, err))
+		}
+		l.mu.Lock()
+		delete(l.running, path)
+		l.mu.Unlock()
+	}()
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestLitestream__ReplicaURL(t *testing.T) {
+	if v := LitestreamReplicaURL(); v != "" {
+		t.Errorf("got %s", v)
+	}
+	os.Setenv("LITESTREAM_REPLICA_URL", "s3://bucket/accounts/")
+	defer os.Unsetenv("LITESTREAM_REPLICA_URL")
+
+	if v := LitestreamReplicaURL(); v != "s3://bucket/accounts" {
+		t.Errorf("got %s", v)
+	}
+	if v := (&litestream{replicaURL: LitestreamReplicaURL()}).replicaFor("/data/accounts.db"); v != "s3://bucket/accounts/accounts.db" {
+		t.Errorf("got %s", v)
+	}
+}
+
+func TestLitestream(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake litestream binary is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "accounts-litestream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Fake litestream which records how it was called
+	calls := filepath.Join(dir, "calls")
+	binary := filepath.Join(dir, "litestream")
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\nif [ \"$1\" = \"replicate\" ]; then sleep 5; fi\n"
+	if err := ioutil.WriteFile(binary, []byte(script), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("LITESTREAM_PATH", binary)
+	os.Setenv("LITESTREAM_REPLICA_URL", "file:///replicas")
+	defer os.Unsetenv("LITESTREAM_PATH")
+	defer os.Unsetenv("LITESTREAM_REPLICA_URL")
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	defer cancelFunc()
+
+	path := filepath.Join(dir, "accounts.db")
+	db, err := SQLiteConnection(log.NewNopLogger(), path).Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// a second connection to the same file doesn't start another replicate process
+	db2, err := SQLiteConnection(log.NewNopLogger(), path).Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+
+	var bs []byte
+	for i := 0; i < 50; i++ {
+		bs, _ = ioutil.ReadFile(calls)
+		if strings.Contains(string(bs), "replicate") {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected calls: %q", lines)
+	}
+	if expected := "restore -if-replica-exists -o " + path + " file:///replicas/accounts.db"; lines[0] != expected {
+		t.Errorf("got %q", lines[0])
+	}
+	if expected := "replicate " + path + " file:///replicas/accounts.db"; lines[1] != expected {
+		t.Errorf("got %q", lines[1])
+	}
+}
@@ -0,0 +1,369 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	gomysql "github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Migrations change the schema of a database one version at a time. Each backend has a dialect with its own list
+// of migrations, numbered from 1 in the order they're listed, so new migrations are only ever appended. The version
+// and name of every applied migration is recorded in schema_migrations, which lets a server apply only what a
+// database is missing and refuse to start against a database whose history doesn't match its own or which a newer
+// release already migrated further.
+//
+// Every migration has an up step and a down step which Rollback runs to return a database to an earlier version, so
+// any release can be rolled back. Down steps undo the schema change and leave rows in the other columns and tables
+// as they are.
+//
+// Databases migrated before schema_migrations existed record their migrations by position in a migrations table,
+// which is copied over the first time they're migrated.
+
+// migration is one versioned change of a database's schema.
+type migration struct {
+	Name string
+	Up   migrationStep
+
+	// Down reverts Up. Migrations without one can't be rolled back, which the tests reject.
+	Down migrationStep
+}
+
+// migrationStep is either one SQL statement or a function for changes which need more than that.
+type migrationStep struct {
+	SQL  string
+	Func func(db *sql.DB) error
+}
+
+func (s migrationStep) empty() bool {
+	return s.SQL == "" && s.Func == nil
+}
+
+// execsql is a migration of one SQL statement, reverted by another.
+func execsql(name, up, down string) migration {
+	return migration{Name: name, Up: migrationStep{SQL: up}, Down: migrationStep{SQL: down}}
+}
+
+// dialect is how migrations are applied to one backend.
+type dialect struct {
+	name       string
+	migrations []migration
+
+	// transactionalDDL backends apply SQL steps in the same transaction as recording them, so they're applied
+	// entirely or not at all. Other steps are marked dirty while they run.
+	transactionalDDL bool
+
+	// hasTable returns the number of tables named by its argument
+	hasTable string
+
+	// lock, when set, holds a lock across servers until its release func is called, so only one migrates a database
+	lock func(ctx context.Context, db *sql.DB) (func(), error)
+}
+
+var (
+	sqliteDialect = &dialect{
+		name:             "sqlite",
+		migrations:       sqliteMigrations,
+		transactionalDDL: true,
+		hasTable:         `select count(*) from sqlite_master where type = 'table' and name = ?;`,
+	}
+
+	mysqlDialect = &dialect{
+		name:       "mysql",
+		migrations: mysqlMigrations,
+		hasTable:   `select count(*) from information_schema.tables where table_schema = database() and table_name = ?;`,
+		lock:       mysqlMigrationLock,
+	}
+)
+
+// dialectOf returns the dialect of db's driver.
+func dialectOf(db *sql.DB) (*dialect, error) {
+	switch db.Driver().(type) {
+	case *sqlite3.SQLiteDriver:
+		return sqliteDialect, nil
+	case *gomysql.MySQLDriver:
+		return mysqlDialect, nil
+	}
+	return nil, fmt.Errorf("migrations: unknown driver %T", db.Driver())
+}
+
+// mysqlMigrationLock holds a named lock on a connection of its own until it's released.
+func mysqlMigrationLock(ctx context.Context, db *sql.DB) (func(), error) {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, `select get_lock('accounts_schema_migrations', 300);`).Scan(&locked); err != nil || locked.Int64 != 1 {
+		conn.Close()
+		if err == nil {
+			err = errors.New("timed out waiting for another server to finish migrating")
+		}
+		return nil, err
+	}
+	return func() {
+		conn.ExecContext(context.Background(), `select release_lock('accounts_schema_migrations');`)
+		conn.Close()
+	}, nil
+}
+
+// appliedMigration is a row of schema_migrations.
+type appliedMigration struct {
+	Version   int
+	Name      string
+	Dirty     bool
+	AppliedAt time.Time
+}
+
+func (d *dialect) setup(ctx context.Context, db *sql.DB) error {
+	query := `create table if not exists schema_migrations(version integer not null primary key, name varchar(255) not null, dirty boolean not null default false, applied_at datetime not null);`
+	if _, err := db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("creating schema_migrations: %v", err)
+	}
+	return d.adoptLegacyMigrations(ctx, db)
+}
+
+// adoptLegacyMigrations copies the migrations recorded in the migrations table (by earlier releases) into an empty
+// schema_migrations. The migrations table is left for those releases.
+func (d *dialect) adoptLegacyMigrations(ctx context.Context, db *sql.DB) error {
+	var n int
+	if err := db.QueryRowContext(ctx, `select count(*) from schema_migrations;`).Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	if err := db.QueryRowContext(ctx, d.hasTable, "migrations").Scan(&n); err != nil || n == 0 {
+		return err
+	}
+	rows, err := db.QueryContext(ctx, `select id, version from migrations order by id;`)
+	if err != nil {
+		return fmt.Errorf("reading migrations: %v", err)
+	}
+	var legacy []appliedMigration
+	for rows.Next() {
+		var m appliedMigration
+		if err := rows.Scan(&m.Version, &m.Name); err != nil {
+			rows.Close()
+			return fmt.Errorf("reading migrations: %v", err)
+		}
+		m.Version++ // migrations were numbered from 0
+		legacy = append(legacy, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading migrations: %v", err)
+	}
+	if err := d.check(legacy); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for i := range legacy {
+		if _, err := tx.ExecContext(ctx, `insert into schema_migrations(version, name, dirty, applied_at) values (?, ?, false, ?);`, legacy[i].Version, legacy[i].Name, now); err != nil {
+			return fmt.Errorf("adopting migrations: error=%v rollback=%v", err, tx.Rollback())
+		}
+	}
+	return tx.Commit()
+}
+
+func (d *dialect) applied(ctx context.Context, db *sql.DB) ([]appliedMigration, error) {
+	rows, err := db.QueryContext(ctx, `select version, name, dirty, applied_at from schema_migrations order by version;`)
+	if err != nil {
+		return nil, fmt.Errorf("reading schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	var out []appliedMigration
+	for rows.Next() {
+		var m appliedMigration
+		if err := rows.Scan(&m.Version, &m.Name, &m.Dirty, &m.AppliedAt); err != nil {
+			return nil, fmt.Errorf("reading schema_migrations: %v", err)
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// check returns an error unless the applied migrations are the first of the dialect's, in order.
+func (d *dialect) check(applied []appliedMigration) error {
+	if len(applied) > len(d.migrations) {
+		return fmt.Errorf("database is at version %d but this release only knows %d %s migrations, roll it back with the release which migrated it", len(applied), len(d.migrations), d.name)
+	}
+	for i := range applied {
+		if version := i + 1; applied[i].Version != version || applied[i].Name != d.migrations[i].Name {
+			return fmt.Errorf("applied migration %d %q doesn't match %s migration %d %q", applied[i].Version, applied[i].Name, d.name, version, d.migrations[i].Name)
+		}
+		if applied[i].Dirty {
+			return fmt.Errorf("migration %d %q failed partway, check the schema and then set dirty = false on its schema_migrations row if it was applied or delete the row if it wasn't", applied[i].Version, applied[i].Name)
+		}
+	}
+	return nil
+}
+
+// run applies step to db, recording version as applied or, when reverting, removing it from schema_migrations.
+func (d *dialect) run(ctx context.Context, db *sql.DB, version int, name string, step migrationStep, revert bool) error {
+	now := time.Now().UTC()
+	if step.SQL != "" && d.transactionalDDL {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, step.SQL); err != nil {
+			return fmt.Errorf("%v rollback=%v", err, tx.Rollback())
+		}
+		if revert {
+			_, err = tx.ExecContext(ctx, `delete from schema_migrations where version = ?;`, version)
+		} else {
+			_, err = tx.ExecContext(ctx, `insert into schema_migrations(version, name, dirty, applied_at) values (?, ?, false, ?);`, version, name, now)
+		}
+		if err != nil {
+			return fmt.Errorf("%v rollback=%v", err, tx.Rollback())
+		}
+		return tx.Commit()
+	}
+
+	// Mark the migration dirty while it runs, so a step which fails partway stops the server from starting
+	// against a schema in an unknown state
+	var err error
+	if revert {
+		_, err = db.ExecContext(ctx, `update schema_migrations set dirty = true where version = ?;`, version)
+	} else {
+		_, err = db.ExecContext(ctx, `insert into schema_migrations(version, name, dirty, applied_at) values (?, ?, true, ?);`, version, name, now)
+	}
+	if err != nil {
+		return err
+	}
+	if step.Func != nil {
+		err = step.Func(db)
+	} else {
+		_, err = db.ExecContext(ctx, step.SQL)
+	}
+	if err != nil {
+		return err
+	}
+	if revert {
+		_, err = db.ExecContext(ctx, `delete from schema_migrations where version = ?;`, version)
+	} else {
+		_, err = db.ExecContext(ctx, `update schema_migrations set dirty = false where version = ?;`, version)
+	}
+	return err
+}
+
+// migrate applies every migration db is missing.
+func migrate(ctx context.Context, logger log.Logger, db *sql.DB) error {
+	d, err := dialectOf(db)
+	if err != nil {
+		return err
+	}
+	if d.lock != nil {
+		release, err := d.lock(ctx, db)
+		if err != nil {
+			return fmt.Errorf("migrations: lock: %v", err)
+		}
+		defer release()
+	}
+	if err := d.setup(ctx, db); err != nil {
+		return fmt.Errorf("migrations: %v", err)
+	}
+	applied, err := d.applied(ctx, db)
+	if err != nil {
+		return fmt.Errorf("migrations: %v", err)
+	}
+	if err := d.check(applied); err != nil {
+		return fmt.Errorf("migrations: %v", err)
+	}
+	for i := len(applied); i < len(d.migrations); i++ {
+		version, m := i+1, d.migrations[i]
+		if err := d.run(ctx, db, version, m.Name, m.Up, false); err != nil {
+			return fmt.Errorf("migrations: applying %d %s: %v", version, m.Name, err)
+		}
+		logger.Log("migrations", fmt.Sprintf("applied %s migration %d %s", d.name, version, m.Name))
+	}
+	return nil
+}
+
+// Rollback reverts the migrations of db after version, newest first. A migration without a down step stops the
+// rollback with an error, leaving the database at its version.
+func Rollback(ctx context.Context, logger log.Logger, db *sql.DB, version int) error {
+	d, err := dialectOf(db)
+	if err != nil {
+		return err
+	}
+	if d.lock != nil {
+		release, err := d.lock(ctx, db)
+		if err != nil {
+			return fmt.Errorf("rollback: lock: %v", err)
+		}
+		defer release()
+	}
+	if err := d.setup(ctx, db); err != nil {
+		return fmt.Errorf("rollback: %v", err)
+	}
+	applied, err := d.applied(ctx, db)
+	if err != nil {
+		return fmt.Errorf("rollback: %v", err)
+	}
+	if err := d.check(applied); err != nil {
+		return fmt.Errorf("rollback: %v", err)
+	}
+	if version < 0 || version > len(applied) {
+		return fmt.Errorf("rollback: database is at version %d, can't roll back to %d", len(applied), version)
+	}
+	for i := len(applied) - 1; i >= version; i-- {
+		m := d.migrations[i]
+		if m.Down.empty() {
+			return fmt.Errorf("rollback: migration %d %s can't be reverted", i+1, m.Name)
+		}
+		if err := d.run(ctx, db, i+1, m.Name, m.Down, true); err != nil {
+			return fmt.Errorf("rollback: reverting %d %s: %v", i+1, m.Name, err)
+		}
+		logger.Log("migrations", fmt.Sprintf("reverted %s migration %d %s", d.name, i+1, m.Name))
+	}
+	return nil
+}
+
+// MigrationState is one of a database's migrations and whether it's been applied.
+type MigrationState struct {
+	Version    int        `json:"version"`
+	Name       string     `json:"name"`
+	AppliedAt  *time.Time `json:"appliedAt,omitempty"`
+	Dirty      bool       `json:"dirty,omitempty"`
+	Reversible bool       `json:"reversible"`
+}
+
+// MigrationStatus returns every migration of db's backend, oldest first, along with when each was applied.
+func MigrationStatus(ctx context.Context, db *sql.DB) ([]MigrationState, error) {
+	d, err := dialectOf(db)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.setup(ctx, db); err != nil {
+		return nil, fmt.Errorf("migrations: %v", err)
+	}
+	applied, err := d.applied(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("migrations: %v", err)
+	}
+	out := make([]MigrationState, len(d.migrations))
+	for i := range d.migrations {
+		out[i] = MigrationState{Version: i + 1, Name: d.migrations[i].Name, Reversible: !d.migrations[i].Down.empty()}
+	}
+	for i := range applied {
+		if i < len(out) && applied[i].Name == out[i].Name {
+			appliedAt := applied[i].AppliedAt
+			out[i].AppliedAt, out[i].Dirty = &appliedAt, applied[i].Dirty
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestMigrations__down(t *testing.T) {
+	for _, d := range []*dialect{sqliteDialect, mysqlDialect} {
+		for i, m := range d.migrations {
+			if m.Up.empty() || m.Down.empty() {
+				t.Errorf("%s migration %d %s needs up and down steps", d.name, i+1, m.Name)
+			}
+		}
+	}
+}
+
+func TestMigrations__rollback(t *testing.T) {
+	db := CreateTestSqliteDB(t)
+	defer db.Close()
+
+	// a release which adds migrations, one of which can't be reverted
+	ctx, logger := context.Background(), log.NewNopLogger()
+	version := len(sqliteMigrations)
+	sqliteDialect.migrations = append(sqliteMigrations,
+		migration{Name: "update_rollback_tests", Up: migrationStep{SQL: `select 1;`}},
+		execsql("create_rollback_tests", `create table rollback_tests(id integer primary key);`, `drop table if exists rollback_tests;`),
+		execsql("create_rollback_tests_index", `create index rollback_tests_idx on rollback_tests (id);`, `drop index if exists rollback_tests_idx;`),
+	)
+	defer func() { sqliteDialect.migrations = sqliteMigrations }()
+
+	if err := migrate(ctx, logger, db.DB); err != nil {
+		t.Fatal(err)
+	}
+	states, err := MigrationStatus(ctx, db.DB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range states {
+		if states[i].AppliedAt == nil || states[i].Dirty || states[i].Version != i+1 {
+			t.Fatalf("unexpected state: %#v", states[i])
+		}
+	}
+	if len(states) != version+3 || states[version].Reversible || !states[version+1].Reversible {
+		t.Errorf("unexpected states: %#v", states[version:])
+	}
+
+	if err := Rollback(ctx, logger, db.DB, version); err == nil || !strings.Contains(err.Error(), "can't be reverted") {
+		t.Errorf("expected error: %v", err)
+	}
+	if _, err := db.DB.Exec(`select count(*) from rollback_tests;`); err == nil {
+		t.Error("expected rollback_tests to be dropped")
+	}
+	states, _ = MigrationStatus(ctx, db.DB)
+	if states[version+1].AppliedAt != nil || states[version].AppliedAt == nil {
+		t.Errorf("expected database at version %d", version+1)
+	}
+
+	// migrating again reapplies what was rolled back
+	if err := migrate(ctx, logger, db.DB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB.Exec(`select count(*) from rollback_tests;`); err != nil {
+		t.Error(err)
+	}
+	if err := Rollback(ctx, logger, db.DB, version+4); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// testMigrationsRoundTrip rolls db back to an empty schema and migrates it up again, checking the rows of the ledger
+// are kept across the migrations which rebuild its tables.
+func testMigrationsRoundTrip(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	ctx, logger := context.Background(), log.NewNopLogger()
+	d, err := dialectOf(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed := -1
+	for i := range d.migrations {
+		if d.migrations[i].Name == "add_ledger_column_types" {
+			typed = i
+		}
+	}
+	now := time.Now()
+	if _, err := db.Exec(`insert into accounts(account_id, customer_id, name, account_number, routing_number, status, type, created_at) values ('alice', 'customer', 'alice', '1234', '987654320', 'open', 'checking', ?);`, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`insert into transactions(transaction_id, timestamp, created_at) values ('deposit', ?, ?);`, now, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`insert into transaction_lines(transaction_id, account_id, purpose, amount, created_at) values ('deposit', 'alice', 'achcredit', 100, ?);`, now); err != nil {
+		t.Fatal(err)
+	}
+
+	// the ledger's rows survive rolling back the migrations which rebuilt its tables
+	if err := Rollback(ctx, logger, db, typed-1); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.QueryRow(`select count(*) from transaction_lines where transaction_id = 'deposit' and account_id = 'alice';`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+	if err := migrate(ctx, logger, db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`select count(*) from accounts where account_id = 'alice';`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+
+	// down to nothing and back up
+	if err := Rollback(ctx, logger, db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(d.hasTable, "accounts").Scan(&n); err != nil || n != 0 {
+		t.Errorf("accounts tables=%d error=%v", n, err)
+	}
+	if err := migrate(ctx, logger, db); err != nil {
+		t.Fatal(err)
+	}
+	states, err := MigrationStatus(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range states {
+		if states[i].AppliedAt == nil || states[i].Dirty {
+			t.Errorf("unexpected state: %#v", states[i])
+		}
+	}
+}
+
+func TestMigrations__roundTrip(t *testing.T) {
+	sqliteDB := CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	testMigrationsRoundTrip(t, sqliteDB.DB)
+
+	mysqlDB := CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	testMigrationsRoundTrip(t, mysqlDB.DB)
+}
+
+func TestMigrations__check(t *testing.T) {
+	db := CreateTestSqliteDB(t)
+	defer db.Close()
+
+	ctx, logger := context.Background(), log.NewNopLogger()
+	if err := migrate(ctx, logger, db.DB); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed step leaves its migration dirty
+	if _, err := db.DB.Exec(`update schema_migrations set dirty = true where version = 2;`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(ctx, logger, db.DB); err == nil || !strings.Contains(err.Error(), "failed partway") {
+		t.Errorf("expected error: %v", err)
+	}
+	db.DB.Exec(`update schema_migrations set dirty = false where version = 2;`)
+
+	// migrations from another history
+	if _, err := db.DB.Exec(`update schema_migrations set name = 'other' where version = 3;`); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(ctx, logger, db.DB); err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Errorf("expected error: %v", err)
+	}
+	db.DB.Exec(`update schema_migrations set name = ? where version = 3;`, sqliteMigrations[2].Name)
+
+	// databases migrated by a newer release
+	if _, err := db.DB.Exec(`insert into schema_migrations(version, name, dirty, applied_at) values (?, 'newer', false, current_timestamp);`, len(sqliteMigrations)+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(ctx, logger, db.DB); err == nil || !strings.Contains(err.Error(), "roll it back") {
+		t.Errorf("expected error: %v", err)
+	}
+}
+
+func TestMigrations__legacy(t *testing.T) {
+	db := CreateTestSqliteDB(t)
+	defer db.Close()
+
+	// a database migrated by an earlier release only has the migrations table
+	ctx, logger := context.Background(), log.NewNopLogger()
+	if _, err := db.DB.Exec(`drop table schema_migrations;`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.DB.Exec(`create table migrations(id integer not null, version varchar(255) not null);`); err != nil {
+		t.Fatal(err)
+	}
+	for i := range sqliteMigrations {
+		if _, err := db.DB.Exec(`insert into migrations(id, version) values (?, ?);`, i, sqliteMigrations[i].Name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := migrate(ctx, logger, db.DB); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := db.DB.QueryRow(`select count(*) from schema_migrations where dirty = false;`).Scan(&n); err != nil || n != len(sqliteMigrations) {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+}
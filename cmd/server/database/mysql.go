@@ -19,7 +19,6 @@ import (
 	"github.com/go-kit/kit/log"
 	kitprom "github.com/go-kit/kit/metrics/prometheus"
 	gomysql "github.com/go-sql-driver/mysql"
-	"github.com/lopezator/migrator"
 	"github.com/ory/dockertest/v3"
 	stdprom "github.com/prometheus/client_golang/prometheus"
 )
@@ -43,36 +42,637 @@ var (
 		return 16
 	}()
 
-	mysqlMigrations = migrator.Migrations(
+	mysqlMigrations = []migration{
 		execsql(
 			"create_accounts",
 			`create table if not exists accounts(account_id varchar(40) primary key, customer_id varchar(40), name varchar(50), account_number varchar(15), routing_number varchar(10), status varchar(15), type varchar(12), created_at datetime, closed_at datetime, last_modified datetime, deleted_at datetime);`,
+			`drop table if exists accounts;`,
 		),
 		execsql(
 			"create_unique_accounts_index",
 			"create unique index accounts_unique_idx on accounts(account_number, routing_number);",
+			`drop index accounts_unique_idx on accounts;`,
 		),
 		execsql(
 			"create_transactions",
 			`create table if not exists transactions(transaction_id varchar(40) primary key, timestamp datetime, created_at datetime, deleted_at datetime);`,
+			`drop table if exists transactions;`,
 		),
 		execsql(
 			"create_transaction_lines",
 			`create table if not exists transaction_lines(transaction_id varchar(40), account_id varchar(40), purpose varchar(12), amount integer, created_at datetime, deleted_at datetime);`,
+			`drop table if exists transaction_lines;`,
 		),
 		execsql(
 			"create_unique_transaction_lines_index",
 			"create unique index transaction_lines_unique_idx on transaction_lines(transaction_id, account_id);",
+			`drop index transaction_lines_unique_idx on transaction_lines;`,
 		),
 		execsql(
 			"create_transaction_lines_id_index",
 			`create index transaction_lines_id_index on transaction_lines(transaction_id);`,
+			`drop index transaction_lines_id_index on transaction_lines;`,
 		),
 		execsql(
 			"create_transaction_lines_account_index",
 			`create index transaction_lines_account_index on transaction_lines(account_id);`,
+			`drop index transaction_lines_account_index on transaction_lines;`,
 		),
-	)
+		execsql(
+			"create_account_balances",
+			`create table if not exists account_balances(account_id varchar(40), stripe integer, balance bigint, last_modified datetime, primary key(account_id, stripe));`,
+			`drop table if exists account_balances;`,
+		),
+		execsql(
+			"backfill_account_balances",
+			`insert into account_balances(account_id, stripe, balance, last_modified) select account_id, 0, sum(case when lower(purpose) = 'achdebit' then -amount else amount end), now() from transaction_lines where deleted_at is null group by account_id;`,
+			`delete from account_balances;`,
+		),
+		execsql(
+			"create_transaction_lines_archive",
+			`create table if not exists transaction_lines_archive(transaction_id varchar(40), account_id varchar(40), purpose varchar(12), amount integer, created_at datetime, deleted_at datetime, archived_at datetime);`,
+			`drop table if exists transaction_lines_archive;`,
+		),
+		execsql(
+			"create_unique_transaction_lines_archive_index",
+			"create unique index transaction_lines_archive_unique_idx on transaction_lines_archive(transaction_id, account_id);",
+			`drop index transaction_lines_archive_unique_idx on transaction_lines_archive;`,
+		),
+		execsql(
+			"create_transaction_lines_archive_account_index",
+			`create index transaction_lines_archive_account_index on transaction_lines_archive(account_id);`,
+			`drop index transaction_lines_archive_account_index on transaction_lines_archive;`,
+		),
+		execsql(
+			"create_account_daily_summaries",
+			`create table if not exists account_daily_summaries(account_id varchar(40), day varchar(10), credits bigint, debits bigint, line_count integer, primary key(account_id, day));`,
+			`drop table if exists account_daily_summaries;`,
+		),
+		execsql(
+			"add_transactions_status",
+			`alter table transactions add column status varchar(10) not null default 'posted';`,
+			`alter table transactions drop column status;`,
+		),
+		execsql(
+			"create_webhook_deliveries",
+			`create table if not exists webhook_deliveries(delivery_id varchar(40) primary key, event_id varchar(40), event_type varchar(40), url text, status varchar(10), response_code integer, error text, payload mediumtext, created_at datetime);`,
+			`drop table if exists webhook_deliveries;`,
+		),
+		execsql(
+			"create_webhook_deliveries_event_index",
+			`create index webhook_deliveries_event_index on webhook_deliveries(event_id);`,
+			`drop index webhook_deliveries_event_index on webhook_deliveries;`,
+		),
+		execsql(
+			"create_webhook_deliveries_created_index",
+			`create index webhook_deliveries_created_index on webhook_deliveries(created_at);`,
+			`drop index webhook_deliveries_created_index on webhook_deliveries;`,
+		),
+		execsql(
+			"create_account_webhooks",
+			`create table if not exists account_webhooks(subscription_id varchar(40) primary key, account_id varchar(40), customer_id varchar(40), url text, secret varchar(64), created_at datetime, last_modified datetime, deleted_at datetime);`,
+			`drop table if exists account_webhooks;`,
+		),
+		execsql(
+			"create_account_webhooks_account_index",
+			`create index account_webhooks_account_index on account_webhooks(account_id);`,
+			`drop index account_webhooks_account_index on account_webhooks;`,
+		),
+		execsql(
+			"create_transaction_attachments",
+			`create table if not exists transaction_attachments(attachment_id varchar(40) primary key, transaction_id varchar(40), type varchar(20), url text, object_key text, content_hash varchar(64), content_type varchar(255), description text, created_at datetime, deleted_at datetime);`,
+			`drop table if exists transaction_attachments;`,
+		),
+		execsql(
+			"create_transaction_attachments_transaction_index",
+			`create index transaction_attachments_transaction_index on transaction_attachments(transaction_id);`,
+			`drop index transaction_attachments_transaction_index on transaction_attachments;`,
+		),
+		execsql(
+			"create_account_statements",
+			`create table if not exists account_statements(statement_id varchar(40) primary key, account_id varchar(40), cycle varchar(7), period_start datetime, period_end datetime, opening_balance bigint, closing_balance bigint, credits bigint, debits bigint, transactions mediumtext, created_at datetime);`,
+			`drop table if exists account_statements;`,
+		),
+		execsql(
+			"create_account_statements_cycle_index",
+			`create unique index account_statements_cycle_index on account_statements(account_id, cycle);`,
+			`drop index account_statements_cycle_index on account_statements;`,
+		),
+		execsql(
+			"create_statement_runs",
+			`create table if not exists statement_runs(run_id varchar(40) primary key, cycle varchar(7), status varchar(20), processed integer, generated integer, skipped integer, failed integer, last_account_id varchar(40), error text, started_at datetime, finished_at datetime, last_modified datetime);`,
+			`drop table if exists statement_runs;`,
+		),
+		execsql(
+			"create_statement_run_failures",
+			`create table if not exists statement_run_failures(run_id varchar(40), account_id varchar(40), error text, created_at datetime, primary key (run_id, account_id));`,
+			`drop table if exists statement_run_failures;`,
+		),
+		execsql(
+			"create_sandbox_snapshots",
+			`create table if not exists sandbox_snapshots(name varchar(20) primary key, clock_offset bigint, created_at datetime);`,
+			`drop table if exists sandbox_snapshots;`,
+		),
+		execsql(
+			"add_transactions_expires_at",
+			`alter table transactions add column expires_at datetime;`,
+			`alter table transactions drop column expires_at;`,
+		),
+		execsql(
+			"create_ledger_transfers",
+			`create table if not exists ledger_transfers(transfer_id varchar(40) primary key, peer varchar(255), source_account_id varchar(40), destination_account_id varchar(40), amount bigint, status varchar(20), expires_at datetime, created_at datetime, last_modified datetime);`,
+			`drop table if exists ledger_transfers;`,
+		),
+		execsql(
+			"create_ledger_transfers_status_index",
+			`create index ledger_transfers_status_index on ledger_transfers(status);`,
+			`drop index ledger_transfers_status_index on ledger_transfers;`,
+		),
+		execsql(
+			"add_transactions_idempotency_key",
+			`alter table transactions add column idempotency_key varchar(255);`,
+			`alter table transactions drop column idempotency_key;`,
+		),
+		execsql(
+			"create_transactions_idempotency_key_index",
+			`create unique index transactions_idempotency_key_index on transactions(idempotency_key);`,
+			`drop index transactions_idempotency_key_index on transactions;`,
+		),
+		execsql(
+			"create_netting_runs",
+			`create table if not exists netting_runs(run_id varchar(40) primary key, peer varchar(255), account_id varchar(40), cutoff datetime, payable bigint, receivable bigint, net bigint, transactions integer, settlement_transaction_id varchar(40), settled_at datetime, created_at datetime);`,
+			`drop table if exists netting_runs;`,
+		),
+		execsql(
+			"create_netting_runs_peer_index",
+			`create index netting_runs_peer_index on netting_runs(peer, cutoff);`,
+			`drop index netting_runs_peer_index on netting_runs;`,
+		),
+		execsql(
+			"create_netted_transactions",
+			`create table if not exists netted_transactions(peer varchar(255), transaction_id varchar(40), run_id varchar(40), primary key (peer, transaction_id));`,
+			`drop table if exists netted_transactions;`,
+		),
+		execsql(
+			"add_transactions_force_post_id",
+			`alter table transactions add column force_post_id varchar(40);`,
+			`alter table transactions drop column force_post_id;`,
+		),
+		execsql(
+			"create_force_posts",
+			`create table if not exists force_posts(force_post_id varchar(40) primary key, transaction_id varchar(40), transaction_lines text, timestamp datetime, reason text, requested_by varchar(255), requested_at datetime, status varchar(20), reviewed_by varchar(255), reviewed_at datetime);`,
+			`drop table if exists force_posts;`,
+		),
+		execsql(
+			"create_transaction_templates",
+			`create table if not exists transaction_templates(name varchar(64) primary key, description text, template_lines text, created_at datetime, last_modified datetime);`,
+			`drop table if exists transaction_templates;`,
+		),
+		execsql(
+			"create_journal_imports",
+			`create table if not exists journal_imports(import_id varchar(40) primary key, filename varchar(255), entries mediumtext, balances mediumtext, status varchar(20), created_by varchar(255), created_at datetime, posted_by varchar(255), posted_at datetime);`,
+			`drop table if exists journal_imports;`,
+		),
+		execsql(
+			"add_transaction_lines_department",
+			`alter table transaction_lines add column department varchar(64);`,
+			`alter table transaction_lines drop column department;`,
+		),
+		execsql(
+			"add_transaction_lines_product",
+			`alter table transaction_lines add column product varchar(64);`,
+			`alter table transaction_lines drop column product;`,
+		),
+		execsql(
+			"add_transaction_lines_region",
+			`alter table transaction_lines add column region varchar(64);`,
+			`alter table transaction_lines drop column region;`,
+		),
+		execsql(
+			"add_transaction_lines_archive_department",
+			`alter table transaction_lines_archive add column department varchar(64);`,
+			`alter table transaction_lines_archive drop column department;`,
+		),
+		execsql(
+			"add_transaction_lines_archive_product",
+			`alter table transaction_lines_archive add column product varchar(64);`,
+			`alter table transaction_lines_archive drop column product;`,
+		),
+		execsql(
+			"add_transaction_lines_archive_region",
+			`alter table transaction_lines_archive add column region varchar(64);`,
+			`alter table transaction_lines_archive drop column region;`,
+		),
+		execsql(
+			"create_budgets",
+			`create table if not exists budgets(budget_id varchar(40) primary key, segment varchar(20), segment_value varchar(64), budget_period varchar(20), amount bigint, enforcement varchar(20), created_at datetime);`,
+			`drop table if exists budgets;`,
+		),
+		execsql(
+			"create_budgets_segment_index",
+			`create unique index budgets_segment_index on budgets(segment, segment_value, budget_period);`,
+			`drop index budgets_segment_index on budgets;`,
+		),
+		execsql(
+			"create_chart_nodes",
+			`create table if not exists chart_nodes(node_id varchar(40) primary key, name varchar(255), parent_id varchar(40), created_at datetime, last_modified datetime);`,
+			`drop table if exists chart_nodes;`,
+		),
+		execsql(
+			"create_chart_node_accounts",
+			`create table if not exists chart_node_accounts(node_id varchar(40), account_id varchar(40) primary key);`,
+			`drop table if exists chart_node_accounts;`,
+		),
+		execsql(
+			"create_customer_exports",
+			`create table if not exists customer_exports(export_id varchar(40) primary key, customer_id varchar(40), status varchar(20), error text, size integer, sha256 varchar(64), archive longblob, created_at datetime, completed_at datetime, expires_at datetime);`,
+			`drop table if exists customer_exports;`,
+		),
+		execsql(
+			"create_customer_exports_customer_index",
+			`create index customer_exports_customer_index on customer_exports(customer_id, created_at);`,
+			`drop index customer_exports_customer_index on customer_exports;`,
+		),
+		execsql(
+			"add_transaction_attachments_anonymized_at",
+			`alter table transaction_attachments add column anonymized_at datetime;`,
+			`alter table transaction_attachments drop column anonymized_at;`,
+		),
+		execsql(
+			"create_region_heartbeats",
+			`create table if not exists region_heartbeats(region varchar(40) primary key, heartbeat_at datetime);`,
+			`drop table if exists region_heartbeats;`,
+		),
+		execsql(
+			"create_ledger_epochs",
+			`create table if not exists ledger_epochs(ledger varchar(40) primary key, epoch bigint, region varchar(40), updated_at datetime);`,
+			`drop table if exists ledger_epochs;`,
+		),
+		execsql(
+			"create_account_activity",
+			`create table if not exists account_activity(account_id varchar(40) primary key, score integer, postings bigint, postings_hour double, postings_day double, debits_day double, unusual_hour_postings_day double, counterparties bigint, new_counterparties_day double, hours varchar(512), first_posted_at datetime, last_posted_at datetime);`,
+			`drop table if exists account_activity;`,
+		),
+		execsql(
+			"create_account_activity_score_index",
+			`create index account_activity_score_index on account_activity(score, last_posted_at);`,
+			`drop index account_activity_score_index on account_activity;`,
+		),
+		execsql(
+			"create_account_counterparties",
+			`create table if not exists account_counterparties(account_id varchar(40), counterparty_id varchar(40), first_seen_at datetime, primary key (account_id, counterparty_id));`,
+			`drop table if exists account_counterparties;`,
+		),
+		execsql(
+			"add_accounts_organization_id",
+			`alter table accounts add column organization_id varchar(40) not null default '';`,
+			`alter table accounts drop column organization_id;`,
+		),
+		execsql(
+			"create_accounts_organization_index",
+			`create index accounts_organization_index on accounts(organization_id, customer_id);`,
+			`drop index accounts_organization_index on accounts;`,
+		),
+		execsql(
+			"add_transactions_organization_id",
+			`alter table transactions add column organization_id varchar(40) not null default '';`,
+			`alter table transactions drop column organization_id;`,
+		),
+		execsql(
+			"add_transaction_lines_organization_id",
+			`alter table transaction_lines add column organization_id varchar(40) not null default '';`,
+			`alter table transaction_lines drop column organization_id;`,
+		),
+		execsql(
+			"add_transaction_lines_archive_organization_id",
+			`alter table transaction_lines_archive add column organization_id varchar(40) not null default '';`,
+			`alter table transaction_lines_archive drop column organization_id;`,
+		),
+		execsql(
+			"drop_transactions_idempotency_key_index",
+			`drop index transactions_idempotency_key_index on transactions;`,
+			`create unique index transactions_idempotency_key_index on transactions(idempotency_key);`,
+		),
+		execsql(
+			"create_transactions_organization_idempotency_key_index",
+			`create unique index transactions_organization_idempotency_key_index on transactions(organization_id, idempotency_key);`,
+			`drop index transactions_organization_idempotency_key_index on transactions;`,
+		),
+		execsql(
+			"add_customer_exports_organization_id",
+			`alter table customer_exports add column organization_id varchar(40) not null default '';`,
+			`alter table customer_exports drop column organization_id;`,
+		),
+		execsql(
+			"create_fraud_reviews",
+			`create table if not exists fraud_reviews(transaction_id varchar(40) primary key, score double, reason text, status varchar(20), created_at datetime, reviewed_by varchar(255), reviewed_at datetime);`,
+			`drop table if exists fraud_reviews;`,
+		),
+		execsql(
+			"create_fraud_reviews_status_index",
+			`create index fraud_reviews_status_index on fraud_reviews(status, created_at);`,
+			`drop index fraud_reviews_status_index on fraud_reviews;`,
+		),
+		execsql(
+			"add_transaction_lines_mcc",
+			`alter table transaction_lines add column mcc varchar(4);`,
+			`alter table transaction_lines drop column mcc;`,
+		),
+		execsql(
+			"add_transaction_lines_archive_mcc",
+			`alter table transaction_lines_archive add column mcc varchar(4);`,
+			`alter table transaction_lines_archive drop column mcc;`,
+		),
+		execsql(
+			"create_account_limits",
+			`create table if not exists account_limits(account_id varchar(40), limit_class varchar(20), amount bigint, updated_at datetime, primary key (account_id, limit_class));`,
+			`drop table if exists account_limits;`,
+		),
+		execsql(
+			"create_audit_log",
+			`create table if not exists audit_log(audit_id varchar(40) primary key, occurred_at datetime, actor varchar(255), organization_id varchar(40), request_id varchar(255), method varchar(10), path varchar(2048), status integer, payload_hash varchar(64));`,
+			`drop table if exists audit_log;`,
+		),
+		execsql(
+			"create_audit_log_occurred_at_index",
+			`create index audit_log_occurred_at_index on audit_log(occurred_at);`,
+			`drop index audit_log_occurred_at_index on audit_log;`,
+		),
+		execsql(
+			"add_transaction_lines_merchant_country",
+			`alter table transaction_lines add column merchant_country varchar(2);`,
+			`alter table transaction_lines drop column merchant_country;`,
+		),
+		execsql(
+			"add_transaction_lines_archive_merchant_country",
+			`alter table transaction_lines_archive add column merchant_country varchar(2);`,
+			`alter table transaction_lines_archive drop column merchant_country;`,
+		),
+		execsql(
+			"create_travel_notices",
+			`create table if not exists travel_notices(notice_id varchar(40) primary key, account_id varchar(40), customer_id varchar(40), start_date varchar(10), end_date varchar(10), countries text, created_at datetime, last_modified datetime, deleted_at datetime);`,
+			`drop table if exists travel_notices;`,
+		),
+		execsql(
+			"create_travel_notices_account_index",
+			`create index travel_notices_account_index on travel_notices(account_id);`,
+			`drop index travel_notices_account_index on travel_notices;`,
+		),
+		execsql(
+			"create_products",
+			`create table if not exists products(product_id varchar(40) primary key, name varchar(100), description text, settings text, created_at datetime, last_modified datetime, deleted_at datetime);`,
+			`drop table if exists products;`,
+		),
+		execsql(
+			"create_account_products",
+			`create table if not exists account_products(account_id varchar(40) primary key, product_id varchar(40), overrides text, assigned_at datetime);`,
+			`drop table if exists account_products;`,
+		),
+		execsql(
+			"create_account_products_product_index",
+			`create index account_products_product_index on account_products(product_id);`,
+			`drop index account_products_product_index on account_products;`,
+		),
+		execsql(
+			"create_product_migrations",
+			`create table if not exists product_migrations(migration_id varchar(40) primary key, from_product_id varchar(40), to_product_id varchar(40), effective_date varchar(10), status varchar(20), migrated_accounts integer, created_by varchar(255), created_at datetime, completed_at datetime);`,
+			`drop table if exists product_migrations;`,
+		),
+		execsql(
+			"add_transactions_metadata",
+			`alter table transactions add column metadata text;`,
+			`alter table transactions drop column metadata;`,
+		),
+		execsql(
+			"create_transaction_tags",
+			`create table if not exists transaction_tags(transaction_id varchar(40), tag varchar(64), primary key(transaction_id, tag));`,
+			`drop table if exists transaction_tags;`,
+		),
+		execsql(
+			"create_transaction_tags_tag_index",
+			`create index transaction_tags_tag_index on transaction_tags(tag);`,
+			`drop index transaction_tags_tag_index on transaction_tags;`,
+		),
+		execsql(
+			"add_transactions_description",
+			`alter table transactions add column description varchar(255);`,
+			`alter table transactions drop column description;`,
+		),
+		execsql(
+			"add_transaction_lines_description",
+			`alter table transaction_lines add column description varchar(255);`,
+			`alter table transaction_lines drop column description;`,
+		),
+		execsql(
+			"add_transaction_lines_archive_description",
+			`alter table transaction_lines_archive add column description varchar(255);`,
+			`alter table transaction_lines_archive drop column description;`,
+		),
+		execsql(
+			"create_promotions",
+			`create table if not exists promotions(promotion_id varchar(40) primary key, account_id varchar(40), funding_account_id varchar(40), amount integer, description varchar(255), clawback text, status varchar(20), transaction_id varchar(40), granted_by varchar(255), granted_at datetime, reversal_transaction_id varchar(40), reversal_reason text, reversed_by varchar(255), reversed_at datetime);`,
+			`drop table if exists promotions;`,
+		),
+		execsql(
+			"create_promotions_account_index",
+			`create index promotions_account_index on promotions(account_id);`,
+			`drop index promotions_account_index on promotions;`,
+		),
+		execsql(
+			"create_referrals",
+			`create table if not exists referrals(referral_id varchar(40) primary key, referrer_customer_id varchar(40), referrer_account_id varchar(40), referee_customer_id varchar(40) unique, referee_account_id varchar(40), referrer_bonus integer, referee_bonus integer, min_deposit integer, min_transactions integer, status varchar(20), referrer_promotion_id varchar(40), referee_promotion_id varchar(40), created_by varchar(255), created_at datetime, qualified_at datetime);`,
+			`drop table if exists referrals;`,
+		),
+		execsql(
+			"create_referrals_referrer_index",
+			`create index referrals_referrer_index on referrals(referrer_customer_id);`,
+			`drop index referrals_referrer_index on referrals;`,
+		),
+		execsql(
+			"create_rewards_accounts",
+			`create table if not exists rewards_accounts(account_id varchar(40) primary key, rewards_account_id varchar(40) unique, created_at datetime);`,
+			`drop table if exists rewards_accounts;`,
+		),
+		execsql(
+			"create_rewards_accruals",
+			`create table if not exists rewards_accruals(accrual_id varchar(40) primary key, account_id varchar(40), rewards_account_id varchar(40), source_transaction_id varchar(40), rate double, amount integer, status varchar(20), transaction_id varchar(40), accrued_at datetime, reversal_transaction_id varchar(40), reversed_at datetime, unique(source_transaction_id, account_id));`,
+			`drop table if exists rewards_accruals;`,
+		),
+		execsql(
+			"create_rewards_accruals_account_index",
+			`create index rewards_accruals_account_index on rewards_accruals(account_id);`,
+			`drop index rewards_accruals_account_index on rewards_accruals;`,
+		),
+		execsql(
+			"create_rewards_redemptions",
+			`create table if not exists rewards_redemptions(redemption_id varchar(40) primary key, account_id varchar(40), rewards_account_id varchar(40), amount integer, transaction_id varchar(40), redeemed_by varchar(255), redeemed_at datetime);`,
+			`drop table if exists rewards_redemptions;`,
+		),
+		execsql(
+			"create_rewards_redemptions_account_index",
+			`create index rewards_redemptions_account_index on rewards_redemptions(account_id);`,
+			`drop index rewards_redemptions_account_index on rewards_redemptions;`,
+		),
+		execsql(
+			"create_recurring_rules",
+			`create table if not exists recurring_rules(rule_id varchar(40) primary key, account_id varchar(40), counterparty_account_id varchar(40), amount integer, direction varchar(10), purpose varchar(20), description varchar(255), schedule varchar(255), start_at datetime, status varchar(20), occurrences integer, next_run_at datetime, last_run_at datetime, last_transaction_id varchar(40), last_error text, created_by varchar(255), created_at datetime, last_modified datetime, deleted_at datetime);`,
+			`drop table if exists recurring_rules;`,
+		),
+		execsql(
+			"create_recurring_rules_account_index",
+			`create index recurring_rules_account_index on recurring_rules(account_id);`,
+			`drop index recurring_rules_account_index on recurring_rules;`,
+		),
+		execsql(
+			"create_recurring_rules_next_run_index",
+			`create index recurring_rules_next_run_index on recurring_rules(status, next_run_at);`,
+			`drop index recurring_rules_next_run_index on recurring_rules;`,
+		),
+		execsql(
+			"add_account_statements_disputes",
+			`alter table account_statements add column disputes mediumtext;`,
+			`alter table account_statements drop column disputes;`,
+		),
+		execsql(
+			"add_account_statements_regenerated_at",
+			`alter table account_statements add column regenerated_at datetime;`,
+			`alter table account_statements drop column regenerated_at;`,
+		),
+		execsql(
+			"create_statement_disputes",
+			`create table if not exists statement_disputes(dispute_id varchar(40) primary key, account_id varchar(40), cycle varchar(7), transaction_id varchar(40), status varchar(10), received_at datetime, created_by varchar(255), created_at datetime, resolved_at datetime, last_modified datetime);`,
+			`drop table if exists statement_disputes;`,
+		),
+		execsql(
+			"create_statement_disputes_cycle_index",
+			`create index statement_disputes_cycle_index on statement_disputes(account_id, cycle);`,
+			`drop index statement_disputes_cycle_index on statement_disputes;`,
+		),
+		execsql(
+			"create_statement_dispute_annotations",
+			`create table if not exists statement_dispute_annotations(annotation_id varchar(40) primary key, dispute_id varchar(40), note text, status varchar(10), created_by varchar(255), created_at datetime);`,
+			`drop table if exists statement_dispute_annotations;`,
+		),
+		execsql(
+			"create_statement_dispute_annotations_dispute_index",
+			`create index statement_dispute_annotations_dispute_index on statement_dispute_annotations(dispute_id);`,
+			`drop index statement_dispute_annotations_dispute_index on statement_dispute_annotations;`,
+		),
+		execsql(
+			"create_interest_rates",
+			`create table if not exists interest_rates(account_type varchar(20) primary key, apy double, updated_by varchar(255), updated_at datetime);`,
+			`drop table if exists interest_rates;`,
+		),
+		execsql(
+			"create_interest_accruals",
+			`create table if not exists interest_accruals(account_id varchar(40), day varchar(10), balance bigint, apy double, amount double, accrued_at datetime, primary key(account_id, day));`,
+			`drop table if exists interest_accruals;`,
+		),
+		execsql(
+			"create_interest_accrual_days",
+			`create table if not exists interest_accrual_days(day varchar(10) primary key, accounts integer, accrued double, finished_at datetime);`,
+			`drop table if exists interest_accrual_days;`,
+		),
+		execsql(
+			"create_interest_postings",
+			`create table if not exists interest_postings(posting_id varchar(40) primary key, account_id varchar(40), month varchar(7), amount integer, transaction_id varchar(40), posted_at datetime, unique(account_id, month));`,
+			`drop table if exists interest_postings;`,
+		),
+		execsql(
+			"add_accounts_overdraft_limit",
+			`alter table accounts add column overdraft_limit integer not null default 0;`,
+			`alter table accounts drop column overdraft_limit;`,
+		),
+		mysqlForeignKeys("add_transaction_lines_foreign_keys"),
+		mysqlLedgerColumns("add_ledger_column_types"),
+		execsql(
+			"create_balance_constraints",
+			`create table if not exists balance_constraints(account_type varchar(12) not null primary key, ceiling bigint);`,
+			`drop table if exists balance_constraints;`,
+		),
+		execsql(
+			"create_account_balances_constraint_insert",
+			`create trigger account_balances_constraint_insert after insert on account_balances for each row
+begin
+  if new.balance < 0 and exists (select 1 from accounts a inner join balance_constraints c on c.account_type = a.type
+    where a.account_id = new.account_id and (select sum(balance) from account_balances where account_id = new.account_id) < -a.overdraft_limit) then
+    signal sqlstate '45000' set message_text = 'balance constraint: insufficient funds, balance is below the account''s floor';
+  end if;
+  if new.balance > 0 and exists (select 1 from accounts a inner join balance_constraints c on c.account_type = a.type
+    where a.account_id = new.account_id and c.ceiling is not null and (select sum(balance) from account_balances where account_id = new.account_id) > c.ceiling) then
+    signal sqlstate '45000' set message_text = 'balance constraint: balance is above the account''s ceiling';
+  end if;
+end;`,
+			`drop trigger if exists account_balances_constraint_insert;`,
+		),
+		execsql(
+			"create_account_balances_constraint_update",
+			`create trigger account_balances_constraint_update after update on account_balances for each row
+begin
+  if new.balance < old.balance and exists (select 1 from accounts a inner join balance_constraints c on c.account_type = a.type
+    where a.account_id = new.account_id and (select sum(balance) from account_balances where account_id = new.account_id) < -a.overdraft_limit) then
+    signal sqlstate '45000' set message_text = 'balance constraint: insufficient funds, balance is below the account''s floor';
+  end if;
+  if new.balance > old.balance and exists (select 1 from accounts a inner join balance_constraints c on c.account_type = a.type
+    where a.account_id = new.account_id and c.ceiling is not null and (select sum(balance) from account_balances where account_id = new.account_id) > c.ceiling) then
+    signal sqlstate '45000' set message_text = 'balance constraint: balance is above the account''s ceiling';
+  end if;
+end;`,
+			`drop trigger if exists account_balances_constraint_update;`,
+		),
+		execsql(
+			"create_account_daily_totals",
+			`create table if not exists account_daily_totals(account_id varchar(40) not null, day varchar(10) not null, credits bigint not null, debits bigint not null, line_count integer not null, refreshed_at datetime not null, primary key (account_id, day));`,
+			`drop table if exists account_daily_totals;`,
+		),
+		execsql(
+			"create_account_daily_total_changes",
+			`create table if not exists account_daily_total_changes(change_id bigint not null auto_increment primary key, account_id varchar(40) not null, transaction_id varchar(40) not null);`,
+			`drop table if exists account_daily_total_changes;`,
+		),
+		execsql(
+			"create_account_daily_total_changes_account_index",
+			`create index account_daily_total_changes_account_index on account_daily_total_changes(account_id);`,
+			`drop index account_daily_total_changes_account_index on account_daily_total_changes;`,
+		),
+		execsql(
+			"create_account_daily_totals_line_insert",
+			`create trigger account_daily_totals_line_insert after insert on transaction_lines for each row
+insert into account_daily_total_changes(account_id, transaction_id) values (new.account_id, new.transaction_id);`,
+			`drop trigger if exists account_daily_totals_line_insert;`,
+		),
+		execsql(
+			"create_account_daily_totals_line_update",
+			`create trigger account_daily_totals_line_update after update on transaction_lines for each row
+begin
+  if new.amount <> old.amount or new.purpose <> old.purpose or not (new.deleted_at <=> old.deleted_at) then
+    insert into account_daily_total_changes(account_id, transaction_id) values (new.account_id, new.transaction_id);
+  end if;
+end;`,
+			`drop trigger if exists account_daily_totals_line_update;`,
+		),
+		execsql(
+			"create_account_daily_totals_status_update",
+			`create trigger account_daily_totals_status_update after update on transactions for each row
+begin
+  if new.status <> old.status then
+    insert into account_daily_total_changes(account_id, transaction_id) select account_id, transaction_id from transaction_lines where transaction_id = new.transaction_id;
+  end if;
+end;`,
+			`drop trigger if exists account_daily_totals_status_update;`,
+		),
+		execsql(
+			"backfill_account_daily_total_changes",
+			`insert into account_daily_total_changes(account_id, transaction_id) select account_id, transaction_id from transaction_lines union select account_id, transaction_id from transaction_lines_archive;`,
+			`delete from account_daily_total_changes;`,
+		),
+		// interest rates are read from products, see cmd/server/interest.go
+		execsql(
+			"drop_interest_rates",
+			`drop table if exists interest_rates;`,
+			`create table if not exists interest_rates(account_type varchar(20) primary key, apy double, updated_by varchar(255), updated_at datetime);`,
+		),
+	}
 )
 
 type discardLogger struct{}
@@ -87,11 +687,26 @@ type mysql struct {
 	dsn    string
 	logger log.Logger
 
+	// foreignKeys is false when inserts aren't checked against the foreign keys of their tables
+	foreignKeys bool
+
+	// skipMigrations leaves the schema as it is, for inspecting and rolling back migrations
+	skipMigrations bool
+
 	connections *kitprom.Gauge
+
+	err error
 }
 
 func (my *mysql) Connect(ctx context.Context) (*sql.DB, error) {
-	db, err := sql.Open("mysql", my.dsn)
+	if my.err != nil {
+		return nil, fmt.Errorf("mysql had error %v", my.err)
+	}
+	dsn := my.dsn
+	if !my.foreignKeys {
+		dsn += "&foreign_key_checks=0"
+	}
+	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -103,10 +718,8 @@ func (my *mysql) Connect(ctx context.Context) (*sql.DB, error) {
 	}
 
 	// Migrate our database
-	if m, err := migrator.New(mysqlMigrations); err != nil {
-		return nil, err
-	} else {
-		if err := m.Migrate(db); err != nil {
+	if !my.skipMigrations {
+		if err := migrate(ctx, my.logger, db); err != nil {
 			return nil, err
 		}
 	}
@@ -134,10 +747,13 @@ func mysqlConnection(logger log.Logger, user, pass string, address string, datab
 	}
 	params := fmt.Sprintf("timeout=%s&charset=utf8mb4&parseTime=true&sql_mode=ALLOW_INVALID_DATES", timeout)
 	dsn := fmt.Sprintf("%s:%s@%s/%s?%s", user, pass, address, database, params)
+	foreignKeys, err := ForeignKeys()
 	return &mysql{
 		dsn:         dsn,
 		logger:      logger,
+		foreignKeys: foreignKeys,
 		connections: mysqlConnections,
+		err:         err,
 	}
 }
 
@@ -167,13 +783,27 @@ func (r *TestMySQLDB) Close() error {
 // CreateTestMySQLDB returns a TestMySQLDB which can be used in tests
 // as a clean mysql database. All migrations are ran on the db before.
 //
+// The test is skipped with -short or when Docker isn't available to run
+// MySQL, unless REQUIRE_MYSQL_TESTS is true, which fails it instead so CI
+// can't pass without covering MySQL.
+//
 // Callers should call close on the returned *TestMySQLDB.
 func CreateTestMySQLDB(t *testing.T) *TestMySQLDB {
+	t.Helper()
+
+	required, _ := strconv.ParseBool(os.Getenv("REQUIRE_MYSQL_TESTS"))
+	skip := func(reason string) {
+		t.Helper()
+		if required {
+			t.Fatalf("MySQL tests are required (REQUIRE_MYSQL_TESTS) but %s", reason)
+		}
+		t.Skipf("skipping MySQL tests: %s (set REQUIRE_MYSQL_TESTS=true to fail instead)", reason)
+	}
 	if testing.Short() {
-		t.Skip("-short flag enabled")
+		skip("-short flag enabled")
 	}
 	if !docker.Enabled() {
-		t.Skip("Docker not enabled")
+		skip("Docker isn't available")
 	}
 
 	pool, err := dockertest.NewPool("")
@@ -211,7 +841,10 @@ func CreateTestMySQLDB(t *testing.T) *TestMySQLDB {
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
-	db, err := mysqlConnection(logger, "moov", "secret", address, "accounts").Connect(ctx)
+	// As with CreateTestSqliteDB, inserts aren't checked against foreign keys.
+	my := mysqlConnection(logger, "moov", "secret", address, "accounts")
+	my.foreignKeys = false
+	db, err := my.Connect(ctx)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -18,7 +18,6 @@ import (
 
 	"github.com/go-kit/kit/log"
 	kitprom "github.com/go-kit/kit/metrics/prometheus"
-	"github.com/lopezator/migrator"
 	"github.com/mattn/go-sqlite3"
 	stdprom "github.com/prometheus/client_golang/prometheus"
 )
@@ -31,33 +30,527 @@ var (
 
 	sqliteVersionLogOnce sync.Once
 
-	sqliteMigrations = migrator.Migrations(
+	sqliteMigrations = []migration{
 		execsql(
 			"create_accounts",
 			`create table if not exists accounts(account_id primary key, customer_id, name, account_number, routing_number, status, type, created_at datetime, closed_at datetime, last_modified datetime, deleted_at datetime, unique(account_number, routing_number));`,
+			`drop table if exists accounts;`,
 		),
 		execsql(
 			"create_transactions",
 			`create table if not exists transactions(transaction_id primary key, timestamp datetime, created_at datetime, deleted_at datetime);`,
+			`drop table if exists transactions;`,
 		),
 		execsql(
 			"create_transaction_lines",
 			`create table if not exists transaction_lines(transaction_id, account_id, purpose, amount integer, created_at datetime, deleted_at datetime, unique(transaction_id, account_id));`,
+			`drop table if exists transaction_lines;`,
 		),
 		execsql(
 			"create_transaction_lines_id_index",
 			`create index transaction_lines_id_index on transaction_lines(transaction_id);`,
+			`drop index if exists transaction_lines_id_index;`,
 		),
 		execsql(
 			"create_transaction_lines_account_index",
 			`create index transaction_lines_account_index on transaction_lines(account_id);`,
+			`drop index if exists transaction_lines_account_index;`,
 		),
-	)
+		execsql(
+			"create_account_balances",
+			`create table if not exists account_balances(account_id, stripe integer, balance integer, last_modified datetime, primary key(account_id, stripe));`,
+			`drop table if exists account_balances;`,
+		),
+		execsql(
+			"backfill_account_balances",
+			`insert into account_balances(account_id, stripe, balance, last_modified) select account_id, 0, sum(case when lower(purpose) = 'achdebit' then -amount else amount end), current_timestamp from transaction_lines where deleted_at is null group by account_id;`,
+			`delete from account_balances;`,
+		),
+		execsql(
+			"create_transaction_lines_archive",
+			`create table if not exists transaction_lines_archive(transaction_id, account_id, purpose, amount integer, created_at datetime, deleted_at datetime, archived_at datetime, unique(transaction_id, account_id));`,
+			`drop table if exists transaction_lines_archive;`,
+		),
+		execsql(
+			"create_transaction_lines_archive_account_index",
+			`create index transaction_lines_archive_account_index on transaction_lines_archive(account_id);`,
+			`drop index if exists transaction_lines_archive_account_index;`,
+		),
+		execsql(
+			"create_account_daily_summaries",
+			`create table if not exists account_daily_summaries(account_id, day, credits integer, debits integer, line_count integer, primary key(account_id, day));`,
+			`drop table if exists account_daily_summaries;`,
+		),
+		sqliteAddColumn("add_transactions_status", "transactions", "status default 'posted'"),
+		execsql(
+			"create_webhook_deliveries",
+			`create table if not exists webhook_deliveries(delivery_id primary key, event_id, event_type, url, status, response_code integer, error, payload, created_at datetime);`,
+			`drop table if exists webhook_deliveries;`,
+		),
+		execsql(
+			"create_webhook_deliveries_event_index",
+			`create index webhook_deliveries_event_index on webhook_deliveries(event_id);`,
+			`drop index if exists webhook_deliveries_event_index;`,
+		),
+		execsql(
+			"create_webhook_deliveries_created_index",
+			`create index webhook_deliveries_created_index on webhook_deliveries(created_at);`,
+			`drop index if exists webhook_deliveries_created_index;`,
+		),
+		execsql(
+			"create_account_webhooks",
+			`create table if not exists account_webhooks(subscription_id primary key, account_id, customer_id, url, secret, created_at datetime, last_modified datetime, deleted_at datetime);`,
+			`drop table if exists account_webhooks;`,
+		),
+		execsql(
+			"create_account_webhooks_account_index",
+			`create index account_webhooks_account_index on account_webhooks(account_id);`,
+			`drop index if exists account_webhooks_account_index;`,
+		),
+		execsql(
+			"create_transaction_attachments",
+			`create table if not exists transaction_attachments(attachment_id primary key, transaction_id, type, url, object_key, content_hash, content_type, description, created_at datetime, deleted_at datetime);`,
+			`drop table if exists transaction_attachments;`,
+		),
+		execsql(
+			"create_transaction_attachments_transaction_index",
+			`create index transaction_attachments_transaction_index on transaction_attachments(transaction_id);`,
+			`drop index if exists transaction_attachments_transaction_index;`,
+		),
+		execsql(
+			"create_account_statements",
+			`create table if not exists account_statements(statement_id primary key, account_id, cycle, period_start datetime, period_end datetime, opening_balance integer, closing_balance integer, credits integer, debits integer, transactions, created_at datetime);`,
+			`drop table if exists account_statements;`,
+		),
+		execsql(
+			"create_account_statements_cycle_index",
+			`create unique index account_statements_cycle_index on account_statements(account_id, cycle);`,
+			`drop index if exists account_statements_cycle_index;`,
+		),
+		execsql(
+			"create_statement_runs",
+			`create table if not exists statement_runs(run_id primary key, cycle, status, processed integer, generated integer, skipped integer, failed integer, last_account_id, error, started_at datetime, finished_at datetime, last_modified datetime);`,
+			`drop table if exists statement_runs;`,
+		),
+		execsql(
+			"create_statement_run_failures",
+			`create table if not exists statement_run_failures(run_id, account_id, error, created_at datetime, primary key (run_id, account_id));`,
+			`drop table if exists statement_run_failures;`,
+		),
+		execsql(
+			"create_sandbox_snapshots",
+			`create table if not exists sandbox_snapshots(name primary key, clock_offset integer, created_at datetime);`,
+			`drop table if exists sandbox_snapshots;`,
+		),
+		sqliteAddColumn("add_transactions_expires_at", "transactions", "expires_at datetime"),
+		execsql(
+			"create_ledger_transfers",
+			`create table if not exists ledger_transfers(transfer_id primary key, peer, source_account_id, destination_account_id, amount integer, status, expires_at datetime, created_at datetime, last_modified datetime);`,
+			`drop table if exists ledger_transfers;`,
+		),
+		execsql(
+			"create_ledger_transfers_status_index",
+			`create index ledger_transfers_status_index on ledger_transfers(status);`,
+			`drop index if exists ledger_transfers_status_index;`,
+		),
+		sqliteAddColumn("add_transactions_idempotency_key", "transactions", "idempotency_key"),
+		execsql(
+			"create_transactions_idempotency_key_index",
+			`create unique index transactions_idempotency_key_index on transactions(idempotency_key);`,
+			`drop index if exists transactions_idempotency_key_index;`,
+		),
+		execsql(
+			"create_netting_runs",
+			`create table if not exists netting_runs(run_id primary key, peer, account_id, cutoff datetime, payable integer, receivable integer, net integer, transactions integer, settlement_transaction_id, settled_at datetime, created_at datetime);`,
+			`drop table if exists netting_runs;`,
+		),
+		execsql(
+			"create_netting_runs_peer_index",
+			`create index netting_runs_peer_index on netting_runs(peer, cutoff);`,
+			`drop index if exists netting_runs_peer_index;`,
+		),
+		execsql(
+			"create_netted_transactions",
+			`create table if not exists netted_transactions(peer, transaction_id, run_id, primary key (peer, transaction_id));`,
+			`drop table if exists netted_transactions;`,
+		),
+		sqliteAddColumn("add_transactions_force_post_id", "transactions", "force_post_id"),
+		execsql(
+			"create_force_posts",
+			`create table if not exists force_posts(force_post_id primary key, transaction_id, transaction_lines, timestamp datetime, reason, requested_by, requested_at datetime, status, reviewed_by, reviewed_at datetime);`,
+			`drop table if exists force_posts;`,
+		),
+		execsql(
+			"create_transaction_templates",
+			`create table if not exists transaction_templates(name primary key, description, template_lines, created_at datetime, last_modified datetime);`,
+			`drop table if exists transaction_templates;`,
+		),
+		execsql(
+			"create_journal_imports",
+			`create table if not exists journal_imports(import_id primary key, filename, entries, balances, status, created_by, created_at datetime, posted_by, posted_at datetime);`,
+			`drop table if exists journal_imports;`,
+		),
+		sqliteAddColumn("add_transaction_lines_department", "transaction_lines", "department"),
+		sqliteAddColumn("add_transaction_lines_product", "transaction_lines", "product"),
+		sqliteAddColumn("add_transaction_lines_region", "transaction_lines", "region"),
+		sqliteAddColumn("add_transaction_lines_archive_department", "transaction_lines_archive", "department"),
+		sqliteAddColumn("add_transaction_lines_archive_product", "transaction_lines_archive", "product"),
+		sqliteAddColumn("add_transaction_lines_archive_region", "transaction_lines_archive", "region"),
+		execsql(
+			"create_budgets",
+			`create table if not exists budgets(budget_id primary key, segment, segment_value, budget_period, amount integer, enforcement, created_at datetime);`,
+			`drop table if exists budgets;`,
+		),
+		execsql(
+			"create_budgets_segment_index",
+			`create unique index budgets_segment_index on budgets(segment, segment_value, budget_period);`,
+			`drop index if exists budgets_segment_index;`,
+		),
+		execsql(
+			"create_chart_nodes",
+			`create table if not exists chart_nodes(node_id primary key, name, parent_id, created_at datetime, last_modified datetime);`,
+			`drop table if exists chart_nodes;`,
+		),
+		execsql(
+			"create_chart_node_accounts",
+			`create table if not exists chart_node_accounts(node_id, account_id primary key);`,
+			`drop table if exists chart_node_accounts;`,
+		),
+		execsql(
+			"create_customer_exports",
+			`create table if not exists customer_exports(export_id primary key, customer_id, status, error, size integer, sha256, archive blob, created_at datetime, completed_at datetime, expires_at datetime);`,
+			`drop table if exists customer_exports;`,
+		),
+		execsql(
+			"create_customer_exports_customer_index",
+			`create index customer_exports_customer_index on customer_exports(customer_id, created_at);`,
+			`drop index if exists customer_exports_customer_index;`,
+		),
+		sqliteAddColumn("add_transaction_attachments_anonymized_at", "transaction_attachments", "anonymized_at datetime"),
+		execsql(
+			"create_region_heartbeats",
+			`create table if not exists region_heartbeats(region primary key, heartbeat_at datetime);`,
+			`drop table if exists region_heartbeats;`,
+		),
+		execsql(
+			"create_ledger_epochs",
+			`create table if not exists ledger_epochs(ledger primary key, epoch integer, region, updated_at datetime);`,
+			`drop table if exists ledger_epochs;`,
+		),
+		execsql(
+			"create_account_activity",
+			`create table if not exists account_activity(account_id primary key, score integer, postings integer, postings_hour real, postings_day real, debits_day real, unusual_hour_postings_day real, counterparties integer, new_counterparties_day real, hours, first_posted_at datetime, last_posted_at datetime);`,
+			`drop table if exists account_activity;`,
+		),
+		execsql(
+			"create_account_activity_score_index",
+			`create index account_activity_score_index on account_activity(score, last_posted_at);`,
+			`drop index if exists account_activity_score_index;`,
+		),
+		execsql(
+			"create_account_counterparties",
+			`create table if not exists account_counterparties(account_id, counterparty_id, first_seen_at datetime, primary key (account_id, counterparty_id));`,
+			`drop table if exists account_counterparties;`,
+		),
+		sqliteAddColumn("add_accounts_organization_id", "accounts", "organization_id not null default ''"),
+		execsql(
+			"create_accounts_organization_index",
+			`create index accounts_organization_index on accounts(organization_id, customer_id);`,
+			`drop index if exists accounts_organization_index;`,
+		),
+		sqliteAddColumn("add_transactions_organization_id", "transactions", "organization_id not null default ''"),
+		sqliteAddColumn("add_transaction_lines_organization_id", "transaction_lines", "organization_id not null default ''"),
+		sqliteAddColumn("add_transaction_lines_archive_organization_id", "transaction_lines_archive", "organization_id not null default ''"),
+		execsql(
+			"drop_transactions_idempotency_key_index",
+			`drop index transactions_idempotency_key_index;`,
+			`create unique index transactions_idempotency_key_index on transactions(idempotency_key);`,
+		),
+		execsql(
+			"create_transactions_organization_idempotency_key_index",
+			`create unique index transactions_organization_idempotency_key_index on transactions(organization_id, idempotency_key);`,
+			`drop index if exists transactions_organization_idempotency_key_index;`,
+		),
+		sqliteAddColumn("add_customer_exports_organization_id", "customer_exports", "organization_id not null default ''"),
+		execsql(
+			"create_fraud_reviews",
+			`create table if not exists fraud_reviews(transaction_id primary key, score, reason, status, created_at datetime, reviewed_by, reviewed_at datetime);`,
+			`drop table if exists fraud_reviews;`,
+		),
+		execsql(
+			"create_fraud_reviews_status_index",
+			`create index fraud_reviews_status_index on fraud_reviews(status, created_at);`,
+			`drop index if exists fraud_reviews_status_index;`,
+		),
+		sqliteAddColumn("add_transaction_lines_mcc", "transaction_lines", "mcc"),
+		sqliteAddColumn("add_transaction_lines_archive_mcc", "transaction_lines_archive", "mcc"),
+		execsql(
+			"create_account_limits",
+			`create table if not exists account_limits(account_id, limit_class, amount integer, updated_at datetime, primary key (account_id, limit_class));`,
+			`drop table if exists account_limits;`,
+		),
+		execsql(
+			"create_audit_log",
+			`create table if not exists audit_log(audit_id primary key, occurred_at datetime, actor, organization_id, request_id, method, path, status integer, payload_hash);`,
+			`drop table if exists audit_log;`,
+		),
+		execsql(
+			"create_audit_log_occurred_at_index",
+			`create index audit_log_occurred_at_index on audit_log(occurred_at);`,
+			`drop index if exists audit_log_occurred_at_index;`,
+		),
+		sqliteAddColumn("add_transaction_lines_merchant_country", "transaction_lines", "merchant_country"),
+		sqliteAddColumn("add_transaction_lines_archive_merchant_country", "transaction_lines_archive", "merchant_country"),
+		execsql(
+			"create_travel_notices",
+			`create table if not exists travel_notices(notice_id primary key, account_id, customer_id, start_date, end_date, countries, created_at datetime, last_modified datetime, deleted_at datetime);`,
+			`drop table if exists travel_notices;`,
+		),
+		execsql(
+			"create_travel_notices_account_index",
+			`create index travel_notices_account_index on travel_notices(account_id);`,
+			`drop index if exists travel_notices_account_index;`,
+		),
+		execsql(
+			"create_products",
+			`create table if not exists products(product_id primary key, name, description, settings, created_at datetime, last_modified datetime, deleted_at datetime);`,
+			`drop table if exists products;`,
+		),
+		execsql(
+			"create_account_products",
+			`create table if not exists account_products(account_id primary key, product_id, overrides, assigned_at datetime);`,
+			`drop table if exists account_products;`,
+		),
+		execsql(
+			"create_account_products_product_index",
+			`create index account_products_product_index on account_products(product_id);`,
+			`drop index if exists account_products_product_index;`,
+		),
+		execsql(
+			"create_product_migrations",
+			`create table if not exists product_migrations(migration_id primary key, from_product_id, to_product_id, effective_date, status, migrated_accounts integer, created_by, created_at datetime, completed_at datetime);`,
+			`drop table if exists product_migrations;`,
+		),
+		sqliteAddColumn("add_transactions_metadata", "transactions", "metadata"),
+		execsql(
+			"create_transaction_tags",
+			`create table if not exists transaction_tags(transaction_id, tag, primary key(transaction_id, tag));`,
+			`drop table if exists transaction_tags;`,
+		),
+		execsql(
+			"create_transaction_tags_tag_index",
+			`create index transaction_tags_tag_index on transaction_tags(tag);`,
+			`drop index if exists transaction_tags_tag_index;`,
+		),
+		sqliteAddColumn("add_transactions_description", "transactions", "description"),
+		sqliteAddColumn("add_transaction_lines_description", "transaction_lines", "description"),
+		sqliteAddColumn("add_transaction_lines_archive_description", "transaction_lines_archive", "description"),
+		execsql(
+			"create_promotions",
+			`create table if not exists promotions(promotion_id primary key, account_id, funding_account_id, amount integer, description, clawback, status, transaction_id, granted_by, granted_at datetime, reversal_transaction_id, reversal_reason, reversed_by, reversed_at datetime);`,
+			`drop table if exists promotions;`,
+		),
+		execsql(
+			"create_promotions_account_index",
+			`create index promotions_account_index on promotions(account_id);`,
+			`drop index if exists promotions_account_index;`,
+		),
+		execsql(
+			"create_referrals",
+			`create table if not exists referrals(referral_id primary key, referrer_customer_id, referrer_account_id, referee_customer_id unique, referee_account_id, referrer_bonus integer, referee_bonus integer, min_deposit integer, min_transactions integer, status, referrer_promotion_id, referee_promotion_id, created_by, created_at datetime, qualified_at datetime);`,
+			`drop table if exists referrals;`,
+		),
+		execsql(
+			"create_referrals_referrer_index",
+			`create index referrals_referrer_index on referrals(referrer_customer_id);`,
+			`drop index if exists referrals_referrer_index;`,
+		),
+		execsql(
+			"create_rewards_accounts",
+			`create table if not exists rewards_accounts(account_id primary key, rewards_account_id unique, created_at datetime);`,
+			`drop table if exists rewards_accounts;`,
+		),
+		execsql(
+			"create_rewards_accruals",
+			`create table if not exists rewards_accruals(accrual_id primary key, account_id, rewards_account_id, source_transaction_id, rate real, amount integer, status, transaction_id, accrued_at datetime, reversal_transaction_id, reversed_at datetime, unique(source_transaction_id, account_id));`,
+			`drop table if exists rewards_accruals;`,
+		),
+		execsql(
+			"create_rewards_accruals_account_index",
+			`create index rewards_accruals_account_index on rewards_accruals(account_id);`,
+			`drop index if exists rewards_accruals_account_index;`,
+		),
+		execsql(
+			"create_rewards_redemptions",
+			`create table if not exists rewards_redemptions(redemption_id primary key, account_id, rewards_account_id, amount integer, transaction_id, redeemed_by, redeemed_at datetime);`,
+			`drop table if exists rewards_redemptions;`,
+		),
+		execsql(
+			"create_rewards_redemptions_account_index",
+			`create index rewards_redemptions_account_index on rewards_redemptions(account_id);`,
+			`drop index if exists rewards_redemptions_account_index;`,
+		),
+		execsql(
+			"create_recurring_rules",
+			`create table if not exists recurring_rules(rule_id primary key, account_id, counterparty_account_id, amount integer, direction, purpose, description, schedule, start_at datetime, status, occurrences integer, next_run_at datetime, last_run_at datetime, last_transaction_id, last_error, created_by, created_at datetime, last_modified datetime, deleted_at datetime);`,
+			`drop table if exists recurring_rules;`,
+		),
+		execsql(
+			"create_recurring_rules_account_index",
+			`create index recurring_rules_account_index on recurring_rules(account_id);`,
+			`drop index if exists recurring_rules_account_index;`,
+		),
+		execsql(
+			"create_recurring_rules_next_run_index",
+			`create index recurring_rules_next_run_index on recurring_rules(status, next_run_at);`,
+			`drop index if exists recurring_rules_next_run_index;`,
+		),
+		sqliteAddColumn("add_account_statements_disputes", "account_statements", "disputes"),
+		sqliteAddColumn("add_account_statements_regenerated_at", "account_statements", "regenerated_at datetime"),
+		execsql(
+			"create_statement_disputes",
+			`create table if not exists statement_disputes(dispute_id primary key, account_id, cycle, transaction_id, status, received_at datetime, created_by, created_at datetime, resolved_at datetime, last_modified datetime);`,
+			`drop table if exists statement_disputes;`,
+		),
+		execsql(
+			"create_statement_disputes_cycle_index",
+			`create index statement_disputes_cycle_index on statement_disputes(account_id, cycle);`,
+			`drop index if exists statement_disputes_cycle_index;`,
+		),
+		execsql(
+			"create_statement_dispute_annotations",
+			`create table if not exists statement_dispute_annotations(annotation_id primary key, dispute_id, note, status, created_by, created_at datetime);`,
+			`drop table if exists statement_dispute_annotations;`,
+		),
+		execsql(
+			"create_statement_dispute_annotations_dispute_index",
+			`create index statement_dispute_annotations_dispute_index on statement_dispute_annotations(dispute_id);`,
+			`drop index if exists statement_dispute_annotations_dispute_index;`,
+		),
+		execsql(
+			"create_interest_rates",
+			`create table if not exists interest_rates(account_type primary key, apy real, updated_by, updated_at datetime);`,
+			`drop table if exists interest_rates;`,
+		),
+		execsql(
+			"create_interest_accruals",
+			`create table if not exists interest_accruals(account_id, day, balance integer, apy real, amount real, accrued_at datetime, primary key(account_id, day));`,
+			`drop table if exists interest_accruals;`,
+		),
+		execsql(
+			"create_interest_accrual_days",
+			`create table if not exists interest_accrual_days(day primary key, accounts integer, accrued real, finished_at datetime);`,
+			`drop table if exists interest_accrual_days;`,
+		),
+		execsql(
+			"create_interest_postings",
+			`create table if not exists interest_postings(posting_id primary key, account_id, month, amount integer, transaction_id, posted_at datetime, unique(account_id, month));`,
+			`drop table if exists interest_postings;`,
+		),
+		sqliteAddColumn("add_accounts_overdraft_limit", "accounts", "overdraft_limit integer not null default 0"),
+		sqliteForeignKeys("add_transaction_lines_foreign_keys"),
+		sqliteRebuild("add_ledger_column_types", sqliteLedgerTables, sqliteUntypedLedgerTables),
+		execsql(
+			"create_balance_constraints",
+			`create table if not exists balance_constraints(account_type text not null primary key, ceiling integer);`,
+			`drop table if exists balance_constraints;`,
+		),
+		execsql(
+			"create_account_balances_constraint_insert",
+			`create trigger account_balances_constraint_insert after insert on account_balances when new.balance <> 0
+begin
+  select raise(abort, 'balance constraint: insufficient funds, balance is below the account''s floor')
+  from accounts a inner join balance_constraints c on c.account_type = a.type
+  where a.account_id = new.account_id and new.balance < 0
+  and (select sum(balance) from account_balances where account_id = new.account_id) < -a.overdraft_limit;
+  select raise(abort, 'balance constraint: balance is above the account''s ceiling')
+  from accounts a inner join balance_constraints c on c.account_type = a.type
+  where a.account_id = new.account_id and new.balance > 0 and c.ceiling is not null
+  and (select sum(balance) from account_balances where account_id = new.account_id) > c.ceiling;
+end;`,
+			`drop trigger if exists account_balances_constraint_insert;`,
+		),
+		execsql(
+			"create_account_balances_constraint_update",
+			`create trigger account_balances_constraint_update after update of balance on account_balances when new.balance <> old.balance
+begin
+  select raise(abort, 'balance constraint: insufficient funds, balance is below the account''s floor')
+  from accounts a inner join balance_constraints c on c.account_type = a.type
+  where a.account_id = new.account_id and new.balance < old.balance
+  and (select sum(balance) from account_balances where account_id = new.account_id) < -a.overdraft_limit;
+  select raise(abort, 'balance constraint: balance is above the account''s ceiling')
+  from accounts a inner join balance_constraints c on c.account_type = a.type
+  where a.account_id = new.account_id and new.balance > old.balance and c.ceiling is not null
+  and (select sum(balance) from account_balances where account_id = new.account_id) > c.ceiling;
+end;`,
+			`drop trigger if exists account_balances_constraint_update;`,
+		),
+		execsql(
+			"create_account_daily_totals",
+			`create table if not exists account_daily_totals(account_id varchar(40) not null, day varchar(10) not null, credits integer not null, debits integer not null, line_count integer not null, refreshed_at datetime not null, primary key (account_id, day));`,
+			`drop table if exists account_daily_totals;`,
+		),
+		execsql(
+			"create_account_daily_total_changes",
+			`create table if not exists account_daily_total_changes(change_id integer primary key autoincrement, account_id varchar(40) not null, transaction_id varchar(40) not null);`,
+			`drop table if exists account_daily_total_changes;`,
+		),
+		execsql(
+			"create_account_daily_total_changes_account_index",
+			`create index account_daily_total_changes_account_index on account_daily_total_changes(account_id);`,
+			`drop index if exists account_daily_total_changes_account_index;`,
+		),
+		execsql(
+			"create_account_daily_totals_line_insert",
+			`create trigger account_daily_totals_line_insert after insert on transaction_lines
+begin
+  insert into account_daily_total_changes(account_id, transaction_id) values (new.account_id, new.transaction_id);
+end;`,
+			`drop trigger if exists account_daily_totals_line_insert;`,
+		),
+		execsql(
+			"create_account_daily_totals_line_update",
+			`create trigger account_daily_totals_line_update after update of amount, purpose, deleted_at on transaction_lines
+begin
+  insert into account_daily_total_changes(account_id, transaction_id) values (new.account_id, new.transaction_id);
+end;`,
+			`drop trigger if exists account_daily_totals_line_update;`,
+		),
+		execsql(
+			"create_account_daily_totals_status_update",
+			`create trigger account_daily_totals_status_update after update of status on transactions
+begin
+  insert into account_daily_total_changes(account_id, transaction_id) select account_id, transaction_id from transaction_lines where transaction_id = new.transaction_id;
+end;`,
+			`drop trigger if exists account_daily_totals_status_update;`,
+		),
+		execsql(
+			"backfill_account_daily_total_changes",
+			`insert into account_daily_total_changes(account_id, transaction_id) select account_id, transaction_id from transaction_lines union select account_id, transaction_id from transaction_lines_archive;`,
+			`delete from account_daily_total_changes;`,
+		),
+		// interest rates are read from products, see cmd/server/interest.go
+		execsql(
+			"drop_interest_rates",
+			`drop table if exists interest_rates;`,
+			`create table if not exists interest_rates(account_type primary key, apy real, updated_by, updated_at datetime);`,
+		),
+	}
 )
 
 type sqlite struct {
 	path string
 
+	// replicate is false for copies of a database (e.g. restored backups) which must never
+	// be written back to the replica.
+	replicate bool
+
+	// foreignKeys is false when SQLite doesn't enforce the foreign keys of tables
+	foreignKeys bool
+
+	// skipMigrations leaves the schema as it is, for inspecting and rolling back migrations
+	skipMigrations bool
+
 	connections *kitprom.Gauge
 	logger      log.Logger
 
@@ -75,19 +568,39 @@ func (s *sqlite) Connect(ctx context.Context) (*sql.DB, error) {
 		}
 	})
 
-	db, err := sql.Open("sqlite3", s.path)
+	// Recover the database from its replica on fresh hosts
+	replicaURL := LitestreamReplicaURL()
+	if !s.replicate {
+		replicaURL = ""
+	}
+	if replicaURL != "" {
+		replicator.binary, replicator.replicaURL = litestreamBinary(), replicaURL
+		if err := replicator.restore(ctx, s.logger, s.path); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open("sqlite3", s.dsn())
 	if err != nil {
 		return nil, err
 	}
+	if s.path == SQLiteMemoryPath {
+		// Every connection to :memory: opens a new empty database, so only ever keep one
+		db.SetMaxOpenConns(1)
+	}
 	if err := db.Ping(); err != nil {
 		return db, err
 	}
 
 	// Migrate our database
-	if m, err := migrator.New(sqliteMigrations); err != nil {
-		return db, err
-	} else {
-		if err := m.Migrate(db); err != nil {
+	if !s.skipMigrations {
+		if err := migrate(ctx, s.logger, db); err != nil {
+			return db, err
+		}
+	}
+
+	if replicaURL != "" {
+		if err := replicator.replicate(ctx, s.logger, db, s.path); err != nil {
 			return db, err
 		}
 	}
@@ -109,14 +622,41 @@ func (s *sqlite) Connect(ctx context.Context) (*sql.DB, error) {
 	return db, err
 }
 
+// dsn is the path of the database with connection options, which go-sqlite3 removes before opening the file.
+func (s *sqlite) dsn() string {
+	sep := "?"
+	if strings.Contains(s.path, "?") {
+		sep = "&"
+	}
+	if s.foreignKeys {
+		return s.path + sep + "_foreign_keys=on"
+	}
+	return s.path + sep + "_foreign_keys=off"
+}
+
 func SQLiteConnection(logger log.Logger, path string) *sqlite {
+	foreignKeys, err := ForeignKeys()
 	return &sqlite{
 		path:        path,
+		replicate:   true,
+		foreignKeys: foreignKeys,
 		logger:      logger,
 		connections: sqliteConnections,
+		err:         err,
 	}
 }
 
+// SQLiteUnreplicatedConnection opens a SQLite database without restoring or replicating it through
+// litestream, which is used for scratch copies of the database.
+func SQLiteUnreplicatedConnection(logger log.Logger, path string) *sqlite {
+	s := SQLiteConnection(logger, path)
+	s.replicate = false
+	return s
+}
+
+// SQLiteMemoryPath opens a SQLite database which only exists for the lifetime of its connection.
+const SQLiteMemoryPath = ":memory:"
+
 func SQLitePath() string {
 	path := os.Getenv("SQLITE_DB_PATH")
 	if path == "" || strings.Contains(path, "..") {
@@ -127,6 +667,21 @@ func SQLitePath() string {
 	return path
 }
 
+func SQLiteShadowPath() string {
+	path := os.Getenv("SQLITE_SHADOW_DB_PATH")
+	if path == "" || strings.Contains(path, "..") {
+		path = "accounts-shadow.db"
+	}
+	return path
+}
+
+// SQLiteShardPath returns the filepath for a shard's database, derived from SQLitePath().
+func SQLiteShardPath(shard int) string {
+	path := SQLitePath()
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s-shard%d%s", strings.TrimSuffix(path, ext), shard, ext)
+}
+
 // TestSQLiteDB is a wrapper around sql.DB for SQLite connections designed for tests to provide
 // a clean database for each testcase.  Callers should cleanup with Close() when finished.
 type TestSQLiteDB struct {
@@ -162,7 +717,11 @@ func CreateTestSqliteDB(t *testing.T) *TestSQLiteDB {
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
-	db, err := SQLiteConnection(log.NewNopLogger(), filepath.Join(dir, "accounts.db")).Connect(ctx)
+	// Repositories are tested on their own, such as transactions of accounts which were never stored, so foreign
+	// keys are only enforced by tests which ask for them.
+	s := SQLiteConnection(log.NewNopLogger(), filepath.Join(dir, "accounts.db"))
+	s.foreignKeys = false
+	db, err := s.Connect(ctx)
 	if err != nil {
 		t.Fatalf("sqlite test: %v", err)
 	}
@@ -173,6 +732,15 @@ func CreateTestSqliteDB(t *testing.T) *TestSQLiteDB {
 	return &TestSQLiteDB{DB: db, Dir: dir, shutdown: cancelFunc}
 }
 
+// SqliteBusy returns true when the provided error is SQLite giving up on a lock held by another connection
+// (SQLITE_BUSY or SQLITE_LOCKED), which happens when writers contend for the database.
+func SqliteBusy(err error) bool {
+	if e, ok := err.(sqlite3.Error); ok && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked) {
+		return true
+	}
+	return err != nil && (strings.Contains(err.Error(), "database is locked") || strings.Contains(err.Error(), "database table is locked"))
+}
+
 // SqliteUniqueViolation returns true when the provided error matches the SQLite error
 // for duplicate entries (violating a unique table constraint).
 func SqliteUniqueViolation(err error) bool {
@@ -46,6 +46,21 @@ func TestSQLite__SQLitePath(t *testing.T) {
 	}
 }
 
+func TestSQLite__SQLiteShadowPath(t *testing.T) {
+	if v := SQLiteShadowPath(); v != "accounts-shadow.db" {
+		t.Errorf("got %s", v)
+	}
+}
+
+func TestSQLite__SQLiteShardPath(t *testing.T) {
+	if v := SQLiteShardPath(0); v != "accounts-shard0.db" {
+		t.Errorf("got %s", v)
+	}
+	if v := SQLiteShardPath(3); v != "accounts-shard3.db" {
+		t.Errorf("got %s", v)
+	}
+}
+
 func TestSqliteUniqueViolation(t *testing.T) {
 	err := errors.New(`problem upserting account="7d676c65eccd48090ff238a0d5e35eb6126c23f2", userId="80cfe1311d9eb7659d02cba9ee6cb04ed3739a85": UNIQUE constraint failed: accounts.account_id`)
 	if !UniqueViolation(err) {
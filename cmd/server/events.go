@@ -0,0 +1,128 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// event describes a change inside of Accounts which other systems might want to react to.
+type event struct {
+	ID        string      `json:"id"`
+	Type      string      `json:"type"`
+	Timestamp time.Time   `json:"timestamp"`
+	Data      interface{} `json:"data"`
+}
+
+func newEvent(_type string, data interface{}) event {
+	return event{
+		ID:        newID(),
+		Type:      _type,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+}
+
+// transactionEvent returns the event emitted when a transaction enters the given status.
+func transactionEvent(status TransactionStatus, tx *transaction) event {
+	return newEvent(fmt.Sprintf("transaction.%s", status), tx)
+}
+
+type eventPublisher interface {
+	publish(evt event) error
+}
+
+// loggingEventPublisher writes each event as a log line.
+type loggingEventPublisher struct {
+	logger log.Logger
+}
+
+func (p *loggingEventPublisher) publish(evt event) error {
+	bs, err := json.Marshal(evt)
+	if err != nil {
+		return fmt.Errorf("publish: event=%s: %v", evt.ID, err)
+	}
+	return p.logger.Log("events", evt.Type, "event", string(bs))
+}
+
+// multiEventPublisher publishes each event to every publisher, even if an earlier one fails.
+type multiEventPublisher []eventPublisher
+
+func (ps multiEventPublisher) publish(evt event) error {
+	var errs []string
+	for i := range ps {
+		if err := ps[i].publish(evt); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("publish: event=%s: %s", evt.ID, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// eventEncoding describes how events are serialized when they're sent to other systems.
+type eventEncoding struct {
+	contentType string
+	marshal     func(evt event) ([]byte, error)
+}
+
+var jsonEventEncoding = &eventEncoding{
+	contentType: "application/json; charset=utf-8",
+	marshal: func(evt event) ([]byte, error) {
+		return json.Marshal(evt)
+	},
+}
+
+// readEventEncoding returns the encoding from EVENT_FORMAT, which is either json (the default)
+// or cloudevents.
+func readEventEncoding() (*eventEncoding, error) {
+	switch format := strings.ToLower(strings.TrimSpace(os.Getenv("EVENT_FORMAT"))); format {
+	case "", "json":
+		return jsonEventEncoding, nil
+	case "cloudevents":
+		return cloudEventEncoding(or(os.Getenv("CLOUDEVENTS_SOURCE"), "moov-io/accounts")), nil
+	default:
+		return nil, fmt.Errorf("unknown EVENT_FORMAT %q", format)
+	}
+}
+
+// cloudEvent is the CloudEvents 1.0 structured JSON envelope of an event.
+//
+// See https://github.com/cloudevents/spec/blob/v1.0/spec.md
+type cloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	ID              string      `json:"id"`
+	Source          string      `json:"source"`
+	Type            string      `json:"type"`
+	Time            time.Time   `json:"time"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// cloudEventEncoding wraps events in a CloudEvents envelope. Event types are prefixed with
+// io.moov.accounts as the spec recommends reverse-DNS names.
+func cloudEventEncoding(source string) *eventEncoding {
+	return &eventEncoding{
+		contentType: "application/cloudevents+json; charset=utf-8",
+		marshal: func(evt event) ([]byte, error) {
+			return json.Marshal(cloudEvent{
+				SpecVersion:     "1.0",
+				ID:              evt.ID,
+				Source:          source,
+				Type:            "io.moov.accounts." + evt.Type,
+				Time:            evt.Timestamp,
+				DataContentType: "application/json",
+				Data:            evt.Data,
+			})
+		},
+	}
+}
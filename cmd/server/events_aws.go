@@ -0,0 +1,259 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/go-kit/kit/log"
+)
+
+// awsMaxBatchSize is the most messages SNS and SQS accept in one batch request.
+const awsMaxBatchSize = 10
+
+// awsEntry is an encoded event waiting to be sent to AWS.
+type awsEntry struct {
+	id        string
+	eventType string
+	body      []byte
+
+	// lastError is the reason the entry's most recent send failed
+	lastError string
+}
+
+// awsBatchSender sends up to awsMaxBatchSize entries in one request and returns the entries which failed.
+type awsBatchSender interface {
+	sendBatch(entries []*awsEntry) []*awsEntry
+}
+
+type snsSender struct {
+	client   snsiface.SNSAPI
+	topicARN string
+}
+
+func (s *snsSender) sendBatch(entries []*awsEntry) []*awsEntry {
+	input := &sns.PublishBatchInput{TopicArn: aws.String(s.topicARN)}
+	for i := range entries {
+		input.PublishBatchRequestEntries = append(input.PublishBatchRequestEntries, &sns.PublishBatchRequestEntry{
+			Id:      aws.String(entries[i].id),
+			Message: aws.String(string(entries[i].body)),
+			MessageAttributes: map[string]*sns.MessageAttributeValue{
+				"type": {DataType: aws.String("String"), StringValue: aws.String(entries[i].eventType)},
+			},
+		})
+	}
+	out, err := s.client.PublishBatch(input)
+	if err != nil {
+		return failAll(entries, err)
+	}
+	var failed []*awsEntry
+	for i := range out.Failed {
+		failed = append(failed, failedEntry(entries, out.Failed[i].Id, out.Failed[i].Code, out.Failed[i].Message)...)
+	}
+	return failed
+}
+
+type sqsSender struct {
+	client   sqsiface.SQSAPI
+	queueURL string
+}
+
+func (s *sqsSender) sendBatch(entries []*awsEntry) []*awsEntry {
+	input := &sqs.SendMessageBatchInput{QueueUrl: aws.String(s.queueURL)}
+	for i := range entries {
+		attributes := map[string]*sqs.MessageAttributeValue{
+			"type": {DataType: aws.String("String"), StringValue: aws.String(entries[i].eventType)},
+		}
+		if entries[i].lastError != "" {
+			attributes["error"] = &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(entries[i].lastError)}
+		}
+		input.Entries = append(input.Entries, &sqs.SendMessageBatchRequestEntry{
+			Id:                aws.String(entries[i].id),
+			MessageBody:       aws.String(string(entries[i].body)),
+			MessageAttributes: attributes,
+		})
+	}
+	out, err := s.client.SendMessageBatch(input)
+	if err != nil {
+		return failAll(entries, err)
+	}
+	var failed []*awsEntry
+	for i := range out.Failed {
+		failed = append(failed, failedEntry(entries, out.Failed[i].Id, out.Failed[i].Code, out.Failed[i].Message)...)
+	}
+	return failed
+}
+
+func failAll(entries []*awsEntry, err error) []*awsEntry {
+	for i := range entries {
+		entries[i].lastError = err.Error()
+	}
+	return entries
+}
+
+func failedEntry(entries []*awsEntry, id, code, message *string) []*awsEntry {
+	for i := range entries {
+		if entries[i].id == aws.StringValue(id) {
+			entries[i].lastError = fmt.Sprintf("%s: %s", aws.StringValue(code), aws.StringValue(message))
+			return []*awsEntry{entries[i]}
+		}
+	}
+	return nil
+}
+
+// awsPublisher sends events to an SNS topic or SQS queue in batches. Events are buffered and sent once a batch
+// fills up or the batch interval passes. Failed events are retried and then sent to the dead-letter queue
+// (when configured) so they can be replayed later.
+type awsPublisher struct {
+	logger   log.Logger
+	encoding *eventEncoding
+
+	sender awsBatchSender
+	dlq    awsBatchSender
+
+	batchSize int
+	interval  time.Duration
+	attempts  int
+
+	entries chan *awsEntry
+	done    chan struct{}
+}
+
+// setupAWSPublisher returns a publisher for AWS_SNS_TOPIC_ARN or AWS_SQS_QUEUE_URL, or nil if neither is set.
+// Credentials and the region are read from the standard AWS environment variables, shared config files or the
+// IAM role of the instance or task.
+func setupAWSPublisher(logger log.Logger, encoding *eventEncoding) (*awsPublisher, error) {
+	topicARN, queueURL := os.Getenv("AWS_SNS_TOPIC_ARN"), os.Getenv("AWS_SQS_QUEUE_URL")
+	if topicARN == "" && queueURL == "" {
+		return nil, nil
+	}
+	if topicARN != "" && queueURL != "" {
+		return nil, errors.New("only one of AWS_SNS_TOPIC_ARN or AWS_SQS_QUEUE_URL can be set")
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+	if err != nil {
+		return nil, fmt.Errorf("aws: session: %v", err)
+	}
+	var sender awsBatchSender
+	if topicARN != "" {
+		sender = &snsSender{client: sns.New(sess), topicARN: topicARN}
+	} else {
+		sender = &sqsSender{client: sqs.New(sess), queueURL: queueURL}
+	}
+	var dlq awsBatchSender
+	if v := os.Getenv("AWS_EVENTS_DLQ_URL"); v != "" {
+		dlq = &sqsSender{client: sqs.New(sess), queueURL: v}
+	}
+
+	batchSize := awsMaxBatchSize
+	if v := os.Getenv("AWS_EVENTS_BATCH_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > awsMaxBatchSize {
+			return nil, fmt.Errorf("invalid AWS_EVENTS_BATCH_SIZE %q: must be between 1 and %d", v, awsMaxBatchSize)
+		}
+		batchSize = n
+	}
+	interval := time.Second
+	if v := os.Getenv("AWS_EVENTS_BATCH_INTERVAL"); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil || dur <= 0 {
+			return nil, fmt.Errorf("invalid AWS_EVENTS_BATCH_INTERVAL %q", v)
+		}
+		interval = dur
+	}
+	return newAWSPublisher(logger, encoding, sender, dlq, batchSize, interval), nil
+}
+
+func newAWSPublisher(logger log.Logger, encoding *eventEncoding, sender, dlq awsBatchSender, batchSize int, interval time.Duration) *awsPublisher {
+	p := &awsPublisher{
+		logger:    logger,
+		encoding:  encoding,
+		sender:    sender,
+		dlq:       dlq,
+		batchSize: batchSize,
+		interval:  interval,
+		attempts:  3,
+		entries:   make(chan *awsEntry, 10*batchSize),
+		done:      make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// publish queues evt to be sent in the next batch. Send failures are logged rather than returned.
+func (p *awsPublisher) publish(evt event) error {
+	bs, err := p.encoding.marshal(evt)
+	if err != nil {
+		return fmt.Errorf("aws: event=%s: %v", evt.ID, err)
+	}
+	p.entries <- &awsEntry{id: evt.ID, eventType: evt.Type, body: bs}
+	return nil
+}
+
+func (p *awsPublisher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	var batch []*awsEntry
+	for {
+		select {
+		case entry, ok := <-p.entries:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			batch = append(batch, entry)
+			if len(batch) >= p.batchSize {
+				p.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			p.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+// flush sends batch, retrying failed entries before moving them to the dead-letter queue.
+func (p *awsPublisher) flush(batch []*awsEntry) {
+	pending := batch
+	for i := 0; i < p.attempts && len(pending) > 0; i++ {
+		pending = p.sender.sendBatch(pending)
+	}
+	if len(pending) == 0 {
+		return
+	}
+	if p.dlq != nil {
+		failed := p.dlq.sendBatch(pending)
+		if len(failed) == 0 {
+			p.logger.Log("events", fmt.Sprintf("moved %d events to the dead-letter queue", len(pending)))
+			return
+		}
+		pending = failed
+	}
+	for i := range pending {
+		p.logger.Log("events", fmt.Sprintf("dropped event=%s: %s", pending[i].id, pending[i].lastError))
+	}
+}
+
+// Close sends any buffered events and stops the publisher.
+func (p *awsPublisher) Close() error {
+	close(p.entries)
+	<-p.done
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/go-kit/kit/log"
+)
+
+type mockSNS struct {
+	snsiface.SNSAPI
+
+	// failures is how many times each message ID is rejected
+	failures map[string]int
+	messages []string
+}
+
+func (c *mockSNS) PublishBatch(input *sns.PublishBatchInput) (*sns.PublishBatchOutput, error) {
+	out := &sns.PublishBatchOutput{}
+	for _, entry := range input.PublishBatchRequestEntries {
+		if aws.StringValue(entry.MessageAttributes["type"].StringValue) == "" {
+			return nil, errors.New("missing type attribute")
+		}
+		if c.failures[*entry.Id] > 0 {
+			c.failures[*entry.Id]--
+			out.Failed = append(out.Failed, &sns.BatchResultErrorEntry{Id: entry.Id, Code: aws.String("InternalError"), Message: aws.String("bad thing")})
+			continue
+		}
+		c.messages = append(c.messages, *entry.Id)
+	}
+	return out, nil
+}
+
+type mockSQS struct {
+	sqsiface.SQSAPI
+
+	mu       sync.Mutex
+	err      error
+	messages []*sqs.SendMessageBatchRequestEntry
+}
+
+func (c *mockSQS) SendMessageBatch(input *sqs.SendMessageBatchInput) (*sqs.SendMessageBatchOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.messages = append(c.messages, input.Entries...)
+	return &sqs.SendMessageBatchOutput{}, nil
+}
+
+func (c *mockSQS) sent() []*sqs.SendMessageBatchRequestEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.messages
+}
+
+func TestAWSPublisher(t *testing.T) {
+	topic, dlq := &mockSNS{failures: make(map[string]int)}, &mockSQS{}
+	publisher := newAWSPublisher(log.NewNopLogger(), jsonEventEncoding, &snsSender{client: topic}, &sqsSender{client: dlq}, 2, time.Hour)
+
+	first, second, third := newEvent("transaction.posted", nil), newEvent("transaction.posted", nil), newEvent("transaction.voided", nil)
+	topic.failures[first.ID] = 1  // succeeds when retried
+	topic.failures[second.ID] = 5 // moves to the DLQ
+	for _, evt := range []event{first, second, third} {
+		if err := publisher.publish(evt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Close sends the remaining partial batch
+	publisher.Close()
+
+	if len(topic.messages) != 2 || topic.messages[0] != first.ID || topic.messages[1] != third.ID {
+		t.Errorf("unexpected messages: %v", topic.messages)
+	}
+	if len(dlq.messages) != 1 || *dlq.messages[0].Id != second.ID || *dlq.messages[0].MessageAttributes["error"].StringValue != "InternalError: bad thing" {
+		t.Errorf("unexpected DLQ messages: %v", dlq.messages)
+	}
+}
+
+func TestAWSPublisher__interval(t *testing.T) {
+	queue := &mockSQS{err: errors.New("bad thing")}
+	publisher := newAWSPublisher(log.NewNopLogger(), jsonEventEncoding, &sqsSender{client: queue}, nil, 10, 10*time.Millisecond)
+	defer publisher.Close()
+
+	// failures without a DLQ are dropped
+	if err := publisher.publish(newEvent("transaction.posted", nil)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	queue.mu.Lock()
+	queue.err = nil
+	queue.mu.Unlock()
+
+	evt := newEvent("transaction.posted", nil)
+	if err := publisher.publish(evt); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if len(queue.sent()) > 0 {
+			break
+		}
+	}
+	if messages := queue.sent(); len(messages) != 1 || *messages[0].Id != evt.ID {
+		t.Errorf("unexpected messages: %v", messages)
+	}
+}
+
+func TestSetupAWSPublisher(t *testing.T) {
+	if p, err := setupAWSPublisher(log.NewNopLogger(), jsonEventEncoding); p != nil || err != nil {
+		t.Errorf("publisher=%v error=%v", p, err)
+	}
+
+	os.Setenv("AWS_SNS_TOPIC_ARN", "arn:aws:sns:us-east-1:123456789012:accounts")
+	os.Setenv("AWS_SQS_QUEUE_URL", "https://sqs.us-east-1.amazonaws.com/123456789012/accounts")
+	defer os.Unsetenv("AWS_SNS_TOPIC_ARN")
+	defer os.Unsetenv("AWS_SQS_QUEUE_URL")
+	if _, err := setupAWSPublisher(log.NewNopLogger(), jsonEventEncoding); err == nil {
+		t.Error("expected error")
+	}
+
+	os.Unsetenv("AWS_SQS_QUEUE_URL")
+	os.Setenv("AWS_EVENTS_BATCH_SIZE", "11")
+	defer os.Unsetenv("AWS_EVENTS_BATCH_SIZE")
+	if _, err := setupAWSPublisher(log.NewNopLogger(), jsonEventEncoding); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats.go"
+)
+
+// jetStreamPublisher is the part of nats.JetStream used to publish events.
+type jetStreamPublisher interface {
+	Publish(subj string, data []byte, opts ...nats.PubOpt) (*nats.PubAck, error)
+}
+
+// natsPublisher publishes events to NATS JetStream with a subject per event type (e.g. accounts.transaction.posted).
+// Each publish waits for the stream to acknowledge the event and is retried otherwise. The event ID is sent as the
+// message ID so JetStream discards duplicates from retries.
+type natsPublisher struct {
+	logger   log.Logger
+	conn     *nats.Conn
+	js       jetStreamPublisher
+	prefix   string
+	encoding *eventEncoding
+
+	attempts int
+	backoff  time.Duration
+}
+
+func newNATSPublisher(logger log.Logger, url, prefix string, encoding *eventEncoding) (*natsPublisher, error) {
+	conn, err := nats.Connect(url, nats.Name("moov-io/accounts"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, fmt.Errorf("nats: connecting to %s: %v", url, err)
+	}
+	js, err := conn.JetStream()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("nats: jetstream: %v", err)
+	}
+	return &natsPublisher{
+		logger:   logger,
+		conn:     conn,
+		js:       js,
+		prefix:   prefix,
+		encoding: encoding,
+		attempts: 3,
+		backoff:  500 * time.Millisecond,
+	}, nil
+}
+
+func (p *natsPublisher) subject(evt event) string {
+	return fmt.Sprintf("%s.%s", p.prefix, evt.Type)
+}
+
+func (p *natsPublisher) publish(evt event) error {
+	bs, err := p.encoding.marshal(evt)
+	if err != nil {
+		return fmt.Errorf("nats: event=%s: %v", evt.ID, err)
+	}
+	subject := p.subject(evt)
+	for i := 1; ; i++ {
+		_, err = p.js.Publish(subject, bs, nats.MsgId(evt.ID))
+		if err == nil {
+			return nil
+		}
+		if i >= p.attempts {
+			return fmt.Errorf("nats: event=%s subject=%s after %d attempts: %v", evt.ID, subject, i, err)
+		}
+		p.logger.Log("events", fmt.Sprintf("retrying event=%s on subject=%s: %v", evt.ID, subject, err))
+		time.Sleep(p.backoff)
+	}
+}
+
+func (p *natsPublisher) Close() error {
+	if p.conn == nil {
+		return nil
+	}
+	return p.conn.Drain()
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/nats-io/nats.go"
+)
+
+type mockJetStream struct {
+	failures int
+
+	subjects []string
+	messages [][]byte
+}
+
+func (js *mockJetStream) Publish(subj string, data []byte, opts ...nats.PubOpt) (*nats.PubAck, error) {
+	if js.failures > 0 {
+		js.failures--
+		return nil, errors.New("nats: timeout")
+	}
+	js.subjects = append(js.subjects, subj)
+	js.messages = append(js.messages, data)
+	return &nats.PubAck{Stream: "ACCOUNTS", Sequence: uint64(len(js.messages))}, nil
+}
+
+func TestNATSPublisher(t *testing.T) {
+	js := &mockJetStream{failures: 1}
+	publisher := &natsPublisher{
+		logger:   log.NewNopLogger(),
+		js:       js,
+		prefix:   "accounts",
+		encoding: jsonEventEncoding,
+		attempts: 2,
+	}
+
+	evt := transactionEvent(TransactionPosted, &transaction{ID: "transaction"})
+	if err := publisher.publish(evt); err != nil {
+		t.Fatal(err)
+	}
+	if len(js.subjects) != 1 || js.subjects[0] != "accounts.transaction.posted" {
+		t.Errorf("unexpected subjects: %v", js.subjects)
+	}
+
+	js.failures = 2
+	if err := publisher.publish(evt); err == nil {
+		t.Error("expected error")
+	}
+	if err := publisher.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMultiEventPublisher(t *testing.T) {
+	first, second := &mockEventPublisher{err: errors.New("bad thing")}, &mockEventPublisher{}
+	evt := newEvent("transaction.posted", nil)
+
+	if err := (multiEventPublisher{first, second}).publish(evt); err == nil {
+		t.Error("expected error")
+	}
+	if len(first.events) != 1 || len(second.events) != 1 {
+		t.Errorf("first=%d second=%d", len(first.events), len(second.events))
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+func TestEvents__readEventEncoding(t *testing.T) {
+	defer os.Unsetenv("EVENT_FORMAT")
+
+	if enc, err := readEventEncoding(); err != nil || enc != jsonEventEncoding {
+		t.Errorf("encoding=%v error=%v", enc, err)
+	}
+
+	os.Setenv("EVENT_FORMAT", "CloudEvents")
+	if enc, err := readEventEncoding(); err != nil || enc.contentType != "application/cloudevents+json; charset=utf-8" {
+		t.Errorf("encoding=%v error=%v", enc, err)
+	}
+
+	os.Setenv("EVENT_FORMAT", "other")
+	if _, err := readEventEncoding(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestEvents__cloudEventEncoding(t *testing.T) {
+	evt := transactionEvent(TransactionPosted, &transaction{ID: "transaction", Status: TransactionPosted})
+
+	bs, err := cloudEventEncoding("moov-io/accounts").marshal(evt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		cloudEvent
+		Data transaction `json:"data"`
+	}
+	if err := json.Unmarshal(bs, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.SpecVersion != "1.0" || out.ID != evt.ID || out.Source != "moov-io/accounts" || out.Type != "io.moov.accounts.transaction.posted" {
+		t.Errorf("unexpected envelope: %s", string(bs))
+	}
+	if !out.Time.Equal(evt.Timestamp) || out.DataContentType != "application/json" || out.Data.ID != "transaction" {
+		t.Errorf("unexpected envelope: %s", string(bs))
+	}
+}
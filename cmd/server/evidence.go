@@ -0,0 +1,280 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	app "github.com/moov-io/accounts"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// evidenceManifest describes an evidence bundle. It's signed so auditors can check the bundle came from us and
+// each file's checksum shows it hasn't been changed since.
+type evidenceManifest struct {
+	StartDate   time.Time      `json:"startDate"`
+	EndDate     time.Time      `json:"endDate"`
+	GeneratedAt time.Time      `json:"generatedAt"`
+	GeneratedBy string         `json:"generatedBy"`
+	Version     string         `json:"version"`
+	Files       []evidenceFile `json:"files"`
+}
+
+type evidenceFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+// accessRecord is a privileged action taken by an operator, such as requesting or approving a force post.
+type accessRecord struct {
+	UserID    string    `json:"userId"`
+	Action    string    `json:"action"`
+	At        time.Time `json:"at"`
+	Reference string    `json:"reference"`
+}
+
+// ledgerIntegrity is the result of verifying one ledger database when the bundle was generated.
+type ledgerIntegrity struct {
+	Ledger int                 `json:"ledger"`
+	Result *ledgerVerification `json:"result,omitempty"`
+	Error  string              `json:"error,omitempty"`
+}
+
+type evidenceService struct {
+	logger log.Logger
+
+	// signingKey signs each bundle's manifest with HMAC-SHA256, read from EVIDENCE_SIGNING_KEY.
+	signingKey []byte
+
+	forcePosts     forcePostRepository
+	journalImports journalImportRepository
+
+	// ledgers are verified for each bundle. They're empty when transactions aren't stored in SQL.
+	ledgers []*sqlTransactionRepository
+
+	environ func() []string
+}
+
+// sqlLedgers returns the SQL databases behind repo, which is one per shard when storage is sharded.
+func sqlLedgers(repo transactionRepository) []*sqlTransactionRepository {
+	switch r := repo.(type) {
+	case *sqlTransactionRepository:
+		return []*sqlTransactionRepository{r}
+	case *shardedTransactionRepository:
+		var out []*sqlTransactionRepository
+		for i := range r.shards {
+			out = append(out, sqlLedgers(r.shards[i])...)
+		}
+		return out
+	}
+	return nil
+}
+
+// Export writes a zip archive of audit logs, access records, ledger integrity checks and a configuration snapshot
+// for the period from start until end. The archive's manifest.json lists every other file with its checksum and
+// manifest.json.sig is the hex encoded HMAC-SHA256 of the manifest.
+func (s *evidenceService) Export(ctx context.Context, start, end time.Time, userID string) ([]byte, error) {
+	if len(s.signingKey) == 0 {
+		return nil, errors.New("evidence: EVIDENCE_SIGNING_KEY is required to sign bundles")
+	}
+	if start.IsZero() || end.IsZero() || !start.Before(end) {
+		return nil, errors.New("evidence: startDate must be before endDate")
+	}
+
+	posts, err := s.forcePosts.getForcePostsBetween(start, end)
+	if err != nil {
+		return nil, err
+	}
+	imports, err := s.journalImports.getImportsBetween(start, end)
+	if err != nil {
+		return nil, err
+	}
+	if posts == nil {
+		posts = []*forcePost{}
+	}
+	if imports == nil {
+		imports = []*journalImport{}
+	}
+
+	manifest := evidenceManifest{
+		StartDate:   start,
+		EndDate:     end,
+		GeneratedAt: time.Now(),
+		GeneratedBy: userID,
+		Version:     app.Version,
+	}
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, v interface{}) error {
+		bs, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("evidence: %s: %v", name, err)
+		}
+		sum := sha256.Sum256(bs)
+		manifest.Files = append(manifest.Files, evidenceFile{Name: name, SHA256: hex.EncodeToString(sum[:]), Size: len(bs)})
+		return writeEvidenceFile(zw, name, bs)
+	}
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"audit/force_posts.json", posts},
+		{"audit/journal_imports.json", imports},
+		{"access_records.json", collectAccessRecords(posts, imports, start, end)},
+		{"integrity.json", s.verifyLedgers()},
+		{"configuration.json", s.configuration()},
+	}
+	for _, f := range files {
+		if err := add(f.name, f.v); err != nil {
+			return nil, err
+		}
+	}
+	bs, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("evidence: manifest.json: %v", err)
+	}
+	if err := writeEvidenceFile(zw, "manifest.json", bs); err != nil {
+		return nil, err
+	}
+	if err := writeEvidenceFile(zw, "manifest.json.sig", []byte(signEvidence(s.signingKey, bs))); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("evidence: %v", err)
+	}
+	s.logger.Log("evidence", fmt.Sprintf("exported evidence from %v until %v with %d files", start, end, len(manifest.Files)), "requestID", requestIDFrom(ctx), "userID", userID)
+	return buf.Bytes(), nil
+}
+
+func writeEvidenceFile(zw *zip.Writer, name string, bs []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return fmt.Errorf("evidence: %s: %v", name, err)
+	}
+	if _, err := w.Write(bs); err != nil {
+		return fmt.Errorf("evidence: %s: %v", name, err)
+	}
+	return nil
+}
+
+// signEvidence returns the hex encoded HMAC-SHA256 of a bundle's manifest.
+func signEvidence(key, manifest []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(manifest)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// collectAccessRecords lists who requested, reviewed, uploaded or posted force posts and journal imports from
+// start until end, oldest first.
+func collectAccessRecords(posts []*forcePost, imports []*journalImport, start, end time.Time) []accessRecord {
+	out := []accessRecord{}
+	record := func(userID, action string, at *time.Time, reference string) {
+		if at != nil && !at.Before(start) && at.Before(end) {
+			out = append(out, accessRecord{UserID: userID, Action: action, At: *at, Reference: reference})
+		}
+	}
+	for _, p := range posts {
+		record(p.RequestedBy, "force-post.requested", &p.RequestedAt, p.ID)
+		record(p.ReviewedBy, fmt.Sprintf("force-post.%s", p.Status), p.ReviewedAt, p.ID)
+	}
+	for _, imp := range imports {
+		record(imp.CreatedBy, "journal-import.uploaded", &imp.CreatedAt, imp.ID)
+		record(imp.PostedBy, "journal-import.posted", imp.PostedAt, imp.ID)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].At.Before(out[j].At) })
+	return out
+}
+
+func (s *evidenceService) verifyLedgers() []ledgerIntegrity {
+	out := []ledgerIntegrity{}
+	for i := range s.ledgers {
+		result, err := verifyLedger(s.ledgers[i])
+		if err != nil {
+			out = append(out, ledgerIntegrity{Ledger: i, Error: err.Error()})
+			continue
+		}
+		out = append(out, ledgerIntegrity{Ledger: i, Result: result})
+	}
+	return out
+}
+
+// configuration snapshots the server's flags and environment. Values of secrets, such as passwords and keys, are
+// redacted along with credentials in URLs.
+func (s *evidenceService) configuration() map[string]map[string]string {
+	flags := make(map[string]string)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags[f.Name] = f.Value.String()
+	})
+	env := make(map[string]string)
+	environ := s.environ
+	if environ == nil {
+		environ = os.Environ
+	}
+	for _, kv := range environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = redactConfigValue(parts[0], parts[1])
+		}
+	}
+	return map[string]map[string]string{"flags": flags, "environment": env}
+}
+
+func redactConfigValue(name, value string) string {
+	upper := strings.ToUpper(name)
+	for _, secret := range []string{"SECRET", "PASSWORD", "TOKEN", "KEY", "DSN"} {
+		if strings.Contains(upper, secret) && value != "" {
+			return "REDACTED"
+		}
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		u.User = url.User("REDACTED")
+		return u.String()
+	}
+	return value
+}
+
+// exportEvidence is an admin route which returns a signed evidence bundle for ?startDate until ?endDate.
+func exportEvidence(logger log.Logger, svc *evidenceService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		page, err := readTransactionPage(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		bundle, err := svc.Export(requestContext(r), page.StartDate, page.EndDate, moovhttp.GetUserID(r))
+		if err != nil {
+			logger.Log("evidence", fmt.Sprintf("problem exporting evidence: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		filename := fmt.Sprintf("evidence-%s-%s.zip", page.StartDate.Format("20060102"), page.EndDate.Format("20060102"))
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+		w.Write(bundle)
+	}
+}
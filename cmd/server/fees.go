@@ -0,0 +1,476 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Fees are charged by each account's product (see products.go) and credited to FEE_ACCOUNT_ID. A product's
+// transactionFees are charged as qualifying transactions are posted and its monthlyFees once each month ends, unless
+// the account's balance reached the fee's waiver. Accounts without a product are only charged MONTHLY_FEES.
+
+// feeOnInsufficientFunds is the trigger of fees charged after a posting is rejected for insufficient funds (NSF).
+const feeOnInsufficientFunds = "nsf"
+
+// feesMetadataKey records the fees added to a transaction's lines, as comma separated name:accountID:amount, so
+// replays of its request can take them back out of the request's own lines.
+const feesMetadataKey = "fees"
+
+// feeBatchSize is how many accounts are read at once while charging monthly fees
+var feeBatchSize = 100
+
+// feeRule charges Amount to the debited accounts of qualifying transactions. Transactions qualify when one of their
+// credited lines has the rule's purpose (e.g. "wire"), or with "nsf" when they're rejected for insufficient funds.
+type feeRule struct {
+	Name string `json:"name"`
+	On   string `json:"on"`
+
+	// Amount in cents, where zero waives the fee (such as in an account's overrides)
+	Amount int `json:"amount"`
+
+	// Separate fees are posted as their own transaction after the qualifying one, rather than adding lines to it.
+	// NSF fees are always separate.
+	Separate bool `json:"separate,omitempty"`
+}
+
+func (r *feeRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("transaction fee on %q has no name", r.On)
+	}
+	r.On = strings.ToLower(r.On)
+	switch r.On {
+	case feeOnInsufficientFunds:
+		r.Separate = true
+	case string(ACHDebit), string(Fee):
+		return fmt.Errorf("fee %s can't be charged on %s lines", r.Name, r.On)
+	default:
+		if err := TransactionPurpose(r.On).validate(); err != nil {
+			return fmt.Errorf("fee %s: %v", r.Name, err)
+		}
+	}
+	if r.Amount < 0 {
+		return fmt.Errorf("fee %s has a negative amount", r.Name)
+	}
+	return nil
+}
+
+// assessedFee is a fee charged to one account for a transaction.
+type assessedFee struct {
+	rule      feeRule
+	accountID string
+}
+
+// lines moves the fee from the account to feeAccountID.
+func (f assessedFee) lines(feeAccountID string) []transactionLine {
+	description := fmt.Sprintf("%s fee", f.rule.Name)
+	return []transactionLine{
+		{AccountID: f.accountID, Purpose: ACHDebit, Amount: f.rule.Amount, Description: description},
+		{AccountID: feeAccountID, Purpose: Fee, Amount: f.rule.Amount, Description: description},
+	}
+}
+
+// addTo charges the fee on the lines of a qualifying transaction, which can only have one line per account. The fee
+// is added to the account's debit and credited to feeAccountID on one fee line shared by every fee.
+func (f assessedFee) addTo(lines []transactionLine, feeAccountID string) []transactionLine {
+	description := fmt.Sprintf("%s fee", f.rule.Name)
+	for i := range lines {
+		if lines[i].AccountID == f.accountID && lines[i].Purpose == ACHDebit {
+			lines[i].Amount += f.rule.Amount
+			break
+		}
+	}
+	for i := range lines {
+		if lines[i].AccountID == feeAccountID {
+			lines[i].Amount += f.rule.Amount
+			lines[i].Description += ", " + description
+			return lines
+		}
+	}
+	return append(lines, transactionLine{AccountID: feeAccountID, Purpose: Fee, Amount: f.rule.Amount, Description: description})
+}
+
+// metadata is how the fee is recorded under feesMetadataKey when it's added to a transaction's lines.
+func (f assessedFee) metadata() string {
+	return fmt.Sprintf("%s:%s:%d", f.rule.Name, f.accountID, f.rule.Amount)
+}
+
+// idempotencyKey is the key a separate fee is posted with, so it's charged once for each source: every retry of a
+// request shares its idempotency key, while requests without one are each their own source.
+func (f assessedFee) idempotencyKey(source transaction) string {
+	id := source.IdempotencyKey
+	if id == "" {
+		id = source.ID
+	}
+	return fmt.Sprintf("fee/%x", sha1.Sum([]byte(fmt.Sprintf("%s/%s/%s", id, f.accountID, f.rule.Name))))
+}
+
+// monthlyFeeRun summarizes the monthly fees charged for a month.
+type monthlyFeeRun struct {
+	Month    string   `json:"month"`
+	Charged  int      `json:"charged"`
+	Amount   int64    `json:"amount"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+// feeEngine charges the fees of each account's product.
+type feeEngine struct {
+	logger log.Logger
+
+	// accountID is the account fees are credited to, read from FEE_ACCOUNT_ID
+	accountID string
+
+	// rules are the monthly fees of each account and, through their products, their transaction fees
+	rules    *projectionRules
+	accounts accountRepository
+
+	// transactions posts separate and monthly fees, and shouldn't charge fees itself
+	transactions *transactionService
+
+	// now is the time fees are charged at, which in sandbox mode is the virtual clock
+	now func() time.Time
+
+	// lastMonth is the last month (YYYY-MM) run charged without failures
+	lastMonth string
+}
+
+// transactionFees returns the transaction fees of the account's product, which are none without a product.
+func (e *feeEngine) transactionFees(accountID string) ([]feeRule, error) {
+	settings, err := e.rules.products.settingsOf(accountID)
+	if err != nil || settings == nil {
+		return nil, err
+	}
+	return settings.TransactionFees, nil
+}
+
+// assess returns the fees owed by the debited accounts of tx for the triggers which tx matches.
+func (e *feeEngine) assess(ctx context.Context, tx transaction, matches func(on string) bool) ([]assessedFee, error) {
+	var accountIDs []string
+	for i := range tx.Lines {
+		if line := tx.Lines[i]; line.Purpose == ACHDebit && line.AccountID != e.accountID && !containsString(accountIDs, line.AccountID) {
+			accountIDs = append(accountIDs, line.AccountID)
+		}
+	}
+	sort.Strings(accountIDs)
+
+	var fees []assessedFee
+	for _, accountID := range accountIDs {
+		rules, err := e.transactionFees(accountID)
+		if err != nil {
+			return fees, fmt.Errorf("account=%s: %v", accountID, err)
+		}
+		for _, rule := range rules {
+			if matches(rule.On) && rule.Amount > 0 {
+				fees = append(fees, assessedFee{rule: rule, accountID: accountID})
+			}
+		}
+	}
+	return fees, nil
+}
+
+// exempt returns true if tx involves the fee account, which exempts it from fees.
+func (e *feeEngine) exempt(tx transaction) bool {
+	for i := range tx.Lines {
+		if tx.Lines[i].AccountID == e.accountID {
+			return true
+		}
+	}
+	return false
+}
+
+// postSeparately charges fees as a transaction of their own after source, unless an earlier attempt of source
+// already did. NSF fees may overdraw the account.
+func (e *feeEngine) postSeparately(ctx context.Context, source transaction, fees []assessedFee, allowOverdraft bool) {
+	for _, fee := range fees {
+		tx := transaction{
+			ID:             newID(),
+			Timestamp:      e.now(),
+			Status:         TransactionPosted,
+			Description:    fmt.Sprintf("%s fee for transaction %s", fee.rule.Name, source.ID),
+			Metadata:       map[string]string{"fee": fee.rule.Name, "sourceTransactionId": source.ID},
+			Lines:          fee.lines(e.accountID),
+			IdempotencyKey: fee.idempotencyKey(source),
+		}
+		if existing, err := e.transactions.repo.getTransactionByIdempotencyKey(ctx, tx.IdempotencyKey); err != nil || existing != nil {
+			if err != nil {
+				e.logger.Log("fees", fmt.Sprintf("problem reading %s fee of account=%s for transaction=%s: %v", fee.rule.Name, fee.accountID, source.ID, err), "requestID", requestIDFrom(ctx))
+			}
+			continue // already charged by an earlier attempt
+		}
+		if err := e.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: allowOverdraft}); err != nil {
+			e.logger.Log("fees", fmt.Sprintf("problem charging %s fee to account=%s for transaction=%s: %v", fee.rule.Name, fee.accountID, source.ID, err), "requestID", requestIDFrom(ctx))
+			continue
+		}
+		e.logger.Log("fees", fmt.Sprintf("charged %s fee of %d to account=%s for transaction=%s", fee.rule.Name, fee.rule.Amount, fee.accountID, source.ID), "transactionID", tx.ID, "requestID", requestIDFrom(ctx))
+		e.transactions.publish(ctx, tx.Status, &tx)
+	}
+}
+
+// chargeMonth charges the monthly fees of every open account for a month (YYYY-MM, by default last month) on its
+// balance when the month ended in the account's time zone, which must have happened in every time zone. Each fee is
+// posted with an idempotency key naming the account, month and fee so running a month again only charges the fees
+// which failed.
+func (e *feeEngine) chargeMonth(ctx context.Context, month string) (*monthlyFeeRun, error) {
+	start, _, err := statementCycle(month, e.now(), lastTimeZone)
+	if err != nil {
+		return nil, err
+	}
+	run := &monthlyFeeRun{Month: start.Format("2006-01")}
+	month = run.Month
+	after := ""
+	for {
+		accts, err := e.accounts.ListAccounts(after, feeBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(accts) == 0 {
+			break
+		}
+		for _, acct := range accts {
+			after = acct.ID
+			if acct.ID == e.accountID || accountClosed(acct) {
+				continue
+			}
+			if err := e.chargeAccountMonth(ctx, acct.ID, acct.CreatedAt, month, run); err != nil {
+				e.logger.Log("fees", fmt.Sprintf("problem charging %s monthly fees to account=%s: %v", month, acct.ID, err), "requestID", requestIDFrom(ctx))
+				run.Failures = append(run.Failures, fmt.Sprintf("account=%s: %v", acct.ID, err))
+			}
+		}
+	}
+	e.logger.Log("fees", fmt.Sprintf("charged %d of %s monthly fees to %d accounts", run.Amount, month, run.Charged), "requestID", requestIDFrom(ctx))
+	return run, nil
+}
+
+func (e *feeEngine) chargeAccountMonth(ctx context.Context, accountID string, createdAt time.Time, month string, run *monthlyFeeRun) error {
+	rules, err := e.rules.forAccount(accountID)
+	if err != nil {
+		return err
+	}
+	if len(rules.fees) == 0 {
+		return nil
+	}
+	loc, err := e.rules.products.locationOf(accountID)
+	if err != nil {
+		return err
+	}
+	start, _ := time.ParseInLocation("2006-01", month, loc)
+	end := start.AddDate(0, 1, 0)
+	if !createdAt.Before(end) {
+		return nil
+	}
+	balance, err := balanceAt(ctx, e.transactions.repo, accountID, end)
+	if err != nil {
+		return err
+	}
+	for _, fee := range rules.monthlyFees(balance) {
+		if fee.Amount <= 0 {
+			continue
+		}
+		key := fmt.Sprintf("monthly-fee/%s/%s/%s", accountID, month, fee.Name)
+		if existing, err := e.transactions.repo.getTransactionByIdempotencyKey(ctx, key); err != nil || existing != nil {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		charged := assessedFee{rule: feeRule{Name: fee.Name, Amount: int(fee.Amount)}, accountID: accountID}
+		tx := transaction{
+			ID:             newID(),
+			Timestamp:      e.now(),
+			Status:         TransactionPosted,
+			Description:    fmt.Sprintf("%s fee for %s", fee.Name, month),
+			Metadata:       map[string]string{"fee": fee.Name, "month": month},
+			Lines:          charged.lines(e.accountID),
+			IdempotencyKey: key,
+		}
+		// monthly fees may overdraw the account, as a bank's would
+		if err := e.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			return fmt.Errorf("%s fee: %v", fee.Name, err)
+		}
+		e.transactions.publish(ctx, tx.Status, &tx)
+		run.Charged++
+		run.Amount += fee.Amount
+	}
+	return nil
+}
+
+// run charges the monthly fees of the last month to have ended in every time zone, once it has. It returns nil when
+// the month was already charged.
+func (e *feeEngine) run(ctx context.Context, now time.Time) (*monthlyFeeRun, error) {
+	start, _, _ := statementCycle("", now, lastTimeZone)
+	if start.Format("2006-01") == e.lastMonth {
+		return nil, nil
+	}
+	run, err := e.chargeMonth(ctx, start.Format("2006-01"))
+	if err != nil {
+		return nil, err
+	}
+	if len(run.Failures) == 0 {
+		e.lastMonth = run.Month
+	}
+	return run, nil
+}
+
+func setupFeeJob(ctx context.Context, logger log.Logger, e *feeEngine, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if _, err := e.run(ctx, e.now()); err != nil {
+					logger.Log("fees", fmt.Sprintf("problem charging monthly fees: %v", err))
+				}
+			}
+		}
+	}()
+}
+
+// feeTransactionRepository charges fees on the transactions created through it. Fees which aren't separate are
+// added to the lines of the qualifying transaction, so they're posted (or rejected) along with it. Initial deposits,
+// reversals, postings which may overdraw accounts (such as force posts) and postings involving the fee account
+// aren't charged.
+type feeTransactionRepository struct {
+	transactionRepository
+
+	fees *feeEngine
+}
+
+func (r *feeTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	if opts.InitialDeposit || opts.Reversal || opts.AllowOverdraft || r.fees.exempt(tx) {
+		return r.transactionRepository.createTransaction(ctx, tx, opts)
+	}
+
+	credited := make(map[string]bool)
+	for i := range tx.Lines {
+		if tx.Lines[i].Purpose != ACHDebit {
+			credited[string(tx.Lines[i].Purpose)] = true
+		}
+	}
+	fees, err := r.fees.assess(ctx, tx, func(on string) bool { return credited[on] })
+	if err != nil {
+		r.fees.logger.Log("fees", fmt.Sprintf("problem assessing fees of transaction=%s: %v", tx.ID, err), "requestID", requestIDFrom(ctx))
+	}
+	var separate []assessedFee
+	var inline []string
+	posted := tx
+	for _, fee := range fees {
+		if fee.rule.Separate {
+			separate = append(separate, fee)
+			continue
+		}
+		if len(inline) == 0 {
+			posted.Lines = append([]transactionLine(nil), tx.Lines...)
+		}
+		posted.Lines = fee.addTo(posted.Lines, r.fees.accountID)
+		inline = append(inline, fee.metadata())
+	}
+	if len(inline) > 0 {
+		posted.Metadata = make(map[string]string, len(tx.Metadata)+1)
+		for k, v := range tx.Metadata {
+			posted.Metadata[k] = v
+		}
+		posted.Metadata[feesMetadataKey] = strings.Join(inline, ",")
+	}
+
+	if err := r.transactionRepository.createTransaction(ctx, posted, opts); err != nil {
+		if errors.Is(err, errInsufficientFunds) {
+			r.chargeInsufficientFunds(ctx, tx)
+		}
+		return err
+	}
+	if len(separate) > 0 {
+		r.fees.postSeparately(ctx, tx, separate, false)
+	}
+	return nil
+}
+
+// getTransactionByIdempotencyKey returns the transaction as it was created, without the fees added to its lines, so
+// replaying its request can be matched against the request's lines.
+func (r *feeTransactionRepository) getTransactionByIdempotencyKey(ctx context.Context, key string) (*transaction, error) {
+	tx, err := r.transactionRepository.getTransactionByIdempotencyKey(ctx, key)
+	if err != nil || tx == nil || tx.Metadata[feesMetadataKey] == "" {
+		return tx, err
+	}
+	// transactions involving the fee account aren't charged, so its line only holds the fees
+	var lines []transactionLine
+	for _, line := range tx.Lines {
+		if line.AccountID != r.fees.accountID {
+			lines = append(lines, line)
+		}
+	}
+	for _, fee := range strings.Split(tx.Metadata[feesMetadataKey], ",") {
+		parts := strings.Split(fee, ":")
+		if len(parts) != 3 {
+			continue
+		}
+		amount, err := strconv.Atoi(parts[2])
+		if err != nil {
+			continue
+		}
+		for i := range lines {
+			if lines[i].AccountID == parts[1] && lines[i].Purpose == ACHDebit {
+				lines[i].Amount -= amount
+				break
+			}
+		}
+	}
+	out := *tx
+	out.Lines = lines
+	out.Metadata = make(map[string]string, len(tx.Metadata))
+	for k, v := range tx.Metadata {
+		if k != feesMetadataKey {
+			out.Metadata[k] = v
+		}
+	}
+	if len(out.Metadata) == 0 {
+		out.Metadata = nil
+	}
+	return &out, nil
+}
+
+// chargeInsufficientFunds charges NSF fees to the debited accounts of a transaction rejected for insufficient funds.
+func (r *feeTransactionRepository) chargeInsufficientFunds(ctx context.Context, tx transaction) {
+	fees, err := r.fees.assess(ctx, tx, func(on string) bool { return on == feeOnInsufficientFunds })
+	if err != nil {
+		r.fees.logger.Log("fees", fmt.Sprintf("problem assessing NSF fees of transaction=%s: %v", tx.ID, err), "requestID", requestIDFrom(ctx))
+		return
+	}
+	r.fees.postSeparately(ctx, tx, fees, true)
+}
+
+// chargeMonthlyFees is an admin route which charges the monthly fees of ?month=YYYY-MM (by default last month), such
+// as to retry accounts which failed. Fees already charged for the month are skipped.
+func chargeMonthlyFees(logger log.Logger, e *feeEngine) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		run, err := e.chargeMonth(requestContext(r), r.URL.Query().Get("month"))
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(run)
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestFeeRule__validate(t *testing.T) {
+	settings := productSettings{TransactionFees: []feeRule{
+		{Name: "wire", On: "Wire", Amount: 2500},
+		{Name: "nsf", On: "nsf", Amount: 3500},
+	}}
+	if err := settings.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if fees := settings.TransactionFees; fees[0].On != "wire" || fees[0].Separate || !fees[1].Separate {
+		t.Errorf("unexpected fees: %#v", fees)
+	}
+
+	invalid := map[string][]feeRule{
+		"no name":         {{On: "wire", Amount: 1}},
+		"achdebit":        {{Name: "debit", On: "achdebit", Amount: 1}},
+		"fee":             {{Name: "fee", On: "fee", Amount: 1}},
+		"unknown trigger": {{Name: "check", On: "check", Amount: 1}},
+		"negative":        {{Name: "wire", On: "wire", Amount: -1}},
+		"repeated":        {{Name: "wire", On: "wire", Amount: 1}, {Name: "wire", On: "card", Amount: 2}},
+	}
+	for name, fees := range invalid {
+		settings := productSettings{TransactionFees: fees}
+		if err := settings.validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// overrides replace the product's transaction fees
+	waived := settings.merge(productSettings{TransactionFees: []feeRule{}})
+	if len(waived.TransactionFees) != 0 {
+		t.Errorf("unexpected fees: %#v", waived.TransactionFees)
+	}
+	if kept := settings.merge(productSettings{}); len(kept.TransactionFees) != 2 {
+		t.Errorf("unexpected fees: %#v", kept.TransactionFees)
+	}
+}
+
+// createTestFeeEngine returns a fee engine crediting "income" which charges the products assigned in repo.
+func createTestFeeEngine(t *testing.T, accountRepo accountRepository, ledger transactionRepository) (*feeEngine, productRepository) {
+	t.Helper()
+
+	db := database.CreateTestSqliteDB(t)
+	t.Cleanup(func() { db.Close() })
+	repo := &sqlProductRepository{db.DB, log.NewNopLogger()}
+	fees := &feeEngine{
+		logger:       log.NewNopLogger(),
+		accountID:    "income",
+		rules:        &projectionRules{products: &productCatalog{repo: repo}},
+		accounts:     accountRepo,
+		transactions: &transactionService{logger: log.NewNopLogger(), repo: ledger, events: &mockEventPublisher{}},
+		now:          time.Now,
+	}
+	return fees, repo
+}
+
+func TestFees(t *testing.T) {
+	accountRepo, ledger := createTestLedger(t, map[string]int{"alice": 10000, "bob": 1000, "carol": 3000, "dave": 3000, "income": 0})
+	fees, products := createTestFeeEngine(t, accountRepo, ledger)
+	checking := productSettings{TransactionFees: []feeRule{
+		{Name: "wire", On: "wire", Amount: 2500},
+		{Name: "foreign-card", On: "card", Amount: 100, Separate: true},
+		{Name: "nsf", On: "nsf", Amount: 3500},
+	}}
+	if err := checking.validate(); err != nil {
+		t.Fatal(err)
+	}
+	for _, accountID := range []string{"alice", "bob", "carol"} {
+		createTestProduct(t, products, accountID, checking)
+	}
+	svc := &transactionService{logger: log.NewNopLogger(), repo: &feeTransactionRepository{transactionRepository: ledger, fees: fees}, events: &mockEventPublisher{}}
+	ctx := context.Background()
+
+	post := func(lines ...transactionLine) (*transaction, error) {
+		t.Helper()
+		return svc.CreateTransaction(ctx, createTransactionRequest{Lines: lines})
+	}
+
+	// wire fees are added to the wire's debit
+	tx, err := post(transactionLine{AccountID: "alice", Purpose: ACHDebit, Amount: 1000}, transactionLine{AccountID: "wires", Purpose: Wire, Amount: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	posted, err := ledger.getTransaction(ctx, tx.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posted.Lines) != 3 || posted.Lines[0].Amount != 3500 || posted.Lines[2].Purpose != Fee || posted.Lines[2].Description != "wire fee" {
+		t.Errorf("unexpected lines: %#v", posted.Lines)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 6500, "income": 2500})
+
+	// separate fees are their own transaction
+	if _, err := post(transactionLine{AccountID: "alice", Purpose: ACHDebit, Amount: 500}, transactionLine{AccountID: "merchant", Purpose: Card, Amount: 500, MCC: "5411"}); err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 5900, "income": 2600})
+	transactions, _, err := ledger.getAccountTransactions(ctx, "income", transactionPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 2 || transactions[0].Metadata["fee"] != "foreign-card" || transactions[0].Metadata["sourceTransactionId"] == "" {
+		t.Errorf("unexpected fee transactions: %#v", transactions)
+	}
+
+	// NSF fees are charged when a debit bounces, even if they overdraw the account
+	_, err = post(transactionLine{AccountID: "bob", Purpose: ACHDebit, Amount: 5000}, transactionLine{AccountID: "landlord", Purpose: ACHCredit, Amount: 5000})
+	if err == nil || !strings.Contains(err.Error(), errInsufficientFunds.Error()) {
+		t.Fatalf("expected insufficient funds: %v", err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"bob": -2500, "income": 6100})
+
+	// a wire which can't cover its fee bounces
+	if _, err := post(transactionLine{AccountID: "carol", Purpose: ACHDebit, Amount: 1000}, transactionLine{AccountID: "wires", Purpose: Wire, Amount: 1000}); err == nil {
+		t.Fatal("expected insufficient funds")
+	}
+	checkBalances(t, accountRepo, map[string]int32{"carol": -500, "income": 9600})
+
+	// accounts without a product aren't charged transaction fees
+	if _, err := post(transactionLine{AccountID: "dave", Purpose: ACHDebit, Amount: 1000}, transactionLine{AccountID: "wires", Purpose: Wire, Amount: 1000}); err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"dave": 2000, "income": 9600})
+
+	// refunds from the fee account aren't charged
+	if _, err := post(transactionLine{AccountID: "income", Purpose: ACHDebit, Amount: 2500}, transactionLine{AccountID: "alice", Purpose: Wire, Amount: 2500}); err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 8400, "income": 7100})
+}
+
+func TestFees__monthly(t *testing.T) {
+	accountRepo, ledger := createTestLedger(t, map[string]int{"alice": 0, "bob": 0, "carol": 0, "income": 0})
+	fees, products := createTestFeeEngine(t, accountRepo, ledger)
+	fees.rules.fees = []monthlyFee{{Name: "maintenance", Amount: 300}}
+	createTestProduct(t, products, "alice", productSettings{MonthlyFees: []monthlyFee{{Name: "maintenance", Amount: 500, WaiveAbove: 150000}}})
+	createTestProduct(t, products, "bob", productSettings{MonthlyFees: []monthlyFee{{Name: "maintenance", Amount: 500, WaiveAbove: 150000}}})
+	ctx := context.Background()
+
+	// alice's balance when the month ended waives her fee, bob's doesn't
+	twoMonthsAgo := time.Now().AddDate(0, -2, 0)
+	for accountID, amount := range map[string]int{"alice": 200000, "bob": 1000} {
+		deposit := transaction{ID: base.ID(), Timestamp: twoMonthsAgo, Lines: []transactionLine{{AccountID: accountID, Purpose: ACHCredit, Amount: amount}}}
+		if err := ledger.createTransaction(ctx, deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fees.run(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 200000, "bob": 500, "carol": -300, "income": 800})
+	if fees.lastMonth == "" {
+		t.Error("expected the month to be recorded")
+	}
+
+	// running a month again doesn't charge it twice
+	run, err := fees.chargeMonth(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Charged != 0 || len(run.Failures) != 0 {
+		t.Errorf("unexpected run: %#v", run)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"bob": 500, "income": 800})
+
+	if _, err := fees.chargeMonth(ctx, time.Now().Format("2006-01")); err == nil {
+		t.Error("expected error charging a month which hasn't ended")
+	}
+}
+
+func TestFees__idempotency(t *testing.T) {
+	accountRepo, ledger := createTestLedger(t, map[string]int{"alice": 10000, "bob": 1000, "income": 0})
+	fees, products := createTestFeeEngine(t, accountRepo, ledger)
+	checking := productSettings{TransactionFees: []feeRule{{Name: "wire", On: "wire", Amount: 2500}, {Name: "nsf", On: "nsf", Amount: 3500}}}
+	if err := checking.validate(); err != nil {
+		t.Fatal(err)
+	}
+	createTestProduct(t, products, "alice", checking)
+	createTestProduct(t, products, "bob", checking)
+	events := &mockEventPublisher{}
+	svc := &transactionService{logger: log.NewNopLogger(), repo: &feeTransactionRepository{transactionRepository: ledger, fees: fees}, events: events}
+	ctx := context.Background()
+
+	// the fees key is set by the ledger, so callers can't set it
+	reserved := createTransactionRequest{
+		Metadata: map[string]string{feesMetadataKey: "wire:alice:0"},
+		Lines:    []transactionLine{{AccountID: "alice", Purpose: ACHDebit, Amount: 1000}, {AccountID: "wires", Purpose: Wire, Amount: 1000}},
+	}
+	if _, err := svc.CreateTransaction(ctx, reserved); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Errorf("expected reserved metadata error: %v", err)
+	}
+
+	// replaying a request charged a fee returns the transaction as the first request did without charging it again
+	wire := createTransactionRequest{
+		IdempotencyKey: "wire-1",
+		Metadata:       map[string]string{"order": "1"},
+		Lines:          []transactionLine{{AccountID: "alice", Purpose: ACHDebit, Amount: 1000}, {AccountID: "wires", Purpose: Wire, Amount: 1000}},
+	}
+	first, err := svc.CreateTransaction(ctx, wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, err := svc.CreateTransaction(ctx, wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Lines) != 3 || first.Lines[0].Amount != 3500 || first.Metadata[feesMetadataKey] != "wire:alice:2500" || first.Metadata["order"] != "1" {
+		t.Errorf("unexpected transaction: %#v", first)
+	}
+	if replay.ID != first.ID || !reflect.DeepEqual(replay.Lines, first.Lines) || !reflect.DeepEqual(replay.Metadata, first.Metadata) {
+		t.Errorf("unexpected replay: %#v", replay)
+	}
+	if len(events.events) != 1 || !reflect.DeepEqual(events.events[0].Data, first) {
+		t.Errorf("unexpected events: %#v", events.events)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 6500, "income": 2500})
+
+	// reading the transaction still shows its fee
+	if posted, err := ledger.getTransaction(ctx, first.ID); err != nil || len(posted.Lines) != 3 || posted.Lines[0].Amount != 3500 {
+		t.Errorf("transaction=%#v error=%v", posted, err)
+	}
+
+	// retrying a request rejected for insufficient funds only charges its NSF fee once
+	rent := createTransactionRequest{
+		IdempotencyKey: "rent-1",
+		Lines:          []transactionLine{{AccountID: "bob", Purpose: ACHDebit, Amount: 5000}, {AccountID: "landlord", Purpose: ACHCredit, Amount: 5000}},
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := svc.CreateTransaction(ctx, rent); err == nil || !strings.Contains(err.Error(), errInsufficientFunds.Error()) {
+			t.Fatalf("expected insufficient funds: %v", err)
+		}
+	}
+	checkBalances(t, accountRepo, map[string]int32{"bob": -2500, "income": 6000})
+
+	// a different request is charged its own NSF fee
+	rent.IdempotencyKey = "rent-2"
+	if _, err := svc.CreateTransaction(ctx, rent); err == nil {
+		t.Fatal("expected insufficient funds")
+	}
+	checkBalances(t, accountRepo, map[string]int32{"bob": -6000, "income": 9500})
+}
+
+func TestFees__sql(t *testing.T) {
+	check := func(t *testing.T, db *sql.DB) {
+		accountRepo := createTestSqlAccountRepository(t, db)
+		ledger := accountRepo.transactionRepo
+		for i, id := range []string{"alice", "bob", "income", "wires", "landlord"} {
+			acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: fmt.Sprintf("%d", i), RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+			if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ctx := context.Background()
+		for id, amount := range map[string]int{"alice": 10000, "bob": 1000} {
+			deposit := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{{AccountID: id, Purpose: ACHCredit, Amount: amount}}}
+			if err := ledger.createTransaction(ctx, deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		products := &sqlProductRepository{db, log.NewNopLogger()}
+		fees := &feeEngine{
+			logger:       log.NewNopLogger(),
+			accountID:    "income",
+			rules:        &projectionRules{products: &productCatalog{repo: products}},
+			accounts:     accountRepo,
+			transactions: &transactionService{logger: log.NewNopLogger(), repo: ledger, events: &mockEventPublisher{}},
+			now:          time.Now,
+		}
+		checking := productSettings{TransactionFees: []feeRule{{Name: "wire", On: "wire", Amount: 2500}, {Name: "nsf", On: "nsf", Amount: 3500}}}
+		if err := checking.validate(); err != nil {
+			t.Fatal(err)
+		}
+		createTestProduct(t, products, "alice", checking)
+		createTestProduct(t, products, "bob", checking)
+		svc := &transactionService{logger: log.NewNopLogger(), repo: &feeTransactionRepository{transactionRepository: ledger, fees: fees}, events: &mockEventPublisher{}}
+
+		// wire fees are posted with the wire and replays don't charge them again
+		wire := createTransactionRequest{
+			IdempotencyKey: base.ID(),
+			Lines:          []transactionLine{{AccountID: "alice", Purpose: ACHDebit, Amount: 1000}, {AccountID: "wires", Purpose: Wire, Amount: 1000}},
+		}
+		first, err := svc.CreateTransaction(ctx, wire)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replay, err := svc.CreateTransaction(ctx, wire); err != nil || replay.ID != first.ID {
+			t.Errorf("replay=%#v error=%v", replay, err)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"alice": 6500, "income": 2500})
+
+		// retried debits which bounce are charged one NSF fee
+		rent := createTransactionRequest{
+			IdempotencyKey: base.ID(),
+			Lines:          []transactionLine{{AccountID: "bob", Purpose: ACHDebit, Amount: 5000}, {AccountID: "landlord", Purpose: ACHCredit, Amount: 5000}},
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := svc.CreateTransaction(ctx, rent); err == nil || !strings.Contains(err.Error(), errInsufficientFunds.Error()) {
+				t.Fatalf("expected insufficient funds: %v", err)
+			}
+		}
+		checkBalances(t, accountRepo, map[string]int32{"bob": -2500, "income": 6000})
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, sqliteDB.DB)
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, mysqlDB.DB)
+}
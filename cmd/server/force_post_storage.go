@@ -0,0 +1,164 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type forcePostRepository interface {
+	Ping() error
+	Close() error
+
+	createForcePost(post *forcePost) error
+
+	// reviewForcePost records the decision on a pending force post. It returns false when the force post
+	// isn't pending, such as when another reviewer decided first.
+	reviewForcePost(forcePostID string, status forcePostStatus, reviewedBy string, reviewedAt time.Time) (bool, error)
+
+	// reopenForcePost returns an approved force post whose transaction couldn't be posted to pending.
+	reopenForcePost(forcePostID string) error
+
+	// getForcePost returns nil if the force post doesn't exist.
+	getForcePost(forcePostID string) (*forcePost, error)
+
+	// getForcePosts returns the most recently requested force posts, newest first. An empty status returns
+	// force posts of every status.
+	getForcePosts(status forcePostStatus, limit int) ([]*forcePost, error)
+
+	// getForcePostsBetween returns force posts requested or reviewed from start until end, oldest first.
+	getForcePostsBetween(start, end time.Time) ([]*forcePost, error)
+}
+
+type sqlForcePostRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlForcePostRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlForcePostRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlForcePostRepository) createForcePost(post *forcePost) error {
+	lines, err := json.Marshal(post.Lines)
+	if err != nil {
+		return fmt.Errorf("createForcePost: force post=%s: %v", post.ID, err)
+	}
+
+	query := `insert into force_posts (force_post_id, transaction_id, transaction_lines, timestamp, reason, requested_by, requested_at, status) values (?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createForcePost: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(post.ID, post.TransactionID, string(lines), post.Timestamp, post.Reason, post.RequestedBy, post.RequestedAt, post.Status)
+	if err != nil {
+		return fmt.Errorf("createForcePost: force post=%s: %v", post.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlForcePostRepository) reviewForcePost(forcePostID string, status forcePostStatus, reviewedBy string, reviewedAt time.Time) (bool, error) {
+	query := `update force_posts set status = ?, reviewed_by = ?, reviewed_at = ? where force_post_id = ? and status = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, fmt.Errorf("reviewForcePost: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(status, reviewedBy, reviewedAt, forcePostID, forcePostPending)
+	if err != nil {
+		return false, fmt.Errorf("reviewForcePost: force post=%s: %v", forcePostID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("reviewForcePost: force post=%s: %v", forcePostID, err)
+	}
+	return n == 1, nil
+}
+
+func (r *sqlForcePostRepository) reopenForcePost(forcePostID string) error {
+	query := `update force_posts set status = ?, reviewed_by = null, reviewed_at = null where force_post_id = ? and status = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("reopenForcePost: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(forcePostPending, forcePostID, forcePostApproved); err != nil {
+		return fmt.Errorf("reopenForcePost: force post=%s: %v", forcePostID, err)
+	}
+	return nil
+}
+
+func (r *sqlForcePostRepository) getForcePost(forcePostID string) (*forcePost, error) {
+	posts, err := r.queryForcePosts(`force_post_id = ?`, forcePostID)
+	if err != nil {
+		return nil, fmt.Errorf("getForcePost: %v", err)
+	}
+	if len(posts) == 0 {
+		return nil, nil
+	}
+	return posts[0], nil
+}
+
+func (r *sqlForcePostRepository) getForcePosts(status forcePostStatus, limit int) ([]*forcePost, error) {
+	where, args := `1 = 1`, []interface{}{}
+	if status != "" {
+		where, args = `status = ?`, append(args, status)
+	}
+	posts, err := r.queryForcePosts(fmt.Sprintf(`%s order by requested_at desc limit %d`, where, limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("getForcePosts: %v", err)
+	}
+	return posts, nil
+}
+
+func (r *sqlForcePostRepository) getForcePostsBetween(start, end time.Time) ([]*forcePost, error) {
+	where := `(requested_at >= ? and requested_at < ?) or (reviewed_at >= ? and reviewed_at < ?) order by requested_at`
+	posts, err := r.queryForcePosts(where, start, end, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("getForcePostsBetween: %v", err)
+	}
+	return posts, nil
+}
+
+func (r *sqlForcePostRepository) queryForcePosts(where string, args ...interface{}) ([]*forcePost, error) {
+	query := fmt.Sprintf(`select force_post_id, transaction_id, transaction_lines, timestamp, reason, requested_by, requested_at, status, reviewed_by, reviewed_at
+from force_posts where %s;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*forcePost
+	for rows.Next() {
+		var post forcePost
+		var lines string
+		var reviewedBy *string
+		if err := rows.Scan(&post.ID, &post.TransactionID, &lines, &post.Timestamp, &post.Reason, &post.RequestedBy, &post.RequestedAt, &post.Status, &reviewedBy, &post.ReviewedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		if err := json.Unmarshal([]byte(lines), &post.Lines); err != nil {
+			return nil, fmt.Errorf("force post=%s lines: %v", post.ID, err)
+		}
+		if reviewedBy != nil {
+			post.ReviewedBy = *reviewedBy
+		}
+		out = append(out, &post)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,282 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Force posts let operators post corrections which an account's balance wouldn't otherwise allow, such as
+// clawing back funds from an overdrawn account. They're requested and approved on the admin port under dual
+// control: the approver must be someone other than the requester, identified by the X-User-ID header, and every
+// request carries a reason. Each step is logged and kept on the force post, and the posted transaction references
+// the force post with its forcePostId.
+type forcePostStatus string
+
+const (
+	forcePostPending  forcePostStatus = "pending"
+	forcePostApproved forcePostStatus = "approved"
+	forcePostRejected forcePostStatus = "rejected"
+)
+
+type forcePost struct {
+	ID string `json:"id"`
+
+	// TransactionID is the ID of the transaction posted once the force post is approved.
+	TransactionID string            `json:"transactionId"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Lines         []transactionLine `json:"lines"`
+
+	Reason      string    `json:"reason"`
+	RequestedBy string    `json:"requestedBy"`
+	RequestedAt time.Time `json:"requestedAt"`
+
+	Status     forcePostStatus `json:"status"`
+	ReviewedBy string          `json:"reviewedBy,omitempty"`
+	ReviewedAt *time.Time      `json:"reviewedAt,omitempty"`
+}
+
+func (p *forcePost) asTransaction() transaction {
+	return transaction{
+		ID:          p.TransactionID,
+		Timestamp:   p.Timestamp,
+		Status:      TransactionPosted,
+		Lines:       p.Lines,
+		ForcePostID: p.ID,
+	}
+}
+
+type createForcePostRequest struct {
+	Lines []transactionLine `json:"lines"`
+
+	// Timestamp of the posted transaction, which defaults to when the force post is requested.
+	Timestamp time.Time `json:"timestamp"`
+	Reason    string    `json:"reason"`
+}
+
+var errForcePostNotFound = errors.New("force post not found")
+
+type forcePostService struct {
+	logger       log.Logger
+	repo         forcePostRepository
+	transactions *transactionService
+}
+
+// RequestForcePost records a pending force post, which isn't posted until someone else approves it.
+func (s *forcePostService) RequestForcePost(ctx context.Context, userID string, req createForcePostRequest) (*forcePost, error) {
+	if userID == "" {
+		return nil, errors.New("force posts must be requested with an X-User-ID")
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		return nil, errors.New("force posts require a reason")
+	}
+
+	now := time.Now()
+	post := &forcePost{
+		ID:            newID(),
+		TransactionID: newID(),
+		Timestamp:     req.Timestamp,
+		Lines:         req.Lines,
+		Reason:        req.Reason,
+		RequestedBy:   userID,
+		RequestedAt:   now,
+		Status:        forcePostPending,
+	}
+	if post.Timestamp.IsZero() {
+		post.Timestamp = now
+	}
+	if err := post.asTransaction().validate(); err != nil {
+		return nil, err
+	}
+	if err := s.repo.createForcePost(post); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, post, "requested", userID)
+	return post, nil
+}
+
+// ApproveForcePost posts the force post's transaction without checking for sufficient funds. The force post is
+// marked approved first so a concurrent approval or rejection can't also decide it, and returned to pending if
+// the transaction can't be posted.
+func (s *forcePostService) ApproveForcePost(ctx context.Context, forcePostID string, userID string) (*forcePost, error) {
+	post, err := s.pending(forcePostID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if userID == post.RequestedBy {
+		return nil, fmt.Errorf("force post=%s must be approved by someone other than %s who requested it", post.ID, post.RequestedBy)
+	}
+	if err := s.review(post, forcePostApproved, userID); err != nil {
+		return nil, err
+	}
+
+	tx := post.asTransaction()
+	if err := s.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		if revertErr := s.repo.reopenForcePost(post.ID); revertErr != nil {
+			s.logger.Log("forcePosts", fmt.Sprintf("problem returning force post=%s to pending: %v", post.ID, revertErr), "requestID", requestIDFrom(ctx))
+		}
+		return nil, fmt.Errorf("force post=%s: %v", post.ID, err)
+	}
+	s.audit(ctx, post, "approved and posted", userID)
+	s.transactions.publish(ctx, tx.Status, &tx)
+	return post, nil
+}
+
+// RejectForcePost discards a pending force post. Requesters can reject their own force posts to withdraw them.
+func (s *forcePostService) RejectForcePost(ctx context.Context, forcePostID string, userID string) (*forcePost, error) {
+	post, err := s.pending(forcePostID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.review(post, forcePostRejected, userID); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, post, "rejected", userID)
+	return post, nil
+}
+
+func (s *forcePostService) pending(forcePostID string, userID string) (*forcePost, error) {
+	if userID == "" {
+		return nil, errors.New("force posts must be reviewed with an X-User-ID")
+	}
+	post, err := s.repo.getForcePost(forcePostID)
+	if err != nil {
+		return nil, err
+	}
+	if post == nil {
+		return nil, errForcePostNotFound
+	}
+	if post.Status != forcePostPending {
+		return nil, fmt.Errorf("force post=%s is already %s", post.ID, post.Status)
+	}
+	return post, nil
+}
+
+func (s *forcePostService) review(post *forcePost, status forcePostStatus, userID string) error {
+	now := time.Now()
+	ok, err := s.repo.reviewForcePost(post.ID, status, userID, now)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("force post=%s was reviewed by someone else", post.ID)
+	}
+	post.Status, post.ReviewedBy, post.ReviewedAt = status, userID, &now
+	return nil
+}
+
+// audit logs every step of a force post along with who took it and why the force post was requested.
+func (s *forcePostService) audit(ctx context.Context, post *forcePost, action string, userID string) {
+	s.logger.Log("forcePosts", fmt.Sprintf("force post=%s %s", post.ID, action), "transactionID", post.TransactionID, "userID", userID, "requestedBy", post.RequestedBy, "reason", post.Reason, "requestID", requestIDFrom(ctx))
+}
+
+// forcePosts is an admin route which lists force posts (GET), optionally with a ?status, or requests one (POST).
+func forcePosts(logger log.Logger, svc *forcePostService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			limit := 100
+			if v := r.URL.Query().Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					moovhttp.Problem(w, fmt.Errorf("invalid limit %q", v))
+					return
+				}
+				limit = n
+			}
+			posts, err := svc.repo.getForcePosts(forcePostStatus(r.URL.Query().Get("status")), limit)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if posts == nil {
+				posts = []*forcePost{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(posts)
+
+		case "POST":
+			var req createForcePostRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			post, err := svc.RequestForcePost(requestContext(r), moovhttp.GetUserID(r), req)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(post)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// getForcePost is an admin route which returns a force post and its review.
+func getForcePost(logger log.Logger, svc *forcePostService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		post, err := svc.repo.getForcePost(mux.Vars(r)["forcePostId"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if post == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(post)
+	}
+}
+
+// reviewForcePost is an admin route which approves or rejects a pending force post.
+func reviewForcePost(logger log.Logger, svc *forcePostService, status forcePostStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		review := svc.RejectForcePost
+		if status == forcePostApproved {
+			review = svc.ApproveForcePost
+		}
+		post, err := review(requestContext(r), mux.Vars(r)["forcePostId"], moovhttp.GetUserID(r))
+		if err != nil {
+			if err == errForcePostNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			logger.Log("forcePosts", fmt.Sprintf("problem reviewing force post: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(post)
+	}
+}
@@ -0,0 +1,126 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestForcePosts(t *testing.T) {
+	check := func(t *testing.T, repo *sqlForcePostRepository) {
+		accountRepo, transactionRepo := createTestLedger(t, map[string]int{"customer": 200, "corrections": 0})
+
+		svc := &forcePostService{
+			logger:       log.NewNopLogger(),
+			repo:         repo,
+			transactions: &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}},
+		}
+		router := mux.NewRouter()
+		router.HandleFunc("/transactions/force-posts", forcePosts(log.NewNopLogger(), svc))
+		router.HandleFunc("/transactions/force-posts/{forcePostId}", getForcePost(log.NewNopLogger(), svc))
+		router.HandleFunc("/transactions/force-posts/{forcePostId}/approve", reviewForcePost(log.NewNopLogger(), svc, forcePostApproved))
+		router.HandleFunc("/transactions/force-posts/{forcePostId}/reject", reviewForcePost(log.NewNopLogger(), svc, forcePostRejected))
+
+		serve := func(method, path, userID, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			if userID != "" {
+				req.Header.Set("x-user-id", userID)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			w.Flush()
+			return w
+		}
+		request := func(userID, body string) *forcePost {
+			t.Helper()
+			w := serve("POST", "/transactions/force-posts", userID, body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+			}
+			var post forcePost
+			if err := json.NewDecoder(w.Body).Decode(&post); err != nil {
+				t.Fatal(err)
+			}
+			return &post
+		}
+		correction := `{"reason": "%s", "lines": [{"accountId": "customer", "purpose": "achdebit", "amount": 500}, {"accountId": "corrections", "purpose": "achcredit", "amount": 500}]}`
+
+		// force posts need a requester, a reason and valid lines
+		if w := serve("POST", "/transactions/force-posts", "", strings.Replace(correction, "%s", "clawback", 1)); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if w := serve("POST", "/transactions/force-posts", "alice", strings.Replace(correction, "%s", " ", 1)); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if w := serve("POST", "/transactions/force-posts", "alice", `{"reason": "clawback", "lines": [{"accountId": "customer", "purpose": "achdebit", "amount": 500}]}`); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+
+		post := request("alice", strings.Replace(correction, "%s", "clawback of duplicated deposit", 1))
+		if post.Status != forcePostPending || post.RequestedBy != "alice" {
+			t.Errorf("unexpected force post: %#v", post)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"customer": 200, "corrections": 0})
+
+		// the requester can't approve their own force post
+		if w := serve("POST", "/transactions/force-posts/"+post.ID+"/approve", "alice", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if w := serve("POST", "/transactions/force-posts/"+post.ID+"/approve", "bob", ""); w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		checkBalances(t, accountRepo, map[string]int32{"customer": -300, "corrections": 500})
+
+		tx, err := transactionRepo.getTransaction(context.Background(), post.TransactionID)
+		if err != nil || tx.ForcePostID != post.ID {
+			t.Errorf("transaction=%#v error=%v", tx, err)
+		}
+		if w := serve("GET", "/transactions/force-posts/"+post.ID, "", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"reviewedBy":"bob"`) {
+			t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+
+		// decided force posts can't be reviewed again
+		if w := serve("POST", "/transactions/force-posts/"+post.ID+"/reject", "carol", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if w := serve("POST", "/transactions/force-posts/"+post.ID+"/approve", "carol", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"customer": -300})
+
+		// requesters can withdraw force posts
+		withdrawn := request("alice", strings.Replace(correction, "%s", "entered twice", 1))
+		if w := serve("POST", "/transactions/force-posts/"+withdrawn.ID+"/reject", "alice", ""); w.Code != http.StatusOK {
+			t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		if w := serve("POST", "/transactions/force-posts/missing/approve", "bob", ""); w.Code != http.StatusNotFound {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+
+		var posts []*forcePost
+		w := serve("GET", "/transactions/force-posts?status=rejected", "", "")
+		if err := json.NewDecoder(w.Body).Decode(&posts); err != nil || len(posts) != 1 || posts[0].ID != withdrawn.ID {
+			t.Errorf("posts=%#v error=%v", posts, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlForcePostRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlForcePostRepository{mysqlDB.DB, log.NewNopLogger()})
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type fraudReviewRepository interface {
+	Ping() error
+	Close() error
+
+	createReview(review *fraudReview) error
+
+	// getReview returns nil if the transaction wasn't held for review.
+	getReview(transactionID string) (*fraudReview, error)
+
+	// getReviews returns the most recently held transactions' reviews, newest first. An empty status returns
+	// reviews of every status.
+	getReviews(status fraudReviewStatus, limit int) ([]*fraudReview, error)
+
+	// decideReview records the decision on a pending review. It returns false when the review isn't pending,
+	// such as when another reviewer decided first.
+	decideReview(transactionID string, status fraudReviewStatus, reviewedBy string, reviewedAt time.Time) (bool, error)
+
+	// reopenReview returns a decided review to pending when its transaction couldn't be updated.
+	reopenReview(transactionID string) error
+}
+
+type sqlFraudReviewRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlFraudReviewRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlFraudReviewRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlFraudReviewRepository) createReview(review *fraudReview) error {
+	query := `insert into fraud_reviews (transaction_id, score, reason, status, created_at) values (?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createReview: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(review.TransactionID, review.Score, review.Reason, review.Status, review.CreatedAt); err != nil {
+		return fmt.Errorf("createReview: transaction=%s: %v", review.TransactionID, err)
+	}
+	return nil
+}
+
+func (r *sqlFraudReviewRepository) getReview(transactionID string) (*fraudReview, error) {
+	reviews, err := r.queryReviews(`transaction_id = ?`, transactionID)
+	if err != nil {
+		return nil, fmt.Errorf("getReview: %v", err)
+	}
+	if len(reviews) == 0 {
+		return nil, nil
+	}
+	return reviews[0], nil
+}
+
+func (r *sqlFraudReviewRepository) getReviews(status fraudReviewStatus, limit int) ([]*fraudReview, error) {
+	where, args := `1 = 1`, []interface{}{}
+	if status != "" {
+		where, args = `status = ?`, append(args, status)
+	}
+	reviews, err := r.queryReviews(fmt.Sprintf(`%s order by created_at desc limit %d`, where, limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("getReviews: %v", err)
+	}
+	return reviews, nil
+}
+
+func (r *sqlFraudReviewRepository) decideReview(transactionID string, status fraudReviewStatus, reviewedBy string, reviewedAt time.Time) (bool, error) {
+	query := `update fraud_reviews set status = ?, reviewed_by = ?, reviewed_at = ? where transaction_id = ? and status = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, fmt.Errorf("decideReview: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(status, reviewedBy, reviewedAt, transactionID, fraudReviewPending)
+	if err != nil {
+		return false, fmt.Errorf("decideReview: transaction=%s: %v", transactionID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("decideReview: transaction=%s: %v", transactionID, err)
+	}
+	return n == 1, nil
+}
+
+func (r *sqlFraudReviewRepository) reopenReview(transactionID string) error {
+	query := `update fraud_reviews set status = ?, reviewed_by = null, reviewed_at = null where transaction_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("reopenReview: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(fraudReviewPending, transactionID); err != nil {
+		return fmt.Errorf("reopenReview: transaction=%s: %v", transactionID, err)
+	}
+	return nil
+}
+
+func (r *sqlFraudReviewRepository) queryReviews(where string, args ...interface{}) ([]*fraudReview, error) {
+	query := fmt.Sprintf(`select transaction_id, score, reason, status, created_at, reviewed_by, reviewed_at from fraud_reviews where %s;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*fraudReview
+	for rows.Next() {
+		var review fraudReview
+		var reviewedBy *string
+		if err := rows.Scan(&review.TransactionID, &review.Score, &review.Reason, &review.Status, &review.CreatedAt, &reviewedBy, &review.ReviewedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		if reviewedBy != nil {
+			review.ReviewedBy = *reviewedBy
+		}
+		out = append(out, &review)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,373 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Fraud scoring asks an external service about each posting before it's written, which can approve it, decline it
+// or hold it for review. Scoring has a strict latency budget: when the service fails or doesn't answer within
+// FRAUD_SCORING_TIMEOUT the posting gets the FRAUD_SCORING_FALLBACK outcome instead of waiting. Transactions held for
+// review are created pending and aren't posted until an operator approves them on the admin port.
+type fraudOutcome string
+
+const (
+	fraudOutcomeApprove fraudOutcome = "approve"
+	fraudOutcomeReview  fraudOutcome = "review"
+	fraudOutcomeDecline fraudOutcome = "decline"
+
+	defaultFraudScoringTimeout = 250 * time.Millisecond
+)
+
+func (o fraudOutcome) validate() error {
+	switch o {
+	case fraudOutcomeApprove, fraudOutcomeReview, fraudOutcomeDecline:
+		return nil
+	default:
+		return fmt.Errorf("unknown fraud outcome %q", o)
+	}
+}
+
+var errTransactionDeclined = errors.New("transaction declined")
+
+// fraudDecision is the scoring service's response for a transaction.
+type fraudDecision struct {
+	Outcome fraudOutcome `json:"outcome"`
+	Score   float64      `json:"score"`
+	Reason  string       `json:"reason"`
+}
+
+// fraudScoreRequest is the transaction sent to the scoring service, along with the travel notices of its debited
+// accounts which cover its day.
+type fraudScoreRequest struct {
+	transaction
+
+	TravelNotices []*travelNotice `json:"travelNotices,omitempty"`
+}
+
+type fraudScorer interface {
+	score(ctx context.Context, req fraudScoreRequest) (*fraudDecision, error)
+}
+
+// httpFraudScorer POSTs each transaction as JSON to a scoring service, which responds with a fraudDecision.
+type httpFraudScorer struct {
+	client   *http.Client
+	endpoint string
+}
+
+func (s *httpFraudScorer) score(ctx context.Context, score fraudScoreRequest) (*fraudDecision, error) {
+	body, err := json.Marshal(score)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if requestID := requestIDFrom(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scoring service returned %s", resp.Status)
+	}
+	var decision fraudDecision
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("reading decision: %v", err)
+	}
+	decision.Outcome = fraudOutcome(strings.ToLower(string(decision.Outcome)))
+	if err := decision.Outcome.validate(); err != nil {
+		return nil, err
+	}
+	return &decision, nil
+}
+
+// fraudRule scores transactions with a line of Purpose (or any purpose when empty) for at least MinAmount cents.
+type fraudRule struct {
+	Purpose   TransactionPurpose
+	MinAmount int
+}
+
+// readFraudScoringRules reads FRAUD_SCORING_RULES, comma separated rules formatted as purpose:minAmount where
+// purpose can be * for every purpose. Every transaction is scored without rules.
+func readFraudScoringRules() ([]fraudRule, error) {
+	var rules []fraudRule
+	for _, v := range strings.Split(os.Getenv("FRAUD_SCORING_RULES"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		parts := strings.Split(v, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid FRAUD_SCORING_RULES rule %q, expected purpose:minAmount", v)
+		}
+		var rule fraudRule
+		if parts[0] != "*" {
+			rule.Purpose = TransactionPurpose(strings.ToLower(parts[0]))
+			if err := rule.Purpose.validate(); err != nil {
+				return nil, fmt.Errorf("invalid FRAUD_SCORING_RULES rule %q: %v", v, err)
+			}
+		}
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid FRAUD_SCORING_RULES rule %q: amount must be at least zero", v)
+		}
+		rule.MinAmount = n
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// fraudScreen decides which postings are scored and holds those the scoring service wants reviewed.
+type fraudScreen struct {
+	logger   log.Logger
+	scorer   fraudScorer
+	rules    []fraudRule
+	timeout  time.Duration
+	fallback fraudOutcome
+	reviews  fraudReviewRepository
+
+	// travel holds the travel notices sent along with transactions, when set
+	travel travelNoticeRepository
+}
+
+// setupFraudScreen returns a fraudScreen for the scoring service at FRAUD_SCORING_URL, or nil when it isn't set.
+func setupFraudScreen(logger log.Logger, reviews fraudReviewRepository, travel travelNoticeRepository) (*fraudScreen, error) {
+	endpoint := os.Getenv("FRAUD_SCORING_URL")
+	if endpoint == "" {
+		return nil, nil
+	}
+	timeout := defaultFraudScoringTimeout
+	if v := os.Getenv("FRAUD_SCORING_TIMEOUT"); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil || dur <= 0 {
+			return nil, fmt.Errorf("invalid FRAUD_SCORING_TIMEOUT %q", v)
+		}
+		timeout = dur
+	}
+	fallback := fraudOutcome(strings.ToLower(or(os.Getenv("FRAUD_SCORING_FALLBACK"), string(fraudOutcomeApprove))))
+	if err := fallback.validate(); err != nil {
+		return nil, fmt.Errorf("invalid FRAUD_SCORING_FALLBACK: %v", err)
+	}
+	rules, err := readFraudScoringRules()
+	if err != nil {
+		return nil, err
+	}
+	return &fraudScreen{
+		logger:   logger,
+		scorer:   &httpFraudScorer{client: &http.Client{Timeout: timeout}, endpoint: endpoint},
+		rules:    rules,
+		timeout:  timeout,
+		fallback: fallback,
+		reviews:  reviews,
+		travel:   travel,
+	}, nil
+}
+
+func (f *fraudScreen) applies(tx transaction) bool {
+	if len(f.rules) == 0 {
+		return true
+	}
+	for _, rule := range f.rules {
+		for i := range tx.Lines {
+			if (rule.Purpose == "" || rule.Purpose == tx.Lines[i].Purpose) && tx.Lines[i].Amount >= rule.MinAmount {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// screen scores tx if a rule applies to it, returning nil for transactions which aren't scored. Transactions the
+// scoring service fails on, or doesn't answer for in time, get the fallback outcome.
+func (f *fraudScreen) screen(ctx context.Context, tx transaction) *fraudDecision {
+	if f == nil || !f.applies(tx) {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	req := fraudScoreRequest{transaction: tx}
+	if f.travel != nil {
+		notices, err := activeTravelNotices(f.travel, tx)
+		if err != nil {
+			f.logger.Log("fraud", fmt.Sprintf("problem reading travel notices for transaction=%s: %v", tx.ID, err), "requestID", requestIDFrom(ctx))
+		}
+		req.TravelNotices = notices
+	}
+
+	start := time.Now()
+	decision, err := f.scorer.score(ctx, req)
+	if err != nil {
+		f.logger.Log("fraud", fmt.Sprintf("problem scoring transaction=%s after %v, using %s: %v", tx.ID, time.Since(start), f.fallback, err), "requestID", requestIDFrom(ctx))
+		return &fraudDecision{Outcome: f.fallback, Reason: "scoring service unavailable"}
+	}
+	return decision
+}
+
+// hold records that tx was created pending for review.
+func (f *fraudScreen) hold(tx transaction, decision *fraudDecision) error {
+	return f.reviews.createReview(&fraudReview{
+		TransactionID: tx.ID,
+		Score:         decision.Score,
+		Reason:        decision.Reason,
+		Status:        fraudReviewPending,
+		CreatedAt:     time.Now(),
+	})
+}
+
+// checkReviewed returns an error for transactions held for review which an operator hasn't approved.
+func (f *fraudScreen) checkReviewed(transactionID string) error {
+	if f == nil {
+		return nil
+	}
+	review, err := f.reviews.getReview(transactionID)
+	if err != nil {
+		return err
+	}
+	if review != nil && review.Status != fraudReviewApproved {
+		return fmt.Errorf("transaction=%s is held for fraud review", transactionID)
+	}
+	return nil
+}
+
+type fraudReviewStatus string
+
+const (
+	fraudReviewPending  fraudReviewStatus = "pending"
+	fraudReviewApproved fraudReviewStatus = "approved"
+	fraudReviewDeclined fraudReviewStatus = "declined"
+)
+
+// fraudReview is a transaction held pending until an operator approves (posts) or declines (fails) it.
+type fraudReview struct {
+	TransactionID string            `json:"transactionId"`
+	Score         float64           `json:"score"`
+	Reason        string            `json:"reason"`
+	Status        fraudReviewStatus `json:"status"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	ReviewedBy    string            `json:"reviewedBy,omitempty"`
+	ReviewedAt    *time.Time        `json:"reviewedAt,omitempty"`
+}
+
+var errFraudReviewNotFound = errors.New("fraud review not found")
+
+// ReviewTransaction posts (approved) or fails (declined) a transaction held for review. The review is decided
+// first so a concurrent reviewer can't also decide it, and returned to pending if the transaction can't be updated.
+func (s *transactionService) ReviewTransaction(ctx context.Context, transactionID string, userID string, status fraudReviewStatus) (*fraudReview, error) {
+	if s.fraud == nil {
+		return nil, errFraudReviewNotFound
+	}
+	if userID == "" {
+		return nil, errors.New("fraud reviews must be decided with an X-User-ID")
+	}
+	review, err := s.fraud.reviews.getReview(transactionID)
+	if err != nil {
+		return nil, err
+	}
+	if review == nil {
+		return nil, errFraudReviewNotFound
+	}
+	if review.Status != fraudReviewPending {
+		return nil, fmt.Errorf("transaction=%s was already %s", transactionID, review.Status)
+	}
+	now := time.Now()
+	if ok, err := s.fraud.reviews.decideReview(transactionID, status, userID, now); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("transaction=%s was reviewed by someone else", transactionID)
+	}
+	review.Status, review.ReviewedBy, review.ReviewedAt = status, userID, &now
+
+	next := TransactionPosted
+	if status == fraudReviewDeclined {
+		next = TransactionFailed
+	}
+	if err := s.repo.updateTransactionStatus(transactionID, next); err != nil {
+		if revertErr := s.fraud.reviews.reopenReview(transactionID); revertErr != nil {
+			s.logger.Log("fraud", fmt.Sprintf("problem returning review of transaction=%s to pending: %v", transactionID, revertErr), "requestID", requestIDFrom(ctx))
+		}
+		return nil, fmt.Errorf("transaction=%s: %v", transactionID, err)
+	}
+	s.logger.Log("fraud", fmt.Sprintf("transaction=%s was %s by %s", transactionID, status, userID), "requestID", requestIDFrom(ctx))
+	if tx, err := s.repo.getTransaction(ctx, transactionID); err == nil {
+		s.publish(ctx, tx.Status, tx)
+	}
+	return review, nil
+}
+
+// getFraudReviews is an admin route which lists transactions held for review, optionally with a ?status.
+func getFraudReviews(logger log.Logger, svc *transactionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				moovhttp.Problem(w, fmt.Errorf("invalid limit %q", v))
+				return
+			}
+			limit = n
+		}
+		reviews, err := svc.fraud.reviews.getReviews(fraudReviewStatus(r.URL.Query().Get("status")), limit)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if reviews == nil {
+			reviews = []*fraudReview{}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(reviews)
+	}
+}
+
+// reviewFraudHold is an admin route which approves or declines a transaction held for review.
+func reviewFraudHold(logger log.Logger, svc *transactionService, status fraudReviewStatus) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		review, err := svc.ReviewTransaction(requestContext(r), mux.Vars(r)["transactionId"], moovhttp.GetUserID(r), status)
+		if err != nil {
+			if err == errFraudReviewNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(review)
+	}
+}
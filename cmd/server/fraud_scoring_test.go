@@ -0,0 +1,205 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestFraudScoring__rules(t *testing.T) {
+	defer os.Unsetenv("FRAUD_SCORING_RULES")
+
+	os.Setenv("FRAUD_SCORING_RULES", "achdebit:10000, *:500000")
+	rules, err := readFraudScoringRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	screen := &fraudScreen{rules: rules}
+	cases := []struct {
+		purpose TransactionPurpose
+		amount  int
+		scored  bool
+	}{
+		{ACHDebit, 10000, true},
+		{ACHDebit, 9999, false},
+		{Wire, 9999, false},
+		{Wire, 500000, true},
+	}
+	for i := range cases {
+		tx := transaction{Lines: []transactionLine{{AccountID: "a", Purpose: cases[i].purpose, Amount: cases[i].amount}}}
+		if scored := screen.applies(tx); scored != cases[i].scored {
+			t.Errorf("%s of %d: scored=%v", cases[i].purpose, cases[i].amount, scored)
+		}
+	}
+
+	for _, v := range []string{"achdebit", "bogus:100", "wire:-1", "wire:lots"} {
+		os.Setenv("FRAUD_SCORING_RULES", v)
+		if _, err := readFraudScoringRules(); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
+
+func TestFraudScoring__scorer(t *testing.T) {
+	var outcome string
+	delay := time.Duration(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tx transaction
+		if err := json.NewDecoder(r.Body).Decode(&tx); err != nil || len(tx.Lines) == 0 {
+			t.Errorf("transaction=%#v error=%v", tx, err)
+		}
+		time.Sleep(delay)
+		w.Write([]byte(`{"outcome": "` + outcome + `", "score": 87.5, "reason": "velocity"}`))
+	}))
+	defer server.Close()
+
+	screen := &fraudScreen{
+		logger:   log.NewNopLogger(),
+		scorer:   &httpFraudScorer{client: server.Client(), endpoint: server.URL},
+		timeout:  50 * time.Millisecond,
+		fallback: fraudOutcomeReview,
+	}
+	tx := transaction{ID: "tx", Lines: []transactionLine{{AccountID: "a", Purpose: ACHDebit, Amount: 100}}}
+
+	outcome = "Decline"
+	if decision := screen.screen(context.Background(), tx); decision == nil || decision.Outcome != fraudOutcomeDecline || decision.Score != 87.5 || decision.Reason != "velocity" {
+		t.Errorf("unexpected decision: %#v", decision)
+	}
+
+	// unknown outcomes and slow responses get the fallback
+	outcome = "maybe"
+	if decision := screen.screen(context.Background(), tx); decision == nil || decision.Outcome != fraudOutcomeReview {
+		t.Errorf("unexpected decision: %#v", decision)
+	}
+	outcome, delay = "approve", 200*time.Millisecond
+	start := time.Now()
+	if decision := screen.screen(context.Background(), tx); decision == nil || decision.Outcome != fraudOutcomeReview {
+		t.Errorf("unexpected decision: %#v", decision)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("scoring took %v", elapsed)
+	}
+}
+
+type testFraudScorer struct {
+	decision *fraudDecision
+}
+
+func (s *testFraudScorer) score(ctx context.Context, req fraudScoreRequest) (*fraudDecision, error) {
+	return s.decision, nil
+}
+
+func TestFraudScoring__outcomes(t *testing.T) {
+	check := func(t *testing.T, repo *sqlFraudReviewRepository) {
+		accountRepo, transactionRepo := createTestLedger(t, map[string]int{"customer": 1000, "merchant": 0})
+
+		scorer := &testFraudScorer{}
+		svc := &transactionService{
+			logger: log.NewNopLogger(),
+			repo:   transactionRepo,
+			events: &mockEventPublisher{},
+			fraud: &fraudScreen{
+				logger:  log.NewNopLogger(),
+				scorer:  scorer,
+				timeout: time.Second,
+				reviews: repo,
+			},
+		}
+		router := mux.NewRouter()
+		router.HandleFunc("/transactions/fraud-reviews", getFraudReviews(log.NewNopLogger(), svc))
+		router.HandleFunc("/transactions/fraud-reviews/{transactionId}/approve", reviewFraudHold(log.NewNopLogger(), svc, fraudReviewApproved))
+		router.HandleFunc("/transactions/fraud-reviews/{transactionId}/decline", reviewFraudHold(log.NewNopLogger(), svc, fraudReviewDeclined))
+		serve := func(method, path, userID string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, nil)
+			if userID != "" {
+				req.Header.Set("x-user-id", userID)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			w.Flush()
+			return w
+		}
+		req := createTransactionRequest{
+			Lines: []transactionLine{
+				{AccountID: "customer", Purpose: ACHDebit, Amount: 100},
+				{AccountID: "merchant", Purpose: ACHCredit, Amount: 100},
+			},
+		}
+
+		// declined transactions aren't created
+		scorer.decision = &fraudDecision{Outcome: fraudOutcomeDecline, Score: 99, Reason: "stolen card"}
+		if _, err := svc.CreateTransaction(context.Background(), req); err == nil || !strings.Contains(err.Error(), "transaction declined: stolen card") {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"customer": 1000, "merchant": 0})
+
+		// reviewed transactions are pending until they're approved
+		scorer.decision = &fraudDecision{Outcome: fraudOutcomeReview, Score: 60, Reason: "new counterparty"}
+		held, err := svc.CreateTransaction(context.Background(), req)
+		if err != nil || held.Status != TransactionPending {
+			t.Fatalf("transaction=%#v error=%v", held, err)
+		}
+		if _, err := svc.UpdateTransactionStatus(context.Background(), held.ID, TransactionPosted); err == nil || !strings.Contains(err.Error(), "held for fraud review") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		w := serve("GET", "/transactions/fraud-reviews?status=pending", "")
+		var reviews []*fraudReview
+		if err := json.NewDecoder(w.Body).Decode(&reviews); err != nil || len(reviews) != 1 || reviews[0].TransactionID != held.ID || reviews[0].Reason != "new counterparty" {
+			t.Fatalf("reviews=%#v error=%v", reviews, err)
+		}
+		if w := serve("POST", "/transactions/fraud-reviews/"+held.ID+"/approve", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status without a user: %d", w.Code)
+		}
+		if w := serve("POST", "/transactions/fraud-reviews/"+held.ID+"/approve", "alice"); w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		checkBalances(t, accountRepo, map[string]int32{"customer": 900, "merchant": 100})
+		if w := serve("POST", "/transactions/fraud-reviews/"+held.ID+"/decline", "bob"); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status deciding twice: %d", w.Code)
+		}
+
+		// declined reviews fail the transaction
+		held, err = svc.CreateTransaction(context.Background(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := serve("POST", "/transactions/fraud-reviews/"+held.ID+"/decline", "bob"); w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		if tx, err := svc.GetTransaction(context.Background(), held.ID); err != nil || tx.Status != TransactionFailed {
+			t.Errorf("transaction=%#v error=%v", tx, err)
+		}
+		if w := serve("POST", "/transactions/fraud-reviews/missing/approve", "bob"); w.Code != http.StatusNotFound {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+
+		// approved transactions post as usual
+		scorer.decision = &fraudDecision{Outcome: fraudOutcomeApprove}
+		if tx, err := svc.CreateTransaction(context.Background(), req); err != nil || tx.Status != TransactionPosted {
+			t.Errorf("transaction=%#v error=%v", tx, err)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"customer": 800, "merchant": 200})
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlFraudReviewRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlFraudReviewRepository{mysqlDB.DB, log.NewNopLogger()})
+}
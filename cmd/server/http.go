@@ -32,6 +32,13 @@ func wrapResponseWriter(logger log.Logger, w http.ResponseWriter, r *http.Reques
 	return moovhttp.EnsureHeaders(logger, routeHistogram.With("route", route), inmemIdempotentRecorder, w, r)
 }
 
+// wrapIdempotentResponseWriter is wrapResponseWriter for routes which persist X-Idempotency-Key themselves and answer
+// replays with their original result, rather than having the in-memory recorder reject them.
+func wrapIdempotentResponseWriter(logger log.Logger, w http.ResponseWriter, r *http.Request) (http.ResponseWriter, error) {
+	route := fmt.Sprintf("%s-%s", strings.ToLower(r.Method), cleanMetricsPath(r.URL.Path))
+	return moovhttp.EnsureHeaders(logger, routeHistogram.With("route", route), nil, w, r)
+}
+
 var baseIdRegex = regexp.MustCompile(`([a-f0-9]{40})`)
 
 // cleanMetricsPath takes a URL path and formats it for Prometheus metrics
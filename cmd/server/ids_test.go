@@ -0,0 +1,51 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestReadIDGenerator(t *testing.T) {
+	defer os.Unsetenv("ID_GENERATOR")
+	defer os.Unsetenv("ID_GENERATOR_SEED")
+
+	os.Setenv("ID_GENERATOR", "sequential")
+	gen, err := readIDGenerator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := gen(); id != "0000000000000000000000000000000000000001" {
+		t.Errorf("unexpected ID: %s", id)
+	}
+	if id := gen(); id != "0000000000000000000000000000000000000002" {
+		t.Errorf("unexpected ID: %s", id)
+	}
+
+	os.Setenv("ID_GENERATOR", "seeded")
+	os.Setenv("ID_GENERATOR_SEED", "42")
+	first, _ := readIDGenerator()
+	second, _ := readIDGenerator()
+	for i := 0; i < 3; i++ {
+		a, b := first(), second()
+		if a != b || !baseIdRegex.MatchString(a) {
+			t.Errorf("seeded IDs differ: %s vs %s", a, b)
+		}
+	}
+
+	os.Setenv("ID_GENERATOR_SEED", "forty-two")
+	if _, err := readIDGenerator(); err == nil {
+		t.Error("expected error")
+	}
+	os.Setenv("ID_GENERATOR", "uuid")
+	if _, err := readIDGenerator(); err == nil {
+		t.Error("expected error")
+	}
+	os.Setenv("ID_GENERATOR", "")
+	if gen, err := readIDGenerator(); err != nil || gen() == gen() {
+		t.Errorf("expected random IDs: %v", err)
+	}
+}
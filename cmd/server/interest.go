@@ -0,0 +1,379 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Interest accrues daily on the end-of-day balance of each account, at the annual percentage yield (APY) of the
+// interest rate tier its balance reaches. Tiers are read from the account's product, or INTEREST_RATE_TIERS without
+// one, and days end at midnight in the account's time zone. Accruals are kept in fractions of a cent and paid once
+// their month ends, as an Interest transaction from INTEREST_EXPENSE_ACCOUNT_ID for the month's accruals rounded to
+// the nearest cent.
+
+var (
+	errInterestAlreadyAccrued = errors.New("interest already accrued")
+	errInterestAlreadyPosted  = errors.New("interest already posted")
+)
+
+// interestBatchSize is how many accounts are read at once while accruing interest
+var interestBatchSize = 100
+
+// dailyRate is the rate which, compounded every day for a year, earns apy.
+func dailyRate(apy float64) float64 {
+	return math.Pow(1+apy, 1.0/365) - 1
+}
+
+// interestAccrual is the interest an account earned on its balance at the end of a day.
+type interestAccrual struct {
+	AccountID string  `json:"accountId"`
+	Day       string  `json:"day"` // YYYY-MM-DD
+	Balance   int64   `json:"balance"`
+	APY       float64 `json:"apy"`
+
+	// Amount is in fractions of a cent, which are rounded once the month's interest is posted
+	Amount    float64   `json:"amount"`
+	AccruedAt time.Time `json:"accruedAt"`
+}
+
+// interestAccrualDay records that interest accrued for every account on Day.
+type interestAccrualDay struct {
+	Day        string    `json:"day"`
+	Accounts   int       `json:"accounts"`
+	Accrued    float64   `json:"accrued"`
+	FinishedAt time.Time `json:"finishedAt"`
+}
+
+// interestPosting is the interest paid to an account for the accruals of a month.
+type interestPosting struct {
+	ID            string    `json:"id"`
+	AccountID     string    `json:"accountId"`
+	Month         string    `json:"month"` // YYYY-MM
+	Amount        int       `json:"amount"`
+	TransactionID string    `json:"transactionId"`
+	PostedAt      time.Time `json:"postedAt"`
+}
+
+func (p *interestPosting) transaction(expenseAccountID string) transaction {
+	return transaction{
+		ID:          p.TransactionID,
+		Timestamp:   p.PostedAt,
+		Status:      TransactionPosted,
+		Description: fmt.Sprintf("Interest for %s", p.Month),
+		Metadata:    map[string]string{"interestPostingId": p.ID, "month": p.Month},
+		Lines: []transactionLine{
+			{AccountID: expenseAccountID, Purpose: ACHDebit, Amount: p.Amount},
+			{AccountID: p.AccountID, Purpose: Interest, Amount: p.Amount},
+		},
+	}
+}
+
+// interestPostingRun summarizes the interest posted for a month.
+type interestPostingRun struct {
+	Month    string   `json:"month"`
+	Posted   int      `json:"posted"`
+	Amount   int64    `json:"amount"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+// accountInterest is an account's daily accruals and posted interest for a month.
+type accountInterest struct {
+	AccountID string             `json:"accountId"`
+	Month     string             `json:"month"`
+	Accrued   float64            `json:"accrued"`
+	Accruals  []*interestAccrual `json:"accruals"`
+	Posting   *interestPosting   `json:"posting,omitempty"`
+}
+
+type interestService struct {
+	logger           log.Logger
+	repo             interestRepository
+	accounts         accountRepository
+	transactions     *transactionService
+	expenseAccountID string
+
+	// rules are the interest rate tiers of each account, which come from its product when it has one
+	rules *projectionRules
+
+	// now is the time interest accrues and posts at, which in sandbox mode is the virtual clock
+	now func() time.Time
+}
+
+// accrueDay accrues a day (YYYY-MM-DD of day) of interest on the closing balance of every open account, at midnight
+// in the account's time zone. Accounts which already accrued for the day are skipped, so an interrupted day can be
+// accrued again.
+func (s *interestService) accrueDay(ctx context.Context, day time.Time) (*interestAccrualDay, error) {
+	out := &interestAccrualDay{Day: day.Format("2006-01-02")}
+	after := ""
+	for {
+		accts, err := s.accounts.ListAccounts(after, interestBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(accts) == 0 {
+			break
+		}
+		for _, acct := range accts {
+			after = acct.ID
+			if acct.ID == s.expenseAccountID || accountClosed(acct) {
+				continue
+			}
+			a, err := s.accrue(ctx, acct.ID, acct.CreatedAt, day)
+			if err != nil {
+				return nil, fmt.Errorf("account=%s: %v", acct.ID, err)
+			}
+			if a != nil {
+				out.Accounts++
+				out.Accrued += a.Amount
+			}
+		}
+	}
+	out.FinishedAt = s.now()
+	if err := s.repo.finishDay(out); err != nil {
+		return nil, err
+	}
+	s.logger.Log("interest", fmt.Sprintf("accrued %.2f of interest for %d accounts on %s", out.Accrued, out.Accounts, out.Day))
+	return out, nil
+}
+
+// accrue saves the interest an account earned on its balance at the end of day in its time zone. It returns nil
+// when the account didn't exist yet, didn't earn interest or already accrued it.
+func (s *interestService) accrue(ctx context.Context, accountID string, createdAt time.Time, day time.Time) (*interestAccrual, error) {
+	settings, err := s.rules.products.settingsOf(accountID)
+	if err != nil {
+		return nil, err
+	}
+	rules := s.rules
+	if settings != nil {
+		rules = &projectionRules{tiers: settings.InterestRateTiers}
+	}
+	end := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, settings.location()).AddDate(0, 0, 1)
+	if !createdAt.Before(end) {
+		return nil, nil
+	}
+	balance, err := balanceAt(ctx, s.transactions.repo, accountID, end)
+	if err != nil {
+		return nil, err
+	}
+	apy := rules.rate(balance)
+	if balance <= 0 || apy <= 0 {
+		return nil, nil
+	}
+	a := &interestAccrual{
+		AccountID: accountID,
+		Day:       day.Format("2006-01-02"),
+		Balance:   balance,
+		APY:       apy,
+		Amount:    float64(balance) * dailyRate(apy),
+		AccruedAt: s.now(),
+	}
+	if err := s.repo.createAccrual(a); err != nil {
+		if err == errInterestAlreadyAccrued {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+// postMonth pays every account the interest it accrued over the days of a month (YYYY-MM, by default last month) in
+// its time zone, once the month has ended in every time zone. Postings are saved before they're posted so a month is
+// never paid twice, and removed again if they can't be posted so they're retried.
+func (s *interestService) postMonth(ctx context.Context, month string) (*interestPostingRun, error) {
+	start, _, err := statementCycle(month, s.now(), lastTimeZone)
+	if err != nil {
+		return nil, err
+	}
+	month = start.Format("2006-01")
+	accrued, err := s.repo.getMonthAccruals(month)
+	if err != nil {
+		return nil, err
+	}
+	accountIDs := make([]string, 0, len(accrued))
+	for id := range accrued {
+		accountIDs = append(accountIDs, id)
+	}
+	sort.Strings(accountIDs)
+
+	run := &interestPostingRun{Month: month}
+	for _, accountID := range accountIDs {
+		amount := int(math.Round(accrued[accountID]))
+		if amount <= 0 {
+			continue
+		}
+		p := &interestPosting{
+			ID:            newID(),
+			AccountID:     accountID,
+			Month:         month,
+			Amount:        amount,
+			TransactionID: newID(),
+			PostedAt:      s.now(),
+		}
+		if err := s.post(ctx, p); err != nil {
+			if err == errInterestAlreadyPosted {
+				continue
+			}
+			s.logger.Log("interest", fmt.Sprintf("problem posting %s interest to account=%s: %v", month, accountID, err), "requestID", requestIDFrom(ctx))
+			run.Failures = append(run.Failures, fmt.Sprintf("account=%s: %v", accountID, err))
+			continue
+		}
+		run.Posted++
+		run.Amount += int64(amount)
+	}
+	s.logger.Log("interest", fmt.Sprintf("posted %d of %s interest to %d accounts", run.Amount, month, run.Posted), "requestID", requestIDFrom(ctx))
+	return run, nil
+}
+
+func (s *interestService) post(ctx context.Context, p *interestPosting) error {
+	if err := s.repo.createPosting(p); err != nil {
+		return err
+	}
+	tx := p.transaction(s.expenseAccountID)
+	if err := s.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		if deleteErr := s.repo.deletePosting(p.ID); deleteErr != nil {
+			s.logger.Log("interest", fmt.Sprintf("problem removing posting=%s: %v", p.ID, deleteErr), "requestID", requestIDFrom(ctx))
+		}
+		return err
+	}
+	s.transactions.publish(ctx, tx.Status, &tx)
+	return nil
+}
+
+// run accrues interest for each day which ended in every time zone since the last accrued day, or for the latest
+// such day on the first run, and posts each month's interest once its last day has accrued. It returns the months
+// posted.
+func (s *interestService) run(ctx context.Context, now time.Time) ([]*interestPostingRun, error) {
+	now = now.In(lastTimeZone)
+	yesterday := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1)
+	day := yesterday
+	last, err := s.repo.lastAccruedDay()
+	if err != nil {
+		return nil, err
+	}
+	if last != "" {
+		t, err := time.Parse("2006-01-02", last)
+		if err != nil {
+			return nil, fmt.Errorf("last accrued day %q: %v", last, err)
+		}
+		day = t.AddDate(0, 0, 1)
+	}
+	var runs []*interestPostingRun
+	for ; !day.After(yesterday); day = day.AddDate(0, 0, 1) {
+		if _, err := s.accrueDay(ctx, day); err != nil {
+			return runs, fmt.Errorf("accruing %s: %v", day.Format("2006-01-02"), err)
+		}
+		if day.AddDate(0, 0, 1).Day() == 1 {
+			run, err := s.postMonth(ctx, day.Format("2006-01"))
+			if err != nil {
+				return runs, fmt.Errorf("posting %s: %v", day.Format("2006-01"), err)
+			}
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+// interest returns an account's accruals and posted interest for a month (YYYY-MM).
+func (s *interestService) interest(ctx context.Context, accountID, month string) (*accountInterest, error) {
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return nil, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+	accts, err := s.accounts.GetAccounts(ctx, []string{accountID})
+	if err != nil {
+		return nil, err
+	}
+	if len(accts) != 1 {
+		return nil, errAccountNotFound
+	}
+	out := &accountInterest{AccountID: accountID, Month: month, Accruals: []*interestAccrual{}}
+	accruals, err := s.repo.getAccruals(accountID, month)
+	if err != nil {
+		return nil, err
+	}
+	for i := range accruals {
+		out.Accrued += accruals[i].Amount
+		out.Accruals = append(out.Accruals, accruals[i])
+	}
+	if out.Posting, err = s.repo.getPosting(accountID, month); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func setupInterestJob(ctx context.Context, logger log.Logger, svc *interestService, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if _, err := svc.run(ctx, svc.now()); err != nil {
+					logger.Log("interest", fmt.Sprintf("problem accruing interest: %v", err))
+				}
+			}
+		}
+	}()
+}
+
+// getAccountInterest is an admin route which returns an account's accruals and posted interest for ?month=YYYY-MM,
+// which defaults to the current month in LEDGER_TIME_ZONE.
+func getAccountInterest(logger log.Logger, svc *interestService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		month := r.URL.Query().Get("month")
+		if month == "" {
+			month = svc.now().In(ledgerLocation).Format("2006-01")
+		}
+		out, err := svc.interest(requestContext(r), mux.Vars(r)["accountId"], month)
+		if err != nil {
+			if err == errAccountNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+// postInterest is an admin route which posts the interest accrued over ?month=YYYY-MM (by default last month), such
+// as to retry accounts which failed. Accounts already paid for the month are skipped.
+func postInterest(logger log.Logger, svc *interestService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		run, err := svc.postMonth(requestContext(r), r.URL.Query().Get("month"))
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(run)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+type interestRepository interface {
+	Ping() error
+	Close() error
+
+	// createAccrual returns errInterestAlreadyAccrued if the account already accrued interest for the day.
+	createAccrual(a *interestAccrual) error
+
+	// getAccruals returns the account's accruals for the days of a month (YYYY-MM), oldest first.
+	getAccruals(accountID, month string) ([]*interestAccrual, error)
+
+	// getMonthAccruals sums the interest accrued by each account over the days of a month (YYYY-MM).
+	getMonthAccruals(month string) (map[string]float64, error)
+
+	// finishDay records that interest accrued for every account on day (YYYY-MM-DD).
+	finishDay(d *interestAccrualDay) error
+
+	// lastAccruedDay returns the most recent day (YYYY-MM-DD) interest accrued for, or empty if none have.
+	lastAccruedDay() (string, error)
+
+	// createPosting returns errInterestAlreadyPosted if the account's interest was already posted for the month.
+	createPosting(p *interestPosting) error
+
+	// deletePosting removes a posting which couldn't be posted.
+	deletePosting(postingID string) error
+
+	// getPosting returns nil if the account's interest wasn't posted for the month.
+	getPosting(accountID, month string) (*interestPosting, error)
+}
+
+type sqlInterestRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlInterestRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlInterestRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlInterestRepository) createAccrual(a *interestAccrual) error {
+	query := `insert into interest_accruals (account_id, day, balance, apy, amount, accrued_at) values (?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createAccrual: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(a.AccountID, a.Day, a.Balance, a.APY, a.Amount, a.AccruedAt); err != nil {
+		if database.UniqueViolation(err) {
+			return errInterestAlreadyAccrued
+		}
+		return fmt.Errorf("createAccrual: account=%s day=%s: %v", a.AccountID, a.Day, err)
+	}
+	return nil
+}
+
+func (r *sqlInterestRepository) getAccruals(accountID, month string) ([]*interestAccrual, error) {
+	query := `select account_id, day, balance, apy, amount, accrued_at from interest_accruals where account_id = ? and day like ? order by day;`
+	rows, err := r.db.Query(query, accountID, month+"-%")
+	if err != nil {
+		return nil, fmt.Errorf("getAccruals: account=%s month=%s: %v", accountID, month, err)
+	}
+	defer rows.Close()
+
+	var out []*interestAccrual
+	for rows.Next() {
+		var a interestAccrual
+		if err := rows.Scan(&a.AccountID, &a.Day, &a.Balance, &a.APY, &a.Amount, &a.AccruedAt); err != nil {
+			return nil, fmt.Errorf("getAccruals: scan: %v", err)
+		}
+		out = append(out, &a)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlInterestRepository) getMonthAccruals(month string) (map[string]float64, error) {
+	rows, err := r.db.Query(`select account_id, sum(amount) from interest_accruals where day like ? group by account_id;`, month+"-%")
+	if err != nil {
+		return nil, fmt.Errorf("getMonthAccruals: month=%s: %v", month, err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]float64)
+	for rows.Next() {
+		var accountID string
+		var amount float64
+		if err := rows.Scan(&accountID, &amount); err != nil {
+			return nil, fmt.Errorf("getMonthAccruals: scan: %v", err)
+		}
+		out[accountID] = amount
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlInterestRepository) finishDay(d *interestAccrualDay) error {
+	query := `replace into interest_accrual_days (day, accounts, accrued, finished_at) values (?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("finishDay: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(d.Day, d.Accounts, d.Accrued, d.FinishedAt); err != nil {
+		return fmt.Errorf("finishDay: day=%s: %v", d.Day, err)
+	}
+	return nil
+}
+
+func (r *sqlInterestRepository) lastAccruedDay() (string, error) {
+	var day sql.NullString
+	if err := r.db.QueryRow(`select max(day) from interest_accrual_days;`).Scan(&day); err != nil {
+		return "", fmt.Errorf("lastAccruedDay: %v", err)
+	}
+	return day.String, nil
+}
+
+func (r *sqlInterestRepository) createPosting(p *interestPosting) error {
+	query := `insert into interest_postings (posting_id, account_id, month, amount, transaction_id, posted_at) values (?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createPosting: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(p.ID, p.AccountID, p.Month, p.Amount, p.TransactionID, p.PostedAt); err != nil {
+		if database.UniqueViolation(err) {
+			return errInterestAlreadyPosted
+		}
+		return fmt.Errorf("createPosting: account=%s month=%s: %v", p.AccountID, p.Month, err)
+	}
+	return nil
+}
+
+func (r *sqlInterestRepository) deletePosting(postingID string) error {
+	if _, err := r.db.Exec(`delete from interest_postings where posting_id = ?;`, postingID); err != nil {
+		return fmt.Errorf("deletePosting: posting=%s: %v", postingID, err)
+	}
+	return nil
+}
+
+func (r *sqlInterestRepository) getPosting(accountID, month string) (*interestPosting, error) {
+	query := `select posting_id, account_id, month, amount, transaction_id, posted_at from interest_postings where account_id = ? and month = ?;`
+	var p interestPosting
+	err := r.db.QueryRow(query, accountID, month).Scan(&p.ID, &p.AccountID, &p.Month, &p.Amount, &p.TransactionID, &p.PostedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getPosting: account=%s month=%s: %v", accountID, month, err)
+	}
+	return &p, nil
+}
@@ -0,0 +1,206 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/moov-io/base"
+)
+
+func TestInterest__dailyRate(t *testing.T) {
+	apy := 0.02
+	if v := math.Pow(1+dailyRate(apy), 365) - 1; math.Abs(v-apy) > 1e-12 {
+		t.Errorf("compounded daily rate earns %v", v)
+	}
+	if dailyRate(0) != 0 {
+		t.Errorf("zero APY accrued %v", dailyRate(0))
+	}
+}
+
+func TestInterestRepository(t *testing.T) {
+	check := func(t *testing.T, repo *sqlInterestRepository) {
+		now := time.Now().Truncate(time.Second)
+		for _, day := range []string{"2020-05-30", "2020-05-31", "2020-06-01"} {
+			if err := repo.createAccrual(&interestAccrual{AccountID: "alice", Day: day, Balance: 100000, APY: 0.02, Amount: 5.4, AccruedAt: now}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := repo.createAccrual(&interestAccrual{AccountID: "alice", Day: "2020-05-31", AccruedAt: now}); err != errInterestAlreadyAccrued {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if accruals, err := repo.getAccruals("alice", "2020-05"); err != nil || len(accruals) != 2 || accruals[0].Day != "2020-05-30" || accruals[1].Amount != 5.4 {
+			t.Errorf("accruals=%#v error=%v", accruals, err)
+		}
+		if totals, err := repo.getMonthAccruals("2020-05"); err != nil || len(totals) != 1 || math.Abs(totals["alice"]-10.8) > 1e-9 {
+			t.Errorf("totals=%#v error=%v", totals, err)
+		}
+
+		if day, err := repo.lastAccruedDay(); err != nil || day != "" {
+			t.Errorf("day=%q error=%v", day, err)
+		}
+		for _, day := range []string{"2020-05-31", "2020-05-30"} {
+			if err := repo.finishDay(&interestAccrualDay{Day: day, Accounts: 1, Accrued: 5.4, FinishedAt: now}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if day, err := repo.lastAccruedDay(); err != nil || day != "2020-05-31" {
+			t.Errorf("day=%q error=%v", day, err)
+		}
+
+		posting := &interestPosting{ID: base.ID(), AccountID: "alice", Month: "2020-05", Amount: 11, TransactionID: base.ID(), PostedAt: now}
+		if err := repo.createPosting(posting); err != nil {
+			t.Fatal(err)
+		}
+		again := *posting
+		again.ID = base.ID()
+		if err := repo.createPosting(&again); err != errInterestAlreadyPosted {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if p, err := repo.getPosting("alice", "2020-05"); err != nil || p == nil || p.ID != posting.ID || p.Amount != 11 {
+			t.Errorf("posting=%#v error=%v", p, err)
+		}
+		if err := repo.deletePosting(posting.ID); err != nil {
+			t.Fatal(err)
+		}
+		if p, err := repo.getPosting("alice", "2020-05"); err != nil || p != nil {
+			t.Errorf("posting=%#v error=%v", p, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlInterestRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlInterestRepository{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestInterest(t *testing.T) {
+	ctx := context.Background()
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"expense": 0, "bob": 0, "carol": 0, "dave": 0})
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	products := &sqlProductRepository{db.DB, log.NewNopLogger()}
+
+	svc := &interestService{
+		logger:           log.NewNopLogger(),
+		repo:             &sqlInterestRepository{db.DB, log.NewNopLogger()},
+		accounts:         accountRepo,
+		transactions:     &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}},
+		expenseAccountID: "expense",
+		rules:            &projectionRules{tiers: []interestTier{{MinBalance: 0, Rate: 0.0365}}, products: &productCatalog{repo: products}},
+		now:              time.Now,
+	}
+
+	// carol's product pays more on larger balances and her days end in Los Angeles, while dave's product pays nothing
+	createTestProduct(t, products, "carol", productSettings{
+		InterestRateTiers: []interestTier{{MinBalance: 0, Rate: 0.01}, {MinBalance: 1500000, Rate: 0.0365}},
+		TimeZone:          "America/Los_Angeles",
+	})
+	createTestProduct(t, products, "dave", productSettings{InterestRateTiers: []interestTier{}})
+
+	// balances (in dollars) are 10,000 before last month and 20,000 from its last day, which for carol is the evening
+	// of the last day in Los Angeles but already the next month in UTC
+	now := time.Now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)
+	lastDay := monthStart.AddDate(0, 1, -1)
+	deposits := map[string][]time.Time{
+		"bob":   {monthStart.AddDate(0, 0, -1), lastDay.Add(12 * time.Hour)},
+		"carol": {monthStart.AddDate(0, 0, -1), lastDay.Add(27 * time.Hour)},
+		"dave":  {monthStart.AddDate(0, 0, -1), lastDay.Add(12 * time.Hour)},
+	}
+	for accountID, times := range deposits {
+		for _, at := range times {
+			deposit := transaction{ID: base.ID(), Timestamp: at, Lines: []transactionLine{{AccountID: accountID, Purpose: ACHCredit, Amount: 1000000}}}
+			if err := transactionRepo.createTransaction(ctx, deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for _, day := range []time.Time{monthStart, lastDay, lastDay} { // accruing a day again is skipped
+		if _, err := svc.accrueDay(ctx, day); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := svc.interest(ctx, "bob", monthStart.Format("2006-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Accruals) != 2 || out.Accruals[0].Balance != 1000000 || out.Accruals[1].Balance != 2000000 {
+		t.Fatalf("accruals=%#v", out.Accruals)
+	}
+	expected := 3000000 * dailyRate(0.0365)
+	if math.Abs(out.Accrued-expected) > 1e-9 || out.Posting != nil {
+		t.Errorf("accrued=%v expected %v posting=%#v", out.Accrued, expected, out.Posting)
+	}
+	out, err = svc.interest(ctx, "carol", monthStart.Format("2006-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Accruals) != 2 || out.Accruals[0].APY != 0.01 || out.Accruals[1].Balance != 2000000 || out.Accruals[1].APY != 0.0365 {
+		t.Fatalf("accruals=%#v", out.Accruals)
+	}
+	carolExpected := 1000000*dailyRate(0.01) + 2000000*dailyRate(0.0365)
+	if math.Abs(out.Accrued-carolExpected) > 1e-9 {
+		t.Errorf("accrued=%v expected %v", out.Accrued, carolExpected)
+	}
+	if out, err := svc.interest(ctx, "dave", monthStart.Format("2006-01")); err != nil || len(out.Accruals) != 0 {
+		t.Errorf("dave accrued interest=%#v error=%v", out, err)
+	}
+
+	// the month's interest is posted once, rounded to the cent
+	run, err := svc.postMonth(ctx, monthStart.Format("2006-01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	amount, carolAmount := int(math.Round(expected)), int(math.Round(carolExpected))
+	if run.Posted != 2 || run.Amount != int64(amount+carolAmount) || len(run.Failures) != 0 {
+		t.Errorf("run=%#v", run)
+	}
+	if run, err := svc.postMonth(ctx, monthStart.Format("2006-01")); err != nil || run.Posted != 0 {
+		t.Errorf("run=%#v error=%v", run, err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"bob": int32(2000000 + amount), "carol": int32(2000000 + carolAmount), "expense": int32(-amount - carolAmount)})
+
+	out, err = svc.interest(ctx, "bob", monthStart.Format("2006-01"))
+	if err != nil || out.Posting == nil || out.Posting.Amount != amount {
+		t.Fatalf("interest=%#v error=%v", out, err)
+	}
+	tx, err := transactionRepo.getTransaction(ctx, out.Posting.TransactionID)
+	if err != nil || tx == nil || tx.Lines[1].Purpose != Interest {
+		t.Errorf("transaction=%#v error=%v", tx, err)
+	}
+
+	// this month can't be posted until it ends
+	if _, err := svc.postMonth(ctx, now.Format("2006-01")); err == nil {
+		t.Error("expected error")
+	}
+
+	// run accrues every day since the last one accrued, through the last day to end in every time zone
+	if _, err := svc.run(ctx, now); err != nil {
+		t.Fatal(err)
+	}
+	last := now.In(lastTimeZone).AddDate(0, 0, -1).Format("2006-01-02")
+	if last < lastDay.Format("2006-01-02") { // this month's first day hasn't ended everywhere yet
+		last = lastDay.Format("2006-01-02")
+	}
+	if day, err := svc.repo.lastAccruedDay(); err != nil || day != last {
+		t.Errorf("day=%q error=%v", day, err)
+	}
+	if out, err := svc.interest(ctx, "expense", monthStart.Format("2006-01")); err != nil || len(out.Accruals) != 0 {
+		t.Errorf("expense account accrued interest=%#v error=%v", out, err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// journalImportClient imports spreadsheets through the admin server of a running instance, which is how -import.journal
+// migrates an existing ledger. Entries go through the same checks and posting as imports previewed by operators.
+type journalImportClient struct {
+	adminURL string
+	userID   string
+	client   *http.Client
+}
+
+func newJournalImportClient(adminURL, userID string) *journalImportClient {
+	if !strings.Contains(adminURL, "://") {
+		adminURL = "http://" + adminURL
+	}
+	return &journalImportClient{
+		adminURL: strings.TrimSuffix(adminURL, "/"),
+		userID:   userID,
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// dryRun checks the spreadsheet at path and returns the report of its problems.
+func (c *journalImportClient) dryRun(ctx context.Context, path string) (*journalImportReport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report journalImportReport
+	if err := c.post(ctx, "/transactions/imports?dryRun=true", data, &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+// importJournal previews the spreadsheet at path and then posts its entries in batches of batchSize. An import
+// which fails part way can be finished by posting it again from the admin server.
+func (c *journalImportClient) importJournal(ctx context.Context, path string, batchSize int) (*journalImport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var imp journalImport
+	query := url.Values{"filename": []string{filepath.Base(path)}}
+	if err := c.post(ctx, "/transactions/imports?"+query.Encode(), data, &imp); err != nil {
+		return nil, err
+	}
+	var posted journalImport
+	if err := c.post(ctx, fmt.Sprintf("/transactions/imports/%s/post?batchSize=%d", imp.ID, batchSize), nil, &posted); err != nil {
+		return nil, fmt.Errorf("import=%s: %v", imp.ID, err)
+	}
+	return &posted, nil
+}
+
+func (c *journalImportClient) post(ctx context.Context, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequest("POST", c.adminURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("X-User-Id", c.userID)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var problem struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&problem); err != nil || problem.Error == "" {
+			return fmt.Errorf("POST %s: HTTP status %d", path, resp.StatusCode)
+		}
+		return errors.New(problem.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
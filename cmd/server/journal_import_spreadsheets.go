@@ -0,0 +1,211 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// zipMagic starts every XLSX file, which are zip archives of XML parts.
+var zipMagic = []byte("PK\x03\x04")
+
+// readSpreadsheet returns the rows of a CSV file or the first sheet of an XLSX workbook.
+func readSpreadsheet(data []byte) ([][]string, error) {
+	if bytes.HasPrefix(data, zipMagic) {
+		return readXLSX(data)
+	}
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading CSV: %v", err)
+	}
+	return rows, nil
+}
+
+// readXLSX returns the rows of the first sheet in an XLSX workbook. Only cell values are read, so formulas
+// are returned as their last calculated value. Empty cells between values are returned as empty strings.
+func readXLSX(data []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("reading XLSX: %v", err)
+	}
+	parts := make(map[string]*zip.File)
+	for _, f := range archive.File {
+		parts[f.Name] = f
+	}
+
+	sheet, err := xlsxFirstSheet(parts)
+	if err != nil {
+		return nil, fmt.Errorf("reading XLSX: %v", err)
+	}
+	var strs []string
+	if f, ok := parts["xl/sharedStrings.xml"]; ok {
+		if strs, err = xlsxSharedStrings(f); err != nil {
+			return nil, fmt.Errorf("reading XLSX: %v", err)
+		}
+	}
+
+	var ws struct {
+		Rows []struct {
+			Cells []struct {
+				Ref    string  `xml:"r,attr"`
+				Type   string  `xml:"t,attr"`
+				Value  string  `xml:"v"`
+				Inline xlsxStr `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := decodeXLSXPart(sheet, &ws); err != nil {
+		return nil, fmt.Errorf("reading XLSX: %v", err)
+	}
+
+	var rows [][]string
+	for _, r := range ws.Rows {
+		var row []string
+		for _, c := range r.Cells {
+			col := len(row)
+			if c.Ref != "" {
+				if col, err = xlsxColumn(c.Ref); err != nil {
+					return nil, fmt.Errorf("reading XLSX: %v", err)
+				}
+			}
+			for len(row) <= col {
+				row = append(row, "")
+			}
+			switch c.Type {
+			case "s":
+				i, err := strconv.Atoi(c.Value)
+				if err != nil || i < 0 || i >= len(strs) {
+					return nil, fmt.Errorf("reading XLSX: cell %s has unknown shared string %q", c.Ref, c.Value)
+				}
+				row[col] = strs[i]
+			case "inlineStr":
+				row[col] = c.Inline.text()
+			default:
+				row[col] = c.Value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// xlsxStr is a rich text string, which is either plain text or a list of formatted runs.
+type xlsxStr struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (s xlsxStr) text() string {
+	if len(s.Runs) == 0 {
+		return s.Text
+	}
+	var buf strings.Builder
+	for i := range s.Runs {
+		buf.WriteString(s.Runs[i].Text)
+	}
+	return buf.String()
+}
+
+func xlsxSharedStrings(f *zip.File) ([]string, error) {
+	var sst struct {
+		Items []xlsxStr `xml:"si"`
+	}
+	if err := decodeXLSXPart(f, &sst); err != nil {
+		return nil, err
+	}
+	out := make([]string, len(sst.Items))
+	for i := range sst.Items {
+		out[i] = sst.Items[i].text()
+	}
+	return out, nil
+}
+
+// xlsxFirstSheet finds the worksheet part of the workbook's first sheet through the workbook's relationships.
+func xlsxFirstSheet(parts map[string]*zip.File) (*zip.File, error) {
+	var workbook struct {
+		Sheets []struct {
+			RelID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	wb, ok := parts["xl/workbook.xml"]
+	if !ok {
+		return nil, errors.New("missing xl/workbook.xml")
+	}
+	if err := decodeXLSXPart(wb, &workbook); err != nil {
+		return nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return nil, errors.New("workbook has no sheets")
+	}
+	if f, ok := parts["xl/_rels/workbook.xml.rels"]; ok {
+		if err := decodeXLSXPart(f, &rels); err != nil {
+			return nil, err
+		}
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].RelID {
+			continue
+		}
+		name := path.Join("xl", rel.Target)
+		if strings.HasPrefix(rel.Target, "/") {
+			name = strings.TrimPrefix(rel.Target, "/")
+		}
+		if f, ok := parts[name]; ok {
+			return f, nil
+		}
+	}
+	if f, ok := parts["xl/worksheets/sheet1.xml"]; ok {
+		return f, nil
+	}
+	return nil, errors.New("first sheet not found")
+}
+
+func decodeXLSXPart(f *zip.File, v interface{}) error {
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%s: %v", f.Name, err)
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(io.LimitReader(rc, maxJournalImportSize)).Decode(v); err != nil {
+		return fmt.Errorf("%s: %v", f.Name, err)
+	}
+	return nil
+}
+
+// xlsxColumn returns the zero-based column of a cell reference such as C12.
+func xlsxColumn(ref string) (int, error) {
+	col := 0
+	for i := 0; i < len(ref); i++ {
+		c := ref[i]
+		if c < 'A' || c > 'Z' {
+			if i == 0 {
+				break
+			}
+			return col - 1, nil
+		}
+		col = col*26 + int(c-'A') + 1
+	}
+	return 0, fmt.Errorf("invalid cell reference %q", ref)
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type journalImportRepository interface {
+	Ping() error
+	Close() error
+
+	createImport(imp *journalImport) error
+
+	// markImportPosted saves the posted status and transaction IDs of an import's entries.
+	markImportPosted(imp *journalImport) error
+
+	// saveImportProgress saves the transaction IDs of the entries posted so far.
+	saveImportProgress(imp *journalImport) error
+
+	// getImport returns nil if the import doesn't exist.
+	getImport(importID string) (*journalImport, error)
+
+	// getImports returns the most recently uploaded imports, newest first.
+	getImports(limit int) ([]*journalImport, error)
+
+	// getImportsBetween returns imports uploaded or posted from start until end, oldest first.
+	getImportsBetween(start, end time.Time) ([]*journalImport, error)
+}
+
+type sqlJournalImportRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlJournalImportRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlJournalImportRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlJournalImportRepository) createImport(imp *journalImport) error {
+	entries, err := json.Marshal(imp.Entries)
+	if err != nil {
+		return fmt.Errorf("createImport: import=%s: %v", imp.ID, err)
+	}
+	balances, err := json.Marshal(imp.Balances)
+	if err != nil {
+		return fmt.Errorf("createImport: import=%s: %v", imp.ID, err)
+	}
+
+	query := `insert into journal_imports (import_id, filename, entries, balances, status, created_by, created_at) values (?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createImport: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(imp.ID, imp.Filename, string(entries), string(balances), imp.Status, imp.CreatedBy, imp.CreatedAt); err != nil {
+		return fmt.Errorf("createImport: import=%s: %v", imp.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlJournalImportRepository) markImportPosted(imp *journalImport) error {
+	entries, err := json.Marshal(imp.Entries)
+	if err != nil {
+		return fmt.Errorf("markImportPosted: import=%s: %v", imp.ID, err)
+	}
+
+	query := `update journal_imports set entries = ?, status = ?, posted_by = ?, posted_at = ? where import_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("markImportPosted: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(string(entries), journalImportPosted, imp.PostedBy, imp.PostedAt, imp.ID); err != nil {
+		return fmt.Errorf("markImportPosted: import=%s: %v", imp.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlJournalImportRepository) saveImportProgress(imp *journalImport) error {
+	entries, err := json.Marshal(imp.Entries)
+	if err != nil {
+		return fmt.Errorf("saveImportProgress: import=%s: %v", imp.ID, err)
+	}
+	if _, err := r.db.Exec(`update journal_imports set entries = ? where import_id = ?;`, string(entries), imp.ID); err != nil {
+		return fmt.Errorf("saveImportProgress: import=%s: %v", imp.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlJournalImportRepository) getImport(importID string) (*journalImport, error) {
+	imports, err := r.queryImports(`import_id = ?`, importID)
+	if err != nil {
+		return nil, fmt.Errorf("getImport: %v", err)
+	}
+	if len(imports) == 0 {
+		return nil, nil
+	}
+	return imports[0], nil
+}
+
+func (r *sqlJournalImportRepository) getImports(limit int) ([]*journalImport, error) {
+	imports, err := r.queryImports(fmt.Sprintf(`1 = 1 order by created_at desc limit %d`, limit))
+	if err != nil {
+		return nil, fmt.Errorf("getImports: %v", err)
+	}
+	return imports, nil
+}
+
+func (r *sqlJournalImportRepository) getImportsBetween(start, end time.Time) ([]*journalImport, error) {
+	where := `(created_at >= ? and created_at < ?) or (posted_at >= ? and posted_at < ?) order by created_at`
+	imports, err := r.queryImports(where, start, end, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("getImportsBetween: %v", err)
+	}
+	return imports, nil
+}
+
+func (r *sqlJournalImportRepository) queryImports(where string, args ...interface{}) ([]*journalImport, error) {
+	query := fmt.Sprintf(`select import_id, filename, entries, balances, status, created_by, created_at, posted_by, posted_at
+from journal_imports where %s;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*journalImport
+	for rows.Next() {
+		var imp journalImport
+		var entries, balances string
+		var postedBy *string
+		if err := rows.Scan(&imp.ID, &imp.Filename, &entries, &balances, &imp.Status, &imp.CreatedBy, &imp.CreatedAt, &postedBy, &imp.PostedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		if err := json.Unmarshal([]byte(entries), &imp.Entries); err != nil {
+			return nil, fmt.Errorf("import=%s entries: %v", imp.ID, err)
+		}
+		if err := json.Unmarshal([]byte(balances), &imp.Balances); err != nil {
+			return nil, fmt.Errorf("import=%s balances: %v", imp.ID, err)
+		}
+		if postedBy != nil {
+			imp.PostedBy = *postedBy
+		}
+		out = append(out, &imp)
+	}
+	return out, rows.Err()
+}
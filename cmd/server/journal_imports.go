@@ -0,0 +1,520 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Journal imports post a spreadsheet (CSV or XLSX) of journal lines from the admin port. Uploading a spreadsheet
+// validates every line and entry and saves a preview of the balances each account would end up with. Nothing is
+// posted until the import is confirmed, which posts one transaction per entry.
+//
+// Spreadsheets have a header row naming the entry, accountId, purpose and amount columns (in any order) and optionally
+// the department, product and region segments and a description. Lines with the same entry are posted together as one transaction, so
+// their debits and credits must balance. An optional timestamp column backdates entries, such as when migrating the
+// history of another ledger.
+type journalImportStatus string
+
+const (
+	journalImportPreviewed journalImportStatus = "previewed"
+	journalImportPosted    journalImportStatus = "posted"
+)
+
+// maxJournalImportSize is the largest spreadsheet accepted, in bytes.
+const maxJournalImportSize = 10 * 1024 * 1024
+
+var errJournalImportNotFound = errors.New("journal import not found")
+
+type journalImport struct {
+	ID       string              `json:"id"`
+	Filename string              `json:"filename,omitempty"`
+	Status   journalImportStatus `json:"status"`
+
+	Entries  []*journalEntry        `json:"entries"`
+	Balances []journalImportBalance `json:"balances"`
+
+	CreatedBy string     `json:"createdBy"`
+	CreatedAt time.Time  `json:"createdAt"`
+	PostedBy  string     `json:"postedBy,omitempty"`
+	PostedAt  *time.Time `json:"postedAt,omitempty"`
+}
+
+// journalEntry is the lines of one entry in a spreadsheet, which are posted as a transaction.
+type journalEntry struct {
+	Entry string            `json:"entry"`
+	Lines []transactionLine `json:"lines"`
+
+	// Timestamp is when the entry is posted at, otherwise it's posted when the import is.
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+
+	// TransactionID is set once the entry is posted.
+	TransactionID string `json:"transactionId,omitempty"`
+}
+
+// journalImportBalance previews how an import changes an account's balance.
+type journalImportBalance struct {
+	AccountID        string `json:"accountId"`
+	Balance          int    `json:"balance"`
+	ResultingBalance int    `json:"resultingBalance"`
+}
+
+// journalImportErrors are the problems found in a spreadsheet, so they can all be fixed at once.
+type journalImportErrors []string
+
+func (errs journalImportErrors) Error() string {
+	return fmt.Sprintf("%d problems with journal import: %s", len(errs), strings.Join(errs, "; "))
+}
+
+// maxJournalImportErrors limits how many problems are returned for a spreadsheet.
+const maxJournalImportErrors = 50
+
+var journalImportColumns = []string{"entry", "accountid", "purpose", "amount"}
+
+// parseJournalEntries groups spreadsheet rows into entries, in the order each entry first appears, and checks that
+// every line is valid and every entry balances.
+func parseJournalEntries(rows [][]string) ([]*journalEntry, error) {
+	// Skip blank rows before the header
+	for len(rows) > 0 && blankRow(rows[0]) {
+		rows = rows[1:]
+	}
+	if len(rows) == 0 {
+		return nil, errors.New("spreadsheet is empty")
+	}
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		name = strings.ToLower(strings.NewReplacer(" ", "", "_", "").Replace(strings.TrimSpace(name)))
+		columns[name] = i
+	}
+	for _, name := range journalImportColumns {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("spreadsheet has no %s column, the header row needs entry, accountId, purpose and amount", name)
+		}
+	}
+
+	var errs journalImportErrors
+	var entries []*journalEntry
+	byName := make(map[string]*journalEntry)
+	for i, row := range rows[1:] {
+		if blankRow(row) {
+			continue
+		}
+		rowNum := i + 2 // one-based and after the header
+		cell := func(name string) string {
+			if n, ok := columns[name]; ok && n < len(row) {
+				return strings.TrimSpace(row[n])
+			}
+			return ""
+		}
+		name := cell("entry")
+		if name == "" {
+			errs = append(errs, fmt.Sprintf("row %d: missing entry", rowNum))
+			continue
+		}
+		line := transactionLine{
+			AccountID:       configuredSystemAccounts.resolve(cell("accountid")),
+			Purpose:         TransactionPurpose(strings.ToLower(cell("purpose"))),
+			Department:      cell("department"),
+			Product:         cell("product"),
+			Region:          cell("region"),
+			MCC:             cell("mcc"),
+			MerchantCountry: cell("merchantcountry"),
+			Description:     cell("description"),
+		}
+		amount, err := strconv.Atoi(cell("amount"))
+		if err != nil || amount <= 0 {
+			errs = append(errs, fmt.Sprintf("row %d: amount %q isn't a positive number of cents", rowNum, cell("amount")))
+			continue
+		}
+		line.Amount = amount
+		if err := line.validate(); err != nil {
+			errs = append(errs, fmt.Sprintf("row %d: %v", rowNum, err))
+			continue
+		}
+
+		var timestamp *time.Time
+		if v := cell("timestamp"); v != "" {
+			t, err := parseJournalTimestamp(v)
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("row %d: %v", rowNum, err))
+				continue
+			}
+			timestamp = &t
+		}
+
+		entry, ok := byName[name]
+		if !ok {
+			entry = &journalEntry{Entry: name, Timestamp: timestamp}
+			byName[name] = entry
+			entries = append(entries, entry)
+		} else if (entry.Timestamp == nil) != (timestamp == nil) || (timestamp != nil && !timestamp.Equal(*entry.Timestamp)) {
+			errs = append(errs, fmt.Sprintf("row %d: entry %s has lines with different timestamps", rowNum, name))
+			continue
+		}
+		entry.Lines = append(entry.Lines, line)
+	}
+	for _, entry := range entries {
+		sum := 0
+		for i := range entry.Lines {
+			sum += lineAmount(entry.Lines[i])
+		}
+		if sum != 0 {
+			errs = append(errs, fmt.Sprintf("entry %s doesn't balance, its lines sum to %d", entry.Entry, sum))
+		}
+	}
+	if len(errs) > maxJournalImportErrors {
+		errs = append(errs[:maxJournalImportErrors], fmt.Sprintf("and %d more", len(errs)-maxJournalImportErrors))
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("spreadsheet has no journal lines")
+	}
+	return entries, nil
+}
+
+// parseJournalTimestamp reads an entry's timestamp as an RFC 3339 timestamp or a YYYY-MM-DD day (in UTC), which
+// can't be in the future.
+func parseJournalTimestamp(v string) (time.Time, error) {
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		if t, err = time.Parse(time.RFC3339, v); err != nil {
+			return t, fmt.Errorf("timestamp %q isn't YYYY-MM-DD or an RFC 3339 timestamp", v)
+		}
+	}
+	if t.After(time.Now()) {
+		return t, fmt.Errorf("timestamp %q is in the future", v)
+	}
+	return t, nil
+}
+
+func blankRow(row []string) bool {
+	for i := range row {
+		if strings.TrimSpace(row[i]) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+type journalImportService struct {
+	logger       log.Logger
+	repo         journalImportRepository
+	accounts     accountRepository
+	transactions *transactionService
+}
+
+// PreviewImport validates a spreadsheet and saves it with the balances every account would have once it's posted.
+func (s *journalImportService) PreviewImport(ctx context.Context, userID string, filename string, data []byte) (*journalImport, error) {
+	if userID == "" {
+		return nil, errors.New("journal imports must be uploaded with an X-User-ID")
+	}
+	entries, balances, err := s.checkImport(data)
+	if err != nil {
+		return nil, err
+	}
+	imp := &journalImport{
+		ID:        newID(),
+		Filename:  filename,
+		Status:    journalImportPreviewed,
+		Entries:   entries,
+		Balances:  balances,
+		CreatedBy: userID,
+		CreatedAt: time.Now(),
+	}
+	if err := s.repo.createImport(imp); err != nil {
+		return nil, err
+	}
+	s.logger.Log("journalImports", fmt.Sprintf("previewed journal import=%s with %d entries", imp.ID, len(imp.Entries)), "userID", userID, "requestID", requestIDFrom(ctx))
+	return imp, nil
+}
+
+// checkImport reads and validates a spreadsheet, returning its entries along with the balances of every account they
+// post to. Problems with the spreadsheet are returned as journalImportErrors.
+func (s *journalImportService) checkImport(data []byte) ([]*journalEntry, []journalImportBalance, error) {
+	rows, err := readSpreadsheet(data)
+	if err != nil {
+		return nil, nil, journalImportErrors{err.Error()}
+	}
+	entries, err := parseJournalEntries(rows)
+	if err != nil {
+		if _, ok := err.(journalImportErrors); !ok {
+			err = journalImportErrors{err.Error()}
+		}
+		return nil, nil, err
+	}
+	balances, err := s.previewBalances(entries)
+	if err != nil {
+		return nil, nil, err
+	}
+	return entries, balances, nil
+}
+
+// journalImportReport is the result of checking a spreadsheet in a dry run, which saves and posts nothing.
+type journalImportReport struct {
+	Valid    bool                   `json:"valid"`
+	Entries  int                    `json:"entries"`
+	Lines    int                    `json:"lines"`
+	Problems []string               `json:"problems,omitempty"`
+	Balances []journalImportBalance `json:"balances,omitempty"`
+}
+
+// DryRunImport checks a spreadsheet as PreviewImport does and reports every problem found, without saving it.
+func (s *journalImportService) DryRunImport(ctx context.Context, data []byte) (*journalImportReport, error) {
+	entries, balances, err := s.checkImport(data)
+	if err != nil {
+		if errs, ok := err.(journalImportErrors); ok {
+			return &journalImportReport{Problems: errs}, nil
+		}
+		return nil, err
+	}
+	report := &journalImportReport{Valid: true, Entries: len(entries), Balances: balances}
+	for i := range entries {
+		report.Lines += len(entries[i].Lines)
+	}
+	return report, nil
+}
+
+// previewBalances returns the current and resulting balance of every account in entries, ordered by account ID.
+// Accounts which don't exist are returned as journalImportErrors.
+func (s *journalImportService) previewBalances(entries []*journalEntry) ([]journalImportBalance, error) {
+	changes := make(map[string]int)
+	for _, entry := range entries {
+		for i := range entry.Lines {
+			changes[entry.Lines[i].AccountID] += lineAmount(entry.Lines[i])
+		}
+	}
+	accountIDs := make([]string, 0, len(changes))
+	for accountID := range changes {
+		accountIDs = append(accountIDs, accountID)
+	}
+	sort.Strings(accountIDs)
+
+	accounts, err := s.accounts.GetAccounts(context.Background(), accountIDs)
+	if err != nil {
+		return nil, fmt.Errorf("previewing balances: %v", err)
+	}
+	current := make(map[string]int)
+	for _, acct := range accounts {
+		current[acct.ID] = int(acct.Balance)
+	}
+	var errs journalImportErrors
+	for _, accountID := range accountIDs {
+		if _, ok := current[accountID]; !ok {
+			errs = append(errs, fmt.Sprintf("account %s doesn't exist", accountID))
+		}
+	}
+	if len(errs) > maxJournalImportErrors {
+		errs = append(errs[:maxJournalImportErrors], fmt.Sprintf("and %d more", len(errs)-maxJournalImportErrors))
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	out := make([]journalImportBalance, len(accountIDs))
+	for i, accountID := range accountIDs {
+		out[i] = journalImportBalance{
+			AccountID:        accountID,
+			Balance:          current[accountID],
+			ResultingBalance: current[accountID] + changes[accountID],
+		}
+	}
+	return out, nil
+}
+
+// defaultJournalImportBatchSize is how many entries are posted between saving an import's progress.
+const defaultJournalImportBatchSize = 100
+
+// PostImport posts each entry of a previewed import as a transaction, saving which entries were posted after every
+// batch of entries. Entries are posted with an idempotency key derived from the import, so posting an import again
+// after a failure (or concurrently) only posts the entries which weren't posted before.
+func (s *journalImportService) PostImport(ctx context.Context, importID string, userID string, batchSize int) (*journalImport, error) {
+	if userID == "" {
+		return nil, errors.New("journal imports must be posted with an X-User-ID")
+	}
+	imp, err := s.repo.getImport(importID)
+	if err != nil {
+		return nil, err
+	}
+	if imp == nil {
+		return nil, errJournalImportNotFound
+	}
+	if imp.Status != journalImportPreviewed {
+		return nil, fmt.Errorf("journal import=%s is already %s", imp.ID, imp.Status)
+	}
+
+	if batchSize <= 0 {
+		batchSize = defaultJournalImportBatchSize
+	}
+	for i, entry := range imp.Entries {
+		if entry.TransactionID == "" {
+			req := createTransactionRequest{
+				Lines:          entry.Lines,
+				IdempotencyKey: fmt.Sprintf("journal-import-%s-%d", imp.ID, i),
+			}
+			if entry.Timestamp != nil {
+				req.Timestamp = *entry.Timestamp
+			}
+			tx, err := s.transactions.CreateTransaction(ctx, req)
+			if err != nil {
+				if i > 0 {
+					if err := s.repo.saveImportProgress(imp); err != nil {
+						s.logger.Log("journalImports", fmt.Sprintf("problem saving journal import=%s progress: %v", imp.ID, err))
+					}
+				}
+				return nil, fmt.Errorf("journal import=%s entry %s: %v", imp.ID, entry.Entry, err)
+			}
+			entry.TransactionID = tx.ID
+		}
+		if n := i + 1; n%batchSize == 0 && n < len(imp.Entries) {
+			if err := s.repo.saveImportProgress(imp); err != nil {
+				return nil, err
+			}
+			s.logger.Log("journalImports", fmt.Sprintf("posted %d of %d entries of journal import=%s", n, len(imp.Entries), imp.ID), "requestID", requestIDFrom(ctx))
+		}
+	}
+
+	now := time.Now()
+	imp.Status, imp.PostedBy, imp.PostedAt = journalImportPosted, userID, &now
+	if err := s.repo.markImportPosted(imp); err != nil {
+		return nil, err
+	}
+	s.logger.Log("journalImports", fmt.Sprintf("posted journal import=%s with %d entries", imp.ID, len(imp.Entries)), "userID", userID, "requestID", requestIDFrom(ctx))
+	return imp, nil
+}
+
+// journalImports is an admin route which lists recent journal imports (GET) or previews a spreadsheet (POST). The
+// request body is the CSV or XLSX file and ?filename is kept with the import. With ?dryRun=true the spreadsheet is
+// only checked and a report of its problems is returned.
+func journalImports(logger log.Logger, svc *journalImportService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			limit := 100
+			if v := r.URL.Query().Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					moovhttp.Problem(w, fmt.Errorf("invalid limit %q", v))
+					return
+				}
+				limit = n
+			}
+			imports, err := svc.repo.getImports(limit)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if imports == nil {
+				imports = []*journalImport{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(imports)
+
+		case "POST":
+			data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxJournalImportSize+1))
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if len(data) > maxJournalImportSize {
+				moovhttp.Problem(w, fmt.Errorf("spreadsheet is larger than %d bytes", maxJournalImportSize))
+				return
+			}
+			if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun")); dryRun {
+				report, err := svc.DryRunImport(requestContext(r), data)
+				if err != nil {
+					moovhttp.Problem(w, err)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				json.NewEncoder(w).Encode(report)
+				return
+			}
+			imp, err := svc.PreviewImport(requestContext(r), moovhttp.GetUserID(r), r.URL.Query().Get("filename"), data)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(imp)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// getJournalImport is an admin route which returns a journal import and its preview.
+func getJournalImport(logger log.Logger, svc *journalImportService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		imp, err := svc.repo.getImport(mux.Vars(r)["importId"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if imp == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(imp)
+	}
+}
+
+// postJournalImport is an admin route which confirms a previewed journal import and posts its entries, saving its
+// progress after every ?batchSize entries.
+func postJournalImport(logger log.Logger, svc *journalImportService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		batchSize := defaultJournalImportBatchSize
+		if v := r.URL.Query().Get("batchSize"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				moovhttp.Problem(w, fmt.Errorf("invalid batchSize %q", v))
+				return
+			}
+			batchSize = n
+		}
+		imp, err := svc.PostImport(requestContext(r), mux.Vars(r)["importId"], moovhttp.GetUserID(r), batchSize)
+		if err != nil {
+			if err == errJournalImportNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			logger.Log("journalImports", fmt.Sprintf("problem posting journal import: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(imp)
+	}
+}
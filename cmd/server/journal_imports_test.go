@@ -0,0 +1,357 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestJournalImports__parse(t *testing.T) {
+	entries, err := parseJournalEntries([][]string{
+		{},
+		{"Entry", "Account ID", "Purpose", "Amount", "Memo", "Description"},
+		{"accrual", "expenses", "ACHDebit", "1200", "rent", "March rent"},
+		{"fees", "expenses", "achdebit", "25"},
+		{"accrual", "payable", "achcredit", "1200"},
+		{"", "", "", ""},
+		{"fees", "income", "fee", "25"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Entry != "accrual" || len(entries[0].Lines) != 2 || entries[1].Entry != "fees" {
+		t.Fatalf("unexpected entries: %#v", entries)
+	}
+	if entries[0].Lines[0] != (transactionLine{AccountID: "expenses", Purpose: ACHDebit, Amount: 1200, Description: "March rent"}) {
+		t.Errorf("unexpected line: %#v", entries[0].Lines[0])
+	}
+
+	// every problem is reported
+	_, err = parseJournalEntries([][]string{
+		{"entry", "accountId", "purpose", "amount"},
+		{"accrual", "expenses", "achdebit", "1200"},
+		{"accrual", "payable", "achcredit", "1100"},
+		{"fees", "expenses", "cash", "25"},
+		{"fees", "income", "fee", "-25"},
+		{"", "income", "fee", "25"},
+	})
+	errs, ok := err.(journalImportErrors)
+	if !ok || len(errs) != 4 || !strings.Contains(errs[3], "entry accrual doesn't balance") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// entries keep their timestamp and system accounts are addressed by name
+	configuredSystemAccounts = systemAccounts{"suspense": systemAccountID("suspense")}
+	defer func() { configuredSystemAccounts = systemAccounts{} }()
+	entries, err = parseJournalEntries([][]string{
+		{"entry", "accountId", "purpose", "amount", "timestamp"},
+		{"opening", "system:suspense", "achdebit", "500", "2019-12-31"},
+		{"opening", "cash", "achcredit", "500", "2019-12-31"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts := entries[0].Timestamp; ts == nil || !ts.Equal(time.Date(2019, time.December, 31, 0, 0, 0, 0, time.UTC)) || entries[0].Lines[0].AccountID != systemAccountID("suspense") {
+		t.Errorf("unexpected entry: %#v", entries[0])
+	}
+	_, err = parseJournalEntries([][]string{
+		{"entry", "accountId", "purpose", "amount", "timestamp"},
+		{"opening", "expenses", "achdebit", "500", "2019-12-31"},
+		{"opening", "cash", "achcredit", "500", "2020-01-01T00:00:00Z"},
+		{"later", "expenses", "achdebit", "500", time.Now().AddDate(0, 0, 2).Format("2006-01-02")},
+		{"later", "cash", "achcredit", "500", "yesterday"},
+	})
+	if errs, ok := err.(journalImportErrors); !ok || len(errs) != 4 || !strings.Contains(errs[0], "different timestamps") || !strings.Contains(errs[1], "in the future") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if _, err := parseJournalEntries([][]string{{"entry", "account", "purpose", "amount"}}); err == nil || !strings.Contains(err.Error(), "no accountid column") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := parseJournalEntries([][]string{{"entry", "accountId", "purpose", "amount"}}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+// createTestXLSX returns a minimal workbook whose first sheet has shared strings, inline strings, numbers and
+// a gap between cells.
+func createTestXLSX(t *testing.T) []byte {
+	t.Helper()
+
+	parts := map[string]string{
+		"xl/workbook.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+  <sheets><sheet name="Journal" sheetId="1" r:id="rId3"/><sheet name="Notes" sheetId="2" r:id="rId1"/></sheets>
+</workbook>`,
+		"xl/_rels/workbook.xml.rels": `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+  <Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>
+  <Relationship Id="rId3" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet2.xml"/>
+</Relationships>`,
+		"xl/sharedStrings.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+  <si><t>entry</t></si><si><t>accountId</t></si><si><t>purpose</t></si><si><t>amount</t></si>
+  <si><r><t>month</t></r><r><t>-end</t></r></si><si><t>achdebit</t></si><si><t>achcredit</t></si>
+</sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData/></worksheet>`,
+		"xl/worksheets/sheet2.xml": `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+  <row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c><c r="D1" t="s"><v>3</v></c><c r="F1" t="inlineStr"><is><t>memo</t></is></c></row>
+  <row r="2"><c r="A2" t="s"><v>4</v></c><c r="B2" t="inlineStr"><is><t>expenses</t></is></c><c r="C2" t="s"><v>5</v></c><c r="D2"><v>1200</v></c></row>
+  <row r="3"><c r="A3" t="s"><v>4</v></c><c r="B3" t="inlineStr"><is><t>payable</t></is></c><c r="C3" t="s"><v>6</v></c><c r="D3"><v>1200</v></c></row>
+</sheetData></worksheet>`,
+	}
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, body := range parts {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(body))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestJournalImports__readSpreadsheet(t *testing.T) {
+	rows, err := readSpreadsheet(createTestXLSX(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"entry", "accountId", "purpose", "amount", "", "memo"},
+		{"month-end", "expenses", "achdebit", "1200"},
+		{"month-end", "payable", "achcredit", "1200"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("rows=%#v", rows)
+	}
+
+	rows, err = readSpreadsheet([]byte("entry,accountId,purpose,amount\naccrual, expenses,achdebit,1200\n"))
+	if err != nil || len(rows) != 2 || rows[1][1] != "expenses" {
+		t.Errorf("rows=%#v error=%v", rows, err)
+	}
+
+	if _, err := readSpreadsheet([]byte("PK\x03\x04 not a zip")); err == nil {
+		t.Error("expected error")
+	}
+	if col, err := xlsxColumn("AB12"); err != nil || col != 27 {
+		t.Errorf("col=%d error=%v", col, err)
+	}
+	if _, err := xlsxColumn("12"); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestJournalImports(t *testing.T) {
+	check := func(t *testing.T, repo *sqlJournalImportRepository) {
+		accountRepo, transactionRepo := createTestLedger(t, map[string]int{"expenses": 5000, "payable": 100, "income": 100})
+
+		svc := &journalImportService{
+			logger:       log.NewNopLogger(),
+			repo:         repo,
+			accounts:     accountRepo,
+			transactions: &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}},
+		}
+		router := mux.NewRouter()
+		router.HandleFunc("/transactions/imports", journalImports(log.NewNopLogger(), svc))
+		router.HandleFunc("/transactions/imports/{importId}", getJournalImport(log.NewNopLogger(), svc))
+		router.HandleFunc("/transactions/imports/{importId}/post", postJournalImport(log.NewNopLogger(), svc))
+
+		serve := func(method, path string, body []byte) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, bytes.NewReader(body))
+			req.Header.Set("x-user-id", "finance")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			w.Flush()
+			return w
+		}
+		preview := func(body []byte) *journalImport {
+			t.Helper()
+			w := serve("POST", "/transactions/imports?filename=journal.xlsx", body)
+			if w.Code != http.StatusOK {
+				t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+			}
+			var imp journalImport
+			if err := json.NewDecoder(w.Body).Decode(&imp); err != nil {
+				t.Fatal(err)
+			}
+			return &imp
+		}
+
+		// nothing is posted from invalid spreadsheets
+		if w := serve("POST", "/transactions/imports", []byte("entry,accountId,purpose,amount\naccrual,expenses,achdebit,1200\n")); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+
+		imp := preview(createTestXLSX(t))
+		expected := []journalImportBalance{
+			{AccountID: "expenses", Balance: 5000, ResultingBalance: 3800},
+			{AccountID: "payable", Balance: 100, ResultingBalance: 1300},
+		}
+		if imp.Status != journalImportPreviewed || imp.Filename != "journal.xlsx" || len(imp.Entries) != 1 || !reflect.DeepEqual(imp.Balances, expected) {
+			t.Errorf("unexpected import: %#v", imp)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"expenses": 5000, "payable": 100})
+
+		w := serve("POST", "/transactions/imports/"+imp.ID+"/post", nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		checkBalances(t, accountRepo, map[string]int32{"expenses": 3800, "payable": 1300})
+
+		// imports are only posted once
+		if w := serve("POST", "/transactions/imports/"+imp.ID+"/post", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if w := serve("GET", "/transactions/imports/"+imp.ID, nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"posted"`) || !strings.Contains(w.Body.String(), `"transactionId"`) {
+			t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+
+		// entries posted before a failure aren't posted again when the import is retried
+		csv := "entry,accountId,purpose,amount\nfirst,expenses,achdebit,300\nfirst,income,achcredit,300\nsecond,income,achdebit,1000\nsecond,payable,achcredit,1000\n"
+		retried := preview([]byte(csv))
+		if w := serve("POST", "/transactions/imports/"+retried.ID+"/post", nil); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"expenses": 3500, "income": 400, "payable": 1300})
+
+		deposit := transactionLine{AccountID: "income", Purpose: ACHCredit, Amount: 2000}
+		if err := transactionRepo.createTransaction(context.Background(), transaction{ID: newID(), Timestamp: imp.CreatedAt, Status: TransactionPosted, Lines: []transactionLine{deposit}}, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+		if w := serve("POST", "/transactions/imports/"+retried.ID+"/post", nil); w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		checkBalances(t, accountRepo, map[string]int32{"expenses": 3500, "income": 1400, "payable": 2300})
+
+		var imports []*journalImport
+		if err := json.NewDecoder(serve("GET", "/transactions/imports", nil).Body).Decode(&imports); err != nil || len(imports) != 2 {
+			t.Errorf("imports=%#v error=%v", imports, err)
+		}
+		if w := serve("POST", "/transactions/imports/missing/post", nil); w.Code != http.StatusNotFound {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+
+		// dry runs report every problem, including accounts which don't exist, without saving anything
+		var report journalImportReport
+		w = serve("POST", "/transactions/imports?dryRun=true", []byte("entry,accountId,purpose,amount\nfirst,expenses,achdebit,300\nfirst,missing,achcredit,300\nsecond,income,achdebit,10\n"))
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil || w.Code != http.StatusOK {
+			t.Fatalf("status=%d error=%v", w.Code, err)
+		}
+		if report.Valid || len(report.Problems) != 1 || !strings.Contains(report.Problems[0], "entry second doesn't balance") {
+			t.Errorf("unexpected report: %#v", report)
+		}
+		w = serve("POST", "/transactions/imports?dryRun=true", []byte("entry,accountId,purpose,amount\nfirst,expenses,achdebit,300\nfirst,missing,achcredit,300\n"))
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil || report.Valid || len(report.Problems) != 1 || !strings.Contains(report.Problems[0], "account missing doesn't exist") {
+			t.Errorf("unexpected report: %#v error=%v", report, err)
+		}
+		if w := serve("POST", "/transactions/imports", []byte("entry,accountId,purpose,amount\nfirst,expenses,achdebit,300\nfirst,missing,achcredit,300\n")); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		report = journalImportReport{}
+		w = serve("POST", "/transactions/imports?dryRun=true", []byte(csv))
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil || !report.Valid || report.Entries != 2 || report.Lines != 4 || len(report.Balances) != 3 {
+			t.Errorf("unexpected report: %#v error=%v", report, err)
+		}
+		if err := json.NewDecoder(serve("GET", "/transactions/imports", nil).Body).Decode(&imports); err != nil || len(imports) != 2 {
+			t.Errorf("imports=%#v error=%v", imports, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlJournalImportRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlJournalImportRepository{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestJournalImports__batches(t *testing.T) {
+	check := func(t *testing.T, repo *sqlJournalImportRepository) {
+		accountRepo, transactionRepo := createTestLedger(t, map[string]int{"cash": 0, "equity": 1000})
+
+		svc := &journalImportService{
+			logger:       log.NewNopLogger(),
+			repo:         repo,
+			accounts:     accountRepo,
+			transactions: &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}},
+		}
+		router := mux.NewRouter()
+		router.HandleFunc("/transactions/imports", journalImports(log.NewNopLogger(), svc))
+		router.HandleFunc("/transactions/imports/{importId}/post", postJournalImport(log.NewNopLogger(), svc))
+		server := httptest.NewServer(router)
+		defer server.Close()
+
+		var buf bytes.Buffer
+		buf.WriteString("entry,accountId,purpose,amount,timestamp\n")
+		for i := 1; i <= 5; i++ {
+			fmt.Fprintf(&buf, "day%d,equity,achdebit,100,2019-12-0%d\nday%d,cash,achcredit,100,2019-12-0%d\n", i, i, i, i)
+		}
+		path := filepath.Join(t.TempDir(), "history.csv")
+		if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		client := newJournalImportClient(strings.TrimPrefix(server.URL, "http://"), "migration")
+		if report, err := client.dryRun(context.Background(), path); err != nil || !report.Valid || report.Entries != 5 {
+			t.Fatalf("report=%#v error=%v", report, err)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"cash": 0, "equity": 1000})
+
+		imp, err := client.importJournal(context.Background(), path, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if imp.Status != journalImportPosted || imp.Filename != "history.csv" || len(imp.Entries) != 5 {
+			t.Errorf("unexpected import: %#v", imp)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"cash": 500, "equity": 500})
+
+		// entries are posted at their timestamps
+		tx, err := transactionRepo.getTransaction(context.Background(), imp.Entries[2].TransactionID)
+		if err != nil || tx == nil || !tx.Timestamp.Equal(time.Date(2019, time.December, 3, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("transaction=%#v error=%v", tx, err)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/transactions/imports/"+imp.ID+"/post?batchSize=none", nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if _, err := client.importJournal(context.Background(), filepath.Join(t.TempDir(), "missing.csv"), 2); err == nil {
+			t.Error("expected error")
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlJournalImportRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlJournalImportRepository{mysqlDB.DB, log.NewNopLogger()})
+}
@@ -0,0 +1,194 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Older mobile clients were built against responses which named fields like accountID (rather than accountId) and
+// wrapped every body in an envelope. The compatibility mode rewrites JSON responses into that shape as they're
+// written, so handlers only ever encode the current format and clients can upgrade on their own schedule.
+//
+//	{"status": 200, "data": {"accountID": "..."}}
+//	{"status": 400, "error": "..."}
+//
+// Requests don't need rewriting, as JSON fields are matched to struct fields without case.
+
+// jsonCompatibilityMode is when responses are rewritten for legacy clients.
+type jsonCompatibilityMode string
+
+const (
+	jsonCompatibilityOff jsonCompatibilityMode = "off"
+
+	// jsonCompatibilityHeader rewrites the responses of requests which send the jsonCompatibilityHeaderName header
+	jsonCompatibilityHeader jsonCompatibilityMode = "header"
+
+	// jsonCompatibilityLegacy rewrites every response
+	jsonCompatibilityLegacy jsonCompatibilityMode = "legacy"
+)
+
+// jsonCompatibilityHeaderName opts a request into the legacy format when JSON_COMPATIBILITY=header.
+const jsonCompatibilityHeaderName = "X-JSON-Compatibility"
+
+// readJSONCompatibilityMode reads JSON_COMPATIBILITY, which is off by default.
+func readJSONCompatibilityMode() (jsonCompatibilityMode, error) {
+	switch mode := jsonCompatibilityMode(strings.ToLower(os.Getenv("JSON_COMPATIBILITY"))); mode {
+	case "":
+		return jsonCompatibilityOff, nil
+	case jsonCompatibilityOff, jsonCompatibilityHeader, jsonCompatibilityLegacy:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown JSON_COMPATIBILITY %q, expected off, header or legacy", mode)
+	}
+}
+
+// jsonCompatibility rewrites JSON responses for legacy clients, see the top of this file.
+type jsonCompatibility struct {
+	logger log.Logger
+	mode   jsonCompatibilityMode
+	next   http.Handler
+}
+
+func newJSONCompatibility(logger log.Logger, mode jsonCompatibilityMode, next http.Handler) http.Handler {
+	if mode == jsonCompatibilityOff {
+		return next
+	}
+	return &jsonCompatibility{logger: logger, mode: mode, next: next}
+}
+
+func (c *jsonCompatibility) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if c.mode == jsonCompatibilityHeader && !strings.EqualFold(r.Header.Get(jsonCompatibilityHeaderName), string(jsonCompatibilityLegacy)) {
+		c.next.ServeHTTP(w, r)
+		return
+	}
+	rec := &jsonCompatibilityWriter{ResponseWriter: w, code: http.StatusOK}
+	c.next.ServeHTTP(rec, r)
+	if !rec.buffering {
+		return
+	}
+	body, err := legacyJSON(rec.code, rec.buf.Bytes())
+	if err != nil {
+		// Send what the handler wrote rather than failing a request which already happened
+		if isJSONContentType(w.Header()) {
+			c.logger.Log("jsonCompatibility", fmt.Sprintf("problem rewriting %s %s response: %v", r.Method, r.URL.Path, err))
+		}
+		body = rec.buf.Bytes()
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(rec.code)
+	w.Write(body)
+}
+
+func isJSONContentType(h http.Header) bool {
+	return strings.HasPrefix(h.Get("Content-Type"), "application/json")
+}
+
+// jsonCompatibilityWriter buffers JSON responses so they can be rewritten. Responses without a Content-Type are also
+// buffered, as moovhttp.Problem only sets it after the status. Other responses, such as CSV exports and streams, are
+// written through untouched.
+type jsonCompatibilityWriter struct {
+	http.ResponseWriter
+
+	code        int
+	wroteHeader bool
+	buffering   bool
+	buf         bytes.Buffer
+}
+
+func (w *jsonCompatibilityWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.code, w.wroteHeader = code, true
+	if isJSONContentType(w.Header()) || w.Header().Get("Content-Type") == "" {
+		w.buffering = true
+		w.Header().Del("Content-Length")
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *jsonCompatibilityWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.buffering {
+		return w.buf.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *jsonCompatibilityWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.buffering {
+		f.Flush()
+	}
+}
+
+// legacyJSON renames the fields of a JSON response to their legacy names and wraps it in the legacy envelope.
+// Empty bodies are left alone.
+func legacyJSON(code int, body []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return body, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	v = legacyFieldNames(v)
+
+	envelope := map[string]interface{}{"status": code}
+	if problem, ok := v.(map[string]interface{}); ok && code >= 400 && len(problem) == 1 && problem["error"] != nil {
+		envelope["error"] = problem["error"]
+	} else if code >= 400 {
+		envelope["error"] = v
+	} else {
+		envelope["data"] = v
+	}
+	out, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// legacyFieldNames renames the keys of every object in v, such as accountId to accountID and transactionIds to
+// transactionIDs. Keys which are just id are kept.
+func legacyFieldNames(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			out[legacyFieldName(key)] = legacyFieldNames(value)
+		}
+		return out
+	case []interface{}:
+		for i := range v {
+			v[i] = legacyFieldNames(v[i])
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+func legacyFieldName(key string) string {
+	for _, suffix := range []string{"Id", "Ids"} {
+		if len(key) > len(suffix) && strings.HasSuffix(key, suffix) {
+			return strings.TrimSuffix(key, suffix) + strings.ToUpper(suffix[:2]) + suffix[2:]
+		}
+	}
+	return key
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestJSONCompatibility__readMode(t *testing.T) {
+	if mode, err := readJSONCompatibilityMode(); err != nil || mode != jsonCompatibilityOff {
+		t.Errorf("mode=%q error=%v", mode, err)
+	}
+	os.Setenv("JSON_COMPATIBILITY", "Header")
+	defer os.Unsetenv("JSON_COMPATIBILITY")
+	if mode, err := readJSONCompatibilityMode(); err != nil || mode != jsonCompatibilityHeader {
+		t.Errorf("mode=%q error=%v", mode, err)
+	}
+	os.Setenv("JSON_COMPATIBILITY", "v1")
+	if _, err := readJSONCompatibilityMode(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestJSONCompatibility__fieldNames(t *testing.T) {
+	for key, expected := range map[string]string{
+		"id":             "id",
+		"accountId":      "accountID",
+		"transactionIds": "transactionIDs",
+		"paid":           "paid",
+		"Id":             "Id",
+	} {
+		if v := legacyFieldName(key); v != expected {
+			t.Errorf("%s: got %s", key, v)
+		}
+	}
+}
+
+func TestJSONCompatibility(t *testing.T) {
+	router := mux.NewRouter()
+	router.Methods("GET").Path("/accounts/{accountId}").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["accountId"] == "missing" {
+			moovhttp.Problem(w, errors.New("account not found"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":      "a",
+			"balance": 12345678901,
+			"lines":   []map[string]string{{"accountId": "a", "transactionId": "t"}},
+		})
+	})
+	router.Methods("GET").Path("/export").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("accountId,amount\n"))
+	})
+	handler := newJSONCompatibility(log.NewNopLogger(), jsonCompatibilityHeader, router)
+
+	serve := func(path string, legacy bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if legacy {
+			req.Header.Set("X-JSON-Compatibility", "legacy")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	// responses are only rewritten for clients asking for it
+	if w := serve("/accounts/a", false); w.Body.String() != `{"balance":12345678901,"id":"a","lines":[{"accountId":"a","transactionId":"t"}]}`+"\n" {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+	w := serve("/accounts/a", true)
+	if w.Code != http.StatusOK || w.Body.String() != `{"data":{"balance":12345678901,"id":"a","lines":[{"accountID":"a","transactionID":"t"}]},"status":200}`+"\n" {
+		t.Errorf("status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = serve("/accounts/missing", true)
+	if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"account not found","status":400}`+"\n" || w.Header().Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("status=%d body=%s headers=%v", w.Code, w.Body.String(), w.Header())
+	}
+
+	// other formats are untouched
+	if w := serve("/export", true); w.Body.String() != "accountId,amount\n" {
+		t.Errorf("unexpected body: %s", w.Body.String())
+	}
+	if w := serve("/nowhere", true); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
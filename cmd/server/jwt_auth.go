@@ -0,0 +1,506 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Services can authenticate with a JWT issued by our identity provider instead of a static token. Tokens are
+// verified against the provider's JSON Web Key Set (JWKS), which is cached and refetched as keys rotate.
+const (
+	// jwtLeeway allows for clock drift between us and the identity provider when checking exp and nbf.
+	jwtLeeway = time.Minute
+
+	// jwksMinRefresh limits how often an unknown key ID can trigger fetching the key set, so tokens with
+	// made up key IDs can't hammer the identity provider.
+	jwksMinRefresh = 30 * time.Second
+)
+
+var (
+	errUnknownSigningKey = errors.New("unknown signing key")
+)
+
+// jwtConfig is read from the environment. JWKS_URL enables JWT authentication.
+type jwtConfig struct {
+	jwksURL  string
+	cacheTTL time.Duration // JWKS_CACHE_TTL, unless the key set's response has Cache-Control: max-age
+
+	issuer   string // JWT_ISSUER
+	audience string // JWT_AUDIENCE
+
+	// required rejects requests which aren't authenticated with a JWT, a signed request or a client certificate.
+	required bool // JWT_REQUIRED
+
+	rolesClaim  string // JWT_ROLES_CLAIM
+	tenantClaim string // JWT_TENANT_CLAIM
+
+	// permissions maps roles to the route groups (postings, reads, reports and admin) they can call, starting
+	// with the built in roles from roles.go.
+	permissions map[string]map[string]bool // JWT_ROLE_PERMISSIONS
+}
+
+func readJWTConfig() (*jwtConfig, error) {
+	cfg := &jwtConfig{
+		jwksURL:     os.Getenv("JWKS_URL"),
+		cacheTTL:    time.Hour,
+		issuer:      os.Getenv("JWT_ISSUER"),
+		audience:    os.Getenv("JWT_AUDIENCE"),
+		rolesClaim:  "roles",
+		tenantClaim: "tenant",
+		permissions: builtinRolePermissions(),
+	}
+	if v := os.Getenv("JWT_REQUIRED"); v != "" {
+		required, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_REQUIRED: invalid boolean %q", v)
+		}
+		cfg.required = required
+	}
+	if cfg.jwksURL == "" {
+		if cfg.required {
+			return nil, errors.New("JWKS_URL is required with JWT_REQUIRED")
+		}
+		return cfg, nil
+	}
+	if cfg.issuer == "" {
+		return nil, errors.New("JWT_ISSUER is required with JWKS_URL")
+	}
+	if v := os.Getenv("JWKS_CACHE_TTL"); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil || dur <= 0 {
+			return nil, fmt.Errorf("JWKS_CACHE_TTL: invalid duration %q", v)
+		}
+		cfg.cacheTTL = dur
+	}
+	if v := os.Getenv("JWT_ROLES_CLAIM"); v != "" {
+		cfg.rolesClaim = v
+	}
+	if v := os.Getenv("JWT_TENANT_CLAIM"); v != "" {
+		cfg.tenantClaim = v
+	}
+	// JWT_ROLE_PERMISSIONS is a comma separated list of role:permissions, where permissions are written as they
+	// are for REQUEST_SIGNING_KEYS plus admin (e.g. ledger-writer:postings+reads,auditor:reports). Built in roles
+	// can be redefined.
+	for _, v := range strings.Split(os.Getenv("JWT_ROLE_PERMISSIONS"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		idx := strings.LastIndex(v, ":")
+		if idx <= 0 || idx == len(v)-1 {
+			return nil, fmt.Errorf("JWT_ROLE_PERMISSIONS: invalid role %q, expected role:permissions", v)
+		}
+		role, perms := v[:idx], make(map[string]bool)
+		for _, perm := range strings.Split(v[idx+1:], "+") {
+			switch perm {
+			case "*", routeGroupPostings, routeGroupReads, routeGroupReports, routeGroupAdmin:
+				perms[perm] = true
+			default:
+				return nil, fmt.Errorf("JWT_ROLE_PERMISSIONS: role %q has unknown permission %q", role, perm)
+			}
+		}
+		cfg.permissions[role] = perms
+	}
+	return cfg, nil
+}
+
+// jwksCache holds the identity provider's public keys by key ID. Keys expire after the TTL so removed keys stop
+// being trusted, and an unknown key ID refetches the set so newly rotated keys are picked up.
+type jwksCache struct {
+	url    string
+	ttl    time.Duration
+	client *http.Client
+
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	expires     time.Time
+	lastFetched time.Time
+	lastErr     error // from fetching at lastFetched, returned rather than retrying within jwksMinRefresh
+
+	now func() time.Time
+}
+
+func newJWKSCache(url string, ttl time.Duration) *jwksCache {
+	return &jwksCache{
+		url:    url,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// get returns the public key for keyID, fetching the key set when it's expired or doesn't contain keyID.
+func (c *jwksCache) get(keyID string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.After(c.expires) {
+		c.keys = nil // expired keys are never used, even if the refresh fails
+		if c.lastErr != nil && now.Sub(c.lastFetched) < jwksMinRefresh {
+			return nil, c.lastErr
+		}
+		if err := c.refresh(now); err != nil {
+			return nil, err
+		}
+	}
+	if key, ok := c.keys[keyID]; ok {
+		return key, nil
+	}
+	if now.Sub(c.lastFetched) < jwksMinRefresh {
+		return nil, fmt.Errorf("kid=%q: %v", keyID, errUnknownSigningKey)
+	}
+	if err := c.refresh(now); err != nil {
+		return nil, err
+	}
+	if key, ok := c.keys[keyID]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("kid=%q: %v", keyID, errUnknownSigningKey)
+}
+
+type jsonWebKey struct {
+	KeyID string `json:"kid"`
+	Type  string `json:"kty"`
+	Use   string `json:"use"`
+
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// EC
+	Curve string `json:"crv"`
+	X     string `json:"x"`
+	Y     string `json:"y"`
+}
+
+func (c *jwksCache) refresh(now time.Time) error {
+	c.lastFetched = now
+	c.lastErr = c.fetch(now)
+	return c.lastErr
+}
+
+func (c *jwksCache) fetch(now time.Time) error {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return fmt.Errorf("fetching JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching JWKS: unexpected HTTP status %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("reading JWKS: %v", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for i := range set.Keys {
+		if set.Keys[i].Use != "" && set.Keys[i].Use != "sig" {
+			continue
+		}
+		key, err := set.Keys[i].publicKey()
+		if err != nil {
+			return fmt.Errorf("reading JWKS: kid=%q: %v", set.Keys[i].KeyID, err)
+		}
+		keys[set.Keys[i].KeyID] = key
+	}
+	c.keys = keys
+	c.expires = now.Add(cacheMaxAge(resp.Header.Get("Cache-Control"), c.ttl))
+	return nil
+}
+
+// cacheMaxAge returns the max-age of a Cache-Control header, or fallback if there isn't one.
+func cacheMaxAge(header string, fallback time.Duration) time.Duration {
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.TrimSpace(directive)
+		if strings.HasPrefix(directive, "max-age=") {
+			if secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && secs > 0 {
+				return time.Duration(secs) * time.Second
+			}
+		}
+	}
+	return fallback
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	decode := func(v string) (*big.Int, error) {
+		bs, err := base64.RawURLEncoding.DecodeString(v)
+		if err != nil || len(bs) == 0 {
+			return nil, fmt.Errorf("invalid key parameter %q", v)
+		}
+		return new(big.Int).SetBytes(bs), nil
+	}
+	switch k.Type {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !elliptic.P256().IsOnCurve(x, y) {
+			return nil, errors.New("point isn't on P-256")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Type)
+}
+
+// jwtClaims describe the caller of a verified token.
+type jwtClaims struct {
+	Subject string
+	Roles   []string
+	Tenant  string
+}
+
+// verifyJWT checks token's signature, issuer, audience and lifetime then returns its claims.
+func verifyJWT(token string, keys *jwksCache, cfg *jwtConfig, now time.Time) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("header: %v", err)
+	}
+	key, err := keys.get(header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed signature")
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		if header.Algorithm != "RS256" {
+			return nil, fmt.Errorf("alg=%q doesn't match an RSA key", header.Algorithm)
+		}
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], sig); err != nil {
+			return nil, errors.New("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		if header.Algorithm != "ES256" {
+			return nil, fmt.Errorf("alg=%q doesn't match an EC key", header.Algorithm)
+		}
+		if len(sig) != 64 {
+			return nil, errors.New("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(pub, hash[:], r, s) {
+			return nil, errors.New("invalid signature")
+		}
+	default:
+		return nil, errors.New("unsupported key")
+	}
+
+	var raw map[string]interface{}
+	if err := decodeJWTSegment(parts[1], &raw); err != nil {
+		return nil, fmt.Errorf("claims: %v", err)
+	}
+	if iss, _ := raw["iss"].(string); iss != cfg.issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if cfg.audience != "" && !containsString(claimStrings(raw["aud"]), cfg.audience) {
+		return nil, fmt.Errorf("token isn't for audience %q", cfg.audience)
+	}
+	exp, ok := raw["exp"].(float64)
+	if !ok {
+		return nil, errors.New("token has no expiration")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return nil, errors.New("token is expired")
+	}
+	if nbf, ok := raw["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("token isn't valid yet")
+	}
+
+	claims := &jwtClaims{Roles: claimStrings(raw[cfg.rolesClaim])}
+	claims.Subject, _ = raw["sub"].(string)
+	claims.Tenant, _ = raw[cfg.tenantClaim].(string)
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return claims, nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	bs, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bs, v)
+}
+
+// claimStrings reads a claim which is either a string or an array of strings, as aud and roles often are.
+func claimStrings(v interface{}) []string {
+	switch vv := v.(type) {
+	case string:
+		return []string{vv}
+	case []interface{}:
+		var out []string
+		for i := range vv {
+			if s, ok := vv[i].(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func containsString(values []string, v string) bool {
+	for i := range values {
+		if values[i] == v {
+			return true
+		}
+	}
+	return false
+}
+
+// jwtAuth verifies bearer tokens before passing requests to next. Requests without a JWT are passed through
+// untouched. Verified requests are attributed to the token's subject through X-User-ID and its tenant through
+// X-Tenant-ID.
+type jwtAuth struct {
+	logger log.Logger
+	cfg    *jwtConfig
+	keys   *jwksCache
+	next   http.Handler
+
+	// signing and clientCerts are true when REQUEST_SIGNING_KEYS and MTLS_CLIENT_IDENTITIES are configured, so
+	// signed requests and client certificates can authenticate requests instead of a JWT.
+	signing     bool
+	clientCerts bool
+
+	now func() time.Time
+}
+
+func newJWTAuth(logger log.Logger, cfg *jwtConfig, signingKeys map[string]*signingKey, clientIDs map[string]*clientIdentity, next http.Handler) http.Handler {
+	if cfg == nil || cfg.jwksURL == "" {
+		return next
+	}
+	return &jwtAuth{
+		logger:      logger,
+		cfg:         cfg,
+		keys:        newJWKSCache(cfg.jwksURL, cfg.cacheTTL),
+		next:        next,
+		signing:     len(signingKeys) > 0,
+		clientCerts: len(clientIDs) > 0,
+		now:         time.Now,
+	}
+}
+
+// allows returns if any of roles can call routes in group.
+func (a *jwtAuth) allows(roles []string, group string) bool {
+	for i := range roles {
+		if perms := a.cfg.permissions[roles[i]]; perms["*"] || perms[group] {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *jwtAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == r.Header.Get("Authorization") || strings.Count(token, ".") != 2 {
+		if a.cfg.required && !a.authenticatedOtherwise(r) {
+			a.logger.Log(
+				"audit", "rejected unauthenticated request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"requestID", moovhttp.GetRequestID(r),
+			)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="accounts"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		a.next.ServeHTTP(w, r)
+		return
+	}
+	claims, err := verifyJWT(token, a.keys, a.cfg, a.now())
+	if err != nil {
+		a.logger.Log(
+			"audit", fmt.Sprintf("rejected JWT: %v", err),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"requestID", moovhttp.GetRequestID(r),
+		)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if group := rolePermission(r); !a.allows(claims.Roles, group) {
+		a.logger.Log(
+			"audit", fmt.Sprintf("rejected %s request: roles %v aren't permitted", group, claims.Roles),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"userID", claims.Subject,
+			"tenant", claims.Tenant,
+			"requestID", moovhttp.GetRequestID(r),
+		)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	r.Header.Set("X-User-ID", claims.Subject)
+	r.Header.Del("X-Tenant-ID")
+	if claims.Tenant != "" {
+		r.Header.Set("X-Tenant-ID", claims.Tenant)
+	}
+	a.next.ServeHTTP(w, r)
+}
+
+// authenticatedOtherwise returns if a request without a JWT is left to be authenticated by requestSigning or
+// clientIdentities, which reject it themselves if it's invalid, or is the unauthenticated health check. Signatures
+// and certificates only stand in for a JWT when their authenticator is configured.
+func (a *jwtAuth) authenticatedOtherwise(r *http.Request) bool {
+	if r.Method == "GET" && r.URL.Path == "/ping" {
+		return true
+	}
+	if a.signing && strings.HasPrefix(r.Header.Get("Authorization"), signingAlgorithm+" ") {
+		return true
+	}
+	return a.clientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
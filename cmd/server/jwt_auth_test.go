@@ -0,0 +1,412 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// testIdentityProvider serves a JWKS of its current keys and signs tokens with them.
+type testIdentityProvider struct {
+	mu      sync.Mutex
+	rsaKeys map[string]*rsa.PrivateKey
+	ecKeys  map[string]*ecdsa.PrivateKey
+	fetches int
+	*httptest.Server
+}
+
+func newTestIdentityProvider(t *testing.T) *testIdentityProvider {
+	t.Helper()
+	idp := &testIdentityProvider{rsaKeys: make(map[string]*rsa.PrivateKey), ecKeys: make(map[string]*ecdsa.PrivateKey)}
+	idp.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.fetches++
+
+		enc := base64.RawURLEncoding
+		var keys []jsonWebKey
+		for kid, k := range idp.rsaKeys {
+			keys = append(keys, jsonWebKey{KeyID: kid, Type: "RSA", Use: "sig", N: enc.EncodeToString(k.N.Bytes()), E: enc.EncodeToString(big.NewInt(int64(k.E)).Bytes())})
+		}
+		for kid, k := range idp.ecKeys {
+			keys = append(keys, jsonWebKey{KeyID: kid, Type: "EC", Curve: "P-256", X: enc.EncodeToString(k.X.Bytes()), Y: enc.EncodeToString(k.Y.Bytes())})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+	}))
+	return idp
+}
+
+func (idp *testIdentityProvider) addRSAKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.rsaKeys[kid] = key
+	idp.mu.Unlock()
+}
+
+func (idp *testIdentityProvider) addECKey(t *testing.T, kid string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.mu.Lock()
+	idp.ecKeys[kid] = key
+	idp.mu.Unlock()
+}
+
+func (idp *testIdentityProvider) removeKey(kid string) {
+	idp.mu.Lock()
+	delete(idp.rsaKeys, kid)
+	delete(idp.ecKeys, kid)
+	idp.mu.Unlock()
+}
+
+func (idp *testIdentityProvider) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	alg := "RS256"
+	if _, ok := idp.ecKeys[kid]; ok {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signingInput))
+
+	var sig []byte
+	if key, ok := idp.ecKeys[kid]; ok {
+		r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	} else {
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, idp.rsaKeys[kid], crypto.SHA256, hash[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signingInput + "." + enc.EncodeToString(sig)
+}
+
+func TestJWTAuth__readJWTConfig(t *testing.T) {
+	cfg, err := readJWTConfig()
+	if err != nil || cfg.jwksURL != "" {
+		t.Fatalf("cfg=%#v error=%v", cfg, err)
+	}
+
+	os.Setenv("JWT_REQUIRED", "yes")
+	if _, err := readJWTConfig(); err == nil || !strings.Contains(err.Error(), "JWT_REQUIRED") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Setenv("JWT_REQUIRED", "true")
+	if _, err := readJWTConfig(); err == nil || !strings.Contains(err.Error(), "JWKS_URL is required") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Unsetenv("JWT_REQUIRED")
+
+	os.Setenv("JWKS_URL", "https://idp.example.com/.well-known/jwks.json")
+	defer os.Unsetenv("JWKS_URL")
+	if _, err := readJWTConfig(); err == nil || !strings.Contains(err.Error(), "JWT_ISSUER") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Setenv("JWT_ISSUER", "https://idp.example.com")
+	defer os.Unsetenv("JWT_ISSUER")
+	if cfg, err := readJWTConfig(); err != nil || !cfg.permissions[roleViewer][routeGroupReads] || cfg.permissions[roleViewer][routeGroupPostings] {
+		t.Errorf("cfg=%#v error=%v", cfg, err)
+	}
+	os.Setenv("JWT_ROLE_PERMISSIONS", "ledger-writer:postings+reads,auditor:writes")
+	defer os.Unsetenv("JWT_ROLE_PERMISSIONS")
+	if _, err := readJWTConfig(); err == nil || !strings.Contains(err.Error(), `unknown permission "writes"`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Setenv("JWT_ROLE_PERMISSIONS", "ledger-writer:postings+reads,auditor:reports")
+	os.Setenv("JWT_REQUIRED", "true")
+	defer os.Unsetenv("JWT_REQUIRED")
+	os.Setenv("JWKS_CACHE_TTL", "10m")
+	defer os.Unsetenv("JWKS_CACHE_TTL")
+	cfg, err = readJWTConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.cacheTTL != 10*time.Minute || !cfg.required || !cfg.permissions["ledger-writer"][routeGroupPostings] || !cfg.permissions["auditor"][routeGroupReports] || !cfg.permissions[roleAdmin]["*"] {
+		t.Errorf("unexpected config: %#v", cfg)
+	}
+}
+
+func TestJWTAuth__middleware(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	defer idp.Close()
+	idp.addRSAKey(t, "rsa-1")
+	idp.addECKey(t, "ec-1")
+
+	cfg := &jwtConfig{
+		jwksURL:     idp.URL,
+		cacheTTL:    time.Hour,
+		issuer:      "https://idp.example.com",
+		audience:    "accounts",
+		rolesClaim:  "roles",
+		tenantClaim: "tenant",
+		permissions: map[string]map[string]bool{
+			"ledger-writer": {routeGroupPostings: true, routeGroupReads: true},
+			"auditor":       {routeGroupReports: true},
+		},
+	}
+	var buf bytes.Buffer
+	signingKeys := map[string]*signingKey{"key-1": {ID: "key-1", Secret: "secret"}}
+	auth := newJWTAuth(log.NewLogfmtLogger(&buf), cfg, signingKeys, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-User-ID", r.Header.Get("X-User-ID"))
+		w.Header().Set("X-Tenant-ID", r.Header.Get("X-Tenant-ID"))
+	})).(*jwtAuth)
+
+	now := time.Now()
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		out := map[string]interface{}{
+			"iss":    "https://idp.example.com",
+			"aud":    []string{"accounts", "paygate"},
+			"sub":    "payroll-service",
+			"exp":    now.Add(5 * time.Minute).Unix(),
+			"roles":  []string{"ledger-writer"},
+			"tenant": "acme",
+		}
+		for k, v := range overrides {
+			out[k] = v
+		}
+		return out
+	}
+	serve := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-User-ID", "spoofed")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		auth.ServeHTTP(w, req)
+		return w
+	}
+
+	// valid RS256 and ES256 tokens are attributed to their subject and tenant
+	for _, kid := range []string{"rsa-1", "ec-1"} {
+		w := serve("POST", "/accounts/transactions", idp.sign(t, kid, claims(nil)))
+		if w.Code != http.StatusOK || w.Header().Get("X-User-ID") != "payroll-service" || w.Header().Get("X-Tenant-ID") != "acme" {
+			t.Errorf("%s: got %d userID=%q tenant=%q", kid, w.Code, w.Header().Get("X-User-ID"), w.Header().Get("X-Tenant-ID"))
+		}
+	}
+
+	// roles limit the route groups which can be called
+	if w := serve("GET", "/accounts/abc/transactions", idp.sign(t, "rsa-1", claims(nil))); w.Code != http.StatusForbidden {
+		t.Errorf("report: got %d", w.Code)
+	}
+	if w := serve("GET", "/accounts/abc/transactions", idp.sign(t, "rsa-1", claims(map[string]interface{}{"roles": "auditor"}))); w.Code != http.StatusOK {
+		t.Errorf("auditor: got %d", w.Code)
+	}
+
+	// invalid tokens
+	bad := map[string]string{
+		"issuer":   idp.sign(t, "rsa-1", claims(map[string]interface{}{"iss": "https://evil.example.com"})),
+		"audience": idp.sign(t, "rsa-1", claims(map[string]interface{}{"aud": "ledger"})),
+		"expired":  idp.sign(t, "rsa-1", claims(map[string]interface{}{"exp": now.Add(-5 * time.Minute).Unix()})),
+		"nbf":      idp.sign(t, "rsa-1", claims(map[string]interface{}{"nbf": now.Add(5 * time.Minute).Unix()})),
+		"subject":  idp.sign(t, "rsa-1", claims(map[string]interface{}{"sub": ""})),
+		"header":   strings.Replace(idp.sign(t, "rsa-1", claims(nil)), ".", "x.", 1),
+	}
+	token := idp.sign(t, "rsa-1", claims(nil))
+	parts := strings.Split(token, ".")
+	forged, _ := json.Marshal(claims(map[string]interface{}{"sub": "admin"}))
+	bad["signature"] = parts[0] + "." + base64.RawURLEncoding.EncodeToString(forged) + "." + parts[2]
+	for name, token := range bad {
+		if w := serve("GET", "/accounts/search", token); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got %d", name, w.Code)
+		}
+	}
+	if !strings.Contains(buf.String(), "rejected JWT") {
+		t.Errorf("unexpected audit log: %s", buf.String())
+	}
+
+	// other authentication is left alone
+	if w := serve("GET", "/ping", ""); w.Code != http.StatusOK || w.Header().Get("X-User-ID") != "spoofed" {
+		t.Errorf("unauthenticated: got %d userID=%q", w.Code, w.Header().Get("X-User-ID"))
+	}
+
+	// unless a JWT is required
+	cfg.required = true
+	buf.Reset()
+	if w := serve("POST", "/accounts/transactions", ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("required: got %d", w.Code)
+	}
+	if !strings.Contains(buf.String(), "rejected unauthenticated request") {
+		t.Errorf("unexpected audit log: %s", buf.String())
+	}
+	if w := serve("GET", "/ping", ""); w.Code != http.StatusOK {
+		t.Errorf("ping: got %d", w.Code)
+	}
+	if w := serve("POST", "/accounts/transactions", idp.sign(t, "rsa-1", claims(nil))); w.Code != http.StatusOK {
+		t.Errorf("required: got %d", w.Code)
+	}
+	req := httptest.NewRequest("POST", "/accounts/transactions", nil)
+	req.Header.Set("Authorization", signingAlgorithm+" Credential=key-1")
+	w := httptest.NewRecorder()
+	auth.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("signed request: got %d", w.Code)
+	}
+}
+
+func TestJWTAuth__requiredWithoutSigningKeys(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	defer idp.Close()
+
+	cfg := &jwtConfig{jwksURL: idp.URL, cacheTTL: time.Hour, issuer: "https://idp.example.com", required: true}
+	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unauthenticated %s %s was served", r.Method, r.URL.Path)
+	})
+	handler = newClientIdentities(log.NewNopLogger(), nil, handler)
+	handler = newRequestSigning(log.NewNopLogger(), nil, handler)
+	handler = newJWTAuth(log.NewNopLogger(), cfg, nil, nil, handler)
+
+	// an Authorization header which only looks signed doesn't skip JWT_REQUIRED when signing isn't configured
+	req := httptest.NewRequest("POST", "/accounts/transactions", strings.NewReader(`{"lines": []}`))
+	req.Header.Set("Authorization", "MOOV-HMAC-SHA256 x")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestJWTAuth__roles(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	defer idp.Close()
+	idp.addRSAKey(t, "rsa-1")
+
+	cfg := &jwtConfig{
+		jwksURL:     idp.URL,
+		cacheTTL:    time.Hour,
+		issuer:      "https://idp.example.com",
+		rolesClaim:  "roles",
+		tenantClaim: "tenant",
+		permissions: builtinRolePermissions(),
+	}
+	var buf bytes.Buffer
+	auth := newJWTAuth(log.NewLogfmtLogger(&buf), cfg, nil, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serve := func(method, path, role string) int {
+		token := idp.sign(t, "rsa-1", map[string]interface{}{
+			"iss":   "https://idp.example.com",
+			"sub":   "support-tool",
+			"exp":   time.Now().Add(5 * time.Minute).Unix(),
+			"roles": []string{role},
+		})
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		auth.ServeHTTP(w, req)
+		return w.Code
+	}
+	cases := []struct {
+		method, path string
+		role         string
+		status       int
+	}{
+		{"GET", "/accounts/abc", roleViewer, http.StatusOK},
+		{"GET", "/accounts/abc/transactions", roleViewer, http.StatusOK},
+		{"POST", "/accounts/transactions", roleViewer, http.StatusForbidden},
+		{"POST", "/accounts/abc/postings", roleViewer, http.StatusForbidden},
+		{"POST", "/accounts/transactions", rolePoster, http.StatusOK},
+		{"POST", "/accounts/transaction-templates/payroll/transactions", rolePoster, http.StatusOK},
+		{"PUT", "/accounts/transaction-templates/payroll", rolePoster, http.StatusForbidden},
+		{"PATCH", "/accounts/abc", rolePoster, http.StatusForbidden},
+		{"POST", "/accounts/abc/close", rolePoster, http.StatusForbidden},
+		{"POST", "/accounts/abc/close", roleAdmin, http.StatusOK},
+		{"PUT", "/accounts/transaction-templates/payroll", roleAdmin, http.StatusOK},
+		{"GET", "/accounts/abc", "unknown", http.StatusForbidden},
+	}
+	for i := range cases {
+		if status := serve(cases[i].method, cases[i].path, cases[i].role); status != cases[i].status {
+			t.Errorf("%s %s as %s: got %d", cases[i].method, cases[i].path, cases[i].role, status)
+		}
+	}
+	if !strings.Contains(buf.String(), "rejected admin request: roles [poster] aren't permitted") {
+		t.Errorf("unexpected audit log: %s", buf.String())
+	}
+}
+
+func TestJWTAuth__rotation(t *testing.T) {
+	idp := newTestIdentityProvider(t)
+	defer idp.Close()
+	idp.addRSAKey(t, "key-1")
+
+	now := time.Now()
+	cache := newJWKSCache(idp.URL, time.Hour)
+	cache.now = func() time.Time { return now }
+
+	if _, err := cache.get("key-1"); err != nil {
+		t.Fatal(err)
+	}
+	// a rotated key isn't fetched until jwksMinRefresh has passed
+	idp.addRSAKey(t, "key-2")
+	if _, err := cache.get("key-2"); err == nil || !strings.Contains(err.Error(), errUnknownSigningKey.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	now = now.Add(jwksMinRefresh)
+	if _, err := cache.get("key-2"); err != nil {
+		t.Fatal(err)
+	}
+	if idp.fetches != 2 {
+		t.Errorf("fetched JWKS %d times", idp.fetches)
+	}
+
+	// removed keys are trusted until the cache expires
+	idp.removeKey("key-1")
+	if _, err := cache.get("key-1"); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(time.Hour + time.Second)
+	if _, err := cache.get("key-1"); err == nil {
+		t.Error("expected removed key to expire")
+	}
+
+	// an expired cache doesn't fall back to its old keys when the identity provider is down
+	idp.Close()
+	now = now.Add(2 * time.Hour)
+	if _, err := cache.get("key-2"); err == nil || !strings.Contains(err.Error(), "fetching JWKS") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if ttl := cacheMaxAge("public, max-age=300", time.Hour); ttl != 5*time.Minute {
+		t.Errorf("ttl=%v", ttl)
+	}
+	if ttl := cacheMaxAge("no-store", time.Hour); ttl != time.Hour {
+		t.Errorf("ttl=%v", ttl)
+	}
+}
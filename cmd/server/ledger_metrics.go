@@ -0,0 +1,124 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	transactionsCreated = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "transactions_created",
+		Help: "Counter of transactions created, by their status",
+	}, []string{"status"})
+
+	transactionsRejected = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "transactions_rejected",
+		Help: "Counter of transactions which couldn't be created, by reason",
+	}, []string{"reason"})
+
+	balanceReadDuration = prometheus.NewHistogramFrom(stdprometheus.HistogramOpts{
+		Name: "balance_read_duration_seconds",
+		Help: "Histogram of how long reading accounts along with their balances takes",
+	}, []string{"operation"})
+
+	sqliteBusyErrors = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "sqlite_busy_errors",
+		Help: "Counter of storage operations which failed because SQLite was busy or locked by another connection",
+	}, []string{"operation"})
+)
+
+// Reasons transactions are rejected, as counted in transactions_rejected.
+const (
+	rejectedInsufficientFunds = "insufficient_funds"
+	rejectedAccountStatus     = "account_status"
+	rejectedBusy              = "busy"
+	rejectedOther             = "other"
+)
+
+// rejectionReason returns why createTransaction failed with err.
+func rejectionReason(err error) string {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, errInsufficientFunds.Error()):
+		return rejectedInsufficientFunds
+	case strings.Contains(msg, errAccountClosed.Error()), strings.Contains(msg, errAccountFrozen.Error()), strings.Contains(msg, errAccountSuspended.Error()):
+		return rejectedAccountStatus
+	case database.SqliteBusy(err):
+		return rejectedBusy
+	}
+	return rejectedOther
+}
+
+func countBusy(operation string, err error) {
+	if err != nil && database.SqliteBusy(err) {
+		sqliteBusyErrors.With("operation", operation).Add(1)
+	}
+}
+
+// instrumentedTransactionRepository records metrics of the transactions posted through the wrapped repository.
+type instrumentedTransactionRepository struct {
+	transactionRepository
+}
+
+func (r *instrumentedTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	err := r.transactionRepository.createTransaction(ctx, tx, opts)
+	if err != nil {
+		transactionsRejected.With("reason", rejectionReason(err)).Add(1)
+		countBusy("createTransaction", err)
+		return err
+	}
+	status := tx.Status
+	if status == "" {
+		status = TransactionPosted
+	}
+	transactionsCreated.With("status", string(status)).Add(1)
+	return nil
+}
+
+func (r *instrumentedTransactionRepository) updateTransactionStatus(transactionID string, status TransactionStatus) error {
+	err := r.transactionRepository.updateTransactionStatus(transactionID, status)
+	countBusy("updateTransactionStatus", err)
+	return err
+}
+
+// instrumentedAccountRepository records how long reading accounts, which calculates their balances, takes.
+type instrumentedAccountRepository struct {
+	accountRepository
+}
+
+func observeBalanceRead(operation string, start time.Time, err error) {
+	balanceReadDuration.With("operation", operation).Observe(time.Since(start).Seconds())
+	countBusy(operation, err)
+}
+
+func (r *instrumentedAccountRepository) GetAccounts(ctx context.Context, accountIDs []string) ([]*accounts.Account, error) {
+	start := time.Now()
+	accts, err := r.accountRepository.GetAccounts(ctx, accountIDs)
+	observeBalanceRead("GetAccounts", start, err)
+	return accts, err
+}
+
+func (r *instrumentedAccountRepository) SearchAccountsByCustomerID(ctx context.Context, customerID string) ([]*accounts.Account, error) {
+	start := time.Now()
+	accts, err := r.accountRepository.SearchAccountsByCustomerID(ctx, customerID)
+	observeBalanceRead("SearchAccountsByCustomerID", start, err)
+	return accts, err
+}
+
+func (r *instrumentedAccountRepository) SearchAccountsByRoutingNumber(ctx context.Context, accountNumber, routingNumber, acctType string) (*accounts.Account, error) {
+	start := time.Now()
+	acct, err := r.accountRepository.SearchAccountsByRoutingNumber(ctx, accountNumber, routingNumber, acctType)
+	observeBalanceRead("SearchAccountsByRoutingNumber", start, err)
+	return acct, err
+}
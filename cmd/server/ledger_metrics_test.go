@@ -0,0 +1,83 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// counterValue returns the value of a counter from the default Prometheus registry.
+func counterValue(t *testing.T, name, label, value string) float64 {
+	t.Helper()
+	families, err := stdprometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == label && l.GetValue() == value {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestLedgerMetrics__rejectionReason(t *testing.T) {
+	cases := map[string]string{
+		`createTransaction: account="a" has insufficient funds`:              rejectedInsufficientFunds,
+		`createTransaction: transaction="t": account="a": account is frozen`: rejectedAccountStatus,
+		`createTransaction: commit: database is locked`:                      rejectedBusy,
+		`transaction="t" is invalid: no lines`:                               rejectedOther,
+	}
+	for msg, expected := range cases {
+		if reason := rejectionReason(errors.New(msg)); reason != expected {
+			t.Errorf("%s: got %s expected %s", msg, reason, expected)
+		}
+	}
+}
+
+func TestLedgerMetrics__instrumentedRepositories(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"a": 100, "b": 0})
+	repo := &instrumentedTransactionRepository{transactionRepo}
+
+	created := counterValue(t, "transactions_created", "status", "posted")
+	rejected := counterValue(t, "transactions_rejected", "reason", rejectedInsufficientFunds)
+
+	post := func(amount int) error {
+		return repo.createTransaction(context.Background(), transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+			{AccountID: "a", Purpose: ACHDebit, Amount: amount},
+			{AccountID: "b", Purpose: ACHCredit, Amount: amount},
+		}}, createTransactionOpts{})
+	}
+	if err := post(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := post(1000); err == nil {
+		t.Fatal("expected error")
+	}
+	if n := counterValue(t, "transactions_created", "status", "posted"); n != created+1 {
+		t.Errorf("transactions_created=%v", n)
+	}
+	if n := counterValue(t, "transactions_rejected", "reason", rejectedInsufficientFunds); n != rejected+1 {
+		t.Errorf("transactions_rejected=%v", n)
+	}
+
+	accts, err := (&instrumentedAccountRepository{accountRepo}).GetAccounts(context.Background(), []string{"a"})
+	if err != nil || len(accts) != 1 || accts[0].Balance != 90 {
+		t.Errorf("accounts=%#v error=%v", accts, err)
+	}
+}
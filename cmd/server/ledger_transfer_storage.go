@@ -0,0 +1,109 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type ledgerTransferRepository interface {
+	Ping() error
+	Close() error
+
+	createLedgerTransfer(transfer *ledgerTransfer) error
+	updateLedgerTransferStatus(transferID string, status ledgerTransferStatus) error
+
+	// getLedgerTransfer returns nil if the transfer doesn't exist.
+	getLedgerTransfer(transferID string) (*ledgerTransfer, error)
+
+	// getUnfinishedLedgerTransfers returns transfers which are neither committed nor aborted and haven't changed
+	// since before, oldest first.
+	getUnfinishedLedgerTransfers(before time.Time) ([]*ledgerTransfer, error)
+}
+
+type sqlLedgerTransferRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlLedgerTransferRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlLedgerTransferRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlLedgerTransferRepository) createLedgerTransfer(transfer *ledgerTransfer) error {
+	query := `insert into ledger_transfers (transfer_id, peer, source_account_id, destination_account_id, amount, status, expires_at, created_at, last_modified) values (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createLedgerTransfer: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(transfer.ID, transfer.Peer, transfer.SourceAccountID, transfer.DestinationAccountID, transfer.Amount, transfer.Status, transfer.ExpiresAt, transfer.CreatedAt, transfer.LastModified)
+	if err != nil {
+		return fmt.Errorf("createLedgerTransfer: transfer=%s: %v", transfer.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlLedgerTransferRepository) updateLedgerTransferStatus(transferID string, status ledgerTransferStatus) error {
+	query := `update ledger_transfers set status = ?, last_modified = ? where transfer_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("updateLedgerTransferStatus: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(status, time.Now(), transferID); err != nil {
+		return fmt.Errorf("updateLedgerTransferStatus: transfer=%s: %v", transferID, err)
+	}
+	return nil
+}
+
+func (r *sqlLedgerTransferRepository) getLedgerTransfer(transferID string) (*ledgerTransfer, error) {
+	transfers, err := r.queryLedgerTransfers(`transfer_id = ?`, transferID)
+	if err != nil {
+		return nil, fmt.Errorf("getLedgerTransfer: %v", err)
+	}
+	if len(transfers) == 0 {
+		return nil, nil
+	}
+	return transfers[0], nil
+}
+
+func (r *sqlLedgerTransferRepository) getUnfinishedLedgerTransfers(before time.Time) ([]*ledgerTransfer, error) {
+	transfers, err := r.queryLedgerTransfers(`status in (?, ?) and last_modified < ?`, ledgerTransferPrepared, ledgerTransferCommitting, before)
+	if err != nil {
+		return nil, fmt.Errorf("getUnfinishedLedgerTransfers: %v", err)
+	}
+	return transfers, nil
+}
+
+func (r *sqlLedgerTransferRepository) queryLedgerTransfers(where string, args ...interface{}) ([]*ledgerTransfer, error) {
+	query := fmt.Sprintf(`select transfer_id, peer, source_account_id, destination_account_id, amount, status, expires_at, created_at, last_modified
+from ledger_transfers where %s order by created_at;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*ledgerTransfer
+	for rows.Next() {
+		var t ledgerTransfer
+		if err := rows.Scan(&t.ID, &t.Peer, &t.SourceAccountID, &t.DestinationAccountID, &t.Amount, &t.Status, &t.ExpiresAt, &t.CreatedAt, &t.LastModified); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		out = append(out, &t)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSqlLedgerTransferRepository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlLedgerTransferRepository) {
+		defer repo.Close()
+
+		now := time.Now().UTC().Truncate(time.Second)
+		transfer := &ledgerTransfer{
+			ID:                   ledgerTransferID(),
+			Peer:                 "program2",
+			SourceAccountID:      base.ID(),
+			DestinationAccountID: base.ID(),
+			Amount:               1250,
+			Status:               ledgerTransferPrepared,
+			ExpiresAt:            now.Add(ledgerTransferTimeout),
+			CreatedAt:            now,
+			LastModified:         now,
+		}
+		if err := repo.createLedgerTransfer(transfer); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.createLedgerTransfer(transfer); err == nil {
+			t.Error("expected error for duplicate transfer")
+		}
+
+		found, err := repo.getLedgerTransfer(transfer.ID)
+		if err != nil || found == nil {
+			t.Fatalf("found=%#v error=%v", found, err)
+		}
+		if found.Peer != "program2" || found.Amount != 1250 || found.Status != ledgerTransferPrepared || !found.ExpiresAt.Equal(transfer.ExpiresAt) {
+			t.Errorf("unexpected transfer: %#v", found)
+		}
+		if found, err := repo.getLedgerTransfer(ledgerTransferID()); err != nil || found != nil {
+			t.Errorf("found=%#v error=%v", found, err)
+		}
+
+		// only transfers which haven't changed recently are unfinished
+		if transfers, err := repo.getUnfinishedLedgerTransfers(now.Add(-time.Minute)); err != nil || len(transfers) != 0 {
+			t.Errorf("transfers=%#v error=%v", transfers, err)
+		}
+		if transfers, err := repo.getUnfinishedLedgerTransfers(now.Add(time.Minute)); err != nil || len(transfers) != 1 {
+			t.Errorf("transfers=%#v error=%v", transfers, err)
+		}
+
+		if err := repo.updateLedgerTransferStatus(transfer.ID, ledgerTransferCommitted); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := repo.getLedgerTransfer(transfer.ID); err != nil || found.Status != ledgerTransferCommitted {
+			t.Errorf("found=%#v error=%v", found, err)
+		}
+		if transfers, err := repo.getUnfinishedLedgerTransfers(now.Add(time.Hour)); err != nil || len(transfers) != 0 {
+			t.Errorf("transfers=%#v error=%v", transfers, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlLedgerTransferRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlLedgerTransferRepository{mysqlDB.DB, log.NewNopLogger()})
+}
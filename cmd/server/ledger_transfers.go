@@ -0,0 +1,469 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Ledger transfers move funds from an account on this instance to an account on another accounts instance (a peer,
+// such as another program's ledger) with a two-phase handshake over both ledgers' holds:
+//
+//  1. We hold the transfer here, debiting the source account and crediting the peer's settlement account.
+//  2. The peer holds it, debiting our settlement account on the peer and crediting the destination account.
+//  3. The peer's hold is committed, then ours.
+//
+// Both holds share the transfer's ID. Each step is recorded before it's attempted so transfers interrupted by a
+// timeout or restart are reconciled against the peer and finished, rather than leaving funds held on one side.
+type ledgerTransferStatus string
+
+const (
+	// ledgerTransferPrepared transfers are held here and may be held on the peer.
+	ledgerTransferPrepared ledgerTransferStatus = "prepared"
+	// ledgerTransferCommitting transfers are held on both ledgers and may be committed on the peer.
+	ledgerTransferCommitting ledgerTransferStatus = "committing"
+
+	ledgerTransferCommitted ledgerTransferStatus = "committed"
+	ledgerTransferAborted   ledgerTransferStatus = "aborted"
+)
+
+var (
+	// ledgerTransferTimeout is how long the peer holds a transfer. Our own hold lasts ledgerTransferLocalTimeout,
+	// so it outlives the peer's and reconciliation can still commit or abort it afterwards.
+	ledgerTransferTimeout      = 5 * time.Minute
+	ledgerTransferLocalTimeout = 24 * time.Hour
+
+	// ledgerTransferIdleTime is how long a transfer is unchanged before it's reconciled, which leaves transfers
+	// still being made by a request alone.
+	ledgerTransferIdleTime = time.Minute
+
+	// ledgerPeerUserID is sent as the X-User-ID header on requests to peers.
+	ledgerPeerUserID = "accounts"
+)
+
+type ledgerTransfer struct {
+	ID                   string               `json:"id"`
+	Peer                 string               `json:"peer"`
+	SourceAccountID      string               `json:"sourceAccountId"`
+	DestinationAccountID string               `json:"destinationAccountId"`
+	Amount               int                  `json:"amount"`
+	Status               ledgerTransferStatus `json:"status"`
+
+	// ExpiresAt is when the peer's hold expires, after which an uncommitted transfer can be aborted.
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	CreatedAt    time.Time `json:"createdAt"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// localLines are the lines held on this instance.
+func (t *ledgerTransfer) localLines(peer *ledgerPeer) []transactionLine {
+	return []transactionLine{
+		{AccountID: t.SourceAccountID, Purpose: ACHDebit, Amount: t.Amount},
+		{AccountID: peer.LocalAccountID, Purpose: ACHCredit, Amount: t.Amount},
+	}
+}
+
+// remoteLines are the lines held on the peer.
+func (t *ledgerTransfer) remoteLines(peer *ledgerPeer) []transactionLine {
+	return []transactionLine{
+		{AccountID: peer.RemoteAccountID, Purpose: ACHDebit, Amount: t.Amount},
+		{AccountID: t.DestinationAccountID, Purpose: ACHCredit, Amount: t.Amount},
+	}
+}
+
+// ledgerTransferID returns a new ID formatted as a UUID, which both ledgers accept as the ID of their hold.
+func ledgerTransferID() string {
+	id := newID()
+	return fmt.Sprintf("%s-%s-%s-%s-%s", id[:8], id[8:12], id[12:16], id[16:20], id[20:32])
+}
+
+// ledgerPeer is another accounts instance we transfer funds to.
+type ledgerPeer struct {
+	Name string
+	URL  string
+
+	// LocalAccountID is the peer's settlement account on this instance, which outgoing transfers credit.
+	LocalAccountID string
+	// RemoteAccountID is our settlement account on the peer, which outgoing transfers debit. It must be funded.
+	RemoteAccountID string
+
+	client *http.Client
+}
+
+// readLedgerPeers reads LEDGER_PEERS, comma separated peers formatted as name:localAccountId:remoteAccountId:url
+func readLedgerPeers() (map[string]*ledgerPeer, error) {
+	peers := make(map[string]*ledgerPeer)
+	v := os.Getenv("LEDGER_PEERS")
+	if v == "" {
+		return peers, nil
+	}
+	for _, p := range strings.Split(v, ",") {
+		parts := strings.SplitN(strings.TrimSpace(p), ":", 4)
+		if len(parts) != 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid LEDGER_PEERS peer %q", p)
+		}
+		u, err := url.Parse(parts[3])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid LEDGER_PEERS url %q", parts[3])
+		}
+		if _, exists := peers[parts[0]]; exists {
+			return nil, fmt.Errorf("duplicate LEDGER_PEERS peer %q", parts[0])
+		}
+		peers[parts[0]] = &ledgerPeer{
+			Name:            parts[0],
+			URL:             strings.TrimSuffix(u.String(), "/"),
+			LocalAccountID:  parts[1],
+			RemoteAccountID: parts[2],
+			client:          &http.Client{Timeout: 10 * time.Second},
+		}
+	}
+	return peers, nil
+}
+
+// ledgerPeerError is a response from a peer which refused our request. Other errors (timeouts, 5xx responses)
+// leave the outcome of a request unknown.
+type ledgerPeerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ledgerPeerError) Error() string {
+	return fmt.Sprintf("peer responded with %d: %s", e.StatusCode, e.Message)
+}
+
+func (p *ledgerPeer) prepare(ctx context.Context, t *ledgerTransfer) (*transaction, error) {
+	return p.do(ctx, "POST", "/accounts/transactions/prepare", prepareTransactionRequest{
+		ID:      t.ID,
+		Lines:   t.remoteLines(p),
+		Timeout: time.Until(t.ExpiresAt).Round(time.Second).String(),
+	})
+}
+
+func (p *ledgerPeer) commit(ctx context.Context, transactionID string) (*transaction, error) {
+	return p.do(ctx, "POST", fmt.Sprintf("/accounts/transactions/%s/commit", transactionID), nil)
+}
+
+func (p *ledgerPeer) getTransaction(ctx context.Context, transactionID string) (*transaction, error) {
+	return p.do(ctx, "GET", fmt.Sprintf("/accounts/transactions/%s", transactionID), nil)
+}
+
+func (p *ledgerPeer) do(ctx context.Context, method, path string, body interface{}) (*transaction, error) {
+	var buf io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		buf = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequest(method, p.URL+path, buf)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", ledgerPeerUserID)
+	if requestID := requestIDFrom(ctx); requestID != "" {
+		req.Header.Set("X-Request-ID", requestID)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bs, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, &ledgerPeerError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(bs))}
+	}
+	var tx transaction
+	if err := json.NewDecoder(resp.Body).Decode(&tx); err != nil {
+		return nil, fmt.Errorf("reading peer transaction: %v", err)
+	}
+	return &tx, nil
+}
+
+// peerRefused returns true when err is a peer refusing a request, as opposed to an unknown outcome.
+func peerRefused(err error) bool {
+	var perr *ledgerPeerError
+	return errors.As(err, &perr) && perr.StatusCode < http.StatusInternalServerError
+}
+
+type ledgerTransferService struct {
+	logger       log.Logger
+	repo         ledgerTransferRepository
+	transactions *transactionService
+	peers        map[string]*ledgerPeer
+}
+
+type createLedgerTransferRequest struct {
+	Peer                 string `json:"peer"`
+	SourceAccountID      string `json:"sourceAccountId"`
+	DestinationAccountID string `json:"destinationAccountId"`
+	Amount               int    `json:"amount"`
+}
+
+// CreateLedgerTransfer transfers funds to an account on a peer. Transfers the peer refuses are aborted and returned
+// as an error, otherwise the transfer is returned in whichever status it reached. Transfers which haven't finished
+// are completed by reconciliation.
+func (s *ledgerTransferService) CreateLedgerTransfer(ctx context.Context, req createLedgerTransferRequest) (*ledgerTransfer, error) {
+	requestID := requestIDFrom(ctx)
+
+	peer, exists := s.peers[req.Peer]
+	if !exists {
+		return nil, fmt.Errorf("unknown peer %q", req.Peer)
+	}
+	if req.SourceAccountID == "" || req.DestinationAccountID == "" || req.Amount <= 0 {
+		return nil, errors.New("sourceAccountId, destinationAccountId and a positive amount are required")
+	}
+
+	now := time.Now()
+	t := &ledgerTransfer{
+		ID:                   ledgerTransferID(),
+		Peer:                 peer.Name,
+		SourceAccountID:      req.SourceAccountID,
+		DestinationAccountID: req.DestinationAccountID,
+		Amount:               req.Amount,
+		Status:               ledgerTransferPrepared,
+		ExpiresAt:            now.Add(ledgerTransferTimeout).UTC(),
+		CreatedAt:            now,
+		LastModified:         now,
+	}
+
+	// Hold funds here first, so the peer is never asked to credit a transfer we can't fund
+	hold := prepareTransactionRequest{ID: t.ID, Lines: t.localLines(peer), Timeout: ledgerTransferLocalTimeout.String()}
+	if _, err := s.transactions.PrepareTransaction(ctx, hold); err != nil {
+		return nil, err
+	}
+	if err := s.repo.createLedgerTransfer(t); err != nil {
+		if _, abortErr := s.transactions.AbortTransaction(ctx, t.ID); abortErr != nil {
+			s.logger.Log("transfers", fmt.Sprintf("problem aborting transaction=%s: %v", t.ID, abortErr), "requestID", requestID)
+		}
+		return nil, err
+	}
+
+	if _, err := peer.prepare(ctx, t); err != nil {
+		if peerRefused(err) {
+			// Nothing was held on the peer, so release our hold
+			if abortErr := s.finish(ctx, t, ledgerTransferAborted); abortErr != nil {
+				s.logger.Log("transfers", fmt.Sprintf("problem aborting transfer=%s: %v", t.ID, abortErr), "requestID", requestID)
+			}
+			return nil, fmt.Errorf("peer %s refused transfer=%s: %v", peer.Name, t.ID, err)
+		}
+		s.logger.Log("transfers", fmt.Sprintf("transfer=%s left %s for reconciliation: %v", t.ID, t.Status, err), "requestID", requestID)
+		return t, nil
+	}
+	if err := s.commit(ctx, peer, t); err != nil {
+		s.logger.Log("transfers", fmt.Sprintf("transfer=%s left %s for reconciliation: %v", t.ID, t.Status, err), "requestID", requestID)
+	}
+	return t, nil
+}
+
+func (s *ledgerTransferService) GetLedgerTransfer(ctx context.Context, transferID string) (*ledgerTransfer, error) {
+	return s.repo.getLedgerTransfer(transferID)
+}
+
+// commit records that the transfer is committing, commits it on the peer and then finishes it here.
+func (s *ledgerTransferService) commit(ctx context.Context, peer *ledgerPeer, t *ledgerTransfer) error {
+	if t.Status != ledgerTransferCommitting {
+		if err := s.setStatus(t, ledgerTransferCommitting); err != nil {
+			return err
+		}
+	}
+	if _, err := peer.commit(ctx, t.ID); err != nil {
+		return fmt.Errorf("committing on peer %s: %v", peer.Name, err)
+	}
+	return s.finish(ctx, t, ledgerTransferCommitted)
+}
+
+// finish commits or aborts our hold of the transfer, then records its outcome.
+func (s *ledgerTransferService) finish(ctx context.Context, t *ledgerTransfer, status ledgerTransferStatus) error {
+	want := TransactionPosted
+	if status == ledgerTransferAborted {
+		want = TransactionVoided
+	}
+	tx, err := s.transactions.GetTransaction(ctx, t.ID)
+	if err != nil {
+		return fmt.Errorf("finish: transfer=%s: %v", t.ID, err)
+	}
+	switch tx.Status {
+	case TransactionHeld:
+		_, err = s.transactions.UpdateTransactionStatus(ctx, t.ID, want)
+	case want:
+		// finished by an earlier attempt
+	default:
+		err = fmt.Errorf("transaction is %s rather than %s", tx.Status, want)
+	}
+	if err != nil {
+		return fmt.Errorf("finish: transfer=%s: %v", t.ID, err)
+	}
+	return s.setStatus(t, status)
+}
+
+func (s *ledgerTransferService) setStatus(t *ledgerTransfer, status ledgerTransferStatus) error {
+	if err := s.repo.updateLedgerTransferStatus(t.ID, status); err != nil {
+		return err
+	}
+	t.Status = status
+	t.LastModified = time.Now()
+	return nil
+}
+
+// reconcile finishes transfers which were interrupted, according to the status of the peer's hold, and returns how
+// many were committed or aborted.
+func (s *ledgerTransferService) reconcile(ctx context.Context, now time.Time) (int, error) {
+	transfers, err := s.repo.getUnfinishedLedgerTransfers(now.Add(-ledgerTransferIdleTime))
+	if err != nil {
+		return 0, fmt.Errorf("reconcile: %v", err)
+	}
+	finished := 0
+	for _, t := range transfers {
+		if err := s.reconcileTransfer(ctx, t, now); err != nil {
+			s.logger.Log("transfers", fmt.Sprintf("problem reconciling %s transfer=%s: %v", t.Status, t.ID, err))
+			continue
+		}
+		finished++
+	}
+	return finished, nil
+}
+
+func (s *ledgerTransferService) reconcileTransfer(ctx context.Context, t *ledgerTransfer, now time.Time) error {
+	peer, exists := s.peers[t.Peer]
+	if !exists {
+		return fmt.Errorf("unknown peer %q", t.Peer)
+	}
+	tx, err := peer.getTransaction(ctx, t.ID)
+	if err != nil {
+		// The peer's hold can't be posted without a commit and can't be committed once it expires
+		if t.Status == ledgerTransferPrepared && now.After(t.ExpiresAt) {
+			return s.finish(ctx, t, ledgerTransferAborted)
+		}
+		return fmt.Errorf("reading transaction from peer %s: %v", peer.Name, err)
+	}
+	switch tx.Status {
+	case TransactionHeld:
+		return s.commit(ctx, peer, t)
+	case TransactionPosted:
+		return s.finish(ctx, t, ledgerTransferCommitted)
+	case TransactionVoided:
+		return s.finish(ctx, t, ledgerTransferAborted)
+	}
+	return fmt.Errorf("peer %s transaction is %s", peer.Name, tx.Status)
+}
+
+// setupLedgerTransferReconciliation periodically finishes transfers which were interrupted.
+func setupLedgerTransferReconciliation(ctx context.Context, logger log.Logger, svc *ledgerTransferService, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				finished, err := svc.reconcile(ctx, now)
+				if err != nil {
+					logger.Log("transfers", err)
+				} else if finished > 0 {
+					logger.Log("transfers", fmt.Sprintf("reconciled %d transfers", finished))
+				}
+			}
+		}
+	}()
+}
+
+func addLedgerTransferRoutes(logger log.Logger, router *mux.Router, svc *ledgerTransferService) {
+	router.Methods("POST").Path("/accounts/transfers").HandlerFunc(createLedgerTransfer(logger, svc))
+	router.Methods("GET").Path("/accounts/transfers/{transferId}").HandlerFunc(getLedgerTransfer(logger, svc))
+}
+
+func createLedgerTransfer(logger log.Logger, svc *ledgerTransferService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		var req createLedgerTransferRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		transfer, err := svc.CreateLedgerTransfer(requestContext(r), req)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(transfer)
+	}
+}
+
+func getLedgerTransfer(logger log.Logger, svc *ledgerTransferService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		transfer, err := svc.GetLedgerTransfer(requestContext(r), mux.Vars(r)["transferId"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if transfer == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(transfer)
+	}
+}
+
+// getUnfinishedLedgerTransfers lists transfers which are still being made or waiting on reconciliation.
+func getUnfinishedLedgerTransfers(logger log.Logger, svc *ledgerTransferService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		transfers, err := svc.repo.getUnfinishedLedgerTransfers(time.Now())
+		if err != nil {
+			logger.Log("transfers", fmt.Sprintf("problem listing transfers: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if transfers == nil {
+			transfers = []*ledgerTransfer{}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(transfers)
+	}
+}
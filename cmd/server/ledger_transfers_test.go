@@ -0,0 +1,258 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// createTestLedger returns in-memory repositories holding an account with each balance.
+func createTestLedger(t *testing.T, balances map[string]int) (*inMemoryAccountRepository, *inMemoryTransactionRepository) {
+	t.Helper()
+
+	accountRepo, transactionRepo := newInMemoryRepositories()
+	for id, balance := range balances {
+		acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: id, RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+		if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+			t.Fatal(err)
+		}
+		if balance == 0 {
+			continue
+		}
+		deposit := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{{AccountID: id, Purpose: ACHCredit, Amount: balance}}}
+		if err := transactionRepo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return accountRepo, transactionRepo
+}
+
+// testLedgerPeer serves another accounts instance's transaction routes, failing requests on demand.
+type testLedgerPeer struct {
+	*httptest.Server
+
+	accounts *inMemoryAccountRepository
+
+	down        bool // every request fails
+	failCommits bool // commits fail without being applied
+	loseCommits bool // commits are applied but their response fails
+}
+
+func newTestLedgerPeer(t *testing.T) *testLedgerPeer {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"settlement": 1000, "destination": 0})
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &mockEventPublisher{}, nil, time.Now)
+
+	peer := &testLedgerPeer{accounts: accountRepo}
+	peer.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		commit := strings.HasSuffix(r.URL.Path, "/commit")
+		switch {
+		case peer.down, commit && peer.failCommits:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case commit && peer.loseCommits:
+			router.ServeHTTP(httptest.NewRecorder(), r)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			router.ServeHTTP(w, r)
+		}
+	}))
+	return peer
+}
+
+func createTestLedgerTransferService(t *testing.T, peer *testLedgerPeer) (*ledgerTransferService, *inMemoryAccountRepository) {
+	t.Helper()
+
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"source": 2000, "program2": 0})
+	db := database.CreateTestSqliteDB(t)
+
+	svc := &ledgerTransferService{
+		logger:       log.NewNopLogger(),
+		repo:         &sqlLedgerTransferRepository{db.DB, log.NewNopLogger()},
+		transactions: &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}},
+		peers: map[string]*ledgerPeer{
+			"program2": {Name: "program2", URL: peer.URL, LocalAccountID: "program2", RemoteAccountID: "settlement", client: peer.Client()},
+		},
+	}
+	return svc, accountRepo
+}
+
+func checkBalances(t *testing.T, repo accountRepository, balances map[string]int32) {
+	t.Helper()
+	for id, balance := range balances {
+		accts, err := repo.GetAccounts(context.Background(), []string{id})
+		if err != nil || len(accts) != 1 {
+			t.Fatalf("accounts=%#v error=%v", accts, err)
+		}
+		if accts[0].Balance != balance {
+			t.Errorf("account=%s balance=%d expected %d", id, accts[0].Balance, balance)
+		}
+	}
+}
+
+func TestLedgerTransfers__readLedgerPeers(t *testing.T) {
+	check := func(v string) (map[string]*ledgerPeer, error) {
+		os.Setenv("LEDGER_PEERS", v)
+		defer os.Unsetenv("LEDGER_PEERS")
+		return readLedgerPeers()
+	}
+	if peers, err := check(""); err != nil || len(peers) != 0 {
+		t.Errorf("peers=%#v error=%v", peers, err)
+	}
+	peers, err := check("program2:a:b:https://accounts.program2:8085/, program3:c:d:http://accounts.program3")
+	if err != nil || len(peers) != 2 {
+		t.Fatalf("peers=%#v error=%v", peers, err)
+	}
+	if p := peers["program2"]; p.URL != "https://accounts.program2:8085" || p.LocalAccountID != "a" || p.RemoteAccountID != "b" {
+		t.Errorf("unexpected peer: %#v", p)
+	}
+	for _, v := range []string{"program2:a:b", "program2::b:https://accounts", "program2:a:b:accounts", "p:a:b:http://x,p:a:b:http://y"} {
+		if _, err := check(v); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+}
+
+func TestLedgerTransfers__commit(t *testing.T) {
+	peer := newTestLedgerPeer(t)
+	defer peer.Close()
+	svc, accountRepo := createTestLedgerTransferService(t, peer)
+	defer svc.repo.Close()
+
+	router := mux.NewRouter()
+	addLedgerTransferRoutes(log.NewNopLogger(), router, svc)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", base.ID())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	w := serve("POST", "/accounts/transfers", `{"peer": "program2", "sourceAccountId": "source", "destinationAccountId": "destination", "amount": 250}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var transfer ledgerTransfer
+	if err := json.NewDecoder(w.Body).Decode(&transfer); err != nil {
+		t.Fatal(err)
+	}
+	if transfer.Status != ledgerTransferCommitted || !uuidRegex.MatchString(transfer.ID) {
+		t.Errorf("unexpected transfer: %#v", transfer)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"source": 1750, "program2": 250})
+	checkBalances(t, peer.accounts, map[string]int32{"settlement": 750, "destination": 250})
+
+	if w := serve("GET", "/accounts/transfers/"+transfer.ID, ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"committed"`) {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve("GET", "/accounts/transfers/"+ledgerTransferID(), ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// unknown peers and transfers we can't fund are rejected before the peer is asked
+	if w := serve("POST", "/accounts/transfers", `{"peer": "other", "sourceAccountId": "source", "destinationAccountId": "destination", "amount": 250}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("POST", "/accounts/transfers", `{"peer": "program2", "sourceAccountId": "source", "destinationAccountId": "destination", "amount": 5000}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
+
+func TestLedgerTransfers__refused(t *testing.T) {
+	peer := newTestLedgerPeer(t)
+	defer peer.Close()
+	svc, accountRepo := createTestLedgerTransferService(t, peer)
+	defer svc.repo.Close()
+	ctx := context.Background()
+
+	// our settlement account on the peer can't fund the transfer, so our hold is released
+	if _, err := svc.CreateLedgerTransfer(ctx, createLedgerTransferRequest{Peer: "program2", SourceAccountID: "source", DestinationAccountID: "destination", Amount: 600}); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Errorf("expected refusal: %v", err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"source": 2000, "program2": 0})
+	checkBalances(t, peer.accounts, map[string]int32{"settlement": 1000, "destination": 0})
+}
+
+func TestLedgerTransfers__reconcile(t *testing.T) {
+	peer := newTestLedgerPeer(t)
+	defer peer.Close()
+	svc, accountRepo := createTestLedgerTransferService(t, peer)
+	defer svc.repo.Close()
+	ctx := context.Background()
+
+	create := func(status ledgerTransferStatus) *ledgerTransfer {
+		t.Helper()
+		transfer, err := svc.CreateLedgerTransfer(ctx, createLedgerTransferRequest{Peer: "program2", SourceAccountID: "source", DestinationAccountID: "destination", Amount: 100})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transfer.Status != status {
+			t.Fatalf("transfer=%s is %s", transfer.ID, transfer.Status)
+		}
+		return transfer
+	}
+	status := func(transfer *ledgerTransfer) ledgerTransferStatus {
+		t.Helper()
+		found, err := svc.GetLedgerTransfer(ctx, transfer.ID)
+		if err != nil || found == nil {
+			t.Fatalf("found=%#v error=%v", found, err)
+		}
+		return found.Status
+	}
+	later := time.Now().Add(2 * time.Minute)
+
+	// the peer never received the transfer, so it's aborted once the peer's hold would have expired
+	peer.down = true
+	unreachable := create(ledgerTransferPrepared)
+	checkBalances(t, accountRepo, map[string]int32{"source": 1900})
+	if n, err := svc.reconcile(ctx, later); err != nil || n != 0 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+	peer.down = false
+	if n, err := svc.reconcile(ctx, later); err != nil || n != 0 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+	if n, err := svc.reconcile(ctx, unreachable.ExpiresAt.Add(time.Second)); err != nil || n != 1 || status(unreachable) != ledgerTransferAborted {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"source": 2000, "program2": 0})
+
+	// the peer holds the transfer but didn't commit it
+	peer.failCommits = true
+	held := create(ledgerTransferCommitting)
+	if n, err := svc.reconcile(ctx, later); err != nil || n != 0 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+	peer.failCommits = false
+	if n, err := svc.reconcile(ctx, later); err != nil || n != 1 || status(held) != ledgerTransferCommitted {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+
+	// the peer committed the transfer but we didn't hear back
+	peer.loseCommits = true
+	lost := create(ledgerTransferCommitting)
+	peer.loseCommits = false
+	if n, err := svc.reconcile(ctx, later); err != nil || n != 1 || status(lost) != ledgerTransferCommitted {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+
+	checkBalances(t, accountRepo, map[string]int32{"source": 1800, "program2": 200})
+	checkBalances(t, peer.accounts, map[string]int32{"settlement": 800, "destination": 200})
+}
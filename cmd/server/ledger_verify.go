@@ -0,0 +1,160 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+// ledgerVerification is the result of checking a ledger database for internal consistency.
+type ledgerVerification struct {
+	Accounts     int `json:"accounts"`
+	Transactions int `json:"transactions"`
+
+	// Problems describes each inconsistency found. An empty list means the ledger verified.
+	Problems []string `json:"problems"`
+}
+
+func (v *ledgerVerification) ok() bool {
+	return len(v.Problems) == 0
+}
+
+func (v *ledgerVerification) problem(format string, args ...interface{}) {
+	v.Problems = append(v.Problems, fmt.Sprintf(format, args...))
+}
+
+// verifyLedger runs the integrity checks against a ledger database:
+//   - every transaction line belongs to a transaction, and every transaction has lines
+//   - every transaction has a known status
+//   - every account's balance checkpoint matches its transaction lines and daily summaries
+func verifyLedger(repo *sqlTransactionRepository) (*ledgerVerification, error) {
+	out := &ledgerVerification{Problems: []string{}}
+
+	if err := repo.db.QueryRow(`select count(*) from transactions where deleted_at is null;`).Scan(&out.Transactions); err != nil {
+		return nil, fmt.Errorf("verifyLedger: transactions: %v", err)
+	}
+
+	var n int
+	query := `select count(*) from (
+select transaction_id from transaction_lines union all select transaction_id from transaction_lines_archive
+) lines where transaction_id not in (select transaction_id from transactions);`
+	if err := repo.db.QueryRow(query).Scan(&n); err != nil {
+		return nil, fmt.Errorf("verifyLedger: orphaned lines: %v", err)
+	}
+	if n > 0 {
+		out.problem("%d transaction lines without a transaction", n)
+	}
+
+	query = `select count(*) from transactions where transaction_id not in (select transaction_id from transaction_lines)
+and transaction_id not in (select transaction_id from transaction_lines_archive);`
+	if err := repo.db.QueryRow(query).Scan(&n); err != nil {
+		return nil, fmt.Errorf("verifyLedger: empty transactions: %v", err)
+	}
+	if n > 0 {
+		out.problem("%d transactions without lines", n)
+	}
+
+	query = `select count(*) from transactions where status not in ('pending', 'posted', 'voided', 'reversed', 'failed', 'held');`
+	if err := repo.db.QueryRow(query).Scan(&n); err != nil {
+		return nil, fmt.Errorf("verifyLedger: statuses: %v", err)
+	}
+	if n > 0 {
+		out.problem("%d transactions with an unknown status", n)
+	}
+
+	// Compare every account's balance checkpoint against its history
+	query = `select account_id from account_balances union select account_id from transaction_lines
+union select account_id from account_daily_summaries;`
+	rows, err := repo.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("verifyLedger: accounts: %v", err)
+	}
+	var accountIDs []string
+	for rows.Next() {
+		var accountID string
+		if err := rows.Scan(&accountID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("verifyLedger: accounts scan: %v", err)
+		}
+		accountIDs = append(accountIDs, accountID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("verifyLedger: accounts: %v", err)
+	}
+	out.Accounts = len(accountIDs)
+
+	for i := range accountIDs {
+		result, err := repo.repairAccountBalance(accountIDs[i], false)
+		if err != nil {
+			return nil, fmt.Errorf("verifyLedger: %v", err)
+		}
+		if result.Drift != 0 {
+			out.problem("account=%s balance=%d expected=%d drift=%d", result.AccountID, result.Actual, result.Expected, result.Drift)
+		}
+	}
+
+	return out, nil
+}
+
+// verifyRestore restores a copy of the SQLite database into a temporary directory and verifies it with SQLite's
+// integrity check and the ledger's. The copy comes from source when it's set (a backup file), otherwise from the
+// litestream replica.
+func verifyRestore(ctx context.Context, logger log.Logger, source string) (*ledgerVerification, error) {
+	dir, err := ioutil.TempDir("", "accounts-verify")
+	if err != nil {
+		return nil, fmt.Errorf("verifyRestore: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	name := filepath.Base(database.SQLitePath())
+	path := filepath.Join(dir, name)
+	if source != "" {
+		if err := copyFile(source, path); err != nil {
+			return nil, fmt.Errorf("verifyRestore: %v", err)
+		}
+	} else {
+		if err := database.RestoreSQLiteReplica(ctx, logger, name, path); err != nil {
+			return nil, fmt.Errorf("verifyRestore: %v", err)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, errors.New("verifyRestore: nothing was restored")
+	}
+
+	result, err := verifySQLiteFile(ctx, logger, path)
+	if err != nil {
+		return nil, fmt.Errorf("verifyRestore: %v", err)
+	}
+	return result, nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
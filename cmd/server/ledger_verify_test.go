@@ -0,0 +1,80 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestLedgerVerify(t *testing.T) {
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+
+	repo := createTestSqlTransactionRepository(t, sqliteDB.DB)
+
+	account1, account2 := base.ID(), base.ID()
+	repo.accountRepo = &testAccountRepository{
+		accounts: []*accounts.Account{
+			{ID: account1, RoutingNumber: defaultRoutingNumber},
+			{ID: account2, RoutingNumber: "121042882"},
+		},
+	}
+	tx := transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now(),
+		Lines: []transactionLine{
+			{AccountID: account1, Purpose: ACHDebit, Amount: 500},
+			{AccountID: account2, Purpose: ACHCredit, Amount: 500},
+		},
+	}
+	if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := verifyLedger(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.ok() || result.Accounts != 2 || result.Transactions != 1 {
+		t.Errorf("unexpected result: %#v", result)
+	}
+
+	// A restored copy of the database verifies the same way
+	result, err = verifyRestore(context.Background(), log.NewNopLogger(), filepath.Join(sqliteDB.Dir, "accounts.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.ok() || result.Transactions != 1 {
+		t.Errorf("unexpected result: %#v", result)
+	}
+
+	// Corrupt the ledger
+	if _, err := repo.db.Exec(`update account_balances set balance = balance + 10 where account_id = ?`, account2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.db.Exec(`insert into transaction_lines(transaction_id, account_id, purpose, amount, created_at) values (?, ?, 'achcredit', 1, ?)`, base.ID(), account2, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	result, err = verifyLedger(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Problems) != 2 {
+		t.Errorf("unexpected problems: %#v", result.Problems)
+	}
+
+	if _, err := verifyRestore(context.Background(), log.NewNopLogger(), filepath.Join(sqliteDB.Dir, "missing.db")); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Segments are optional dimensions (department, product and region) on transaction lines which are used to
+// allocate postings for internal P&L reporting. Each segment only accepts the values configured for it.
+type lineSegment string
+
+const (
+	segmentDepartment lineSegment = "department"
+	segmentProduct    lineSegment = "product"
+	segmentRegion     lineSegment = "region"
+)
+
+var lineSegmentEnvVars = map[lineSegment]string{
+	segmentDepartment: "LINE_SEGMENT_DEPARTMENTS",
+	segmentProduct:    "LINE_SEGMENT_PRODUCTS",
+	segmentRegion:     "LINE_SEGMENT_REGIONS",
+}
+
+func (s lineSegment) validate() error {
+	if _, ok := lineSegmentEnvVars[s]; !ok {
+		return fmt.Errorf("unknown segment %q, expected department, product or region", s)
+	}
+	return nil
+}
+
+// value returns the line's value for the segment.
+func (s lineSegment) value(line transactionLine) string {
+	switch s {
+	case segmentDepartment:
+		return line.Department
+	case segmentProduct:
+		return line.Product
+	case segmentRegion:
+		return line.Region
+	}
+	return ""
+}
+
+// segmentValues are the values each segment accepts.
+type segmentValues map[lineSegment]map[string]bool
+
+// configuredSegments is read from LINE_SEGMENT_DEPARTMENTS, LINE_SEGMENT_PRODUCTS and LINE_SEGMENT_REGIONS on startup.
+var configuredSegments = segmentValues{}
+
+// readSegmentValues reads the comma separated values of each segment. Segments without values can't be set on lines.
+func readSegmentValues() segmentValues {
+	out := make(segmentValues)
+	for segment, key := range lineSegmentEnvVars {
+		for _, v := range strings.Split(os.Getenv(key), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				if out[segment] == nil {
+					out[segment] = make(map[string]bool)
+				}
+				out[segment][v] = true
+			}
+		}
+	}
+	return out
+}
+
+// check returns an error if any of the line's segments has a value which isn't configured.
+func (values segmentValues) check(line transactionLine) error {
+	for _, segment := range []lineSegment{segmentDepartment, segmentProduct, segmentRegion} {
+		v := segment.value(line)
+		if v == "" || values[segment][v] {
+			continue
+		}
+		if len(values[segment]) == 0 {
+			return fmt.Errorf("transactionLine: AccountID=%s %s=%q is invalid, no values are configured in %s", line.AccountID, segment, v, lineSegmentEnvVars[segment])
+		}
+		return fmt.Errorf("transactionLine: AccountID=%s %s=%q is invalid", line.AccountID, segment, v)
+	}
+	return nil
+}
+
+// segmentTotal is the sum of posted lines with one value of a segment. Lines without the segment are totaled
+// with an empty value.
+type segmentTotal struct {
+	Value   string `json:"value"`
+	Credits int64  `json:"credits"`
+	Debits  int64  `json:"debits"`
+	Net     int64  `json:"net"`
+	Lines   int64  `json:"lines"`
+}
+
+func (t *segmentTotal) add(line transactionLine) {
+	if line.Purpose == ACHDebit {
+		t.Debits += int64(line.Amount)
+	} else {
+		t.Credits += int64(line.Amount)
+	}
+	t.Net = t.Credits - t.Debits
+	t.Lines++
+}
+
+// segmentTotalsQuery selects which lines are totaled.
+type segmentTotalsQuery struct {
+	Segment lineSegment
+
+	// AccountID optionally limits the totals to one account's lines.
+	AccountID string
+
+	// Since and Until bound the transaction timestamps included, when set. Until is exclusive.
+	Since, Until time.Time
+}
+
+func (q segmentTotalsQuery) includes(t *transaction, line transactionLine) bool {
+	if t.Status != TransactionPosted && t.Status != TransactionReversed {
+		return false
+	}
+	if q.AccountID != "" && line.AccountID != q.AccountID {
+		return false
+	}
+	if !q.Since.IsZero() && t.Timestamp.Before(q.Since) {
+		return false
+	}
+	return q.Until.IsZero() || t.Timestamp.Before(q.Until)
+}
+
+// mergeSegmentTotals combines totals of the same value and orders them by value.
+func mergeSegmentTotals(totals []segmentTotal) []segmentTotal {
+	byValue := make(map[string]*segmentTotal)
+	for i := range totals {
+		t, ok := byValue[totals[i].Value]
+		if !ok {
+			t = &segmentTotal{Value: totals[i].Value}
+			byValue[t.Value] = t
+		}
+		t.Credits += totals[i].Credits
+		t.Debits += totals[i].Debits
+		t.Lines += totals[i].Lines
+		t.Net = t.Credits - t.Debits
+	}
+	out := make([]segmentTotal, 0, len(byValue))
+	for _, t := range byValue {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Value < out[j].Value })
+	return out
+}
+
+// segmentTotals is an admin route which totals posted lines by a ?segment (department, product or region),
+// optionally for one ?accountId and between ?since and ?until (RFC 3339 timestamps).
+func segmentTotals(logger log.Logger, repo transactionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q := segmentTotalsQuery{
+			Segment:   lineSegment(strings.ToLower(r.URL.Query().Get("segment"))),
+			AccountID: r.URL.Query().Get("accountId"),
+		}
+		if err := q.Segment.validate(); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		for param, when := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+			if v := r.URL.Query().Get(param); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					moovhttp.Problem(w, fmt.Errorf("invalid %s: %v", param, err))
+					return
+				}
+				*when = t
+			}
+		}
+		totals, err := repo.getSegmentTotals(q)
+		if err != nil {
+			logger.Log("segments", fmt.Sprintf("problem totaling %s segments: %v", q.Segment, err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if totals == nil {
+			totals = []segmentTotal{}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(totals)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/moov-io/base"
+)
+
+func TestLineSegments__readSegmentValues(t *testing.T) {
+	os.Setenv("LINE_SEGMENT_DEPARTMENTS", " sales, ops ,,")
+	defer os.Unsetenv("LINE_SEGMENT_DEPARTMENTS")
+
+	values := readSegmentValues()
+	if !values[segmentDepartment]["sales"] || !values[segmentDepartment]["ops"] || len(values[segmentDepartment]) != 2 {
+		t.Errorf("unexpected departments: %#v", values)
+	}
+	if len(values[segmentProduct]) != 0 || len(values[segmentRegion]) != 0 {
+		t.Errorf("unexpected values: %#v", values)
+	}
+
+	if err := values.check(transactionLine{AccountID: "a", Department: "sales"}); err != nil {
+		t.Error(err)
+	}
+	if err := values.check(transactionLine{AccountID: "a", Department: "legal"}); err == nil {
+		t.Error("expected error")
+	}
+	if err := values.check(transactionLine{AccountID: "a", Region: "emea"}); err == nil || !strings.Contains(err.Error(), "LINE_SEGMENT_REGIONS") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLineSegments__validate(t *testing.T) {
+	defer func(values segmentValues) { configuredSegments = values }(configuredSegments)
+	configuredSegments = segmentValues{segmentProduct: {"cards": true}}
+
+	line := transactionLine{AccountID: base.ID(), Purpose: ACHCredit, Amount: 100, Product: "cards"}
+	if err := line.validate(); err != nil {
+		t.Error(err)
+	}
+	line.Product = "loans"
+	if err := line.validate(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestLineSegments__totals(t *testing.T) {
+	defer func(values segmentValues) { configuredSegments = values }(configuredSegments)
+	configuredSegments = segmentValues{
+		segmentDepartment: {"sales": true, "ops": true},
+		segmentProduct:    {"cards": true},
+		segmentRegion:     {"emea": true},
+	}
+
+	check := func(t *testing.T, repo transactionRepository) {
+		now := time.Now()
+		post := func(status TransactionStatus, when time.Time, lines ...transactionLine) {
+			t.Helper()
+			tx := transaction{ID: base.ID(), Timestamp: when, Status: status, Lines: lines}
+			if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		post(TransactionPosted, now,
+			transactionLine{AccountID: "expenses", Purpose: ACHDebit, Amount: 500, Department: "sales"},
+			transactionLine{AccountID: "cash", Purpose: ACHCredit, Amount: 500},
+		)
+		post(TransactionPosted, now.Add(-48*time.Hour),
+			transactionLine{AccountID: "expenses", Purpose: ACHDebit, Amount: 200, Department: "ops"},
+			transactionLine{AccountID: "cash", Purpose: ACHCredit, Amount: 200, Department: "ops"},
+		)
+		post(TransactionPending, now,
+			transactionLine{AccountID: "expenses", Purpose: ACHDebit, Amount: 1000, Department: "sales"},
+			transactionLine{AccountID: "cash", Purpose: ACHCredit, Amount: 1000, Department: "sales"},
+		)
+
+		totals, err := repo.getSegmentTotals(segmentTotalsQuery{Segment: segmentDepartment})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []segmentTotal{
+			{Value: "", Credits: 500, Net: 500, Lines: 1},
+			{Value: "ops", Credits: 200, Debits: 200, Lines: 2},
+			{Value: "sales", Debits: 500, Net: -500, Lines: 1},
+		}
+		if !reflect.DeepEqual(totals, expected) {
+			t.Errorf("totals=%#v", totals)
+		}
+
+		totals, err = repo.getSegmentTotals(segmentTotalsQuery{Segment: segmentDepartment, AccountID: "expenses", Since: now.Add(-time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != 1 || totals[0] != (segmentTotal{Value: "sales", Debits: 500, Net: -500, Lines: 1}) {
+			t.Errorf("totals=%#v", totals)
+		}
+
+		totals, err = repo.getSegmentTotals(segmentTotalsQuery{Segment: segmentRegion, Until: now.Add(-time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != 1 || totals[0] != (segmentTotal{Credits: 200, Debits: 200, Lines: 2}) {
+			t.Errorf("totals=%#v", totals)
+		}
+	}
+
+	_, transactionRepo := createTestLedger(t, map[string]int{})
+	check(t, transactionRepo)
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	repo := createTestSqlTransactionRepository(t, sqliteDB.DB)
+	defer repo.Close()
+	check(t, repo)
+}
+
+func TestLineSegments__sql(t *testing.T) {
+	defer func(values segmentValues) { configuredSegments = values }(configuredSegments)
+	configuredSegments = segmentValues{
+		segmentDepartment: {"sales": true, "ops": true},
+		segmentProduct:    {"cards": true},
+		segmentRegion:     {"emea": true},
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	repo := createTestSqlTransactionRepository(t, sqliteDB.DB)
+	defer repo.Close()
+
+	tx := transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now().Add(-72 * time.Hour),
+		Status:    TransactionPosted,
+		Lines: []transactionLine{
+			{AccountID: base.ID(), Purpose: ACHDebit, Amount: 300, Department: "sales", Product: "cards", Region: "emea"},
+			{AccountID: base.ID(), Purpose: ACHCredit, Amount: 300},
+		},
+	}
+	if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		t.Fatal(err)
+	}
+	found, err := repo.getTransaction(context.Background(), tx.ID)
+	if err != nil || found == nil {
+		t.Fatalf("transaction=%#v error=%v", found, err)
+	}
+	if !reflect.DeepEqual(found.Lines, tx.Lines) && !reflect.DeepEqual(found.Lines, []transactionLine{tx.Lines[1], tx.Lines[0]}) {
+		t.Errorf("unexpected lines: %#v", found.Lines)
+	}
+
+	// archived lines are still totaled
+	if _, err := sqliteDB.DB.Exec(`update transaction_lines set created_at = ?`, tx.Timestamp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.compactTransactionLines(time.Now().Add(-24 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	totals, err := repo.getSegmentTotals(segmentTotalsQuery{Segment: segmentProduct})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 2 || totals[1] != (segmentTotal{Value: "cards", Debits: 300, Net: -300, Lines: 1}) {
+		t.Errorf("totals=%#v", totals)
+	}
+}
+
+func TestLineSegments__route(t *testing.T) {
+	_, transactionRepo := createTestLedger(t, map[string]int{})
+	handler := segmentTotals(log.NewNopLogger(), transactionRepo)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, target, nil))
+		w.Flush()
+		return w
+	}
+
+	w := serve("GET", "/transactions/segments?segment=Department&since=2020-01-01T00:00:00Z")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var totals []segmentTotal
+	if err := json.NewDecoder(w.Body).Decode(&totals); err != nil || totals == nil {
+		t.Errorf("totals=%#v error=%v", totals, err)
+	}
+
+	if w := serve("GET", "/transactions/segments?segment=team"); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("GET", "/transactions/segments?segment=region&until=yesterday"); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("POST", "/transactions/segments?segment=region"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
@@ -7,8 +7,10 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -31,6 +33,21 @@ var (
 	adminAddr = flag.String("admin.addr", bind.Admin("accounts"), "Admin HTTP listen address")
 
 	flagLogFormat = flag.String("log.format", "", "Format for log lines (Options: json, plain")
+	flagStorage   = flag.String("storage", "", "Storage engine for accounts and transactions (Options: sqlite, mysql, memory)")
+
+	flagVerifyRestore = flag.Bool("verify.restore", false, "Restore the SQLite database into a temporary directory, verify its ledger and exit")
+	flagVerifySource  = flag.String("verify.source", "", "SQLite backup file to verify with -verify.restore instead of the litestream replica")
+
+	flagRestoreSQLite = flag.String("restore.sqlite", "", "SQLite backup (filepath or s3:// URL) to verify and restore over -restore.target, then exit")
+	flagRestoreTarget = flag.String("restore.target", "", "SQLite database replaced by -restore.sqlite (Default: SQLITE_DB_PATH)")
+
+	flagMigrateStatus   = flag.Bool("migrate.status", false, "Print the migrations of every database and which are applied, then exit")
+	flagMigrateRollback = flag.Int("migrate.rollback", -1, "Revert the migrations of every database after this version, then exit")
+
+	flagImportJournal   = flag.String("import.journal", "", "CSV or XLSX journal entries to import through the admin server and exit")
+	flagImportAdmin     = flag.String("import.admin", "", "Admin server to import -import.journal through (Default: -admin.addr)")
+	flagImportDryRun    = flag.Bool("import.dry-run", false, "Only check -import.journal and report its problems")
+	flagImportBatchSize = flag.Int("import.batch-size", defaultJournalImportBatchSize, "Entries of -import.journal posted between saving the import's progress")
 )
 
 func main() {
@@ -50,6 +67,124 @@ func main() {
 
 	logger.Log("main", fmt.Sprintf("Starting moov/accounts server version %s", app.Version))
 
+	// -storage picks the engine for accounts and transactions unless their environment variables are set
+	if *flagStorage != "" {
+		for _, key := range []string{"ACCOUNT_STORAGE_TYPE", "TRANSACTION_STORAGE_TYPE"} {
+			if os.Getenv(key) == "" {
+				os.Setenv(key, *flagStorage)
+			}
+		}
+	}
+
+	// Prove backups are restorable rather than starting the server
+	if *flagVerifyRestore {
+		result, err := verifyRestore(context.Background(), logger, *flagVerifySource)
+		if err != nil {
+			logger.Log("verify", err)
+			os.Exit(1)
+		}
+		json.NewEncoder(os.Stdout).Encode(result)
+		if !result.ok() {
+			logger.Log("verify", fmt.Sprintf("ledger verification found %d problems", len(result.Problems)))
+			os.Exit(1)
+		}
+		logger.Log("verify", fmt.Sprintf("verified %d accounts and %d transactions", result.Accounts, result.Transactions))
+		os.Exit(0)
+	}
+
+	// Replace the SQLite database with a verified backup while the server is stopped
+	if *flagRestoreSQLite != "" {
+		result, err := restoreSQLiteBackup(context.Background(), logger, nil, *flagRestoreSQLite, or(*flagRestoreTarget, database.SQLitePath()))
+		if result != nil {
+			json.NewEncoder(os.Stdout).Encode(result)
+		}
+		if err != nil {
+			logger.Log("restore", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// List or roll back the migrations of every database while the server is stopped
+	if *flagMigrateStatus || *flagMigrateRollback >= 0 {
+		migrations, err := rollbackDatabases(context.Background(), logger, *flagMigrateRollback)
+		if migrations != nil {
+			json.NewEncoder(os.Stdout).Encode(migrations)
+		}
+		if err != nil {
+			logger.Log("migrations", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Import journal entries into a running server rather than starting one
+	if *flagImportJournal != "" {
+		if *flagImportAdmin == "" {
+			*flagImportAdmin = "localhost" + *adminAddr
+		}
+		client := newJournalImportClient(*flagImportAdmin, "journal-import-cli")
+		if *flagImportDryRun {
+			report, err := client.dryRun(context.Background(), *flagImportJournal)
+			if err != nil {
+				logger.Log("import", err)
+				os.Exit(1)
+			}
+			json.NewEncoder(os.Stdout).Encode(report)
+			if !report.Valid {
+				logger.Log("import", fmt.Sprintf("found %d problems in %s", len(report.Problems), *flagImportJournal))
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+		imp, err := client.importJournal(context.Background(), *flagImportJournal, *flagImportBatchSize)
+		if err != nil {
+			logger.Log("import", err)
+			os.Exit(1)
+		}
+		json.NewEncoder(os.Stdout).Encode(imp)
+		logger.Log("import", fmt.Sprintf("posted import=%s with %d entries", imp.ID, len(imp.Entries)))
+		os.Exit(0)
+	}
+
+	// Generate deterministic IDs when configured
+	if gen, err := readIDGenerator(); err != nil {
+		panic(err.Error())
+	} else {
+		newID = gen
+	}
+
+	// Read the values transaction lines can be allocated to
+	configuredSegments = readSegmentValues()
+
+	// Read the merchant categories card transactions can't be posted with
+	if blocklist, err := readMCCBlocklist(); err != nil {
+		panic(err.Error())
+	} else {
+		blockedMCCs = blocklist
+	}
+
+	// Read the currency of the ledger's amounts, which responses include formatting hints for
+	if code, err := readLedgerCurrency(); err != nil {
+		panic(err.Error())
+	} else {
+		ledgerCurrency = code
+	}
+
+	// Read the time zone days and months start in for accounts without their own
+	if loc, err := readLedgerTimeZone(); err != nil {
+		panic(err.Error())
+	} else {
+		ledgerLocation = loc
+	}
+
+	// Read the Intuit bank ID Quicken expects in QFX statements
+	if bankID, err := readStatementQFXBankID(); err != nil {
+		panic(err.Error())
+	} else {
+		statementQFXBankID = bankID
+	}
+
 	// Check for default routing number
 	if defaultRoutingNumber == "" { // accounts.go
 		logger.Log("main", "No default routing number specified, please set DEFAULT_ROUTING_NUMBER")
@@ -60,6 +195,13 @@ func main() {
 	ctx, cancelFunc := context.WithCancel(context.Background())
 	defer cancelFunc()
 
+	// Export spans over OTLP when configured
+	shutdownTracing, err := setupTracing(ctx, logger, app.Version)
+	if err != nil {
+		panic(err.Error())
+	}
+	defer shutdownTracing(context.Background())
+
 	// Channel for errors
 	errs := make(chan error)
 	go func() {
@@ -73,9 +215,20 @@ func main() {
 		*adminAddr = v
 	}
 
+	// Restrict which networks can call the admin and public routes when allowlists are configured
+	policies, err := readOriginPolicies()
+	if err != nil {
+		panic(err.Error())
+	}
+
 	// Start Admin server (with Prometheus metrics)
-	adminServer := admin.NewServer(*adminAddr)
-	adminServer.AddVersionHandler(app.Version) // Setup 'GET /version'
+	adminBindAddr := *adminAddr
+	if len(policies.admin) > 0 {
+		adminBindAddr = ":0" // loopback, behind the allowlist proxy
+	}
+	adminServer := admin.NewServer(adminBindAddr)
+	info := newBuildInfo()
+	adminServer.AddHandler("/version", info.ServeHTTP)
 	go func() {
 		logger.Log("admin", fmt.Sprintf("listening on %s", adminServer.BindAddr()))
 		if err := adminServer.Listen(); err != nil {
@@ -85,39 +238,763 @@ func main() {
 		}
 	}()
 	defer adminServer.Shutdown()
+	if len(policies.admin) > 0 {
+		adminProxy := newAdminProxy(logger, *adminAddr, adminServer.BindAddr(), policies)
+		go func() {
+			logger.Log("admin", fmt.Sprintf("listening on %s for %d allowed networks", *adminAddr, len(policies.admin)))
+			if err := adminProxy.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				err = fmt.Errorf("problem starting admin http: %v", err)
+				logger.Log("admin", err)
+				errs <- err
+			}
+		}()
+		defer adminProxy.Shutdown(context.TODO())
+	}
 
-	// Setup Account storage
-	accountsDB, err := database.New(ctx, logger, or(os.Getenv("ACCOUNT_STORAGE_TYPE"), "sqlite"))
+	// Report panics and storage problems to Sentry when configured
+	var reporter errorReporter
+	if sentry, err := newSentryReporter(logger, os.Getenv("SENTRY_DSN")); err != nil {
+		panic(err.Error())
+	} else if sentry != nil {
+		reporter = sentry
+		info.enable("sentry")
+	}
+
+	// Run as the active or a passive region, where passive regions reject writes and report replication lag
+	regionName, regionRole, err := readRegion()
 	if err != nil {
-		panic(fmt.Sprintf("error connecting to accounts database: %v", err))
+		panic(err.Error())
+	}
+	var reg *region
+	if regionName != "" {
+		regionDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to region database: %v", err))
+		}
+		regionRepo := &sqlRegionRepository{regionDB, logger}
+		defer regionRepo.Close()
+		reg = newRegion(logger, regionName, regionRole, regionRepo)
+		adminServer.AddHandler("/region", getRegion(logger, reg))
+		adminServer.AddHandler("/region/promote", promoteRegion(logger, reg))
+		info.enable("region")
+		logger.Log("main", fmt.Sprintf("starting region=%s as %s", regionName, regionRole))
 	}
-	accountRepo, err := setupSqlAccountStorage(context.Background(), logger, accountsDB)
+
+	// Setup Account storage
+	inMemory, err := memoryStorage()
 	if err != nil {
-		panic(fmt.Sprintf("account storage: %v", err))
+		panic(err.Error())
+	}
+	if foreignKeys, err := database.ForeignKeys(); err != nil {
+		panic(err.Error())
+	} else if foreignKeys && !inMemory {
+		info.enable("foreign-keys")
+	}
+	var memoryAccountRepo *inMemoryAccountRepository
+	var memoryTransactionRepo *inMemoryTransactionRepository
+	if inMemory {
+		memoryAccountRepo, memoryTransactionRepo = newInMemoryRepositories()
+	}
+	var accountRepo accountRepository
+	if inMemory {
+		accountRepo = memoryAccountRepo
+	} else if shards := storageShards(); shards > 1 {
+		accountRepo, err = setupShardedAccountStorage(ctx, logger, or(os.Getenv("ACCOUNT_STORAGE_TYPE"), "sqlite"), shards)
+		if err != nil {
+			panic(fmt.Sprintf("sharded account storage: %v", err))
+		}
+	} else {
+		accountsDB, err := database.New(ctx, logger, or(os.Getenv("ACCOUNT_STORAGE_TYPE"), "sqlite"))
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to accounts database: %v", err))
+		}
+		accountRepo, err = setupSqlAccountStorage(context.Background(), logger, accountsDB)
+		if err != nil {
+			panic(fmt.Sprintf("account storage: %v", err))
+		}
+	}
+
+	// Mirror writes into shadow storage backends while migrating between them
+	shadows := &shadowReport{}
+	if _type := os.Getenv("ACCOUNT_SHADOW_STORAGE_TYPE"); _type != "" {
+		shadowDB, err := database.NewShadow(ctx, logger, _type)
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to accounts shadow database: %v", err))
+		}
+		shadowRepo, err := setupSqlAccountStorage(context.Background(), logger, shadowDB)
+		if err != nil {
+			panic(fmt.Sprintf("account shadow storage: %v", err))
+		}
+		accountRepo = &dualWriteAccountRepository{primary: accountRepo, shadow: shadowRepo, logger: logger, report: shadows}
+	}
+	if reporter != nil {
+		accountRepo = newReportingAccountRepository(accountRepo, reporter)
+	}
+	accountRepo = &instrumentedAccountRepository{accountRepo}
+	accountRepo = &tracedAccountRepository{accountRepo}
+	if reg != nil {
+		accountRepo = &regionAccountRepository{accountRepo, reg}
 	}
 	defer accountRepo.Close()
 	logger.Log("main", fmt.Sprintf("using %T for account storage", accountRepo))
-	adminServer.AddLivenessCheck("accounts", accountRepo.Ping)
+	adminServer.AddReadinessCheck("accounts", accountRepo.Ping)
+
+	// Create the ledger accounts the bank owns, which transaction lines and settings can name as "system:<name>"
+	if names, err := readSystemAccountNames(); err != nil {
+		panic(err.Error())
+	} else if len(names) > 0 {
+		configuredSystemAccounts, err = bootstrapSystemAccounts(ctx, logger, accountRepo, names)
+		if err != nil {
+			// Passive regions can't write, but the active region creates the same accounts
+			if reg == nil || reg.active() {
+				panic(err.Error())
+			}
+			logger.Log("accounts", err.Error())
+		}
+		adminServer.AddHandler("/system-accounts", getSystemAccounts(logger, configuredSystemAccounts))
+		info.enable("system-accounts")
+	}
 
 	// Setup Transaction storage
-	transactionsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
-	if err != nil {
-		panic(fmt.Sprintf("error connecting to transactions database: %v", err))
+	var transactionRepo transactionRepository
+	if inMemory {
+		transactionRepo = memoryTransactionRepo
+	} else if shards := storageShards(); shards > 1 {
+		if err := checkShardedEngines(shards); err != nil {
+			panic(err.Error())
+		}
+		transactionRepo, err = setupShardedTransactionStorage(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"), shards)
+		if err != nil {
+			panic(fmt.Sprintf("sharded transaction storage: %v", err))
+		}
+	} else {
+		transactionsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to transactions database: %v", err))
+		}
+		repo, err := setupSqlTransactionStorage(context.Background(), logger, transactionsDB)
+		if err != nil {
+			panic(fmt.Sprintf("transaction storage: %v", err))
+		}
+		if err := setupConnectionPools(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"), repo); err != nil {
+			panic(fmt.Sprintf("transaction storage: %v", err))
+		}
+		transactionRepo = repo
 	}
-	transactionRepo, err := setupSqlTransactionStorage(context.Background(), logger, transactionsDB)
-	if err != nil {
-		panic(fmt.Sprintf("transaction storage: %v", err))
+	ledgers := sqlLedgers(transactionRepo)
+	if constraints, err := readBalanceConstraints(); err != nil {
+		panic(err.Error())
+	} else {
+		// Clear constraints from earlier deployments when none are set
+		for i := range ledgers {
+			if err := ledgers[i].setBalanceConstraints(constraints); err != nil {
+				panic(fmt.Sprintf("balance constraints: %v", err))
+			}
+		}
+		if len(constraints) > 0 && len(ledgers) > 0 {
+			info.enable("balance-constraints")
+		}
+	}
+	if reg != nil {
+		// Fence postings with our epoch so a region we were failed over from can't keep posting
+		reg.fence(ledgers)
+		if err := reg.start(); err != nil {
+			panic(err.Error())
+		}
+		go reg.run(ctx, regionHeartbeatInterval())
+	}
+	if _type := os.Getenv("TRANSACTION_SHADOW_STORAGE_TYPE"); _type != "" {
+		shadowDB, err := database.NewShadow(ctx, logger, _type)
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to transactions shadow database: %v", err))
+		}
+		shadowRepo, err := setupSqlTransactionStorage(context.Background(), logger, shadowDB)
+		if err != nil {
+			panic(fmt.Sprintf("transaction shadow storage: %v", err))
+		}
+		transactionRepo = &dualWriteTransactionRepository{primary: transactionRepo, shadow: shadowRepo, logger: logger, report: shadows}
+	}
+	if reporter != nil {
+		transactionRepo = newReportingTransactionRepository(transactionRepo, reporter)
+	}
+	transactionRepo = &instrumentedTransactionRepository{transactionRepo}
+	transactionRepo = &tracedTransactionRepository{transactionRepo}
+	if reg != nil {
+		transactionRepo = &regionTransactionRepository{transactionRepo, reg}
 	}
 	defer transactionRepo.Close()
 	logger.Log("main", fmt.Sprintf("using %T for transaction storage", transactionRepo))
-	adminServer.AddLivenessCheck("transactions", transactionRepo.Ping)
+	adminServer.AddReadinessCheck("transactions", transactionRepo.Ping)
+	adminServer.AddHandler("/storage/shadow", shadows.ServeHTTP)
+	adminServer.AddHandler("/accounts/{accountId}/balance/repair", repairAccountBalance(logger, transactionRepo))
+	adminServer.AddHandler("/transactions/segments", segmentTotals(logger, transactionRepo))
+	adminServer.AddHandler("/transactions/mcc-spend", getMCCSpend(logger, transactionRepo))
+	adminServer.AddHandler("/trial-balance", getTrialBalance(logger, accountRepo, transactionRepo))
+	adminServer.AddHandler("/trial-balance/accounts/{accountId}", getTrialBalanceAccount(logger, accountRepo, transactionRepo))
+	adminServer.AddHandler("/trial-balance/transactions/{transactionId}", getTrialBalanceTransaction(logger, transactionRepo))
+
+	// Check postings against segment budgets
+	budgetsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to budgets database: %v", err))
+	}
+	budgetRepo := &sqlBudgetRepository{budgetsDB, logger}
+	defer budgetRepo.Close()
+	transactionRepo = &budgetingTransactionRepository{transactionRepository: transactionRepo, budgets: budgetRepo, logger: logger}
+	adminServer.AddHandler("/budgets", budgets(logger, budgetRepo))
+	adminServer.AddHandler("/budgets/report", budgetReport(logger, budgetRepo, transactionRepo))
+	adminServer.AddHandler("/budgets/{budgetId}", deleteBudget(logger, budgetRepo))
+
+	// Accounts are assigned products which bundle their rates, fees, limits, statement cycle and features
+	productsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to products database: %v", err))
+	}
+	productRepo := &sqlProductRepository{productsDB, logger}
+	defer productRepo.Close()
+	catalog := &productCatalog{repo: productRepo}
+	transactionRepo = &productTransactionRepository{transactionRepository: transactionRepo, products: catalog}
+	productMigrationSvc := &productMigrationService{logger: logger, repo: productRepo, accounts: accountRepo}
+	adminServer.AddHandler("/products", products(logger, productRepo))
+	adminServer.AddHandler("/products/migrations", productMigrations(logger, productMigrationSvc))
+	adminServer.AddHandler("/products/migrations/{migrationId}", productMigrationByID(logger, productMigrationSvc))
+	adminServer.AddHandler("/products/{productId}", productByID(logger, productRepo))
+	adminServer.AddHandler("/accounts/{accountId}/product", accountProductAssignment(logger, productRepo))
+
+	// Check debits against each account's daily ATM, POS and ACH debit limits
+	defaultLimits, err := readDailyLimits()
+	if err != nil {
+		panic(err.Error())
+	}
+	accountLimitsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to account limits database: %v", err))
+	}
+	accountLimitRepo := &sqlAccountLimitRepository{accountLimitsDB, logger}
+	defer accountLimitRepo.Close()
+	dailyLimits := &accountLimits{repo: accountLimitRepo, defaults: defaultLimits, products: catalog}
+	transactionRepo = &limitingTransactionRepository{transactionRepository: transactionRepo, limits: dailyLimits}
+	adminServer.AddHandler("/accounts/{accountId}/limits/{class}", accountLimitOverride(logger, accountLimitRepo))
+
+	// Decline card transactions from abroad unless the account has a travel notice for them
+	homeCountry, err := readCardHomeCountry()
+	if err != nil {
+		panic(err.Error())
+	}
+	travelNoticesDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to travel notices database: %v", err))
+	}
+	travelNoticeRepo := &sqlTravelNoticeRepository{travelNoticesDB, logger}
+	defer travelNoticeRepo.Close()
+	if homeCountry != "" {
+		transactionRepo = &geoBlockingTransactionRepository{transactionRepository: transactionRepo, homeCountry: homeCountry, notices: travelNoticeRepo}
+	}
+
+	// Setup storage of transaction attachment references
+	attachmentsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to attachments database: %v", err))
+	}
+	attachmentRepo := &sqlAttachmentRepository{attachmentsDB, logger}
+	defer attachmentRepo.Close()
+
+	// Strip counterparty details from transactions once they're older than our retention period
+	retentionYears, err := piiRetentionYears()
+	if err != nil {
+		panic(err.Error())
+	}
+	adminServer.AddHandler("/transactions/anonymize", anonymizeTransactions(logger, &anonymizationService{
+		logger:         logger,
+		attachments:    attachmentRepo,
+		transactions:   transactionRepo,
+		retentionYears: retentionYears,
+	}))
+
+	// Generate statements for every account in a cycle from the admin port
+	statementsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to statements database: %v", err))
+	}
+	statementRepo := &sqlStatementRepository{statementsDB, logger}
+	defer statementRepo.Close()
+	statements := newStatementGenerator(logger, accountRepo, transactionRepo, statementRepo)
+	statements.products = catalog
+	adminServer.AddHandler("/statements/runs", statementRuns(logger, statements))
+	adminServer.AddHandler("/statements/runs/{runId}", getStatementRun(logger, statementRepo))
+	adminServer.AddHandler("/statements/runs/{runId}/resume", resumeStatementRun(logger, statements))
+	adminServer.AddHandler("/accounts/{accountId}/statements", getAccountStatements(logger, statementRepo))
+	adminServer.AddHandler("/accounts/{accountId}/statements/{cycle}", getAccountStatement(logger, statements))
+	adminServer.AddHandler("/accounts/{accountId}/statements/{cycle}/regenerate", regenerateStatement(logger, statements))
+	adminServer.AddHandler("/accounts/{accountId}/statements/{cycle}/disputes", statementDisputesRoute(logger, statements))
+	adminServer.AddHandler("/accounts/{accountId}/statements/{cycle}/disputes/{disputeId}/annotations", annotateStatementDispute(logger, statements))
+	autoGenerate, err := statementAutoGenerate()
+	if err != nil {
+		panic(err.Error())
+	}
+	if autoGenerate {
+		setupStatementJob(ctx, logger, statements, time.Hour)
+		info.enable("statement-auto-generate")
+	}
+
+	// Export everything held on a customer for data portability requests
+	exportsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to customer exports database: %v", err))
+	}
+	exportRepo := &sqlCustomerExportRepository{exportsDB, logger}
+	defer exportRepo.Close()
+	exporter := newCustomerExporter(logger, accountRepo, transactionRepo, statementRepo, exportRepo)
+
+	// Compact old transaction lines into daily summaries
+	setupCompactionJob(ctx, logger, transactionRepo, compactionDays())
+
+	// Keep each account's daily totals current for statements, interest and balance history
+	if interval, err := readDailyTotalsInterval(); err != nil {
+		panic(err.Error())
+	} else {
+		setupDailyTotalsJob(ctx, logger, ledgers, interval)
+	}
+	adminServer.AddHandler("/accounts/{accountId}/balance-history", getBalanceHistory(logger, transactionRepo))
+
+	// Publish events to a webhook endpoint, NATS JetStream or AWS when configured, otherwise log them
+	encoding, err := readEventEncoding()
+	if err != nil {
+		panic(err.Error())
+	}
+	var publishers multiEventPublisher
+	if url := os.Getenv("WEBHOOK_URL"); url != "" {
+		webhooksDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to webhooks database: %v", err))
+		}
+		webhookRepo := &sqlWebhookRepository{webhooksDB, logger}
+		defer webhookRepo.Close()
+
+		publisher := newWebhookPublisher(logger, url, webhookRepo, encoding)
+		adminServer.AddHandler("/webhooks/deliveries", getWebhookDeliveries(logger, webhookRepo))
+		adminServer.AddHandler("/webhooks/deliveries/{deliveryId}", getWebhookDelivery(logger, webhookRepo))
+		adminServer.AddHandler("/webhooks/redeliver", redeliverWebhooks(logger, publisher))
+		publishers = append(publishers, publisher)
+		info.enable("webhooks")
+		logger.Log("main", fmt.Sprintf("publishing events to webhook %s", url))
+	}
+	if url := os.Getenv("NATS_URL"); url != "" {
+		publisher, err := newNATSPublisher(logger, url, or(os.Getenv("NATS_SUBJECT_PREFIX"), "accounts"), encoding)
+		if err != nil {
+			panic(err.Error())
+		}
+		defer publisher.Close()
+		publishers = append(publishers, publisher)
+		info.enable("nats")
+		logger.Log("main", fmt.Sprintf("publishing events to NATS JetStream at %s", url))
+	}
+	if publisher, err := setupAWSPublisher(logger, encoding); err != nil {
+		panic(err.Error())
+	} else if publisher != nil {
+		defer publisher.Close()
+		publishers = append(publishers, publisher)
+		info.enable("aws")
+		logger.Log("main", "publishing events to AWS")
+	}
+	var events eventPublisher = publishers
+	if len(publishers) == 0 {
+		events = &loggingEventPublisher{logger}
+	}
+
+	// Deliver transaction events to webhooks customers have registered on their accounts
+	accountWebhooksDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to account webhooks database: %v", err))
+	}
+	accountWebhookRepo := &sqlAccountWebhookRepository{accountWebhooksDB, logger}
+	defer accountWebhookRepo.Close()
+	events = multiEventPublisher{events, newAccountWebhookPublisher(logger, accountWebhookRepo, encoding)}
+
+	// Record activity features of each account for fraud signals
+	activityScore, err := accountActivityEventScore()
+	if err != nil {
+		panic(err.Error())
+	}
+	activityDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to account activity database: %v", err))
+	}
+	activityRepo := &sqlAccountActivityRepository{activityDB, logger}
+	defer activityRepo.Close()
+	activity := newActivityRecorder(logger, activityRepo, events, activityScore)
+	go activity.run(ctx)
+	transactionRepo = &activityTransactionRepository{transactionRepository: transactionRepo, activity: activity}
+	adminServer.AddHandler("/accounts/activity", getActiveAccounts(logger, activity))
+	adminServer.AddHandler("/accounts/{accountId}/activity", getAccountActivity(logger, activity))
+
+	// Sandbox instances post reversals and run the fee, interest and recurring engines on a virtual clock callers can advance
+	clock := time.Now
+	var sandboxClk *sandboxClock
+	if sandboxEnabled() {
+		sandboxClk = &sandboxClock{wall: time.Now}
+		clock = sandboxClk.Now
+	}
+
+	// Accrue cash-back rewards on debits into each account's rewards account
+	rewardsRates, err := readRewardsRates()
+	if err != nil {
+		panic(err.Error())
+	}
+	var rewards *rewardsService
+	if rewardsRates != nil {
+		fundingAccountID := configuredSystemAccounts.resolve(os.Getenv("REWARDS_FUNDING_ACCOUNT_ID"))
+		if fundingAccountID == "" {
+			panic("REWARDS_RATES requires REWARDS_FUNDING_ACCOUNT_ID")
+		}
+		rewardsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to rewards database: %v", err))
+		}
+		rewardsRepo := &sqlRewardsRepository{rewardsDB, logger}
+		defer rewardsRepo.Close()
+		rewards = &rewardsService{
+			logger:           logger,
+			repo:             rewardsRepo,
+			accounts:         accountRepo,
+			transactions:     &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock},
+			rates:            rewardsRates,
+			fundingAccountID: fundingAccountID,
+		}
+		transactionRepo = &rewardsTransactionRepository{transactionRepository: transactionRepo, rewards: rewards}
+		adminServer.AddHandler("/rewards/reconciliation", getRewardsReconciliation(logger, rewards))
+		info.enable("rewards")
+		logger.Log("main", fmt.Sprintf("accruing rewards funded by account=%s", fundingAccountID))
+	}
+
+	// Read the interest rate tiers and fees accounts are projected and charged with
+	projectionRules, err := readProjectionRules()
+	if err != nil {
+		panic(err.Error())
+	}
+	projectionRules.products = catalog
+
+	// Charge the fees of each account's product, credited to FEE_ACCOUNT_ID
+	var fees *feeEngine
+	if feeAccountID := configuredSystemAccounts.resolve(os.Getenv("FEE_ACCOUNT_ID")); feeAccountID != "" {
+		fees = &feeEngine{
+			logger:       logger,
+			accountID:    feeAccountID,
+			rules:        projectionRules,
+			accounts:     accountRepo,
+			transactions: &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock},
+			now:          clock,
+		}
+		transactionRepo = &feeTransactionRepository{transactionRepository: transactionRepo, fees: fees}
+		setupFeeJob(ctx, logger, fees, time.Minute)
+		adminServer.AddHandler("/fees/monthly", chargeMonthlyFees(logger, fees))
+		info.enable("fees")
+		logger.Log("main", fmt.Sprintf("charging fees to account=%s", feeAccountID))
+	}
+
+	// Accrue interest daily at the rate tiers of each account's product, paid monthly from INTEREST_EXPENSE_ACCOUNT_ID
+	var interest *interestService
+	if expenseAccountID := configuredSystemAccounts.resolve(os.Getenv("INTEREST_EXPENSE_ACCOUNT_ID")); expenseAccountID != "" {
+		interestDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to interest database: %v", err))
+		}
+		interestRepo := &sqlInterestRepository{interestDB, logger}
+		defer interestRepo.Close()
+		interest = &interestService{
+			logger:           logger,
+			repo:             interestRepo,
+			accounts:         accountRepo,
+			transactions:     &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock},
+			expenseAccountID: expenseAccountID,
+			rules:            projectionRules,
+			now:              clock,
+		}
+		setupInterestJob(ctx, logger, interest, time.Minute)
+		adminServer.AddHandler("/interest/postings", postInterest(logger, interest))
+		adminServer.AddHandler("/accounts/{accountId}/interest", getAccountInterest(logger, interest))
+		info.enable("interest")
+		logger.Log("main", fmt.Sprintf("accruing interest paid from account=%s", expenseAccountID))
+	}
+
+	// Score postings with an external fraud service which can decline them or hold them for review
+	fraudReviewsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to fraud reviews database: %v", err))
+	}
+	fraudReviewRepo := &sqlFraudReviewRepository{fraudReviewsDB, logger}
+	defer fraudReviewRepo.Close()
+	fraud, err := setupFraudScreen(logger, fraudReviewRepo, travelNoticeRepo)
+	if err != nil {
+		panic(err.Error())
+	}
+	if fraud != nil {
+		reviews := &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock, fraud: fraud}
+		adminServer.AddHandler("/transactions/fraud-reviews", getFraudReviews(logger, reviews))
+		adminServer.AddHandler("/transactions/fraud-reviews/{transactionId}/approve", reviewFraudHold(logger, reviews, fraudReviewApproved))
+		adminServer.AddHandler("/transactions/fraud-reviews/{transactionId}/decline", reviewFraudHold(logger, reviews, fraudReviewDeclined))
+		info.enable("fraud-scoring")
+		logger.Log("main", fmt.Sprintf("scoring postings with %s", os.Getenv("FRAUD_SCORING_URL")))
+	}
+
+	// Post transactions from a command queue
+	if consumer, err := setupCommandConsumer(logger, &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock, fraud: fraud}, events); err != nil {
+		panic(err.Error())
+	} else if consumer != nil {
+		go consumer.run(ctx)
+	}
+
+	// Abort held transactions which weren't committed or aborted before they expired
+	setupHoldExpiryJob(ctx, logger, &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock}, time.Minute)
+
+	// Find (and repair) rows left behind by partial failures. The transaction tables are read directly, so this
+	// isn't available with memory or sharded storage.
+	if !inMemory && storageShards() == 1 {
+		orphansDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to orphaned data database: %v", err))
+		}
+		orphanRepo := &sqlOrphanRepository{orphansDB, logger}
+		defer orphanRepo.Close()
+		adminServer.AddHandler("/orphans", orphanedData(logger, &orphanDetector{
+			logger:       logger,
+			repo:         orphanRepo,
+			accounts:     accountRepo,
+			transactions: &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock},
+		}))
+	}
+
+	// Post transactions from recurring rules (such as monthly fees) as they're due
+	recurringDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to recurring rules database: %v", err))
+	}
+	recurringRepo := &sqlRecurringRuleRepository{recurringDB, logger}
+	defer recurringRepo.Close()
+	recurring := &recurringService{
+		logger:       logger,
+		repo:         recurringRepo,
+		accounts:     accountRepo,
+		transactions: &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock, fraud: fraud},
+		now:          clock,
+	}
+	setupRecurringJob(ctx, logger, recurring, time.Minute)
+
+	// Post corrections which bypass balance checks once a second operator approves them
+	forcePostsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to force posts database: %v", err))
+	}
+	forcePostRepo := &sqlForcePostRepository{forcePostsDB, logger}
+	defer forcePostRepo.Close()
+	forcePostSvc := &forcePostService{logger: logger, repo: forcePostRepo, transactions: &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock}}
+	adminServer.AddHandler("/transactions/force-posts", forcePosts(logger, forcePostSvc))
+	adminServer.AddHandler("/transactions/force-posts/{forcePostId}", getForcePost(logger, forcePostSvc))
+	adminServer.AddHandler("/transactions/force-posts/{forcePostId}/approve", reviewForcePost(logger, forcePostSvc, forcePostApproved))
+	adminServer.AddHandler("/transactions/force-posts/{forcePostId}/reject", reviewForcePost(logger, forcePostSvc, forcePostRejected))
+
+	// Preview and post spreadsheets of journal entries from the admin port
+	journalImportsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to journal imports database: %v", err))
+	}
+	journalImportRepo := &sqlJournalImportRepository{journalImportsDB, logger}
+	defer journalImportRepo.Close()
+	journalImportSvc := &journalImportService{logger: logger, repo: journalImportRepo, accounts: accountRepo, transactions: &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock}}
+	adminServer.AddHandler("/transactions/imports", journalImports(logger, journalImportSvc))
+	adminServer.AddHandler("/transactions/imports/{importId}", getJournalImport(logger, journalImportSvc))
+	adminServer.AddHandler("/transactions/imports/{importId}/post", postJournalImport(logger, journalImportSvc))
+
+	// Package audit evidence for a period into a signed archive
+	evidence := &evidenceService{
+		logger:         logger,
+		signingKey:     []byte(os.Getenv("EVIDENCE_SIGNING_KEY")),
+		forcePosts:     forcePostRepo,
+		journalImports: journalImportRepo,
+		ledgers:        ledgers,
+	}
+	adminServer.AddHandler("/evidence", exportEvidence(logger, evidence))
+
+	// Take online backups of the SQLite ledger databases
+	backups := &sqliteBackupService{logger: logger, ledgers: ledgers, target: os.Getenv("SQLITE_BACKUP_TARGET")}
+	adminServer.AddHandler("/sqlite/backups", backupSQLite(logger, backups))
+
+	// Arrange internal accounts into a chart of accounts with rolled-up balances
+	chartDB, err := database.New(ctx, logger, or(os.Getenv("ACCOUNT_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to chart of accounts database: %v", err))
+	}
+	chartRepo := &sqlChartRepository{chartDB, logger}
+	defer chartRepo.Close()
+	chart := &chartService{logger: logger, repo: chartRepo, accounts: accountRepo}
+	adminServer.AddHandler("/chart/nodes", chartNodes(logger, chart))
+	adminServer.AddHandler("/chart/nodes/{nodeId}", chartNodeRoute(logger, chart))
+	adminServer.AddHandler("/chart/nodes/{nodeId}/balances", chartNodeBalances(logger, chart))
+
+	// Save the lines of recurring manual entries to post transactions from
+	templatesDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to transaction templates database: %v", err))
+	}
+	templateRepo := &sqlTransactionTemplateRepository{templatesDB, logger}
+	defer templateRepo.Close()
+	templates := &transactionTemplateService{logger: logger, repo: templateRepo, transactions: &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock, fraud: fraud}}
+
+	// Transfer funds to accounts on other accounts instances
+	peers, err := readLedgerPeers()
+	if err != nil {
+		panic(err.Error())
+	}
+	var transfers *ledgerTransferService
+	if len(peers) > 0 {
+		transfersDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to transfers database: %v", err))
+		}
+		transferRepo := &sqlLedgerTransferRepository{transfersDB, logger}
+		defer transferRepo.Close()
+
+		transfers = &ledgerTransferService{
+			logger:       logger,
+			repo:         transferRepo,
+			transactions: &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock},
+			peers:        peers,
+		}
+		setupLedgerTransferReconciliation(ctx, logger, transfers, time.Minute)
+		adminServer.AddHandler("/transfers", getUnfinishedLedgerTransfers(logger, transfers))
+		info.enable("ledger-transfers")
+		logger.Log("main", fmt.Sprintf("transferring to %d ledger peers", len(peers)))
+
+		// Net the obligations with each peer into one settlement transaction per day
+		if accountID := configuredSystemAccounts.resolve(os.Getenv("NETTING_SETTLEMENT_ACCOUNT_ID")); accountID != "" {
+			cutoff, err := readNettingCutoff()
+			if err != nil {
+				panic(err.Error())
+			}
+			nettingRepo := &sqlNettingRepository{transfersDB, logger}
+			netting := &nettingService{
+				logger:              logger,
+				repo:                nettingRepo,
+				transactions:        transfers.transactions,
+				peers:               peers,
+				settlementAccountID: accountID,
+			}
+			setupNettingJob(ctx, logger, netting, cutoff)
+			adminServer.AddHandler("/netting/runs", nettingRuns(logger, netting))
+			info.enable("netting")
+			logger.Log("main", fmt.Sprintf("netting ledger peer obligations daily at %v %s", cutoff, ledgerLocation))
+		}
+	}
+
+	// Move accounts between products once their migrations are effective, notifying each account
+	productMigrationSvc.events = events
+	setupProductMigrationJob(ctx, logger, productMigrationSvc, time.Minute)
+
+	// Grant promotional credits from a marketing account, clawing them back when their conditions are met
+	promotionsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to promotions database: %v", err))
+	}
+	promotionRepo := &sqlPromotionRepository{promotionsDB, logger}
+	defer promotionRepo.Close()
+	promotionSvc := &promotionService{
+		logger:           logger,
+		repo:             promotionRepo,
+		accounts:         accountRepo,
+		transactions:     &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock},
+		fundingAccountID: configuredSystemAccounts.resolve(os.Getenv("PROMOTIONS_FUNDING_ACCOUNT_ID")),
+	}
+	adminServer.AddHandler("/promotions", promotions(logger, promotionSvc))
+	adminServer.AddHandler("/promotions/{promotionId}", getPromotion(logger, promotionSvc))
+	adminServer.AddHandler("/promotions/{promotionId}/reversal", reversePromotion(logger, promotionSvc))
+
+	// Pay referral bonuses as promotions once referred customers' accounts qualify
+	referralProgram, err := readReferralProgram()
+	if err != nil {
+		panic(err.Error())
+	}
+	if referralProgram != nil {
+		if promotionSvc.fundingAccountID == "" {
+			panic("referral bonuses require PROMOTIONS_FUNDING_ACCOUNT_ID")
+		}
+		referralsDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+		if err != nil {
+			panic(fmt.Sprintf("error connecting to referrals database: %v", err))
+		}
+		referralRepo := &sqlReferralRepository{referralsDB, logger}
+		defer referralRepo.Close()
+		referralSvc := &referralService{
+			logger:       logger,
+			repo:         referralRepo,
+			accounts:     accountRepo,
+			transactions: transactionRepo,
+			promotions:   promotionSvc,
+			program:      referralProgram,
+		}
+		setupReferralJob(ctx, logger, referralSvc, time.Minute)
+		adminServer.AddHandler("/referrals", referrals(logger, referralSvc))
+		adminServer.AddHandler("/referrals/report", getReferralReport(logger, referralSvc))
+		adminServer.AddHandler("/referrals/{referralId}", getReferral(logger, referralSvc))
+		info.enable("referrals")
+		logger.Log("main", fmt.Sprintf("referrals pay referrers %d and referees %d", referralProgram.ReferrerBonus, referralProgram.RefereeBonus))
+	}
+
+	// Let privileged callers advance a virtual clock on sandbox instances
+	if sandboxEnabled() {
+		sb, err := newSandbox(logger, sandboxClk, accountRepo, &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock}, interest, fees, recurring)
+		if err != nil {
+			panic(err.Error())
+		}
+		adminServer.AddHandler("/sandbox/clock", getSandboxClock(logger, sb))
+		adminServer.AddHandler("/sandbox/clock/advance", advanceSandboxClock(logger, sb))
+		adminServer.AddHandler("/sandbox/scenarios", loadSandboxScenario(logger, sb))
+
+		// Resetting customers and snapshots copy tables, so every table must be in one database
+		storageType := or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite")
+		if !inMemory && storageShards() == 1 && strings.EqualFold(storageType, or(os.Getenv("ACCOUNT_STORAGE_TYPE"), "sqlite")) {
+			sandboxDB, err := database.New(ctx, logger, storageType)
+			if err != nil {
+				panic(fmt.Sprintf("error connecting to sandbox database: %v", err))
+			}
+			sandboxRepo := &sqlSandboxRepository{sandboxDB, logger}
+			defer sandboxRepo.Close()
+			adminServer.AddHandler("/sandbox/customers/{customerId}", resetSandboxCustomer(logger, sb, sandboxRepo))
+			adminServer.AddHandler("/sandbox/snapshots", sandboxSnapshots(logger, sb, sandboxRepo))
+			adminServer.AddHandler("/sandbox/snapshots/{name}", deleteSandboxSnapshot(logger, sandboxRepo))
+			adminServer.AddHandler("/sandbox/snapshots/{name}/restore", restoreSandboxSnapshot(logger, sb, sandboxRepo))
+		} else {
+			logger.Log("main", "sandbox resets and snapshots are unavailable with memory, sharded or mixed storage types")
+		}
+		info.enable("sandbox")
+		logger.Log("main", "sandbox mode enabled")
+	}
 
 	// Setup business HTTP routes
 	router := mux.NewRouter()
+	router.Use(nameSpanByRoute)
 	moovhttp.AddCORSHandler(router)
 	addPingRoute(logger, router)
 	addAccountRoutes(logger, router, accountRepo, transactionRepo)
-	addTransactionRoutes(logger, router, accountRepo, transactionRepo)
+	addAccountClosureRoutes(logger, router, &accountClosureService{
+		logger:       logger,
+		accounts:     accountRepo,
+		transactions: &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, clock: clock},
+		promotions:   promotionSvc,
+	})
+	addProjectionRoutes(logger, router, accountRepo, projectionRules)
+	addTransactionRoutes(logger, router, accountRepo, transactionRepo, attachmentRepo, events, fraud, clock)
+	addAccountLimitRoutes(logger, router, accountRepo, transactionRepo, dailyLimits)
+	addTravelNoticeRoutes(logger, router, accountRepo, travelNoticeRepo)
+	addAccountWebhookRoutes(logger, router, accountRepo, accountWebhookRepo)
+	addCustomerExportRoutes(logger, router, exporter)
+	addTransactionTemplateRoutes(logger, router, templates)
+	addRecurringRuleRoutes(logger, router, recurring)
+	if transfers != nil {
+		addLedgerTransferRoutes(logger, router, transfers)
+	}
+	if rewards != nil {
+		addRewardsRoutes(logger, router, rewards)
+	}
 
 	// Start business HTTP server
 	readTimeout, _ := time.ParseDuration("30s")
@@ -129,9 +1006,64 @@ func main() {
 		*httpAddr = v
 	}
 
+	// Limit concurrent requests per route group so reports can't starve postings
+	limits, err := bulkheadLimits()
+	if err != nil {
+		panic(err.Error())
+	}
+
+	// Let machine callers authenticate with HMAC signed requests
+	signingKeys, err := readSigningKeys()
+	if err != nil {
+		panic(err.Error())
+	}
+	// Accept JWTs from our identity provider for service-to-service calls
+	jwtCfg, err := readJWTConfig()
+	if err != nil {
+		panic(err.Error())
+	}
+	// Map partners' client certificates to their tenant when mTLS is enabled
+	clientIDs, err := readClientIdentities()
+	if err != nil {
+		panic(err.Error())
+	}
+	// Scope every request to the caller's organization
+	orgRequired, err := organizationRequired()
+	if err != nil {
+		panic(err.Error())
+	}
+	// Record every mutating request in the audit log
+	auditDB, err := database.New(ctx, logger, or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite"))
+	if err != nil {
+		panic(fmt.Sprintf("error connecting to audit log database: %v", err))
+	}
+	auditRepo := &sqlAuditRepository{auditDB, logger}
+	defer auditRepo.Close()
+	adminServer.AddHandler("/audit", getAuditLog(logger, auditRepo))
+	// Limit how fast each tenant can post transactions
+	rateLimits, err := readRateLimits()
+	if err != nil {
+		panic(err.Error())
+	}
+	// Rewrite responses for clients built against the legacy JSON format
+	jsonCompat, err := readJSONCompatibilityMode()
+	if err != nil {
+		panic(err.Error())
+	}
+	var handler http.Handler = newBulkhead(logger, router, limits, bulkheadQueueTimeout())
+	handler = newRateLimiter(logger, rateLimits, handler) // inside organizations, so postings are counted against their tenant
+	handler = newAuditLog(logger, auditRepo, handler)     // inside the authenticators, so entries record the caller
+	handler = newRegionGuard(logger, reg, handler)
+	handler = newOrganizations(logger, orgRequired, handler)  // after authentication sets X-Tenant-ID
+	handler = newClientIdentities(logger, clientIDs, handler) // inside the other authenticators, so certificates override headers from tokens
+	handler = newRequestSigning(logger, signingKeys, handler)
+	handler = newJWTAuth(logger, jwtCfg, signingKeys, clientIDs, handler)
+	handler = newTenantReset(handler)                           // outside the authenticators, so callers can't send their own X-Tenant-ID
+	handler = newJSONCompatibility(logger, jsonCompat, handler) // outside the authenticators, so their errors are rewritten too
+
 	serve := &http.Server{
 		Addr:    *httpAddr,
-		Handler: router,
+		Handler: newAccessLog(logger, newTracing(policies.guard(logger, "public", policies.public, newRecovery(logger, reporter, handler)))),
 		TLSConfig: &tls.Config{
 			InsecureSkipVerify:       false,
 			PreferServerCipherSuites: true,
@@ -141,7 +1073,23 @@ func main() {
 		WriteTimeout: writTimeout,
 		IdleTimeout:  idleTimeout,
 	}
+	if err := setupClientAuth(serve.TLSConfig, clientIDs); err != nil {
+		panic(err.Error())
+	}
+	if serve.TLSConfig.ClientCAs != nil && (os.Getenv("HTTPS_CERT_FILE") == "" || os.Getenv("HTTPS_KEY_FILE") == "") {
+		panic("HTTPS_CLIENT_CA_FILE requires HTTPS_CERT_FILE and HTTPS_KEY_FILE")
+	}
+	if serve.TLSConfig.ClientCAs != nil {
+		info.enable("mtls")
+	}
+	if jwtCfg.jwksURL != "" {
+		info.enable("jwt")
+	}
+	// Only report ready while the HTTP server is accepting requests
+	var httpServing serving
+	adminServer.AddReadinessCheck("http", httpServing.check)
 	shutdownServer := func() {
+		httpServing.stop()
 		if err := serve.Shutdown(context.TODO()); err != nil {
 			logger.Log("main", err)
 		}
@@ -149,14 +1097,22 @@ func main() {
 
 	// Start business logic HTTP server
 	go func() {
+		listener, err := net.Listen("tcp", *httpAddr)
+		if err != nil {
+			logger.Log("main", err)
+			return
+		}
+		httpServing.start()
+		defer httpServing.stop()
+
 		if certFile, keyFile := os.Getenv("HTTPS_CERT_FILE"), os.Getenv("HTTPS_KEY_FILE"); certFile != "" && keyFile != "" {
 			logger.Log("main", fmt.Sprintf("binding to %s for secure HTTP server", *httpAddr))
-			if err := serve.ListenAndServeTLS(certFile, keyFile); err != nil {
+			if err := serve.ServeTLS(listener, certFile, keyFile); err != nil {
 				logger.Log("main", err)
 			}
 		} else {
 			logger.Log("main", fmt.Sprintf("binding to %s for HTTP server", *httpAddr))
-			if err := serve.ListenAndServe(); err != nil {
+			if err := serve.Serve(listener); err != nil {
 				logger.Log("main", err)
 			}
 		}
@@ -0,0 +1,544 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Card transactions credit the account which settles with the card network using card purpose lines, which carry
+// the merchant category code (MCC, ISO 18245) of the merchant. The cardholder is debited by the transaction's other
+// lines, so spending is totaled by the MCC of each transaction's card lines.
+
+var errBlockedMCC = errors.New("merchant category is blocked")
+
+// mccGambling is shorthand in MCC_BLOCKLIST for the gambling and lottery categories.
+var mccGambling = []string{"7800", "7801", "7802", "7995", "9406"}
+
+// mccRanges are the ISO 18245 blocks reserved for individual airlines, car rental agencies and hotel chains.
+var mccRanges = []struct {
+	first, last int
+	description string
+}{
+	{3000, 3299, "Airlines"},
+	{3300, 3499, "Car Rental Agencies"},
+	{3500, 3999, "Lodging – Hotels, Motels and Resorts"},
+}
+
+// mccDescriptions are the assigned ISO 18245 codes outside of mccRanges.
+var mccDescriptions = map[string]string{
+	"0742": "Veterinary Services",
+	"0763": "Agricultural Cooperatives",
+	"0780": "Landscaping and Horticultural Services",
+	"1520": "General Contractors – Residential and Commercial",
+	"1711": "Heating, Plumbing and Air Conditioning Contractors",
+	"1731": "Electrical Contractors",
+	"1740": "Masonry, Stonework, Tile Setting, Plastering and Insulation Contractors",
+	"1750": "Carpentry Contractors",
+	"1761": "Roofing, Siding and Sheet Metal Work Contractors",
+	"1771": "Concrete Work Contractors",
+	"1799": "Special Trade Contractors",
+	"2741": "Miscellaneous Publishing and Printing",
+	"2791": "Typesetting, Plate Making and Related Services",
+	"2842": "Specialty Cleaning, Polishing and Sanitation Preparations",
+	"4011": "Railroads",
+	"4111": "Local and Suburban Commuter Passenger Transportation",
+	"4112": "Passenger Railways",
+	"4119": "Ambulance Services",
+	"4121": "Taxicabs and Limousines",
+	"4131": "Bus Lines",
+	"4214": "Motor Freight Carriers and Trucking",
+	"4215": "Courier Services",
+	"4225": "Public Warehousing and Storage",
+	"4411": "Steamship and Cruise Lines",
+	"4457": "Boat Rentals and Leasing",
+	"4468": "Marinas, Marine Service and Supplies",
+	"4511": "Airlines and Air Carriers",
+	"4582": "Airports, Flying Fields and Airport Terminals",
+	"4722": "Travel Agencies and Tour Operators",
+	"4723": "Package Tour Operators",
+	"4784": "Tolls and Bridge Fees",
+	"4789": "Transportation Services",
+	"4812": "Telecommunication Equipment and Telephone Sales",
+	"4813": "Key-entry Telecom Merchants",
+	"4814": "Telecommunication Services",
+	"4815": "Monthly Summary Telephone Charges",
+	"4816": "Computer Network and Information Services",
+	"4821": "Telegraph Services",
+	"4829": "Wire Transfers and Money Orders",
+	"4899": "Cable, Satellite and Other Pay Television and Radio",
+	"4900": "Utilities – Electric, Gas, Water and Sanitary",
+	"5013": "Motor Vehicle Supplies and New Parts",
+	"5021": "Office and Commercial Furniture",
+	"5039": "Construction Materials",
+	"5044": "Photographic, Photocopy and Microfilm Equipment and Supplies",
+	"5045": "Computers, Peripherals and Software",
+	"5046": "Commercial Equipment",
+	"5047": "Medical, Dental, Ophthalmic and Hospital Equipment and Supplies",
+	"5051": "Metal Service Centers and Offices",
+	"5065": "Electrical Parts and Equipment",
+	"5072": "Hardware, Equipment and Supplies",
+	"5074": "Plumbing and Heating Equipment and Supplies",
+	"5085": "Industrial Supplies",
+	"5094": "Precious Stones and Metals, Watches and Jewelry",
+	"5099": "Durable Goods",
+	"5111": "Stationery, Office Supplies, Printing and Writing Paper",
+	"5122": "Drugs, Drug Proprietaries and Druggist Sundries",
+	"5131": "Piece Goods, Notions and Other Dry Goods",
+	"5137": "Uniforms and Commercial Clothing",
+	"5139": "Commercial Footwear",
+	"5169": "Chemicals and Allied Products",
+	"5172": "Petroleum and Petroleum Products",
+	"5192": "Books, Periodicals and Newspapers",
+	"5193": "Florists' Supplies, Nursery Stock and Flowers",
+	"5198": "Paints, Varnishes and Supplies",
+	"5199": "Nondurable Goods",
+	"5200": "Home Supply Warehouse Stores",
+	"5211": "Lumber and Building Materials Stores",
+	"5231": "Glass, Paint and Wallpaper Stores",
+	"5251": "Hardware Stores",
+	"5261": "Nurseries and Lawn and Garden Supply Stores",
+	"5262": "Marketplaces",
+	"5271": "Mobile Home Dealers",
+	"5300": "Wholesale Clubs",
+	"5309": "Duty Free Stores",
+	"5310": "Discount Stores",
+	"5311": "Department Stores",
+	"5331": "Variety Stores",
+	"5399": "Miscellaneous General Merchandise",
+	"5411": "Grocery Stores and Supermarkets",
+	"5422": "Freezer and Locker Meat Provisioners",
+	"5441": "Candy, Nut and Confectionery Stores",
+	"5451": "Dairy Products Stores",
+	"5462": "Bakeries",
+	"5499": "Convenience Stores and Specialty Markets",
+	"5511": "Car and Truck Dealers (New and Used)",
+	"5521": "Car and Truck Dealers (Used Only)",
+	"5531": "Auto and Home Supply Stores",
+	"5532": "Automotive Tire Stores",
+	"5533": "Automotive Parts and Accessories Stores",
+	"5541": "Service Stations",
+	"5542": "Automated Fuel Dispensers",
+	"5551": "Boat Dealers",
+	"5552": "Electric Vehicle Charging",
+	"5561": "Camper, Recreational and Utility Trailer Dealers",
+	"5571": "Motorcycle Shops and Dealers",
+	"5592": "Motor Home Dealers",
+	"5598": "Snowmobile Dealers",
+	"5599": "Miscellaneous Automotive, Aircraft and Farm Equipment Dealers",
+	"5611": "Men's and Boys' Clothing and Accessories Stores",
+	"5621": "Women's Ready-to-Wear Stores",
+	"5631": "Women's Accessory and Specialty Shops",
+	"5641": "Children's and Infants' Wear Stores",
+	"5651": "Family Clothing Stores",
+	"5655": "Sports and Riding Apparel Stores",
+	"5661": "Shoe Stores",
+	"5681": "Furriers and Fur Shops",
+	"5691": "Men's and Women's Clothing Stores",
+	"5697": "Tailors, Seamstresses, Mending and Alterations",
+	"5698": "Wig and Toupee Stores",
+	"5699": "Miscellaneous Apparel and Accessory Shops",
+	"5712": "Furniture, Home Furnishings and Equipment Stores",
+	"5713": "Floor Covering Stores",
+	"5714": "Drapery, Window Covering and Upholstery Stores",
+	"5718": "Fireplace and Fireplace Accessories Stores",
+	"5719": "Miscellaneous Home Furnishing Specialty Stores",
+	"5722": "Household Appliance Stores",
+	"5732": "Electronics Stores",
+	"5733": "Music Stores – Musical Instruments, Pianos and Sheet Music",
+	"5734": "Computer Software Stores",
+	"5735": "Record Stores",
+	"5811": "Caterers",
+	"5812": "Eating Places and Restaurants",
+	"5813": "Drinking Places – Bars, Taverns and Nightclubs",
+	"5814": "Fast Food Restaurants",
+	"5815": "Digital Goods – Books, Movies and Music",
+	"5816": "Digital Goods – Games",
+	"5817": "Digital Goods – Applications",
+	"5818": "Digital Goods – Large Digital Goods Merchants",
+	"5912": "Drug Stores and Pharmacies",
+	"5921": "Package Stores – Beer, Wine and Liquor",
+	"5931": "Used Merchandise and Secondhand Stores",
+	"5932": "Antique Shops",
+	"5933": "Pawn Shops",
+	"5935": "Wrecking and Salvage Yards",
+	"5937": "Antique Reproductions",
+	"5940": "Bicycle Shops",
+	"5941": "Sporting Goods Stores",
+	"5942": "Book Stores",
+	"5943": "Stationery, Office and School Supply Stores",
+	"5944": "Jewelry, Watch, Clock and Silverware Stores",
+	"5945": "Hobby, Toy and Game Shops",
+	"5946": "Camera and Photographic Supply Stores",
+	"5947": "Gift, Card, Novelty and Souvenir Shops",
+	"5948": "Luggage and Leather Goods Stores",
+	"5949": "Sewing, Needlework, Fabric and Piece Goods Stores",
+	"5950": "Glassware and Crystal Stores",
+	"5960": "Direct Marketing – Insurance Services",
+	"5962": "Direct Marketing – Travel Related Arrangement Services",
+	"5963": "Door-to-Door Sales",
+	"5964": "Direct Marketing – Catalog Merchants",
+	"5965": "Direct Marketing – Combination Catalog and Retail Merchants",
+	"5966": "Direct Marketing – Outbound Telemarketing Merchants",
+	"5967": "Direct Marketing – Inbound Teleservices Merchants",
+	"5968": "Direct Marketing – Continuity and Subscription Merchants",
+	"5969": "Direct Marketing – Other Direct Marketers",
+	"5970": "Artist's Supply and Craft Shops",
+	"5971": "Art Dealers and Galleries",
+	"5972": "Stamp and Coin Stores",
+	"5973": "Religious Goods Stores",
+	"5975": "Hearing Aids – Sales, Service and Supplies",
+	"5976": "Orthopedic Goods and Prosthetic Devices",
+	"5977": "Cosmetic Stores",
+	"5978": "Typewriter Stores",
+	"5983": "Fuel Dealers – Fuel Oil, Wood, Coal and Liquefied Petroleum",
+	"5992": "Florists",
+	"5993": "Cigar Stores and Stands",
+	"5994": "News Dealers and Newsstands",
+	"5995": "Pet Shops, Pet Food and Supplies",
+	"5996": "Swimming Pools – Sales, Supplies and Services",
+	"5997": "Electric Razor Stores",
+	"5998": "Tent and Awning Shops",
+	"5999": "Miscellaneous and Specialty Retail Stores",
+	"6010": "Financial Institutions – Manual Cash Disbursements",
+	"6011": "Financial Institutions – Automated Cash Disbursements",
+	"6012": "Financial Institutions – Merchandise, Services and Debt Repayment",
+	"6050": "Quasi Cash – Financial Institutions",
+	"6051": "Non-Financial Institutions – Foreign Currency, Money Orders and Travelers Cheques",
+	"6211": "Security Brokers and Dealers",
+	"6300": "Insurance Sales, Underwriting and Premiums",
+	"6381": "Insurance Premiums",
+	"6399": "Insurance",
+	"6513": "Real Estate Agents and Managers – Rentals",
+	"6529": "Remote Stored Value Load – Financial Institutions",
+	"6530": "Remote Stored Value Load – Merchants",
+	"6532": "Payment Transactions – Financial Institutions",
+	"6533": "Payment Transactions – Merchants",
+	"6534": "Money Transfers – Financial Institutions",
+	"6535": "Value Purchases – Financial Institutions",
+	"6536": "MoneySend Intracountry",
+	"6537": "MoneySend Intercountry",
+	"6538": "MoneySend Funding",
+	"6540": "Non-Financial Institutions – Stored Value Card Purchases and Loads",
+	"6611": "Overpayments",
+	"6760": "Savings Bonds",
+	"7011": "Lodging – Hotels, Motels and Resorts",
+	"7012": "Timeshares",
+	"7032": "Sporting and Recreational Camps",
+	"7033": "Trailer Parks and Campgrounds",
+	"7210": "Laundry, Cleaning and Garment Services",
+	"7211": "Laundry Services – Family and Commercial",
+	"7216": "Dry Cleaners",
+	"7217": "Carpet and Upholstery Cleaning",
+	"7221": "Photographic Studios",
+	"7230": "Beauty and Barber Shops",
+	"7251": "Shoe Repair Shops, Shoe Shine Parlors and Hat Cleaning Shops",
+	"7261": "Funeral Services and Crematories",
+	"7273": "Dating Services",
+	"7276": "Tax Preparation Services",
+	"7277": "Counseling Services – Debt, Marriage and Personal",
+	"7278": "Buying and Shopping Services and Clubs",
+	"7295": "Babysitting Services",
+	"7296": "Clothing Rental – Costumes, Uniforms and Formal Wear",
+	"7297": "Massage Parlors",
+	"7298": "Health and Beauty Spas",
+	"7299": "Miscellaneous Personal Services",
+	"7311": "Advertising Services",
+	"7321": "Consumer Credit Reporting Agencies",
+	"7322": "Debt Collection Agencies",
+	"7332": "Blueprinting and Photocopying Services",
+	"7333": "Commercial Photography, Art and Graphics",
+	"7338": "Quick Copy, Reproduction and Blueprinting Services",
+	"7339": "Stenographic and Secretarial Support Services",
+	"7342": "Exterminating and Disinfecting Services",
+	"7349": "Cleaning, Maintenance and Janitorial Services",
+	"7361": "Employment Agencies and Temporary Help Services",
+	"7372": "Computer Programming, Data Processing and Integrated Systems Design Services",
+	"7375": "Information Retrieval Services",
+	"7379": "Computer Maintenance and Repair Services",
+	"7392": "Management, Consulting and Public Relations Services",
+	"7393": "Detective, Protective and Security Services",
+	"7394": "Equipment, Tool, Furniture and Appliance Rental and Leasing",
+	"7395": "Photofinishing Laboratories and Photo Developing",
+	"7399": "Business Services",
+	"7511": "Truck Stops",
+	"7512": "Automobile Rental Agencies",
+	"7513": "Truck and Utility Trailer Rentals",
+	"7519": "Motor Home and Recreational Vehicle Rentals",
+	"7523": "Parking Lots, Parking Meters and Garages",
+	"7531": "Automotive Body Repair Shops",
+	"7534": "Tire Retreading and Repair Shops",
+	"7535": "Automotive Paint Shops",
+	"7538": "Automotive Service Shops",
+	"7542": "Car Washes",
+	"7549": "Towing Services",
+	"7622": "Electronics Repair Shops",
+	"7623": "Air Conditioning and Refrigeration Repair Shops",
+	"7629": "Electrical and Small Appliance Repair Shops",
+	"7631": "Watch, Clock and Jewelry Repair Shops",
+	"7641": "Furniture Reupholstery, Repair and Refinishing",
+	"7692": "Welding Services",
+	"7699": "Miscellaneous Repair Shops and Related Services",
+	"7800": "Government Owned Lotteries",
+	"7801": "Government Licensed Online Casinos",
+	"7802": "Government Licensed Horse and Dog Racing",
+	"7829": "Motion Picture and Video Tape Production and Distribution",
+	"7832": "Motion Picture Theaters",
+	"7841": "Video Tape Rental Stores",
+	"7911": "Dance Halls, Studios and Schools",
+	"7922": "Theatrical Producers and Ticket Agencies",
+	"7929": "Bands, Orchestras and Miscellaneous Entertainers",
+	"7932": "Billiard and Pool Establishments",
+	"7933": "Bowling Alleys",
+	"7941": "Commercial and Professional Sports, Athletic Fields and Sports Promoters",
+	"7991": "Tourist Attractions and Exhibits",
+	"7992": "Public Golf Courses",
+	"7993": "Video Amusement Game Supplies",
+	"7994": "Video Game Arcades and Establishments",
+	"7995": "Betting, including Lottery Tickets, Casino Gaming Chips, Off-Track Betting and Wagers at Race Tracks",
+	"7996": "Amusement Parks, Circuses, Carnivals and Fortune Tellers",
+	"7997": "Membership Clubs, Country Clubs and Private Golf Courses",
+	"7998": "Aquariums, Seaquariums, Dolphinariums and Zoos",
+	"7999": "Recreation Services",
+	"8011": "Doctors and Physicians",
+	"8021": "Dentists and Orthodontists",
+	"8031": "Osteopaths",
+	"8041": "Chiropractors",
+	"8042": "Optometrists and Ophthalmologists",
+	"8043": "Opticians, Optical Goods and Eyeglasses",
+	"8049": "Podiatrists and Chiropodists",
+	"8050": "Nursing and Personal Care Facilities",
+	"8062": "Hospitals",
+	"8071": "Medical and Dental Laboratories",
+	"8099": "Medical Services and Health Practitioners",
+	"8111": "Legal Services and Attorneys",
+	"8211": "Elementary and Secondary Schools",
+	"8220": "Colleges, Universities, Professional Schools and Junior Colleges",
+	"8241": "Correspondence Schools",
+	"8244": "Business and Secretarial Schools",
+	"8249": "Vocational and Trade Schools",
+	"8299": "Schools and Educational Services",
+	"8351": "Child Care Services",
+	"8398": "Charitable and Social Service Organizations",
+	"8641": "Civic, Social and Fraternal Associations",
+	"8651": "Political Organizations",
+	"8661": "Religious Organizations",
+	"8675": "Automobile Associations",
+	"8699": "Membership Organizations",
+	"8734": "Testing Laboratories",
+	"8911": "Architectural, Engineering and Surveying Services",
+	"8931": "Accounting, Auditing and Bookkeeping Services",
+	"8999": "Professional Services",
+	"9211": "Court Costs, including Alimony and Child Support",
+	"9222": "Fines",
+	"9223": "Bail and Bond Payments",
+	"9311": "Tax Payments",
+	"9399": "Government Services",
+	"9402": "Postal Services – Government Only",
+	"9405": "Intra-Government Purchases",
+	"9406": "Government Owned Lotteries (Non-U.S.)",
+	"9700": "Automated Referral Service",
+	"9701": "Visa Credential Server",
+	"9702": "Emergency Services",
+	"9751": "U.K. Supermarkets, Electronic Hot File",
+	"9752": "U.K. Petrol Stations, Electronic Hot File",
+	"9950": "Intra-Company Purchases",
+}
+
+// mccDescription returns the ISO 18245 description of an MCC, or an empty string if it isn't assigned.
+func mccDescription(mcc string) string {
+	if len(mcc) != 4 {
+		return ""
+	}
+	if desc, ok := mccDescriptions[mcc]; ok {
+		return desc
+	}
+	n, err := strconv.Atoi(mcc)
+	if err != nil {
+		return ""
+	}
+	for _, r := range mccRanges {
+		if n >= r.first && n <= r.last {
+			return r.description
+		}
+	}
+	return ""
+}
+
+func validateMCC(mcc string) error {
+	if mccDescription(mcc) == "" {
+		return fmt.Errorf("unknown merchant category code %q", mcc)
+	}
+	return nil
+}
+
+// mcc returns the MCC of a transaction's card lines, or an empty string if it isn't a card transaction.
+func (t transaction) mcc() string {
+	for i := range t.Lines {
+		if t.Lines[i].MCC != "" {
+			return t.Lines[i].MCC
+		}
+	}
+	return ""
+}
+
+// mccBlocklist holds the MCCs each organization can't post card transactions with. The blocklist under "*"
+// applies to every organization.
+type mccBlocklist map[string]map[string]bool
+
+// blockedMCCs is read from MCC_BLOCKLIST on startup.
+var blockedMCCs = mccBlocklist{}
+
+// readMCCBlocklist reads MCC_BLOCKLIST, comma separated MCCs which are blocked for every organization. Entries
+// formatted as organization:mcc only block the MCC for that organization and "gambling" blocks each of mccGambling.
+func readMCCBlocklist() (mccBlocklist, error) {
+	out := make(mccBlocklist)
+	for _, v := range strings.Split(os.Getenv("MCC_BLOCKLIST"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		organization, mcc := "*", v
+		if idx := strings.LastIndex(v, ":"); idx >= 0 {
+			organization, mcc = strings.TrimSpace(v[:idx]), strings.TrimSpace(v[idx+1:])
+			if !organizationRegex.MatchString(organization) {
+				return nil, fmt.Errorf("MCC_BLOCKLIST: invalid organization in %q", v)
+			}
+		}
+		codes := []string{mcc}
+		if strings.EqualFold(mcc, "gambling") {
+			codes = mccGambling
+		} else if err := validateMCC(mcc); err != nil {
+			return nil, fmt.Errorf("MCC_BLOCKLIST: %v", err)
+		}
+		if out[organization] == nil {
+			out[organization] = make(map[string]bool)
+		}
+		for _, code := range codes {
+			out[organization][code] = true
+		}
+	}
+	return out, nil
+}
+
+// check returns an error if t is a card transaction with an MCC blocked for ctx's organization. Contexts which
+// aren't scoped to an organization are only checked against MCCs blocked for every organization.
+func (b mccBlocklist) check(ctx context.Context, t transaction) error {
+	mcc := t.mcc()
+	if mcc == "" {
+		return nil
+	}
+	organization, ok := organizationFrom(ctx)
+	if b["*"][mcc] || (ok && b[organization][mcc]) {
+		return fmt.Errorf("transaction=%s: %v: mcc=%s (%s)", t.ID, errBlockedMCC, mcc, mccDescription(mcc))
+	}
+	return nil
+}
+
+// mccSpend is how much was spent on card transactions with one MCC.
+type mccSpend struct {
+	MCC          string `json:"mcc"`
+	Description  string `json:"description"`
+	Amount       int64  `json:"amount"`
+	Transactions int64  `json:"transactions"`
+}
+
+// mccSpendQuery selects the card transactions whose spending is totaled.
+type mccSpendQuery struct {
+	// AccountID optionally limits spending to one account's debits.
+	AccountID string
+
+	// Since and Until bound the transaction timestamps included, when set. Until is exclusive.
+	Since, Until time.Time
+}
+
+// spend returns the amount debited by a posted card transaction, or zero if it isn't included. Reversed card
+// transactions were refunded so they're not spending.
+func (q mccSpendQuery) spend(t *transaction) int64 {
+	if t.Status != TransactionPosted || t.mcc() == "" {
+		return 0
+	}
+	if (!q.Since.IsZero() && t.Timestamp.Before(q.Since)) || (!q.Until.IsZero() && !t.Timestamp.Before(q.Until)) {
+		return 0
+	}
+	var amount int64
+	for _, line := range t.Lines {
+		if line.Purpose == ACHDebit && (q.AccountID == "" || line.AccountID == q.AccountID) {
+			amount += int64(line.Amount)
+		}
+	}
+	return amount
+}
+
+// mergeMCCSpend combines spending with the same MCC, describes each MCC and orders them by amount, largest first.
+func mergeMCCSpend(spend []mccSpend) []mccSpend {
+	byMCC := make(map[string]*mccSpend)
+	for i := range spend {
+		s, ok := byMCC[spend[i].MCC]
+		if !ok {
+			s = &mccSpend{MCC: spend[i].MCC, Description: mccDescription(spend[i].MCC)}
+			byMCC[s.MCC] = s
+		}
+		s.Amount += spend[i].Amount
+		s.Transactions += spend[i].Transactions
+	}
+	out := make([]mccSpend, 0, len(byMCC))
+	for _, s := range byMCC {
+		out = append(out, *s)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Amount == out[j].Amount {
+			return out[i].MCC < out[j].MCC
+		}
+		return out[i].Amount > out[j].Amount
+	})
+	return out
+}
+
+// getMCCSpend is an admin route which totals posted card transactions by MCC, optionally for one ?accountId and
+// between ?since and ?until (RFC 3339 timestamps).
+func getMCCSpend(logger log.Logger, repo transactionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q := mccSpendQuery{AccountID: r.URL.Query().Get("accountId")}
+		for param, when := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+			if v := r.URL.Query().Get(param); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					moovhttp.Problem(w, fmt.Errorf("invalid %s: %v", param, err))
+					return
+				}
+				*when = t
+			}
+		}
+		spend, err := repo.getMCCSpend(q)
+		if err != nil {
+			logger.Log("mcc", fmt.Sprintf("problem totaling spend by MCC: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if spend == nil {
+			spend = []mccSpend{}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(spend)
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestMCC__validate(t *testing.T) {
+	for _, mcc := range []string{"5411", "7995", "3001", "3999", "0742"} {
+		if err := validateMCC(mcc); err != nil {
+			t.Errorf("%s: %v", mcc, err)
+		}
+	}
+	for _, mcc := range []string{"", "0000", "1234", "541", "54111", "abcd"} {
+		if err := validateMCC(mcc); err == nil {
+			t.Errorf("expected error for %q", mcc)
+		}
+	}
+	if desc := mccDescription("3125"); desc != "Airlines" {
+		t.Errorf("unexpected description: %q", desc)
+	}
+}
+
+func TestMCC__lines(t *testing.T) {
+	tx := func(lines ...transactionLine) transaction {
+		return transaction{ID: base.ID(), Timestamp: time.Now(), Lines: lines}
+	}
+	debit := transactionLine{AccountID: "cardholder", Purpose: ACHDebit, Amount: 100}
+
+	if err := tx(debit, transactionLine{AccountID: "settlement", Purpose: Card, Amount: 100, MCC: "5812"}).validate(); err != nil {
+		t.Error(err)
+	}
+	cases := map[string]transaction{
+		"unknown merchant category code": tx(debit, transactionLine{AccountID: "settlement", Purpose: Card, Amount: 100}),
+		"isn't a card line":              tx(debit, transactionLine{AccountID: "settlement", Purpose: ACHCredit, Amount: 100, MCC: "5812"}),
+		"card lines with different MCCs": tx(debit, debit,
+			transactionLine{AccountID: "settlement", Purpose: Card, Amount: 100, MCC: "5812"},
+			transactionLine{AccountID: "settlement", Purpose: Card, Amount: 100, MCC: "5411"},
+		),
+	}
+	for expected, tx := range cases {
+		if err := tx.validate(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q error: %v", expected, err)
+		}
+	}
+}
+
+func TestMCC__blocklist(t *testing.T) {
+	defer func(blocklist mccBlocklist) { blockedMCCs = blocklist }(blockedMCCs)
+	defer os.Unsetenv("MCC_BLOCKLIST")
+
+	os.Setenv("MCC_BLOCKLIST", "5933, acme:gambling")
+	blocklist, err := readMCCBlocklist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := mccBlocklist{
+		"*":    {"5933": true},
+		"acme": {"7800": true, "7801": true, "7802": true, "7995": true, "9406": true},
+	}
+	if !reflect.DeepEqual(blocklist, expected) {
+		t.Errorf("blocklist=%#v", blocklist)
+	}
+	for _, v := range []string{"1234", "acme:", "acme/other:5411"} {
+		os.Setenv("MCC_BLOCKLIST", v)
+		if _, err := readMCCBlocklist(); err == nil {
+			t.Errorf("expected error for %q", v)
+		}
+	}
+
+	blockedMCCs = blocklist
+	accountRepo, transactionRepo := newInMemoryRepositories()
+	for id, balance := range map[string]int{"cardholder": 1000, "settlement": 0} {
+		acct := &accounts.Account{ID: id, AccountNumber: id, OrganizationID: "acme", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+		if err := accountRepo.CreateAccount("customer", acct); err != nil {
+			t.Fatal(err)
+		}
+		if balance == 0 {
+			continue
+		}
+		deposit := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{{AccountID: id, Purpose: ACHCredit, Amount: balance}}}
+		if err := transactionRepo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}}
+	purchase := func(mcc string) []transactionLine {
+		return []transactionLine{
+			{AccountID: "cardholder", Purpose: ACHDebit, Amount: 100},
+			{AccountID: "settlement", Purpose: Card, Amount: 100, MCC: mcc},
+		}
+	}
+
+	acme := withOrganization(context.Background(), "acme")
+	if _, err := svc.CreateTransaction(acme, createTransactionRequest{Lines: purchase("7995")}); err == nil || !strings.Contains(err.Error(), errBlockedMCC.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := svc.PrepareTransaction(acme, prepareTransactionRequest{Lines: purchase("7801")}); err == nil || !strings.Contains(err.Error(), errBlockedMCC.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := svc.CreateTransaction(context.Background(), createTransactionRequest{Lines: purchase("5933")}); err == nil || !strings.Contains(err.Error(), errBlockedMCC.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"cardholder": 1000, "settlement": 0})
+
+	// unscoped postings only check MCCs blocked for every organization
+	if _, err := svc.CreateTransaction(context.Background(), createTransactionRequest{Lines: purchase("7995")}); err != nil {
+		t.Error(err)
+	}
+	if _, err := svc.CreateTransaction(acme, createTransactionRequest{Lines: purchase("5812")}); err != nil {
+		t.Error(err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"cardholder": 800, "settlement": 200})
+}
+
+func TestMCC__spend(t *testing.T) {
+	check := func(t *testing.T, repo transactionRepository) {
+		now := time.Now()
+		post := func(status TransactionStatus, when time.Time, lines ...transactionLine) {
+			t.Helper()
+			tx := transaction{ID: base.ID(), Timestamp: when, Status: status, Lines: lines}
+			if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		purchase := func(status TransactionStatus, when time.Time, cardholder string, amount int, mcc string) {
+			t.Helper()
+			post(status, when,
+				transactionLine{AccountID: cardholder, Purpose: ACHDebit, Amount: amount},
+				transactionLine{AccountID: "settlement", Purpose: Card, Amount: amount, MCC: mcc},
+			)
+		}
+		purchase(TransactionPosted, now, "alice", 500, "5812")
+		purchase(TransactionPosted, now, "alice", 250, "5812")
+		purchase(TransactionPosted, now.Add(-48*time.Hour), "alice", 300, "5411")
+		purchase(TransactionPosted, now, "bob", 1000, "5411")
+		purchase(TransactionPending, now, "bob", 700, "5812")
+		post(TransactionPosted, now,
+			transactionLine{AccountID: "alice", Purpose: ACHDebit, Amount: 50},
+			transactionLine{AccountID: "bob", Purpose: ACHCredit, Amount: 50},
+		)
+
+		spend, err := repo.getMCCSpend(mccSpendQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []mccSpend{
+			{MCC: "5411", Description: "Grocery Stores and Supermarkets", Amount: 1300, Transactions: 2},
+			{MCC: "5812", Description: "Eating Places and Restaurants", Amount: 750, Transactions: 2},
+		}
+		if !reflect.DeepEqual(spend, expected) {
+			t.Errorf("spend=%#v", spend)
+		}
+
+		spend, err = repo.getMCCSpend(mccSpendQuery{AccountID: "alice", Since: now.Add(-time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(spend) != 1 || spend[0].MCC != "5812" || spend[0].Amount != 750 || spend[0].Transactions != 2 {
+			t.Errorf("spend=%#v", spend)
+		}
+
+		// archived lines are still spending
+		if _, err := repo.compactTransactionLines(now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		spend, err = repo.getMCCSpend(mccSpendQuery{Until: now.Add(-time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(spend) != 1 || spend[0].MCC != "5411" || spend[0].Amount != 300 || spend[0].Transactions != 1 {
+			t.Errorf("spend=%#v", spend)
+		}
+	}
+
+	_, transactionRepo := createTestLedger(t, map[string]int{})
+	check(t, transactionRepo)
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	repo := createTestSqlTransactionRepository(t, sqliteDB.DB)
+	defer repo.Close()
+	check(t, repo)
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	repo = createTestSqlTransactionRepository(t, mysqlDB.DB)
+	defer repo.Close()
+	check(t, repo)
+}
+
+func TestMCC__spendRoute(t *testing.T) {
+	repo := &mockTransactionRepository{
+		transactions: []transaction{
+			{ID: "1", Timestamp: time.Now(), Status: TransactionPosted, Lines: []transactionLine{
+				{AccountID: "alice", Purpose: ACHDebit, Amount: 500},
+				{AccountID: "settlement", Purpose: Card, Amount: 500, MCC: "5812"},
+			}},
+		},
+	}
+	handler := getMCCSpend(log.NewNopLogger(), repo)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/transactions/mcc-spend?accountId=alice", nil))
+	w.Flush()
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var spend []mccSpend
+	if err := json.NewDecoder(w.Body).Decode(&spend); err != nil || len(spend) != 1 || spend[0].Amount != 500 {
+		t.Errorf("spend=%#v error=%v", spend, err)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/transactions/mcc-spend?since=yesterday", nil))
+	w.Flush()
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Organizations let multiple programs share one Accounts deployment. Every account, transaction and transaction line
+// stores the organization it belongs to and requests on the HTTP server are scoped to the caller's organization, so
+// repositories only read and write that organization's rows. Admin routes and background jobs aren't scoped.
+
+var (
+	errMissingOrganization  = errors.New("missing X-Organization header")
+	errOrganizationMismatch = errors.New("X-Organization doesn't match the authenticated tenant")
+
+	// organizationRegex matches organization IDs, which are stored in 40 character columns
+	organizationRegex = regexp.MustCompile(`^[A-Za-z0-9._-]{1,40}$`)
+)
+
+type organizationKey struct{}
+
+// withOrganization returns a context scoping repository calls to organization.
+func withOrganization(ctx context.Context, organization string) context.Context {
+	return context.WithValue(ctx, organizationKey{}, organization)
+}
+
+// organizationFrom returns the organization ctx is scoped to. ok is false for contexts which aren't scoped, such as
+// those of admin routes and background jobs.
+func organizationFrom(ctx context.Context) (string, bool) {
+	v, ok := ctx.Value(organizationKey{}).(string)
+	return v, ok
+}
+
+// unscoped returns ctx without its organization, for lookups which must see every organization's rows.
+func unscoped(ctx context.Context) context.Context {
+	return context.WithValue(ctx, organizationKey{}, nil)
+}
+
+// organizationFilter returns a condition limiting column to ctx's organization for appending to a where clause, and
+// its argument. Both are empty when ctx isn't scoped.
+func organizationFilter(ctx context.Context, column string) (string, []interface{}) {
+	organization, ok := organizationFrom(ctx)
+	if !ok {
+		return "", nil
+	}
+	return fmt.Sprintf(" and %s = ?", column), []interface{}{organization}
+}
+
+// inOrganization returns true if a row belonging to organization is visible to ctx.
+func inOrganization(ctx context.Context, organization string) bool {
+	v, ok := organizationFrom(ctx)
+	return !ok || v == organization
+}
+
+// organizeTransaction sets the organization of t and returns the organization of each line's account. Accounts
+// read across every organization are passed in, as transactions can refer to accounts which don't exist yet. Those
+// lines belong to the transaction's organization. In a scoped ctx the transaction is ctx's organization and accounts
+// of other organizations are rejected as not found, otherwise it's the organization of the first line's account.
+func organizeTransaction(ctx context.Context, t *transaction, accts []*accounts.Account) (map[string]string, error) {
+	organizations := make(map[string]string)
+	for i := range accts {
+		organizations[accts[i].ID] = accts[i].OrganizationID
+	}
+	if organization, ok := organizationFrom(ctx); ok {
+		for i := range t.Lines {
+			if v, exists := organizations[t.Lines[i].AccountID]; exists && v != organization {
+				return nil, fmt.Errorf("account=%q: %v", t.Lines[i].AccountID, errAccountNotFound)
+			}
+		}
+		t.OrganizationID = organization
+	} else if t.OrganizationID == "" && len(t.Lines) > 0 {
+		t.OrganizationID = organizations[t.Lines[0].AccountID]
+	}
+	for i := range t.Lines {
+		if _, exists := organizations[t.Lines[i].AccountID]; !exists {
+			organizations[t.Lines[i].AccountID] = t.OrganizationID
+		}
+	}
+	return organizations, nil
+}
+
+// organizationRequired reads ORGANIZATION_REQUIRED, which defaults to false. Without it requests which don't name
+// an organization are scoped to the default (empty) organization, which holds rows from before organizations, so
+// existing clients keep working.
+func organizationRequired() (bool, error) {
+	v := os.Getenv("ORGANIZATION_REQUIRED")
+	if v == "" {
+		return false, nil
+	}
+	required, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid ORGANIZATION_REQUIRED %q: %v", v, err)
+	}
+	return required, nil
+}
+
+// requestOrganization returns the organization of r, from the X-Tenant-ID set by a verified JWT, signing key or client
+// certificate or else the caller's X-Organization header.
+func requestOrganization(r *http.Request) (string, error) {
+	organization, tenant := r.Header.Get("X-Organization"), r.Header.Get("X-Tenant-ID")
+	if tenant != "" {
+		if organization != "" && organization != tenant {
+			return "", errOrganizationMismatch
+		}
+		organization = tenant
+	}
+	if organization != "" && !organizationRegex.MatchString(organization) {
+		return "", fmt.Errorf("invalid organization %q, expected up to 40 letters, digits, '.', '_' or '-'", organization)
+	}
+	return organization, nil
+}
+
+// organizationOptional returns true for routes which don't need an organization. Export downloads are authorized
+// by their signed link alone.
+func organizationOptional(r *http.Request) bool {
+	if r.Method != "GET" {
+		return false
+	}
+	if r.URL.Path == "/ping" {
+		return true
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	return len(parts) == 5 && parts[0] == "customers" && parts[2] == "exports" && parts[4] == "download"
+}
+
+// newTenantReset removes any X-Tenant-ID the caller sent before passing requests to next. It wraps every
+// authenticator, so the header only ever holds the tenant of a verified JWT, signing key or client certificate.
+func newTenantReset(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("X-Tenant-ID")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// organizations scopes each request to the caller's organization before passing it to next.
+type organizations struct {
+	logger   log.Logger
+	required bool
+	next     http.Handler
+}
+
+func newOrganizations(logger log.Logger, required bool, next http.Handler) http.Handler {
+	return &organizations{logger: logger, required: required, next: next}
+}
+
+func (o *organizations) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	organization, err := requestOrganization(r)
+	if err != nil {
+		o.reject(r, err)
+		if err == errOrganizationMismatch {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		moovhttp.Problem(w, err)
+		return
+	}
+	if organization == "" && o.required && !organizationOptional(r) {
+		o.reject(r, errMissingOrganization)
+		moovhttp.Problem(w, errMissingOrganization)
+		return
+	}
+	o.next.ServeHTTP(w, r.WithContext(withOrganization(r.Context(), organization)))
+}
+
+func (o *organizations) reject(r *http.Request, err error) {
+	o.logger.Log(
+		"audit", fmt.Sprintf("rejected request: %v", err),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"tenant", r.Header.Get("X-Tenant-ID"),
+		"requestID", moovhttp.GetRequestID(r),
+	)
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestOrganizations(t *testing.T) {
+	var buf bytes.Buffer
+	var scoped string
+	handler := newOrganizations(log.NewLogfmtLogger(&buf), true, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		organization, ok := organizationFrom(r.Context())
+		if !ok {
+			t.Error("request isn't scoped")
+		}
+		scoped = organization
+	}))
+
+	cases := []struct {
+		method, path         string
+		organization, tenant string
+		status               int
+		expectedOrganization string
+	}{
+		{"GET", "/accounts/search", "acme", "", http.StatusOK, "acme"},
+		{"GET", "/accounts/search", "", "acme", http.StatusOK, "acme"},
+		{"GET", "/accounts/search", "acme", "acme", http.StatusOK, "acme"},
+		{"GET", "/accounts/search", "other", "acme", http.StatusForbidden, ""},
+		{"GET", "/accounts/search", "", "", http.StatusBadRequest, ""},
+		{"GET", "/accounts/search", "acme/other", "", http.StatusBadRequest, ""},
+		{"GET", "/ping", "", "", http.StatusOK, ""},
+		{"GET", "/customers/foo/exports/bar/download", "", "", http.StatusOK, ""},
+		{"POST", "/customers/foo/exports", "", "", http.StatusBadRequest, ""},
+	}
+	for i := range cases {
+		scoped = ""
+		req := httptest.NewRequest(cases[i].method, cases[i].path, nil)
+		req.Header.Set("X-Organization", cases[i].organization)
+		req.Header.Set("X-Tenant-ID", cases[i].tenant)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		w.Flush()
+
+		if w.Code != cases[i].status || scoped != cases[i].expectedOrganization {
+			t.Errorf("%s %s X-Organization=%q X-Tenant-ID=%q: got %d scoped to %q", cases[i].method, cases[i].path, cases[i].organization, cases[i].tenant, w.Code, scoped)
+		}
+	}
+	if !strings.Contains(buf.String(), "rejected request: missing X-Organization header") {
+		t.Errorf("unexpected audit log: %s", buf.String())
+	}
+
+	// without requiring an organization requests are scoped to the default one
+	handler = newOrganizations(log.NewNopLogger(), false, handler.(*organizations).next)
+	w := httptest.NewRecorder()
+	scoped = "unset"
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/accounts/search", nil))
+	if w.Code != http.StatusOK || scoped != "" {
+		t.Errorf("got %d scoped to %q", w.Code, scoped)
+	}
+}
+
+func TestOrganizations__tenantReset(t *testing.T) {
+	var handler http.Handler = newOrganizations(log.NewNopLogger(), false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		organization, _ := organizationFrom(r.Context())
+		w.Header().Set("X-Organization", organization)
+	}))
+	handler = newRequestSigning(log.NewNopLogger(), nil, handler)
+	handler = newTenantReset(handler)
+
+	// callers can't scope themselves to a tenant without authenticating as it
+	req := httptest.NewRequest("GET", "/accounts/search", nil)
+	req.Header.Set("X-Tenant-ID", "acme")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("X-Organization") != "" {
+		t.Errorf("got %d scoped to %q", w.Code, w.Header().Get("X-Organization"))
+	}
+}
+
+func TestOrganizations__required(t *testing.T) {
+	defer os.Unsetenv("ORGANIZATION_REQUIRED")
+
+	if required, err := organizationRequired(); err != nil || required {
+		t.Errorf("required=%v error=%v", required, err)
+	}
+	os.Setenv("ORGANIZATION_REQUIRED", "true")
+	if required, err := organizationRequired(); err != nil || !required {
+		t.Errorf("required=%v error=%v", required, err)
+	}
+	os.Setenv("ORGANIZATION_REQUIRED", "maybe")
+	if _, err := organizationRequired(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSqlAccountRepository__organizations(t *testing.T) {
+	check := func(t *testing.T, repo *sqlAccountRepository) {
+		defer repo.Close()
+
+		customerID := base.ID()
+		acme, other := withOrganization(context.Background(), "acme"), withOrganization(context.Background(), "other")
+		account := &accounts.Account{
+			ID:             base.ID(),
+			CustomerID:     customerID,
+			OrganizationID: "acme",
+			Name:           "Checking",
+			AccountNumber:  createAccountNumber(),
+			RoutingNumber:  "121042882",
+			Status:         "open",
+			Type:           "Checking",
+			CreatedAt:      time.Now(),
+		}
+		if err := repo.CreateAccount(customerID, account); err != nil {
+			t.Fatal(err)
+		}
+
+		if accts, err := repo.GetAccounts(acme, []string{account.ID}); err != nil || len(accts) != 1 || accts[0].OrganizationID != "acme" {
+			t.Fatalf("accounts=%#v error=%v", accts, err)
+		}
+		if accts, err := repo.GetAccounts(context.Background(), []string{account.ID}); err != nil || len(accts) != 1 {
+			t.Errorf("unscoped: accounts=%#v error=%v", accts, err)
+		}
+		if accts, err := repo.GetAccounts(other, []string{account.ID}); err != nil || len(accts) != 0 {
+			t.Errorf("other organization: accounts=%#v error=%v", accts, err)
+		}
+		if accts, err := repo.SearchAccountsByCustomerID(other, customerID); err != nil || len(accts) != 0 {
+			t.Errorf("other organization: accounts=%#v error=%v", accts, err)
+		}
+		if acct, err := repo.SearchAccountsByRoutingNumber(other, account.AccountNumber, account.RoutingNumber, "checking"); err != nil || acct != nil {
+			t.Errorf("other organization: account=%#v error=%v", acct, err)
+		}
+		if acct, err := repo.SearchAccountsByRoutingNumber(acme, account.AccountNumber, account.RoutingNumber, "checking"); err != nil || acct == nil {
+			t.Errorf("account=%#v error=%v", acct, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlAccountRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlAccountRepository(t, mysqlDB.DB))
+}
+
+func TestTransactionRepositories__organizations(t *testing.T) {
+	check := func(t *testing.T, repo transactionRepository) {
+		defer repo.Close()
+
+		acme, other := withOrganization(context.Background(), "acme"), withOrganization(context.Background(), "other")
+		newTransaction := func(accountIDs ...string) transaction {
+			return transaction{
+				ID:             base.ID(),
+				Timestamp:      time.Now(),
+				IdempotencyKey: "key",
+				Lines: []transactionLine{
+					{AccountID: accountIDs[0], Purpose: ACHDebit, Amount: 100},
+					{AccountID: accountIDs[1], Purpose: ACHCredit, Amount: 100},
+				},
+			}
+		}
+		opts := createTransactionOpts{AllowOverdraft: true}
+
+		// postings can't touch another organization's accounts
+		if err := repo.createTransaction(acme, newTransaction("acme1", "other1"), opts); err == nil || !strings.Contains(err.Error(), errAccountNotFound.Error()) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		tx := newTransaction("acme1", "acme2")
+		if err := repo.createTransaction(acme, tx, opts); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := repo.getTransaction(acme, tx.ID); err != nil || found == nil || found.OrganizationID != "acme" {
+			t.Errorf("transaction=%#v error=%v", found, err)
+		}
+		if found, _ := repo.getTransaction(other, tx.ID); found != nil {
+			t.Errorf("other organization read transaction=%#v", found)
+		}
+		if found, err := repo.getTransaction(context.Background(), tx.ID); err != nil || found == nil {
+			t.Errorf("unscoped: transaction=%#v error=%v", found, err)
+		}
+		if txs, _, err := repo.getAccountTransactions(other, "acme1", transactionPage{}); err != nil || len(txs) != 0 {
+			t.Errorf("other organization: transactions=%#v error=%v", txs, err)
+		}
+		if txs, _, err := repo.getAccountTransactions(acme, "acme1", transactionPage{}); err != nil || len(txs) != 1 {
+			t.Errorf("transactions=%#v error=%v", txs, err)
+		}
+
+		// idempotency keys are unique per organization
+		if found, err := repo.getTransactionByIdempotencyKey(other, "key"); err != nil || found != nil {
+			t.Errorf("other organization: transaction=%#v error=%v", found, err)
+		}
+		otherTx := newTransaction("other1", "other2")
+		if err := repo.createTransaction(other, otherTx, opts); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := repo.getTransactionByIdempotencyKey(other, "key"); err != nil || found == nil || found.ID != otherTx.ID {
+			t.Errorf("transaction=%#v error=%v", found, err)
+		}
+	}
+
+	organizationAccounts := func() accountRepository {
+		return &testAccountRepository{
+			accounts: []*accounts.Account{
+				{ID: "acme1", AccountNumber: "1", OrganizationID: "acme", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"},
+				{ID: "acme2", AccountNumber: "2", OrganizationID: "acme", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"},
+				{ID: "other1", AccountNumber: "3", OrganizationID: "other", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"},
+				{ID: "other2", AccountNumber: "4", OrganizationID: "other", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"},
+			},
+		}
+	}
+
+	accountRepo, memoryRepo := newInMemoryRepositories()
+	for _, acct := range organizationAccounts().(*testAccountRepository).accounts {
+		if err := accountRepo.CreateAccount("", acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(t, memoryRepo)
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	repo := createTestSqlTransactionRepository(t, sqliteDB.DB)
+	repo.accountRepo = organizationAccounts()
+	check(t, repo)
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	repo = createTestSqlTransactionRepository(t, mysqlDB.DB)
+	repo.accountRepo = organizationAccounts()
+	check(t, repo)
+}
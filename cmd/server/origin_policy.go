@@ -0,0 +1,152 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// originPolicies restrict the networks which can call each group of routes. An empty allowlist lets every
+// caller through, as Accounts did before allowlists existed.
+type originPolicies struct {
+	admin  []*net.IPNet // ADMIN_ALLOWED_CIDRS
+	public []*net.IPNet // PUBLIC_ALLOWED_CIDRS
+
+	// trustedProxies are load balancers whose X-Forwarded-For header is believed. Callers elsewhere could
+	// set the header to anything.
+	trustedProxies []*net.IPNet // TRUSTED_PROXY_CIDRS
+}
+
+func readOriginPolicies() (*originPolicies, error) {
+	policies := &originPolicies{}
+	for key, nets := range map[string]*[]*net.IPNet{
+		"ADMIN_ALLOWED_CIDRS":  &policies.admin,
+		"PUBLIC_ALLOWED_CIDRS": &policies.public,
+		"TRUSTED_PROXY_CIDRS":  &policies.trustedProxies,
+	} {
+		parsed, err := parseCIDRs(os.Getenv(key))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		*nets = parsed
+	}
+	return policies, nil
+}
+
+// parseCIDRs reads a comma separated list of CIDR blocks, such as 10.0.0.0/8. Single IP addresses are also
+// accepted.
+func parseCIDRs(v string) ([]*net.IPNet, error) {
+	var out []*net.IPNet
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			out = append(out, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, block, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %v", s, err)
+		}
+		out = append(out, block)
+	}
+	return out, nil
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for i := range nets {
+		if nets[i].Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the caller. X-Forwarded-For is only followed through trusted proxies, from the
+// nearest hop back to the first one we don't run.
+func (p *originPolicies) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !containsIP(p.trustedProxies, ip) {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			return ip
+		}
+		ip = hop
+		if !containsIP(p.trustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// guard wraps next so only callers within allowed reach it. Rejected requests are written to the audit log and
+// return 403 Forbidden. next is returned as-is when allowed is empty.
+func (p *originPolicies) guard(logger log.Logger, group string, allowed []*net.IPNet, next http.Handler) http.Handler {
+	if len(allowed) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := p.clientIP(r)
+		if ip != nil && containsIP(allowed, ip) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		logger.Log(
+			"audit", fmt.Sprintf("rejected %s request from outside the allowlist", group),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"caller", ip,
+			"remoteAddr", r.RemoteAddr,
+			"forwardedFor", r.Header.Get("X-Forwarded-For"),
+			"userID", moovhttp.GetUserID(r),
+			"requestID", moovhttp.GetRequestID(r),
+		)
+		w.WriteHeader(http.StatusForbidden)
+	})
+}
+
+// newAdminProxy serves the admin port at addr through the admin allowlist. Routes can't be wrapped on the admin
+// server itself (metrics and health checks are registered inside it), so it listens on loopback at backend and
+// every request is proxied to it once allowed.
+func newAdminProxy(logger log.Logger, addr, backend string, policies *originPolicies) *http.Server {
+	proxy := httputil.NewSingleHostReverseProxy(&url.URL{Scheme: "http", Host: backend})
+	timeout := 45 * time.Second // matches the admin server
+	return &http.Server{
+		Addr:         addr,
+		Handler:      policies.guard(logger, "admin", policies.admin, proxy),
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+		IdleTimeout:  timeout,
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestOriginPolicies__parseCIDRs(t *testing.T) {
+	nets, err := parseCIDRs(" 10.0.0.0/8, 192.168.1.5 ,,2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nets) != 3 || nets[1].String() != "192.168.1.5/32" || nets[2].String() != "2001:db8::1/128" {
+		t.Errorf("unexpected networks: %v", nets)
+	}
+	for _, v := range []string{"10.0.0.0/33", "localhost"} {
+		if _, err := parseCIDRs(v); err == nil {
+			t.Errorf("%s: expected error", v)
+		}
+	}
+
+	os.Setenv("ADMIN_ALLOWED_CIDRS", "10.0.0.0/8/8")
+	defer os.Unsetenv("ADMIN_ALLOWED_CIDRS")
+	if _, err := readOriginPolicies(); err == nil || !strings.Contains(err.Error(), "ADMIN_ALLOWED_CIDRS") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestOriginPolicies__guard(t *testing.T) {
+	allowed, _ := parseCIDRs("10.1.0.0/16")
+	trusted, _ := parseCIDRs("172.16.0.0/12")
+	policies := &originPolicies{admin: allowed, trustedProxies: trusted}
+
+	var buf bytes.Buffer
+	handler := policies.guard(log.NewLogfmtLogger(&buf), "admin", policies.admin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	cases := []struct {
+		remoteAddr, forwardedFor string
+		expected                 int
+	}{
+		{"10.1.2.3:5000", "", http.StatusOK},
+		{"10.2.2.3:5000", "", http.StatusForbidden},
+		// X-Forwarded-For is only believed from trusted proxies
+		{"10.2.2.3:5000", "10.1.2.3", http.StatusForbidden},
+		{"172.16.0.9:5000", "10.1.2.3", http.StatusOK},
+		{"172.16.0.9:5000", "10.1.2.3, 172.16.0.10", http.StatusOK},
+		{"172.16.0.9:5000", "10.1.2.3, 10.2.2.3", http.StatusForbidden},
+		{"172.16.0.9:5000", "", http.StatusForbidden},
+	}
+	for i := range cases {
+		req := httptest.NewRequest("GET", "/evidence", nil)
+		req.RemoteAddr = cases[i].remoteAddr
+		if cases[i].forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", cases[i].forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		if w.Code != cases[i].expected {
+			t.Errorf("#%d: got %d expected %d", i, w.Code, cases[i].expected)
+		}
+	}
+	if !strings.Contains(buf.String(), "rejected admin request") || !strings.Contains(buf.String(), "caller=10.2.2.3") {
+		t.Errorf("unexpected audit log: %s", buf.String())
+	}
+
+	// without an allowlist every caller is let through
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.RemoteAddr = "203.0.113.7:5000"
+	w := httptest.NewRecorder()
+	policies.guard(log.NewNopLogger(), "public", policies.public, http.NotFoundHandler()).ServeHTTP(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
+
+func TestOriginPolicies__adminProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("PONG"))
+	}))
+	defer backend.Close()
+
+	allowed, _ := parseCIDRs("127.0.0.1")
+	proxy := newAdminProxy(log.NewNopLogger(), ":0", strings.TrimPrefix(backend.URL, "http://"), &originPolicies{admin: allowed})
+	for remoteAddr, expected := range map[string]int{"127.0.0.1:5000": http.StatusOK, "10.0.0.1:5000": http.StatusForbidden} {
+		req := httptest.NewRequest("GET", "/live", nil)
+		req.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		proxy.Handler.ServeHTTP(w, req)
+		if w.Code != expected {
+			t.Errorf("%s: got %d expected %d", remoteAddr, w.Code, expected)
+		}
+		if expected == http.StatusOK && w.Body.String() != "PONG" {
+			t.Errorf("unexpected body: %q", w.Body.String())
+		}
+	}
+}
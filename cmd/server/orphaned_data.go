@@ -0,0 +1,258 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// orphanBatchSize is how many accounts with transaction lines are checked at once
+var orphanBatchSize = 100
+
+// danglingHoldGrace is how long after expiring a hold is left for the hold expiry job to abort
+const danglingHoldGrace = time.Hour
+
+// orphanedLine is a transaction line of an account which doesn't exist.
+type orphanedLine struct {
+	TransactionID string             `json:"transactionId"`
+	AccountID     string             `json:"accountId"`
+	Purpose       TransactionPurpose `json:"purpose"`
+	Amount        int                `json:"amount"`
+	Status        TransactionStatus  `json:"status"`
+	Repaired      bool               `json:"repaired"`
+}
+
+// emptyTransaction is a transaction without any lines.
+type emptyTransaction struct {
+	TransactionID string `json:"transactionId"`
+	Repaired      bool   `json:"repaired"`
+}
+
+// danglingHold is a held transaction which nothing will commit or abort: its hold expired long enough ago that the
+// expiry job should have aborted it, or it holds funds of an account which is closed or doesn't exist.
+type danglingHold struct {
+	TransactionID string     `json:"transactionId"`
+	AccountIDs    []string   `json:"accountIds"`
+	ExpiresAt     *time.Time `json:"expiresAt,omitempty"`
+	Reason        string     `json:"reason"`
+	Repaired      bool       `json:"repaired"`
+}
+
+// orphanReport lists the rows left behind by partial failures, such as an account whose creation failed after its
+// initial deposit was posted. Problems are orphans which couldn't be repaired automatically.
+type orphanReport struct {
+	OrphanedLines     []orphanedLine     `json:"orphanedLines"`
+	EmptyTransactions []emptyTransaction `json:"emptyTransactions"`
+	DanglingHolds     []*danglingHold    `json:"danglingHolds"`
+	Problems          []string           `json:"problems"`
+}
+
+func (r *orphanReport) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+type orphanDetector struct {
+	logger       log.Logger
+	repo         *sqlOrphanRepository
+	accounts     accountRepository
+	transactions *transactionService
+}
+
+// detect finds orphaned data and, with repair, cleans it up:
+//   - dangling holds are aborted, which releases the funds they reserved
+//   - transactions without lines are deleted
+//   - transactions whose every line is of an account which doesn't exist are deleted, along with the balances of
+//     those accounts. Transactions which also move funds of accounts which exist are left as problems, as
+//     deleting some of their lines would unbalance them.
+func (d *orphanDetector) detect(ctx context.Context, now time.Time, repair bool) (*orphanReport, error) {
+	out := &orphanReport{
+		OrphanedLines:     []orphanedLine{},
+		EmptyTransactions: []emptyTransaction{},
+		DanglingHolds:     []*danglingHold{},
+		Problems:          []string{},
+	}
+
+	// Find every account which has transaction lines but doesn't exist
+	missing := make(map[string]bool)
+	after := ""
+	for {
+		accountIDs, err := d.repo.getLineAccountIDs(after, orphanBatchSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(accountIDs) == 0 {
+			break
+		}
+		after = accountIDs[len(accountIDs)-1]
+		accts, err := d.accounts.GetAccounts(ctx, accountIDs)
+		if err != nil {
+			return nil, err
+		}
+		found := make(map[string]bool)
+		for i := range accts {
+			found[accts[i].ID] = true
+		}
+		for i := range accountIDs {
+			if !found[accountIDs[i]] {
+				missing[accountIDs[i]] = true
+			}
+		}
+	}
+
+	if err := d.detectHolds(ctx, now, missing, repair, out); err != nil {
+		return nil, err
+	}
+
+	emptyIDs, err := d.repo.getEmptyTransactions()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range emptyIDs {
+		empty := emptyTransaction{TransactionID: id}
+		if repair {
+			if err := d.repo.deleteTransaction(id, now); err != nil {
+				out.problem("transaction=%s has no lines: %v", id, err)
+			} else {
+				empty.Repaired = true
+			}
+		}
+		out.EmptyTransactions = append(out.EmptyTransactions, empty)
+	}
+
+	if err := d.detectLines(missing, now, repair, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (d *orphanDetector) detectHolds(ctx context.Context, now time.Time, missing map[string]bool, repair bool, out *orphanReport) error {
+	holds, err := d.repo.getHolds()
+	if err != nil {
+		return err
+	}
+	for _, hold := range holds {
+		for _, accountID := range hold.AccountIDs {
+			if missing[accountID] {
+				hold.Reason = fmt.Sprintf("account=%s doesn't exist", accountID)
+				break
+			}
+		}
+		if hold.Reason == "" {
+			accts, err := d.accounts.GetAccounts(ctx, hold.AccountIDs)
+			if err != nil {
+				return err
+			}
+			for i := range accts {
+				if accountClosed(accts[i]) {
+					hold.Reason = fmt.Sprintf("account=%s is closed", accts[i].ID)
+					break
+				}
+			}
+		}
+		if hold.Reason == "" && hold.ExpiresAt != nil && hold.ExpiresAt.Add(danglingHoldGrace).Before(now) {
+			hold.Reason = "expired without being aborted"
+		}
+		if hold.Reason == "" {
+			continue
+		}
+		if repair {
+			if _, err := d.transactions.UpdateTransactionStatus(ctx, hold.TransactionID, TransactionVoided); err != nil {
+				out.problem("held transaction=%s: %v", hold.TransactionID, err)
+			} else {
+				hold.Repaired = true
+			}
+		}
+		out.DanglingHolds = append(out.DanglingHolds, hold)
+	}
+	return nil
+}
+
+func (d *orphanDetector) detectLines(missing map[string]bool, now time.Time, repair bool, out *orphanReport) error {
+	accountIDs := make([]string, 0, len(missing))
+	for id := range missing {
+		accountIDs = append(accountIDs, id)
+	}
+	sort.Strings(accountIDs)
+
+	checked, deleted := make(map[string]bool), make(map[string]bool)
+	for _, accountID := range accountIDs {
+		lines, err := d.repo.getAccountLines(accountID)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			if repair && !checked[line.TransactionID] {
+				checked[line.TransactionID] = true
+				deleted[line.TransactionID] = d.repairLine(line, missing, now, out)
+			}
+			line.Repaired = deleted[line.TransactionID]
+			out.OrphanedLines = append(out.OrphanedLines, line)
+		}
+		if repair {
+			if err := d.repo.deleteAccountBalance(accountID); err != nil {
+				out.problem("account=%s doesn't exist: %v", accountID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// repairLine deletes the transaction of an orphaned line, returning true, if none of the transaction's accounts
+// exist.
+func (d *orphanDetector) repairLine(line orphanedLine, missing map[string]bool, now time.Time, out *orphanReport) bool {
+	accountIDs, err := d.repo.getTransactionAccountIDs(line.TransactionID)
+	if err != nil {
+		out.problem("transaction=%s: %v", line.TransactionID, err)
+		return false
+	}
+	for _, accountID := range accountIDs {
+		if !missing[accountID] {
+			out.problem("transaction=%s moves funds of account=%s which doesn't exist and account=%s which does, correct it manually", line.TransactionID, line.AccountID, accountID)
+			return false
+		}
+	}
+	if err := d.repo.deleteTransaction(line.TransactionID, now); err != nil {
+		out.problem("transaction=%s: %v", line.TransactionID, err)
+		return false
+	}
+	d.logger.Log("orphans", fmt.Sprintf("deleted transaction=%s of accounts which don't exist", line.TransactionID))
+	return true
+}
+
+// orphanedData is an admin route which reports orphaned data (GET) or repairs what it can (POST).
+func orphanedData(logger log.Logger, d *orphanDetector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var repair bool
+		switch r.Method {
+		case "GET":
+		case "POST":
+			repair = true
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		report, err := d.detect(requestContext(r), time.Now(), repair)
+		if err != nil {
+			logger.Log("orphans", fmt.Sprintf("problem detecting orphaned data: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if n := len(report.OrphanedLines) + len(report.EmptyTransactions) + len(report.DanglingHolds); n > 0 {
+			logger.Log("orphans", fmt.Sprintf("found %d orphaned rows repair=%v problems=%d", n, repair, len(report.Problems)), "userID", moovhttp.GetUserID(r))
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(report)
+	}
+}
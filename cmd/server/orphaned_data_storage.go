@@ -0,0 +1,168 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// sqlOrphanRepository reads the transaction tables directly to find rows left behind by partial failures.
+type sqlOrphanRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlOrphanRepository) Close() error {
+	return r.db.Close()
+}
+
+// getLineAccountIDs returns up to limit IDs of accounts with transaction lines, ordered by ID, starting after the
+// account ID given.
+func (r *sqlOrphanRepository) getLineAccountIDs(after string, limit int) ([]string, error) {
+	query := `select distinct account_id from transaction_lines where account_id > ? and deleted_at is null order by account_id limit ?;`
+	rows, err := r.db.Query(query, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getLineAccountIDs: %v", err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("getLineAccountIDs: scan: %v", err)
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// getAccountLines returns the lines of an account along with the status of their transactions.
+func (r *sqlOrphanRepository) getAccountLines(accountID string) ([]orphanedLine, error) {
+	query := `select l.transaction_id, l.account_id, l.purpose, l.amount, t.status from transaction_lines l
+inner join transactions t on l.transaction_id = t.transaction_id
+where l.account_id = ? and l.deleted_at is null and t.deleted_at is null order by l.transaction_id;`
+	rows, err := r.db.Query(query, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getAccountLines: account=%s: %v", accountID, err)
+	}
+	defer rows.Close()
+
+	var out []orphanedLine
+	for rows.Next() {
+		var line orphanedLine
+		var status *string
+		if err := rows.Scan(&line.TransactionID, &line.AccountID, &line.Purpose, &line.Amount, &status); err != nil {
+			return nil, fmt.Errorf("getAccountLines: scan: %v", err)
+		}
+		line.Status = TransactionPosted // transactions from before statuses were added are posted
+		if status != nil && *status != "" {
+			line.Status = TransactionStatus(*status)
+		}
+		out = append(out, line)
+	}
+	return out, rows.Err()
+}
+
+// getTransactionAccountIDs returns the accounts of a transaction's lines.
+func (r *sqlOrphanRepository) getTransactionAccountIDs(transactionID string) ([]string, error) {
+	rows, err := r.db.Query(`select account_id from transaction_lines where transaction_id = ? and deleted_at is null;`, transactionID)
+	if err != nil {
+		return nil, fmt.Errorf("getTransactionAccountIDs: transaction=%s: %v", transactionID, err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("getTransactionAccountIDs: scan: %v", err)
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// getEmptyTransactions returns the IDs of transactions without any lines, including archived lines.
+func (r *sqlOrphanRepository) getEmptyTransactions() ([]string, error) {
+	query := `select transaction_id from transactions where deleted_at is null
+and transaction_id not in (select transaction_id from transaction_lines where deleted_at is null)
+and transaction_id not in (select transaction_id from transaction_lines_archive) order by transaction_id;`
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("getEmptyTransactions: %v", err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("getEmptyTransactions: scan: %v", err)
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// getHolds returns every held transaction along with the accounts of its lines.
+func (r *sqlOrphanRepository) getHolds() ([]*danglingHold, error) {
+	query := `select t.transaction_id, t.expires_at, l.account_id from transactions t
+inner join transaction_lines l on t.transaction_id = l.transaction_id
+where t.status = 'held' and t.deleted_at is null and l.deleted_at is null order by t.transaction_id, l.account_id;`
+	rows, err := r.db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("getHolds: %v", err)
+	}
+	defer rows.Close()
+
+	var out []*danglingHold
+	for rows.Next() {
+		var transactionID, accountID string
+		var expiresAt *time.Time
+		if err := rows.Scan(&transactionID, &expiresAt, &accountID); err != nil {
+			return nil, fmt.Errorf("getHolds: scan: %v", err)
+		}
+		if len(out) == 0 || out[len(out)-1].TransactionID != transactionID {
+			out = append(out, &danglingHold{TransactionID: transactionID, ExpiresAt: expiresAt})
+		}
+		hold := out[len(out)-1]
+		hold.AccountIDs = append(hold.AccountIDs, accountID)
+	}
+	return out, rows.Err()
+}
+
+// deleteTransaction soft-deletes a transaction and its lines.
+func (r *sqlOrphanRepository) deleteTransaction(transactionID string, now time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("deleteTransaction: begin: %v", err)
+	}
+	if _, err := tx.Exec(`update transactions set deleted_at = ? where transaction_id = ? and deleted_at is null;`, now, transactionID); err != nil {
+		return fmt.Errorf("deleteTransaction: transaction=%s: %v rollback=%v", transactionID, err, tx.Rollback())
+	}
+	if _, err := tx.Exec(`update transaction_lines set deleted_at = ? where transaction_id = ? and deleted_at is null;`, now, transactionID); err != nil {
+		return fmt.Errorf("deleteTransaction: transaction=%s lines: %v rollback=%v", transactionID, err, tx.Rollback())
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("deleteTransaction: commit: %v", err)
+	}
+	return nil
+}
+
+// deleteAccountBalance removes the balance checkpoint of an account which doesn't exist once none of its lines
+// remain.
+func (r *sqlOrphanRepository) deleteAccountBalance(accountID string) error {
+	query := `delete from account_balances where account_id = ?
+and not exists (select 1 from transaction_lines where account_id = ? and deleted_at is null);`
+	if _, err := r.db.Exec(query, accountID, accountID); err != nil {
+		return fmt.Errorf("deleteAccountBalance: account=%s: %v", accountID, err)
+	}
+	return nil
+}
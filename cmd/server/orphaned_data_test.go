@@ -0,0 +1,147 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestOrphanedData(t *testing.T) {
+	check := func(t *testing.T, db *sql.DB) {
+		ctx := context.Background()
+
+		accountRepo := createTestSqlAccountRepository(t, db)
+		transactionRepo, err := setupSqlTransactionStorage(ctx, log.NewNopLogger(), db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"alice", "bob"} {
+			acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: id, RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+			if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+				t.Fatal(err)
+			}
+		}
+		deposit := transaction{ID: "deposit", Timestamp: time.Now(), Lines: []transactionLine{{AccountID: "alice", Purpose: ACHCredit, Amount: 10000}}}
+		if err := transactionRepo.createTransaction(ctx, deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Leave behind rows as partial failures would
+		now := time.Now()
+		insertTransaction := func(id, status string, expiresAt *time.Time, lines ...transactionLine) {
+			t.Helper()
+			if _, err := db.Exec(`insert into transactions (transaction_id, timestamp, created_at, status, expires_at) values (?, ?, ?, ?, ?);`, id, now, now, status, expiresAt); err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range lines {
+				if _, err := db.Exec(`insert into transaction_lines (transaction_id, account_id, purpose, amount, created_at) values (?, ?, ?, ?, ?);`, id, line.AccountID, line.Purpose, line.Amount, now); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		insertTransaction("ghost-deposit", "posted", nil, transactionLine{AccountID: "ghost", Purpose: ACHCredit, Amount: 500})
+		insertTransaction("mixed", "posted", nil, transactionLine{AccountID: "alice", Purpose: ACHDebit, Amount: 100}, transactionLine{AccountID: "phantom", Purpose: ACHCredit, Amount: 100})
+		insertTransaction("empty", "posted", nil)
+		expired, later := now.Add(-2*time.Hour), now.Add(time.Hour)
+		insertTransaction("expired-hold", "held", &expired, transactionLine{AccountID: "bob", Purpose: ACHDebit, Amount: 100}, transactionLine{AccountID: "alice", Purpose: ACHCredit, Amount: 100})
+		insertTransaction("hold", "held", &later, transactionLine{AccountID: "alice", Purpose: ACHDebit, Amount: 100}, transactionLine{AccountID: "bob", Purpose: ACHCredit, Amount: 100})
+
+		repo := &sqlOrphanRepository{db, log.NewNopLogger()}
+		detector := &orphanDetector{
+			logger:       log.NewNopLogger(),
+			repo:         repo,
+			accounts:     accountRepo,
+			transactions: &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}},
+		}
+		orphanBatchSize = 1 // page through accounts
+		defer func() { orphanBatchSize = 100 }()
+
+		// GET only reports
+		handler := orphanedData(log.NewNopLogger(), detector)
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/orphans", nil))
+		w.Flush()
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d", w.Code)
+		}
+		var report orphanReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		if len(report.OrphanedLines) != 2 || report.OrphanedLines[0].AccountID != "ghost" || report.OrphanedLines[1].TransactionID != "mixed" || report.OrphanedLines[0].Repaired {
+			t.Errorf("orphaned lines: %#v", report.OrphanedLines)
+		}
+		if len(report.EmptyTransactions) != 1 || report.EmptyTransactions[0].TransactionID != "empty" {
+			t.Errorf("empty transactions: %#v", report.EmptyTransactions)
+		}
+		if len(report.DanglingHolds) != 1 || report.DanglingHolds[0].TransactionID != "expired-hold" || len(report.DanglingHolds[0].AccountIDs) != 2 {
+			t.Errorf("dangling holds: %#v", report.DanglingHolds)
+		}
+		if len(report.Problems) != 0 {
+			t.Errorf("problems: %v", report.Problems)
+		}
+
+		// repairing deletes what can be deleted safely
+		out, err := detector.detect(ctx, time.Now(), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !out.OrphanedLines[0].Repaired || out.OrphanedLines[1].Repaired || !out.EmptyTransactions[0].Repaired || !out.DanglingHolds[0].Repaired {
+			t.Errorf("repairs: %#v", out)
+		}
+		if len(out.Problems) != 1 || !strings.Contains(out.Problems[0], "transaction=mixed") {
+			t.Errorf("problems: %v", out.Problems)
+		}
+		if tx, err := transactionRepo.getTransaction(ctx, "expired-hold"); err != nil || tx.Status != TransactionVoided {
+			t.Errorf("transaction=%#v error=%v", tx, err)
+		}
+		if tx, err := transactionRepo.getTransaction(ctx, "hold"); err != nil || tx.Status != TransactionHeld {
+			t.Errorf("transaction=%#v error=%v", tx, err)
+		}
+
+		out, err = detector.detect(ctx, time.Now(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out.OrphanedLines) != 1 || len(out.EmptyTransactions) != 0 || len(out.DanglingHolds) != 0 {
+			t.Errorf("report after repair: %#v", out)
+		}
+
+		// holds on closed accounts are dangling
+		if _, err := db.Exec(`update accounts set status = 'closed' where account_id = 'bob';`); err != nil {
+			t.Fatal(err)
+		}
+		if out, err := detector.detect(ctx, time.Now(), false); err != nil || len(out.DanglingHolds) != 1 || out.DanglingHolds[0].Reason != "account=bob is closed" {
+			t.Errorf("report=%#v error=%v", out, err)
+		}
+
+		// unsupported method
+		w = httptest.NewRecorder()
+		handler(w, httptest.NewRequest("DELETE", "/orphans", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, sqliteDB.DB)
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, mysqlDB.DB)
+}
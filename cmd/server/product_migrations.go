@@ -0,0 +1,452 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Product migrations move every account on one product to another on an effective date. Accounts keep their
+// overrides, and since interest, fees, limits and statements read an account's product when they're calculated the
+// new product's settings apply from then on. Each migrated account gets a notice event.
+
+type productMigrationStatus string
+
+const (
+	productMigrationScheduled productMigrationStatus = "scheduled"
+	productMigrationCompleted productMigrationStatus = "completed"
+	productMigrationCanceled  productMigrationStatus = "canceled"
+)
+
+type productMigration struct {
+	ID               string                 `json:"id"`
+	FromProductID    string                 `json:"fromProductId"`
+	ToProductID      string                 `json:"toProductId"`
+	EffectiveDate    string                 `json:"effectiveDate"` // YYYY-MM-DD, accounts are migrated from midnight UTC
+	Status           productMigrationStatus `json:"status"`
+	MigratedAccounts int                    `json:"migratedAccounts"`
+	CreatedBy        string                 `json:"createdBy"`
+	CreatedAt        time.Time              `json:"createdAt"`
+	CompletedAt      *time.Time             `json:"completedAt,omitempty"`
+}
+
+// effective returns true once the migration's effective date has started.
+func (m *productMigration) effective(now time.Time) bool {
+	return m.EffectiveDate <= now.UTC().Format("2006-01-02")
+}
+
+// productMigrationNotice is the data of the account.product_migrated event sent for each migrated account.
+type productMigrationNotice struct {
+	MigrationID   string          `json:"migrationId"`
+	AccountID     string          `json:"accountId"`
+	CustomerID    string          `json:"customerId"`
+	FromProductID string          `json:"fromProductId"`
+	ToProductID   string          `json:"toProductId"`
+	EffectiveDate string          `json:"effectiveDate"`
+	Settings      productSettings `json:"settings"`
+}
+
+// dailyLimitChange is a daily limit which differs between products. Nil limits are the DAILY_LIMIT_* default.
+type dailyLimitChange struct {
+	Class   limitClass `json:"class"`
+	Current *int64     `json:"current"`
+	New     *int64     `json:"new"`
+}
+
+// accountMigrationImpact compares an account's monthly interest and fees at its current balance, along with its
+// other settings, before and after a migration.
+type accountMigrationImpact struct {
+	AccountID             string             `json:"accountId"`
+	Balance               int64              `json:"balance"`
+	CurrentInterest       int64              `json:"currentInterest"`
+	NewInterest           int64              `json:"newInterest"`
+	CurrentFees           int64              `json:"currentFees"`
+	NewFees               int64              `json:"newFees"`
+	CurrentStatementCycle statementFrequency `json:"currentStatementCycle"`
+	NewStatementCycle     statementFrequency `json:"newStatementCycle"`
+	DailyLimitChanges     []dailyLimitChange `json:"dailyLimitChanges"`
+	FeaturesAdded         []productFeature   `json:"featuresAdded"`
+	FeaturesRemoved       []productFeature   `json:"featuresRemoved"`
+}
+
+// productMigrationReport is the impact of migrating every account on a product. InterestChange and FeesChange are
+// the total change in monthly interest and fees across those accounts.
+type productMigrationReport struct {
+	FromProductID  string                    `json:"fromProductId"`
+	ToProductID    string                    `json:"toProductId"`
+	EffectiveDate  string                    `json:"effectiveDate"`
+	Accounts       []*accountMigrationImpact `json:"accounts"`
+	InterestChange int64                     `json:"interestChange"`
+	FeesChange     int64                     `json:"feesChange"`
+}
+
+type productMigrationRequest struct {
+	FromProductID string `json:"fromProductId"`
+	ToProductID   string `json:"toProductId"`
+	EffectiveDate string `json:"effectiveDate"`
+}
+
+type productMigrationService struct {
+	logger   log.Logger
+	repo     productRepository
+	accounts accountRepository
+	events   eventPublisher
+}
+
+// readProducts returns the products of a migration, which must both exist.
+func (s *productMigrationService) readProducts(fromProductID, toProductID string) (*product, *product, error) {
+	if fromProductID == toProductID {
+		return nil, nil, errors.New("accounts must be migrated to a different product")
+	}
+	from, err := s.repo.getProduct(fromProductID)
+	if err != nil {
+		return nil, nil, err
+	}
+	to, err := s.repo.getProduct(toProductID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if from == nil || to == nil {
+		return nil, nil, fmt.Errorf("products %q and %q must both exist", fromProductID, toProductID)
+	}
+	return from, to, nil
+}
+
+// impact reports how migrating the accounts on a product would change them.
+func (s *productMigrationService) impact(ctx context.Context, req productMigrationRequest) (*productMigrationReport, error) {
+	from, to, err := s.readProducts(req.FromProductID, req.ToProductID)
+	if err != nil {
+		return nil, err
+	}
+	assignments, err := s.repo.getProductAccounts(from.ID)
+	if err != nil {
+		return nil, err
+	}
+	balances, err := s.balances(ctx, assignments)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &productMigrationReport{
+		FromProductID: from.ID,
+		ToProductID:   to.ID,
+		EffectiveDate: req.EffectiveDate,
+		Accounts:      make([]*accountMigrationImpact, 0, len(assignments)),
+	}
+	for _, assignment := range assignments {
+		current := from.Settings.merge(assignment.Overrides)
+		next := to.Settings.merge(assignment.Overrides)
+		balance := balances[assignment.AccountID]
+
+		impact := &accountMigrationImpact{
+			AccountID:             assignment.AccountID,
+			Balance:               balance,
+			CurrentStatementCycle: current.statementCycle(),
+			NewStatementCycle:     next.statementCycle(),
+			DailyLimitChanges:     dailyLimitChanges(current.DailyLimits, next.DailyLimits),
+			FeaturesAdded:         missingFeatures(next.Features, &current),
+			FeaturesRemoved:       missingFeatures(current.Features, &next),
+		}
+		impact.CurrentInterest, impact.CurrentFees = monthlyCharges(current, balance)
+		impact.NewInterest, impact.NewFees = monthlyCharges(next, balance)
+
+		report.Accounts = append(report.Accounts, impact)
+		report.InterestChange += impact.NewInterest - impact.CurrentInterest
+		report.FeesChange += impact.NewFees - impact.CurrentFees
+	}
+	return report, nil
+}
+
+// balances reads the balance of each assigned account. Accounts which aren't found have a zero balance.
+func (s *productMigrationService) balances(ctx context.Context, assignments []*accountProduct) (map[string]int64, error) {
+	var accountIDs []string
+	for i := range assignments {
+		accountIDs = append(accountIDs, assignments[i].AccountID)
+	}
+	accts, err := s.accounts.GetAccounts(ctx, accountIDs)
+	if err != nil {
+		return nil, fmt.Errorf("reading account balances: %v", err)
+	}
+	out := make(map[string]int64)
+	for i := range accts {
+		out[accts[i].ID] = int64(accts[i].Balance)
+	}
+	return out, nil
+}
+
+// monthlyCharges returns the interest paid and fees charged for a month on a balance with the settings.
+func monthlyCharges(settings productSettings, balance int64) (int64, int64) {
+	rules := &projectionRules{tiers: settings.InterestRateTiers, fees: settings.MonthlyFees}
+	_, interest := rules.monthlyInterest(balance)
+	var fees int64
+	for _, fee := range rules.monthlyFees(balance) {
+		fees += fee.Amount
+	}
+	return interest, fees
+}
+
+func dailyLimitChanges(current, next map[limitClass]int64) []dailyLimitChange {
+	out := []dailyLimitChange{}
+	for _, class := range []limitClass{limitATM, limitPOS, limitACHDebit} {
+		c, cok := current[class]
+		n, nok := next[class]
+		if cok == nok && c == n {
+			continue
+		}
+		change := dailyLimitChange{Class: class}
+		if cok {
+			change.Current = &c
+		}
+		if nok {
+			change.New = &n
+		}
+		out = append(out, change)
+	}
+	return out
+}
+
+// missingFeatures returns the features which settings don't have.
+func missingFeatures(features []productFeature, settings *productSettings) []productFeature {
+	out := []productFeature{}
+	for _, f := range features {
+		if !settings.hasFeature(f) {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// schedule saves a migration to run on its effective date, which can't be in the past.
+func (s *productMigrationService) schedule(ctx context.Context, req productMigrationRequest, userID string, now time.Time) (*productMigration, *productMigrationReport, error) {
+	m := &productMigration{
+		ID:            newID(),
+		FromProductID: req.FromProductID,
+		ToProductID:   req.ToProductID,
+		EffectiveDate: req.EffectiveDate,
+		Status:        productMigrationScheduled,
+		CreatedBy:     userID,
+		CreatedAt:     now,
+	}
+	if m.EffectiveDate < now.UTC().Format("2006-01-02") {
+		return nil, nil, fmt.Errorf("effectiveDate %s is in the past", m.EffectiveDate)
+	}
+	report, err := s.impact(ctx, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := s.repo.createProductMigration(m); err != nil {
+		return nil, nil, err
+	}
+	s.logger.Log("products", fmt.Sprintf("scheduled migration=%s of %d accounts from product=%s to product=%s on %s", m.ID, len(report.Accounts), m.FromProductID, m.ToProductID, m.EffectiveDate), "userID", userID)
+	return m, report, nil
+}
+
+// cancel stops a scheduled migration from running.
+func (s *productMigrationService) cancel(m *productMigration) error {
+	if m.Status != productMigrationScheduled {
+		return fmt.Errorf("migration=%s is already %s", m.ID, m.Status)
+	}
+	m.Status = productMigrationCanceled
+	return s.repo.updateProductMigration(m)
+}
+
+// migrateDue runs the scheduled migrations which have become effective, returning how many accounts were migrated.
+func (s *productMigrationService) migrateDue(ctx context.Context, now time.Time) (int, error) {
+	migrations, err := s.repo.getProductMigrations(productMigrationScheduled)
+	if err != nil {
+		return 0, err
+	}
+	// run the oldest first, so chained migrations move accounts along in the order they were scheduled
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].CreatedAt.Before(migrations[j].CreatedAt) })
+
+	migrated := 0
+	for _, m := range migrations {
+		if !m.effective(now) {
+			continue
+		}
+		n, err := s.migrate(ctx, m, now)
+		migrated += n
+		if err != nil {
+			return migrated, fmt.Errorf("migration=%s: %v", m.ID, err)
+		}
+	}
+	return migrated, nil
+}
+
+// migrate moves every account on the migration's product to the new one. Accounts which were already moved are
+// no longer on the old product, so a migration which fails part of the way through can be run again.
+func (s *productMigrationService) migrate(ctx context.Context, m *productMigration, now time.Time) (int, error) {
+	_, to, err := s.readProducts(m.FromProductID, m.ToProductID)
+	if err != nil {
+		return 0, err
+	}
+	assignments, err := s.repo.getProductAccounts(m.FromProductID)
+	if err != nil {
+		return 0, err
+	}
+	customers := make(map[string]string)
+	if len(assignments) > 0 {
+		var accountIDs []string
+		for i := range assignments {
+			accountIDs = append(accountIDs, assignments[i].AccountID)
+		}
+		accts, err := s.accounts.GetAccounts(ctx, accountIDs)
+		if err != nil {
+			return 0, fmt.Errorf("reading accounts: %v", err)
+		}
+		for i := range accts {
+			customers[accts[i].ID] = accts[i].CustomerID
+		}
+	}
+
+	for i, assignment := range assignments {
+		assignment.ProductID, assignment.AssignedAt = to.ID, now
+		if err := s.repo.assignProduct(assignment); err != nil {
+			m.MigratedAccounts += i
+			s.repo.updateProductMigration(m)
+			return i, err
+		}
+		notice := productMigrationNotice{
+			MigrationID:   m.ID,
+			AccountID:     assignment.AccountID,
+			CustomerID:    customers[assignment.AccountID],
+			FromProductID: m.FromProductID,
+			ToProductID:   m.ToProductID,
+			EffectiveDate: m.EffectiveDate,
+			Settings:      to.Settings.merge(assignment.Overrides),
+		}
+		if err := s.events.publish(newEvent("account.product_migrated", notice)); err != nil {
+			s.logger.Log("products", fmt.Sprintf("problem publishing migration notice for account=%s: %v", assignment.AccountID, err))
+		}
+	}
+
+	m.Status, m.CompletedAt = productMigrationCompleted, &now
+	m.MigratedAccounts += len(assignments)
+	if err := s.repo.updateProductMigration(m); err != nil {
+		return len(assignments), err
+	}
+	s.logger.Log("products", fmt.Sprintf("migrated %d accounts from product=%s to product=%s in migration=%s", len(assignments), m.FromProductID, m.ToProductID, m.ID))
+	return len(assignments), nil
+}
+
+// setupProductMigrationJob runs product migrations once they're effective.
+func setupProductMigrationJob(ctx context.Context, logger log.Logger, svc *productMigrationService, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				if _, err := svc.migrateDue(ctx, now); err != nil {
+					logger.Log("products", fmt.Sprintf("problem migrating products: %v", err))
+				}
+			}
+		}
+	}()
+}
+
+// productMigrations is an admin route which lists migrations (GET) or schedules one (POST). With ?dryRun=true the
+// impact of a migration is returned without scheduling it.
+func productMigrations(logger log.Logger, svc *productMigrationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			migrations, err := svc.repo.getProductMigrations(productMigrationStatus(r.URL.Query().Get("status")))
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if migrations == nil {
+				migrations = []*productMigration{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(migrations)
+
+		case "POST":
+			var req productMigrationRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if _, err := time.Parse("2006-01-02", req.EffectiveDate); err != nil {
+				moovhttp.Problem(w, fmt.Errorf("invalid effectiveDate: %v", err))
+				return
+			}
+			if dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dryRun")); dryRun {
+				report, err := svc.impact(r.Context(), req)
+				if err != nil {
+					moovhttp.Problem(w, err)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json; charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				json.NewEncoder(w).Encode(report)
+				return
+			}
+			m, report, err := svc.schedule(r.Context(), req, moovhttp.GetUserID(r), time.Now())
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(struct {
+				*productMigration
+				Impact *productMigrationReport `json:"impact"`
+			}{m, report})
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// productMigrationByID is an admin route which reads (GET) or cancels (DELETE) a migration. Only scheduled
+// migrations can be canceled.
+func productMigrationByID(logger log.Logger, svc *productMigrationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, err := svc.repo.getProductMigration(mux.Vars(r)["migrationId"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if m == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(m)
+
+		case "DELETE":
+			if err := svc.cancel(m); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("products", fmt.Sprintf("canceled migration=%s", m.ID), "userID", moovhttp.GetUserID(r))
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// createTestProductMigrationService returns a service with alice and bob on a basic product which can be migrated
+// to a premium product.
+func createTestProductMigrationService(t *testing.T, repo productRepository) (*productMigrationService, *product, *product, *mockEventPublisher) {
+	t.Helper()
+
+	accountRepo, _ := createTestLedger(t, map[string]int{"alice": 100000, "bob": 500000})
+	now := time.Now()
+	basic := &product{ID: base.ID(), Name: "Basic", CreatedAt: now, LastModified: now, Settings: productSettings{
+		MonthlyFees: []monthlyFee{{Name: "maintenance", Amount: 500, WaiveAbove: 200000}},
+		DailyLimits: map[limitClass]int64{limitATM: 50000},
+		Features:    []productFeature{featureCards},
+	}}
+	premium := &product{ID: base.ID(), Name: "Premium", CreatedAt: now, LastModified: now, Settings: productSettings{
+		InterestRateTiers: []interestTier{{MinBalance: 0, Rate: 0.012}},
+		DailyLimits:       map[limitClass]int64{limitATM: 100000, limitPOS: 200000},
+		StatementCycle:    statementsQuarterly,
+		Features:          []productFeature{featureCards, featureWires},
+	}}
+	for _, p := range []*product{basic, premium} {
+		if err := repo.createProduct(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, assignment := range []*accountProduct{
+		{AccountID: "alice", ProductID: basic.ID, AssignedAt: now},
+		{AccountID: "bob", ProductID: basic.ID, Overrides: productSettings{DailyLimits: map[limitClass]int64{limitATM: 80000}}, AssignedAt: now},
+	} {
+		if err := repo.assignProduct(assignment); err != nil {
+			t.Fatal(err)
+		}
+	}
+	events := &mockEventPublisher{}
+	return &productMigrationService{logger: log.NewNopLogger(), repo: repo, accounts: accountRepo, events: events}, basic, premium, events
+}
+
+func TestProductMigrations__impact(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	svc, basic, premium, _ := createTestProductMigrationService(t, &sqlProductRepository{db.DB, log.NewNopLogger()})
+
+	report, err := svc.impact(context.Background(), productMigrationRequest{FromProductID: basic.ID, ToProductID: premium.ID, EffectiveDate: "2020-07-01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Accounts) != 2 || report.InterestChange != 600 || report.FeesChange != -500 {
+		t.Fatalf("report=%#v", report)
+	}
+	alice, bob := report.Accounts[0], report.Accounts[1]
+	if alice.AccountID != "alice" || alice.Balance != 100000 || alice.CurrentInterest != 0 || alice.NewInterest != 100 || alice.CurrentFees != 500 || alice.NewFees != 0 {
+		t.Errorf("alice=%#v", alice)
+	}
+	if alice.CurrentStatementCycle != statementsMonthly || alice.NewStatementCycle != statementsQuarterly {
+		t.Errorf("alice=%#v", alice)
+	}
+	if !reflect.DeepEqual(alice.FeaturesAdded, []productFeature{featureWires}) || len(alice.FeaturesRemoved) != 0 {
+		t.Errorf("alice=%#v", alice)
+	}
+	if len(alice.DailyLimitChanges) != 2 || *alice.DailyLimitChanges[0].Current != 50000 || *alice.DailyLimitChanges[0].New != 100000 || alice.DailyLimitChanges[1].Current != nil {
+		t.Errorf("alice limits=%#v", alice.DailyLimitChanges)
+	}
+	// bob's fee is waived and his ATM limit override stays with him
+	if bob.CurrentFees != 0 || bob.NewInterest != 500 || len(bob.DailyLimitChanges) != 1 || bob.DailyLimitChanges[0].Class != limitPOS {
+		t.Errorf("bob=%#v", bob)
+	}
+
+	if _, err := svc.impact(context.Background(), productMigrationRequest{FromProductID: basic.ID, ToProductID: basic.ID}); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := svc.impact(context.Background(), productMigrationRequest{FromProductID: basic.ID, ToProductID: "missing"}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestProductMigrations__migrate(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlProductRepository{db.DB, log.NewNopLogger()}
+	svc, basic, premium, events := createTestProductMigrationService(t, repo)
+
+	now := time.Date(2020, time.June, 15, 12, 0, 0, 0, time.UTC)
+	req := productMigrationRequest{FromProductID: basic.ID, ToProductID: premium.ID, EffectiveDate: "2020-06-14"}
+	if _, _, err := svc.schedule(context.Background(), req, "ops", now); err == nil || !strings.Contains(err.Error(), "in the past") {
+		t.Errorf("expected error: %v", err)
+	}
+	req.EffectiveDate = "2020-07-01"
+	m, report, err := svc.schedule(context.Background(), req, "ops", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Accounts) != 2 || m.Status != productMigrationScheduled || m.CreatedBy != "ops" {
+		t.Errorf("migration=%#v report=%#v", m, report)
+	}
+
+	// nothing moves before the effective date
+	if n, err := svc.migrateDue(context.Background(), now); err != nil || n != 0 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+	if n, err := svc.migrateDue(context.Background(), time.Date(2020, time.July, 1, 0, 1, 0, 0, time.UTC)); err != nil || n != 2 {
+		t.Fatalf("n=%d error=%v", n, err)
+	}
+	settings, err := (&productCatalog{repo: repo}).settingsOf("bob")
+	if err != nil || settings.DailyLimits[limitATM] != 80000 || !settings.hasFeature(featureWires) {
+		t.Errorf("settings=%#v error=%v", settings, err)
+	}
+	if n, err := repo.countProductAccounts(basic.ID); err != nil || n != 0 {
+		t.Errorf("n=%d error=%v", n, err)
+	}
+
+	if len(events.events) != 2 {
+		t.Fatalf("events=%#v", events.events)
+	}
+	notice, ok := events.events[0].Data.(productMigrationNotice)
+	if !ok || events.events[0].Type != "account.product_migrated" || notice.AccountID != "alice" || notice.CustomerID != "customer" || notice.MigrationID != m.ID {
+		t.Errorf("event=%#v", events.events[0])
+	}
+
+	m, err = repo.getProductMigration(m.ID)
+	if err != nil || m.Status != productMigrationCompleted || m.MigratedAccounts != 2 || m.CompletedAt == nil {
+		t.Errorf("migration=%#v error=%v", m, err)
+	}
+	if err := svc.cancel(m); err == nil {
+		t.Error("expected completed migrations not to be canceled")
+	}
+}
+
+func TestProductMigrations__storage(t *testing.T) {
+	check := func(t *testing.T, repo productRepository) {
+		now := time.Now().Truncate(time.Second)
+		m := &productMigration{ID: base.ID(), FromProductID: "a", ToProductID: "b", EffectiveDate: "2020-07-01", Status: productMigrationScheduled, CreatedBy: "ops", CreatedAt: now}
+		if err := repo.createProductMigration(m); err != nil {
+			t.Fatal(err)
+		}
+		other := &productMigration{ID: base.ID(), FromProductID: "b", ToProductID: "c", EffectiveDate: "2020-08-01", Status: productMigrationScheduled, CreatedAt: now.Add(time.Second)}
+		if err := repo.createProductMigration(other); err != nil {
+			t.Fatal(err)
+		}
+		m.Status, m.MigratedAccounts, m.CompletedAt = productMigrationCompleted, 3, &now
+		if err := repo.updateProductMigration(m); err != nil {
+			t.Fatal(err)
+		}
+
+		found, err := repo.getProductMigration(m.ID)
+		if err != nil || found == nil || found.Status != productMigrationCompleted || found.MigratedAccounts != 3 || !found.CompletedAt.Equal(now) {
+			t.Errorf("migration=%#v error=%v", found, err)
+		}
+		if migrations, err := repo.getProductMigrations(""); err != nil || len(migrations) != 2 || migrations[0].ID != other.ID {
+			t.Errorf("migrations=%#v error=%v", migrations, err)
+		}
+		if migrations, err := repo.getProductMigrations(productMigrationScheduled); err != nil || len(migrations) != 1 || migrations[0].ID != other.ID {
+			t.Errorf("migrations=%#v error=%v", migrations, err)
+		}
+		if found, err := repo.getProductMigration("missing"); err != nil || found != nil {
+			t.Errorf("migration=%#v error=%v", found, err)
+		}
+
+		for _, accountID := range []string{"bob", "alice"} {
+			if err := repo.assignProduct(&accountProduct{AccountID: accountID, ProductID: "a", AssignedAt: now}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if assignments, err := repo.getProductAccounts("a"); err != nil || len(assignments) != 2 || assignments[0].AccountID != "alice" {
+			t.Errorf("assignments=%#v error=%v", assignments, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlProductRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlProductRepository{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestProductMigrations__routes(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlProductRepository{db.DB, log.NewNopLogger()}
+	svc, basic, premium, _ := createTestProductMigrationService(t, repo)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/products/migrations", productMigrations(log.NewNopLogger(), svc))
+	router.HandleFunc("/products/migrations/{migrationId}", productMigrationByID(log.NewNopLogger(), svc))
+	router.HandleFunc("/products/{productId}", productByID(log.NewNopLogger(), repo))
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		w.Flush()
+		return w
+	}
+	effective := time.Now().AddDate(0, 1, 0).Format("2006-01-02")
+	body := `{"fromProductId": "` + basic.ID + `", "toProductId": "` + premium.ID + `", "effectiveDate": "` + effective + `"}`
+
+	if w := serve("POST", "/products/migrations", `{"fromProductId": "`+basic.ID+`", "toProductId": "`+premium.ID+`", "effectiveDate": "July"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// dry runs report the impact without scheduling anything
+	w := serve("POST", "/products/migrations?dryRun=true", body)
+	var report productMigrationReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil || len(report.Accounts) != 2 {
+		t.Fatalf("report=%#v error=%v", report, err)
+	}
+	if migrations, _ := repo.getProductMigrations(""); len(migrations) != 0 {
+		t.Errorf("migrations=%#v", migrations)
+	}
+
+	w = serve("POST", "/products/migrations", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var created struct {
+		productMigration
+		Impact productMigrationReport `json:"impact"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || created.ID == "" || len(created.Impact.Accounts) != 2 {
+		t.Fatalf("migration=%#v error=%v", created, err)
+	}
+
+	// the target product of a scheduled migration can't be deleted
+	if w := serve("DELETE", "/products/"+premium.ID, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("GET", "/products/migrations/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("DELETE", "/products/migrations/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("DELETE", "/products/migrations/"+created.ID, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	w = serve("GET", "/products/migrations?status=canceled", "")
+	var migrations []*productMigration
+	if err := json.NewDecoder(w.Body).Decode(&migrations); err != nil || len(migrations) != 1 || migrations[0].Status != productMigrationCanceled {
+		t.Errorf("migrations=%#v error=%v", migrations, err)
+	}
+	if w := serve("GET", "/products/migrations/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type productRepository interface {
+	Ping() error
+	Close() error
+
+	createProduct(p *product) error
+	updateProduct(p *product) error
+	deleteProduct(productID string) error
+
+	// getProduct returns nil if the product doesn't exist.
+	getProduct(productID string) (*product, error)
+	getProducts() ([]*product, error)
+
+	// assignProduct creates or replaces the account's product assignment.
+	assignProduct(assignment *accountProduct) error
+
+	// unassignProduct returns false if the account wasn't assigned a product.
+	unassignProduct(accountID string) (bool, error)
+
+	// getAccountProduct returns nil if the account isn't assigned a product.
+	getAccountProduct(accountID string) (*accountProduct, error)
+
+	// countProductAccounts returns how many accounts are assigned the product.
+	countProductAccounts(productID string) (int, error)
+
+	// getProductAccounts returns the assignments of accounts on the product, ordered by account ID.
+	getProductAccounts(productID string) ([]*accountProduct, error)
+
+	createProductMigration(m *productMigration) error
+
+	// updateProductMigration saves the migration's status, migrated accounts and completion time.
+	updateProductMigration(m *productMigration) error
+
+	// getProductMigration returns nil if the migration doesn't exist.
+	getProductMigration(migrationID string) (*productMigration, error)
+
+	// getProductMigrations returns migrations with the status (or every migration when empty), newest first.
+	getProductMigrations(status productMigrationStatus) ([]*productMigration, error)
+}
+
+type sqlProductRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlProductRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlProductRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlProductRepository) createProduct(p *product) error {
+	settings, err := json.Marshal(p.Settings)
+	if err != nil {
+		return fmt.Errorf("createProduct: product=%s: %v", p.ID, err)
+	}
+
+	query := `insert into products (product_id, name, description, settings, created_at, last_modified) values (?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createProduct: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(p.ID, p.Name, p.Description, string(settings), p.CreatedAt, p.LastModified); err != nil {
+		return fmt.Errorf("createProduct: product=%s: %v", p.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlProductRepository) updateProduct(p *product) error {
+	settings, err := json.Marshal(p.Settings)
+	if err != nil {
+		return fmt.Errorf("updateProduct: product=%s: %v", p.ID, err)
+	}
+
+	query := `update products set name = ?, description = ?, settings = ?, last_modified = ? where product_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("updateProduct: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(p.Name, p.Description, string(settings), p.LastModified, p.ID); err != nil {
+		return fmt.Errorf("updateProduct: product=%s: %v", p.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlProductRepository) deleteProduct(productID string) error {
+	query := `update products set deleted_at = ? where product_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("deleteProduct: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(time.Now(), productID); err != nil {
+		return fmt.Errorf("deleteProduct: product=%s: %v", productID, err)
+	}
+	return nil
+}
+
+func (r *sqlProductRepository) getProduct(productID string) (*product, error) {
+	products, err := r.queryProducts(`product_id = ?`, productID)
+	if err != nil {
+		return nil, fmt.Errorf("getProduct: %v", err)
+	}
+	if len(products) == 0 {
+		return nil, nil
+	}
+	return products[0], nil
+}
+
+func (r *sqlProductRepository) getProducts() ([]*product, error) {
+	products, err := r.queryProducts(`1 = 1`)
+	if err != nil {
+		return nil, fmt.Errorf("getProducts: %v", err)
+	}
+	return products, nil
+}
+
+func (r *sqlProductRepository) queryProducts(where string, args ...interface{}) ([]*product, error) {
+	query := fmt.Sprintf(`select product_id, name, description, settings, created_at, last_modified
+from products where %s and deleted_at is null order by name, product_id;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*product
+	for rows.Next() {
+		var p product
+		var settings string
+		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &settings, &p.CreatedAt, &p.LastModified); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		if err := json.Unmarshal([]byte(settings), &p.Settings); err != nil {
+			return nil, fmt.Errorf("product=%s settings: %v", p.ID, err)
+		}
+		out = append(out, &p)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlProductRepository) assignProduct(assignment *accountProduct) error {
+	overrides, err := json.Marshal(assignment.Overrides)
+	if err != nil {
+		return fmt.Errorf("assignProduct: account=%s: %v", assignment.AccountID, err)
+	}
+
+	query := `replace into account_products (account_id, product_id, overrides, assigned_at) values (?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("assignProduct: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(assignment.AccountID, assignment.ProductID, string(overrides), assignment.AssignedAt); err != nil {
+		return fmt.Errorf("assignProduct: account=%s product=%s: %v", assignment.AccountID, assignment.ProductID, err)
+	}
+	return nil
+}
+
+func (r *sqlProductRepository) unassignProduct(accountID string) (bool, error) {
+	query := `delete from account_products where account_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, fmt.Errorf("unassignProduct: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(accountID)
+	if err != nil {
+		return false, fmt.Errorf("unassignProduct: account=%s: %v", accountID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("unassignProduct: account=%s: %v", accountID, err)
+	}
+	return n == 1, nil
+}
+
+func (r *sqlProductRepository) getAccountProduct(accountID string) (*accountProduct, error) {
+	query := `select account_id, product_id, overrides, assigned_at from account_products where account_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("getAccountProduct: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	var assignment accountProduct
+	var overrides string
+	if err := stmt.QueryRow(accountID).Scan(&assignment.AccountID, &assignment.ProductID, &overrides, &assignment.AssignedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getAccountProduct: account=%s: %v", accountID, err)
+	}
+	if err := json.Unmarshal([]byte(overrides), &assignment.Overrides); err != nil {
+		return nil, fmt.Errorf("getAccountProduct: account=%s overrides: %v", accountID, err)
+	}
+	return &assignment, nil
+}
+
+func (r *sqlProductRepository) countProductAccounts(productID string) (int, error) {
+	query := `select count(*) from account_products where product_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return 0, fmt.Errorf("countProductAccounts: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	var n int
+	if err := stmt.QueryRow(productID).Scan(&n); err != nil {
+		return 0, fmt.Errorf("countProductAccounts: product=%s: %v", productID, err)
+	}
+	return n, nil
+}
+
+func (r *sqlProductRepository) getProductAccounts(productID string) ([]*accountProduct, error) {
+	query := `select account_id, product_id, overrides, assigned_at from account_products where product_id = ? order by account_id;`
+	rows, err := r.db.Query(query, productID)
+	if err != nil {
+		return nil, fmt.Errorf("getProductAccounts: product=%s: %v", productID, err)
+	}
+	defer rows.Close()
+
+	var out []*accountProduct
+	for rows.Next() {
+		var assignment accountProduct
+		var overrides string
+		if err := rows.Scan(&assignment.AccountID, &assignment.ProductID, &overrides, &assignment.AssignedAt); err != nil {
+			return nil, fmt.Errorf("getProductAccounts: scan: %v", err)
+		}
+		if err := json.Unmarshal([]byte(overrides), &assignment.Overrides); err != nil {
+			return nil, fmt.Errorf("getProductAccounts: account=%s overrides: %v", assignment.AccountID, err)
+		}
+		out = append(out, &assignment)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlProductRepository) createProductMigration(m *productMigration) error {
+	query := `insert into product_migrations (migration_id, from_product_id, to_product_id, effective_date, status, migrated_accounts, created_by, created_at) values (?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createProductMigration: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(m.ID, m.FromProductID, m.ToProductID, m.EffectiveDate, m.Status, m.MigratedAccounts, m.CreatedBy, m.CreatedAt); err != nil {
+		return fmt.Errorf("createProductMigration: migration=%s: %v", m.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlProductRepository) updateProductMigration(m *productMigration) error {
+	query := `update product_migrations set status = ?, migrated_accounts = ?, completed_at = ? where migration_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("updateProductMigration: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(m.Status, m.MigratedAccounts, m.CompletedAt, m.ID); err != nil {
+		return fmt.Errorf("updateProductMigration: migration=%s: %v", m.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlProductRepository) getProductMigration(migrationID string) (*productMigration, error) {
+	migrations, err := r.queryProductMigrations(`migration_id = ?`, migrationID)
+	if err != nil {
+		return nil, fmt.Errorf("getProductMigration: %v", err)
+	}
+	if len(migrations) == 0 {
+		return nil, nil
+	}
+	return migrations[0], nil
+}
+
+func (r *sqlProductRepository) getProductMigrations(status productMigrationStatus) ([]*productMigration, error) {
+	where, args := `1 = 1`, []interface{}{}
+	if status != "" {
+		where, args = `status = ?`, append(args, status)
+	}
+	migrations, err := r.queryProductMigrations(where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getProductMigrations: %v", err)
+	}
+	return migrations, nil
+}
+
+func (r *sqlProductRepository) queryProductMigrations(where string, args ...interface{}) ([]*productMigration, error) {
+	query := fmt.Sprintf(`select migration_id, from_product_id, to_product_id, effective_date, status, migrated_accounts, created_by, created_at, completed_at
+from product_migrations where %s order by created_at desc, migration_id desc;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*productMigration
+	for rows.Next() {
+		var m productMigration
+		if err := rows.Scan(&m.ID, &m.FromProductID, &m.ToProductID, &m.EffectiveDate, &m.Status, &m.MigratedAccounts, &m.CreatedBy, &m.CreatedAt, &m.CompletedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		out = append(out, &m)
+	}
+	return out, rows.Err()
+}
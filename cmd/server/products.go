@@ -0,0 +1,511 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Products bundle the interest rate tiers, monthly and transaction fees, daily limits, statement cycle, time zone and
+// features of accounts so they're configured once for every account on a product. Accounts are assigned a product on
+// the admin port, along with overrides of its settings for that account. Accounts without a product keep the settings read
+// from the environment and every feature.
+
+var errProductFeature = errors.New("account's product doesn't include feature")
+
+type productFeature string
+
+const (
+	// featureCards lets card transactions debit the account
+	featureCards productFeature = "cards"
+
+	// featureWires allows Wire lines on the account
+	featureWires productFeature = "wires"
+)
+
+func (f productFeature) validate() error {
+	switch f {
+	case featureCards, featureWires:
+		return nil
+	default:
+		return fmt.Errorf("unknown product feature %q, expected cards or wires", f)
+	}
+}
+
+// statementFrequency is how often an account's statements are generated.
+type statementFrequency string
+
+const (
+	statementsMonthly statementFrequency = "monthly"
+
+	// statementsQuarterly statements are generated by the runs of March, June, September and December cycles
+	// and cover the three months ending with them
+	statementsQuarterly statementFrequency = "quarterly"
+
+	statementsNone statementFrequency = "none"
+)
+
+func (f statementFrequency) validate() error {
+	switch f {
+	case statementsMonthly, statementsQuarterly, statementsNone:
+		return nil
+	default:
+		return fmt.Errorf("unknown statement cycle %q, expected monthly, quarterly or none", f)
+	}
+}
+
+// productSettings are what a product configures. In overrides, nil lists and an empty statement cycle or time zone
+// keep the product's settings while daily limits are overridden per class.
+type productSettings struct {
+	InterestRateTiers []interestTier       `json:"interestRateTiers"`
+	MonthlyFees       []monthlyFee         `json:"monthlyFees"`
+	TransactionFees   []feeRule            `json:"transactionFees"`
+	DailyLimits       map[limitClass]int64 `json:"dailyLimits,omitempty"`
+	StatementCycle    statementFrequency   `json:"statementCycle,omitempty"`
+	Features          []productFeature     `json:"features"`
+
+	// TimeZone is the IANA time zone (such as America/Los_Angeles) daily limits and statement cycles follow
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+func (s *productSettings) validate() error {
+	for _, tier := range s.InterestRateTiers {
+		if tier.MinBalance < 0 || tier.Rate < 0 {
+			return fmt.Errorf("invalid interest rate tier %#v", tier)
+		}
+	}
+	sort.Slice(s.InterestRateTiers, func(i, j int) bool { return s.InterestRateTiers[i].MinBalance < s.InterestRateTiers[j].MinBalance })
+	for _, fee := range s.MonthlyFees {
+		if fee.Name == "" || fee.Amount < 0 || fee.WaiveAbove < 0 {
+			return fmt.Errorf("invalid monthly fee %#v", fee)
+		}
+	}
+	names := make(map[string]bool)
+	for i := range s.TransactionFees {
+		if err := s.TransactionFees[i].validate(); err != nil {
+			return err
+		}
+		if names[s.TransactionFees[i].Name] {
+			return fmt.Errorf("transaction fee %s is repeated", s.TransactionFees[i].Name)
+		}
+		names[s.TransactionFees[i].Name] = true
+	}
+	for class, amount := range s.DailyLimits {
+		if err := class.validate(); err != nil {
+			return err
+		}
+		if amount < 0 {
+			return fmt.Errorf("daily %s limit must be zero or more cents", class)
+		}
+	}
+	if s.StatementCycle != "" {
+		if err := s.StatementCycle.validate(); err != nil {
+			return err
+		}
+	}
+	for _, f := range s.Features {
+		if err := f.validate(); err != nil {
+			return err
+		}
+	}
+	if s.TimeZone != "" {
+		if _, err := loadTimeZone(s.TimeZone); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge returns the settings with overrides applied.
+func (s productSettings) merge(overrides productSettings) productSettings {
+	out := s
+	if overrides.InterestRateTiers != nil {
+		out.InterestRateTiers = overrides.InterestRateTiers
+	}
+	if overrides.MonthlyFees != nil {
+		out.MonthlyFees = overrides.MonthlyFees
+	}
+	if overrides.TransactionFees != nil {
+		out.TransactionFees = overrides.TransactionFees
+	}
+	if len(overrides.DailyLimits) > 0 {
+		out.DailyLimits = make(map[limitClass]int64)
+		for class, amount := range s.DailyLimits {
+			out.DailyLimits[class] = amount
+		}
+		for class, amount := range overrides.DailyLimits {
+			out.DailyLimits[class] = amount
+		}
+	}
+	if overrides.StatementCycle != "" {
+		out.StatementCycle = overrides.StatementCycle
+	}
+	if overrides.Features != nil {
+		out.Features = overrides.Features
+	}
+	if overrides.TimeZone != "" {
+		out.TimeZone = overrides.TimeZone
+	}
+	return out
+}
+
+// hasFeature returns true for accounts without a product, which have every feature.
+func (s *productSettings) hasFeature(f productFeature) bool {
+	if s == nil {
+		return true
+	}
+	for i := range s.Features {
+		if s.Features[i] == f {
+			return true
+		}
+	}
+	return false
+}
+
+// statementCycle returns how often the account's statements are generated, which is monthly without a product.
+func (s *productSettings) statementCycle() statementFrequency {
+	if s == nil || s.StatementCycle == "" {
+		return statementsMonthly
+	}
+	return s.StatementCycle
+}
+
+// location returns the time zone the account's days and months start in, which is LEDGER_TIME_ZONE without a
+// product or when the product doesn't set one.
+func (s *productSettings) location() *time.Location {
+	if s == nil || s.TimeZone == "" {
+		return ledgerLocation
+	}
+	loc, err := loadTimeZone(s.TimeZone)
+	if err != nil {
+		return ledgerLocation // time zones are validated when saved
+	}
+	return loc
+}
+
+type product struct {
+	ID           string          `json:"id"`
+	Name         string          `json:"name"`
+	Description  string          `json:"description"`
+	Settings     productSettings `json:"settings"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	LastModified time.Time       `json:"lastModified"`
+}
+
+// accountProduct assigns an account to a product.
+type accountProduct struct {
+	AccountID  string          `json:"accountId"`
+	ProductID  string          `json:"productId"`
+	Overrides  productSettings `json:"overrides"`
+	AssignedAt time.Time       `json:"assignedAt"`
+}
+
+// productCatalog reads the settings of each account from its product. A nil catalog leaves every account without
+// a product.
+type productCatalog struct {
+	repo productRepository
+}
+
+// settingsOf returns the account's product settings with its overrides applied, or nil if it isn't assigned a product.
+func (c *productCatalog) settingsOf(accountID string) (*productSettings, error) {
+	if c == nil {
+		return nil, nil
+	}
+	assignment, err := c.repo.getAccountProduct(accountID)
+	if err != nil || assignment == nil {
+		return nil, err
+	}
+	p, err := c.repo.getProduct(assignment.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, fmt.Errorf("account=%s is assigned missing product=%s", accountID, assignment.ProductID)
+	}
+	settings := p.Settings.merge(assignment.Overrides)
+	return &settings, nil
+}
+
+// locationOf returns the time zone of the account's product settings, see productSettings.location.
+func (c *productCatalog) locationOf(accountID string) (*time.Location, error) {
+	settings, err := c.settingsOf(accountID)
+	if err != nil {
+		return nil, err
+	}
+	return settings.location(), nil
+}
+
+// productTransactionRepository rejects transactions which use a feature the product of one of their accounts
+// doesn't include. Force posts, initial deposits and reversals aren't checked.
+type productTransactionRepository struct {
+	transactionRepository
+
+	products *productCatalog
+}
+
+func (r *productTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	if !opts.AllowOverdraft && !opts.InitialDeposit && !opts.Reversal {
+		if err := r.checkFeatures(tx); err != nil {
+			return err
+		}
+	}
+	return r.transactionRepository.createTransaction(ctx, tx, opts)
+}
+
+func (r *productTransactionRepository) checkFeatures(tx transaction) error {
+	card := tx.mcc() != ""
+	for i := range tx.Lines {
+		var feature productFeature
+		switch {
+		case tx.Lines[i].Purpose == Wire:
+			feature = featureWires
+		case card && tx.Lines[i].Purpose == ACHDebit:
+			feature = featureCards
+		default:
+			continue
+		}
+		settings, err := r.products.settingsOf(tx.Lines[i].AccountID)
+		if err != nil {
+			return fmt.Errorf("checkFeatures: account=%s: %v", tx.Lines[i].AccountID, err)
+		}
+		if !settings.hasFeature(feature) {
+			return fmt.Errorf("transaction=%s: %v: account=%s feature=%s", tx.ID, errProductFeature, tx.Lines[i].AccountID, feature)
+		}
+	}
+	return nil
+}
+
+type productRequest struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Settings    productSettings `json:"settings"`
+}
+
+func (req *productRequest) validate() error {
+	if req.Name = strings.TrimSpace(req.Name); req.Name == "" {
+		return errors.New("products need a name")
+	}
+	return req.Settings.validate()
+}
+
+// products is an admin route which lists products (GET) or creates one (POST).
+func products(logger log.Logger, repo productRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			list, err := repo.getProducts()
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if list == nil {
+				list = []*product{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(list)
+
+		case "POST":
+			var req productRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if err := req.validate(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			now := time.Now()
+			p := &product{
+				ID:           newID(),
+				Name:         req.Name,
+				Description:  req.Description,
+				Settings:     req.Settings,
+				CreatedAt:    now,
+				LastModified: now,
+			}
+			if err := repo.createProduct(p); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("products", fmt.Sprintf("created product=%s %q", p.ID, p.Name), "userID", moovhttp.GetUserID(r))
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(p)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// productByID is an admin route which reads (GET), replaces (PUT) or deletes (DELETE) a product. Products can't be
+// deleted while accounts are assigned to them or scheduled migrations move accounts to them.
+func productByID(logger log.Logger, repo productRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := repo.getProduct(mux.Vars(r)["productId"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(p)
+
+		case "PUT":
+			var req productRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if err := req.validate(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			p.Name, p.Description, p.Settings = req.Name, req.Description, req.Settings
+			p.LastModified = time.Now()
+			if err := repo.updateProduct(p); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("products", fmt.Sprintf("updated product=%s", p.ID), "userID", moovhttp.GetUserID(r))
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(p)
+
+		case "DELETE":
+			n, err := repo.countProductAccounts(p.ID)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if n > 0 {
+				moovhttp.Problem(w, fmt.Errorf("product=%s is assigned to %d accounts", p.ID, n))
+				return
+			}
+			migrations, err := repo.getProductMigrations(productMigrationScheduled)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			for i := range migrations {
+				if migrations[i].ToProductID == p.ID {
+					moovhttp.Problem(w, fmt.Errorf("product=%s is the target of migration=%s", p.ID, migrations[i].ID))
+					return
+				}
+			}
+			if err := repo.deleteProduct(p.ID); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("products", fmt.Sprintf("deleted product=%s", p.ID), "userID", moovhttp.GetUserID(r))
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// assignedProduct is an account's product assignment along with the settings it ends up with.
+type assignedProduct struct {
+	accountProduct
+
+	Settings productSettings `json:"settings"`
+}
+
+// accountProductAssignment is an admin route which reads (GET), sets (PUT) or removes (DELETE) the product an
+// account is assigned and its overrides.
+func accountProductAssignment(logger log.Logger, repo productRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID := mux.Vars(r)["accountId"]
+		switch r.Method {
+		case "GET":
+			assignment, err := repo.getAccountProduct(accountID)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if assignment == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			settings, err := (&productCatalog{repo: repo}).settingsOf(accountID)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(assignedProduct{accountProduct: *assignment, Settings: *settings})
+
+		case "PUT":
+			var req struct {
+				ProductID string          `json:"productId"`
+				Overrides productSettings `json:"overrides"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if err := req.Overrides.validate(); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			p, err := repo.getProduct(req.ProductID)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if p == nil {
+				moovhttp.Problem(w, fmt.Errorf("product=%q not found", req.ProductID))
+				return
+			}
+			assignment := &accountProduct{AccountID: accountID, ProductID: p.ID, Overrides: req.Overrides, AssignedAt: time.Now()}
+			if err := repo.assignProduct(assignment); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("products", fmt.Sprintf("assigned account=%s to product=%s", accountID, p.ID), "userID", moovhttp.GetUserID(r))
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(assignedProduct{accountProduct: *assignment, Settings: p.Settings.merge(req.Overrides)})
+
+		case "DELETE":
+			found, err := repo.unassignProduct(accountID)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			logger.Log("products", fmt.Sprintf("removed account=%s from its product", accountID), "userID", moovhttp.GetUserID(r))
+			w.WriteHeader(http.StatusOK)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
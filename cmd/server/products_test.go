@@ -0,0 +1,390 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestProducts__settings(t *testing.T) {
+	cases := map[string]productSettings{
+		"invalid interest rate tier": {InterestRateTiers: []interestTier{{MinBalance: 0, Rate: -0.01}}},
+		"invalid monthly fee":        {MonthlyFees: []monthlyFee{{Amount: 500}}},
+		"unknown limit class":        {DailyLimits: map[limitClass]int64{"wire": 100}},
+		"zero or more cents":         {DailyLimits: map[limitClass]int64{limitPOS: -1}},
+		"unknown statement cycle":    {StatementCycle: "weekly"},
+		"unknown product feature":    {Features: []productFeature{"crypto"}},
+		"unknown time zone":          {TimeZone: "Mars/Olympus"},
+	}
+	for expected, settings := range cases {
+		if err := settings.validate(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q error: %v", expected, err)
+		}
+	}
+
+	product := productSettings{
+		InterestRateTiers: []interestTier{{MinBalance: 0, Rate: 0.01}},
+		MonthlyFees:       []monthlyFee{{Name: "maintenance", Amount: 500}},
+		DailyLimits:       map[limitClass]int64{limitATM: 50000, limitPOS: 100000},
+		StatementCycle:    statementsQuarterly,
+		Features:          []productFeature{featureCards},
+	}
+	merged := product.merge(productSettings{
+		MonthlyFees: []monthlyFee{},
+		DailyLimits: map[limitClass]int64{limitATM: 80000},
+		TimeZone:    "America/Los_Angeles",
+	})
+	if len(merged.MonthlyFees) != 0 || !reflect.DeepEqual(merged.InterestRateTiers, product.InterestRateTiers) || merged.StatementCycle != statementsQuarterly {
+		t.Errorf("merged=%#v", merged)
+	}
+	if expected := map[limitClass]int64{limitATM: 80000, limitPOS: 100000}; !reflect.DeepEqual(merged.DailyLimits, expected) {
+		t.Errorf("limits=%#v", merged.DailyLimits)
+	}
+	if product.DailyLimits[limitATM] != 50000 {
+		t.Error("merging changed the product's limits")
+	}
+	if !merged.hasFeature(featureCards) || merged.hasFeature(featureWires) {
+		t.Errorf("features=%#v", merged.Features)
+	}
+
+	if merged.location().String() != "America/Los_Angeles" {
+		t.Errorf("location=%v", merged.location())
+	}
+
+	// accounts without a product have every feature, monthly statements and the ledger's time zone
+	var none *productSettings
+	if !none.hasFeature(featureWires) || none.statementCycle() != statementsMonthly || none.location() != ledgerLocation {
+		t.Error("unexpected settings without a product")
+	}
+}
+
+func TestProductRepository(t *testing.T) {
+	check := func(t *testing.T, repo productRepository) {
+		now := time.Now().Truncate(time.Second)
+		p := &product{ID: base.ID(), Name: "Basic Checking", Settings: productSettings{
+			DailyLimits: map[limitClass]int64{limitATM: 50000},
+			Features:    []productFeature{featureCards},
+		}, CreatedAt: now, LastModified: now}
+		if err := repo.createProduct(p); err != nil {
+			t.Fatal(err)
+		}
+		p.Description = "Everyday spending"
+		p.Settings.StatementCycle = statementsQuarterly
+		if err := repo.updateProduct(p); err != nil {
+			t.Fatal(err)
+		}
+		found, err := repo.getProduct(p.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found == nil || found.Description != "Everyday spending" || !reflect.DeepEqual(found.Settings, p.Settings) {
+			t.Errorf("product=%#v", found)
+		}
+
+		if err := repo.assignProduct(&accountProduct{AccountID: "alice", ProductID: p.ID, AssignedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+		overrides := productSettings{Features: []productFeature{featureCards, featureWires}}
+		if err := repo.assignProduct(&accountProduct{AccountID: "alice", ProductID: p.ID, Overrides: overrides, AssignedAt: now}); err != nil {
+			t.Fatal(err)
+		}
+		assignment, err := repo.getAccountProduct("alice")
+		if err != nil || assignment == nil || assignment.ProductID != p.ID || !reflect.DeepEqual(assignment.Overrides, overrides) {
+			t.Errorf("assignment=%#v error=%v", assignment, err)
+		}
+		if n, err := repo.countProductAccounts(p.ID); err != nil || n != 1 {
+			t.Errorf("n=%d error=%v", n, err)
+		}
+		if found, err := repo.unassignProduct("alice"); err != nil || !found {
+			t.Errorf("found=%v error=%v", found, err)
+		}
+		if found, err := repo.unassignProduct("alice"); err != nil || found {
+			t.Errorf("found=%v error=%v", found, err)
+		}
+		if assignment, err := repo.getAccountProduct("alice"); err != nil || assignment != nil {
+			t.Errorf("assignment=%#v error=%v", assignment, err)
+		}
+
+		if err := repo.deleteProduct(p.ID); err != nil {
+			t.Fatal(err)
+		}
+		if products, err := repo.getProducts(); err != nil || len(products) != 0 {
+			t.Errorf("products=%#v error=%v", products, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlProductRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlProductRepository{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestProducts__routes(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlProductRepository{db.DB, log.NewNopLogger()}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/products", products(log.NewNopLogger(), repo))
+	router.HandleFunc("/products/{productId}", productByID(log.NewNopLogger(), repo))
+	router.HandleFunc("/accounts/{accountId}/product", accountProductAssignment(log.NewNopLogger(), repo))
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		w.Flush()
+		return w
+	}
+
+	if w := serve("POST", "/products", `{"settings": {"features": ["cards"]}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a name to be required: %d", w.Code)
+	}
+	w := serve("POST", "/products", `{"name": "Basic Checking", "settings": {"monthlyFees": [{"name": "maintenance", "amount": 500}], "dailyLimits": {"atm": 50000}, "features": ["cards"]}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var created product
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil || created.ID == "" {
+		t.Fatalf("product=%#v error=%v", created, err)
+	}
+
+	if w := serve("PUT", "/accounts/alice/product", `{"productId": "missing"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("PUT", "/accounts/alice/product", `{"productId": "`+created.ID+`", "overrides": {"dailyLimits": {"pos": 20000}}}`); w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	w = serve("GET", "/accounts/alice/product", "")
+	var assigned assignedProduct
+	if err := json.NewDecoder(w.Body).Decode(&assigned); err != nil {
+		t.Fatal(err)
+	}
+	if expected := map[limitClass]int64{limitATM: 50000, limitPOS: 20000}; assigned.ProductID != created.ID || !reflect.DeepEqual(assigned.Settings.DailyLimits, expected) {
+		t.Errorf("assigned=%#v", assigned)
+	}
+
+	// products can't be deleted while accounts are assigned to them
+	if w := serve("DELETE", "/products/"+created.ID, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("DELETE", "/accounts/alice/product", ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("GET", "/accounts/alice/product", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("DELETE", "/products/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("GET", "/products/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
+
+// createTestProduct assigns accountID a new product with settings.
+func createTestProduct(t *testing.T, repo productRepository, accountID string, settings productSettings) {
+	t.Helper()
+
+	p := &product{ID: base.ID(), Name: "test", Settings: settings, CreatedAt: time.Now(), LastModified: time.Now()}
+	if err := repo.createProduct(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.assignProduct(&accountProduct{AccountID: accountID, ProductID: p.ID, AssignedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProducts__engines(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"alice": 100000, "bob": 100000, "settlement": 0})
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlProductRepository{db.DB, log.NewNopLogger()}
+	catalog := &productCatalog{repo: repo}
+	createTestProduct(t, repo, "alice", productSettings{
+		InterestRateTiers: []interestTier{{MinBalance: 0, Rate: 0.12}},
+		MonthlyFees:       []monthlyFee{},
+		DailyLimits:       map[limitClass]int64{limitPOS: 5000},
+		StatementCycle:    statementsNone,
+		Features:          []productFeature{featureCards},
+	})
+
+	// projections use the product's rates and fees
+	rules := &projectionRules{fees: []monthlyFee{{Name: "maintenance", Amount: 500}}, products: catalog}
+	aliceRules, err := rules.forAccount("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := aliceRules.project("alice", 100000, 1, time.Now()); p.TotalInterest != 1000 || p.TotalFees != 0 {
+		t.Errorf("projection=%#v", p)
+	}
+	if bobRules, err := rules.forAccount("bob"); err != nil || bobRules != rules {
+		t.Errorf("rules=%#v error=%v", bobRules, err)
+	}
+
+	// daily limits start from the product's
+	limitRepo := &sqlAccountLimitRepository{db.DB, log.NewNopLogger()}
+	limits := &accountLimits{repo: limitRepo, defaults: map[limitClass]int64{limitATM: 20000}, products: catalog}
+	if err := limitRepo.setAccountLimit("alice", limitATM, 30000, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := limits.limitsOf("alice"); err != nil || !reflect.DeepEqual(got, map[limitClass]int64{limitATM: 30000, limitPOS: 5000}) {
+		t.Errorf("limits=%#v error=%v", got, err)
+	}
+
+	// transactions need their accounts' products to include the features they use
+	products := &productTransactionRepository{transactionRepository: transactionRepo, products: catalog}
+	post := func(lines ...transactionLine) error {
+		tx := transaction{ID: base.ID(), Timestamp: time.Now(), Status: TransactionPosted, Lines: lines}
+		return products.createTransaction(context.Background(), tx, createTransactionOpts{})
+	}
+	if err := post(
+		transactionLine{AccountID: "alice", Purpose: ACHDebit, Amount: 100},
+		transactionLine{AccountID: "settlement", Purpose: Card, Amount: 100, MCC: "5812"},
+	); err != nil {
+		t.Error(err)
+	}
+	if err := post(
+		transactionLine{AccountID: "alice", Purpose: Wire, Amount: 100},
+		transactionLine{AccountID: "bob", Purpose: ACHDebit, Amount: 100},
+	); err == nil || !strings.Contains(err.Error(), errProductFeature.Error()) {
+		t.Errorf("expected error: %v", err)
+	}
+	if err := post(
+		transactionLine{AccountID: "bob", Purpose: Wire, Amount: 100},
+		transactionLine{AccountID: "alice", Purpose: ACHDebit, Amount: 100},
+	); err != nil {
+		t.Error(err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 99800, "bob": 100100, "settlement": 100})
+}
+
+func TestProducts__statementCycles(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlProductRepository{db.DB, log.NewNopLogger()}
+	statementRepo := &sqlStatementRepository{db.DB, log.NewNopLogger()}
+
+	opened := time.Date(2019, time.December, 1, 0, 0, 0, 0, time.UTC)
+	accountRepo := &testAccountRepository{}
+	createTestProduct(t, repo, "quarterly", productSettings{StatementCycle: statementsQuarterly})
+	createTestProduct(t, repo, "none", productSettings{StatementCycle: statementsNone})
+	transactionRepo := &mockTransactionRepository{
+		transactions: []transaction{
+			{
+				ID:        base.ID(),
+				Timestamp: time.Date(2020, time.January, 5, 0, 0, 0, 0, time.UTC),
+				Status:    TransactionPosted,
+				Lines: []transactionLine{
+					{AccountID: "quarterly", Purpose: ACHCredit, Amount: 100},
+					{AccountID: "none", Purpose: ACHDebit, Amount: 100},
+				},
+			},
+		},
+	}
+	g := newStatementGenerator(log.NewNopLogger(), accountRepo, transactionRepo, statementRepo)
+	g.products = &productCatalog{repo: repo}
+
+	generate := func(accountID, cycle string) bool {
+		t.Helper()
+		generated, err := g.generate(&accounts.Account{ID: accountID, CreatedAt: opened}, cycle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return generated
+	}
+	if generate("none", "2020-03") || generate("quarterly", "2020-02") {
+		t.Error("expected no statement")
+	}
+	if !generate("quarterly", "2020-03") || !generate("monthly", "2020-02") {
+		t.Error("expected a statement")
+	}
+	stmt, err := statementRepo.getStatement("quarterly", "2020-03")
+	if err != nil || stmt == nil {
+		t.Fatalf("statement=%#v error=%v", stmt, err)
+	}
+	if !stmt.PeriodStart.Equal(time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)) || stmt.Credits != 100 || len(stmt.Transactions) != 1 {
+		t.Errorf("statement=%#v", stmt)
+	}
+}
+
+func TestProducts__timeZones(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"west": 0, "bank": 0})
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlProductRepository{db.DB, log.NewNopLogger()}
+	createTestProduct(t, repo, "west", productSettings{TimeZone: "America/Los_Angeles"})
+	catalog := &productCatalog{repo: repo}
+
+	deposit := func(when time.Time, amount int) {
+		t.Helper()
+		tx := transaction{
+			ID:        base.ID(),
+			Timestamp: when,
+			Lines: []transactionLine{
+				{AccountID: "west", Purpose: ACHCredit, Amount: amount},
+				{AccountID: "bank", Purpose: ACHDebit, Amount: amount},
+			},
+		}
+		if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deposit(time.Date(2020, time.January, 31, 20, 0, 0, 0, time.UTC), 100)
+	deposit(time.Date(2020, time.February, 1, 3, 0, 0, 0, time.UTC), 200) // January 31st in Los Angeles
+	deposit(time.Date(2020, time.February, 10, 12, 0, 0, 0, time.UTC), 400)
+	deposit(time.Date(2020, time.March, 1, 5, 0, 0, 0, time.UTC), 800) // February 29th in Los Angeles
+
+	// statement cycles run from midnight to midnight in the account's time zone
+	statementRepo := &sqlStatementRepository{db.DB, log.NewNopLogger()}
+	g := newStatementGenerator(log.NewNopLogger(), accountRepo, transactionRepo, statementRepo)
+	g.products = catalog
+	opened := time.Date(2019, time.December, 1, 0, 0, 0, 0, time.UTC)
+	if generated, err := g.generate(&accounts.Account{ID: "west", CreatedAt: opened}, "2020-02"); err != nil || !generated {
+		t.Fatalf("generated=%v error=%v", generated, err)
+	}
+	stmt, err := statementRepo.getStatement("west", "2020-02")
+	if err != nil || stmt == nil {
+		t.Fatalf("statement=%#v error=%v", stmt, err)
+	}
+	if !stmt.PeriodStart.Equal(time.Date(2020, time.February, 1, 8, 0, 0, 0, time.UTC)) || !stmt.PeriodEnd.Equal(time.Date(2020, time.March, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("start=%v end=%v", stmt.PeriodStart, stmt.PeriodEnd)
+	}
+	if stmt.OpeningBalance != 300 || stmt.Credits != 1200 || stmt.ClosingBalance != 1500 || len(stmt.Transactions) != 2 {
+		t.Errorf("opening=%d credits=%d closing=%d transactions=%d", stmt.OpeningBalance, stmt.Credits, stmt.ClosingBalance, len(stmt.Transactions))
+	}
+
+	// daily limits reset at midnight in the account's time zone
+	limits := &accountLimits{repo: &sqlAccountLimitRepository{db.DB, log.NewNopLogger()}, defaults: map[limitClass]int64{limitACHDebit: 500}, products: catalog}
+	remaining, err := limits.remaining(context.Background(), transactionRepo, "west", time.Date(2020, time.March, 1, 5, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining.Day != "2020-02-29" || !remaining.ResetsAt.Equal(time.Date(2020, time.March, 1, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("day=%s resetsAt=%v", remaining.Day, remaining.ResetsAt)
+	}
+	remaining, err = limits.remaining(context.Background(), transactionRepo, "bank", time.Date(2020, time.March, 1, 5, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining.Day != "2020-03-01" || remaining.Limits[0].Used != 800 {
+		t.Errorf("day=%s limits=%#v", remaining.Day, remaining.Limits)
+	}
+}
@@ -0,0 +1,241 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultProjectionMonths = 12
+	maxProjectionMonths     = 60
+)
+
+// interestTier is the annual interest rate paid on balances (in USD cents) of at least MinBalance.
+type interestTier struct {
+	MinBalance int64   `json:"minBalance"`
+	Rate       float64 `json:"rate"`
+}
+
+// monthlyFee is charged every month unless the balance is at least WaiveAbove (when set).
+type monthlyFee struct {
+	Name       string `json:"name"`
+	Amount     int64  `json:"amount"`
+	WaiveAbove int64  `json:"waiveAbove,omitempty"`
+}
+
+// projectionRules are the interest rate tiers and fees used to project account balances.
+type projectionRules struct {
+	tiers []interestTier // sorted by MinBalance
+	fees  []monthlyFee
+
+	// products, when set, replaces the tiers and fees of accounts assigned a product
+	products *productCatalog
+}
+
+// forAccount returns the rules of an account, which are its product's when it has one.
+func (r *projectionRules) forAccount(accountID string) (*projectionRules, error) {
+	settings, err := r.products.settingsOf(accountID)
+	if err != nil || settings == nil {
+		return r, err
+	}
+	return &projectionRules{tiers: settings.InterestRateTiers, fees: settings.MonthlyFees}, nil
+}
+
+// readProjectionRules reads INTEREST_RATE_TIERS and MONTHLY_FEES.
+//
+// INTEREST_RATE_TIERS is a comma separated list of minBalance:annualRate pairs (e.g. 0:0.001,1000000:0.015). The rate
+// of the highest tier a balance reaches applies to the whole balance.
+//
+// MONTHLY_FEES is a comma separated list of name:amount or name:amount:waiveAbove (e.g. maintenance:500:150000).
+func readProjectionRules() (*projectionRules, error) {
+	rules := &projectionRules{}
+	if v := os.Getenv("INTEREST_RATE_TIERS"); v != "" {
+		for _, tier := range strings.Split(v, ",") {
+			parts := strings.Split(strings.TrimSpace(tier), ":")
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("invalid INTEREST_RATE_TIERS tier %q", tier)
+			}
+			min, err := strconv.ParseInt(parts[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid INTEREST_RATE_TIERS tier %q: %v", tier, err)
+			}
+			rate, err := strconv.ParseFloat(parts[1], 64)
+			if err != nil || rate < 0 {
+				return nil, fmt.Errorf("invalid INTEREST_RATE_TIERS rate %q", parts[1])
+			}
+			rules.tiers = append(rules.tiers, interestTier{MinBalance: min, Rate: rate})
+		}
+		sort.Slice(rules.tiers, func(i, j int) bool { return rules.tiers[i].MinBalance < rules.tiers[j].MinBalance })
+	}
+	if v := os.Getenv("MONTHLY_FEES"); v != "" {
+		for _, fee := range strings.Split(v, ",") {
+			parts := strings.Split(strings.TrimSpace(fee), ":")
+			if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+				return nil, fmt.Errorf("invalid MONTHLY_FEES fee %q", fee)
+			}
+			f := monthlyFee{Name: parts[0]}
+			var err error
+			if f.Amount, err = strconv.ParseInt(parts[1], 10, 64); err != nil || f.Amount < 0 {
+				return nil, fmt.Errorf("invalid MONTHLY_FEES amount %q", parts[1])
+			}
+			if len(parts) == 3 {
+				if f.WaiveAbove, err = strconv.ParseInt(parts[2], 10, 64); err != nil {
+					return nil, fmt.Errorf("invalid MONTHLY_FEES waiver %q", parts[2])
+				}
+			}
+			rules.fees = append(rules.fees, f)
+		}
+	}
+	return rules, nil
+}
+
+// rate returns the annual interest rate paid on balance.
+func (r *projectionRules) rate(balance int64) float64 {
+	var rate float64
+	for i := range r.tiers {
+		if balance >= r.tiers[i].MinBalance {
+			rate = r.tiers[i].Rate
+		}
+	}
+	return rate
+}
+
+// monthlyInterest returns the annual rate and interest paid for a month on an opening balance.
+func (r *projectionRules) monthlyInterest(balance int64) (float64, int64) {
+	if balance <= 0 {
+		return 0, 0
+	}
+	rate := r.rate(balance)
+	return rate, int64(math.Round(float64(balance) * rate / 12))
+}
+
+// monthlyFees returns the fees charged for a month on an opening balance.
+func (r *projectionRules) monthlyFees(balance int64) []projectedFee {
+	fees := []projectedFee{}
+	for _, fee := range r.fees {
+		if fee.WaiveAbove > 0 && balance >= fee.WaiveAbove {
+			continue
+		}
+		fees = append(fees, projectedFee{Name: fee.Name, Amount: fee.Amount})
+	}
+	return fees
+}
+
+type projectedFee struct {
+	Name   string `json:"name"`
+	Amount int64  `json:"amount"`
+}
+
+type projectedMonth struct {
+	Month          string         `json:"month"` // YYYY-MM
+	OpeningBalance int64          `json:"openingBalance"`
+	InterestRate   float64        `json:"interestRate"`
+	Interest       int64          `json:"interest"`
+	Fees           []projectedFee `json:"fees"`
+	ClosingBalance int64          `json:"closingBalance"`
+}
+
+// projection is an estimate of an account's interest and fees, assuming its balance only changes from them.
+type projection struct {
+	AccountID     string           `json:"accountId"`
+	Balance       int64            `json:"balance"`
+	TotalInterest int64            `json:"totalInterest"`
+	TotalFees     int64            `json:"totalFees"`
+	Months        []projectedMonth `json:"months"`
+}
+
+// project estimates the interest earned and fees charged over the months after now. Interest is paid monthly
+// on each month's opening balance at the rate of its tier and fees are assessed against the opening balance.
+func (r *projectionRules) project(accountID string, balance int64, months int, now time.Time) *projection {
+	p := &projection{
+		AccountID: accountID,
+		Balance:   balance,
+		Months:    make([]projectedMonth, 0, months),
+	}
+	start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= months; i++ {
+		month := projectedMonth{
+			Month:          start.AddDate(0, i, 0).Format("2006-01"),
+			OpeningBalance: balance,
+			Fees:           r.monthlyFees(balance),
+		}
+		month.InterestRate, month.Interest = r.monthlyInterest(balance)
+		var fees int64
+		for _, fee := range month.Fees {
+			fees += fee.Amount
+		}
+		balance += month.Interest - fees
+		month.ClosingBalance = balance
+
+		p.TotalInterest += month.Interest
+		p.TotalFees += fees
+		p.Months = append(p.Months, month)
+	}
+	return p
+}
+
+func addProjectionRoutes(logger log.Logger, router *mux.Router, accountRepo accountRepository, rules *projectionRules) {
+	router.Methods("GET").Path("/accounts/{accountId}/projections").HandlerFunc(getAccountProjections(logger, accountRepo, rules))
+}
+
+// getAccountProjections returns the projected interest and fees of an account over the next ?months=N months
+func getAccountProjections(logger log.Logger, accountRepo accountRepository, rules *projectionRules) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		accountID := getAccountID(w, r)
+		if accountID == "" {
+			return
+		}
+		months := defaultProjectionMonths
+		if v := r.URL.Query().Get("months"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 || n > maxProjectionMonths {
+				moovhttp.Problem(w, fmt.Errorf("months must be between 1 and %d", maxProjectionMonths))
+				return
+			}
+			months = n
+		}
+
+		accts, err := accountRepo.GetAccounts(r.Context(), []string{accountID})
+		if err != nil {
+			logger.Log("projections", fmt.Sprintf("problem reading account=%s: %v", accountID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if len(accts) != 1 {
+			moovhttp.Problem(w, errors.New("account not found"))
+			return
+		}
+		accountRules, err := rules.forAccount(accountID)
+		if err != nil {
+			logger.Log("projections", fmt.Sprintf("problem reading account=%s product: %v", accountID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(accountRules.project(accountID, int64(accts[0].Balance), months, time.Now()))
+	}
+}
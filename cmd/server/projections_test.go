@@ -0,0 +1,113 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestReadProjectionRules(t *testing.T) {
+	os.Setenv("INTEREST_RATE_TIERS", "1000000:0.02, 0:0.01")
+	os.Setenv("MONTHLY_FEES", "maintenance:500:150000,paper:100")
+	defer os.Unsetenv("INTEREST_RATE_TIERS")
+	defer os.Unsetenv("MONTHLY_FEES")
+
+	rules, err := readProjectionRules()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules.tiers) != 2 || rules.tiers[0].MinBalance != 0 || rules.rate(5000) != 0.01 || rules.rate(1000000) != 0.02 {
+		t.Errorf("unexpected tiers: %#v", rules.tiers)
+	}
+	if len(rules.fees) != 2 || rules.fees[0].WaiveAbove != 150000 || rules.fees[1].Amount != 100 {
+		t.Errorf("unexpected fees: %#v", rules.fees)
+	}
+
+	os.Setenv("MONTHLY_FEES", "maintenance")
+	if _, err := readProjectionRules(); err == nil {
+		t.Error("expected error")
+	}
+	os.Setenv("MONTHLY_FEES", "")
+	os.Setenv("INTEREST_RATE_TIERS", "0:lots")
+	if _, err := readProjectionRules(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestProjectionRules__project(t *testing.T) {
+	rules := &projectionRules{
+		tiers: []interestTier{{MinBalance: 0, Rate: 0.012}, {MinBalance: 200000, Rate: 0.024}},
+		fees:  []monthlyFee{{Name: "maintenance", Amount: 500, WaiveAbove: 150000}},
+	}
+	now := time.Date(2020, time.January, 20, 0, 0, 0, 0, time.UTC)
+
+	p := rules.project("account", 100000, 2, now)
+	if len(p.Months) != 2 || p.Months[0].Month != "2020-02" || p.Months[1].Month != "2020-03" {
+		t.Fatalf("unexpected months: %#v", p.Months)
+	}
+	// 1000.00 at 1.2% earns 1.00 a month and the maintenance fee isn't waived
+	if m := p.Months[0]; m.Interest != 100 || len(m.Fees) != 1 || m.ClosingBalance != 99600 {
+		t.Errorf("unexpected month: %#v", m)
+	}
+	if p.TotalInterest != 200 || p.TotalFees != 1000 || p.Months[1].ClosingBalance != 99200 {
+		t.Errorf("unexpected projection: %#v", p)
+	}
+
+	// higher tier and waived fees
+	p = rules.project("account", 200000, 1, now)
+	if m := p.Months[0]; m.InterestRate != 0.024 || m.Interest != 400 || len(m.Fees) != 0 {
+		t.Errorf("unexpected month: %#v", m)
+	}
+
+	// no interest is paid on negative balances
+	p = rules.project("account", -5000, 1, now)
+	if m := p.Months[0]; m.Interest != 0 || m.ClosingBalance != -5500 {
+		t.Errorf("unexpected month: %#v", m)
+	}
+}
+
+func TestAccounts__getAccountProjections(t *testing.T) {
+	account := &accounts.Account{ID: base.ID(), Balance: 100000}
+	rules := &projectionRules{tiers: []interestTier{{MinBalance: 0, Rate: 0.012}}}
+
+	router := mux.NewRouter()
+	addProjectionRoutes(log.NewNopLogger(), router, &testAccountRepository{accounts: []*accounts.Account{account}}, rules)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/accounts/"+account.ID+"/projections"+query, nil)
+		req.Header.Set("x-user-id", base.ID())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	w := get("?months=3")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var p projection
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.AccountID != account.ID || len(p.Months) != 3 || p.TotalInterest != 300 {
+		t.Errorf("unexpected projection: %#v", p)
+	}
+
+	if w := get("?months=600"); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+)
+
+type promotionRepository interface {
+	Ping() error
+	Close() error
+
+	createPromotion(p *promotion) error
+
+	// deletePromotion removes a promotion whose credit couldn't be posted.
+	deletePromotion(promotionID string) error
+
+	// reversePromotion saves the reversal of a granted promotion. It returns false when the promotion isn't
+	// granted, such as when it was reversed concurrently.
+	reversePromotion(p *promotion) (bool, error)
+
+	// reinstatePromotion returns a reversed promotion whose reversal couldn't be posted to granted.
+	reinstatePromotion(promotionID string) error
+
+	// getPromotion returns nil if the promotion doesn't exist.
+	getPromotion(promotionID string) (*promotion, error)
+
+	// getPromotions returns the account's (or every account's when empty) promotions with the status (or every
+	// status when empty), newest first. A limit of zero returns every promotion.
+	getPromotions(accountID string, status promotionStatus, limit int) ([]*promotion, error)
+}
+
+type sqlPromotionRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlPromotionRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlPromotionRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlPromotionRepository) createPromotion(p *promotion) error {
+	var clawback *string
+	if p.Clawback != nil {
+		bs, err := json.Marshal(p.Clawback)
+		if err != nil {
+			return fmt.Errorf("createPromotion: promotion=%s: %v", p.ID, err)
+		}
+		v := string(bs)
+		clawback = &v
+	}
+
+	query := `insert into promotions (promotion_id, account_id, funding_account_id, amount, description, clawback, status, transaction_id, granted_by, granted_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createPromotion: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(p.ID, p.AccountID, p.FundingAccountID, p.Amount, p.Description, clawback, p.Status, p.TransactionID, p.GrantedBy, p.GrantedAt); err != nil {
+		return fmt.Errorf("createPromotion: promotion=%s: %v", p.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlPromotionRepository) deletePromotion(promotionID string) error {
+	if _, err := r.db.Exec(`delete from promotions where promotion_id = ?;`, promotionID); err != nil {
+		return fmt.Errorf("deletePromotion: promotion=%s: %v", promotionID, err)
+	}
+	return nil
+}
+
+func (r *sqlPromotionRepository) reversePromotion(p *promotion) (bool, error) {
+	query := `update promotions set status = ?, reversal_transaction_id = ?, reversal_reason = ?, reversed_by = ?, reversed_at = ? where promotion_id = ? and status = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, fmt.Errorf("reversePromotion: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(promotionReversed, p.ReversalTransactionID, p.ReversalReason, p.ReversedBy, p.ReversedAt, p.ID, promotionGranted)
+	if err != nil {
+		return false, fmt.Errorf("reversePromotion: promotion=%s: %v", p.ID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("reversePromotion: promotion=%s: %v", p.ID, err)
+	}
+	return n == 1, nil
+}
+
+func (r *sqlPromotionRepository) reinstatePromotion(promotionID string) error {
+	query := `update promotions set status = ?, reversal_transaction_id = null, reversal_reason = null, reversed_by = null, reversed_at = null where promotion_id = ? and status = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("reinstatePromotion: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(promotionGranted, promotionID, promotionReversed); err != nil {
+		return fmt.Errorf("reinstatePromotion: promotion=%s: %v", promotionID, err)
+	}
+	return nil
+}
+
+func (r *sqlPromotionRepository) getPromotion(promotionID string) (*promotion, error) {
+	promotions, err := r.queryPromotions(`promotion_id = ?`, promotionID)
+	if err != nil {
+		return nil, fmt.Errorf("getPromotion: %v", err)
+	}
+	if len(promotions) == 0 {
+		return nil, nil
+	}
+	return promotions[0], nil
+}
+
+func (r *sqlPromotionRepository) getPromotions(accountID string, status promotionStatus, limit int) ([]*promotion, error) {
+	where, args := `1 = 1`, []interface{}{}
+	if accountID != "" {
+		where, args = where+` and account_id = ?`, append(args, accountID)
+	}
+	if status != "" {
+		where, args = where+` and status = ?`, append(args, status)
+	}
+	where += ` order by granted_at desc, promotion_id desc`
+	if limit > 0 {
+		where += fmt.Sprintf(` limit %d`, limit)
+	}
+	promotions, err := r.queryPromotions(where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getPromotions: %v", err)
+	}
+	return promotions, nil
+}
+
+func (r *sqlPromotionRepository) queryPromotions(where string, args ...interface{}) ([]*promotion, error) {
+	query := fmt.Sprintf(`select promotion_id, account_id, funding_account_id, amount, description, clawback, status, transaction_id, granted_by, granted_at,
+reversal_transaction_id, reversal_reason, reversed_by, reversed_at from promotions where %s;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*promotion
+	for rows.Next() {
+		var p promotion
+		var clawback, reversalTransactionID, reversalReason, reversedBy *string
+		if err := rows.Scan(&p.ID, &p.AccountID, &p.FundingAccountID, &p.Amount, &p.Description, &clawback, &p.Status, &p.TransactionID, &p.GrantedBy, &p.GrantedAt,
+			&reversalTransactionID, &reversalReason, &reversedBy, &p.ReversedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		if clawback != nil {
+			if err := json.Unmarshal([]byte(*clawback), &p.Clawback); err != nil {
+				return nil, fmt.Errorf("promotion=%s clawback: %v", p.ID, err)
+			}
+		}
+		if reversalTransactionID != nil {
+			p.ReversalTransactionID = *reversalTransactionID
+		}
+		if reversalReason != nil {
+			p.ReversalReason = *reversalReason
+		}
+		if reversedBy != nil {
+			p.ReversedBy = *reversedBy
+		}
+		out = append(out, &p)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,396 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Promotions are credits, such as sign-up bonuses, granted to an account from a marketing account. Each one posts
+// a transaction which is linked to the promotion. Promotions can have clawback conditions which reverse the credit,
+// posting a transaction back to the marketing account, and operators can reverse a promotion themselves.
+
+type promotionStatus string
+
+const (
+	promotionGranted  promotionStatus = "granted"
+	promotionReversed promotionStatus = "reversed"
+)
+
+var errPromotionNotFound = errors.New("promotion not found")
+
+// promotionClawback are the conditions which reverse a promotion.
+type promotionClawback struct {
+	// AccountClosedWithinDays reverses the promotion when its account is closed within this many days of the grant
+	AccountClosedWithinDays int `json:"accountClosedWithinDays,omitempty"`
+}
+
+func (c *promotionClawback) validate() error {
+	if c == nil {
+		return nil
+	}
+	if c.AccountClosedWithinDays <= 0 {
+		return fmt.Errorf("invalid clawback accountClosedWithinDays %d", c.AccountClosedWithinDays)
+	}
+	return nil
+}
+
+// onClosure returns true if closing the account at now reverses a promotion granted at grantedAt.
+func (c *promotionClawback) onClosure(grantedAt, now time.Time) bool {
+	if c == nil || c.AccountClosedWithinDays <= 0 {
+		return false
+	}
+	return now.Before(grantedAt.AddDate(0, 0, c.AccountClosedWithinDays))
+}
+
+type promotion struct {
+	ID               string             `json:"id"`
+	AccountID        string             `json:"accountId"`
+	FundingAccountID string             `json:"fundingAccountId"`
+	Amount           int                `json:"amount"`
+	Description      string             `json:"description"`
+	Clawback         *promotionClawback `json:"clawback,omitempty"`
+	Status           promotionStatus    `json:"status"`
+
+	// TransactionID is the credit posted when the promotion was granted
+	TransactionID string    `json:"transactionId"`
+	GrantedBy     string    `json:"grantedBy"`
+	GrantedAt     time.Time `json:"grantedAt"`
+
+	// ReversalTransactionID is the debit posted when the promotion was reversed. ReversedBy is empty when a
+	// clawback condition reversed it.
+	ReversalTransactionID string     `json:"reversalTransactionId,omitempty"`
+	ReversalReason        string     `json:"reversalReason,omitempty"`
+	ReversedBy            string     `json:"reversedBy,omitempty"`
+	ReversedAt            *time.Time `json:"reversedAt,omitempty"`
+}
+
+// grantTransaction is the credit of the promotion's amount from its funding account.
+func (p *promotion) grantTransaction() transaction {
+	return transaction{
+		ID:          p.TransactionID,
+		Timestamp:   p.GrantedAt,
+		Status:      TransactionPosted,
+		Description: p.Description,
+		Metadata:    map[string]string{"promotionId": p.ID},
+		Lines: []transactionLine{
+			{AccountID: p.FundingAccountID, Purpose: ACHDebit, Amount: p.Amount},
+			{AccountID: p.AccountID, Purpose: ACHCredit, Amount: p.Amount},
+		},
+	}
+}
+
+// reversalTransaction returns the promotion's amount to its funding account.
+func (p *promotion) reversalTransaction() transaction {
+	return transaction{
+		ID:          p.ReversalTransactionID,
+		Timestamp:   *p.ReversedAt,
+		Status:      TransactionPosted,
+		Description: fmt.Sprintf("Reversal of promotion %s", p.ID),
+		Metadata:    map[string]string{"promotionId": p.ID},
+		Lines: []transactionLine{
+			{AccountID: p.AccountID, Purpose: ACHDebit, Amount: p.Amount},
+			{AccountID: p.FundingAccountID, Purpose: ACHCredit, Amount: p.Amount},
+		},
+	}
+}
+
+type grantPromotionRequest struct {
+	AccountID string `json:"accountId"`
+	Amount    int    `json:"amount"`
+
+	// Description is shown on the credit, such as "Sign-up bonus"
+	Description string `json:"description"`
+
+	// FundingAccountID is the account the credit is paid from, which defaults to PROMOTIONS_FUNDING_ACCOUNT_ID
+	FundingAccountID string `json:"fundingAccountId,omitempty"`
+
+	Clawback *promotionClawback `json:"clawback,omitempty"`
+}
+
+type reversePromotionRequest struct {
+	Reason string `json:"reason"`
+}
+
+type promotionService struct {
+	logger       log.Logger
+	repo         promotionRepository
+	accounts     accountRepository
+	transactions *transactionService
+
+	// fundingAccountID is the marketing account promotions are paid from unless their grant names another
+	fundingAccountID string
+}
+
+// GrantPromotion credits an account from the funding account. The promotion is saved first so its transaction is
+// always linked to it, and removed again if the transaction can't be posted. Funding accounts aren't checked for
+// sufficient funds as they record what promotions cost.
+func (s *promotionService) GrantPromotion(ctx context.Context, userID string, req grantPromotionRequest) (*promotion, error) {
+	if userID == "" {
+		return nil, errors.New("promotions must be granted with an X-User-ID")
+	}
+	req.Description = strings.TrimSpace(req.Description)
+	if req.FundingAccountID == "" {
+		req.FundingAccountID = s.fundingAccountID
+	}
+	switch {
+	case req.AccountID == "":
+		return nil, errors.New("promotions require an accountId")
+	case req.FundingAccountID == "":
+		return nil, errors.New("promotions require a fundingAccountId when PROMOTIONS_FUNDING_ACCOUNT_ID isn't set")
+	case req.FundingAccountID == req.AccountID:
+		return nil, fmt.Errorf("account=%s can't fund its own promotion", req.AccountID)
+	case req.Amount <= 0:
+		return nil, fmt.Errorf("invalid promotion amount %d", req.Amount)
+	case req.Description == "":
+		return nil, errors.New("promotions require a description")
+	}
+	if err := validateDescription(req.Description); err != nil {
+		return nil, err
+	}
+	if err := req.Clawback.validate(); err != nil {
+		return nil, err
+	}
+	accts, err := s.accounts.GetAccounts(ctx, []string{req.AccountID, req.FundingAccountID})
+	if err != nil {
+		return nil, err
+	}
+	if len(accts) != 2 {
+		return nil, fmt.Errorf("account=%s or funding account=%s: %v", req.AccountID, req.FundingAccountID, errAccountNotFound)
+	}
+
+	p := &promotion{
+		ID:               newID(),
+		AccountID:        req.AccountID,
+		FundingAccountID: req.FundingAccountID,
+		Amount:           req.Amount,
+		Description:      req.Description,
+		Clawback:         req.Clawback,
+		Status:           promotionGranted,
+		TransactionID:    newID(),
+		GrantedBy:        userID,
+		GrantedAt:        time.Now(),
+	}
+	if err := s.repo.createPromotion(p); err != nil {
+		return nil, err
+	}
+	tx := p.grantTransaction()
+	if err := s.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		if deleteErr := s.repo.deletePromotion(p.ID); deleteErr != nil {
+			s.logger.Log("promotions", fmt.Sprintf("problem removing promotion=%s: %v", p.ID, deleteErr), "requestID", requestIDFrom(ctx))
+		}
+		return nil, fmt.Errorf("promotion=%s: %v", p.ID, err)
+	}
+	s.logger.Log("promotions", fmt.Sprintf("granted promotion=%s of %d to account=%s", p.ID, p.Amount, p.AccountID), "transactionID", p.TransactionID, "userID", userID, "requestID", requestIDFrom(ctx))
+	s.transactions.publish(ctx, tx.Status, &tx)
+	return p, nil
+}
+
+// ReversePromotion returns a granted promotion's credit to its funding account. userID is empty when a clawback
+// condition reverses the promotion.
+func (s *promotionService) ReversePromotion(ctx context.Context, promotionID string, userID string, reason string) (*promotion, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, errors.New("reversing a promotion requires a reason")
+	}
+	p, err := s.repo.getPromotion(promotionID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, errPromotionNotFound
+	}
+	if err := s.reverse(ctx, p, userID, reason); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// reverse marks the promotion reversed first so a concurrent reversal can't also post, and marks it granted
+// again if the reversal can't be posted. Reversals aren't checked for sufficient funds, so a customer who spent
+// the credit is left owing it.
+func (s *promotionService) reverse(ctx context.Context, p *promotion, userID string, reason string) error {
+	if p.Status != promotionGranted {
+		return fmt.Errorf("promotion=%s is already %s", p.ID, p.Status)
+	}
+	now := time.Now()
+	p.Status, p.ReversalTransactionID, p.ReversalReason, p.ReversedBy, p.ReversedAt = promotionReversed, newID(), reason, userID, &now
+	ok, err := s.repo.reversePromotion(p)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("promotion=%s was reversed by someone else", p.ID)
+	}
+	tx := p.reversalTransaction()
+	if err := s.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true, Reversal: true}); err != nil {
+		if revertErr := s.repo.reinstatePromotion(p.ID); revertErr != nil {
+			s.logger.Log("promotions", fmt.Sprintf("problem returning promotion=%s to granted: %v", p.ID, revertErr), "requestID", requestIDFrom(ctx))
+		}
+		return fmt.Errorf("promotion=%s: %v", p.ID, err)
+	}
+	s.logger.Log("promotions", fmt.Sprintf("reversed promotion=%s of %d from account=%s", p.ID, p.Amount, p.AccountID), "transactionID", p.ReversalTransactionID, "userID", userID, "reason", reason, "requestID", requestIDFrom(ctx))
+	s.transactions.publish(ctx, tx.Status, &tx)
+	return nil
+}
+
+// clawbackOnClosure reverses the account's promotions whose clawback conditions are met by closing it now. Nothing
+// is reversed unless the account could then be closed, which requires sweeping a balance left over (or owed) from
+// spending the credits. It returns true if any promotions were reversed.
+func (s *promotionService) clawbackOnClosure(ctx context.Context, acct *accounts.Account, sweeping bool) (bool, error) {
+	granted, err := s.repo.getPromotions(acct.ID, promotionGranted, 0)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	var clawbacks []*promotion
+	var total int32
+	for i := range granted {
+		if granted[i].Clawback.onClosure(granted[i].GrantedAt, now) {
+			clawbacks = append(clawbacks, granted[i])
+			total += int32(granted[i].Amount)
+		}
+	}
+	if len(clawbacks) == 0 {
+		return false, nil
+	}
+
+	after := *acct
+	after.Balance -= total
+	after.BalanceAvailable -= total
+	if sweeping {
+		// the sweep empties whatever is left
+		after.BalanceAvailable -= after.Balance
+		after.Balance = 0
+	}
+	if err := checkClosable(&after); err != nil {
+		return false, fmt.Errorf("after clawing back %d promotions: %v", len(clawbacks), err)
+	}
+	for i := range clawbacks {
+		reason := fmt.Sprintf("account closed within %d days", clawbacks[i].Clawback.AccountClosedWithinDays)
+		if err := s.reverse(ctx, clawbacks[i], "", reason); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// promotions is an admin route which lists promotions (GET), optionally of an ?accountId or with a ?status, or
+// grants one (POST).
+func promotions(logger log.Logger, svc *promotionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			q := r.URL.Query()
+			limit := 100
+			if v := q.Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					moovhttp.Problem(w, fmt.Errorf("invalid limit %q", v))
+					return
+				}
+				limit = n
+			}
+			out, err := svc.repo.getPromotions(q.Get("accountId"), promotionStatus(q.Get("status")), limit)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if out == nil {
+				out = []*promotion{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(out)
+
+		case "POST":
+			var req grantPromotionRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			p, err := svc.GrantPromotion(requestContext(r), moovhttp.GetUserID(r), req)
+			if err != nil {
+				logger.Log("promotions", fmt.Sprintf("problem granting promotion: %v", err), "requestID", moovhttp.GetRequestID(r))
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(p)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// getPromotion is an admin route which returns a promotion.
+func getPromotion(logger log.Logger, svc *promotionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		p, err := svc.repo.getPromotion(mux.Vars(r)["promotionId"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if p == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(p)
+	}
+}
+
+// reversePromotion is an admin route which reverses a granted promotion.
+func reversePromotion(logger log.Logger, svc *promotionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		userID := moovhttp.GetUserID(r)
+		if userID == "" {
+			moovhttp.Problem(w, errors.New("promotions must be reversed with an X-User-ID"))
+			return
+		}
+		var req reversePromotionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		p, err := svc.ReversePromotion(requestContext(r), mux.Vars(r)["promotionId"], userID, req.Reason)
+		if err != nil {
+			if err == errPromotionNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			logger.Log("promotions", fmt.Sprintf("problem reversing promotion: %v", err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(p)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/moov-io/base"
+)
+
+func TestPromotionClawback(t *testing.T) {
+	var none *promotionClawback
+	if err := none.validate(); err != nil {
+		t.Error(err)
+	}
+	if err := (&promotionClawback{}).validate(); err == nil {
+		t.Error("expected error")
+	}
+
+	granted := time.Date(2020, time.January, 1, 12, 0, 0, 0, time.UTC)
+	clawback := &promotionClawback{AccountClosedWithinDays: 90}
+	if err := clawback.validate(); err != nil {
+		t.Error(err)
+	}
+	if !clawback.onClosure(granted, granted.AddDate(0, 0, 89)) {
+		t.Error("expected a clawback within 90 days")
+	}
+	if clawback.onClosure(granted, granted.AddDate(0, 0, 90)) || none.onClosure(granted, granted) {
+		t.Error("unexpected clawback")
+	}
+}
+
+func TestPromotionRepository(t *testing.T) {
+	check := func(t *testing.T, repo *sqlPromotionRepository) {
+		now := time.Now().Truncate(time.Second)
+		first := &promotion{ID: base.ID(), AccountID: "alice", FundingAccountID: "marketing", Amount: 2500, Description: "Sign-up bonus",
+			Clawback: &promotionClawback{AccountClosedWithinDays: 90}, Status: promotionGranted, TransactionID: base.ID(), GrantedBy: "ops", GrantedAt: now.Add(-time.Hour)}
+		second := &promotion{ID: base.ID(), AccountID: "bob", FundingAccountID: "marketing", Amount: 500, Description: "Referral",
+			Status: promotionGranted, TransactionID: base.ID(), GrantedBy: "ops", GrantedAt: now}
+		for _, p := range []*promotion{first, second} {
+			if err := repo.createPromotion(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		found, err := repo.getPromotion(first.ID)
+		if err != nil || found == nil || found.Clawback == nil || found.Clawback.AccountClosedWithinDays != 90 || found.Amount != 2500 || !found.GrantedAt.Equal(first.GrantedAt) {
+			t.Fatalf("promotion=%#v error=%v", found, err)
+		}
+		if found, err := repo.getPromotion("missing"); err != nil || found != nil {
+			t.Errorf("promotion=%#v error=%v", found, err)
+		}
+		if promotions, err := repo.getPromotions("", "", 0); err != nil || len(promotions) != 2 || promotions[0].ID != second.ID || promotions[0].Clawback != nil {
+			t.Errorf("promotions=%#v error=%v", promotions, err)
+		}
+		if promotions, err := repo.getPromotions("alice", promotionGranted, 10); err != nil || len(promotions) != 1 || promotions[0].ID != first.ID {
+			t.Errorf("promotions=%#v error=%v", promotions, err)
+		}
+
+		// promotions are only reversed once
+		first.ReversalTransactionID, first.ReversalReason, first.ReversedBy, first.ReversedAt = base.ID(), "fraud", "ops", &now
+		if ok, err := repo.reversePromotion(first); err != nil || !ok {
+			t.Fatalf("ok=%v error=%v", ok, err)
+		}
+		if ok, err := repo.reversePromotion(first); err != nil || ok {
+			t.Errorf("ok=%v error=%v", ok, err)
+		}
+		found, _ = repo.getPromotion(first.ID)
+		if found.Status != promotionReversed || found.ReversalTransactionID != first.ReversalTransactionID || found.ReversalReason != "fraud" || found.ReversedBy != "ops" || found.ReversedAt == nil {
+			t.Errorf("unexpected promotion: %#v", found)
+		}
+		if promotions, err := repo.getPromotions("alice", promotionGranted, 0); err != nil || len(promotions) != 0 {
+			t.Errorf("promotions=%#v error=%v", promotions, err)
+		}
+
+		if err := repo.reinstatePromotion(first.ID); err != nil {
+			t.Fatal(err)
+		}
+		found, _ = repo.getPromotion(first.ID)
+		if found.Status != promotionGranted || found.ReversalTransactionID != "" || found.ReversedAt != nil {
+			t.Errorf("unexpected promotion: %#v", found)
+		}
+
+		if err := repo.deletePromotion(second.ID); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := repo.getPromotion(second.ID); err != nil || found != nil {
+			t.Errorf("promotion=%#v error=%v", found, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlPromotionRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlPromotionRepository{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestPromotions(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"marketing": 0, "alice": 0, "bob": 1000, "sweep": 0})
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	events := &mockEventPublisher{}
+	transactions := &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: events}
+	svc := &promotionService{
+		logger:           log.NewNopLogger(),
+		repo:             &sqlPromotionRepository{db.DB, log.NewNopLogger()},
+		accounts:         accountRepo,
+		transactions:     transactions,
+		fundingAccountID: "marketing",
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/promotions", promotions(log.NewNopLogger(), svc))
+	router.HandleFunc("/promotions/{promotionId}", getPromotion(log.NewNopLogger(), svc))
+	router.HandleFunc("/promotions/{promotionId}/reversal", reversePromotion(log.NewNopLogger(), svc))
+	serve := func(method, path, body, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", userID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+	grant := func(body string) *promotion {
+		t.Helper()
+		w := serve("POST", "/promotions", body, "marketing-ops")
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		var p promotion
+		if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		return &p
+	}
+
+	for body, userID := range map[string]string{
+		`{"accountId": "alice", "amount": 2500, "description": "Sign-up bonus"}`:                                              "",
+		`{"amount": 2500, "description": "Sign-up bonus"}`:                                                                    "ops",
+		`{"accountId": "alice", "description": "Sign-up bonus"}`:                                                              "ops",
+		`{"accountId": "alice", "amount": 2500}`:                                                                              "ops",
+		`{"accountId": "alice", "amount": 2500, "description": "Sign-up bonus", "clawback": {"accountClosedWithinDays": -1}}`: "ops",
+		`{"accountId": "missing", "amount": 2500, "description": "Sign-up bonus"}`:                                            "ops",
+		`{"accountId": "marketing", "amount": 2500, "description": "Sign-up bonus"}`:                                          "ops",
+	} {
+		if w := serve("POST", "/promotions", body, userID); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: bogus HTTP status: %d", body, w.Code)
+		}
+	}
+	checkBalances(t, accountRepo, map[string]int32{"marketing": 0, "alice": 0})
+
+	bonus := grant(`{"accountId": "alice", "amount": 2500, "description": "Sign-up bonus", "clawback": {"accountClosedWithinDays": 90}}`)
+	referral := grant(`{"accountId": "bob", "amount": 500, "description": "Referral", "fundingAccountId": "sweep"}`)
+	if bonus.Status != promotionGranted || bonus.FundingAccountID != "marketing" || bonus.GrantedBy != "marketing-ops" || referral.FundingAccountID != "sweep" {
+		t.Errorf("bonus=%#v referral=%#v", bonus, referral)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"marketing": -2500, "alice": 2500, "bob": 1500, "sweep": -500})
+	tx, err := transactionRepo.getTransaction(context.Background(), bonus.TransactionID)
+	if err != nil || tx.Description != "Sign-up bonus" || tx.Metadata["promotionId"] != bonus.ID {
+		t.Errorf("transaction=%#v error=%v", tx, err)
+	}
+
+	w := serve("GET", "/promotions?accountId=alice", "", "ops")
+	var found []*promotion
+	if err := json.NewDecoder(w.Body).Decode(&found); err != nil || len(found) != 1 || found[0].ID != bonus.ID {
+		t.Errorf("promotions=%#v error=%v", found, err)
+	}
+	if w := serve("GET", "/promotions/"+referral.ID, "", "ops"); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("GET", "/promotions/missing", "", "ops"); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// operators can reverse promotions once, with a reason
+	if w := serve("POST", "/promotions/"+referral.ID+"/reversal", `{}`, "ops"); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("POST", "/promotions/missing/reversal", `{"reason": "duplicate"}`, "ops"); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	w = serve("POST", "/promotions/"+referral.ID+"/reversal", `{"reason": "duplicate"}`, "ops")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var reversed promotion
+	if err := json.NewDecoder(w.Body).Decode(&reversed); err != nil || reversed.Status != promotionReversed || reversed.ReversedBy != "ops" || reversed.ReversalTransactionID == "" {
+		t.Errorf("promotion=%#v error=%v", reversed, err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"bob": 1000, "sweep": 0})
+	if w := serve("POST", "/promotions/"+referral.ID+"/reversal", `{"reason": "duplicate"}`, "ops"); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// closing alice's account within 90 days claws back the bonus, which she partly spent
+	spend := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+		{AccountID: "alice", Purpose: ACHDebit, Amount: 1000},
+		{AccountID: "bob", Purpose: ACHCredit, Amount: 1000},
+	}}
+	if err := transactionRepo.createTransaction(context.Background(), spend, createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	closure := &accountClosureService{logger: log.NewNopLogger(), accounts: accountRepo, transactions: transactions, promotions: svc}
+	if _, err := closure.CloseAccount(context.Background(), "alice", closeAccountRequest{}); err == nil || !strings.Contains(err.Error(), "balance of -1000") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 1500, "marketing": -2500})
+
+	acct, err := closure.CloseAccount(context.Background(), "alice", closeAccountRequest{SweepAccountID: "sweep"})
+	if err != nil || acct.Status != "closed" {
+		t.Fatalf("account=%#v error=%v", acct, err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 0, "marketing": 0, "sweep": -1000})
+	clawedBack, _ := svc.repo.getPromotion(bonus.ID)
+	if clawedBack.Status != promotionReversed || clawedBack.ReversedBy != "" || clawedBack.ReversalReason != "account closed within 90 days" {
+		t.Errorf("unexpected promotion: %#v", clawedBack)
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var rateLimited = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+	Name: "http_rate_limited",
+	Help: "Counter of postings rejected with a 429 because their tenant was over its rate limit",
+}, nil)
+
+// maxRateLimitBuckets is how many tenants' buckets are kept before full buckets are dropped. A dropped bucket is
+// recreated full, so dropping them doesn't change which requests are limited.
+const maxRateLimitBuckets = 10000
+
+// rateLimit is a token bucket's refill rate (postings per second) and size.
+type rateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func parseRateLimit(rate, burst string) (rateLimit, error) {
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil || r <= 0 || math.IsInf(r, 0) {
+		return rateLimit{}, fmt.Errorf("invalid rate %q", rate)
+	}
+	b, err := strconv.Atoi(burst)
+	if err != nil || b < 1 {
+		return rateLimit{}, fmt.Errorf("invalid burst %q", burst)
+	}
+	return rateLimit{Rate: r, Burst: b}, nil
+}
+
+// rateLimits are the limits of postings from each tenant. Tenants without their own limit get the default, and
+// aren't limited when it's nil.
+type rateLimits struct {
+	Default *rateLimit
+	Tenants map[string]rateLimit
+}
+
+func (l rateLimits) enabled() bool {
+	return l.Default != nil || len(l.Tenants) > 0
+}
+
+func (l rateLimits) tenant(tenant string) (rateLimit, bool) {
+	if limit, ok := l.Tenants[tenant]; ok {
+		return limit, true
+	}
+	if l.Default != nil {
+		return *l.Default, true
+	}
+	return rateLimit{}, false
+}
+
+// readRateLimits reads RATE_LIMIT_POSTINGS, the rate:burst of every tenant's postings, and RATE_LIMIT_TENANTS, a
+// comma separated list of tenant:rate:burst quotas which replace it for those tenants.
+func readRateLimits() (rateLimits, error) {
+	limits := rateLimits{Tenants: make(map[string]rateLimit)}
+	if v := strings.TrimSpace(os.Getenv("RATE_LIMIT_POSTINGS")); v != "" {
+		parts := strings.Split(v, ":")
+		if len(parts) != 2 {
+			return limits, fmt.Errorf("RATE_LIMIT_POSTINGS: %q isn't rate:burst", v)
+		}
+		limit, err := parseRateLimit(parts[0], parts[1])
+		if err != nil {
+			return limits, fmt.Errorf("RATE_LIMIT_POSTINGS: %v", err)
+		}
+		limits.Default = &limit
+	}
+	for _, v := range strings.Split(os.Getenv("RATE_LIMIT_TENANTS"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		parts := strings.Split(v, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return limits, fmt.Errorf("RATE_LIMIT_TENANTS: %q isn't tenant:rate:burst", v)
+		}
+		limit, err := parseRateLimit(parts[1], parts[2])
+		if err != nil {
+			return limits, fmt.Errorf("RATE_LIMIT_TENANTS: tenant %q: %v", parts[0], err)
+		}
+		if _, exists := limits.Tenants[parts[0]]; exists {
+			return limits, fmt.Errorf("RATE_LIMIT_TENANTS: duplicate tenant %q", parts[0])
+		}
+		limits.Tenants[parts[0]] = limit
+	}
+	return limits, nil
+}
+
+// postsTransaction returns true for requests which create transactions.
+func postsTransaction(r *http.Request) bool {
+	if r.Method != "POST" {
+		return false
+	}
+	switch r.URL.Path {
+	case "/accounts/transactions", "/accounts/transactions/prepare", "/accounts/transfers":
+		return true
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	return len(parts) == 4 && parts[1] == "transaction-templates" && parts[3] == "transactions"
+}
+
+// rateLimitTenant returns who a posting is counted against, which is the organization it's scoped to. Requests in
+// the default organization are counted against the authenticated caller (such as a signing key) instead.
+func rateLimitTenant(r *http.Request) string {
+	if organization, _ := organizationFrom(r.Context()); organization != "" {
+		return organization
+	}
+	return r.Header.Get("X-User-ID")
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter rejects postings from a tenant with a 429 once it's used its bucket of tokens. Buckets refill at the
+// tenant's rate up to its burst, so short bursts are allowed while a runaway client is held to the rate.
+type rateLimiter struct {
+	logger log.Logger
+	next   http.Handler
+	limits rateLimits
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(logger log.Logger, limits rateLimits, next http.Handler) http.Handler {
+	if !limits.enabled() {
+		return next
+	}
+	return &rateLimiter{
+		logger:  logger,
+		next:    next,
+		limits:  limits,
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+func (rl *rateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !postsTransaction(r) {
+		rl.next.ServeHTTP(w, r)
+		return
+	}
+	tenant := rateLimitTenant(r)
+	limit, ok := rl.limits.tenant(tenant)
+	if !ok {
+		rl.next.ServeHTTP(w, r)
+		return
+	}
+	if wait := rl.take(tenant, limit); wait > 0 {
+		rateLimited.Add(1)
+		rl.logger.Log("ratelimit", fmt.Sprintf("limited %s %s from tenant %q", r.Method, r.URL.Path, tenant), "requestID", moovhttp.GetRequestID(r))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error":"rate limit exceeded, please retry"}`))
+		return
+	}
+	rl.next.ServeHTTP(w, r)
+}
+
+// take removes a token from the tenant's bucket, returning zero if it had one or how long until it will.
+func (rl *rateLimiter) take(tenant string, limit rateLimit) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	bucket, ok := rl.buckets[tenant]
+	if !ok {
+		if len(rl.buckets) >= maxRateLimitBuckets {
+			rl.dropFullBuckets(now)
+		}
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		rl.buckets[tenant] = bucket
+	}
+	if elapsed := now.Sub(bucket.last).Seconds(); elapsed > 0 {
+		bucket.tokens = math.Min(float64(limit.Burst), bucket.tokens+elapsed*limit.Rate)
+		bucket.last = now
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return 0
+	}
+	return time.Duration((1 - bucket.tokens) / limit.Rate * float64(time.Second))
+}
+
+func (rl *rateLimiter) dropFullBuckets(now time.Time) {
+	for tenant, bucket := range rl.buckets {
+		limit, _ := rl.limits.tenant(tenant)
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(rl.buckets, tenant)
+		}
+	}
+}
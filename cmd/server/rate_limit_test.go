@@ -0,0 +1,150 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRateLimits__read(t *testing.T) {
+	defer os.Unsetenv("RATE_LIMIT_POSTINGS")
+	defer os.Unsetenv("RATE_LIMIT_TENANTS")
+
+	if limits, err := readRateLimits(); err != nil || limits.enabled() {
+		t.Errorf("limits=%#v error=%v", limits, err)
+	}
+
+	os.Setenv("RATE_LIMIT_POSTINGS", "10:20")
+	os.Setenv("RATE_LIMIT_TENANTS", "acme:0.5:5, other:100:100")
+	limits, err := readRateLimits()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit, _ := limits.tenant("acme"); limit != (rateLimit{Rate: 0.5, Burst: 5}) {
+		t.Errorf("acme: %#v", limit)
+	}
+	if limit, _ := limits.tenant("unknown"); limit != (rateLimit{Rate: 10, Burst: 20}) {
+		t.Errorf("unknown: %#v", limit)
+	}
+
+	for _, v := range []string{"10", "0:5", "10:0", "fast:5"} {
+		os.Setenv("RATE_LIMIT_POSTINGS", v)
+		if _, err := readRateLimits(); err == nil {
+			t.Errorf("expected error for RATE_LIMIT_POSTINGS=%q", v)
+		}
+	}
+	os.Setenv("RATE_LIMIT_POSTINGS", "")
+	for _, v := range []string{"acme:10", ":1:1", "acme:1:1,acme:2:2", "acme:-1:5"} {
+		os.Setenv("RATE_LIMIT_TENANTS", v)
+		if _, err := readRateLimits(); err == nil {
+			t.Errorf("expected error for RATE_LIMIT_TENANTS=%q", v)
+		}
+	}
+}
+
+func TestRateLimits__postsTransaction(t *testing.T) {
+	cases := map[string]bool{
+		"POST /accounts/transactions":                                 true,
+		"POST /accounts/transactions/prepare":                         true,
+		"POST /accounts/transfers":                                    true,
+		"POST /accounts/transaction-templates/payroll/transactions":   true,
+		"POST /accounts/transaction-templates":                        false,
+		"POST /accounts":                                              false,
+		"PUT /accounts/transactions/1/status":                         false,
+		"GET /accounts/transactions/1":                                false,
+		"POST /accounts/transactions/1/reversal":                      false,
+		"DELETE /accounts/transaction-templates/payroll/transactions": false,
+	}
+	for route, expected := range cases {
+		parts := strings.SplitN(route, " ", 2)
+		if posts := postsTransaction(httptest.NewRequest(parts[0], parts[1], nil)); posts != expected {
+			t.Errorf("%s: got %v", route, posts)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	var served int
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	})
+	limits := rateLimits{
+		Default: &rateLimit{Rate: 1, Burst: 2},
+		Tenants: map[string]rateLimit{"acme": {Rate: 0.1, Burst: 1}},
+	}
+	handler := newRateLimiter(log.NewNopLogger(), limits, next).(*rateLimiter)
+	now := time.Now()
+	handler.now = func() time.Time { return now }
+
+	post := func(organization, userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/accounts/transactions", nil)
+		if organization != "" {
+			req = req.WithContext(withOrganization(req.Context(), organization))
+		}
+		req.Header.Set("X-User-ID", userID)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	// the default bucket allows a burst of two postings
+	for i := 0; i < 2; i++ {
+		if w := post("other", ""); w.Code != http.StatusOK {
+			t.Fatalf("posting %d: got %d", i, w.Code)
+		}
+	}
+	w := post("other", "")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("got %d Retry-After=%q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// tenants are limited separately, with their own quotas
+	if w := post("acme", ""); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	if w := post("acme", ""); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "10" {
+		t.Errorf("got %d Retry-After=%q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// the default organization is limited by caller
+	for _, userID := range []string{"key1", "key1", "key2", "key2"} {
+		if w := post("", userID); w.Code != http.StatusOK {
+			t.Errorf("%s: got %d", userID, w.Code)
+		}
+	}
+	if w := post("", "key1"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("got %d", w.Code)
+	}
+
+	// buckets refill over time
+	now = now.Add(1500 * time.Millisecond)
+	if w := post("other", ""); w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	if w := post("acme", ""); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "9" {
+		t.Errorf("got %d Retry-After=%q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// reads aren't limited
+	for i := 0; i < 5; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/accounts/transactions/1", nil).WithContext(withOrganization(context.Background(), "acme")))
+		if w.Code != http.StatusOK {
+			t.Errorf("got %d", w.Code)
+		}
+	}
+	if served != 13 {
+		t.Errorf("served %d requests", served)
+	}
+}
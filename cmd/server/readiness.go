@@ -0,0 +1,32 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+// serving tracks whether the public HTTP server is accepting requests. The admin server starts first, so without
+// it GET /ready would report ready while storage is still being setup and again while the server drains on shutdown.
+type serving struct {
+	state int32
+}
+
+func (s *serving) start() {
+	atomic.StoreInt32(&s.state, 1)
+}
+
+func (s *serving) stop() {
+	atomic.StoreInt32(&s.state, 0)
+}
+
+// check is an admin readiness check.
+func (s *serving) check() error {
+	if atomic.LoadInt32(&s.state) == 0 {
+		return errors.New("HTTP server isn't serving requests")
+	}
+	return nil
+}
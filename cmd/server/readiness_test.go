@@ -0,0 +1,62 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/moov-io/base/admin"
+)
+
+func TestServing(t *testing.T) {
+	var s serving
+	if err := s.check(); err == nil {
+		t.Error("expected error before starting")
+	}
+	s.start()
+	if err := s.check(); err != nil {
+		t.Error(err)
+	}
+	s.stop()
+	if err := s.check(); err == nil {
+		t.Error("expected error after stopping")
+	}
+}
+
+func TestServing__ready(t *testing.T) {
+	var s serving
+	repo := &testAccountRepository{}
+
+	svc := admin.NewServer(":0")
+	go svc.Listen()
+	defer svc.Shutdown()
+	svc.AddReadinessCheck("http", s.check)
+	svc.AddReadinessCheck("accounts", repo.Ping)
+
+	ready := func() (int, string) {
+		resp, err := http.Get("http://" + svc.BindAddr() + "/ready")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		bs, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(bs)
+	}
+	if code, body := ready(); code == http.StatusOK || !strings.Contains(body, "isn't serving") {
+		t.Errorf("ready while starting: %d %s", code, body)
+	}
+	s.start()
+	if code, body := ready(); code != http.StatusOK {
+		t.Errorf("not ready: %d %s", code, body)
+	}
+	repo.err = errors.New("connection refused")
+	if code, body := ready(); code == http.StatusOK || !strings.Contains(body, "accounts") {
+		t.Errorf("ready without storage: %d %s", code, body)
+	}
+}
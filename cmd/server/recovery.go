@@ -0,0 +1,210 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	app "github.com/moov-io/accounts"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	handlerPanics = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "http_handler_panics",
+		Help: "Counter of HTTP handlers which panicked",
+	}, []string{"route"})
+)
+
+// errorReporter sends unexpected errors to an external error tracking service.
+type errorReporter interface {
+	report(evt errorEvent)
+}
+
+// errorEvent is an error along with context for grouping and debugging it.
+type errorEvent struct {
+	Err error
+
+	// Fingerprint groups similar events together. When empty the aggregator groups by message.
+	Fingerprint []string
+
+	Tags  map[string]string
+	Extra map[string]string
+}
+
+// requestErrorEvent returns an errorEvent for err which occurred while serving r.
+func requestErrorEvent(err error, stack []byte, r *http.Request) errorEvent {
+	return errorEvent{
+		Err: err,
+		Tags: map[string]string{
+			"method": r.Method,
+			"route":  cleanMetricsPath(r.URL.Path),
+		},
+		Extra: map[string]string{
+			"path":      r.URL.Path,
+			"requestID": moovhttp.GetRequestID(r),
+			"userID":    moovhttp.GetUserID(r),
+			"stack":     string(stack),
+		},
+	}
+}
+
+// recovery converts panics from the wrapped handler into 500 responses rather than letting a single
+// request take down the process.
+type recovery struct {
+	logger   log.Logger
+	reporter errorReporter // optional
+	next     http.Handler
+}
+
+func newRecovery(logger log.Logger, reporter errorReporter, next http.Handler) *recovery {
+	return &recovery{
+		logger:   logger,
+		reporter: reporter,
+		next:     next,
+	}
+}
+
+func (rec *recovery) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v == http.ErrAbortHandler {
+			panic(v) // the stdlib uses this to abort a response, so let it through
+		}
+		err, ok := v.(error)
+		if !ok {
+			err = fmt.Errorf("%v", v)
+		}
+		stack := debug.Stack()
+
+		handlerPanics.With("route", fmt.Sprintf("%s-%s", strings.ToLower(r.Method), cleanMetricsPath(r.URL.Path))).Add(1)
+		rec.logger.Log(
+			"panic", err,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"userID", moovhttp.GetUserID(r),
+			"requestID", moovhttp.GetRequestID(r),
+			"stack", string(stack),
+		)
+		if rec.reporter != nil {
+			rec.reporter.report(requestErrorEvent(err, stack, r))
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": "internal server error",
+		})
+	}()
+	rec.next.ServeHTTP(w, r)
+}
+
+// sentryReporter sends errors to Sentry's store API.
+type sentryReporter struct {
+	logger log.Logger
+	client *http.Client
+
+	storeURL string
+	key      string
+}
+
+// newSentryReporter parses a Sentry DSN (https://<key>@<host>/<project>) and returns a reporter
+// for it. A nil reporter is returned when dsn is empty.
+func newSentryReporter(logger log.Logger, dsn string) (*sentryReporter, error) {
+	if dsn == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sentry: invalid DSN: %v", err)
+	}
+	project := strings.Trim(u.Path, "/")
+	if u.User == nil || u.User.Username() == "" || project == "" {
+		return nil, errors.New("sentry: DSN is missing its key or project")
+	}
+	return &sentryReporter{
+		logger:   logger,
+		client:   &http.Client{Timeout: 5 * time.Second},
+		storeURL: fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project),
+		key:      u.User.Username(),
+	}, nil
+}
+
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	Release     string            `json:"release"`
+	Message     string            `json:"message"`
+	Fingerprint []string          `json:"fingerprint,omitempty"`
+	Tags        map[string]string `json:"tags"`
+	Extra       map[string]string `json:"extra"`
+}
+
+func (s *sentryReporter) report(e errorEvent) {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	evt := sentryEvent{
+		EventID:     hex.EncodeToString(id),
+		Timestamp:   time.Now().UTC().Format("2006-01-02T15:04:05"),
+		Level:       "error",
+		Platform:    "go",
+		Logger:      "accounts",
+		Release:     app.Version,
+		Message:     e.Err.Error(),
+		Fingerprint: e.Fingerprint,
+		Tags:        e.Tags,
+		Extra:       e.Extra,
+	}
+	// Send the event in the background so the client's response isn't delayed by Sentry
+	go func() {
+		if err := s.send(evt); err != nil {
+			s.logger.Log("sentry", fmt.Sprintf("problem reporting event=%s: %v", evt.EventID, err))
+		}
+	}()
+}
+
+func (s *sentryReporter) send(evt sentryEvent) error {
+	bs, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", s.storeURL, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=moov-accounts/%s, sentry_key=%s", app.Version, s.key))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected HTTP status: %s", resp.Status)
+	}
+	return nil
+}
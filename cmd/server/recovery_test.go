@@ -0,0 +1,92 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+type mockErrorReporter struct {
+	err    error
+	events []errorEvent
+}
+
+func (r *mockErrorReporter) report(evt errorEvent) {
+	r.err = evt.Err
+	r.events = append(r.events, evt)
+}
+
+func TestRecovery(t *testing.T) {
+	reporter := &mockErrorReporter{}
+	handler := newRecovery(log.NewNopLogger(), reporter, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic(errors.New("bad thing"))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	if reporter.err != nil {
+		t.Errorf("unexpected report: %v", reporter.err)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got %d", w.Code)
+	}
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp["error"] == "" {
+		t.Errorf("resp=%#v error=%v", resp, err)
+	}
+	if reporter.err == nil || reporter.err.Error() != "bad thing" {
+		t.Errorf("unexpected report: %v", reporter.err)
+	}
+}
+
+func TestSentryReporter(t *testing.T) {
+	if r, err := newSentryReporter(log.NewNopLogger(), ""); r != nil || err != nil {
+		t.Errorf("reporter=%v error=%v", r, err)
+	}
+	if _, err := newSentryReporter(log.NewNopLogger(), "https://sentry.example.com/"); err == nil {
+		t.Error("expected error")
+	}
+
+	events := make(chan sentryEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/42/store/" || !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var evt sentryEvent
+		json.NewDecoder(r.Body).Decode(&evt)
+		events <- evt
+	}))
+	defer server.Close()
+
+	reporter, err := newSentryReporter(log.NewNopLogger(), strings.Replace(server.URL, "http://", "http://public@", 1)+"/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/accounts/search", nil)
+	req.Header.Set("X-Request-ID", "request")
+	reporter.report(requestErrorEvent(errors.New("bad thing"), []byte("stack"), req))
+
+	evt := <-events
+	if evt.Message != "bad thing" || evt.Extra["requestID"] != "request" || len(evt.EventID) != 32 {
+		t.Errorf("unexpected event: %#v", evt)
+	}
+}
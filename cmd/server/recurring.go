@@ -0,0 +1,541 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Recurring rules post a transaction between an account and a counterparty account on a schedule, such as a monthly
+// fee or interest paid from a program account. Schedules are a subset of RFC 5545 recurrence rules (RRULE) anchored
+// at the rule's start, whose time of day each occurrence keeps. A job materializes each occurrence once it's due.
+
+const (
+	// maxRecurringPeriods bounds how many periods (days, weeks, months or years) of a schedule are searched for
+	// its next occurrence
+	maxRecurringPeriods = 100 * 366
+)
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// recurringSchedule is a parsed recurrence rule, such as FREQ=MONTHLY;BYMONTHDAY=-1 for the last day of each month.
+type recurringSchedule struct {
+	freq       string // DAILY, WEEKLY, MONTHLY or YEARLY
+	interval   int
+	byDay      []time.Weekday // only with FREQ=WEEKLY
+	byMonthDay []int          // only with FREQ=MONTHLY, negative days count back from the end of the month
+	count      int
+	until      time.Time
+}
+
+// parseRecurringSchedule reads FREQ (required), INTERVAL, BYDAY, BYMONTHDAY, COUNT and UNTIL parts from an RRULE.
+// An "RRULE:" prefix is ignored.
+func parseRecurringSchedule(rule string) (*recurringSchedule, error) {
+	rule = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(rule)), "RRULE:")
+	if rule == "" {
+		return nil, errors.New("empty schedule")
+	}
+	s := &recurringSchedule{interval: 1}
+	for _, part := range strings.Split(rule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("invalid schedule part %q", part)
+		}
+		switch key, value := kv[0], kv[1]; key {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY", "YEARLY":
+				s.freq = value
+			default:
+				return nil, fmt.Errorf("unsupported FREQ %q", value)
+			}
+		case "INTERVAL", "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %s %q", key, value)
+			}
+			if key == "INTERVAL" {
+				s.interval = n
+			} else {
+				s.count = n
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				wd, ok := weekdays[day]
+				if !ok {
+					return nil, fmt.Errorf("invalid BYDAY %q", day)
+				}
+				s.byDay = append(s.byDay, wd)
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(value, ",") {
+				n, err := strconv.Atoi(day)
+				if err != nil || n == 0 || n < -31 || n > 31 {
+					return nil, fmt.Errorf("invalid BYMONTHDAY %q", day)
+				}
+				s.byMonthDay = append(s.byMonthDay, n)
+			}
+		case "UNTIL":
+			until, err := time.Parse("20060102T150405Z", value)
+			if err != nil {
+				if until, err = time.Parse("20060102", value); err != nil {
+					return nil, fmt.Errorf("invalid UNTIL %q, expected YYYYMMDD or YYYYMMDDTHHMMSSZ", value)
+				}
+				until = until.Add(24*time.Hour - time.Second) // the whole day
+			}
+			s.until = until
+		default:
+			return nil, fmt.Errorf("unsupported schedule part %q", key)
+		}
+	}
+	if s.freq == "" {
+		return nil, errors.New("schedule has no FREQ")
+	}
+	if len(s.byDay) > 0 && s.freq != "WEEKLY" {
+		return nil, errors.New("BYDAY is only supported with FREQ=WEEKLY")
+	}
+	if len(s.byMonthDay) > 0 && s.freq != "MONTHLY" {
+		return nil, errors.New("BYMONTHDAY is only supported with FREQ=MONTHLY")
+	}
+	if s.count > 0 && !s.until.IsZero() {
+		return nil, errors.New("schedule can't have both COUNT and UNTIL")
+	}
+	return s, nil
+}
+
+// next returns the first occurrence after the given time of a schedule starting at start, or false once the
+// schedule has finished.
+func (s *recurringSchedule) next(start, after time.Time) (time.Time, bool) {
+	start = start.UTC()
+	n := 0
+	for period := 0; period < maxRecurringPeriods; period++ {
+		for _, t := range s.period(start, period) {
+			if t.Before(start) {
+				continue
+			}
+			if !s.until.IsZero() && t.After(s.until) {
+				return time.Time{}, false
+			}
+			if n++; s.count > 0 && n > s.count {
+				return time.Time{}, false
+			}
+			if t.After(after) {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// period returns the occurrences within the nth period of the schedule, in order. Days which don't exist in a
+// period (such as the 31st of a shorter month) are skipped.
+func (s *recurringSchedule) period(start time.Time, n int) []time.Time {
+	n *= s.interval
+	switch s.freq {
+	case "DAILY":
+		return []time.Time{start.AddDate(0, 0, n)}
+
+	case "WEEKLY":
+		monday := start.AddDate(0, 0, -((int(start.Weekday())+6)%7)+7*n)
+		days := s.byDay
+		if len(days) == 0 {
+			days = []time.Weekday{start.Weekday()}
+		}
+		var out []time.Time
+		for _, wd := range days {
+			out = append(out, monday.AddDate(0, 0, (int(wd)+6)%7))
+		}
+		return sortedUniqueTimes(out)
+
+	case "MONTHLY":
+		first := time.Date(start.Year(), start.Month()+time.Month(n), 1, start.Hour(), start.Minute(), start.Second(), 0, time.UTC)
+		daysInMonth := first.AddDate(0, 1, -1).Day()
+		days := s.byMonthDay
+		if len(days) == 0 {
+			days = []int{start.Day()}
+		}
+		var out []time.Time
+		for _, day := range days {
+			if day < 0 {
+				day = daysInMonth + 1 + day
+			}
+			if day >= 1 && day <= daysInMonth {
+				out = append(out, first.AddDate(0, 0, day-1))
+			}
+		}
+		return sortedUniqueTimes(out)
+
+	case "YEARLY":
+		t := time.Date(start.Year()+n, start.Month(), start.Day(), start.Hour(), start.Minute(), start.Second(), 0, time.UTC)
+		if t.Month() != start.Month() {
+			return nil // February 29th outside a leap year
+		}
+		return []time.Time{t}
+	}
+	return nil
+}
+
+func sortedUniqueTimes(times []time.Time) []time.Time {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	out := times[:0]
+	for i := range times {
+		if i == 0 || !times[i].Equal(times[i-1]) {
+			out = append(out, times[i])
+		}
+	}
+	return out
+}
+
+type recurringDirection string
+
+const (
+	// recurringDebit moves funds from the account to the counterparty, such as a fee
+	recurringDebit recurringDirection = "debit"
+	// recurringCredit moves funds from the counterparty to the account, such as interest
+	recurringCredit recurringDirection = "credit"
+)
+
+type recurringRuleStatus string
+
+const (
+	recurringActive    recurringRuleStatus = "active"
+	recurringCompleted recurringRuleStatus = "completed"
+)
+
+type recurringRule struct {
+	ID                    string              `json:"id"`
+	AccountID             string              `json:"accountId"`
+	CounterpartyAccountID string              `json:"counterpartyAccountId"`
+	Amount                int                 `json:"amount"`
+	Direction             recurringDirection  `json:"direction"`
+	Purpose               TransactionPurpose  `json:"purpose"`
+	Description           string              `json:"description,omitempty"`
+	Schedule              string              `json:"schedule"`
+	Start                 time.Time           `json:"start"`
+	Status                recurringRuleStatus `json:"status"`
+
+	// Occurrences counts every occurrence which was due, whether or not its transaction posted
+	Occurrences int        `json:"occurrences"`
+	NextRunAt   *time.Time `json:"nextRunAt,omitempty"`
+
+	// LastRunAt is the latest occurrence, which posted LastTransactionID or failed with LastError
+	LastRunAt         *time.Time `json:"lastRunAt,omitempty"`
+	LastTransactionID string     `json:"lastTransactionId,omitempty"`
+	LastError         string     `json:"lastError,omitempty"`
+
+	CreatedBy    string    `json:"createdBy,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// lines returns the lines of each transaction the rule posts. The debited side is an ACH debit and the credited side
+// has the rule's purpose.
+func (rule *recurringRule) lines() []transactionLine {
+	from, to := rule.AccountID, rule.CounterpartyAccountID
+	if rule.Direction == recurringCredit {
+		from, to = to, from
+	}
+	return []transactionLine{
+		{AccountID: from, Purpose: ACHDebit, Amount: rule.Amount},
+		{AccountID: to, Purpose: rule.Purpose, Amount: rule.Amount},
+	}
+}
+
+type createRecurringRuleRequest struct {
+	CounterpartyAccountID string             `json:"counterpartyAccountId"`
+	Amount                int                `json:"amount"`
+	Direction             recurringDirection `json:"direction"`
+	Purpose               TransactionPurpose `json:"purpose"`
+	Description           string             `json:"description,omitempty"`
+	Schedule              string             `json:"schedule"`
+
+	// Start anchors the schedule and defaults to now
+	Start *time.Time `json:"start,omitempty"`
+}
+
+type recurringService struct {
+	logger       log.Logger
+	repo         recurringRuleRepository
+	accounts     accountRepository
+	transactions *transactionService
+
+	// now is the time rules are created and run at, which in sandbox mode is the virtual clock
+	now func() time.Time
+}
+
+// CreateRule saves a rule posting between the account and a counterparty account on a schedule.
+func (s *recurringService) CreateRule(ctx context.Context, accountID string, userID string, req createRecurringRuleRequest) (*recurringRule, error) {
+	if req.CounterpartyAccountID == "" || req.CounterpartyAccountID == accountID {
+		return nil, errors.New("recurring rules require a counterpartyAccountId other than the account")
+	}
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("invalid amount %d", req.Amount)
+	}
+	if req.Direction != recurringDebit && req.Direction != recurringCredit {
+		return nil, fmt.Errorf("invalid direction %q, expected debit or credit", req.Direction)
+	}
+	if err := req.Purpose.validate(); err != nil {
+		return nil, err
+	}
+	if req.Purpose == ACHDebit {
+		return nil, errors.New("purpose is of the credited line and can't be achdebit")
+	}
+	if err := validateDescription(req.Description); err != nil {
+		return nil, err
+	}
+	schedule, err := parseRecurringSchedule(req.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule: %v", err)
+	}
+	accts, err := s.accounts.GetAccounts(ctx, []string{accountID, req.CounterpartyAccountID})
+	if err != nil {
+		return nil, err
+	}
+	if len(accts) != 2 {
+		return nil, fmt.Errorf("account=%s or counterparty account=%s: %v", accountID, req.CounterpartyAccountID, errAccountNotFound)
+	}
+
+	now := s.now()
+	rule := &recurringRule{
+		ID:                    newID(),
+		AccountID:             accountID,
+		CounterpartyAccountID: req.CounterpartyAccountID,
+		Amount:                req.Amount,
+		Direction:             req.Direction,
+		Purpose:               req.Purpose,
+		Description:           req.Description,
+		Schedule:              strings.ToUpper(strings.TrimSpace(req.Schedule)),
+		Start:                 now.UTC().Truncate(time.Second),
+		Status:                recurringActive,
+		CreatedBy:             userID,
+		CreatedAt:             now,
+		LastModified:          now,
+	}
+	if req.Start != nil {
+		rule.Start = req.Start.UTC().Truncate(time.Second)
+	}
+	// Occurrences before the rule was created aren't posted
+	from := rule.Start
+	if from.Before(now) {
+		from = now
+	}
+	next, ok := schedule.next(rule.Start, from.Add(-time.Second))
+	if !ok {
+		return nil, errors.New("schedule has no occurrences")
+	}
+	rule.NextRunAt = &next
+	if err := s.repo.createRecurringRule(rule); err != nil {
+		return nil, err
+	}
+	s.logger.Log("recurring", fmt.Sprintf("created recurring rule=%s for account=%s first running at %v", rule.ID, accountID, next), "userID", userID, "requestID", requestIDFrom(ctx))
+	return rule, nil
+}
+
+// runDue materializes every occurrence of active rules which is due at now, including occurrences missed while the
+// job wasn't running. It returns how many transactions were posted.
+func (s *recurringService) runDue(ctx context.Context, now time.Time) (int, error) {
+	rules, err := s.repo.getDueRecurringRules(now)
+	if err != nil {
+		return 0, err
+	}
+	posted := 0
+	for _, rule := range rules {
+		for rule.Status == recurringActive && rule.NextRunAt != nil && !rule.NextRunAt.After(now) {
+			ok, err := s.run(ctx, rule)
+			if err != nil {
+				return posted, fmt.Errorf("recurring rule=%s: %v", rule.ID, err)
+			}
+			if ok {
+				posted++
+			}
+		}
+	}
+	return posted, nil
+}
+
+// run posts the rule's next occurrence and schedules the one after it. The occurrence's idempotency key means a
+// transaction which posted before the rule could be saved isn't posted again. Occurrences which fail (such as for
+// insufficient funds) are skipped and recorded as the rule's LastError.
+func (s *recurringService) run(ctx context.Context, rule *recurringRule) (bool, error) {
+	schedule, err := parseRecurringSchedule(rule.Schedule)
+	if err != nil {
+		return false, err
+	}
+	occurrence := *rule.NextRunAt
+	tx, err := s.transactions.CreateTransaction(ctx, createTransactionRequest{
+		Lines:          rule.lines(),
+		Timestamp:      s.now(),
+		Description:    rule.Description,
+		Metadata:       map[string]string{"recurringRuleId": rule.ID, "occurrence": occurrence.Format(time.RFC3339)},
+		IdempotencyKey: fmt.Sprintf("recurring:%s:%d", rule.ID, occurrence.Unix()),
+	})
+	rule.Occurrences++
+	rule.LastRunAt = &occurrence
+	if err != nil {
+		rule.LastTransactionID, rule.LastError = "", err.Error()
+		s.logger.Log("recurring", fmt.Sprintf("problem posting recurring rule=%s occurrence at %v: %v", rule.ID, occurrence, err))
+	} else {
+		rule.LastTransactionID, rule.LastError = tx.ID, ""
+		s.logger.Log("recurring", fmt.Sprintf("posted recurring rule=%s occurrence at %v", rule.ID, occurrence), "transactionID", tx.ID)
+	}
+	if next, ok := schedule.next(rule.Start, occurrence); ok {
+		rule.NextRunAt = &next
+	} else {
+		rule.Status, rule.NextRunAt = recurringCompleted, nil
+	}
+	rule.LastModified = s.now()
+	if err := s.repo.updateRecurringRule(rule); err != nil {
+		return false, err
+	}
+	return tx != nil, nil
+}
+
+func setupRecurringJob(ctx context.Context, logger log.Logger, svc *recurringService, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if _, err := svc.runDue(ctx, svc.now()); err != nil {
+					logger.Log("recurring", fmt.Sprintf("problem running recurring rules: %v", err))
+				}
+			}
+		}
+	}()
+}
+
+func addRecurringRuleRoutes(logger log.Logger, router *mux.Router, svc *recurringService) {
+	router.Methods("GET").Path("/accounts/{accountId}/recurring").HandlerFunc(getRecurringRules(logger, svc))
+	router.Methods("POST").Path("/accounts/{accountId}/recurring").HandlerFunc(createRecurringRule(logger, svc))
+	router.Methods("GET").Path("/accounts/{accountId}/recurring/{ruleId}").HandlerFunc(getRecurringRule(logger, svc))
+	router.Methods("DELETE").Path("/accounts/{accountId}/recurring/{ruleId}").HandlerFunc(deleteRecurringRule(logger, svc))
+}
+
+func getRecurringRules(logger log.Logger, svc *recurringService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		account := customerAccount(w, r, svc.accounts)
+		if account == nil {
+			return
+		}
+		rules, err := svc.repo.getRecurringRules(account.ID)
+		if err != nil {
+			logger.Log("recurring", fmt.Sprintf("problem reading account=%s recurring rules: %v", account.ID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if rules == nil {
+			rules = []*recurringRule{}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(rules)
+	}
+}
+
+func createRecurringRule(logger log.Logger, svc *recurringService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		account := customerAccount(w, r, svc.accounts)
+		if account == nil {
+			return
+		}
+		var req createRecurringRuleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		rule, err := svc.CreateRule(requestContext(r), account.ID, moovhttp.GetUserID(r), req)
+		if err != nil {
+			logger.Log("recurring", fmt.Sprintf("problem creating recurring rule: %v", err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(rule)
+	}
+}
+
+func getRecurringRule(logger log.Logger, svc *recurringService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		account := customerAccount(w, r, svc.accounts)
+		if account == nil {
+			return
+		}
+		rule, err := svc.repo.getRecurringRule(account.ID, mux.Vars(r)["ruleId"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if rule == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(rule)
+	}
+}
+
+// deleteRecurringRule stops a rule from posting any further occurrences.
+func deleteRecurringRule(logger log.Logger, svc *recurringService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		account := customerAccount(w, r, svc.accounts)
+		if account == nil {
+			return
+		}
+		ruleID := mux.Vars(r)["ruleId"]
+		rule, err := svc.repo.getRecurringRule(account.ID, ruleID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if rule == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := svc.repo.deleteRecurringRule(account.ID, ruleID); err != nil {
+			logger.Log("recurring", fmt.Sprintf("problem deleting recurring rule=%s: %v", ruleID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("recurring", fmt.Sprintf("deleted recurring rule=%s", ruleID), "requestID", moovhttp.GetRequestID(r))
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type recurringRuleRepository interface {
+	Ping() error
+	Close() error
+
+	createRecurringRule(rule *recurringRule) error
+
+	// updateRecurringRule saves a rule's status and the outcome of its latest occurrence.
+	updateRecurringRule(rule *recurringRule) error
+	deleteRecurringRule(accountID, ruleID string) error
+
+	// getRecurringRule returns nil if the rule doesn't exist on accountID.
+	getRecurringRule(accountID, ruleID string) (*recurringRule, error)
+	getRecurringRules(accountID string) ([]*recurringRule, error)
+
+	// getDueRecurringRules returns the active rules whose next occurrence is at or before now.
+	getDueRecurringRules(now time.Time) ([]*recurringRule, error)
+}
+
+type sqlRecurringRuleRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlRecurringRuleRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlRecurringRuleRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlRecurringRuleRepository) createRecurringRule(rule *recurringRule) error {
+	query := `insert into recurring_rules (rule_id, account_id, counterparty_account_id, amount, direction, purpose, description, schedule, start_at, status, occurrences, next_run_at, created_by, created_at, last_modified) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createRecurringRule: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(rule.ID, rule.AccountID, rule.CounterpartyAccountID, rule.Amount, rule.Direction, rule.Purpose, rule.Description, rule.Schedule,
+		rule.Start, rule.Status, rule.Occurrences, rule.NextRunAt, rule.CreatedBy, rule.CreatedAt, rule.LastModified)
+	if err != nil {
+		return fmt.Errorf("createRecurringRule: rule=%s: %v", rule.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlRecurringRuleRepository) updateRecurringRule(rule *recurringRule) error {
+	query := `update recurring_rules set status = ?, occurrences = ?, next_run_at = ?, last_run_at = ?, last_transaction_id = ?, last_error = ?, last_modified = ?
+where rule_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("updateRecurringRule: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(rule.Status, rule.Occurrences, rule.NextRunAt, rule.LastRunAt, rule.LastTransactionID, rule.LastError, rule.LastModified, rule.ID)
+	if err != nil {
+		return fmt.Errorf("updateRecurringRule: rule=%s: %v", rule.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlRecurringRuleRepository) deleteRecurringRule(accountID, ruleID string) error {
+	query := `update recurring_rules set deleted_at = ? where rule_id = ? and account_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("deleteRecurringRule: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(time.Now(), ruleID, accountID); err != nil {
+		return fmt.Errorf("deleteRecurringRule: rule=%s: %v", ruleID, err)
+	}
+	return nil
+}
+
+func (r *sqlRecurringRuleRepository) getRecurringRule(accountID, ruleID string) (*recurringRule, error) {
+	rules, err := r.queryRecurringRules(`rule_id = ? and account_id = ?`, ruleID, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getRecurringRule: %v", err)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	return rules[0], nil
+}
+
+func (r *sqlRecurringRuleRepository) getRecurringRules(accountID string) ([]*recurringRule, error) {
+	rules, err := r.queryRecurringRules(`account_id = ? order by created_at, rule_id`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getRecurringRules: %v", err)
+	}
+	return rules, nil
+}
+
+func (r *sqlRecurringRuleRepository) getDueRecurringRules(now time.Time) ([]*recurringRule, error) {
+	rules, err := r.queryRecurringRules(`status = ? and next_run_at <= ? order by next_run_at, rule_id`, recurringActive, now)
+	if err != nil {
+		return nil, fmt.Errorf("getDueRecurringRules: %v", err)
+	}
+	return rules, nil
+}
+
+func (r *sqlRecurringRuleRepository) queryRecurringRules(where string, args ...interface{}) ([]*recurringRule, error) {
+	query := fmt.Sprintf(`select rule_id, account_id, counterparty_account_id, amount, direction, purpose, description, schedule, start_at, status, occurrences,
+next_run_at, last_run_at, last_transaction_id, last_error, created_by, created_at, last_modified from recurring_rules where deleted_at is null and %s;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*recurringRule
+	for rows.Next() {
+		var rule recurringRule
+		var lastTransactionID, lastError *string
+		if err := rows.Scan(&rule.ID, &rule.AccountID, &rule.CounterpartyAccountID, &rule.Amount, &rule.Direction, &rule.Purpose, &rule.Description, &rule.Schedule,
+			&rule.Start, &rule.Status, &rule.Occurrences, &rule.NextRunAt, &rule.LastRunAt, &lastTransactionID, &lastError, &rule.CreatedBy, &rule.CreatedAt, &rule.LastModified); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		if lastTransactionID != nil {
+			rule.LastTransactionID = *lastTransactionID
+		}
+		if lastError != nil {
+			rule.LastError = *lastError
+		}
+		out = append(out, &rule)
+	}
+	return out, rows.Err()
+}
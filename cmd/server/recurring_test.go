@@ -0,0 +1,252 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/moov-io/base"
+)
+
+func TestRecurringSchedule__parse(t *testing.T) {
+	s, err := parseRecurringSchedule("rrule:freq=weekly;interval=2;byday=MO,FR;count=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.freq != "WEEKLY" || s.interval != 2 || len(s.byDay) != 2 || s.byDay[1] != time.Friday || s.count != 4 {
+		t.Errorf("unexpected schedule: %#v", s)
+	}
+	if s, err := parseRecurringSchedule("FREQ=MONTHLY;UNTIL=20270101"); err != nil || !s.until.Equal(time.Date(2027, 1, 1, 23, 59, 59, 0, time.UTC)) {
+		t.Errorf("schedule=%#v error=%v", s, err)
+	}
+
+	for _, v := range []string{
+		"", "INTERVAL=2", "FREQ=HOURLY", "FREQ=DAILY;INTERVAL=0", "FREQ=WEEKLY;BYDAY=XX", "FREQ=DAILY;BYDAY=MO",
+		"FREQ=MONTHLY;BYMONTHDAY=32", "FREQ=WEEKLY;BYMONTHDAY=1", "FREQ=DAILY;COUNT=2;UNTIL=20270101", "FREQ=DAILY;BYHOUR=9", "FREQ",
+	} {
+		if _, err := parseRecurringSchedule(v); err == nil {
+			t.Errorf("%s: expected error", v)
+		}
+	}
+}
+
+func TestRecurringSchedule__next(t *testing.T) {
+	occurrences := func(rule string, start time.Time, n int) []string {
+		t.Helper()
+		s, err := parseRecurringSchedule(rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		after := start.Add(-time.Second)
+		for i := 0; i < n; i++ {
+			next, ok := s.next(start, after)
+			if !ok {
+				break
+			}
+			out = append(out, next.Format("2006-01-02 15:04"))
+			after = next
+		}
+		return out
+	}
+	start := time.Date(2026, 1, 31, 9, 30, 0, 0, time.UTC) // a Saturday
+
+	cases := map[string][]string{
+		"FREQ=DAILY;INTERVAL=3":                {"2026-01-31 09:30", "2026-02-03 09:30", "2026-02-06 09:30"},
+		"FREQ=WEEKLY":                          {"2026-01-31 09:30", "2026-02-07 09:30", "2026-02-14 09:30"},
+		"FREQ=WEEKLY;BYDAY=MO,SA":              {"2026-01-31 09:30", "2026-02-02 09:30", "2026-02-07 09:30", "2026-02-09 09:30"},
+		"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO":      {"2026-02-09 09:30", "2026-02-23 09:30"},
+		"FREQ=MONTHLY":                         {"2026-01-31 09:30", "2026-03-31 09:30", "2026-05-31 09:30"},
+		"FREQ=MONTHLY;BYMONTHDAY=-1":           {"2026-01-31 09:30", "2026-02-28 09:30", "2026-03-31 09:30"},
+		"FREQ=MONTHLY;BYMONTHDAY=1,15;COUNT=3": {"2026-02-01 09:30", "2026-02-15 09:30", "2026-03-01 09:30"},
+		"FREQ=MONTHLY;UNTIL=20260430":          {"2026-01-31 09:30", "2026-03-31 09:30"},
+		"FREQ=YEARLY":                          {"2026-01-31 09:30", "2027-01-31 09:30"},
+	}
+	for rule, expected := range cases {
+		if got := occurrences(rule, start, len(expected)); fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("%s: got %v expected %v", rule, got, expected)
+		}
+	}
+	for _, rule := range []string{"FREQ=MONTHLY;BYMONTHDAY=1,15;COUNT=3", "FREQ=MONTHLY;UNTIL=20260430"} {
+		if got := occurrences(rule, start, 10); len(got) != len(cases[rule]) {
+			t.Errorf("%s: expected the schedule to finish: %v", rule, got)
+		}
+	}
+
+	// February 29th only occurs in leap years
+	if got := occurrences("FREQ=YEARLY", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC), 2); fmt.Sprint(got) != "[2028-02-29 00:00 2032-02-29 00:00]" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestRecurringRuleRepository(t *testing.T) {
+	check := func(t *testing.T, repo *sqlRecurringRuleRepository) {
+		now := time.Now().UTC().Truncate(time.Second)
+		next := now.Add(time.Hour)
+		rule := &recurringRule{ID: base.ID(), AccountID: "alice", CounterpartyAccountID: "fees", Amount: 500, Direction: recurringDebit, Purpose: Fee,
+			Schedule: "FREQ=MONTHLY", Start: now, Status: recurringActive, NextRunAt: &next, CreatedBy: "test", CreatedAt: now, LastModified: now}
+		if err := repo.createRecurringRule(rule); err != nil {
+			t.Fatal(err)
+		}
+		if due, err := repo.getDueRecurringRules(now); err != nil || len(due) != 0 {
+			t.Errorf("due=%#v error=%v", due, err)
+		}
+		due, err := repo.getDueRecurringRules(next)
+		if err != nil || len(due) != 1 || due[0].Amount != 500 || due[0].Purpose != Fee || !due[0].NextRunAt.Equal(next) || due[0].LastRunAt != nil {
+			t.Fatalf("due=%#v error=%v", due, err)
+		}
+
+		rule.Occurrences, rule.LastRunAt, rule.LastTransactionID = 1, &next, base.ID()
+		rule.Status, rule.NextRunAt = recurringCompleted, nil
+		if err := repo.updateRecurringRule(rule); err != nil {
+			t.Fatal(err)
+		}
+		if due, err := repo.getDueRecurringRules(next); err != nil || len(due) != 0 {
+			t.Errorf("due=%#v error=%v", due, err)
+		}
+		found, err := repo.getRecurringRule("alice", rule.ID)
+		if err != nil || found == nil || found.Status != recurringCompleted || found.Occurrences != 1 || found.NextRunAt != nil || found.LastTransactionID != rule.LastTransactionID {
+			t.Errorf("rule=%#v error=%v", found, err)
+		}
+		if found, err := repo.getRecurringRule("bob", rule.ID); err != nil || found != nil {
+			t.Errorf("rule=%#v error=%v", found, err)
+		}
+
+		if err := repo.deleteRecurringRule("alice", rule.ID); err != nil {
+			t.Fatal(err)
+		}
+		if rules, err := repo.getRecurringRules("alice"); err != nil || len(rules) != 0 {
+			t.Errorf("rules=%#v error=%v", rules, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlRecurringRuleRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlRecurringRuleRepository{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestRecurringRules(t *testing.T) {
+	accountRepo, ledger := createTestLedger(t, map[string]int{"alice": 2000, "fees": 0, "program": 10000})
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	svc := &recurringService{
+		logger:       log.NewNopLogger(),
+		repo:         &sqlRecurringRuleRepository{db.DB, log.NewNopLogger()},
+		accounts:     accountRepo,
+		transactions: &transactionService{logger: log.NewNopLogger(), repo: ledger, events: &mockEventPublisher{}},
+		now:          time.Now,
+	}
+	router := mux.NewRouter()
+	addRecurringRuleRoutes(log.NewNopLogger(), router, svc)
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", "test")
+		req.Header.Set("X-Customer-ID", "customer")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	start := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	w := serve("POST", "/accounts/alice/recurring", fmt.Sprintf(`{"counterpartyAccountId": "fees", "amount": 500, "direction": "debit", "purpose": "fee",
+"description": "Monthly fee", "schedule": "FREQ=MONTHLY;COUNT=3", "start": %q}`, start.Format(time.RFC3339)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var fee recurringRule
+	if err := json.NewDecoder(w.Body).Decode(&fee); err != nil || fee.Status != recurringActive || fee.NextRunAt == nil || !fee.NextRunAt.Equal(start) {
+		t.Fatalf("rule=%#v error=%v", fee, err)
+	}
+	w = serve("POST", "/accounts/alice/recurring", fmt.Sprintf(`{"counterpartyAccountId": "program", "amount": 25, "direction": "credit", "purpose": "interest",
+"schedule": "FREQ=WEEKLY", "start": %q}`, start.Format(time.RFC3339)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var interest recurringRule
+	if err := json.NewDecoder(w.Body).Decode(&interest); err != nil {
+		t.Fatal(err)
+	}
+
+	for body, expected := range map[string]string{
+		`{"counterpartyAccountId": "alice", "amount": 1, "direction": "debit", "purpose": "fee", "schedule": "FREQ=DAILY"}`:     "counterpartyAccountId",
+		`{"counterpartyAccountId": "fees", "amount": 0, "direction": "debit", "purpose": "fee", "schedule": "FREQ=DAILY"}`:      "invalid amount",
+		`{"counterpartyAccountId": "fees", "amount": 1, "direction": "up", "purpose": "fee", "schedule": "FREQ=DAILY"}`:         "invalid direction",
+		`{"counterpartyAccountId": "fees", "amount": 1, "direction": "debit", "purpose": "achdebit", "schedule": "FREQ=DAILY"}`: "achdebit",
+		`{"counterpartyAccountId": "fees", "amount": 1, "direction": "debit", "purpose": "fee", "schedule": "FREQ=HOURLY"}`:     "invalid schedule",
+		`{"counterpartyAccountId": "missing", "amount": 1, "direction": "debit", "purpose": "fee", "schedule": "FREQ=DAILY"}`:   "not found",
+	} {
+		if w := serve("POST", "/accounts/alice/recurring", body); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("%s: bogus HTTP status %d: %s", expected, w.Code, w.Body.String())
+		}
+	}
+
+	// occurrences are posted once they're due, catching up on any which were missed
+	if posted, err := svc.runDue(context.Background(), start.Add(-time.Minute)); err != nil || posted != 0 {
+		t.Errorf("posted=%d error=%v", posted, err)
+	}
+	if posted, err := svc.runDue(context.Background(), start); err != nil || posted != 2 {
+		t.Errorf("posted=%d error=%v", posted, err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 1525, "fees": 500, "program": 9975})
+	if posted, err := svc.runDue(context.Background(), start.AddDate(0, 1, 0)); err != nil || posted != 5 {
+		t.Errorf("posted=%d error=%v", posted, err)
+	}
+	w = serve("GET", "/accounts/alice/recurring/"+fee.ID, "")
+	if err := json.NewDecoder(w.Body).Decode(&fee); err != nil || fee.Occurrences != 2 || fee.LastTransactionID == "" || fee.LastError != "" || fee.Status != recurringActive {
+		t.Errorf("rule=%#v error=%v", fee, err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 1125, "fees": 1000, "program": 9875})
+
+	// running again doesn't post occurrences twice
+	if posted, err := svc.runDue(context.Background(), start.AddDate(0, 1, 0)); err != nil || posted != 0 {
+		t.Errorf("posted=%d error=%v", posted, err)
+	}
+
+	// failed occurrences are skipped and the rule completes after its count
+	if w := serve("DELETE", "/accounts/alice/recurring/"+interest.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	tx := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+		{AccountID: "alice", Purpose: ACHDebit, Amount: 200},
+		{AccountID: "program", Purpose: ACHCredit, Amount: 200},
+	}}
+	if err := ledger.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if posted, err := svc.runDue(context.Background(), start.AddDate(0, 3, 0)); err != nil || posted != 0 {
+		t.Errorf("posted=%d error=%v", posted, err)
+	}
+	w = serve("GET", "/accounts/alice/recurring", "")
+	var rules []recurringRule
+	if err := json.NewDecoder(w.Body).Decode(&rules); err != nil || len(rules) != 1 {
+		t.Fatalf("rules=%#v error=%v", rules, err)
+	}
+	if r := rules[0]; r.Status != recurringCompleted || r.Occurrences != 3 || r.NextRunAt != nil || !strings.Contains(r.LastError, "insufficient funds") {
+		t.Errorf("unexpected rule: %#v", r)
+	}
+
+	for path, code := range map[string]int{"/accounts/missing/recurring": http.StatusNotFound, "/accounts/alice/recurring/" + interest.ID: http.StatusNotFound} {
+		if w := serve("GET", path, ""); w.Code != code {
+			t.Errorf("%s: bogus HTTP status: %d", path, w.Code)
+		}
+	}
+}
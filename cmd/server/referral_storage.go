@@ -0,0 +1,132 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+type referralRepository interface {
+	Ping() error
+	Close() error
+
+	// createReferral returns errCustomerAlreadyReferred when the referee's customer has a referral.
+	createReferral(ref *referral) error
+
+	// updateReferral saves a referral's status and the promotions paid for it.
+	updateReferral(ref *referral) error
+
+	// getReferral returns nil if the referral doesn't exist.
+	getReferral(referralID string) (*referral, error)
+
+	// getReferrals returns the referrals the customer (or every customer when empty) made or received with the
+	// status (or every status when empty), oldest first.
+	getReferrals(customerID string, status referralStatus) ([]*referral, error)
+}
+
+type sqlReferralRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlReferralRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlReferralRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlReferralRepository) createReferral(ref *referral) error {
+	query := `insert into referrals (referral_id, referrer_customer_id, referrer_account_id, referee_customer_id, referee_account_id, referrer_bonus, referee_bonus, min_deposit, min_transactions, status, created_by, created_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createReferral: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	_, err = stmt.Exec(ref.ID, ref.ReferrerCustomerID, ref.ReferrerAccountID, ref.RefereeCustomerID, ref.RefereeAccountID,
+		ref.Program.ReferrerBonus, ref.Program.RefereeBonus, ref.Program.MinDeposit, ref.Program.MinTransactions, ref.Status, ref.CreatedBy, ref.CreatedAt)
+	if err != nil {
+		if database.UniqueViolation(err) {
+			return errCustomerAlreadyReferred
+		}
+		return fmt.Errorf("createReferral: referral=%s: %v", ref.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlReferralRepository) updateReferral(ref *referral) error {
+	query := `update referrals set status = ?, referrer_promotion_id = ?, referee_promotion_id = ?, qualified_at = ? where referral_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("updateReferral: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(ref.Status, ref.ReferrerPromotionID, ref.RefereePromotionID, ref.QualifiedAt, ref.ID); err != nil {
+		return fmt.Errorf("updateReferral: referral=%s: %v", ref.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlReferralRepository) getReferral(referralID string) (*referral, error) {
+	referrals, err := r.queryReferrals(`referral_id = ?`, referralID)
+	if err != nil {
+		return nil, fmt.Errorf("getReferral: %v", err)
+	}
+	if len(referrals) == 0 {
+		return nil, nil
+	}
+	return referrals[0], nil
+}
+
+func (r *sqlReferralRepository) getReferrals(customerID string, status referralStatus) ([]*referral, error) {
+	where, args := `1 = 1`, []interface{}{}
+	if customerID != "" {
+		where, args = where+` and (referrer_customer_id = ? or referee_customer_id = ?)`, append(args, customerID, customerID)
+	}
+	if status != "" {
+		where, args = where+` and status = ?`, append(args, status)
+	}
+	referrals, err := r.queryReferrals(where+` order by created_at, referral_id`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("getReferrals: %v", err)
+	}
+	return referrals, nil
+}
+
+func (r *sqlReferralRepository) queryReferrals(where string, args ...interface{}) ([]*referral, error) {
+	query := fmt.Sprintf(`select referral_id, referrer_customer_id, referrer_account_id, referee_customer_id, referee_account_id, referrer_bonus, referee_bonus,
+min_deposit, min_transactions, status, referrer_promotion_id, referee_promotion_id, created_by, created_at, qualified_at from referrals where %s;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*referral
+	for rows.Next() {
+		var ref referral
+		var referrerPromotionID, refereePromotionID *string
+		if err := rows.Scan(&ref.ID, &ref.ReferrerCustomerID, &ref.ReferrerAccountID, &ref.RefereeCustomerID, &ref.RefereeAccountID, &ref.Program.ReferrerBonus, &ref.Program.RefereeBonus,
+			&ref.Program.MinDeposit, &ref.Program.MinTransactions, &ref.Status, &referrerPromotionID, &refereePromotionID, &ref.CreatedBy, &ref.CreatedAt, &ref.QualifiedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		if referrerPromotionID != nil {
+			ref.ReferrerPromotionID = *referrerPromotionID
+		}
+		if refereePromotionID != nil {
+			ref.RefereePromotionID = *refereePromotionID
+		}
+		out = append(out, &ref)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,393 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Referrals link a customer who referred another (the referrer) to the customer they referred (the referee). Once
+// the referee's account qualifies, by being funded and posting enough transactions, both accounts are paid a bonus
+// as promotions (see promotions.go). The program's terms are copied onto each referral when it's created, so
+// changing them doesn't change what earlier referrals pay.
+
+type referralStatus string
+
+const (
+	referralPending   referralStatus = "pending"
+	referralQualified referralStatus = "qualified"
+)
+
+var errCustomerAlreadyReferred = errors.New("customer was already referred")
+
+// referralProgram are the bonuses paid for a referral and what the referee's account needs to qualify for them.
+type referralProgram struct {
+	ReferrerBonus int `json:"referrerBonus"`
+	RefereeBonus  int `json:"refereeBonus"`
+
+	// MinDeposit is the balance the referee's account needs to count as funded
+	MinDeposit int `json:"minDeposit"`
+
+	// MinTransactions is how many posted transactions the referee's account needs
+	MinTransactions int `json:"minTransactions"`
+}
+
+// readReferralProgram reads the REFERRAL_* variables. It returns nil when neither bonus is set, which disables
+// referrals.
+func readReferralProgram() (*referralProgram, error) {
+	program := &referralProgram{MinDeposit: 1, MinTransactions: 1}
+	for key, dest := range map[string]*int{
+		"REFERRAL_REFERRER_BONUS":   &program.ReferrerBonus,
+		"REFERRAL_REFEREE_BONUS":    &program.RefereeBonus,
+		"REFERRAL_MIN_DEPOSIT":      &program.MinDeposit,
+		"REFERRAL_MIN_TRANSACTIONS": &program.MinTransactions,
+	} {
+		if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s %q", key, v)
+			}
+			*dest = n
+		}
+	}
+	if program.ReferrerBonus == 0 && program.RefereeBonus == 0 {
+		return nil, nil
+	}
+	return program, nil
+}
+
+type referral struct {
+	ID                 string `json:"id"`
+	ReferrerCustomerID string `json:"referrerCustomerId"`
+	ReferrerAccountID  string `json:"referrerAccountId"`
+	RefereeCustomerID  string `json:"refereeCustomerId"`
+	RefereeAccountID   string `json:"refereeAccountId"`
+
+	Program referralProgram `json:"program"`
+	Status  referralStatus  `json:"status"`
+
+	// ReferrerPromotionID and RefereePromotionID are the bonuses paid once the referral qualified
+	ReferrerPromotionID string `json:"referrerPromotionId,omitempty"`
+	RefereePromotionID  string `json:"refereePromotionId,omitempty"`
+
+	CreatedBy   string     `json:"createdBy"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	QualifiedAt *time.Time `json:"qualifiedAt,omitempty"`
+}
+
+type createReferralRequest struct {
+	ReferrerAccountID string `json:"referrerAccountId"`
+	RefereeAccountID  string `json:"refereeAccountId"`
+}
+
+// referralReport is what a referral program has cost. Bonuses which were later reversed aren't counted.
+type referralReport struct {
+	Referrals       int   `json:"referrals"`
+	Pending         int   `json:"pending"`
+	Qualified       int   `json:"qualified"`
+	ReferrerBonuses int64 `json:"referrerBonuses"`
+	RefereeBonuses  int64 `json:"refereeBonuses"`
+	Cost            int64 `json:"cost"`
+}
+
+type referralService struct {
+	logger       log.Logger
+	repo         referralRepository
+	accounts     accountRepository
+	transactions transactionRepository
+	promotions   *promotionService
+	program      *referralProgram
+}
+
+// CreateReferral records that the referrer's customer referred the referee's. Customers can only be referred once
+// and can't refer themselves.
+func (s *referralService) CreateReferral(ctx context.Context, userID string, req createReferralRequest) (*referral, error) {
+	if userID == "" {
+		return nil, errors.New("referrals must be created with an X-User-ID")
+	}
+	if req.ReferrerAccountID == "" || req.RefereeAccountID == "" {
+		return nil, errors.New("referrals require a referrerAccountId and refereeAccountId")
+	}
+	accts, err := s.accounts.GetAccounts(ctx, []string{req.ReferrerAccountID, req.RefereeAccountID})
+	if err != nil {
+		return nil, err
+	}
+	if len(accts) != 2 {
+		return nil, fmt.Errorf("referrer account=%s or referee account=%s: %v", req.ReferrerAccountID, req.RefereeAccountID, errAccountNotFound)
+	}
+	ref := &referral{
+		ID:                newID(),
+		ReferrerAccountID: req.ReferrerAccountID,
+		RefereeAccountID:  req.RefereeAccountID,
+		Program:           *s.program,
+		Status:            referralPending,
+		CreatedBy:         userID,
+		CreatedAt:         time.Now(),
+	}
+	for i := range accts {
+		if accts[i].ID == ref.ReferrerAccountID {
+			ref.ReferrerCustomerID = accts[i].CustomerID
+		} else {
+			ref.RefereeCustomerID = accts[i].CustomerID
+		}
+	}
+	if ref.ReferrerCustomerID == ref.RefereeCustomerID {
+		return nil, fmt.Errorf("customer=%s can't refer themselves", ref.RefereeCustomerID)
+	}
+	if err := s.repo.createReferral(ref); err != nil {
+		return nil, err
+	}
+	s.logger.Log("referrals", fmt.Sprintf("customer=%s referred customer=%s in referral=%s", ref.ReferrerCustomerID, ref.RefereeCustomerID, ref.ID), "userID", userID, "requestID", requestIDFrom(ctx))
+	return ref, nil
+}
+
+// qualifies returns true once the referee's account is funded and has posted enough transactions.
+func (s *referralService) qualifies(ctx context.Context, ref *referral) (bool, error) {
+	accts, err := s.accounts.GetAccounts(ctx, []string{ref.RefereeAccountID})
+	if err != nil {
+		return false, err
+	}
+	if len(accts) != 1 || accountClosed(accts[0]) || int(accts[0].Balance) < ref.Program.MinDeposit {
+		return false, nil
+	}
+	if ref.Program.MinTransactions == 0 {
+		return true, nil
+	}
+	transactions, _, err := s.transactions.getAccountTransactions(ctx, ref.RefereeAccountID, transactionPage{Limit: ref.Program.MinTransactions, Posted: true})
+	if err != nil {
+		return false, err
+	}
+	return len(transactions) >= ref.Program.MinTransactions, nil
+}
+
+// qualifyPending pays the bonuses of every pending referral which qualifies. Each bonus is saved on the referral as
+// it's paid, so a referral which fails part of the way is finished by a later run without paying twice.
+func (s *referralService) qualifyPending(ctx context.Context) (int, error) {
+	pending, err := s.repo.getReferrals("", referralPending)
+	if err != nil {
+		return 0, err
+	}
+	qualified := 0
+	var firstErr error
+	for _, ref := range pending {
+		if err := s.qualify(ctx, ref); err != nil {
+			s.logger.Log("referrals", fmt.Sprintf("problem qualifying referral=%s: %v", ref.ID, err))
+			if firstErr == nil {
+				firstErr = fmt.Errorf("referral=%s: %v", ref.ID, err)
+			}
+			continue
+		}
+		if ref.Status == referralQualified {
+			qualified++
+		}
+	}
+	return qualified, firstErr
+}
+
+func (s *referralService) qualify(ctx context.Context, ref *referral) error {
+	ok, err := s.qualifies(ctx, ref)
+	if err != nil || !ok {
+		return err
+	}
+	if ref.ReferrerPromotionID == "" && ref.Program.ReferrerBonus > 0 {
+		p, err := s.promotions.GrantPromotion(ctx, "referrals", grantPromotionRequest{
+			AccountID:   ref.ReferrerAccountID,
+			Amount:      ref.Program.ReferrerBonus,
+			Description: "Referral bonus",
+		})
+		if err != nil {
+			return fmt.Errorf("referrer bonus: %v", err)
+		}
+		ref.ReferrerPromotionID = p.ID
+		if err := s.repo.updateReferral(ref); err != nil {
+			return err
+		}
+	}
+	if ref.RefereePromotionID == "" && ref.Program.RefereeBonus > 0 {
+		p, err := s.promotions.GrantPromotion(ctx, "referrals", grantPromotionRequest{
+			AccountID:   ref.RefereeAccountID,
+			Amount:      ref.Program.RefereeBonus,
+			Description: "Referral bonus",
+		})
+		if err != nil {
+			return fmt.Errorf("referee bonus: %v", err)
+		}
+		ref.RefereePromotionID = p.ID
+		if err := s.repo.updateReferral(ref); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	ref.Status, ref.QualifiedAt = referralQualified, &now
+	if err := s.repo.updateReferral(ref); err != nil {
+		return err
+	}
+	s.logger.Log("referrals", fmt.Sprintf("referral=%s qualified", ref.ID), "referrerPromotionID", ref.ReferrerPromotionID, "refereePromotionID", ref.RefereePromotionID)
+	return nil
+}
+
+// report totals the bonuses of referrals created from start until end, when they're set.
+func (s *referralService) report(start, end time.Time) (*referralReport, error) {
+	referrals, err := s.repo.getReferrals("", "")
+	if err != nil {
+		return nil, err
+	}
+	bonus := func(promotionID string) (int64, error) {
+		if promotionID == "" {
+			return 0, nil
+		}
+		p, err := s.promotions.repo.getPromotion(promotionID)
+		if err != nil || p == nil || p.Status != promotionGranted {
+			return 0, err
+		}
+		return int64(p.Amount), nil
+	}
+	report := &referralReport{}
+	for _, ref := range referrals {
+		if (!start.IsZero() && ref.CreatedAt.Before(start)) || (!end.IsZero() && !ref.CreatedAt.Before(end)) {
+			continue
+		}
+		report.Referrals++
+		if ref.Status == referralPending {
+			report.Pending++
+		} else {
+			report.Qualified++
+		}
+		referrer, err := bonus(ref.ReferrerPromotionID)
+		if err != nil {
+			return nil, err
+		}
+		referee, err := bonus(ref.RefereePromotionID)
+		if err != nil {
+			return nil, err
+		}
+		report.ReferrerBonuses += referrer
+		report.RefereeBonuses += referee
+	}
+	report.Cost = report.ReferrerBonuses + report.RefereeBonuses
+	return report, nil
+}
+
+func setupReferralJob(ctx context.Context, logger log.Logger, svc *referralService, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if _, err := svc.qualifyPending(ctx); err != nil {
+					logger.Log("referrals", fmt.Sprintf("problem qualifying referrals: %v", err))
+				}
+			}
+		}
+	}()
+}
+
+// referrals is an admin route which lists referrals (GET), optionally of a ?customerId as either referrer or
+// referee or with a ?status, or creates one (POST).
+func referrals(logger log.Logger, svc *referralService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			q := r.URL.Query()
+			out, err := svc.repo.getReferrals(q.Get("customerId"), referralStatus(q.Get("status")))
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if out == nil {
+				out = []*referral{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(out)
+
+		case "POST":
+			var req createReferralRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			ref, err := svc.CreateReferral(requestContext(r), moovhttp.GetUserID(r), req)
+			if err != nil {
+				logger.Log("referrals", fmt.Sprintf("problem creating referral: %v", err), "requestID", moovhttp.GetRequestID(r))
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(ref)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// getReferral is an admin route which returns a referral.
+func getReferral(logger log.Logger, svc *referralService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		ref, err := svc.repo.getReferral(mux.Vars(r)["referralId"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if ref == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(ref)
+	}
+}
+
+// getReferralReport is an admin route which reports what referrals created between ?startDate and ?endDate cost.
+func getReferralReport(logger log.Logger, svc *referralService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var start, end time.Time
+		for param, dest := range map[string]*time.Time{"startDate": &start, "endDate": &end} {
+			if v := r.URL.Query().Get(param); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					moovhttp.Problem(w, fmt.Errorf("invalid %s %q", param, v))
+					return
+				}
+				*dest = t
+			}
+		}
+		report, err := svc.report(start, end)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(report)
+	}
+}
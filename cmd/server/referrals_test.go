@@ -0,0 +1,221 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/moov-io/base"
+)
+
+func TestReadReferralProgram(t *testing.T) {
+	if program, err := readReferralProgram(); err != nil || program != nil {
+		t.Errorf("program=%#v error=%v", program, err)
+	}
+
+	os.Setenv("REFERRAL_REFEREE_BONUS", "2500")
+	os.Setenv("REFERRAL_MIN_TRANSACTIONS", "3")
+	defer os.Unsetenv("REFERRAL_REFEREE_BONUS")
+	defer os.Unsetenv("REFERRAL_MIN_TRANSACTIONS")
+	program, err := readReferralProgram()
+	if err != nil || program == nil {
+		t.Fatalf("program=%#v error=%v", program, err)
+	}
+	if program.ReferrerBonus != 0 || program.RefereeBonus != 2500 || program.MinDeposit != 1 || program.MinTransactions != 3 {
+		t.Errorf("unexpected program: %#v", program)
+	}
+
+	os.Setenv("REFERRAL_MIN_DEPOSIT", "-1")
+	defer os.Unsetenv("REFERRAL_MIN_DEPOSIT")
+	if _, err := readReferralProgram(); err == nil || !strings.Contains(err.Error(), "REFERRAL_MIN_DEPOSIT") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReferralRepository(t *testing.T) {
+	check := func(t *testing.T, repo *sqlReferralRepository) {
+		now := time.Now().Truncate(time.Second)
+		program := referralProgram{ReferrerBonus: 5000, RefereeBonus: 2500, MinDeposit: 100, MinTransactions: 2}
+		first := &referral{ID: base.ID(), ReferrerCustomerID: "alice", ReferrerAccountID: "alice-checking", RefereeCustomerID: "bob", RefereeAccountID: "bob-checking",
+			Program: program, Status: referralPending, CreatedBy: "ops", CreatedAt: now.Add(-time.Hour)}
+		second := &referral{ID: base.ID(), ReferrerCustomerID: "bob", ReferrerAccountID: "bob-checking", RefereeCustomerID: "carol", RefereeAccountID: "carol-checking",
+			Program: program, Status: referralPending, CreatedBy: "ops", CreatedAt: now}
+		for _, ref := range []*referral{first, second} {
+			if err := repo.createReferral(ref); err != nil {
+				t.Fatal(err)
+			}
+		}
+		again := *second
+		again.ID = base.ID()
+		if err := repo.createReferral(&again); err != errCustomerAlreadyReferred {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		found, err := repo.getReferral(first.ID)
+		if err != nil || found == nil || found.Program != program || found.RefereeCustomerID != "bob" || !found.CreatedAt.Equal(first.CreatedAt) || found.QualifiedAt != nil {
+			t.Fatalf("referral=%#v error=%v", found, err)
+		}
+		if found, err := repo.getReferral("missing"); err != nil || found != nil {
+			t.Errorf("referral=%#v error=%v", found, err)
+		}
+		if referrals, err := repo.getReferrals("bob", ""); err != nil || len(referrals) != 2 || referrals[0].ID != first.ID {
+			t.Errorf("referrals=%#v error=%v", referrals, err)
+		}
+		if referrals, err := repo.getReferrals("carol", referralPending); err != nil || len(referrals) != 1 || referrals[0].ID != second.ID {
+			t.Errorf("referrals=%#v error=%v", referrals, err)
+		}
+
+		first.Status, first.ReferrerPromotionID, first.RefereePromotionID, first.QualifiedAt = referralQualified, base.ID(), base.ID(), &now
+		if err := repo.updateReferral(first); err != nil {
+			t.Fatal(err)
+		}
+		found, _ = repo.getReferral(first.ID)
+		if found.Status != referralQualified || found.ReferrerPromotionID != first.ReferrerPromotionID || found.RefereePromotionID != first.RefereePromotionID || found.QualifiedAt == nil {
+			t.Errorf("unexpected referral: %#v", found)
+		}
+		if referrals, err := repo.getReferrals("", referralPending); err != nil || len(referrals) != 1 || referrals[0].ID != second.ID {
+			t.Errorf("referrals=%#v error=%v", referrals, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlReferralRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlReferralRepository{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestReferrals(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"marketing": 0})
+	for id, customerID := range map[string]string{"alice": "alice", "bob": "bob", "bob-savings": "bob", "carol": "carol"} {
+		acct := &accounts.Account{ID: id, CustomerID: customerID, AccountNumber: id, RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+		if err := accountRepo.CreateAccount(customerID, acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	transactions := &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}}
+	promotions := &promotionService{
+		logger:           log.NewNopLogger(),
+		repo:             &sqlPromotionRepository{db.DB, log.NewNopLogger()},
+		accounts:         accountRepo,
+		transactions:     transactions,
+		fundingAccountID: "marketing",
+	}
+	svc := &referralService{
+		logger:       log.NewNopLogger(),
+		repo:         &sqlReferralRepository{db.DB, log.NewNopLogger()},
+		accounts:     accountRepo,
+		transactions: transactionRepo,
+		promotions:   promotions,
+		program:      &referralProgram{ReferrerBonus: 5000, RefereeBonus: 2500, MinDeposit: 1000, MinTransactions: 2},
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/referrals", referrals(log.NewNopLogger(), svc))
+	router.HandleFunc("/referrals/report", getReferralReport(log.NewNopLogger(), svc))
+	router.HandleFunc("/referrals/{referralId}", getReferral(log.NewNopLogger(), svc))
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", "ops")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+	deposit := func(accountID string, amount int) {
+		t.Helper()
+		tx := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{{AccountID: accountID, Purpose: ACHCredit, Amount: amount}}}
+		if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, body := range []string{
+		`{"referrerAccountId": "alice"}`,
+		`{"referrerAccountId": "alice", "refereeAccountId": "missing"}`,
+		`{"referrerAccountId": "bob", "refereeAccountId": "bob-savings"}`,
+	} {
+		if w := serve("POST", "/referrals", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: bogus HTTP status: %d", body, w.Code)
+		}
+	}
+	w := serve("POST", "/referrals", `{"referrerAccountId": "alice", "refereeAccountId": "bob"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var ref referral
+	if err := json.NewDecoder(w.Body).Decode(&ref); err != nil || ref.ReferrerCustomerID != "alice" || ref.RefereeCustomerID != "bob" || ref.Program.ReferrerBonus != 5000 || ref.Status != referralPending {
+		t.Fatalf("referral=%#v error=%v", ref, err)
+	}
+	if w := serve("POST", "/referrals", `{"referrerAccountId": "carol", "refereeAccountId": "bob-savings"}`); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "already referred") {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if w := serve("GET", "/referrals/"+ref.ID, ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("GET", "/referrals/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// bob's account qualifies once it's funded with enough transactions
+	deposit("bob", 600)
+	if n, err := svc.qualifyPending(context.Background()); err != nil || n != 0 {
+		t.Errorf("qualified=%d error=%v", n, err)
+	}
+	deposit("bob", 600)
+	if n, err := svc.qualifyPending(context.Background()); err != nil || n != 1 {
+		t.Errorf("qualified=%d error=%v", n, err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"marketing": -7500, "alice": 5000, "bob": 3700})
+	if n, err := svc.qualifyPending(context.Background()); err != nil || n != 0 {
+		t.Errorf("qualified=%d error=%v", n, err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"marketing": -7500})
+
+	w = serve("GET", "/referrals?customerId=alice&status=qualified", "")
+	var found []*referral
+	if err := json.NewDecoder(w.Body).Decode(&found); err != nil || len(found) != 1 || found[0].QualifiedAt == nil || found[0].ReferrerPromotionID == "" || found[0].RefereePromotionID == "" {
+		t.Fatalf("referrals=%#v error=%v", found, err)
+	}
+
+	// the report counts pending referrals and leaves out bonuses which were reversed
+	if w := serve("POST", "/referrals", `{"referrerAccountId": "bob", "refereeAccountId": "carol"}`); w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if _, err := promotions.ReversePromotion(context.Background(), found[0].RefereePromotionID, "ops", "fraud"); err != nil {
+		t.Fatal(err)
+	}
+	w = serve("GET", "/referrals/report", "")
+	var report referralReport
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if report.Referrals != 2 || report.Pending != 1 || report.Qualified != 1 || report.ReferrerBonuses != 5000 || report.RefereeBonuses != 0 || report.Cost != 5000 {
+		t.Errorf("unexpected report: %#v", report)
+	}
+	w = serve("GET", "/referrals/report?startDate="+time.Now().Add(time.Hour).Format(time.RFC3339), "")
+	if err := json.NewDecoder(w.Body).Decode(&report); err != nil || report.Referrals != 0 || report.Cost != 0 {
+		t.Errorf("report=%#v error=%v", report, err)
+	}
+	if w := serve("GET", "/referrals/report?startDate=yesterday", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
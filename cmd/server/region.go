@@ -0,0 +1,367 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+type regionRole string
+
+const (
+	regionActive  regionRole = "active"
+	regionPassive regionRole = "passive"
+)
+
+var errPassiveRegion = errors.New("region is passive, writes are only accepted by the active region")
+
+// readRegion returns this instance's region (REGION) and its role (REGION_ROLE). Regions are disabled when REGION
+// is empty, which is a single region deployment.
+func readRegion() (string, regionRole, error) {
+	name := strings.TrimSpace(os.Getenv("REGION"))
+	switch role := regionRole(strings.ToLower(or(os.Getenv("REGION_ROLE"), string(regionActive)))); role {
+	case regionActive, regionPassive:
+		if name == "" && role == regionPassive {
+			return "", "", errors.New("REGION_ROLE=passive requires REGION")
+		}
+		return name, role, nil
+	default:
+		return "", "", fmt.Errorf("unknown REGION_ROLE %q", role)
+	}
+}
+
+// regionHeartbeatInterval returns how often the active region writes heartbeats and passive regions read them,
+// from REGION_HEARTBEAT_INTERVAL.
+func regionHeartbeatInterval() time.Duration {
+	if v := os.Getenv("REGION_HEARTBEAT_INTERVAL"); v != "" {
+		if dur, err := time.ParseDuration(v); err == nil && dur > 0 {
+			return dur
+		}
+	}
+	return 5 * time.Second
+}
+
+// region is this instance's role in an active/passive deployment across regions. Only the active region accepts
+// writes. Passive regions serve reads from a replica of the ledger database, reporting how far behind it is, until
+// an operator promotes them after failing over the database.
+type region struct {
+	// epoch fences our postings, see region_fencing.go. It's read without holding mu by every posting.
+	epoch   int64
+	ledgers []fencedLedger
+
+	name   string
+	logger log.Logger
+	repo   regionRepository
+
+	mu         sync.RWMutex
+	role       regionRole
+	promotedAt *time.Time
+
+	// replicatedAt is the newest heartbeat read from another region, which is how current our replica is
+	replicatedAt *time.Time
+
+	now func() time.Time
+}
+
+func newRegion(logger log.Logger, name string, role regionRole, repo regionRepository) *region {
+	return &region{
+		name:   name,
+		logger: logger,
+		repo:   repo,
+		role:   role,
+		now:    time.Now,
+	}
+}
+
+func (g *region) active() bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.role == regionActive
+}
+
+// run writes or reads a heartbeat every interval until ctx is cancelled.
+func (g *region) run(ctx context.Context, interval time.Duration) {
+	g.tick()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			g.tick()
+		}
+	}
+}
+
+// tick writes a heartbeat when we're active and reads the latest one replicated from the active region otherwise.
+func (g *region) tick() {
+	if g.active() {
+		if err := g.repo.writeHeartbeat(g.name, g.now()); err != nil {
+			g.logger.Log("region", fmt.Sprintf("problem writing heartbeat: %v", err))
+		}
+		g.checkFenced()
+		return
+	}
+	at, err := g.repo.latestHeartbeat(g.name)
+	if err != nil {
+		g.logger.Log("region", fmt.Sprintf("problem reading heartbeat: %v", err))
+		return
+	}
+	g.mu.Lock()
+	g.replicatedAt = at
+	g.mu.Unlock()
+}
+
+// replicationLag returns how far behind the active region our replica is. It's measured from the last heartbeat we
+// read, so it can overstate the lag by up to one heartbeat interval but never understates it.
+func (g *region) replicationLag() (time.Duration, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.replicatedAt == nil {
+		return 0, errors.New("no heartbeat has been replicated from the active region")
+	}
+	lag := g.now().Sub(*g.replicatedAt)
+	if lag < 0 {
+		lag = 0
+	}
+	return lag, nil
+}
+
+// regionStatus is returned from the admin routes.
+type regionStatus struct {
+	Region string     `json:"region"`
+	Role   regionRole `json:"role"`
+
+	// ReplicatedAt is the newest heartbeat replicated from the active region. After a failover, writes made on the
+	// old active region after this time may not have been replicated.
+	ReplicatedAt *time.Time `json:"replicatedAt,omitempty"`
+
+	// ReplicationLag is how many seconds behind the active region our replica is, while we're passive.
+	ReplicationLag *float64 `json:"replicationLag,omitempty"`
+
+	PromotedAt *time.Time `json:"promotedAt,omitempty"`
+
+	// Epoch fences postings from regions which were failed over from.
+	Epoch int64 `json:"epoch,omitempty"`
+}
+
+func (g *region) status() *regionStatus {
+	lag, err := g.replicationLag()
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	out := &regionStatus{
+		Region:       g.name,
+		Role:         g.role,
+		ReplicatedAt: g.replicatedAt,
+		PromotedAt:   g.promotedAt,
+		Epoch:        g.currentEpoch(),
+	}
+	if g.role == regionPassive && err == nil {
+		secs := lag.Seconds()
+		out.ReplicationLag = &secs
+	}
+	return out
+}
+
+// promote makes a passive region active. The ledger database must already be promoted from a replica, which is
+// checked by writing a heartbeat, as the service can't do that itself. We then claim a newer epoch than any region
+// has held, which fences postings from the region we're taking over from.
+func (g *region) promote(ctx context.Context, userID string) (*regionStatus, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.role == regionActive {
+		return nil, fmt.Errorf("region=%s is already active", g.name)
+	}
+	now := g.now()
+	if err := g.repo.writeHeartbeat(g.name, now); err != nil {
+		return nil, fmt.Errorf("region=%s can't write to the ledger database, promote the database replica first: %v", g.name, err)
+	}
+	if len(g.ledgers) > 0 {
+		epoch, _, err := g.latestEpoch()
+		if err != nil {
+			return nil, fmt.Errorf("region=%s: %v", g.name, err)
+		}
+		if err := g.claimEpoch(epoch + 1); err != nil {
+			return nil, fmt.Errorf("region=%s: %v", g.name, err)
+		}
+	}
+	g.role = regionActive
+	g.promotedAt = &now
+
+	replicatedAt := "never"
+	if g.replicatedAt != nil {
+		replicatedAt = g.replicatedAt.Format(time.RFC3339)
+	}
+	g.logger.Log(
+		"audit", fmt.Sprintf("promoted region=%s to active at epoch %d, last replicated at %s", g.name, g.currentEpoch(), replicatedAt),
+		"requestID", requestIDFrom(ctx),
+		"userID", userID,
+	)
+	return &regionStatus{Region: g.name, Role: g.role, ReplicatedAt: g.replicatedAt, PromotedAt: g.promotedAt, Epoch: g.currentEpoch()}, nil
+}
+
+// regionGuard rejects writes to a passive region with a 503 and adds X-Replication-Lag (in whole seconds, rounded
+// up) to its reads. Callers which can't use data older than X-Max-Staleness seconds get a 503 instead, so they can
+// retry against the active region.
+type regionGuard struct {
+	logger log.Logger
+	region *region
+	next   http.Handler
+}
+
+func newRegionGuard(logger log.Logger, g *region, next http.Handler) http.Handler {
+	if g == nil {
+		return next
+	}
+	return &regionGuard{logger: logger, region: g, next: next}
+}
+
+func (rg *regionGuard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Region", rg.region.name)
+	if rg.region.active() {
+		w.Header().Set("X-Region-Role", string(regionActive))
+		rg.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("X-Region-Role", string(regionPassive))
+
+	if routeGroup(r) == routeGroupPostings {
+		rg.unavailable(w, fmt.Sprintf("region=%s is passive, send writes to the active region", rg.region.name))
+		return
+	}
+
+	lag, err := rg.region.replicationLag()
+	if err == nil {
+		w.Header().Set("X-Replication-Lag", strconv.FormatInt(int64(math.Ceil(lag.Seconds())), 10))
+	}
+	if v := r.Header.Get("X-Max-Staleness"); v != "" {
+		max, perr := strconv.Atoi(v)
+		if perr != nil || max < 0 {
+			moovhttp.Problem(w, fmt.Errorf("invalid X-Max-Staleness %q", v))
+			return
+		}
+		if err != nil {
+			rg.unavailable(w, fmt.Sprintf("region=%s replication lag is unknown: %v", rg.region.name, err))
+			return
+		}
+		if lag > time.Duration(max)*time.Second {
+			rg.unavailable(w, fmt.Sprintf("region=%s is %v behind, more than X-Max-Staleness", rg.region.name, lag.Round(time.Second)))
+			return
+		}
+	}
+	rg.next.ServeHTTP(w, r)
+}
+
+func (rg *regionGuard) unavailable(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// regionTransactionRepository rejects postings while our region is passive, including those from background jobs
+// and the admin port which don't pass through regionGuard. Once storage rejects a posting from a stale epoch we're
+// demoted to passive.
+type regionTransactionRepository struct {
+	transactionRepository
+	region *region
+}
+
+func (r *regionTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	if !r.region.active() {
+		return errPassiveRegion
+	}
+	return r.fenced(r.transactionRepository.createTransaction(ctx, tx, opts))
+}
+
+func (r *regionTransactionRepository) updateTransactionStatus(transactionID string, status TransactionStatus) error {
+	if !r.region.active() {
+		return errPassiveRegion
+	}
+	return r.fenced(r.transactionRepository.updateTransactionStatus(transactionID, status))
+}
+
+func (r *regionTransactionRepository) compactTransactionLines(before time.Time) (*compactionResult, error) {
+	if !r.region.active() {
+		return nil, errPassiveRegion
+	}
+	return r.transactionRepository.compactTransactionLines(before)
+}
+
+func (r *regionTransactionRepository) fenced(err error) error {
+	if isStaleEpoch(err) {
+		r.region.demote(err.Error())
+	}
+	return err
+}
+
+// regionAccountRepository rejects creating and updating accounts while our region is passive.
+type regionAccountRepository struct {
+	accountRepository
+	region *region
+}
+
+func (r *regionAccountRepository) CreateAccount(customerID string, account *accounts.Account) error {
+	if !r.region.active() {
+		return errPassiveRegion
+	}
+	return r.accountRepository.CreateAccount(customerID, account)
+}
+
+func (r *regionAccountRepository) UpdateAccount(account *accounts.Account) error {
+	if !r.region.active() {
+		return errPassiveRegion
+	}
+	return r.accountRepository.UpdateAccount(account)
+}
+
+// getRegion is an admin route which returns our region's role and replication lag.
+func getRegion(logger log.Logger, g *region) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(g.status())
+	}
+}
+
+// promoteRegion is an admin route which makes a passive region active once its database has been promoted.
+func promoteRegion(logger log.Logger, g *region) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		status, err := g.promote(requestContext(r), moovhttp.GetUserID(r))
+		if err != nil {
+			logger.Log("region", fmt.Sprintf("problem promoting region: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(status)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+)
+
+// Postings are fenced with an epoch so a region which was failed over from can't keep writing to the ledger, for
+// example when it was only cut off from the other region and still reaches the promoted database. Each promotion
+// takes a higher epoch than any before it and stores it in every ledger database. Postings check the stored epoch
+// inside their own database transaction and are rejected when it's newer than the epoch their region holds.
+
+var errStaleEpoch = errors.New("stale epoch, another region has been promoted")
+
+// ledgerEpochID is the single row of ledger_epochs.
+const ledgerEpochID = "ledger"
+
+// fencedLedger is storage which rejects postings made with an older epoch than the one it holds.
+type fencedLedger interface {
+	// readEpoch returns the ledger's epoch and the region which claimed it, or zero if none has.
+	readEpoch() (int64, string, error)
+
+	// claimEpoch stores epoch for region, failing with errStaleEpoch if the ledger holds a newer one.
+	claimEpoch(region string, epoch int64) error
+}
+
+func (r *sqlTransactionRepository) readEpoch() (int64, string, error) {
+	var epoch int64
+	var region string
+	err := r.postingDB.QueryRow(`select epoch, region from ledger_epochs where ledger = ?;`, ledgerEpochID).Scan(&epoch, &region)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, "", fmt.Errorf("readEpoch: %v", err)
+	}
+	return epoch, region, nil
+}
+
+func (r *sqlTransactionRepository) claimEpoch(region string, epoch int64) error {
+	query := `update ledger_epochs set epoch = ?, region = ?, updated_at = ? where ledger = ? and epoch <= ?;`
+	res, err := r.postingDB.Exec(query, epoch, region, time.Now(), ledgerEpochID, epoch)
+	if err != nil {
+		return fmt.Errorf("claimEpoch: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	query = `insert into ledger_epochs (ledger, epoch, region, updated_at) values (?, ?, ?, ?);`
+	if _, err := r.postingDB.Exec(query, ledgerEpochID, epoch, region, time.Now()); err != nil {
+		if database.UniqueViolation(err) {
+			current, holder, _ := r.readEpoch()
+			return fmt.Errorf("claimEpoch: region=%s epoch %d: %v (region=%s holds epoch %d)", region, epoch, errStaleEpoch, holder, current)
+		}
+		return fmt.Errorf("claimEpoch: %v", err)
+	}
+	return nil
+}
+
+// checkEpoch rejects a posting inside tx when the ledger holds a newer epoch than ours. On MySQL the epoch stays
+// share locked until tx ends, so a promotion waits for postings already checked to finish.
+func (r *sqlTransactionRepository) checkEpoch(tx *sql.Tx) error {
+	if r.epoch == nil {
+		return nil
+	}
+	ours := r.epoch()
+	var epoch int64
+	var region string
+	query := fmt.Sprintf(`select epoch, region from ledger_epochs where ledger = ?%s;`, database.ForShare(r.postingDB))
+	if err := tx.QueryRow(query, ledgerEpochID).Scan(&epoch, &region); err != nil {
+		if err == sql.ErrNoRows {
+			return nil // no region has claimed the ledger
+		}
+		return fmt.Errorf("checkEpoch: %v", err)
+	}
+	if epoch > ours {
+		return fmt.Errorf("epoch %d: %v (region=%s holds epoch %d)", ours, errStaleEpoch, region, epoch)
+	}
+	return nil
+}
+
+func isStaleEpoch(err error) bool {
+	return err != nil && strings.Contains(err.Error(), errStaleEpoch.Error())
+}
+
+func (g *region) currentEpoch() int64 {
+	return atomic.LoadInt64(&g.epoch)
+}
+
+// fence has postings to ledgers check our epoch.
+func (g *region) fence(ledgers []*sqlTransactionRepository) {
+	for i := range ledgers {
+		ledgers[i].epoch = g.currentEpoch
+		g.ledgers = append(g.ledgers, ledgers[i])
+	}
+}
+
+// latestEpoch returns the newest epoch held by any of our ledgers and the region which claimed it.
+func (g *region) latestEpoch() (int64, string, error) {
+	var latest int64
+	var holder string
+	for i := range g.ledgers {
+		epoch, region, err := g.ledgers[i].readEpoch()
+		if err != nil {
+			return 0, "", err
+		}
+		if epoch > latest {
+			latest, holder = epoch, region
+		}
+	}
+	return latest, holder, nil
+}
+
+// claimEpoch stores epoch in every ledger before we use it.
+func (g *region) claimEpoch(epoch int64) error {
+	for i := range g.ledgers {
+		if err := g.ledgers[i].claimEpoch(g.name, epoch); err != nil {
+			return err
+		}
+	}
+	atomic.StoreInt64(&g.epoch, epoch)
+	return nil
+}
+
+// start claims an epoch when we start as the active region. Restarting keeps the epoch we held, but if another
+// region was promoted since then we start passive rather than take the ledger back from it.
+func (g *region) start() error {
+	if !g.active() || len(g.ledgers) == 0 {
+		return nil
+	}
+	epoch, holder, err := g.latestEpoch()
+	if err != nil {
+		return fmt.Errorf("region=%s: %v", g.name, err)
+	}
+	switch {
+	case epoch == 0:
+		epoch = 1
+	case holder != g.name:
+		g.demote(fmt.Sprintf("region=%s holds epoch %d", holder, epoch))
+		return nil
+	}
+	if err := g.claimEpoch(epoch); err != nil {
+		return fmt.Errorf("region=%s: %v", g.name, err)
+	}
+	return nil
+}
+
+// checkFenced demotes an active region once another region has claimed a newer epoch.
+func (g *region) checkFenced() {
+	if !g.active() {
+		return
+	}
+	epoch, holder, err := g.latestEpoch()
+	if err != nil {
+		g.logger.Log("region", fmt.Sprintf("problem reading epoch: %v", err))
+		return
+	}
+	if epoch > g.currentEpoch() {
+		g.demote(fmt.Sprintf("region=%s holds epoch %d", holder, epoch))
+	}
+}
+
+// demote makes us passive after we've been fenced, so writes are rejected before they reach storage.
+func (g *region) demote(reason string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.role == regionPassive {
+		return
+	}
+	g.role = regionPassive
+	g.logger.Log("audit", fmt.Sprintf("fenced region=%s at epoch %d, now passive: %s", g.name, g.currentEpoch(), reason))
+}
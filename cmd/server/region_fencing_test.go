@@ -0,0 +1,176 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSqlTransactionRepository__epochs(t *testing.T) {
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		t.Helper()
+		defer repo.Close()
+
+		if epoch, region, err := repo.readEpoch(); err != nil || epoch != 0 || region != "" {
+			t.Errorf("epoch=%d region=%q error=%v", epoch, region, err)
+		}
+		if err := repo.claimEpoch("us-east", 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.claimEpoch("us-east", 1); err != nil {
+			t.Fatal(err) // restarting keeps the epoch
+		}
+		if err := repo.claimEpoch("us-west", 2); err != nil {
+			t.Fatal(err)
+		}
+		if epoch, region, err := repo.readEpoch(); err != nil || epoch != 2 || region != "us-west" {
+			t.Errorf("epoch=%d region=%q error=%v", epoch, region, err)
+		}
+		if err := repo.claimEpoch("us-east", 1); !isStaleEpoch(err) {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		// postings from the old epoch are rejected
+		account1, account2 := base.ID(), base.ID()
+		repo.accountRepo = &testAccountRepository{
+			accounts: []*accounts.Account{
+				{ID: account1, AccountNumber: "123", RoutingNumber: defaultRoutingNumber},
+				{ID: account2, AccountNumber: "432", RoutingNumber: "121042882"},
+			},
+		}
+		tx := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Status:    TransactionPending,
+			Lines: []transactionLine{
+				{AccountID: account1, Purpose: ACHDebit, Amount: 500},
+				{AccountID: account2, Purpose: ACHCredit, Amount: 500},
+			},
+		}
+		var epoch int64 = 1
+		repo.epoch = func() int64 { return epoch }
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); !isStaleEpoch(err) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if found, _ := repo.getTransaction(context.Background(), tx.ID); found != nil {
+			t.Errorf("transaction=%#v was written", found)
+		}
+
+		epoch = 2
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Fatal(err)
+		}
+		epoch = 1
+		if err := repo.updateTransactionStatus(tx.ID, TransactionVoided); !isStaleEpoch(err) {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if found, err := repo.getTransaction(context.Background(), tx.ID); err != nil || found == nil || found.Status != TransactionPending {
+			t.Errorf("transaction=%#v error=%v", found, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestRegion__fencing(t *testing.T) {
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	ledger := createTestSqlTransactionRepository(t, sqliteDB.DB)
+
+	// the active region claims the first epoch and keeps it across restarts
+	var buf bytes.Buffer
+	east := newRegion(log.NewLogfmtLogger(&buf), "us-east", regionActive, &testRegionRepository{})
+	east.fence([]*sqlTransactionRepository{ledger})
+	if err := east.start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := east.start(); err != nil || east.currentEpoch() != 1 || !east.active() {
+		t.Fatalf("epoch=%d error=%v", east.currentEpoch(), err)
+	}
+
+	// promoting another region fences the old one
+	west := newRegion(log.NewLogfmtLogger(&buf), "us-west", regionPassive, &testRegionRepository{})
+	west.fence([]*sqlTransactionRepository{ledger})
+	status, err := west.promote(context.Background(), "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Epoch != 2 || west.currentEpoch() != 2 {
+		t.Errorf("unexpected status: %#v", status)
+	}
+	if ledger.epoch() != 2 {
+		t.Errorf("ledger checks epoch %d", ledger.epoch())
+	}
+
+	// which is demoted as soon as it notices
+	east.tick()
+	if east.active() || east.currentEpoch() != 1 {
+		t.Errorf("active=%v epoch=%d", east.active(), east.currentEpoch())
+	}
+	if !strings.Contains(buf.String(), "fenced region=us-east at epoch 1") {
+		t.Errorf("unexpected audit log: %s", buf.String())
+	}
+
+	// and restarts passive, even if it's still configured as active
+	east = newRegion(log.NewNopLogger(), "us-east", regionActive, &testRegionRepository{})
+	east.fence([]*sqlTransactionRepository{createTestSqlTransactionRepository(t, sqliteDB.DB)})
+	if err := east.start(); err != nil || east.active() {
+		t.Errorf("active=%v error=%v", east.active(), err)
+	}
+}
+
+func TestRegion__fencedPostings(t *testing.T) {
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	ledger := createTestSqlTransactionRepository(t, sqliteDB.DB)
+	ledger.accountRepo = &testAccountRepository{
+		accounts: []*accounts.Account{{ID: "source", RoutingNumber: defaultRoutingNumber}, {ID: "dest", RoutingNumber: defaultRoutingNumber}},
+	}
+
+	east := newRegion(log.NewNopLogger(), "us-east", regionActive, &testRegionRepository{})
+	east.fence([]*sqlTransactionRepository{ledger})
+	if err := east.start(); err != nil {
+		t.Fatal(err)
+	}
+	// another region takes over before east notices
+	if err := ledger.claimEpoch("us-west", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := &regionTransactionRepository{ledger, east}
+	tx := transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now(),
+		Lines: []transactionLine{
+			{AccountID: "source", Purpose: ACHDebit, Amount: 100},
+			{AccountID: "dest", Purpose: ACHCredit, Amount: 100},
+		},
+	}
+	if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); !isStaleEpoch(err) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if east.active() {
+		t.Error("expected fenced region to be demoted")
+	}
+	if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != errPassiveRegion {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
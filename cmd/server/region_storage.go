@@ -0,0 +1,61 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// regionRepository stores heartbeats in the ledger database. The active region writes them and passive regions read
+// them back from their replica, so the age of the newest heartbeat is how far replication is behind.
+type regionRepository interface {
+	Ping() error
+	Close() error
+
+	writeHeartbeat(region string, at time.Time) error
+
+	// latestHeartbeat returns the newest heartbeat written by a region other than ours, or nil if there isn't one.
+	latestHeartbeat(ourRegion string) (*time.Time, error)
+}
+
+type sqlRegionRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlRegionRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlRegionRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlRegionRepository) writeHeartbeat(region string, at time.Time) error {
+	if _, err := r.db.Exec(`replace into region_heartbeats (region, heartbeat_at) values (?, ?);`, region, at); err != nil {
+		return fmt.Errorf("writeHeartbeat: region=%s: %v", region, err)
+	}
+	return nil
+}
+
+func (r *sqlRegionRepository) latestHeartbeat(ourRegion string) (*time.Time, error) {
+	rows, err := r.db.Query(`select heartbeat_at from region_heartbeats where region <> ? order by heartbeat_at desc limit 1;`, ourRegion)
+	if err != nil {
+		return nil, fmt.Errorf("latestHeartbeat: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var at time.Time
+		if err := rows.Scan(&at); err != nil {
+			return nil, fmt.Errorf("latestHeartbeat: scan: %v", err)
+		}
+		return &at, nil
+	}
+	return nil, rows.Err()
+}
@@ -0,0 +1,267 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+type testRegionRepository struct {
+	heartbeats map[string]time.Time
+	err        error
+}
+
+func (r *testRegionRepository) Ping() error  { return r.err }
+func (r *testRegionRepository) Close() error { return nil }
+
+func (r *testRegionRepository) writeHeartbeat(region string, at time.Time) error {
+	if r.err != nil {
+		return r.err
+	}
+	if r.heartbeats == nil {
+		r.heartbeats = make(map[string]time.Time)
+	}
+	r.heartbeats[region] = at
+	return nil
+}
+
+func (r *testRegionRepository) latestHeartbeat(ourRegion string) (*time.Time, error) {
+	var latest *time.Time
+	for region, at := range r.heartbeats {
+		if region != ourRegion && (latest == nil || at.After(*latest)) {
+			at := at
+			latest = &at
+		}
+	}
+	return latest, r.err
+}
+
+func TestRegion__readRegion(t *testing.T) {
+	if name, role, err := readRegion(); err != nil || name != "" || role != regionActive {
+		t.Errorf("name=%q role=%q error=%v", name, role, err)
+	}
+
+	os.Setenv("REGION", "us-west")
+	os.Setenv("REGION_ROLE", "Passive")
+	defer os.Unsetenv("REGION")
+	defer os.Unsetenv("REGION_ROLE")
+	if name, role, err := readRegion(); err != nil || name != "us-west" || role != regionPassive {
+		t.Errorf("name=%q role=%q error=%v", name, role, err)
+	}
+
+	os.Setenv("REGION_ROLE", "standby")
+	if _, _, err := readRegion(); err == nil || !strings.Contains(err.Error(), "REGION_ROLE") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	os.Setenv("REGION", "")
+	os.Setenv("REGION_ROLE", "passive")
+	if _, _, err := readRegion(); err == nil || !strings.Contains(err.Error(), "requires REGION") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRegion__heartbeats(t *testing.T) {
+	now := time.Now()
+	repo := &testRegionRepository{}
+	east := newRegion(log.NewNopLogger(), "us-east", regionActive, repo)
+	east.now = func() time.Time { return now.Add(-10 * time.Second) }
+	east.tick()
+
+	west := newRegion(log.NewNopLogger(), "us-west", regionPassive, repo)
+	west.now = func() time.Time { return now }
+	if _, err := west.replicationLag(); err == nil {
+		t.Error("expected unknown lag before reading a heartbeat")
+	}
+	west.tick()
+	if lag, err := west.replicationLag(); err != nil || lag != 10*time.Second {
+		t.Errorf("lag=%v error=%v", lag, err)
+	}
+	if _, ok := repo.heartbeats["us-west"]; ok {
+		t.Error("passive region wrote a heartbeat")
+	}
+
+	status := west.status()
+	if status.Role != regionPassive || status.ReplicationLag == nil || *status.ReplicationLag != 10 || status.ReplicatedAt == nil {
+		t.Errorf("unexpected status: %#v", status)
+	}
+}
+
+func TestRegion__promote(t *testing.T) {
+	repo := &testRegionRepository{err: errors.New("read-only replica")}
+	var buf bytes.Buffer
+	west := newRegion(log.NewLogfmtLogger(&buf), "us-west", regionPassive, repo)
+
+	// the database must be promoted first
+	if _, err := west.promote(context.Background(), "ops"); err == nil || !strings.Contains(err.Error(), "promote the database replica first") {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if west.active() {
+		t.Fatal("region promoted without a writable database")
+	}
+
+	repo.err = nil
+	status, err := west.promote(context.Background(), "ops")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !west.active() || status.Role != regionActive || status.PromotedAt == nil {
+		t.Errorf("unexpected status: %#v", status)
+	}
+	if _, ok := repo.heartbeats["us-west"]; !ok {
+		t.Error("expected heartbeat from promoted region")
+	}
+	if !strings.Contains(buf.String(), "promoted region=us-west to active") || !strings.Contains(buf.String(), "userID=ops") {
+		t.Errorf("unexpected audit log: %s", buf.String())
+	}
+
+	if _, err := west.promote(context.Background(), "ops"); err == nil || !strings.Contains(err.Error(), "already active") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestRegion__guard(t *testing.T) {
+	now := time.Now()
+	repo := &testRegionRepository{heartbeats: map[string]time.Time{"us-east": now.Add(-4500 * time.Millisecond)}}
+	west := newRegion(log.NewNopLogger(), "us-west", regionPassive, repo)
+	west.now = func() time.Time { return now }
+
+	handler := newRegionGuard(log.NewNopLogger(), west, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	call := func(method, path, maxStaleness string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if maxStaleness != "" {
+			req.Header.Set("X-Max-Staleness", maxStaleness)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// unknown lag
+	if w := call("GET", "/accounts/search", "60"); w.Code != http.StatusServiceUnavailable || w.Header().Get("X-Replication-Lag") != "" {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	west.tick()
+
+	// writes are rejected
+	w := call("POST", "/accounts/transactions", "")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("X-Region-Role") != "passive" || !strings.Contains(w.Body.String(), "send writes to the active region") {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	// reads report their staleness
+	w = call("GET", "/accounts/foo/transactions", "")
+	if w.Code != http.StatusOK || w.Header().Get("X-Replication-Lag") != "5" || w.Header().Get("X-Region") != "us-west" {
+		t.Errorf("got %d: %#v", w.Code, w.Header())
+	}
+	if w := call("GET", "/accounts/search", "10"); w.Code != http.StatusOK {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("GET", "/accounts/search", "2"); w.Code != http.StatusServiceUnavailable || !strings.Contains(w.Body.String(), "X-Max-Staleness") {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	if w := call("GET", "/accounts/search", "soon"); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	// everything passes once we're promoted
+	if _, err := west.promote(context.Background(), "ops"); err != nil {
+		t.Fatal(err)
+	}
+	if w := call("POST", "/accounts/transactions", ""); w.Code != http.StatusOK || w.Header().Get("X-Region-Role") != "active" {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+
+	if h := newRegionGuard(log.NewNopLogger(), nil, handler); h != handler {
+		t.Error("expected handler to be unwrapped without a region")
+	}
+}
+
+func TestRegion__repositories(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"source": 2000, "dest": 0})
+	west := newRegion(log.NewNopLogger(), "us-west", regionPassive, &testRegionRepository{})
+
+	accts := &regionAccountRepository{accountRepo, west}
+	txs := &regionTransactionRepository{transactionRepo, west}
+
+	if err := accts.CreateAccount("customer", &accounts.Account{ID: "other", CustomerID: "customer"}); err != errPassiveRegion {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := accts.UpdateAccount(&accounts.Account{ID: "source"}); err != errPassiveRegion {
+		t.Errorf("unexpected error: %v", err)
+	}
+	tx := transaction{
+		ID: base.ID(),
+		Lines: []transactionLine{
+			{AccountID: "source", Purpose: ACHDebit, Amount: 100},
+			{AccountID: "dest", Purpose: ACHCredit, Amount: 100},
+		},
+		Timestamp: time.Now(),
+	}
+	if err := txs.createTransaction(context.Background(), tx, createTransactionOpts{}); err != errPassiveRegion {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := txs.updateTransactionStatus(tx.ID, TransactionVoided); err != errPassiveRegion {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// reads still work
+	if accts, err := accts.GetAccounts(context.Background(), []string{"source"}); err != nil || len(accts) != 1 {
+		t.Errorf("accounts=%#v error=%v", accts, err)
+	}
+
+	if _, err := west.promote(context.Background(), "ops"); err != nil {
+		t.Fatal(err)
+	}
+	if err := txs.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSqlRegionRepository(t *testing.T) {
+	check := func(t *testing.T, repo *sqlRegionRepository) {
+		t.Helper()
+
+		if at, err := repo.latestHeartbeat("us-west"); err != nil || at != nil {
+			t.Errorf("heartbeat=%v error=%v", at, err)
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		if err := repo.writeHeartbeat("us-east", now.Add(-time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.writeHeartbeat("us-east", now); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.writeHeartbeat("us-west", now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if at, err := repo.latestHeartbeat("us-west"); err != nil || at == nil || !at.Equal(now) {
+			t.Errorf("heartbeat=%v error=%v", at, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlRegionRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlRegionRepository{mysqlDB.DB, log.NewNopLogger()})
+}
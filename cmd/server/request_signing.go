@@ -0,0 +1,300 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Machine callers can sign requests with a shared key instead of relying on a session, much like AWS Signature
+// Version 4. A signed request has two headers:
+//
+//	X-Request-Date: 20201016T150405Z
+//	Authorization: MOOV-HMAC-SHA256 Credential=<key ID>, SignedHeaders=host;x-request-date, Signature=<hex>
+//
+// The signature is the hex encoded HMAC-SHA256, with the key's secret, of the string to sign:
+//
+//	MOOV-HMAC-SHA256 \n <X-Request-Date> \n hex(sha256(canonical request))
+//
+// where the canonical request is the method, path, sorted query string, each signed header as "name:value",
+// the signed header names and the hex encoded SHA-256 of the body, each on their own line.
+const (
+	signingAlgorithm  = "MOOV-HMAC-SHA256"
+	signingDateHeader = "X-Request-Date"
+	signingDateFormat = "20060102T150405Z"
+
+	// maxSignedBodySize caps how much of a request body is read to check its signature.
+	maxSignedBodySize = 10 * 1024 * 1024
+)
+
+var (
+	errSignatureMismatch = errors.New("request signature doesn't match")
+)
+
+// signingKey is a shared secret a machine caller signs requests with. Its requests are scoped to Organization and
+// Permissions are the route groups (postings, reads and reports) they can call.
+type signingKey struct {
+	ID           string
+	Organization string
+	Secret       string
+	Permissions  map[string]bool
+}
+
+func (k *signingKey) allows(group string) bool {
+	return k.Permissions["*"] || k.Permissions[group]
+}
+
+// readSigningKeys parses REQUEST_SIGNING_KEYS, a comma separated list of keyID=organization:secret:permissions
+// where permissions are route groups joined with '+' (e.g. reads+reports) or '*' for every group.
+func readSigningKeys() (map[string]*signingKey, error) {
+	keys := make(map[string]*signingKey)
+	for _, v := range strings.Split(os.Getenv("REQUEST_SIGNING_KEYS"), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		eq, first, last := strings.Index(v, "="), strings.Index(v, ":"), strings.LastIndex(v, ":")
+		if eq <= 0 || first < eq || first == last || last == len(v)-1 {
+			return nil, fmt.Errorf("REQUEST_SIGNING_KEYS: invalid key %q, expected keyID=organization:secret:permissions", redactSigningKey(v))
+		}
+		key := &signingKey{ID: v[:eq], Organization: v[eq+1 : first], Secret: v[first+1 : last], Permissions: make(map[string]bool)}
+		if !organizationRegex.MatchString(key.Organization) {
+			return nil, fmt.Errorf("REQUEST_SIGNING_KEYS: key %q has an invalid organization %q", key.ID, key.Organization)
+		}
+		if key.Secret == "" {
+			return nil, fmt.Errorf("REQUEST_SIGNING_KEYS: key %q has no secret", key.ID)
+		}
+		for _, perm := range strings.Split(v[last+1:], "+") {
+			switch perm {
+			case "*", routeGroupPostings, routeGroupReads, routeGroupReports:
+				key.Permissions[perm] = true
+			default:
+				return nil, fmt.Errorf("REQUEST_SIGNING_KEYS: key %q has unknown permission %q", key.ID, perm)
+			}
+		}
+		if _, exists := keys[key.ID]; exists {
+			return nil, fmt.Errorf("REQUEST_SIGNING_KEYS: duplicate key %q", key.ID)
+		}
+		keys[key.ID] = key
+	}
+	return keys, nil
+}
+
+func redactSigningKey(v string) string {
+	if i := strings.Index(v, ":"); i >= 0 {
+		return v[:i] + ":..."
+	}
+	return v
+}
+
+// requestSigningTolerance returns how far X-Request-Date can be from our clock, read from
+// REQUEST_SIGNING_TOLERANCE.
+func requestSigningTolerance() time.Duration {
+	if v := os.Getenv("REQUEST_SIGNING_TOLERANCE"); v != "" {
+		if dur, err := time.ParseDuration(v); err == nil && dur > 0 {
+			return dur
+		}
+	}
+	return 5 * time.Minute
+}
+
+// canonicalRequest returns the canonical form of r which is hashed into its signature.
+func canonicalRequest(r *http.Request, signedHeaders []string, body []byte) string {
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	query := r.URL.Query()
+	var params []string
+	for name, values := range query {
+		for _, v := range values {
+			params = append(params, url.QueryEscape(name)+"="+url.QueryEscape(v))
+		}
+	}
+	sort.Strings(params)
+
+	var headers []string
+	for _, name := range signedHeaders {
+		v := r.Header.Get(name)
+		if name == "host" {
+			v = r.Host
+		}
+		headers = append(headers, name+":"+strings.TrimSpace(v))
+	}
+	sum := sha256.Sum256(body)
+	return strings.Join([]string{
+		r.Method,
+		path,
+		strings.Join(params, "&"),
+		strings.Join(headers, "\n"),
+		strings.Join(signedHeaders, ";"),
+		hex.EncodeToString(sum[:]),
+	}, "\n")
+}
+
+// requestSignature returns the hex encoded signature of a request's canonical form.
+func requestSignature(secret, date, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signingAlgorithm + "\n" + date + "\n" + hex.EncodeToString(hash[:])))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signRequest sets the headers which sign r with key at now. The body is read and replaced.
+func signRequest(r *http.Request, key *signingKey, now time.Time) error {
+	body, err := readSignedBody(r)
+	if err != nil {
+		return err
+	}
+	date := now.UTC().Format(signingDateFormat)
+	r.Header.Set(signingDateHeader, date)
+	signed := []string{"host", "x-request-date"}
+	sig := requestSignature(key.Secret, date, canonicalRequest(r, signed, body))
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s, SignedHeaders=%s, Signature=%s", signingAlgorithm, key.ID, strings.Join(signed, ";"), sig))
+	return nil
+}
+
+// readSignedBody reads r's body so it can be hashed and replaces it for handlers to read again.
+func readSignedBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxSignedBodySize+1))
+	r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSignedBodySize {
+		return nil, fmt.Errorf("signed request bodies are limited to %d bytes", maxSignedBodySize)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// parseAuthorization reads the credential, signed headers and signature of a signed request's Authorization header.
+func parseAuthorization(v string) (keyID string, signedHeaders []string, signature string, err error) {
+	fields := strings.Split(strings.TrimSpace(strings.TrimPrefix(v, signingAlgorithm)), ",")
+	for _, f := range fields {
+		kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+		if len(kv) != 2 {
+			return "", nil, "", fmt.Errorf("malformed Authorization field %q", f)
+		}
+		switch kv[0] {
+		case "Credential":
+			keyID = kv[1]
+		case "SignedHeaders":
+			signedHeaders = strings.Split(strings.ToLower(kv[1]), ";")
+		case "Signature":
+			signature = kv[1]
+		}
+	}
+	if keyID == "" || signature == "" || len(signedHeaders) == 0 {
+		return "", nil, "", errors.New("Authorization requires Credential, SignedHeaders and Signature")
+	}
+	return keyID, signedHeaders, signature, nil
+}
+
+// requestSigning verifies signed requests before passing them to next. Requests without a signature are passed
+// through untouched. Verified requests are attributed to their key through X-User-ID and scoped to its organization
+// through X-Tenant-ID. Signed requests are rejected
+// when no keys are configured, as their signature can't be verified.
+type requestSigning struct {
+	logger    log.Logger
+	keys      map[string]*signingKey
+	tolerance time.Duration
+	next      http.Handler
+
+	now func() time.Time
+}
+
+func newRequestSigning(logger log.Logger, keys map[string]*signingKey, next http.Handler) http.Handler {
+	return &requestSigning{logger: logger, keys: keys, tolerance: requestSigningTolerance(), next: next, now: time.Now}
+}
+
+func (s *requestSigning) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), signingAlgorithm+" ") {
+		s.next.ServeHTTP(w, r)
+		return
+	}
+	key, err := s.verify(r)
+	if err != nil {
+		s.logger.Log(
+			"audit", fmt.Sprintf("rejected signed request: %v", err),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"requestID", moovhttp.GetRequestID(r),
+		)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if group := routeGroup(r); !key.allows(group) {
+		s.logger.Log(
+			"audit", fmt.Sprintf("rejected signed %s request: key isn't permitted", group),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"keyID", key.ID,
+			"requestID", moovhttp.GetRequestID(r),
+		)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	r.Header.Set("X-User-ID", key.ID)
+	r.Header.Set("X-Tenant-ID", key.Organization)
+	s.next.ServeHTTP(w, r)
+}
+
+func (s *requestSigning) verify(r *http.Request) (*signingKey, error) {
+	keyID, signedHeaders, signature, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return nil, err
+	}
+	key, ok := s.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", keyID)
+	}
+	signed := make(map[string]bool)
+	for _, name := range signedHeaders {
+		signed[name] = true
+	}
+	if !signed["host"] || !signed["x-request-date"] {
+		return nil, errors.New("host and x-request-date must be signed")
+	}
+
+	date := r.Header.Get(signingDateHeader)
+	when, err := time.Parse(signingDateFormat, date)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q", signingDateHeader, date)
+	}
+	if skew := s.now().Sub(when); skew > s.tolerance || skew < -s.tolerance {
+		return nil, fmt.Errorf("%s %q is outside of %v", signingDateHeader, date, s.tolerance)
+	}
+
+	body, err := readSignedBody(r)
+	if err != nil {
+		return nil, err
+	}
+	expected := requestSignature(key.Secret, date, canonicalRequest(r, signedHeaders, body))
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return nil, fmt.Errorf("key=%s: %v", key.ID, errSignatureMismatch)
+	}
+	return key, nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRequestSigning__readSigningKeys(t *testing.T) {
+	os.Setenv("REQUEST_SIGNING_KEYS", "payroll=acme:s3:cr=et:postings+reads, reporting=acme:secret:reports,admin=other:x:*")
+	defer os.Unsetenv("REQUEST_SIGNING_KEYS")
+
+	keys, err := readSigningKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("unexpected keys: %#v", keys)
+	}
+	if k := keys["payroll"]; k.Organization != "acme" || k.Secret != "s3:cr=et" || !k.allows(routeGroupPostings) || !k.allows(routeGroupReads) || k.allows(routeGroupReports) {
+		t.Errorf("unexpected key: %#v", k)
+	}
+	if k := keys["admin"]; k.Organization != "other" || !k.allows(routeGroupReports) {
+		t.Errorf("unexpected key: %#v", k)
+	}
+
+	invalid := []string{
+		"payroll", "payroll=acme:secret", "payroll=acme::reads", "payroll=acme:secret:writes", "a=acme:b:reads,a=acme:c:reads",
+		"payroll:secret:reads", "payroll=:secret:reads", "payroll:sec=ret:reads", "payroll=acme/other:secret:reads",
+	}
+	for _, v := range invalid {
+		os.Setenv("REQUEST_SIGNING_KEYS", v)
+		if _, err := readSigningKeys(); err == nil || !strings.Contains(err.Error(), "REQUEST_SIGNING_KEYS") {
+			t.Errorf("%s: unexpected error: %v", v, err)
+		}
+	}
+}
+
+func TestRequestSigning__middleware(t *testing.T) {
+	now := time.Date(2020, time.October, 16, 15, 4, 5, 0, time.UTC)
+	keys := map[string]*signingKey{
+		"payroll":   {ID: "payroll", Organization: "acme", Secret: "secret", Permissions: map[string]bool{routeGroupPostings: true, routeGroupReads: true}},
+		"reporting": {ID: "reporting", Organization: "acme", Secret: "other", Permissions: map[string]bool{routeGroupReports: true}},
+	}
+	var buf bytes.Buffer
+	handler := &requestSigning{
+		logger:    log.NewLogfmtLogger(&buf),
+		keys:      keys,
+		tolerance: 5 * time.Minute,
+		now:       func() time.Time { return now },
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			w.Header().Set("X-User-ID", r.Header.Get("X-User-ID"))
+			w.Header().Set("X-Tenant-ID", r.Header.Get("X-Tenant-ID"))
+			w.Write(body)
+		}),
+	}
+	newRequest := func(method, target, body string) *http.Request {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("X-User-ID", "spoofed")
+		return req
+	}
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	// a signed posting is passed through with its body intact, attributed to the key and scoped to its organization
+	req := newRequest("POST", "/accounts/transactions?b=2&a=1", `{"lines":[]}`)
+	req.Header.Set("X-Tenant-ID", "other")
+	if err := signRequest(req, keys["payroll"], now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	w := serve(req)
+	if w.Code != http.StatusOK || w.Body.String() != `{"lines":[]}` || w.Header().Get("X-User-ID") != "payroll" || w.Header().Get("X-Tenant-ID") != "acme" {
+		t.Errorf("unexpected response: %d %q userID=%q tenant=%q", w.Code, w.Body.String(), w.Header().Get("X-User-ID"), w.Header().Get("X-Tenant-ID"))
+	}
+
+	// tampering with the body, query or method invalidates the signature
+	tampered := []func(*http.Request){
+		func(r *http.Request) { r.Body = ioutil.NopCloser(strings.NewReader(`{"lines":[{}]}`)) },
+		func(r *http.Request) { r.URL.RawQuery = "a=1&b=3" },
+		func(r *http.Request) { r.Method = "PATCH" },
+		func(r *http.Request) { r.Host = "evil.example.com" },
+	}
+	for i := range tampered {
+		req := newRequest("POST", "/accounts/transactions?b=2&a=1", `{"lines":[]}`)
+		signRequest(req, keys["payroll"], now)
+		tampered[i](req)
+		if w := serve(req); w.Code != http.StatusUnauthorized {
+			t.Errorf("tampered #%d: got %d", i, w.Code)
+		}
+	}
+
+	// expired signatures, unknown keys and unsigned dates are rejected
+	req = newRequest("GET", "/accounts/search", "")
+	signRequest(req, keys["payroll"], now.Add(-10*time.Minute))
+	if w := serve(req); w.Code != http.StatusUnauthorized {
+		t.Errorf("expired: got %d", w.Code)
+	}
+	req = newRequest("GET", "/accounts/search", "")
+	signRequest(req, &signingKey{ID: "unknown", Secret: "secret"}, now)
+	if w := serve(req); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown key: got %d", w.Code)
+	}
+	req = newRequest("GET", "/accounts/search", "")
+	signRequest(req, keys["payroll"], now)
+	req.Header.Set("Authorization", strings.Replace(req.Header.Get("Authorization"), "host;x-request-date", "host", 1))
+	if w := serve(req); w.Code != http.StatusUnauthorized {
+		t.Errorf("unsigned date: got %d", w.Code)
+	}
+
+	// keys can only call the route groups they're permitted
+	req = newRequest("POST", "/accounts", "{}")
+	signRequest(req, keys["reporting"], now)
+	if w := serve(req); w.Code != http.StatusForbidden {
+		t.Errorf("forbidden: got %d", w.Code)
+	}
+	req = newRequest("GET", "/accounts/abc/transactions", "")
+	signRequest(req, keys["reporting"], now)
+	if w := serve(req); w.Code != http.StatusOK {
+		t.Errorf("report: got %d", w.Code)
+	}
+
+	if !strings.Contains(buf.String(), "rejected signed request") || !strings.Contains(buf.String(), "keyID=reporting") {
+		t.Errorf("unexpected audit log: %s", buf.String())
+	}
+
+	// requests without a signature are left to the existing authentication
+	w = serve(newRequest("GET", "/ping", ""))
+	if w.Code != http.StatusOK || w.Header().Get("X-User-ID") != "spoofed" {
+		t.Errorf("unsigned: got %d userID=%q", w.Code, w.Header().Get("X-User-ID"))
+	}
+	// signed requests can't be verified without keys
+	req = newRequest("POST", "/accounts/transactions", `{}`)
+	req.Header.Set("Authorization", signingAlgorithm+" x")
+	w = httptest.NewRecorder()
+	newRequestSigning(log.NewNopLogger(), nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("unverified signed request was served")
+	})).ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("no keys: got %d", w.Code)
+	}
+}
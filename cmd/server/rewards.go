@@ -0,0 +1,574 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Cash-back rewards accrue on the debits of checking and savings accounts at a rate configured by MCC or purpose.
+// Each account's rewards are held in a rewards account of its own, which is credited from a funding account as
+// rewards accrue and debited as they're redeemed into the account. Outstanding rewards are a liability held in the
+// ledger, so the balances of rewards accounts reconcile with what accrued and was redeemed.
+
+var (
+	errRewardsAccountExists  = errors.New("account already has a rewards account")
+	errRewardsAlreadyAccrued = errors.New("rewards already accrued on transaction")
+	errNoRewards             = errors.New("account has no rewards")
+	errInsufficientRewards   = errors.New("insufficient rewards")
+)
+
+// rewardsRates are the fractions of a debit accrued as rewards. Rates for an MCC take precedence over the rates of
+// purposes.
+type rewardsRates struct {
+	mccs     map[string]float64
+	purposes map[TransactionPurpose]float64
+}
+
+// readRewardsRates reads REWARDS_RATES, comma separated key:rate pairs where the key is an MCC or a transaction
+// purpose (e.g. "5411:0.03,card:0.01"). It returns nil when rewards aren't configured.
+func readRewardsRates() (*rewardsRates, error) {
+	v := strings.TrimSpace(os.Getenv("REWARDS_RATES"))
+	if v == "" {
+		return nil, nil
+	}
+	rates := &rewardsRates{mccs: make(map[string]float64), purposes: make(map[TransactionPurpose]float64)}
+	for _, pair := range strings.Split(v, ",") {
+		parts := strings.Split(strings.TrimSpace(pair), ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("REWARDS_RATES: invalid rate %q", pair)
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return nil, fmt.Errorf("REWARDS_RATES: invalid rate %q", pair)
+		}
+		if err := validateMCC(key); err == nil {
+			rates.mccs[key] = rate
+			continue
+		}
+		if err := TransactionPurpose(key).validate(); err != nil {
+			return nil, fmt.Errorf("REWARDS_RATES: %q is neither a merchant category code nor a purpose", parts[0])
+		}
+		rates.purposes[TransactionPurpose(key)] = rate
+	}
+	return rates, nil
+}
+
+// rate returns the rate accrued on the debits of tx. That's the rate of its MCC when one is configured, otherwise
+// the highest rate of the purposes of its credited lines.
+func (r *rewardsRates) rate(tx transaction) float64 {
+	if rate, ok := r.mccs[tx.mcc()]; ok {
+		return rate
+	}
+	var rate float64
+	for i := range tx.Lines {
+		if tx.Lines[i].Purpose != ACHDebit {
+			rate = math.Max(rate, r.purposes[tx.Lines[i].Purpose])
+		}
+	}
+	return rate
+}
+
+type rewardsAccrualStatus string
+
+const (
+	rewardsAccrued  rewardsAccrualStatus = "accrued"
+	rewardsReversed rewardsAccrualStatus = "reversed"
+)
+
+// rewardsAccrual is the rewards an account earned on one of its debits (the source transaction).
+type rewardsAccrual struct {
+	ID                  string               `json:"id"`
+	AccountID           string               `json:"accountId"`
+	RewardsAccountID    string               `json:"rewardsAccountId"`
+	SourceTransactionID string               `json:"sourceTransactionId"`
+	Rate                float64              `json:"rate"`
+	Amount              int                  `json:"amount"`
+	Status              rewardsAccrualStatus `json:"status"`
+	TransactionID       string               `json:"transactionId"`
+	AccruedAt           time.Time            `json:"accruedAt"`
+
+	// ReversalTransactionID is set once the source transaction was reversed
+	ReversalTransactionID string     `json:"reversalTransactionId,omitempty"`
+	ReversedAt            *time.Time `json:"reversedAt,omitempty"`
+}
+
+func (a *rewardsAccrual) transaction(fundingAccountID string) transaction {
+	return transaction{
+		ID:          a.TransactionID,
+		Timestamp:   a.AccruedAt,
+		Status:      TransactionPosted,
+		Description: "Cash-back rewards",
+		Metadata:    map[string]string{"rewardsAccrualId": a.ID, "sourceTransactionId": a.SourceTransactionID},
+		Lines: []transactionLine{
+			{AccountID: fundingAccountID, Purpose: ACHDebit, Amount: a.Amount},
+			{AccountID: a.RewardsAccountID, Purpose: ACHCredit, Amount: a.Amount},
+		},
+	}
+}
+
+func (a *rewardsAccrual) reversalTransaction(fundingAccountID string) transaction {
+	return transaction{
+		ID:          a.ReversalTransactionID,
+		Timestamp:   *a.ReversedAt,
+		Status:      TransactionPosted,
+		Description: fmt.Sprintf("Reversal of cash-back rewards on transaction %s", a.SourceTransactionID),
+		Metadata:    map[string]string{"rewardsAccrualId": a.ID, "sourceTransactionId": a.SourceTransactionID},
+		Lines: []transactionLine{
+			{AccountID: a.RewardsAccountID, Purpose: ACHDebit, Amount: a.Amount},
+			{AccountID: fundingAccountID, Purpose: ACHCredit, Amount: a.Amount},
+		},
+	}
+}
+
+// rewardsRedemption moves rewards from an account's rewards account into the account.
+type rewardsRedemption struct {
+	ID               string    `json:"id"`
+	AccountID        string    `json:"accountId"`
+	RewardsAccountID string    `json:"rewardsAccountId"`
+	Amount           int       `json:"amount"`
+	TransactionID    string    `json:"transactionId"`
+	RedeemedBy       string    `json:"redeemedBy,omitempty"`
+	RedeemedAt       time.Time `json:"redeemedAt"`
+}
+
+func (r *rewardsRedemption) transaction() transaction {
+	return transaction{
+		ID:          r.TransactionID,
+		Timestamp:   r.RedeemedAt,
+		Status:      TransactionPosted,
+		Description: "Cash-back rewards redemption",
+		Metadata:    map[string]string{"rewardsRedemptionId": r.ID},
+		Lines: []transactionLine{
+			{AccountID: r.RewardsAccountID, Purpose: ACHDebit, Amount: r.Amount},
+			{AccountID: r.AccountID, Purpose: ACHCredit, Amount: r.Amount},
+		},
+	}
+}
+
+type redeemRewardsRequest struct {
+	// Amount defaults to every outstanding reward
+	Amount int `json:"amount"`
+}
+
+// rewardsBalance is an account's rewards sub-balance along with what it accrued and redeemed in total.
+type rewardsBalance struct {
+	AccountID        string `json:"accountId"`
+	RewardsAccountID string `json:"rewardsAccountId,omitempty"`
+	Balance          int32  `json:"balance"`
+	Accrued          int64  `json:"accrued"`
+	Redeemed         int64  `json:"redeemed"`
+}
+
+// rewardsReconciliation compares the balances of every rewards account to the rewards accrued and not redeemed.
+// A Difference other than zero means the ledger and the accrual records disagree.
+type rewardsReconciliation struct {
+	RewardsAccounts int   `json:"rewardsAccounts"`
+	Balance         int64 `json:"balance"`
+	Accrued         int64 `json:"accrued"`
+	Redeemed        int64 `json:"redeemed"`
+	Outstanding     int64 `json:"outstanding"`
+	Difference      int64 `json:"difference"`
+}
+
+type rewardsService struct {
+	logger           log.Logger
+	repo             rewardsRepository
+	accounts         accountRepository
+	transactions     *transactionService
+	rates            *rewardsRates
+	fundingAccountID string
+
+	// redeemMu serializes redemptions so the rewards balance checked isn't spent concurrently
+	redeemMu sync.Mutex
+}
+
+// accrue credits the rewards of each checking and savings account debited by tx to its rewards account. Accruals
+// are saved before they're posted so a transaction never accrues twice, and removed again if they can't be posted.
+func (s *rewardsService) accrue(ctx context.Context, tx transaction) error {
+	rate := s.rates.rate(tx)
+	if rate == 0 {
+		return nil
+	}
+	debits := make(map[string]int)
+	for i := range tx.Lines {
+		if line := tx.Lines[i]; line.Purpose == ACHDebit && line.AccountID != s.fundingAccountID {
+			debits[line.AccountID] += line.Amount
+		}
+	}
+	if len(debits) == 0 {
+		return nil
+	}
+	accountIDs := make([]string, 0, len(debits))
+	for id := range debits {
+		accountIDs = append(accountIDs, id)
+	}
+	sort.Strings(accountIDs)
+	accts, err := s.accounts.GetAccounts(ctx, accountIDs)
+	if err != nil {
+		return err
+	}
+	for _, acct := range accts {
+		if t := AccountType(strings.ToLower(acct.Type)); t != Checking && t != Savings {
+			continue
+		}
+		amount := int(math.Floor(float64(debits[acct.ID]) * rate))
+		if amount <= 0 {
+			continue
+		}
+		if err := s.accrueTo(ctx, acct, tx.ID, rate, amount); err != nil {
+			return fmt.Errorf("account=%s: %v", acct.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *rewardsService) accrueTo(ctx context.Context, acct *accounts.Account, sourceTransactionID string, rate float64, amount int) error {
+	rewardsAccountID, err := s.rewardsAccount(ctx, acct)
+	if err != nil {
+		return err
+	}
+	a := &rewardsAccrual{
+		ID:                  newID(),
+		AccountID:           acct.ID,
+		RewardsAccountID:    rewardsAccountID,
+		SourceTransactionID: sourceTransactionID,
+		Rate:                rate,
+		Amount:              amount,
+		Status:              rewardsAccrued,
+		TransactionID:       newID(),
+		AccruedAt:           time.Now(),
+	}
+	if err := s.repo.createAccrual(a); err != nil {
+		if err == errRewardsAlreadyAccrued {
+			return nil
+		}
+		return err
+	}
+	tx := a.transaction(s.fundingAccountID)
+	if err := s.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		if deleteErr := s.repo.deleteAccrual(a.ID); deleteErr != nil {
+			s.logger.Log("rewards", fmt.Sprintf("problem removing accrual=%s: %v", a.ID, deleteErr), "requestID", requestIDFrom(ctx))
+		}
+		return fmt.Errorf("accrual=%s: %v", a.ID, err)
+	}
+	s.logger.Log("rewards", fmt.Sprintf("accrued %d to account=%s on transaction=%s", a.Amount, a.AccountID, a.SourceTransactionID), "transactionID", a.TransactionID, "requestID", requestIDFrom(ctx))
+	s.transactions.publish(ctx, tx.Status, &tx)
+	return nil
+}
+
+// rewardsAccount returns the ID of acct's rewards account, creating it on the account's first accrual.
+func (s *rewardsService) rewardsAccount(ctx context.Context, acct *accounts.Account) (string, error) {
+	rewardsAccountID, err := s.repo.getRewardsAccountID(acct.ID)
+	if err != nil || rewardsAccountID != "" {
+		return rewardsAccountID, err
+	}
+	now := time.Now()
+	rewards := &accounts.Account{
+		ID:             newID(),
+		CustomerID:     acct.CustomerID,
+		OrganizationID: acct.OrganizationID,
+		Name:           "Rewards",
+		RoutingNumber:  acct.RoutingNumber,
+		Status:         string(accountStatusOpen),
+		Type:           string(Rewards),
+		CreatedAt:      now,
+		LastModified:   now,
+	}
+	if rewards.AccountNumber, err = generateAccountNumber(rewards, s.accounts); err != nil {
+		return "", err
+	}
+	if err := s.repo.createRewardsAccount(acct.ID, rewards.ID); err != nil {
+		if err == errRewardsAccountExists {
+			return s.repo.getRewardsAccountID(acct.ID)
+		}
+		return "", err
+	}
+	if err := s.accounts.CreateAccount(rewards.CustomerID, rewards); err != nil {
+		if deleteErr := s.repo.deleteRewardsAccount(acct.ID); deleteErr != nil {
+			s.logger.Log("rewards", fmt.Sprintf("problem removing rewards account of account=%s: %v", acct.ID, deleteErr), "requestID", requestIDFrom(ctx))
+		}
+		return "", fmt.Errorf("creating rewards account: %v", err)
+	}
+	s.logger.Log("rewards", fmt.Sprintf("created rewards account=%s for account=%s", rewards.ID, acct.ID), "requestID", requestIDFrom(ctx))
+	return rewards.ID, nil
+}
+
+// reverseAccruals returns the rewards accrued on a reversed transaction to the funding account. Like promotions the
+// accrual is marked reversed before posting, so it's only reversed once, and marked accrued again if the reversal
+// can't be posted. Reversals aren't checked for sufficient funds as the rewards may have been redeemed already.
+func (s *rewardsService) reverseAccruals(ctx context.Context, sourceTransactionID string) error {
+	accruals, err := s.repo.getAccruals(sourceTransactionID)
+	if err != nil {
+		return err
+	}
+	for _, a := range accruals {
+		if a.Status != rewardsAccrued {
+			continue
+		}
+		now := time.Now()
+		a.Status, a.ReversalTransactionID, a.ReversedAt = rewardsReversed, newID(), &now
+		ok, err := s.repo.reverseAccrual(a)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue // reversed concurrently
+		}
+		tx := a.reversalTransaction(s.fundingAccountID)
+		if err := s.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true, Reversal: true}); err != nil {
+			if reinstateErr := s.repo.reinstateAccrual(a.ID); reinstateErr != nil {
+				s.logger.Log("rewards", fmt.Sprintf("problem reinstating accrual=%s: %v", a.ID, reinstateErr), "requestID", requestIDFrom(ctx))
+			}
+			return fmt.Errorf("accrual=%s: %v", a.ID, err)
+		}
+		s.logger.Log("rewards", fmt.Sprintf("reversed accrual=%s of %d from account=%s", a.ID, a.Amount, a.AccountID), "transactionID", tx.ID, "requestID", requestIDFrom(ctx))
+		s.transactions.publish(ctx, tx.Status, &tx)
+	}
+	return nil
+}
+
+// Redeem moves amount (or every outstanding reward when zero) from the account's rewards account into it. The
+// amount is checked against the rewards balance here, rather than by the ledger, as the ledger's insufficient funds
+// check never lets a balance reach zero. The redemption is saved first and removed again if it can't be posted.
+func (s *rewardsService) Redeem(ctx context.Context, accountID string, userID string, req redeemRewardsRequest) (*rewardsRedemption, error) {
+	s.redeemMu.Lock()
+	defer s.redeemMu.Unlock()
+
+	bal, err := s.balance(ctx, accountID)
+	if err != nil {
+		return nil, err
+	}
+	if bal.RewardsAccountID == "" {
+		return nil, fmt.Errorf("account=%s: %v", accountID, errNoRewards)
+	}
+	if req.Amount == 0 {
+		req.Amount = int(bal.Balance)
+	}
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("account=%s: %v to redeem", accountID, errNoRewards)
+	}
+	if req.Amount > int(bal.Balance) {
+		return nil, fmt.Errorf("account=%s: %v to redeem %d", accountID, errInsufficientRewards, req.Amount)
+	}
+	r := &rewardsRedemption{
+		ID:               newID(),
+		AccountID:        accountID,
+		RewardsAccountID: bal.RewardsAccountID,
+		Amount:           req.Amount,
+		TransactionID:    newID(),
+		RedeemedBy:       userID,
+		RedeemedAt:       time.Now(),
+	}
+	if err := s.repo.createRedemption(r); err != nil {
+		return nil, err
+	}
+	tx := r.transaction()
+	if err := s.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		if deleteErr := s.repo.deleteRedemption(r.ID); deleteErr != nil {
+			s.logger.Log("rewards", fmt.Sprintf("problem removing redemption=%s: %v", r.ID, deleteErr), "requestID", requestIDFrom(ctx))
+		}
+		return nil, fmt.Errorf("redemption=%s: %v", r.ID, err)
+	}
+	s.logger.Log("rewards", fmt.Sprintf("redeemed %d of rewards into account=%s", r.Amount, accountID), "transactionID", tx.ID, "userID", userID, "requestID", requestIDFrom(ctx))
+	s.transactions.publish(ctx, tx.Status, &tx)
+	return r, nil
+}
+
+// balance returns the account's rewards. Accounts which haven't accrued anything don't have a rewards account.
+func (s *rewardsService) balance(ctx context.Context, accountID string) (*rewardsBalance, error) {
+	accts, err := s.accounts.GetAccounts(ctx, []string{accountID})
+	if err != nil {
+		return nil, err
+	}
+	if len(accts) != 1 {
+		return nil, errAccountNotFound
+	}
+	bal := &rewardsBalance{AccountID: accountID}
+	if bal.RewardsAccountID, err = s.repo.getRewardsAccountID(accountID); err != nil || bal.RewardsAccountID == "" {
+		return bal, err
+	}
+	rewards, err := s.accounts.GetAccounts(ctx, []string{bal.RewardsAccountID})
+	if err != nil {
+		return nil, err
+	}
+	if len(rewards) == 1 {
+		bal.Balance = rewards[0].Balance
+	}
+	if bal.Accrued, bal.Redeemed, err = s.repo.getRewardsTotals(accountID); err != nil {
+		return nil, err
+	}
+	return bal, nil
+}
+
+func (s *rewardsService) reconcile(ctx context.Context) (*rewardsReconciliation, error) {
+	rewardsAccountIDs, err := s.repo.getRewardsAccountIDs()
+	if err != nil {
+		return nil, err
+	}
+	out := &rewardsReconciliation{RewardsAccounts: len(rewardsAccountIDs)}
+	if len(rewardsAccountIDs) > 0 {
+		accts, err := s.accounts.GetAccounts(ctx, rewardsAccountIDs)
+		if err != nil {
+			return nil, err
+		}
+		for i := range accts {
+			out.Balance += int64(accts[i].Balance)
+		}
+	}
+	if out.Accrued, out.Redeemed, err = s.repo.getRewardsTotals(""); err != nil {
+		return nil, err
+	}
+	out.Outstanding = out.Accrued - out.Redeemed
+	out.Difference = out.Balance - out.Outstanding
+	return out, nil
+}
+
+// rewardsTransactionRepository accrues rewards on postings once they're committed and reverses the rewards of
+// postings which are reversed. Rewards are best effort, so problems are logged rather than failing the posting.
+type rewardsTransactionRepository struct {
+	transactionRepository
+
+	rewards *rewardsService
+}
+
+func (r *rewardsTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	if err := r.transactionRepository.createTransaction(ctx, tx, opts); err != nil {
+		return err
+	}
+	if (tx.Status == "" || tx.Status == TransactionPosted) && !opts.InitialDeposit && !opts.Reversal {
+		if err := r.rewards.accrue(ctx, tx); err != nil {
+			r.rewards.logger.Log("rewards", fmt.Sprintf("problem accruing rewards on transaction=%s: %v", tx.ID, err), "requestID", requestIDFrom(ctx))
+		}
+	}
+	if opts.Reverses != "" {
+		if err := r.rewards.reverseAccruals(ctx, opts.Reverses); err != nil {
+			r.rewards.logger.Log("rewards", fmt.Sprintf("problem reversing rewards of transaction=%s: %v", opts.Reverses, err), "requestID", requestIDFrom(ctx))
+		}
+	}
+	return nil
+}
+
+func (r *rewardsTransactionRepository) updateTransactionStatus(transactionID string, status TransactionStatus) error {
+	if err := r.transactionRepository.updateTransactionStatus(transactionID, status); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var err error
+	switch status {
+	case TransactionPosted:
+		var tx *transaction
+		if tx, err = r.transactionRepository.getTransaction(ctx, transactionID); err == nil && tx != nil {
+			err = r.rewards.accrue(ctx, *tx)
+		}
+	case TransactionReversed:
+		err = r.rewards.reverseAccruals(ctx, transactionID)
+	}
+	if err != nil {
+		r.rewards.logger.Log("rewards", fmt.Sprintf("problem updating rewards of transaction=%s: %v", transactionID, err))
+	}
+	return nil
+}
+
+func addRewardsRoutes(logger log.Logger, r *mux.Router, svc *rewardsService) {
+	r.Methods("GET").Path("/accounts/{accountId}/rewards").HandlerFunc(getRewards(logger, svc))
+	r.Methods("POST").Path("/accounts/{accountId}/rewards/redemptions").HandlerFunc(redeemRewards(logger, svc))
+}
+
+func getRewards(logger log.Logger, svc *rewardsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		accountID := getAccountID(w, r)
+		if accountID == "" {
+			return
+		}
+		bal, err := svc.balance(requestContext(r), accountID)
+		if err != nil {
+			if err == errAccountNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(bal)
+	}
+}
+
+func redeemRewards(logger log.Logger, svc *rewardsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		accountID := getAccountID(w, r)
+		if accountID == "" {
+			return
+		}
+
+		// The request body is optional
+		var req redeemRewardsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			moovhttp.Problem(w, err)
+			return
+		}
+		redemption, err := svc.Redeem(requestContext(r), accountID, moovhttp.GetUserID(r), req)
+		if err != nil {
+			if err == errAccountNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			logger.Log("rewards", fmt.Sprintf("problem redeeming rewards: %v", err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(redemption)
+	}
+}
+
+// getRewardsReconciliation is an admin route which compares the balances of rewards accounts to the rewards
+// accrued and not redeemed.
+func getRewardsReconciliation(logger log.Logger, svc *rewardsService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		out, err := svc.reconcile(requestContext(r))
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
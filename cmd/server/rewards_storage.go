@@ -0,0 +1,240 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+type rewardsRepository interface {
+	Ping() error
+	Close() error
+
+	// createRewardsAccount links an account to its rewards account, returning errRewardsAccountExists if it
+	// already has one.
+	createRewardsAccount(accountID, rewardsAccountID string) error
+
+	// deleteRewardsAccount unlinks a rewards account which couldn't be created.
+	deleteRewardsAccount(accountID string) error
+
+	// getRewardsAccountID returns an empty string if the account doesn't have a rewards account.
+	getRewardsAccountID(accountID string) (string, error)
+
+	getRewardsAccountIDs() ([]string, error)
+
+	// createAccrual returns errRewardsAlreadyAccrued if the account already accrued on the source transaction.
+	createAccrual(a *rewardsAccrual) error
+
+	// deleteAccrual removes an accrual which couldn't be posted.
+	deleteAccrual(accrualID string) error
+
+	// getAccruals returns the accruals on a source transaction.
+	getAccruals(sourceTransactionID string) ([]*rewardsAccrual, error)
+
+	// reverseAccrual saves the reversal of an accrual. It returns false when the accrual isn't accrued, such as when
+	// it was reversed concurrently.
+	reverseAccrual(a *rewardsAccrual) (bool, error)
+
+	// reinstateAccrual returns a reversed accrual whose reversal couldn't be posted to accrued.
+	reinstateAccrual(accrualID string) error
+
+	createRedemption(r *rewardsRedemption) error
+
+	// deleteRedemption removes a redemption which couldn't be posted.
+	deleteRedemption(redemptionID string) error
+
+	// getRewardsTotals sums the account's (or every account's when empty) accrued rewards, excluding reversed
+	// accruals, and redemptions.
+	getRewardsTotals(accountID string) (accrued int64, redeemed int64, err error)
+}
+
+type sqlRewardsRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlRewardsRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlRewardsRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlRewardsRepository) createRewardsAccount(accountID, rewardsAccountID string) error {
+	query := `insert into rewards_accounts (account_id, rewards_account_id, created_at) values (?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createRewardsAccount: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(accountID, rewardsAccountID, time.Now()); err != nil {
+		if database.UniqueViolation(err) {
+			return errRewardsAccountExists
+		}
+		return fmt.Errorf("createRewardsAccount: account=%s: %v", accountID, err)
+	}
+	return nil
+}
+
+func (r *sqlRewardsRepository) deleteRewardsAccount(accountID string) error {
+	if _, err := r.db.Exec(`delete from rewards_accounts where account_id = ?;`, accountID); err != nil {
+		return fmt.Errorf("deleteRewardsAccount: account=%s: %v", accountID, err)
+	}
+	return nil
+}
+
+func (r *sqlRewardsRepository) getRewardsAccountID(accountID string) (string, error) {
+	var rewardsAccountID string
+	err := r.db.QueryRow(`select rewards_account_id from rewards_accounts where account_id = ?;`, accountID).Scan(&rewardsAccountID)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("getRewardsAccountID: account=%s: %v", accountID, err)
+	}
+	return rewardsAccountID, nil
+}
+
+func (r *sqlRewardsRepository) getRewardsAccountIDs() ([]string, error) {
+	rows, err := r.db.Query(`select rewards_account_id from rewards_accounts order by rewards_account_id;`)
+	if err != nil {
+		return nil, fmt.Errorf("getRewardsAccountIDs: %v", err)
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("getRewardsAccountIDs: scan: %v", err)
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlRewardsRepository) createAccrual(a *rewardsAccrual) error {
+	query := `insert into rewards_accruals (accrual_id, account_id, rewards_account_id, source_transaction_id, rate, amount, status, transaction_id, accrued_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createAccrual: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(a.ID, a.AccountID, a.RewardsAccountID, a.SourceTransactionID, a.Rate, a.Amount, a.Status, a.TransactionID, a.AccruedAt); err != nil {
+		if database.UniqueViolation(err) {
+			return errRewardsAlreadyAccrued
+		}
+		return fmt.Errorf("createAccrual: accrual=%s: %v", a.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlRewardsRepository) deleteAccrual(accrualID string) error {
+	if _, err := r.db.Exec(`delete from rewards_accruals where accrual_id = ?;`, accrualID); err != nil {
+		return fmt.Errorf("deleteAccrual: accrual=%s: %v", accrualID, err)
+	}
+	return nil
+}
+
+func (r *sqlRewardsRepository) getAccruals(sourceTransactionID string) ([]*rewardsAccrual, error) {
+	query := `select accrual_id, account_id, rewards_account_id, source_transaction_id, rate, amount, status, transaction_id, accrued_at, reversal_transaction_id, reversed_at
+from rewards_accruals where source_transaction_id = ? order by account_id;`
+	rows, err := r.db.Query(query, sourceTransactionID)
+	if err != nil {
+		return nil, fmt.Errorf("getAccruals: transaction=%s: %v", sourceTransactionID, err)
+	}
+	defer rows.Close()
+
+	var out []*rewardsAccrual
+	for rows.Next() {
+		var a rewardsAccrual
+		var reversalTransactionID *string
+		if err := rows.Scan(&a.ID, &a.AccountID, &a.RewardsAccountID, &a.SourceTransactionID, &a.Rate, &a.Amount, &a.Status, &a.TransactionID, &a.AccruedAt, &reversalTransactionID, &a.ReversedAt); err != nil {
+			return nil, fmt.Errorf("getAccruals: scan: %v", err)
+		}
+		if reversalTransactionID != nil {
+			a.ReversalTransactionID = *reversalTransactionID
+		}
+		out = append(out, &a)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlRewardsRepository) reverseAccrual(a *rewardsAccrual) (bool, error) {
+	query := `update rewards_accruals set status = ?, reversal_transaction_id = ?, reversed_at = ? where accrual_id = ? and status = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, fmt.Errorf("reverseAccrual: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(rewardsReversed, a.ReversalTransactionID, a.ReversedAt, a.ID, rewardsAccrued)
+	if err != nil {
+		return false, fmt.Errorf("reverseAccrual: accrual=%s: %v", a.ID, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("reverseAccrual: accrual=%s: %v", a.ID, err)
+	}
+	return n == 1, nil
+}
+
+func (r *sqlRewardsRepository) reinstateAccrual(accrualID string) error {
+	query := `update rewards_accruals set status = ?, reversal_transaction_id = null, reversed_at = null where accrual_id = ? and status = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("reinstateAccrual: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(rewardsAccrued, accrualID, rewardsReversed); err != nil {
+		return fmt.Errorf("reinstateAccrual: accrual=%s: %v", accrualID, err)
+	}
+	return nil
+}
+
+func (r *sqlRewardsRepository) createRedemption(redemption *rewardsRedemption) error {
+	query := `insert into rewards_redemptions (redemption_id, account_id, rewards_account_id, amount, transaction_id, redeemed_by, redeemed_at) values (?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createRedemption: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(redemption.ID, redemption.AccountID, redemption.RewardsAccountID, redemption.Amount, redemption.TransactionID, redemption.RedeemedBy, redemption.RedeemedAt); err != nil {
+		return fmt.Errorf("createRedemption: redemption=%s: %v", redemption.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlRewardsRepository) deleteRedemption(redemptionID string) error {
+	if _, err := r.db.Exec(`delete from rewards_redemptions where redemption_id = ?;`, redemptionID); err != nil {
+		return fmt.Errorf("deleteRedemption: redemption=%s: %v", redemptionID, err)
+	}
+	return nil
+}
+
+func (r *sqlRewardsRepository) getRewardsTotals(accountID string) (int64, int64, error) {
+	where, args := `1 = 1`, []interface{}{}
+	if accountID != "" {
+		where, args = `account_id = ?`, append(args, accountID)
+	}
+	var accrued, redeemed sql.NullInt64
+	query := fmt.Sprintf(`select sum(amount) from rewards_accruals where %s and status = ?;`, where)
+	if err := r.db.QueryRow(query, append(args, rewardsAccrued)...).Scan(&accrued); err != nil {
+		return 0, 0, fmt.Errorf("getRewardsTotals: accrued: %v", err)
+	}
+	query = fmt.Sprintf(`select sum(amount) from rewards_redemptions where %s;`, where)
+	if err := r.db.QueryRow(query, args...).Scan(&redeemed); err != nil {
+		return 0, 0, fmt.Errorf("getRewardsTotals: redeemed: %v", err)
+	}
+	return accrued.Int64, redeemed.Int64, nil
+}
@@ -0,0 +1,255 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/moov-io/base"
+)
+
+func TestReadRewardsRates(t *testing.T) {
+	if rates, err := readRewardsRates(); err != nil || rates != nil {
+		t.Errorf("rates=%#v error=%v", rates, err)
+	}
+
+	os.Setenv("REWARDS_RATES", "5411:0.03, Card:0.01,wire:0.005")
+	defer os.Unsetenv("REWARDS_RATES")
+	rates, err := readRewardsRates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	grocery := transaction{Lines: []transactionLine{{AccountID: "alice", Purpose: ACHDebit, Amount: 100}, {AccountID: "network", Purpose: Card, Amount: 100, MCC: "5411"}}}
+	restaurant := transaction{Lines: []transactionLine{{AccountID: "alice", Purpose: ACHDebit, Amount: 100}, {AccountID: "network", Purpose: Card, Amount: 100, MCC: "5812"}}}
+	transfer := transaction{Lines: []transactionLine{{AccountID: "alice", Purpose: ACHDebit, Amount: 100}, {AccountID: "bob", Purpose: Transfer, Amount: 100}}}
+	if rates.rate(grocery) != 0.03 || rates.rate(restaurant) != 0.01 || rates.rate(transfer) != 0 {
+		t.Errorf("unexpected rates: %#v", rates)
+	}
+
+	for _, v := range []string{"card", "card:0", "card:1.5", "brokerage:0.01", "9999:0.01"} {
+		os.Setenv("REWARDS_RATES", v)
+		if _, err := readRewardsRates(); err == nil || !strings.Contains(err.Error(), "REWARDS_RATES") {
+			t.Errorf("%s: unexpected error: %v", v, err)
+		}
+	}
+}
+
+func TestRewardsRepository(t *testing.T) {
+	check := func(t *testing.T, repo *sqlRewardsRepository) {
+		if id, err := repo.getRewardsAccountID("alice"); err != nil || id != "" {
+			t.Errorf("id=%q error=%v", id, err)
+		}
+		if err := repo.createRewardsAccount("alice", "alice-rewards"); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.createRewardsAccount("alice", "other"); err != errRewardsAccountExists {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if id, err := repo.getRewardsAccountID("alice"); err != nil || id != "alice-rewards" {
+			t.Errorf("id=%q error=%v", id, err)
+		}
+		if ids, err := repo.getRewardsAccountIDs(); err != nil || len(ids) != 1 || ids[0] != "alice-rewards" {
+			t.Errorf("ids=%v error=%v", ids, err)
+		}
+
+		now := time.Now().Truncate(time.Second)
+		first := &rewardsAccrual{ID: base.ID(), AccountID: "alice", RewardsAccountID: "alice-rewards", SourceTransactionID: "groceries", Rate: 0.03, Amount: 300,
+			Status: rewardsAccrued, TransactionID: base.ID(), AccruedAt: now}
+		second := &rewardsAccrual{ID: base.ID(), AccountID: "alice", RewardsAccountID: "alice-rewards", SourceTransactionID: "dinner", Rate: 0.01, Amount: 50,
+			Status: rewardsAccrued, TransactionID: base.ID(), AccruedAt: now}
+		for _, a := range []*rewardsAccrual{first, second} {
+			if err := repo.createAccrual(a); err != nil {
+				t.Fatal(err)
+			}
+		}
+		again := *first
+		again.ID = base.ID()
+		if err := repo.createAccrual(&again); err != errRewardsAlreadyAccrued {
+			t.Errorf("unexpected error: %v", err)
+		}
+		accruals, err := repo.getAccruals("groceries")
+		if err != nil || len(accruals) != 1 || accruals[0].Rate != 0.03 || accruals[0].Amount != 300 || !accruals[0].AccruedAt.Equal(now) {
+			t.Fatalf("accruals=%#v error=%v", accruals, err)
+		}
+
+		redemption := &rewardsRedemption{ID: base.ID(), AccountID: "alice", RewardsAccountID: "alice-rewards", Amount: 100, TransactionID: base.ID(), RedeemedAt: now}
+		if err := repo.createRedemption(redemption); err != nil {
+			t.Fatal(err)
+		}
+		if accrued, redeemed, err := repo.getRewardsTotals("alice"); err != nil || accrued != 350 || redeemed != 100 {
+			t.Errorf("accrued=%d redeemed=%d error=%v", accrued, redeemed, err)
+		}
+
+		// accruals are only reversed once
+		second.ReversalTransactionID, second.ReversedAt = base.ID(), &now
+		if ok, err := repo.reverseAccrual(second); err != nil || !ok {
+			t.Fatalf("ok=%v error=%v", ok, err)
+		}
+		if ok, err := repo.reverseAccrual(second); err != nil || ok {
+			t.Errorf("ok=%v error=%v", ok, err)
+		}
+		if accruals, err := repo.getAccruals("dinner"); err != nil || accruals[0].Status != rewardsReversed || accruals[0].ReversalTransactionID != second.ReversalTransactionID || accruals[0].ReversedAt == nil {
+			t.Errorf("accruals=%#v error=%v", accruals, err)
+		}
+		if accrued, _, err := repo.getRewardsTotals(""); err != nil || accrued != 300 {
+			t.Errorf("accrued=%d error=%v", accrued, err)
+		}
+		if err := repo.reinstateAccrual(second.ID); err != nil {
+			t.Fatal(err)
+		}
+		if accruals, err := repo.getAccruals("dinner"); err != nil || accruals[0].Status != rewardsAccrued || accruals[0].ReversalTransactionID != "" || accruals[0].ReversedAt != nil {
+			t.Errorf("accruals=%#v error=%v", accruals, err)
+		}
+
+		for _, err := range []error{repo.deleteAccrual(second.ID), repo.deleteRedemption(redemption.ID), repo.deleteRewardsAccount("alice")} {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if accrued, redeemed, err := repo.getRewardsTotals("alice"); err != nil || accrued != 300 || redeemed != 0 {
+			t.Errorf("accrued=%d redeemed=%d error=%v", accrued, redeemed, err)
+		}
+		if id, err := repo.getRewardsAccountID("alice"); err != nil || id != "" {
+			t.Errorf("id=%q error=%v", id, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlRewardsRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlRewardsRepository{mysqlDB.DB, log.NewNopLogger()})
+}
+
+func TestRewards(t *testing.T) {
+	accountRepo, ledger := createTestLedger(t, map[string]int{"funding": 0, "alice": 50000, "bob": 10000, "network": 0})
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	svc := &rewardsService{
+		logger:           log.NewNopLogger(),
+		repo:             &sqlRewardsRepository{db.DB, log.NewNopLogger()},
+		accounts:         accountRepo,
+		transactions:     &transactionService{logger: log.NewNopLogger(), repo: ledger, events: &mockEventPublisher{}},
+		rates:            &rewardsRates{mccs: map[string]float64{"5411": 0.03}, purposes: map[TransactionPurpose]float64{Card: 0.01, ACHCredit: 0.02}},
+		fundingAccountID: "funding",
+	}
+	transactionRepo := &rewardsTransactionRepository{transactionRepository: ledger, rewards: svc}
+	transactions := &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}}
+	post := func(tx transaction) {
+		t.Helper()
+		if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	purchase := func(mcc string, amount int) transaction {
+		return transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+			{AccountID: "alice", Purpose: ACHDebit, Amount: amount},
+			{AccountID: "network", Purpose: Card, Amount: amount, MCC: mcc},
+		}}
+	}
+
+	groceries, dinner := purchase("5411", 10000), purchase("5812", 5050)
+	post(groceries)
+	post(dinner)
+	post(transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+		{AccountID: "alice", Purpose: ACHDebit, Amount: 1000},
+		{AccountID: "bob", Purpose: Transfer, Amount: 1000},
+	}})
+	bal, err := svc.balance(context.Background(), "alice")
+	if err != nil || bal.RewardsAccountID == "" || bal.Balance != 350 || bal.Accrued != 350 || bal.Redeemed != 0 {
+		t.Fatalf("balance=%#v error=%v", bal, err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 33950, "funding": -350, bal.RewardsAccountID: 350})
+	if bal, err := svc.balance(context.Background(), "bob"); err != nil || bal.RewardsAccountID != "" || bal.Balance != 0 {
+		t.Errorf("balance=%#v error=%v", bal, err)
+	}
+
+	// pending transactions accrue once they're posted
+	pending := purchase("5812", 2000)
+	pending.Status = TransactionPending
+	post(pending)
+	checkBalances(t, accountRepo, map[string]int32{"funding": -350})
+	if _, err := transactions.UpdateTransactionStatus(context.Background(), pending.ID, TransactionPosted); err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"funding": -370, bal.RewardsAccountID: 370})
+
+	// reversing a payment reverses its rewards
+	payment := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+		{AccountID: "alice", Purpose: ACHDebit, Amount: 2500},
+		{AccountID: "bob", Purpose: ACHCredit, Amount: 2500},
+	}}
+	post(payment)
+	checkBalances(t, accountRepo, map[string]int32{"funding": -420, bal.RewardsAccountID: 420})
+	checkBalances(t, accountRepo, map[string]int32{"bob": 13500})
+	if _, err := transactions.ReverseTransaction(context.Background(), payment.ID); err != nil {
+		t.Fatal(err)
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 31950, "funding": -370, bal.RewardsAccountID: 370})
+
+	router := mux.NewRouter()
+	addRewardsRoutes(log.NewNopLogger(), router, svc)
+	router.HandleFunc("/rewards/reconciliation", getRewardsReconciliation(log.NewNopLogger(), svc))
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", "alice")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	w := serve("POST", "/accounts/alice/rewards/redemptions", `{"amount": 50}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var redemption rewardsRedemption
+	if err := json.NewDecoder(w.Body).Decode(&redemption); err != nil || redemption.Amount != 50 || redemption.RedeemedBy != "alice" {
+		t.Errorf("redemption=%#v error=%v", redemption, err)
+	}
+	if w := serve("POST", "/accounts/alice/rewards/redemptions", `{"amount": 500}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := serve("POST", "/accounts/alice/rewards/redemptions", ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 32320, "funding": -370, bal.RewardsAccountID: 0})
+	for path, code := range map[string]int{"/accounts/alice/rewards/redemptions": http.StatusBadRequest, "/accounts/bob/rewards/redemptions": http.StatusBadRequest, "/accounts/missing/rewards/redemptions": http.StatusNotFound} {
+		if w := serve("POST", path, ""); w.Code != code {
+			t.Errorf("%s: bogus HTTP status: %d", path, w.Code)
+		}
+	}
+
+	w = serve("GET", "/accounts/alice/rewards", "")
+	if err := json.NewDecoder(w.Body).Decode(&bal); err != nil || bal.Balance != 0 || bal.Accrued != 370 || bal.Redeemed != 370 {
+		t.Errorf("balance=%#v error=%v", bal, err)
+	}
+	if w := serve("GET", "/accounts/missing/rewards", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	w = serve("GET", "/rewards/reconciliation", "")
+	var recon rewardsReconciliation
+	if err := json.NewDecoder(w.Body).Decode(&recon); err != nil {
+		t.Fatal(err)
+	}
+	if recon.RewardsAccounts != 1 || recon.Balance != 0 || recon.Accrued != 370 || recon.Redeemed != 370 || recon.Outstanding != 0 || recon.Difference != 0 {
+		t.Errorf("unexpected reconciliation: %#v", recon)
+	}
+}
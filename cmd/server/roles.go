@@ -0,0 +1,58 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Roles in a token's roles claim grant the route groups a caller can use. Viewers can read accounts and
+// transactions but never move money, which is what support tooling needs. Posters can also post transactions and
+// admins can also change accounts and the configuration postings use. Other roles are mapped to route groups with
+// JWT_ROLE_PERMISSIONS.
+const (
+	roleViewer = "viewer"
+	rolePoster = "poster"
+	roleAdmin  = "admin"
+
+	// routeGroupAdmin is the permission needed for adminRoute requests. They're postings as far as concurrency
+	// limits, signing keys and client certificates are concerned.
+	routeGroupAdmin = "admin"
+)
+
+func builtinRolePermissions() map[string]map[string]bool {
+	return map[string]map[string]bool{
+		roleViewer: {routeGroupReads: true, routeGroupReports: true},
+		rolePoster: {routeGroupPostings: true, routeGroupReads: true, routeGroupReports: true},
+		roleAdmin:  {"*": true},
+	}
+}
+
+// adminRoute returns true for requests which change accounts or transaction templates rather than post money.
+func adminRoute(r *http.Request) bool {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] != "accounts" {
+		return false
+	}
+	switch {
+	case r.Method == "PATCH" && len(parts) == 2:
+		return true // updating an account
+	case r.Method == "POST" && len(parts) == 3 && parts[2] == "close":
+		return true
+	case parts[1] == "transaction-templates":
+		// managing templates, but not posting transactions from them
+		return r.Method != "GET" && !(len(parts) == 4 && parts[3] == "transactions")
+	}
+	return false
+}
+
+// rolePermission returns the permission a role needs to make the request.
+func rolePermission(r *http.Request) string {
+	if adminRoute(r) {
+		return routeGroupAdmin
+	}
+	return routeGroup(r)
+}
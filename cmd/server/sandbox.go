@@ -0,0 +1,317 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+var (
+	// sandboxBatchSize is how many accounts are read at once while advancing the clock
+	sandboxBatchSize = 100
+
+	// maxSandboxAdvance is the furthest the clock can be advanced in one request
+	maxSandboxAdvance = 366 * 24 * time.Hour
+)
+
+// sandboxEnabled returns true when SANDBOX_MODE is set, which lets privileged callers advance the ledger's
+// virtual clock. It must only be enabled on dedicated instances used for integration testing.
+func sandboxEnabled() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("SANDBOX_MODE"))
+	return enabled
+}
+
+// sandboxClock is the ledger's virtual clock in sandbox mode. It runs alongside the wall clock from an offset
+// which only moves forward, unless a snapshot is restored.
+type sandboxClock struct {
+	mu     sync.RWMutex
+	offset time.Duration
+
+	wall func() time.Time
+}
+
+func (c *sandboxClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.wall().Add(c.offset)
+}
+
+func (c *sandboxClock) getOffset() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.offset
+}
+
+// setOffset moves the clock to offset ahead of the wall clock, which is only done when restoring snapshots.
+func (c *sandboxClock) setOffset(offset time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset = offset
+}
+
+// advance moves the clock forward by d and returns the virtual times before and after.
+func (c *sandboxClock) advance(d time.Duration) (time.Time, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.wall()
+	from := now.Add(c.offset)
+	c.offset += d
+	return from, now.Add(c.offset)
+}
+
+// sandbox simulates the passing of time so partners can test month-long flows in minutes. Advancing its clock
+// releases pending transactions once they've been held for the availability delay and runs the recurring rule,
+// interest and fee engines, which read the same clock, at each month end and when the clock stops.
+type sandbox struct {
+	logger log.Logger
+	clock  *sandboxClock
+
+	accountRepo accountRepository
+	svc         *transactionService
+
+	// interest and fees are nil when they aren't configured
+	interest  *interestService
+	fees      *feeEngine
+	recurring *recurringService
+
+	// ledgerAccountID is the account canned scenarios transact against
+	ledgerAccountID string
+
+	// availabilityDelay is how long pending transactions are held before they're posted
+	availabilityDelay time.Duration
+
+	mu sync.Mutex // held while advancing
+}
+
+// newSandbox returns a sandbox advancing clock, which the interest, fee and recurring engines must read the time from.
+func newSandbox(logger log.Logger, clock *sandboxClock, accountRepo accountRepository, svc *transactionService, interest *interestService, fees *feeEngine, recurring *recurringService) (*sandbox, error) {
+	sb := &sandbox{
+		logger:            logger,
+		clock:             clock,
+		accountRepo:       accountRepo,
+		svc:               svc,
+		interest:          interest,
+		fees:              fees,
+		recurring:         recurring,
+		ledgerAccountID:   configuredSystemAccounts.resolve(os.Getenv("SANDBOX_LEDGER_ACCOUNT_ID")),
+		availabilityDelay: 48 * time.Hour,
+	}
+	if v := os.Getenv("SANDBOX_AVAILABILITY_DELAY"); v != "" {
+		dur, err := time.ParseDuration(v)
+		if err != nil || dur < 0 {
+			return nil, fmt.Errorf("invalid SANDBOX_AVAILABILITY_DELAY %q", v)
+		}
+		sb.availabilityDelay = dur
+	}
+	return sb, nil
+}
+
+// sandboxAdvance is the outcome of advancing the sandbox clock.
+type sandboxAdvance struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+
+	// MonthsClosed are the months (YYYY-MM) which ended in every time zone while advancing
+	MonthsClosed []string `json:"monthsClosed"`
+
+	Released  int `json:"released"`
+	Recurring int `json:"recurring"`
+
+	// Interest and Fees are the interest paid and monthly fees charged for the months closed
+	Interest int64 `json:"interest"`
+	Fees     int64 `json:"fees"`
+
+	// Failures are the accounts or transactions which couldn't be processed. They are not retried.
+	Failures []string `json:"failures,omitempty"`
+}
+
+func (a *sandboxAdvance) fail(err error) {
+	a.Failures = append(a.Failures, err.Error())
+}
+
+// monthEnds returns each time after from, up to and including to, when a month ended in every time zone.
+func monthEnds(from, to time.Time) []time.Time {
+	var out []time.Time
+	from = from.In(lastTimeZone)
+	next := time.Date(from.Year(), from.Month(), 1, 0, 0, 0, 0, lastTimeZone).AddDate(0, 1, 0)
+	for !next.After(to) {
+		out = append(out, next)
+		next = next.AddDate(0, 1, 0)
+	}
+	return out
+}
+
+// advance moves the clock forward by d, stopping at each month end on the way to release transactions and run the
+// engines as they would have at that time.
+func (sb *sandbox) advance(ctx context.Context, d time.Duration) (*sandboxAdvance, error) {
+	if d <= 0 || d > maxSandboxAdvance {
+		return nil, fmt.Errorf("advance: duration must be positive and at most %v", maxSandboxAdvance)
+	}
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+
+	from := sb.clock.Now()
+	result := &sandboxAdvance{From: from, To: from.Add(d), MonthsClosed: []string{}}
+	at := from
+	for _, stop := range append(monthEnds(from, result.To), result.To) {
+		if stop.After(at) {
+			sb.clock.advance(stop.Sub(at))
+			at = stop
+		}
+		if err := sb.release(ctx, at, result); err != nil {
+			return result, fmt.Errorf("advance: %v", err)
+		}
+		if err := sb.runEngines(ctx, at, result); err != nil {
+			return result, fmt.Errorf("advance: %v", err)
+		}
+	}
+	sb.logger.Log("sandbox", fmt.Sprintf("advanced clock to %v: closed %d months, released %d transactions", result.To, len(result.MonthsClosed), result.Released), "requestID", requestIDFrom(ctx))
+	return result, nil
+}
+
+// runEngines posts the recurring rules due, and interest and monthly fees of months which ended, as of at.
+func (sb *sandbox) runEngines(ctx context.Context, at time.Time, result *sandboxAdvance) error {
+	if sb.recurring != nil {
+		posted, err := sb.recurring.runDue(ctx, at)
+		if err != nil {
+			result.fail(fmt.Errorf("recurring: %v", err))
+		}
+		result.Recurring += posted
+	}
+	if sb.interest != nil {
+		runs, err := sb.interest.run(ctx, at)
+		for _, run := range runs {
+			result.Interest += run.Amount
+			result.Failures = append(result.Failures, run.Failures...)
+		}
+		if err != nil {
+			return fmt.Errorf("interest: %v", err)
+		}
+	}
+	if sb.fees != nil {
+		run, err := sb.fees.run(ctx, at)
+		if err != nil {
+			return fmt.Errorf("fees: %v", err)
+		}
+		if run != nil {
+			result.Fees += run.Amount
+			result.Failures = append(result.Failures, run.Failures...)
+		}
+	}
+	if ends := monthEnds(at.Add(-time.Nanosecond), at); len(ends) == 1 {
+		month, _, _ := statementCycle("", at, lastTimeZone)
+		result.MonthsClosed = append(result.MonthsClosed, month.Format("2006-01"))
+	}
+	return nil
+}
+
+// eachAccount calls fn with every account, in batches ordered by their ID.
+func (sb *sandbox) eachAccount(fn func(*accounts.Account)) error {
+	after := ""
+	for {
+		accts, err := sb.accountRepo.ListAccounts(after, sandboxBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(accts) == 0 {
+			return nil
+		}
+		for i := range accts {
+			fn(accts[i])
+			after = accts[i].ID
+		}
+	}
+}
+
+// release posts pending transactions which have been held for the availability delay as of at.
+func (sb *sandbox) release(ctx context.Context, at time.Time, result *sandboxAdvance) error {
+	seen := make(map[string]bool)
+	return sb.eachAccount(func(account *accounts.Account) {
+		err := eachAccountTransactionPage(ctx, sb.svc.repo, account.ID, transactionPage{}, func(transactions []transaction) error {
+			for i := range transactions {
+				tx := transactions[i]
+				if seen[tx.ID] || tx.Status != TransactionPending || tx.Timestamp.Add(sb.availabilityDelay).After(at) {
+					continue
+				}
+				seen[tx.ID] = true
+				if _, err := sb.svc.UpdateTransactionStatus(ctx, tx.ID, TransactionPosted); err != nil {
+					result.fail(fmt.Errorf("transaction=%s: %v", tx.ID, err))
+					continue
+				}
+				result.Released++
+			}
+			return nil
+		})
+		if err != nil {
+			result.fail(fmt.Errorf("account=%s: %v", account.ID, err))
+		}
+	})
+}
+
+type sandboxClockResponse struct {
+	Now    time.Time `json:"now"`
+	Offset string    `json:"offset"`
+}
+
+// getSandboxClock returns the current time of the sandbox's virtual clock.
+func getSandboxClock(logger log.Logger, sb *sandbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(sandboxClockResponse{Now: sb.clock.Now(), Offset: sb.clock.getOffset().String()})
+	}
+}
+
+type advanceSandboxClockRequest struct {
+	Duration string `json:"duration"` // e.g. 720h
+}
+
+// advanceSandboxClock moves the sandbox's virtual clock forward, releasing pending transactions and running the
+// engines on the way.
+func advanceSandboxClock(logger log.Logger, sb *sandbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req advanceSandboxClockRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			moovhttp.Problem(w, fmt.Errorf("invalid duration: %v", err))
+			return
+		}
+
+		result, err := sb.advance(withRequestID(r.Context(), moovhttp.GetRequestID(r)), d)
+		if err != nil {
+			logger.Log("sandbox", fmt.Sprintf("problem advancing clock: %v", err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// highVolumeTransactions is how many transactions the high-volume scenario posts
+var highVolumeTransactions = 500
+
+// sandboxScenario creates accounts for a customer with a canned history, which is spread over the 30 days
+// before the sandbox clock.
+type sandboxScenario func(sb *sandbox, customerID string) ([]string, error)
+
+var sandboxScenarios = map[string]sandboxScenario{
+	// nsf-history is an account with a low balance and debits which failed from insufficient funds
+	"nsf-history": func(sb *sandbox, customerID string) ([]string, error) {
+		start := sb.clock.Now().AddDate(0, 0, -30)
+		account, err := sb.createAccount(customerID, "NSF History", "open", 5000, start)
+		if err != nil {
+			return nil, err
+		}
+		for i := 1; i <= 9; i++ {
+			status, amount := TransactionPosted, 400
+			if i%3 == 0 {
+				status, amount = TransactionFailed, 10000
+			}
+			if err := sb.transfer(start.AddDate(0, 0, 3*i), account, ACHDebit, amount, status); err != nil {
+				return nil, err
+			}
+		}
+		return []string{account}, nil
+	},
+
+	// frozen-account is a funded account whose status is frozen
+	"frozen-account": func(sb *sandbox, customerID string) ([]string, error) {
+		start := sb.clock.Now().AddDate(0, 0, -30)
+		account, err := sb.createAccount(customerID, "Frozen", "frozen", 100000, start)
+		if err != nil {
+			return nil, err
+		}
+		if err := sb.transfer(start.AddDate(0, 0, 10), account, ACHCredit, 25000, TransactionPosted); err != nil {
+			return nil, err
+		}
+		return []string{account}, nil
+	},
+
+	// high-volume is an account with hundreds of small credits and debits
+	"high-volume": func(sb *sandbox, customerID string) ([]string, error) {
+		start := sb.clock.Now().AddDate(0, 0, -30)
+		account, err := sb.createAccount(customerID, "High Volume", "open", 10000000, start)
+		if err != nil {
+			return nil, err
+		}
+		every := 30 * 24 * time.Hour / time.Duration(highVolumeTransactions)
+		for i := 0; i < highVolumeTransactions; i++ {
+			purpose := ACHCredit
+			if i%2 == 1 {
+				purpose = ACHDebit
+			}
+			if err := sb.transfer(start.Add(time.Duration(i+1)*every), account, purpose, 100+i%900, TransactionPosted); err != nil {
+				return nil, err
+			}
+		}
+		return []string{account}, nil
+	},
+}
+
+// createAccount opens an account for the customer funded with balance (in USD cents) at the given time.
+func (sb *sandbox) createAccount(customerID, name, status string, balance int, at time.Time) (string, error) {
+	account := &accounts.Account{
+		ID:            newID(),
+		CustomerID:    customerID,
+		Name:          name,
+		RoutingNumber: defaultRoutingNumber,
+		Status:        status,
+		Type:          "checking",
+		CreatedAt:     at,
+		LastModified:  at,
+	}
+	number, err := generateAccountNumber(account, sb.accountRepo)
+	if number == "" {
+		return "", fmt.Errorf("createAccount: account number: %v", err)
+	}
+	account.AccountNumber = number
+	if err := sb.accountRepo.CreateAccount(customerID, account); err != nil {
+		return "", fmt.Errorf("createAccount: %v", err)
+	}
+	deposit := transaction{
+		ID:        newID(),
+		Timestamp: at,
+		Status:    TransactionPosted,
+		Lines:     []transactionLine{{AccountID: account.ID, Purpose: ACHCredit, Amount: balance}},
+	}
+	if err := sb.svc.repo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+		return "", fmt.Errorf("createAccount: deposit: %v", err)
+	}
+	return account.ID, nil
+}
+
+// transfer records a credit or debit of an account against the ledger account. Failed transfers are recorded as
+// pending and then failed, so they never affect balances.
+func (sb *sandbox) transfer(at time.Time, accountID string, purpose TransactionPurpose, amount int, status TransactionStatus) error {
+	tx := transaction{
+		ID:        newID(),
+		Timestamp: at,
+		Status:    status,
+		Lines: []transactionLine{
+			{AccountID: accountID, Purpose: purpose, Amount: amount},
+			{AccountID: sb.ledgerAccountID, Purpose: ACHCredit, Amount: amount},
+		},
+	}
+	if purpose == ACHCredit {
+		tx.Lines[1].Purpose = ACHDebit
+	}
+	if status == TransactionFailed {
+		tx.Status = TransactionPending
+	}
+	if err := sb.svc.repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		return fmt.Errorf("transfer: %v", err)
+	}
+	if status == TransactionFailed {
+		if err := sb.svc.repo.updateTransactionStatus(tx.ID, TransactionFailed); err != nil {
+			return fmt.Errorf("transfer: %v", err)
+		}
+	}
+	return nil
+}
+
+type loadSandboxScenarioRequest struct {
+	Scenario   string `json:"scenario"`
+	CustomerID string `json:"customerId"`
+}
+
+type sandboxScenarioResponse struct {
+	Scenario string              `json:"scenario"`
+	Accounts []*accounts.Account `json:"accounts"`
+}
+
+func sandboxScenarioNames() string {
+	var names []string
+	for name := range sandboxScenarios {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// loadSandboxScenario creates accounts for a customer from one of the canned scenarios.
+func loadSandboxScenario(logger log.Logger, sb *sandbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req loadSandboxScenarioRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		scenario, exists := sandboxScenarios[req.Scenario]
+		if !exists {
+			moovhttp.Problem(w, fmt.Errorf("unknown scenario %q, options: %s", req.Scenario, sandboxScenarioNames()))
+			return
+		}
+		if req.CustomerID == "" {
+			moovhttp.Problem(w, errors.New("missing customerId"))
+			return
+		}
+		if sb.ledgerAccountID == "" {
+			moovhttp.Problem(w, errors.New("scenarios require SANDBOX_LEDGER_ACCOUNT_ID"))
+			return
+		}
+
+		sb.mu.Lock()
+		defer sb.mu.Unlock()
+
+		accountIDs, err := scenario(sb, req.CustomerID)
+		if err != nil {
+			logger.Log("sandbox", fmt.Sprintf("problem loading scenario=%s for customer=%s: %v", req.Scenario, req.CustomerID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		accts, err := sb.accountRepo.GetAccounts(r.Context(), accountIDs)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("sandbox", fmt.Sprintf("loaded scenario=%s for customer=%s", req.Scenario, req.CustomerID), "requestID", moovhttp.GetRequestID(r))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(sandboxScenarioResponse{Scenario: req.Scenario, Accounts: accts})
+	}
+}
+
+// resetSandboxCustomer deletes a customer's accounts and their transactions, then recomputes the balances of
+// other accounts those transactions were posted against.
+func resetSandboxCustomer(logger log.Logger, sb *sandbox, repo sandboxRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		customerID := mux.Vars(r)["customerId"]
+		if customerID == "" {
+			moovhttp.Problem(w, errors.New("missing customerId"))
+			return
+		}
+
+		sb.mu.Lock()
+		defer sb.mu.Unlock()
+
+		reset, err := repo.resetCustomer(customerID)
+		if err != nil {
+			logger.Log("sandbox", fmt.Sprintf("problem resetting customer=%s: %v", customerID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		for _, accountID := range reset.counterparties {
+			if _, err := sb.svc.repo.repairAccountBalance(accountID, true); err != nil {
+				logger.Log("sandbox", fmt.Sprintf("problem repairing account=%s balance: %v", accountID, err), "requestID", moovhttp.GetRequestID(r))
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
+		logger.Log("sandbox", fmt.Sprintf("reset customer=%s: deleted %d accounts and %d transactions", customerID, reset.Accounts, reset.Transactions), "requestID", moovhttp.GetRequestID(r))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(reset)
+	}
+}
+
+type createSandboxSnapshotRequest struct {
+	Name string `json:"name"`
+}
+
+// sandboxSnapshots lists snapshots (GET) or snapshots the ledger and sandbox clock (POST).
+func sandboxSnapshots(logger log.Logger, sb *sandbox, repo sandboxRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			snapshots, err := repo.getSnapshots()
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if snapshots == nil {
+				snapshots = []*sandboxSnapshot{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(snapshots)
+
+		case "POST":
+			var req createSandboxSnapshotRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+
+			sb.mu.Lock()
+			defer sb.mu.Unlock()
+
+			snapshot := &sandboxSnapshot{Name: req.Name, ClockOffset: sb.clock.getOffset(), CreatedAt: time.Now()}
+			snapshot.Offset = snapshot.ClockOffset.String()
+			if err := repo.createSnapshot(snapshot); err != nil {
+				logger.Log("sandbox", fmt.Sprintf("problem creating snapshot=%s: %v", req.Name, err), "requestID", moovhttp.GetRequestID(r))
+				moovhttp.Problem(w, err)
+				return
+			}
+			logger.Log("sandbox", fmt.Sprintf("created snapshot=%s", req.Name), "requestID", moovhttp.GetRequestID(r))
+
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(snapshot)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// restoreSandboxSnapshot replaces the ledger with a snapshot and moves the sandbox clock back to when it was taken.
+func restoreSandboxSnapshot(logger log.Logger, sb *sandbox, repo sandboxRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		name := mux.Vars(r)["name"]
+
+		sb.mu.Lock()
+		defer sb.mu.Unlock()
+
+		snapshot, err := repo.restoreSnapshot(name)
+		if err != nil {
+			logger.Log("sandbox", fmt.Sprintf("problem restoring snapshot=%s: %v", name, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if snapshot == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		sb.clock.setOffset(snapshot.ClockOffset)
+		logger.Log("sandbox", fmt.Sprintf("restored snapshot=%s", name), "requestID", moovhttp.GetRequestID(r))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(snapshot)
+	}
+}
+
+func deleteSandboxSnapshot(logger log.Logger, repo sandboxRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "DELETE" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		name := mux.Vars(r)["name"]
+		if err := repo.deleteSnapshot(name); err != nil {
+			logger.Log("sandbox", fmt.Sprintf("problem deleting snapshot=%s: %v", name, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestSandbox__fixtures(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	accountRepo := createTestSqlAccountRepository(t, db.DB)
+	ledger := &accounts.Account{ID: "ledger", CustomerID: "bank", AccountNumber: "1", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+	if err := accountRepo.CreateAccount(ledger.CustomerID, ledger); err != nil {
+		t.Fatal(err)
+	}
+	sb := &sandbox{
+		logger:          log.NewNopLogger(),
+		clock:           &sandboxClock{wall: time.Now},
+		accountRepo:     accountRepo,
+		svc:             &transactionService{logger: log.NewNopLogger(), repo: accountRepo.transactionRepo, events: &mockEventPublisher{}},
+		ledgerAccountID: "ledger",
+	}
+	repo := &sqlSandboxRepository{db.DB, log.NewNopLogger()}
+
+	highVolumeTransactions = 20
+	defer func() { highVolumeTransactions = 500 }()
+
+	router := mux.NewRouter()
+	router.Path("/sandbox/clock/advance").HandlerFunc(advanceSandboxClock(log.NewNopLogger(), sb))
+	router.Path("/sandbox/scenarios").HandlerFunc(loadSandboxScenario(log.NewNopLogger(), sb))
+	router.Path("/sandbox/customers/{customerId}").HandlerFunc(resetSandboxCustomer(log.NewNopLogger(), sb, repo))
+	router.Path("/sandbox/snapshots").HandlerFunc(sandboxSnapshots(log.NewNopLogger(), sb, repo))
+	router.Path("/sandbox/snapshots/{name}").HandlerFunc(deleteSandboxSnapshot(log.NewNopLogger(), repo))
+	router.Path("/sandbox/snapshots/{name}/restore").HandlerFunc(restoreSandboxSnapshot(log.NewNopLogger(), sb, repo))
+
+	serve := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: bogus HTTP status: %d: %s", method, path, w.Code, w.Body.String())
+		}
+		return w
+	}
+	loadScenario := func(name string) *accounts.Account {
+		t.Helper()
+		var resp sandboxScenarioResponse
+		if err := json.NewDecoder(serve("POST", "/sandbox/scenarios", `{"scenario": "`+name+`", "customerId": "customer"}`).Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Scenario != name || len(resp.Accounts) != 1 {
+			t.Fatalf("unexpected response: %#v", resp)
+		}
+		return resp.Accounts[0]
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/sandbox/scenarios", strings.NewReader(`{"scenario": "bankrupt", "customerId": "customer"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	nsf := loadScenario("nsf-history")
+	if nsf.Balance != 2600 { // 5000 - 6 * 400, the three failed debits are ignored
+		t.Errorf("unexpected balance: %d", nsf.Balance)
+	}
+	transactions, _, err := sb.svc.GetAccountTransactions(context.Background(), nsf.ID, transactionPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := 0
+	for i := range transactions {
+		if transactions[i].Status == TransactionFailed {
+			failed++
+		}
+	}
+	if len(transactions) != 10 || failed != 3 {
+		t.Errorf("got %d transactions with %d failed", len(transactions), failed)
+	}
+
+	if frozen := loadScenario("frozen-account"); frozen.Status != "frozen" || frozen.Balance != 125000 {
+		t.Errorf("unexpected account: %#v", frozen)
+	}
+
+	// snapshot, then load another scenario and restore
+	serve("POST", "/sandbox/clock/advance", `{"duration": "24h"}`)
+	serve("POST", "/sandbox/snapshots", `{"name": "two_accounts"}`)
+	highVolume := loadScenario("high-volume")
+	serve("POST", "/sandbox/clock/advance", `{"duration": "24h"}`)
+
+	var snapshot sandboxSnapshot
+	if err := json.NewDecoder(serve("POST", "/sandbox/snapshots/two_accounts/restore", "").Body).Decode(&snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.Offset != "24h0m0s" || sb.clock.getOffset() != 24*time.Hour {
+		t.Errorf("snapshot=%#v offset=%v", snapshot, sb.clock.getOffset())
+	}
+	if accts, _ := accountRepo.GetAccounts(context.Background(), []string{highVolume.ID}); len(accts) != 0 {
+		t.Errorf("high-volume account wasn't removed: %#v", accts)
+	}
+
+	// resetting the customer removes their accounts and leaves the ledger balanced
+	var reset customerReset
+	if err := json.NewDecoder(serve("DELETE", "/sandbox/customers/customer", "").Body).Decode(&reset); err != nil {
+		t.Fatal(err)
+	}
+	if reset.Accounts != 2 || reset.Transactions != 12 {
+		t.Errorf("unexpected reset: %#v", reset)
+	}
+	accts, err := accountRepo.GetAccounts(context.Background(), []string{nsf.ID, "ledger"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accts) != 1 || accts[0].ID != "ledger" || accts[0].Balance != 0 {
+		t.Errorf("unexpected accounts: %#v", accts)
+	}
+
+	serve("DELETE", "/sandbox/snapshots/two_accounts", "")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/sandbox/snapshots/two_accounts/restore", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// sandboxTables hold the ledger's state, which is what customers are reset from and snapshots copy.
+var sandboxTables = []string{
+	"accounts",
+	"account_balances",
+	"account_daily_summaries",
+	"account_statements",
+	"account_webhooks",
+	"transactions",
+	"transaction_lines",
+	"transaction_lines_archive",
+	"transaction_attachments",
+	"transaction_tags",
+}
+
+var (
+	// snapshotNameRegex limits names so they can be part of table names (which MySQL limits to 64 characters)
+	snapshotNameRegex = regexp.MustCompile(`^[a-z0-9_]{1,20}$`)
+
+	errDuplicateSnapshot = errors.New("snapshot already exists")
+)
+
+// sandboxSnapshot is a copy of the ledger's state, and the sandbox clock, which can be restored later.
+type sandboxSnapshot struct {
+	Name string `json:"name"`
+
+	// ClockOffset is how far the sandbox clock was ahead of the wall clock
+	ClockOffset time.Duration `json:"-"`
+	Offset      string        `json:"clockOffset"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+func validateSnapshotName(name string) error {
+	if !snapshotNameRegex.MatchString(name) {
+		return fmt.Errorf("snapshot name %q must be 1 to 20 lowercase letters, digits or underscores", name)
+	}
+	return nil
+}
+
+func snapshotTable(name, table string) string {
+	return fmt.Sprintf("sandbox_snapshot_%s_%s", name, table)
+}
+
+// customerReset is what was deleted when resetting a customer's data.
+type customerReset struct {
+	CustomerID   string `json:"customerId"`
+	Accounts     int    `json:"accounts"`
+	Transactions int    `json:"transactions"`
+
+	// counterparties are accounts of other customers which shared a deleted transaction
+	counterparties []string
+}
+
+type sandboxRepository interface {
+	Close() error
+
+	// resetCustomer deletes a customer's accounts and every transaction posted against them.
+	resetCustomer(customerID string) (*customerReset, error)
+
+	// createSnapshot copies the ledger's tables, returning errDuplicateSnapshot if the name is taken.
+	createSnapshot(snapshot *sandboxSnapshot) error
+	getSnapshots() ([]*sandboxSnapshot, error)
+
+	// restoreSnapshot replaces the ledger's tables with the snapshot's copies, returning nil if it doesn't exist.
+	restoreSnapshot(name string) (*sandboxSnapshot, error)
+	deleteSnapshot(name string) error
+}
+
+type sqlSandboxRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlSandboxRepository) Close() error {
+	return r.db.Close()
+}
+
+// sandboxDeleteBatchSize keeps the placeholders of each delete under SQLite's limit
+const sandboxDeleteBatchSize = 100
+
+// deleteIn deletes rows of table whose column is one of ids, in batches.
+func deleteIn(tx *sql.Tx, table, column string, ids []string) error {
+	for len(ids) > 0 {
+		n := len(ids)
+		if n > sandboxDeleteBatchSize {
+			n = sandboxDeleteBatchSize
+		}
+		args := make([]interface{}, n)
+		for i := range ids[:n] {
+			args[i] = ids[i]
+		}
+		query := fmt.Sprintf(`delete from %s where %s in (?%s);`, table, column, strings.Repeat(", ?", n-1))
+		if _, err := tx.Exec(query, args...); err != nil {
+			return fmt.Errorf("%s: %v", table, err)
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
+
+func queryStrings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlSandboxRepository) resetCustomer(customerID string) (*customerReset, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("resetCustomer: begin: %v", err)
+	}
+	reset := &customerReset{CustomerID: customerID}
+
+	accountIDs, err := queryStrings(tx, `select account_id from accounts where customer_id = ?;`, customerID)
+	if err != nil {
+		return nil, fmt.Errorf("resetCustomer: accounts: error=%v rollback=%v", err, tx.Rollback())
+	}
+	owned := make(map[string]bool)
+	for i := range accountIDs {
+		owned[accountIDs[i]] = true
+	}
+
+	var transactionIDs []string
+	counterparties := make(map[string]bool)
+	for i := range accountIDs {
+		ids, err := queryStrings(tx, `select transaction_id from transaction_lines where account_id = ?
+union select transaction_id from transaction_lines_archive where account_id = ?;`, accountIDs[i], accountIDs[i])
+		if err != nil {
+			return nil, fmt.Errorf("resetCustomer: account=%s transactions: error=%v rollback=%v", accountIDs[i], err, tx.Rollback())
+		}
+		for j := range ids {
+			others, err := queryStrings(tx, `select account_id from transaction_lines where transaction_id = ?
+union select account_id from transaction_lines_archive where transaction_id = ?;`, ids[j], ids[j])
+			if err != nil {
+				return nil, fmt.Errorf("resetCustomer: transaction=%s lines: error=%v rollback=%v", ids[j], err, tx.Rollback())
+			}
+			for k := range others {
+				if !owned[others[k]] {
+					counterparties[others[k]] = true
+				}
+			}
+		}
+		transactionIDs = append(transactionIDs, ids...)
+	}
+	transactionIDs = uniqueStrings(transactionIDs)
+
+	for _, table := range []string{"transaction_lines", "transaction_lines_archive", "transaction_attachments", "transaction_tags", "transactions"} {
+		if err := deleteIn(tx, table, "transaction_id", transactionIDs); err != nil {
+			return nil, fmt.Errorf("resetCustomer: error=%v rollback=%v", err, tx.Rollback())
+		}
+	}
+	for _, table := range []string{"account_balances", "account_daily_summaries", "account_statements", "account_webhooks", "accounts"} {
+		if err := deleteIn(tx, table, "account_id", accountIDs); err != nil {
+			return nil, fmt.Errorf("resetCustomer: error=%v rollback=%v", err, tx.Rollback())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("resetCustomer: commit: %v", err)
+	}
+
+	reset.Accounts, reset.Transactions = len(accountIDs), len(transactionIDs)
+	for id := range counterparties {
+		reset.counterparties = append(reset.counterparties, id)
+	}
+	return reset, nil
+}
+
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for i := range in {
+		if !seen[in[i]] {
+			seen[in[i]] = true
+			out = append(out, in[i])
+		}
+	}
+	return out
+}
+
+func (r *sqlSandboxRepository) createSnapshot(snapshot *sandboxSnapshot) error {
+	if err := validateSnapshotName(snapshot.Name); err != nil {
+		return err
+	}
+	query := `insert into sandbox_snapshots (name, clock_offset, created_at) values (?, ?, ?);`
+	if _, err := r.db.Exec(query, snapshot.Name, int64(snapshot.ClockOffset), snapshot.CreatedAt); err != nil {
+		if classifyStorageError(err) == storageErrorConstraint {
+			return errDuplicateSnapshot
+		}
+		return fmt.Errorf("createSnapshot: snapshot=%s: %v", snapshot.Name, err)
+	}
+	for _, table := range sandboxTables {
+		dst := snapshotTable(snapshot.Name, table)
+		if _, err := r.db.Exec(fmt.Sprintf(`drop table if exists %s;`, dst)); err != nil {
+			return fmt.Errorf("createSnapshot: snapshot=%s drop %s: %v", snapshot.Name, table, err)
+		}
+		if _, err := r.db.Exec(fmt.Sprintf(`create table %s as select * from %s;`, dst, table)); err != nil {
+			return fmt.Errorf("createSnapshot: snapshot=%s copy %s: %v", snapshot.Name, table, err)
+		}
+	}
+	return nil
+}
+
+func (r *sqlSandboxRepository) getSnapshots() ([]*sandboxSnapshot, error) {
+	return r.querySnapshots(``)
+}
+
+func (r *sqlSandboxRepository) querySnapshots(where string, args ...interface{}) ([]*sandboxSnapshot, error) {
+	rows, err := r.db.Query(fmt.Sprintf(`select name, clock_offset, created_at from sandbox_snapshots %s order by created_at desc;`, where), args...)
+	if err != nil {
+		return nil, fmt.Errorf("getSnapshots: %v", err)
+	}
+	defer rows.Close()
+
+	var out []*sandboxSnapshot
+	for rows.Next() {
+		var snapshot sandboxSnapshot
+		var offset int64
+		if err := rows.Scan(&snapshot.Name, &offset, &snapshot.CreatedAt); err != nil {
+			return nil, fmt.Errorf("getSnapshots: scan: %v", err)
+		}
+		snapshot.ClockOffset = time.Duration(offset)
+		snapshot.Offset = snapshot.ClockOffset.String()
+		out = append(out, &snapshot)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlSandboxRepository) restoreSnapshot(name string) (*sandboxSnapshot, error) {
+	if err := validateSnapshotName(name); err != nil {
+		return nil, err
+	}
+	snapshots, err := r.querySnapshots(`where name = ?`, name)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("restoreSnapshot: begin: %v", err)
+	}
+	for _, table := range sandboxTables {
+		if _, err := tx.Exec(fmt.Sprintf(`delete from %s;`, table)); err != nil {
+			return nil, fmt.Errorf("restoreSnapshot: snapshot=%s delete %s: error=%v rollback=%v", name, table, err, tx.Rollback())
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`insert into %s select * from %s;`, table, snapshotTable(name, table))); err != nil {
+			return nil, fmt.Errorf("restoreSnapshot: snapshot=%s restore %s: error=%v rollback=%v", name, table, err, tx.Rollback())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("restoreSnapshot: commit: %v", err)
+	}
+	return snapshots[0], nil
+}
+
+func (r *sqlSandboxRepository) deleteSnapshot(name string) error {
+	if err := validateSnapshotName(name); err != nil {
+		return err
+	}
+	for _, table := range sandboxTables {
+		if _, err := r.db.Exec(fmt.Sprintf(`drop table if exists %s;`, snapshotTable(name, table))); err != nil {
+			return fmt.Errorf("deleteSnapshot: snapshot=%s drop %s: %v", name, table, err)
+		}
+	}
+	if _, err := r.db.Exec(`delete from sandbox_snapshots where name = ?;`, name); err != nil {
+		return fmt.Errorf("deleteSnapshot: snapshot=%s: %v", name, err)
+	}
+	return nil
+}
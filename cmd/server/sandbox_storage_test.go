@@ -0,0 +1,119 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestValidateSnapshotName(t *testing.T) {
+	if err := validateSnapshotName("suite_1"); err != nil {
+		t.Error(err)
+	}
+	for _, name := range []string{"", "Suite", "drop table;", "a_name_which_is_far_too_long"} {
+		if err := validateSnapshotName(name); err == nil {
+			t.Errorf("expected error for %q", name)
+		}
+	}
+}
+
+func TestSqlSandboxRepository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, db *sql.DB) {
+		repo := &sqlSandboxRepository{db, log.NewNopLogger()}
+		accountRepo := createTestSqlAccountRepository(t, db)
+		transactionRepo := accountRepo.transactionRepo
+
+		for _, acct := range []*accounts.Account{{ID: "account", CustomerID: "customer"}, {ID: "ledger", CustomerID: "bank"}} {
+			acct.AccountNumber, acct.RoutingNumber, acct.Status, acct.Type = base.ID()[:8], defaultRoutingNumber, "open", "checking"
+			if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tx := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Status:    TransactionPosted,
+			Lines: []transactionLine{
+				{AccountID: "account", Purpose: ACHCredit, Amount: 500},
+				{AccountID: "ledger", Purpose: ACHDebit, Amount: 500},
+			},
+		}
+		if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Fatal(err)
+		}
+
+		snapshot := &sandboxSnapshot{Name: "suite", ClockOffset: time.Hour, CreatedAt: time.Now()}
+		if err := repo.createSnapshot(snapshot); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.createSnapshot(snapshot); err != errDuplicateSnapshot {
+			t.Errorf("unexpected error: %v", err)
+		}
+		snapshots, err := repo.getSnapshots()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(snapshots) != 1 || snapshots[0].Name != "suite" || snapshots[0].Offset != "1h0m0s" {
+			t.Errorf("unexpected snapshots: %#v", snapshots)
+		}
+
+		reset, err := repo.resetCustomer("customer")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reset.Accounts != 1 || reset.Transactions != 1 || len(reset.counterparties) != 1 || reset.counterparties[0] != "ledger" {
+			t.Errorf("unexpected reset: %#v", reset)
+		}
+		if accts, _ := accountRepo.GetAccounts(context.Background(), []string{"account"}); len(accts) != 0 {
+			t.Errorf("account wasn't deleted: %#v", accts)
+		}
+		if tx, _ := transactionRepo.getTransaction(context.Background(), tx.ID); tx != nil {
+			t.Errorf("transaction wasn't deleted: %#v", tx)
+		}
+
+		restored, err := repo.restoreSnapshot("suite")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restored == nil || restored.ClockOffset != time.Hour {
+			t.Errorf("unexpected snapshot: %#v", restored)
+		}
+		accts, err := accountRepo.GetAccounts(context.Background(), []string{"account"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(accts) != 1 || accts[0].Balance != 500 {
+			t.Errorf("unexpected accounts: %#v", accts)
+		}
+
+		if err := repo.deleteSnapshot("suite"); err != nil {
+			t.Fatal(err)
+		}
+		if restored, err := repo.restoreSnapshot("suite"); restored != nil || err != nil {
+			t.Errorf("snapshot=%#v error=%v", restored, err)
+		}
+	}
+
+	// SQLite tests
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, sqliteDB.DB)
+
+	// MySQL tests
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, mysqlDB.DB)
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestMonthEnds(t *testing.T) {
+	from := time.Date(2020, time.January, 15, 0, 0, 0, 0, time.UTC)
+
+	if ends := monthEnds(from, from.Add(24*time.Hour)); len(ends) != 0 {
+		t.Errorf("unexpected month ends: %v", ends)
+	}
+	// months end in every time zone 12 hours after they end in UTC
+	ends := monthEnds(from, time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC))
+	if len(ends) != 2 || !ends[0].Equal(time.Date(2020, time.February, 1, 12, 0, 0, 0, time.UTC)) || !ends[1].Equal(time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected month ends: %v", ends)
+	}
+	if ends := monthEnds(from, time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)); len(ends) != 1 {
+		t.Errorf("unexpected month ends: %v", ends)
+	}
+}
+
+func TestSandbox__advance(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	accountRepo := createTestSqlAccountRepository(t, db.DB)
+	transactionRepo := accountRepo.transactionRepo
+	for i, id := range []string{"account", "ledger", "expense", "income"} {
+		acct := &accounts.Account{ID: id, AccountNumber: fmt.Sprintf("%d", i), RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+		if err := accountRepo.CreateAccount(base.ID(), acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the virtual clock starts after the accounts were created
+	wall := time.Date(time.Now().Year()+1, time.January, 15, 0, 0, 0, 0, time.UTC)
+	deposit := transaction{
+		ID:        base.ID(),
+		Timestamp: wall,
+		Status:    TransactionPosted,
+		Lines:     []transactionLine{{AccountID: "account", Purpose: ACHCredit, Amount: 100000}},
+	}
+	if err := transactionRepo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+		t.Fatal(err)
+	}
+	pending := transaction{
+		ID:        base.ID(),
+		Timestamp: wall,
+		Status:    TransactionPending,
+		Lines: []transactionLine{
+			{AccountID: "account", Purpose: ACHDebit, Amount: 500},
+			{AccountID: "ledger", Purpose: ACHCredit, Amount: 500},
+		},
+	}
+	if err := transactionRepo.createTransaction(context.Background(), pending, createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the engines read the sandbox's clock
+	clock := &sandboxClock{wall: func() time.Time { return wall }}
+	fees, products := createTestFeeEngine(t, accountRepo, transactionRepo)
+	fees.now = clock.Now
+	fees.lastMonth = wall.AddDate(0, -1, 0).Format("2006-01") // the fee job already charged December
+	createTestProduct(t, products, "account", productSettings{
+		InterestRateTiers: []interestTier{{MinBalance: 0, Rate: 0.12}},
+		MonthlyFees:       []monthlyFee{{Name: "maintenance", Amount: 500, WaiveAbove: 1000000}},
+		TimeZone:          "UTC",
+	})
+	svc := &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}}
+	interest := &interestService{
+		logger:           log.NewNopLogger(),
+		repo:             &sqlInterestRepository{db.DB, log.NewNopLogger()},
+		accounts:         accountRepo,
+		transactions:     svc,
+		expenseAccountID: "expense",
+		rules:            fees.rules,
+		now:              clock.Now,
+	}
+	sb, err := newSandbox(log.NewNopLogger(), clock, accountRepo, svc, interest, fees, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := mux.NewRouter()
+	router.Path("/sandbox/clock").HandlerFunc(getSandboxClock(log.NewNopLogger(), sb))
+	router.Path("/sandbox/clock/advance").HandlerFunc(advanceSandboxClock(log.NewNopLogger(), sb))
+
+	// nothing is released within the availability delay
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/sandbox/clock/advance", strings.NewReader(`{"duration": "24h"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var result sandboxAdvance
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Released != 0 || len(result.MonthsClosed) != 0 || result.Interest != 0 || result.Fees != 0 || !result.To.Equal(wall.Add(24*time.Hour)) {
+		t.Errorf("unexpected result: %#v", result)
+	}
+
+	// the pending transaction is released before January closes, then its interest is paid and fees charged
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/sandbox/clock/advance", strings.NewReader(`{"duration": "744h"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	january := wall.Format("2006-01")
+	paid := int64(math.Round(17 * 99500 * dailyRate(0.12))) // January 15th through 31st
+	if result.Released != 1 || result.Interest != paid || result.Fees != 500 || len(result.Failures) != 0 {
+		t.Errorf("unexpected result: %#v", result)
+	}
+	if len(result.MonthsClosed) != 1 || result.MonthsClosed[0] != january {
+		t.Errorf("unexpected months closed: %v", result.MonthsClosed)
+	}
+	if out, err := interest.interest(context.Background(), "account", january); err != nil || out.Posting == nil || len(out.Accruals) != 17 {
+		t.Errorf("interest=%#v error=%v", out, err)
+	}
+	if day, err := interest.repo.lastAccruedDay(); err != nil || day != wall.AddDate(0, 1, -1).Format("2006-01-02") {
+		t.Errorf("last accrued day=%q error=%v", day, err)
+	}
+
+	accts, err := accountRepo.GetAccounts(context.Background(), []string{"account", "ledger", "expense", "income"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int64{"account": 99000 + paid, "ledger": 500, "expense": -paid, "income": 500}
+	for _, acct := range accts {
+		if int64(acct.Balance) != expected[acct.ID] {
+			t.Errorf("account=%s balance=%d expected %d", acct.ID, acct.Balance, expected[acct.ID])
+		}
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/sandbox/clock", nil))
+	var now sandboxClockResponse
+	if err := json.NewDecoder(w.Body).Decode(&now); err != nil {
+		t.Fatal(err)
+	}
+	if now.Offset != "768h0m0s" || !now.Now.Equal(wall.Add(768*time.Hour)) {
+		t.Errorf("unexpected clock: %#v", now)
+	}
+}
+
+func TestSandbox__advanceErr(t *testing.T) {
+	sb := &sandbox{logger: log.NewNopLogger(), clock: &sandboxClock{wall: time.Now}}
+	if _, err := sb.advance(context.Background(), -1*time.Hour); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := sb.advance(context.Background(), 2*maxSandboxAdvance); err == nil {
+		t.Error("expected error")
+	}
+
+	w := httptest.NewRecorder()
+	advanceSandboxClock(log.NewNopLogger(), sb)(w, httptest.NewRequest("POST", "/sandbox/clock/advance", strings.NewReader(`{"duration": "a month"}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	advanceSandboxClock(log.NewNopLogger(), sb)(w, httptest.NewRequest("GET", "/sandbox/clock/advance", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
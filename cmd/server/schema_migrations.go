@@ -0,0 +1,101 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+// databaseMigrations are the migrations of one database the server migrates on startup.
+type databaseMigrations struct {
+	Database   string                    `json:"database"`
+	Migrations []database.MigrationState `json:"migrations"`
+}
+
+// migrationDatabase is a database the server migrates on startup, opened without migrating it.
+type migrationDatabase struct {
+	name string
+	open func(ctx context.Context) (*sql.DB, error)
+}
+
+// migrationDatabases returns every database the server migrates on startup. TRANSACTION_STORAGE_TYPE's database holds
+// transactions and every other table, and is named ledger when accounts are stored in it too. ACCOUNT_STORAGE_TYPE's
+// holds accounts when it's a different engine and, with STORAGE_SHARDS, each shard of either engine holds its
+// accounts and transactions.
+func migrationDatabases(logger log.Logger) ([]migrationDatabase, error) {
+	inMemory, err := memoryStorage()
+	if err != nil {
+		return nil, err
+	}
+	if inMemory {
+		return nil, errors.New("memory storage has no migrations")
+	}
+	type engine struct{ name, _type string }
+	engines := []engine{{"ledger", or(os.Getenv("TRANSACTION_STORAGE_TYPE"), "sqlite")}}
+	if accountType := or(os.Getenv("ACCOUNT_STORAGE_TYPE"), "sqlite"); !strings.EqualFold(accountType, engines[0]._type) {
+		engines = []engine{{"transactions", engines[0]._type}, {"accounts", accountType}}
+	}
+
+	var out []migrationDatabase
+	for _, e := range engines {
+		e := e
+		out = append(out, migrationDatabase{name: e.name, open: func(ctx context.Context) (*sql.DB, error) {
+			return database.NewWithoutMigrations(ctx, logger, e._type)
+		}})
+	}
+	if shards := storageShards(); shards > 1 {
+		for _, e := range engines {
+			for shard := 0; shard < shards; shard++ {
+				e, shard := e, shard
+				out = append(out, migrationDatabase{name: fmt.Sprintf("%s shard %d", e.name, shard), open: func(ctx context.Context) (*sql.DB, error) {
+					return database.NewShardWithoutMigrations(ctx, logger, e._type, shard)
+				}})
+			}
+		}
+	}
+	return out, nil
+}
+
+// rollbackDatabases reverts the migrations of every database the server migrates after version, unless it's
+// negative, and returns the migrations of each. It stops at the first database which can't be rolled back.
+func rollbackDatabases(ctx context.Context, logger log.Logger, version int) ([]databaseMigrations, error) {
+	databases, err := migrationDatabases(logger)
+	if err != nil {
+		return nil, err
+	}
+	var out []databaseMigrations
+	for _, d := range databases {
+		states, err := rollbackDatabase(ctx, logger, d, version)
+		if err != nil {
+			return out, fmt.Errorf("%s database: %v", d.name, err)
+		}
+		out = append(out, databaseMigrations{Database: d.name, Migrations: states})
+	}
+	return out, nil
+}
+
+func rollbackDatabase(ctx context.Context, logger log.Logger, d migrationDatabase, version int) ([]database.MigrationState, error) {
+	db, err := d.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	if version >= 0 {
+		if err := database.Rollback(ctx, log.With(logger, "database", d.name), db, version); err != nil {
+			return nil, err
+		}
+	}
+	return database.MigrationStatus(ctx, db)
+}
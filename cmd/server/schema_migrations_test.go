@@ -0,0 +1,70 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestRollbackDatabases(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accounts-migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Setenv("SQLITE_DB_PATH", filepath.Join(dir, "accounts.db"))
+	os.Setenv("STORAGE_SHARDS", "2")
+	defer os.Unsetenv("SQLITE_DB_PATH")
+	defer os.Unsetenv("STORAGE_SHARDS")
+
+	// the server migrates the ledger database and each shard
+	ctx, logger := context.Background(), log.NewNopLogger()
+	db, err := database.New(ctx, logger, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	for shard := 0; shard < 2; shard++ {
+		db, err := database.NewShard(ctx, logger, "sqlite", shard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Close()
+	}
+
+	migrations, err := rollbackDatabases(ctx, logger, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 3 || migrations[0].Database != "ledger" || migrations[2].Database != "ledger shard 1" {
+		t.Fatalf("unexpected databases: %#v", migrations)
+	}
+
+	// every database is rolled back
+	version := len(migrations[0].Migrations) - 1
+	if migrations, err = rollbackDatabases(ctx, logger, version); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range migrations {
+		if m.Migrations[version-1].AppliedAt == nil || m.Migrations[version].AppliedAt != nil {
+			t.Errorf("%s: unexpected migrations: %#v", m.Database, m.Migrations[version-1:])
+		}
+	}
+
+	os.Setenv("ACCOUNT_STORAGE_TYPE", "memory")
+	defer os.Unsetenv("ACCOUNT_STORAGE_TYPE")
+	if _, err := rollbackDatabases(ctx, logger, -1); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Settlement netting nets the obligations accumulated between this program and each ledger peer during the day.
+// Transfers to a peer credit its settlement account here and transfers from the peer debit it, so at each cutoff
+// the account's unnetted lines are the gross flows in each direction. One settlement transaction per peer moves
+// their net amount between the peer's settlement account and NETTING_SETTLEMENT_ACCOUNT_ID, which tracks what's
+// owed over the external settlement rail.
+//
+// Each run is recorded along with the transactions it netted before its settlement transaction is posted, so an
+// interrupted run is settled by the next one rather than netting the same transactions twice.
+
+// readNettingCutoff reads NETTING_CUTOFF, the time of day (HH:MM in LEDGER_TIME_ZONE) obligations are netted, as
+// an offset from midnight. It defaults to 17:00.
+func readNettingCutoff() (time.Duration, error) {
+	v := os.Getenv("NETTING_CUTOFF")
+	if v == "" {
+		return 17 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid NETTING_CUTOFF %q: %v", v, err)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// nextNettingCutoff returns the first cutoff after now, in UTC. Cutoffs are a time of day in loc, so they stay at the
+// same local time when daylight saving time starts or ends.
+func nextNettingCutoff(now time.Time, offset time.Duration, loc *time.Location) time.Time {
+	day, _ := dayIn(now, loc)
+	hour, minute := int(offset/time.Hour), int(offset%time.Hour/time.Minute)
+	for {
+		cutoff := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+		if cutoff.After(now) {
+			return cutoff.UTC()
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+}
+
+// nettingRun is one peer's netted obligations at a cutoff.
+type nettingRun struct {
+	ID   string `json:"id"`
+	Peer string `json:"peer"`
+
+	// AccountID is the peer's settlement account on this instance.
+	AccountID string    `json:"accountId"`
+	Cutoff    time.Time `json:"cutoff"`
+
+	// Payable is the gross amount transferred to the peer and Receivable the gross amount the peer transferred here.
+	Payable    int `json:"payable"`
+	Receivable int `json:"receivable"`
+	Gross      int `json:"gross"`
+
+	// Net is the amount owed to the peer, which is negative when the peer owes us.
+	Net          int `json:"net"`
+	Transactions int `json:"transactions"`
+
+	// SettlementTransactionID is empty when the obligations cancelled out and nothing was settled.
+	SettlementTransactionID string     `json:"settlementTransactionId,omitempty"`
+	SettledAt               *time.Time `json:"settledAt,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// settlementLines move the run's net amount out of the peer's settlement account when we owe the peer, or into it
+// when the peer owes us.
+func (run *nettingRun) settlementLines(settlementAccountID string) []transactionLine {
+	peer := transactionLine{AccountID: run.AccountID, Purpose: ACHDebit, Amount: run.Net}
+	settlement := transactionLine{AccountID: settlementAccountID, Purpose: ACHCredit, Amount: run.Net}
+	if run.Net < 0 {
+		peer.Purpose, peer.Amount = ACHCredit, -1*run.Net
+		settlement.Purpose, settlement.Amount = ACHDebit, -1*run.Net
+	}
+	return []transactionLine{peer, settlement}
+}
+
+// nettingReport compares the gross flows between peers with the net amounts settled at a cutoff.
+type nettingReport struct {
+	Cutoff time.Time     `json:"cutoff"`
+	Runs   []*nettingRun `json:"runs"`
+
+	Gross int `json:"gross"`
+	// Net is the total amount settled in either direction.
+	Net int `json:"net"`
+}
+
+func (r *nettingReport) add(run *nettingRun) {
+	r.Runs = append(r.Runs, run)
+	r.Gross += run.Gross
+	if run.Net < 0 {
+		r.Net += -1 * run.Net
+	} else {
+		r.Net += run.Net
+	}
+}
+
+// nettingReports groups runs (ordered by cutoff) into a report per cutoff.
+func nettingReports(runs []*nettingRun) []*nettingReport {
+	out := []*nettingReport{}
+	for _, run := range runs {
+		if len(out) == 0 || !out[len(out)-1].Cutoff.Equal(run.Cutoff) {
+			out = append(out, &nettingReport{Cutoff: run.Cutoff, Runs: []*nettingRun{}})
+		}
+		out[len(out)-1].add(run)
+	}
+	return out
+}
+
+type nettingService struct {
+	logger       log.Logger
+	repo         nettingRepository
+	transactions *transactionService
+	peers        map[string]*ledgerPeer
+
+	// settlementAccountID is the account net settlements with every peer are posted against.
+	settlementAccountID string
+
+	// mu keeps the nightly job and admin requests from netting at the same time
+	mu sync.Mutex
+}
+
+// net settles any interrupted runs and then nets each peer's obligations up to cutoff.
+func (s *nettingService) net(ctx context.Context, cutoff time.Time) (*nettingReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	unsettled, err := s.repo.getUnsettledNettingRuns()
+	if err != nil {
+		return nil, fmt.Errorf("net: %v", err)
+	}
+	for _, run := range unsettled {
+		if err := s.settle(ctx, run); err != nil {
+			return nil, fmt.Errorf("net: %v", err)
+		}
+	}
+
+	var names []string
+	for name := range s.peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := &nettingReport{Cutoff: cutoff, Runs: []*nettingRun{}}
+	for _, name := range names {
+		run, err := s.netPeer(ctx, s.peers[name], cutoff)
+		if err != nil {
+			return nil, fmt.Errorf("net: peer=%s: %v", name, err)
+		}
+		report.add(run)
+	}
+	return report, nil
+}
+
+// netPeer nets every posted (or since reversed) transaction on the peer's settlement account up to cutoff which
+// an earlier run hasn't netted. Transactions still held are netted once they're committed.
+func (s *nettingService) netPeer(ctx context.Context, peer *ledgerPeer, cutoff time.Time) (*nettingRun, error) {
+	netted, err := s.repo.getNettedTransactions(peer.Name)
+	if err != nil {
+		return nil, err
+	}
+	run := &nettingRun{
+		ID:        newID(),
+		Peer:      peer.Name,
+		AccountID: peer.LocalAccountID,
+		Cutoff:    cutoff,
+		CreatedAt: time.Now(),
+	}
+	var transactionIDs []string
+	err = eachAccountTransactionPage(ctx, s.transactions.repo, peer.LocalAccountID, transactionPage{}, func(transactions []transaction) error {
+		for i := range transactions {
+			tx := transactions[i]
+			if netted[tx.ID] || tx.Timestamp.After(cutoff) {
+				continue
+			}
+			if tx.Status != TransactionPosted && tx.Status != TransactionReversed {
+				continue
+			}
+			for _, line := range tx.Lines {
+				if line.AccountID != peer.LocalAccountID {
+					continue
+				}
+				if amt := lineAmount(line); amt > 0 {
+					run.Payable += amt
+				} else {
+					run.Receivable += -1 * amt
+				}
+			}
+			transactionIDs = append(transactionIDs, tx.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	run.Transactions = len(transactionIDs)
+	run.Gross = run.Payable + run.Receivable
+	run.Net = run.Payable - run.Receivable
+
+	// The settlement transaction is netted along with the obligations it settles so later runs skip it.
+	if run.Net != 0 {
+		run.SettlementTransactionID = newID()
+		transactionIDs = append(transactionIDs, run.SettlementTransactionID)
+	}
+	if err := s.repo.createNettingRun(run, transactionIDs); err != nil {
+		return nil, err
+	}
+	if run.Net != 0 {
+		if err := s.settle(ctx, run); err != nil {
+			return nil, err
+		}
+	}
+	return run, nil
+}
+
+// settle posts the run's settlement transaction, which may already exist if an earlier attempt was interrupted
+// before the run was marked settled.
+func (s *nettingService) settle(ctx context.Context, run *nettingRun) error {
+	tx := transaction{
+		ID:        run.SettlementTransactionID,
+		Timestamp: run.Cutoff,
+		Status:    TransactionPosted,
+		Lines:     run.settlementLines(s.settlementAccountID),
+	}
+	if err := s.transactions.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		if existing, _ := s.transactions.repo.getTransaction(ctx, tx.ID); existing == nil {
+			return fmt.Errorf("settle: run=%s: %v", run.ID, err)
+		}
+	} else {
+		s.transactions.publish(ctx, TransactionPosted, &tx)
+	}
+
+	now := time.Now()
+	if err := s.repo.markNettingRunSettled(run.ID, now); err != nil {
+		return fmt.Errorf("settle: %v", err)
+	}
+	run.SettledAt = &now
+	return nil
+}
+
+// setupNettingJob nets obligations at each day's cutoff until ctx is cancelled.
+func setupNettingJob(ctx context.Context, logger log.Logger, svc *nettingService, offset time.Duration) {
+	go func() {
+		for {
+			cutoff := nextNettingCutoff(time.Now(), offset, ledgerLocation)
+			t := time.NewTimer(time.Until(cutoff))
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+				report, err := svc.net(ctx, cutoff)
+				if err != nil {
+					logger.Log("netting", fmt.Sprintf("problem netting obligations at %v: %v", cutoff, err))
+					continue
+				}
+				logger.Log("netting", fmt.Sprintf("netted %d peers at %v: gross=%d net=%d", len(report.Runs), cutoff, report.Gross, report.Net))
+			}
+		}
+	}()
+}
+
+// nettingRuns is an admin route which lists reports of recent runs (GET), optionally for one ?peer, or nets
+// obligations up to now (POST) ahead of the next cutoff.
+func nettingRuns(logger log.Logger, svc *nettingService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			limit := 100
+			if v := r.URL.Query().Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					moovhttp.Problem(w, fmt.Errorf("invalid limit %q", v))
+					return
+				}
+				limit = n
+			}
+			runs, err := svc.repo.getNettingRuns(r.URL.Query().Get("peer"), limit)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(nettingReports(runs))
+
+		case "POST":
+			report, err := svc.net(r.Context(), time.Now())
+			if err != nil {
+				logger.Log("netting", fmt.Sprintf("problem netting obligations: %v", err))
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(report)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
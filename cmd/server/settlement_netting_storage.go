@@ -0,0 +1,153 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type nettingRepository interface {
+	Ping() error
+	Close() error
+
+	// createNettingRun records run along with the transactions it netted, which are excluded from later runs.
+	createNettingRun(run *nettingRun, transactionIDs []string) error
+	markNettingRunSettled(runID string, settledAt time.Time) error
+
+	// getNettingRuns returns the most recent runs, newest first. An empty peer returns runs for every peer.
+	getNettingRuns(peer string, limit int) ([]*nettingRun, error)
+
+	// getUnsettledNettingRuns returns runs whose settlement transaction hasn't been posted, oldest first.
+	getUnsettledNettingRuns() ([]*nettingRun, error)
+
+	// getNettedTransactions returns the IDs of every transaction already netted for peer.
+	getNettedTransactions(peer string) (map[string]bool, error)
+}
+
+type sqlNettingRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlNettingRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlNettingRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlNettingRepository) createNettingRun(run *nettingRun, transactionIDs []string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("createNettingRun: begin: %v", err)
+	}
+
+	var settlementTransactionID *string
+	if run.SettlementTransactionID != "" {
+		settlementTransactionID = &run.SettlementTransactionID
+	}
+	query := `insert into netting_runs (run_id, peer, account_id, cutoff, payable, receivable, net, transactions, settlement_transaction_id, settled_at, created_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = tx.Exec(query, run.ID, run.Peer, run.AccountID, run.Cutoff, run.Payable, run.Receivable, run.Net, run.Transactions, settlementTransactionID, run.SettledAt, run.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("createNettingRun: run=%s: %v rollback=%v", run.ID, err, tx.Rollback())
+	}
+
+	stmt, err := tx.Prepare(`insert into netted_transactions (peer, transaction_id, run_id) values (?, ?, ?);`)
+	if err != nil {
+		return fmt.Errorf("createNettingRun: prepare: %v rollback=%v", err, tx.Rollback())
+	}
+	defer stmt.Close()
+	for _, id := range transactionIDs {
+		if _, err := stmt.Exec(run.Peer, id, run.ID); err != nil {
+			return fmt.Errorf("createNettingRun: run=%s transaction=%s: %v rollback=%v", run.ID, id, err, tx.Rollback())
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("createNettingRun: commit: %v", err)
+	}
+	return nil
+}
+
+func (r *sqlNettingRepository) markNettingRunSettled(runID string, settledAt time.Time) error {
+	query := `update netting_runs set settled_at = ? where run_id = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("markNettingRunSettled: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(settledAt, runID); err != nil {
+		return fmt.Errorf("markNettingRunSettled: run=%s: %v", runID, err)
+	}
+	return nil
+}
+
+func (r *sqlNettingRepository) getNettingRuns(peer string, limit int) ([]*nettingRun, error) {
+	where, args := `1 = 1`, []interface{}{}
+	if peer != "" {
+		where, args = `peer = ?`, append(args, peer)
+	}
+	runs, err := r.queryNettingRuns(fmt.Sprintf(`%s order by cutoff desc, peer limit %d`, where, limit), args...)
+	if err != nil {
+		return nil, fmt.Errorf("getNettingRuns: %v", err)
+	}
+	return runs, nil
+}
+
+func (r *sqlNettingRepository) getUnsettledNettingRuns() ([]*nettingRun, error) {
+	runs, err := r.queryNettingRuns(`settlement_transaction_id is not null and settled_at is null order by cutoff, peer`)
+	if err != nil {
+		return nil, fmt.Errorf("getUnsettledNettingRuns: %v", err)
+	}
+	return runs, nil
+}
+
+func (r *sqlNettingRepository) queryNettingRuns(where string, args ...interface{}) ([]*nettingRun, error) {
+	query := fmt.Sprintf(`select run_id, peer, account_id, cutoff, payable, receivable, net, transactions, settlement_transaction_id, settled_at, created_at
+from netting_runs where %s;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*nettingRun
+	for rows.Next() {
+		var run nettingRun
+		var settlementTransactionID *string
+		if err := rows.Scan(&run.ID, &run.Peer, &run.AccountID, &run.Cutoff, &run.Payable, &run.Receivable, &run.Net, &run.Transactions, &settlementTransactionID, &run.SettledAt, &run.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		if settlementTransactionID != nil {
+			run.SettlementTransactionID = *settlementTransactionID
+		}
+		run.Gross = run.Payable + run.Receivable
+		out = append(out, &run)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlNettingRepository) getNettedTransactions(peer string) (map[string]bool, error) {
+	rows, err := r.db.Query(`select transaction_id from netted_transactions where peer = ?;`, peer)
+	if err != nil {
+		return nil, fmt.Errorf("getNettedTransactions: %v", err)
+	}
+	defer rows.Close()
+
+	out := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("getNettedTransactions: scan: %v", err)
+		}
+		out[id] = true
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestNetting__readNettingCutoff(t *testing.T) {
+	check := func(v string) (time.Duration, error) {
+		os.Setenv("NETTING_CUTOFF", v)
+		defer os.Unsetenv("NETTING_CUTOFF")
+		return readNettingCutoff()
+	}
+	if offset, err := check(""); err != nil || offset != 17*time.Hour {
+		t.Errorf("offset=%v error=%v", offset, err)
+	}
+	if offset, err := check("21:30"); err != nil || offset != 21*time.Hour+30*time.Minute {
+		t.Errorf("offset=%v error=%v", offset, err)
+	}
+	if _, err := check("9pm"); err == nil {
+		t.Error("expected error")
+	}
+
+	now := time.Date(2020, time.March, 4, 12, 0, 0, 0, time.UTC)
+	if cutoff := nextNettingCutoff(now, 17*time.Hour, time.UTC); !cutoff.Equal(time.Date(2020, time.March, 4, 17, 0, 0, 0, time.UTC)) {
+		t.Errorf("cutoff=%v", cutoff)
+	}
+	if cutoff := nextNettingCutoff(now, 9*time.Hour, time.UTC); !cutoff.Equal(time.Date(2020, time.March, 5, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("cutoff=%v", cutoff)
+	}
+
+	// cutoffs keep their local time across daylight saving time, which started on March 8th
+	la, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cutoff := nextNettingCutoff(time.Date(2020, time.March, 7, 23, 0, 0, 0, time.UTC), 9*time.Hour, la); !cutoff.Equal(time.Date(2020, time.March, 8, 16, 0, 0, 0, time.UTC)) {
+		t.Errorf("cutoff=%v", cutoff)
+	}
+}
+
+func TestNetting__net(t *testing.T) {
+	check := func(t *testing.T, repo *sqlNettingRepository) {
+		accountRepo, transactionRepo := createTestLedger(t, map[string]int{"customer": 5000, "program2": 0, "program3": 0, "settlement": 0})
+
+		svc := &nettingService{
+			logger:       log.NewNopLogger(),
+			repo:         repo,
+			transactions: &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}},
+			peers: map[string]*ledgerPeer{
+				"program2": {Name: "program2", LocalAccountID: "program2"},
+				"program3": {Name: "program3", LocalAccountID: "program3"},
+			},
+			settlementAccountID: "settlement",
+		}
+		ctx := context.Background()
+		cutoff := time.Now().Add(-time.Minute)
+
+		// transfers to a peer credit its settlement account and transfers from it debit the account
+		transfer := func(at time.Time, status TransactionStatus, peer string, amount int) *transaction {
+			t.Helper()
+			lines := []transactionLine{{AccountID: "customer", Purpose: ACHDebit, Amount: amount}, {AccountID: peer, Purpose: ACHCredit, Amount: amount}}
+			if amount < 0 {
+				lines = []transactionLine{{AccountID: peer, Purpose: ACHDebit, Amount: -1 * amount}, {AccountID: "customer", Purpose: ACHCredit, Amount: -1 * amount}}
+			}
+			tx := &transaction{ID: base.ID(), Timestamp: at, Status: status, Lines: lines}
+			if status == TransactionHeld {
+				expires := time.Now().Add(time.Hour)
+				tx.ExpiresAt = &expires
+			}
+			if err := transactionRepo.createTransaction(context.Background(), *tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+				t.Fatal(err)
+			}
+			return tx
+		}
+		transfer(cutoff.Add(-time.Hour), TransactionPosted, "program2", 300)
+		transfer(cutoff.Add(-time.Hour), TransactionPosted, "program2", 200)
+		transfer(cutoff.Add(-time.Hour), TransactionPosted, "program2", -150)
+		transfer(cutoff.Add(-time.Hour), TransactionPosted, "program3", -400)
+		held := transfer(cutoff.Add(-time.Hour), TransactionHeld, "program3", 100)
+		transfer(cutoff.Add(time.Second), TransactionPosted, "program3", 700) // after the cutoff
+
+		report, err := svc.net(ctx, cutoff)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Runs) != 2 || report.Gross != 1050 || report.Net != 750 {
+			t.Fatalf("unexpected report: %#v", report)
+		}
+		if run := report.Runs[0]; run.Peer != "program2" || run.Payable != 500 || run.Receivable != 150 || run.Net != 350 || run.Transactions != 3 || run.SettledAt == nil {
+			t.Errorf("unexpected run: %#v", run)
+		}
+		if run := report.Runs[1]; run.Peer != "program3" || run.Payable != 0 || run.Receivable != 400 || run.Net != -400 || run.Transactions != 1 {
+			t.Errorf("unexpected run: %#v", run)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"program2": 0, "program3": 700, "settlement": -50})
+
+		// the held transfer is netted once it's committed, along with transfers after the last cutoff
+		if err := transactionRepo.updateTransactionStatus(held.ID, TransactionPosted); err != nil {
+			t.Fatal(err)
+		}
+		report, err = svc.net(ctx, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Runs[0].Transactions != 0 || report.Runs[0].SettlementTransactionID != "" || report.Runs[1].Payable != 800 || report.Runs[1].Net != 800 {
+			t.Errorf("unexpected report: %#v", report)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"program2": 0, "program3": 0, "settlement": 750})
+
+		// runs interrupted before their settlement was posted are settled by the next run
+		unsettled := transfer(time.Now().Add(-time.Second), TransactionPosted, "program2", 50)
+		interrupted := &nettingRun{ID: base.ID(), Peer: "program2", AccountID: "program2", Cutoff: time.Now(), Payable: 50, Gross: 50, Net: 50, Transactions: 1, SettlementTransactionID: base.ID(), CreatedAt: time.Now()}
+		if err := svc.repo.createNettingRun(interrupted, []string{unsettled.ID, interrupted.SettlementTransactionID}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := svc.net(ctx, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if runs, err := svc.repo.getUnsettledNettingRuns(); err != nil || len(runs) != 0 {
+			t.Errorf("runs=%#v error=%v", runs, err)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"program2": 0, "settlement": 800})
+
+		// reports are grouped by cutoff, newest first
+		req := httptest.NewRequest("GET", "/netting/runs?peer=program3", nil)
+		w := httptest.NewRecorder()
+		nettingRuns(log.NewNopLogger(), svc)(w, req)
+		w.Flush()
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		var reports []*nettingReport
+		if err := json.NewDecoder(w.Body).Decode(&reports); err != nil {
+			t.Fatal(err)
+		}
+		if len(reports) != 3 || reports[2].Gross != 400 || reports[1].Net != 800 {
+			t.Errorf("unexpected reports: %#v", reports)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlNettingRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlNettingRepository{mysqlDB.DB, log.NewNopLogger()})
+}
@@ -0,0 +1,321 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/go-kit/kit/log"
+)
+
+// Copying accounts.db while the server writes to it captures pages from different moments and regularly produces
+// a corrupt file. Backups are instead taken online with VACUUM INTO, which writes a consistent snapshot of the
+// database from a read transaction, and every backup is integrity checked before it's kept. Restores check the
+// backup the same way before replacing the database, so a bad backup never takes the place of a good database.
+
+// sqliteBackup describes a backup of one SQLite database file.
+type sqliteBackup struct {
+	Database string    `json:"database"` // filename of the backed up database, such as accounts.db
+	Location string    `json:"location"` // filepath or s3:// URL the backup was written to
+	Size     int64     `json:"size"`
+	SHA256   string    `json:"sha256"`
+	Created  time.Time `json:"created"`
+
+	Verification *ledgerVerification `json:"verification"`
+}
+
+// sqliteBackupService backs up each SQLite ledger database to a directory or S3.
+type sqliteBackupService struct {
+	logger  log.Logger
+	ledgers []*sqlTransactionRepository
+
+	// target is where backups are written when a request doesn't name one, read from SQLITE_BACKUP_TARGET
+	target string
+
+	uploader s3manageriface.UploaderAPI
+
+	now func() time.Time
+}
+
+// Backup writes a verified backup of every SQLite ledger database (each shard is its own file) to target, which is
+// a directory or an s3://bucket/prefix URL. Backups are named after their database and the time they were taken.
+func (s *sqliteBackupService) Backup(ctx context.Context, target string) ([]sqliteBackup, error) {
+	if target == "" {
+		target = s.target
+	}
+	if target == "" {
+		return nil, errors.New("sqlite backup: no target, set SQLITE_BACKUP_TARGET or ?target")
+	}
+	if len(s.ledgers) == 0 {
+		return nil, errors.New("sqlite backup: transactions aren't stored in SQLite")
+	}
+
+	dir, err := ioutil.TempDir("", "accounts-backup")
+	if err != nil {
+		return nil, fmt.Errorf("sqlite backup: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now().UTC()
+	if s.now != nil {
+		now = s.now().UTC()
+	}
+	var out []sqliteBackup
+	for i := range s.ledgers {
+		source, err := sqliteDatabaseFile(ctx, s.ledgers[i])
+		if err != nil {
+			return nil, fmt.Errorf("sqlite backup: %v", err)
+		}
+		ext := filepath.Ext(source)
+		name := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(filepath.Base(source), ext), now.Format("20060102T150405Z"), ext)
+
+		scratch := filepath.Join(dir, name)
+		if _, err := s.ledgers[i].db.ExecContext(ctx, `vacuum into ?;`, scratch); err != nil {
+			return nil, fmt.Errorf("sqlite backup: vacuum %s: %v", source, err)
+		}
+		backup := sqliteBackup{Database: filepath.Base(source), Created: now}
+		backup.Verification, err = verifySQLiteFile(ctx, s.logger, scratch)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite backup: %s: %v", source, err)
+		}
+		if !backup.Verification.ok() {
+			return nil, fmt.Errorf("sqlite backup: %s failed verification: %s", source, strings.Join(backup.Verification.Problems, ", "))
+		}
+		backup.Size, backup.SHA256, err = sha256File(scratch)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite backup: %v", err)
+		}
+
+		backup.Location, err = s.store(ctx, scratch, target, name)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite backup: %v", err)
+		}
+		s.logger.Log("backup", fmt.Sprintf("backed up %s to %s", source, backup.Location))
+		out = append(out, backup)
+	}
+	return out, nil
+}
+
+// store moves the backup at scratch to target under name, returning where it was written.
+func (s *sqliteBackupService) store(ctx context.Context, scratch, target, name string) (string, error) {
+	if bucket, prefix, ok := parseS3URL(target); ok {
+		if s.uploader == nil {
+			sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+			if err != nil {
+				return "", fmt.Errorf("aws session: %v", err)
+			}
+			s.uploader = s3manager.NewUploader(sess)
+		}
+		fd, err := os.Open(scratch)
+		if err != nil {
+			return "", err
+		}
+		defer fd.Close()
+
+		key := path.Join(prefix, name)
+		_, err = s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   fd,
+		})
+		if err != nil {
+			return "", fmt.Errorf("uploading to s3://%s/%s: %v", bucket, key, err)
+		}
+		return fmt.Sprintf("s3://%s/%s", bucket, key), nil
+	}
+
+	if err := os.MkdirAll(target, 0700); err != nil {
+		return "", err
+	}
+	// Write next to the final file and rename it into place, so a backup file only exists once it's complete
+	dest := filepath.Join(target, name)
+	if err := copyFile(scratch, dest+".tmp"); err != nil {
+		return "", err
+	}
+	return dest, os.Rename(dest+".tmp", dest)
+}
+
+// sqliteDatabaseFile returns the filepath of the SQLite database repo is connected to.
+func sqliteDatabaseFile(ctx context.Context, repo *sqlTransactionRepository) (string, error) {
+	var file string
+	if err := repo.db.QueryRowContext(ctx, `select file from pragma_database_list where name = 'main';`).Scan(&file); err != nil {
+		return "", fmt.Errorf("finding database file: %v", err)
+	}
+	if file == "" {
+		return "", errors.New("in-memory databases can't be backed up")
+	}
+	return file, nil
+}
+
+// verifySQLiteFile runs SQLite's integrity check and then the ledger integrity checks against the database at path.
+func verifySQLiteFile(ctx context.Context, logger log.Logger, path string) (*ledgerVerification, error) {
+	db, err := database.SQLiteUnreplicatedConnection(logger, path).Connect(ctx)
+	if err != nil {
+		if db != nil {
+			db.Close()
+		}
+		return nil, fmt.Errorf("open %s: %v", path, err)
+	}
+	repo, err := setupSqlTransactionStorage(ctx, logger, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	defer repo.Close()
+
+	rows, err := db.QueryContext(ctx, `pragma integrity_check;`)
+	if err != nil {
+		return nil, fmt.Errorf("integrity check: %v", err)
+	}
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("integrity check: %v", err)
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("integrity check: %v", err)
+	}
+
+	result, err := verifyLedger(repo)
+	if err != nil {
+		return nil, err
+	}
+	result.Problems = append(problems, result.Problems...)
+	return result, nil
+}
+
+func sha256File(path string) (int64, string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer fd.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, fd)
+	if err != nil {
+		return 0, "", err
+	}
+	return n, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// parseS3URL splits s3://bucket/prefix into its bucket and prefix.
+func parseS3URL(raw string) (string, string, bool) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "s3" || u.Host == "" {
+		return "", "", false
+	}
+	return u.Host, strings.Trim(u.Path, "/"), true
+}
+
+// restoreSQLiteBackup replaces the SQLite database at dest with the backup at source (a filepath or s3:// URL) once
+// the backup passes its integrity checks. The server must be stopped first. The database being replaced, along with
+// its write-ahead log, is kept next to it with a .replaced-<timestamp> suffix.
+func restoreSQLiteBackup(ctx context.Context, logger log.Logger, downloader s3manageriface.DownloaderAPI, source, dest string) (*ledgerVerification, error) {
+	if source == "" || dest == "" {
+		return nil, errors.New("restore: backup and database paths are required")
+	}
+	// Stage the backup in the database's directory so it can be renamed into place
+	scratch := dest + ".restoring"
+	defer os.Remove(scratch)
+
+	if bucket, key, ok := parseS3URL(source); ok {
+		if downloader == nil {
+			sess, err := session.NewSessionWithOptions(session.Options{SharedConfigState: session.SharedConfigEnable})
+			if err != nil {
+				return nil, fmt.Errorf("restore: aws session: %v", err)
+			}
+			downloader = s3manager.NewDownloader(sess)
+		}
+		fd, err := os.Create(scratch)
+		if err != nil {
+			return nil, fmt.Errorf("restore: %v", err)
+		}
+		_, err = downloader.DownloadWithContext(ctx, fd, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		if cerr := fd.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("restore: downloading %s: %v", source, err)
+		}
+	} else {
+		if err := copyFile(source, scratch); err != nil {
+			return nil, fmt.Errorf("restore: %v", err)
+		}
+	}
+
+	result, err := verifySQLiteFile(ctx, logger, scratch)
+	if err != nil {
+		return nil, fmt.Errorf("restore: %v", err)
+	}
+	if !result.ok() {
+		return result, fmt.Errorf("restore: %s failed verification, %s was not replaced", source, dest)
+	}
+	os.Remove(scratch + "-wal")
+	os.Remove(scratch + "-shm")
+
+	// A write-ahead log left beside the restored file would be replayed into it, so it moves aside with the database
+	suffix := ".replaced-" + time.Now().UTC().Format("20060102T150405Z")
+	for _, ext := range []string{"", "-wal", "-shm"} {
+		if _, err := os.Stat(dest + ext); err == nil {
+			if err := os.Rename(dest+ext, dest+suffix+ext); err != nil {
+				return nil, fmt.Errorf("restore: %v", err)
+			}
+		}
+	}
+	if err := os.Rename(scratch, dest); err != nil {
+		return nil, fmt.Errorf("restore: %v", err)
+	}
+	logger.Log("restore", fmt.Sprintf("restored %s from %s", dest, source))
+	return result, nil
+}
+
+// backupSQLite is an admin route which backs up the SQLite ledger databases to ?target or SQLITE_BACKUP_TARGET.
+func backupSQLite(logger log.Logger, svc *sqliteBackupService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		backups, err := svc.Backup(requestContext(r), r.URL.Query().Get("target"))
+		if err != nil {
+			logger.Log("backup", err)
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(backups)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager/s3manageriface"
+	"github.com/go-kit/kit/log"
+)
+
+type mockS3Uploader struct {
+	s3manageriface.UploaderAPI
+
+	bucket, key string
+	body        []byte
+}
+
+func (u *mockS3Uploader) UploadWithContext(ctx aws.Context, input *s3manager.UploadInput, opts ...func(*s3manager.Uploader)) (*s3manager.UploadOutput, error) {
+	u.bucket, u.key = aws.StringValue(input.Bucket), aws.StringValue(input.Key)
+	bs, err := ioutil.ReadAll(input.Body)
+	u.body = bs
+	return &s3manager.UploadOutput{}, err
+}
+
+func TestSQLiteBackup(t *testing.T) {
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+
+	repo := createTestSqlTransactionRepository(t, sqliteDB.DB)
+	account1, account2 := base.ID(), base.ID()
+	repo.accountRepo = &testAccountRepository{
+		accounts: []*accounts.Account{
+			{ID: account1, RoutingNumber: defaultRoutingNumber},
+			{ID: account2, RoutingNumber: "121042882"},
+		},
+	}
+	tx := transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now(),
+		Lines: []transactionLine{
+			{AccountID: account1, Purpose: ACHDebit, Amount: 500},
+			{AccountID: account2, Purpose: ACHCredit, Amount: 500},
+		},
+	}
+	if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "accounts-backup-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	uploader := &mockS3Uploader{}
+	svc := &sqliteBackupService{
+		logger:   log.NewNopLogger(),
+		ledgers:  []*sqlTransactionRepository{repo},
+		uploader: uploader,
+		now:      func() time.Time { return time.Date(2020, time.June, 1, 12, 30, 0, 0, time.UTC) },
+	}
+	if _, err := svc.Backup(context.Background(), ""); err == nil {
+		t.Error("expected error without a target")
+	}
+
+	backups, err := svc.Backup(context.Background(), filepath.Join(dir, "backups"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := filepath.Join(dir, "backups", "accounts-20200601T123000Z.db")
+	if len(backups) != 1 || backups[0].Location != location || backups[0].Database != "accounts.db" || backups[0].Size == 0 {
+		t.Fatalf("unexpected backups: %#v", backups)
+	}
+	if v := backups[0].Verification; !v.ok() || v.Accounts != 2 || v.Transactions != 1 {
+		t.Errorf("unexpected verification: %#v", v)
+	}
+	if _, sum, _ := sha256File(location); sum != backups[0].SHA256 {
+		t.Errorf("checksum %s doesn't match %s", backups[0].SHA256, sum)
+	}
+
+	backups, err = svc.Backup(context.Background(), "s3://bucket/accounts/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backups[0].Location != "s3://bucket/accounts/accounts-20200601T123000Z.db" || uploader.bucket != "bucket" || uploader.key != "accounts/accounts-20200601T123000Z.db" {
+		t.Errorf("location=%s bucket=%s key=%s", backups[0].Location, uploader.bucket, uploader.key)
+	}
+	if int64(len(uploader.body)) != backups[0].Size {
+		t.Errorf("uploaded %d bytes of %d", len(uploader.body), backups[0].Size)
+	}
+
+	// Restore over an existing database, which is kept aside
+	dest := filepath.Join(dir, "accounts.db")
+	if err := ioutil.WriteFile(dest, []byte("previous"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	result, err := restoreSQLiteBackup(context.Background(), log.NewNopLogger(), nil, location, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.ok() || result.Transactions != 1 {
+		t.Errorf("unexpected result: %#v", result)
+	}
+	if matches, _ := filepath.Glob(dest + ".replaced-*"); len(matches) != 1 {
+		t.Errorf("replaced databases: %v", matches)
+	}
+
+	// Corrupt backups are refused and the database is left alone
+	corrupt := filepath.Join(dir, "corrupt.db")
+	if err := ioutil.WriteFile(corrupt, []byte("not a database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restoreSQLiteBackup(context.Background(), log.NewNopLogger(), nil, corrupt, dest); err == nil {
+		t.Error("expected error")
+	}
+	if _, sum, _ := sha256File(dest); sum != backups[0].SHA256 {
+		t.Error("restored database was changed")
+	}
+}
+
+func TestSQLiteBackup__route(t *testing.T) {
+	svc := &sqliteBackupService{logger: log.NewNopLogger()}
+
+	w := httptest.NewRecorder()
+	backupSQLite(log.NewNopLogger(), svc)(w, httptest.NewRequest("GET", "/sqlite/backups", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	// memory storage has nothing to back up
+	w = httptest.NewRecorder()
+	backupSQLite(log.NewNopLogger(), svc)(w, httptest.NewRequest("POST", "/sqlite/backups?target=/tmp", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
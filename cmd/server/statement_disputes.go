@@ -0,0 +1,326 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+type statementDisputeStatus string
+
+const (
+	statementDisputeOpen     statementDisputeStatus = "open"
+	statementDisputeResolved statementDisputeStatus = "resolved"
+)
+
+// maxDisputeNoteLength is the longest annotation of a dispute, in characters.
+const maxDisputeNoteLength = 2000
+
+// statementDispute is a customer's claim of an error in one of the transactions on their statement. Its annotations
+// document the notice, investigation and resolution of the claim, as Regulation E error resolution requires.
+type statementDispute struct {
+	ID            string                 `json:"id"`
+	AccountID     string                 `json:"accountId"`
+	Cycle         string                 `json:"cycle"`
+	TransactionID string                 `json:"transactionId"`
+	Status        statementDisputeStatus `json:"status"`
+
+	// ReceivedAt is when the customer notified us of the error, which error resolution deadlines count from
+	ReceivedAt time.Time `json:"receivedAt"`
+
+	Annotations []statementDisputeAnnotation `json:"annotations"`
+
+	CreatedBy    string     `json:"createdBy,omitempty"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	ResolvedAt   *time.Time `json:"resolvedAt,omitempty"`
+	LastModified time.Time  `json:"lastModified"`
+}
+
+// statementDisputeAnnotation is a note added to a dispute, optionally changing the dispute's status.
+type statementDisputeAnnotation struct {
+	ID        string                 `json:"id"`
+	Note      string                 `json:"note"`
+	Status    statementDisputeStatus `json:"status,omitempty"`
+	CreatedBy string                 `json:"createdBy,omitempty"`
+	CreatedAt time.Time              `json:"createdAt"`
+}
+
+type createStatementDisputeRequest struct {
+	TransactionID string `json:"transactionId"`
+	Note          string `json:"note"`
+
+	// ReceivedAt defaults to now
+	ReceivedAt *time.Time `json:"receivedAt,omitempty"`
+}
+
+type annotateStatementDisputeRequest struct {
+	Note   string                 `json:"note"`
+	Status statementDisputeStatus `json:"status,omitempty"`
+}
+
+var (
+	errStatementNotFound = errors.New("statement not found")
+	errDisputeNotFound   = errors.New("statement dispute not found")
+)
+
+func validateDisputeNote(note string) error {
+	if note == "" {
+		return errors.New("missing note")
+	}
+	if len(note) > maxDisputeNoteLength {
+		return fmt.Errorf("note is longer than %d characters", maxDisputeNoteLength)
+	}
+	return nil
+}
+
+// statementDisputes returns the disputes of an account's cycle as they're embedded in its statement.
+func (g *statementGenerator) statementDisputes(accountID, cycle string) ([]statementDispute, error) {
+	disputes, err := g.repo.getStatementDisputes(accountID, cycle)
+	if err != nil {
+		return nil, err
+	}
+	var out []statementDispute
+	for i := range disputes {
+		out = append(out, *disputes[i])
+	}
+	return out, nil
+}
+
+// regenerate rebuilds an account's statement over the same period from its current transactions and disputes.
+func (g *statementGenerator) regenerate(accountID, cycle string) (*statement, error) {
+	existing, err := g.repo.getStatement(accountID, cycle)
+	if err != nil {
+		return nil, err
+	}
+	if existing == nil {
+		return nil, errStatementNotFound
+	}
+	transactions, _, err := g.transactionRepo.getAccountTransactions(context.Background(), accountID, transactionPage{})
+	if err != nil {
+		return nil, err
+	}
+	stmt := buildStatement(accountID, existing.PeriodStart, existing.PeriodEnd, transactions)
+	stmt.ID = existing.ID
+	stmt.Cycle = existing.Cycle
+	stmt.CreatedAt = existing.CreatedAt
+	if stmt.Disputes, err = g.statementDisputes(accountID, cycle); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	stmt.RegeneratedAt = &now
+	if err := g.repo.updateStatement(stmt); err != nil {
+		return nil, err
+	}
+	g.logger.Log("statements", fmt.Sprintf("regenerated cycle=%s statement for account=%s", cycle, accountID))
+	return stmt, nil
+}
+
+// openDispute records a dispute of a transaction on an account's statement. Each transaction can only have one
+// open dispute per statement.
+func (g *statementGenerator) openDispute(accountID, cycle, userID string, req createStatementDisputeRequest) (*statementDispute, error) {
+	if err := validateDisputeNote(req.Note); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	receivedAt := now
+	if req.ReceivedAt != nil {
+		if req.ReceivedAt.After(now) {
+			return nil, errors.New("receivedAt is in the future")
+		}
+		receivedAt = *req.ReceivedAt
+	}
+
+	stmt, err := g.repo.getStatement(accountID, cycle)
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return nil, errStatementNotFound
+	}
+	found := false
+	for i := range stmt.Transactions {
+		if stmt.Transactions[i].ID == req.TransactionID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("transaction=%s isn't on the cycle=%s statement", req.TransactionID, cycle)
+	}
+	existing, err := g.repo.getStatementDisputes(accountID, cycle)
+	if err != nil {
+		return nil, err
+	}
+	for i := range existing {
+		if existing[i].TransactionID == req.TransactionID && existing[i].Status == statementDisputeOpen {
+			return nil, fmt.Errorf("transaction=%s already has an open dispute=%s", req.TransactionID, existing[i].ID)
+		}
+	}
+
+	dispute := &statementDispute{
+		ID:            newID(),
+		AccountID:     accountID,
+		Cycle:         cycle,
+		TransactionID: req.TransactionID,
+		Status:        statementDisputeOpen,
+		ReceivedAt:    receivedAt,
+		Annotations: []statementDisputeAnnotation{
+			{ID: newID(), Note: req.Note, Status: statementDisputeOpen, CreatedBy: userID, CreatedAt: now},
+		},
+		CreatedBy:    userID,
+		CreatedAt:    now,
+		LastModified: now,
+	}
+	if err := g.repo.createDispute(dispute); err != nil {
+		return nil, err
+	}
+	g.logger.Log("statements", fmt.Sprintf("opened dispute=%s of transaction=%s on account=%s cycle=%s", dispute.ID, dispute.TransactionID, accountID, cycle), "userID", userID)
+	return dispute, nil
+}
+
+// annotateDispute adds a note to a dispute, resolving or reopening it when the annotation has a status.
+func (g *statementGenerator) annotateDispute(accountID, cycle, disputeID, userID string, req annotateStatementDisputeRequest) (*statementDispute, error) {
+	if err := validateDisputeNote(req.Note); err != nil {
+		return nil, err
+	}
+	switch req.Status {
+	case "", statementDisputeOpen, statementDisputeResolved:
+	default:
+		return nil, fmt.Errorf("unknown dispute status %q", req.Status)
+	}
+
+	dispute, err := g.repo.getDispute(accountID, disputeID)
+	if err != nil {
+		return nil, err
+	}
+	if dispute == nil || dispute.Cycle != cycle {
+		return nil, errDisputeNotFound
+	}
+
+	now := time.Now()
+	annotation := statementDisputeAnnotation{ID: newID(), Note: req.Note, Status: req.Status, CreatedBy: userID, CreatedAt: now}
+	switch req.Status {
+	case statementDisputeResolved:
+		dispute.ResolvedAt = &now
+	case statementDisputeOpen:
+		dispute.ResolvedAt = nil
+	}
+	if req.Status != "" {
+		dispute.Status = req.Status
+	}
+	dispute.LastModified = now
+	if err := g.repo.annotateDispute(dispute, annotation); err != nil {
+		return nil, err
+	}
+	dispute.Annotations = append(dispute.Annotations, annotation)
+	return dispute, nil
+}
+
+// statementDisputesRoute is an admin route which lists (GET) or opens (POST) the disputes of an account's statement.
+func statementDisputesRoute(logger log.Logger, g *statementGenerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		accountID, cycle := mux.Vars(r)["accountId"], mux.Vars(r)["cycle"]
+		switch r.Method {
+		case "GET":
+			disputes, err := g.repo.getStatementDisputes(accountID, cycle)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if disputes == nil {
+				disputes = []*statementDispute{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(disputes)
+
+		case "POST":
+			var req createStatementDisputeRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			dispute, err := g.openDispute(accountID, cycle, moovhttp.GetUserID(r), req)
+			if err != nil {
+				if err == errStatementNotFound {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				logger.Log("statements", fmt.Sprintf("problem opening dispute on account=%s cycle=%s: %v", accountID, cycle, err))
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(dispute)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// annotateStatementDispute is an admin route which adds an annotation to a dispute.
+func annotateStatementDispute(logger log.Logger, g *statementGenerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var req annotateStatementDisputeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		vars := mux.Vars(r)
+		dispute, err := g.annotateDispute(vars["accountId"], vars["cycle"], vars["disputeId"], moovhttp.GetUserID(r), req)
+		if err != nil {
+			if err == errDisputeNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			logger.Log("statements", fmt.Sprintf("problem annotating dispute=%s: %v", vars["disputeId"], err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(dispute)
+	}
+}
+
+// regenerateStatement is an admin route which rebuilds an account's statement with its current disputes.
+func regenerateStatement(logger log.Logger, g *statementGenerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		vars := mux.Vars(r)
+		stmt, err := g.regenerate(vars["accountId"], vars["cycle"])
+		if err != nil {
+			if err == errStatementNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			logger.Log("statements", fmt.Sprintf("problem regenerating account=%s cycle=%s statement: %v", vars["accountId"], vars["cycle"], err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(stmt)
+	}
+}
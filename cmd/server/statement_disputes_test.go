@@ -0,0 +1,139 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestStatementDisputes(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlStatementRepository{db.DB, log.NewNopLogger()}
+
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	tx := func(amount int) transaction {
+		return transaction{
+			ID:        base.ID(),
+			Timestamp: start.Add(24 * time.Hour),
+			Status:    TransactionPosted,
+			Lines: []transactionLine{
+				{AccountID: "account", Purpose: ACHDebit, Amount: amount},
+				{AccountID: "merchant", Purpose: Card, Amount: amount},
+			},
+		}
+	}
+	disputed, other := tx(100), tx(250)
+	transactionRepo := &mockTransactionRepository{transactions: []transaction{disputed, other}}
+
+	g := newStatementGenerator(log.NewNopLogger(), createTestSqlAccountRepository(t, db.DB), transactionRepo, repo)
+	stmt := buildStatement("account", start, start.AddDate(0, 1, 0), transactionRepo.transactions)
+	stmt.ID, stmt.CreatedAt = base.ID(), time.Now()
+	if err := repo.createStatement(stmt); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	router.Path("/accounts/{accountId}/statements/{cycle}/regenerate").HandlerFunc(regenerateStatement(log.NewNopLogger(), g))
+	router.Path("/accounts/{accountId}/statements/{cycle}/disputes").HandlerFunc(statementDisputesRoute(log.NewNopLogger(), g))
+	router.Path("/accounts/{accountId}/statements/{cycle}/disputes/{disputeId}/annotations").HandlerFunc(annotateStatementDispute(log.NewNopLogger(), g))
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", "operator")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	readDispute := func(w *httptest.ResponseRecorder) *statementDispute {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		var dispute statementDispute
+		if err := json.NewDecoder(w.Body).Decode(&dispute); err != nil {
+			t.Fatal(err)
+		}
+		return &dispute
+	}
+
+	body := fmt.Sprintf(`{"transactionId": %q, "note": "Customer called, doesn't recognize the charge"}`, disputed.ID)
+	if w := do("POST", "/accounts/account/statements/2020-02/disputes", body); w.Code != http.StatusNotFound {
+		t.Errorf("expected a missing statement to be 404: %d", w.Code)
+	}
+	if w := do("POST", "/accounts/account/statements/2020-01/disputes", `{"transactionId": "other", "note": "wrong amount"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a transaction which isn't on the statement to be rejected: %d", w.Code)
+	}
+	if w := do("POST", "/accounts/account/statements/2020-01/disputes", fmt.Sprintf(`{"transactionId": %q}`, disputed.ID)); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a dispute without a note to be rejected: %d", w.Code)
+	}
+
+	dispute := readDispute(do("POST", "/accounts/account/statements/2020-01/disputes", body))
+	if dispute.Status != statementDisputeOpen || dispute.TransactionID != disputed.ID || dispute.CreatedBy != "operator" || len(dispute.Annotations) != 1 {
+		t.Errorf("unexpected dispute: %#v", dispute)
+	}
+	if w := do("POST", "/accounts/account/statements/2020-01/disputes", body); w.Code != http.StatusBadRequest {
+		t.Errorf("expected a second open dispute of the transaction to be rejected: %d", w.Code)
+	}
+
+	path := fmt.Sprintf("/accounts/account/statements/2020-01/disputes/%s/annotations", dispute.ID)
+	if w := do("POST", path, `{"note": "done", "status": "closed"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unknown status to be rejected: %d", w.Code)
+	}
+	if w := do("POST", "/accounts/account/statements/2019-12/disputes/"+dispute.ID+"/annotations", `{"note": "checked"}`); w.Code != http.StatusNotFound {
+		t.Errorf("expected a dispute of another cycle to be 404: %d", w.Code)
+	}
+	readDispute(do("POST", path, `{"note": "Requested receipts from the card network"}`))
+	dispute = readDispute(do("POST", path, `{"note": "Merchant charged twice, provisional credit made final", "status": "resolved"}`))
+	if dispute.Status != statementDisputeResolved || dispute.ResolvedAt == nil || len(dispute.Annotations) != 3 {
+		t.Errorf("unexpected dispute: %#v", dispute)
+	}
+
+	// the statement only shows disputes once it's regenerated
+	if found, _ := repo.getStatement("account", "2020-01"); len(found.Disputes) != 0 {
+		t.Errorf("unexpected disputes: %#v", found.Disputes)
+	}
+	w := do("POST", "/accounts/account/statements/2020-01/regenerate", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	found, err := repo.getStatement("account", "2020-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.ID != stmt.ID || found.RegeneratedAt == nil || found.Debits != 350 || len(found.Disputes) != 1 {
+		t.Fatalf("unexpected statement: %#v", found)
+	}
+	if d := found.Disputes[0]; d.ID != dispute.ID || d.Status != statementDisputeResolved || len(d.Annotations) != 3 || d.Annotations[2].Status != statementDisputeResolved {
+		t.Errorf("unexpected dispute: %#v", d)
+	}
+	if w := do("POST", "/accounts/account/statements/2020-02/regenerate", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	w = do("GET", "/accounts/account/statements/2020-01/disputes", "")
+	var disputes []statementDispute
+	if err := json.NewDecoder(w.Body).Decode(&disputes); err != nil || len(disputes) != 1 {
+		t.Errorf("disputes=%#v error=%v", disputes, err)
+	}
+
+	// resolved disputes don't block new ones
+	readDispute(do("POST", "/accounts/account/statements/2020-01/disputes", body))
+	if w := do("PUT", "/accounts/account/statements/2020-01/disputes", ""); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
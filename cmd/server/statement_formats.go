@@ -0,0 +1,357 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+)
+
+// statementRow is one line of a rendered statement along with the account's balance after it.
+type statementRow struct {
+	Date          time.Time
+	TransactionID string
+	Description   string
+	Purpose       string
+	Amount        int64
+	Balance       int64
+	Dispute       string // status of the transaction's dispute, if any
+}
+
+// statementRows lists each of the statement's lines in the order they were posted. The first and last rows are the
+// opening and closing balances, which have no amount.
+func statementRows(stmt *statement) []statementRow {
+	disputes := make(map[string]string)
+	for i := range stmt.Disputes {
+		disputes[stmt.Disputes[i].TransactionID] = string(stmt.Disputes[i].Status)
+	}
+	balance := stmt.OpeningBalance
+	rows := []statementRow{{Date: stmt.PeriodStart, Description: "Opening balance", Balance: balance}}
+	for _, tx := range stmt.Transactions {
+		for _, line := range tx.Lines {
+			balance += int64(lineAmount(line))
+			description := line.Description
+			if description == "" {
+				description = tx.Description
+			}
+			rows = append(rows, statementRow{
+				Date:          tx.Timestamp,
+				TransactionID: tx.ID,
+				Description:   description,
+				Purpose:       string(line.Purpose),
+				Amount:        int64(lineAmount(line)),
+				Balance:       balance,
+				Dispute:       disputes[tx.ID],
+			})
+		}
+	}
+	return append(rows, statementRow{Date: statementLastDay(stmt), Description: "Closing balance", Balance: stmt.ClosingBalance})
+}
+
+// statementLastDay is the last day a statement covers, as its PeriodEnd is exclusive.
+func statementLastDay(stmt *statement) time.Time {
+	return stmt.PeriodEnd.AddDate(0, 0, -1)
+}
+
+// formatCents formats an amount of USD cents as dollars, such as -12.34
+func formatCents(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, amount/100, amount%100)
+}
+
+// writeStatementCSV renders a statement as CSV with a row for each line, between its opening and closing balances.
+func writeStatementCSV(w io.Writer, stmt *statement) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"date", "transactionId", "description", "purpose", "amount", "balance", "dispute"})
+	for _, row := range statementRows(stmt) {
+		amount := ""
+		if row.TransactionID != "" {
+			amount = formatCents(row.Amount)
+		}
+		out.Write([]string{row.Date.UTC().Format("2006-01-02"), row.TransactionID, row.Description, row.Purpose, amount, formatCents(row.Balance), row.Dispute})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// statementQFXBankID is the Intuit bank ID (QFX_INTU_BID) Quicken requires in QFX statements, empty when unset.
+var statementQFXBankID string
+
+// readStatementQFXBankID reads QFX_INTU_BID, the numeric ID Intuit assigned to the institution for Web Connect.
+func readStatementQFXBankID() (string, error) {
+	v := os.Getenv("QFX_INTU_BID")
+	for _, r := range v {
+		if r < '0' || r > '9' {
+			return "", fmt.Errorf("invalid QFX_INTU_BID %q, expected digits", v)
+		}
+	}
+	return v, nil
+}
+
+// writeStatementOFX renders a statement as an OFX 1.02 bank statement (SGML) which Quicken and QuickBooks import,
+// with a STMTTRN for each of the rows written to CSV. With a bankID the file is QFX, which Quicken requires from
+// institutions registered with Intuit.
+func writeStatementOFX(w io.Writer, stmt *statement, acct *accounts.Account, now time.Time, bankID string) error {
+	accountType := "CHECKING"
+	switch AccountType(strings.ToLower(acct.Type)) {
+	case Savings:
+		accountType = "SAVINGS"
+	case Loan:
+		accountType = "CREDITLINE"
+	}
+	lastDay := statementLastDay(stmt)
+
+	var buf bytes.Buffer
+	buf.WriteString("OFXHEADER:100\r\nDATA:OFXSGML\r\nVERSION:102\r\nSECURITY:NONE\r\nENCODING:USASCII\r\nCHARSET:1252\r\nCOMPRESSION:NONE\r\nOLDFILEUID:NONE\r\nNEWFILEUID:NONE\r\n\r\n")
+	lines := []string{
+		"<OFX>",
+		"<SIGNONMSGSRSV1>", "<SONRS>",
+		"<STATUS>", "<CODE>0", "<SEVERITY>INFO", "</STATUS>",
+		"<DTSERVER>" + ofxTime(now), "<LANGUAGE>ENG",
+	}
+	if bankID != "" {
+		lines = append(lines, "<INTU.BID>"+bankID)
+	}
+	lines = append(lines,
+		"</SONRS>", "</SIGNONMSGSRSV1>",
+		"<BANKMSGSRSV1>", "<STMTTRNRS>",
+		"<TRNUID>"+ofxEscape(stmt.AccountID+"-"+stmt.Cycle),
+		"<STATUS>", "<CODE>0", "<SEVERITY>INFO", "</STATUS>",
+		"<STMTRS>", "<CURDEF>USD",
+		"<BANKACCTFROM>", "<BANKID>"+ofxEscape(acct.RoutingNumber), "<ACCTID>"+ofxEscape(acct.AccountNumber), "<ACCTTYPE>"+accountType, "</BANKACCTFROM>",
+		"<BANKTRANLIST>", "<DTSTART>"+ofxTime(stmt.PeriodStart), "<DTEND>"+ofxTime(lastDay),
+	)
+	for _, row := range statementRows(stmt) {
+		if row.TransactionID == "" {
+			continue // opening and closing balances
+		}
+		name := row.Description
+		if name == "" {
+			name = row.Purpose
+		}
+		lines = append(lines,
+			"<STMTTRN>",
+			"<TRNTYPE>"+ofxTransactionType(row),
+			"<DTPOSTED>"+ofxTime(row.Date),
+			"<TRNAMT>"+formatCents(row.Amount),
+			"<FITID>"+ofxEscape(row.TransactionID),
+			"<NAME>"+ofxEscape(truncate(name, 32)),
+			"<MEMO>"+ofxEscape(row.Purpose),
+			"</STMTTRN>",
+		)
+	}
+	lines = append(lines,
+		"</BANKTRANLIST>",
+		"<LEDGERBAL>", "<BALAMT>"+formatCents(stmt.ClosingBalance), "<DTASOF>"+ofxTime(lastDay), "</LEDGERBAL>",
+		"</STMTRS>", "</STMTTRNRS>", "</BANKMSGSRSV1>",
+		"</OFX>",
+	)
+	buf.WriteString(strings.Join(lines, "\r\n"))
+	buf.WriteString("\r\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ofxTransactionType maps a statement row to the closest OFX TRNTYPE.
+func ofxTransactionType(row statementRow) string {
+	switch TransactionPurpose(row.Purpose) {
+	case Interest:
+		if row.Amount < 0 {
+			return "DEBIT"
+		}
+		return "INT"
+	case Card:
+		return "POS"
+	case Transfer:
+		return "XFER"
+	}
+	if row.Amount < 0 {
+		return "DEBIT"
+	}
+	return "CREDIT"
+}
+
+func ofxTime(t time.Time) string {
+	return t.UTC().Format("20060102150405") + "[0:GMT]"
+}
+
+// ofxEscape escapes the SGML special characters and replaces characters outside of printable ASCII with '?', as
+// the file is declared USASCII.
+func ofxEscape(s string) string {
+	var out strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '&':
+			out.WriteString("&amp;")
+		case r == '<':
+			out.WriteString("&lt;")
+		case r == '>':
+			out.WriteString("&gt;")
+		case r < ' ' || r > '~':
+			out.WriteRune('?')
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+const (
+	statementPDFLinesPerPage = 60
+	statementPDFWidth        = 96 // characters of Courier 9pt across a Letter page
+)
+
+// writeStatementPDF renders a statement as a PDF with its balances and a table of its lines, followed by its
+// disputes and their annotations.
+func writeStatementPDF(w io.Writer, stmt *statement) error {
+	lines := []string{
+		"Account Statement",
+		"",
+		fmt.Sprintf("Account:          %s", stmt.AccountID),
+		fmt.Sprintf("Period:           %s to %s", stmt.PeriodStart.UTC().Format("2006-01-02"), statementLastDay(stmt).UTC().Format("2006-01-02")),
+		fmt.Sprintf("Opening balance:  %s", formatCents(stmt.OpeningBalance)),
+		fmt.Sprintf("Credits:          %s", formatCents(stmt.Credits)),
+		fmt.Sprintf("Debits:           %s", formatCents(stmt.Debits)),
+		fmt.Sprintf("Closing balance:  %s", formatCents(stmt.ClosingBalance)),
+		"",
+		fmt.Sprintf("%-10s  %-42s  %-10s  %13s  %13s", "Date", "Description", "Purpose", "Amount", "Balance"),
+		strings.Repeat("-", statementPDFWidth),
+	}
+	for _, row := range statementRows(stmt) {
+		amount, description := "", row.Description
+		if row.TransactionID != "" {
+			amount = formatCents(row.Amount)
+			if description == "" {
+				description = row.TransactionID
+			}
+		}
+		if row.Dispute != "" {
+			description = fmt.Sprintf("%s [%s dispute]", description, row.Dispute)
+		}
+		lines = append(lines, fmt.Sprintf("%-10s  %-42s  %-10s  %13s  %13s", row.Date.UTC().Format("2006-01-02"), truncate(description, 42), truncate(row.Purpose, 10), amount, formatCents(row.Balance)))
+	}
+	if len(stmt.Disputes) > 0 {
+		lines = append(lines, "", "Disputes")
+		for _, dispute := range stmt.Disputes {
+			lines = append(lines, fmt.Sprintf("Transaction %s, received %s, %s", dispute.TransactionID, dispute.ReceivedAt.UTC().Format("2006-01-02"), dispute.Status))
+			for _, annotation := range dispute.Annotations {
+				note := fmt.Sprintf("%s: %s", annotation.CreatedAt.UTC().Format("2006-01-02"), annotation.Note)
+				for _, line := range wrapText(note, statementPDFWidth-4) {
+					lines = append(lines, "    "+line)
+				}
+			}
+		}
+	}
+
+	var pages [][]string
+	for len(lines) > statementPDFLinesPerPage {
+		pages = append(pages, lines[:statementPDFLinesPerPage])
+		lines = lines[statementPDFLinesPerPage:]
+	}
+	pages = append(pages, lines)
+	for i := range pages {
+		pages[i] = append(pages[i], "", fmt.Sprintf("%*s", statementPDFWidth, fmt.Sprintf("Page %d of %d", i+1, len(pages))))
+	}
+	_, err := w.Write(renderTextPDF(pages))
+	return err
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
+
+// wrapText splits s into lines of at most width characters, breaking between words where it can.
+func wrapText(s string, width int) []string {
+	var out []string
+	line := ""
+	for _, word := range strings.Fields(s) {
+		for len(word) > width {
+			if line != "" {
+				out, line = append(out, line), ""
+			}
+			out, word = append(out, word[:width]), word[width:]
+		}
+		switch {
+		case line == "":
+			line = word
+		case len(line)+1+len(word) > width:
+			out, line = append(out, line), word
+		default:
+			line += " " + word
+		}
+	}
+	if line != "" || len(out) == 0 {
+		out = append(out, line)
+	}
+	return out
+}
+
+// renderTextPDF writes a PDF of Letter sized pages, each showing its lines of text in Courier. Characters outside
+// of printable ASCII are replaced with '?' as the built in fonts can't show them.
+func renderTextPDF(pages [][]string) []byte {
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i := range pages {
+		var content bytes.Buffer
+		content.WriteString("BT\n/F1 9 Tf\n11 TL\n40 752 Td\n")
+		for _, line := range pages[i] {
+			fmt.Fprintf(&content, "(%s) Tj T*\n", pdfEscape(line))
+		}
+		content.WriteString("ET")
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 5+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+func pdfEscape(s string) string {
+	var out strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			out.WriteRune('\\')
+			out.WriteRune(r)
+		case r < ' ' || r > '~':
+			out.WriteRune('?')
+		default:
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,149 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+)
+
+func testStatement(lines int) *statement {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stmt := &statement{
+		AccountID:      "account",
+		Cycle:          "2020-01",
+		PeriodStart:    start,
+		PeriodEnd:      start.AddDate(0, 1, 0),
+		OpeningBalance: 1000,
+	}
+	for i := 0; i < lines; i++ {
+		purpose, amount := ACHCredit, 250
+		if i%2 == 1 {
+			purpose, amount = ACHDebit, 100
+		}
+		stmt.Transactions = append(stmt.Transactions, transaction{
+			ID:          fmt.Sprintf("tx-%d", i),
+			Timestamp:   start.Add(time.Duration(i) * time.Hour),
+			Description: "Transfer (monthly)",
+			Lines:       []transactionLine{{AccountID: "account", Purpose: purpose, Amount: amount}},
+		})
+		if purpose == ACHCredit {
+			stmt.Credits += int64(amount)
+		} else {
+			stmt.Debits += int64(amount)
+		}
+	}
+	stmt.ClosingBalance = stmt.OpeningBalance + stmt.Credits - stmt.Debits
+	return stmt
+}
+
+func TestStatementFormats__CSV(t *testing.T) {
+	stmt := testStatement(2)
+	stmt.Transactions[1].Lines[0].Description = "Coffee, to go"
+	stmt.Disputes = []statementDispute{{TransactionID: "tx-1", Status: statementDisputeOpen}}
+
+	var buf bytes.Buffer
+	if err := writeStatementCSV(&buf, stmt); err != nil {
+		t.Fatal(err)
+	}
+	expected := `date,transactionId,description,purpose,amount,balance,dispute
+2020-01-01,,Opening balance,,,10.00,
+2020-01-01,tx-0,Transfer (monthly),achcredit,2.50,12.50,
+2020-01-01,tx-1,"Coffee, to go",achdebit,-1.00,11.50,open
+2020-01-31,,Closing balance,,,11.50,
+`
+	if buf.String() != expected {
+		t.Errorf("unexpected CSV:\n%s", buf.String())
+	}
+}
+
+func TestStatementFormats__PDF(t *testing.T) {
+	stmt := testStatement(100)
+	stmt.Disputes = []statementDispute{{
+		TransactionID: "tx-1",
+		Status:        statementDisputeResolved,
+		Annotations:   []statementDisputeAnnotation{{Note: strings.Repeat("Merchant refunded ", 10)}},
+	}}
+
+	var buf bytes.Buffer
+	if err := writeStatementPDF(&buf, stmt); err != nil {
+		t.Fatal(err)
+	}
+	pdf := buf.String()
+	if !strings.HasPrefix(pdf, "%PDF-1.4\n") || !strings.HasSuffix(pdf, "%%EOF\n") {
+		t.Errorf("unexpected PDF: %q", pdf)
+	}
+	if !strings.Contains(pdf, "/Count 2") || !strings.Contains(pdf, "(Closing balance:  85.00) Tj") {
+		t.Errorf("unexpected PDF: %s", pdf)
+	}
+	if !strings.Contains(pdf, `Transfer \(monthly\) [resolved dispute]`) || !strings.Contains(pdf, "Page 2 of 2") {
+		t.Errorf("unexpected PDF: %s", pdf)
+	}
+
+	// the cross-reference table points at each object
+	start := strings.LastIndex(pdf, "startxref\n")
+	var xref int
+	fmt.Sscanf(pdf[start+len("startxref\n"):], "%d", &xref)
+	if !strings.HasPrefix(pdf[xref:], "xref\n0 8\n") {
+		t.Errorf("bad xref offset %d", xref)
+	}
+	if offset := strings.Index(pdf, "3 0 obj"); !strings.Contains(pdf[xref:], fmt.Sprintf("%010d 00000 n \n", offset)) {
+		t.Errorf("missing xref entry of offset %d", offset)
+	}
+}
+
+func TestStatementFormats__OFX(t *testing.T) {
+	stmt := testStatement(2)
+	stmt.Transactions[1].Lines[0].Description = "Coffee & cake"
+	stmt.Transactions = append(stmt.Transactions, transaction{
+		ID:        "tx-interest",
+		Timestamp: time.Date(2020, time.January, 2, 0, 0, 0, 0, time.UTC),
+		Lines:     []transactionLine{{AccountID: "account", Purpose: Interest, Amount: 50}},
+	})
+	acct := &accounts.Account{ID: "account", AccountNumber: "1234567", RoutingNumber: defaultRoutingNumber, Type: string(Savings)}
+
+	var buf bytes.Buffer
+	if err := writeStatementOFX(&buf, stmt, acct, time.Date(2020, time.February, 1, 9, 30, 0, 0, time.UTC), ""); err != nil {
+		t.Fatal(err)
+	}
+	ofx := buf.String()
+	if !strings.HasPrefix(ofx, "OFXHEADER:100\r\nDATA:OFXSGML\r\nVERSION:102\r\n") || strings.Contains(ofx, "INTU.BID") {
+		t.Errorf("unexpected OFX: %s", ofx)
+	}
+	for _, expected := range []string{
+		"<DTSERVER>20200201093000[0:GMT]",
+		"<BANKID>" + defaultRoutingNumber + "\r\n<ACCTID>1234567\r\n<ACCTTYPE>SAVINGS",
+		"<DTSTART>20200101000000[0:GMT]\r\n<DTEND>20200131000000[0:GMT]",
+		"<TRNTYPE>CREDIT\r\n<DTPOSTED>20200101000000[0:GMT]\r\n<TRNAMT>2.50\r\n<FITID>tx-0\r\n<NAME>Transfer (monthly)\r\n<MEMO>achcredit",
+		"<TRNTYPE>DEBIT\r\n<DTPOSTED>20200101010000[0:GMT]\r\n<TRNAMT>-1.00\r\n<FITID>tx-1\r\n<NAME>Coffee &amp; cake",
+		"<TRNTYPE>INT\r\n<DTPOSTED>20200102000000[0:GMT]\r\n<TRNAMT>0.50",
+		"<LEDGERBAL>\r\n<BALAMT>11.50\r\n<DTASOF>20200131000000[0:GMT]",
+	} {
+		if !strings.Contains(ofx, expected) {
+			t.Errorf("missing %q in OFX:\n%s", expected, ofx)
+		}
+	}
+	if strings.Count(ofx, "<STMTTRN>") != 3 || !strings.HasSuffix(ofx, "</OFX>\r\n") {
+		t.Errorf("unexpected OFX: %s", ofx)
+	}
+}
+
+func TestStatementFormats__wrapText(t *testing.T) {
+	lines := wrapText("the quick brown fox jumps over the lazy dog", 10)
+	if strings.Join(lines, "|") != "the quick|brown fox|jumps over|the lazy|dog" {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+	if lines := wrapText("abcdefghijklmnopqrstuvwxyz", 10); strings.Join(lines, "|") != "abcdefghij|klmnopqrst|uvwxyz" {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+	if formatCents(-5) != "-0.05" || formatCents(123456) != "1234.56" {
+		t.Errorf("formatCents: %s %s", formatCents(-5), formatCents(123456))
+	}
+}
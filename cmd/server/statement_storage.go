@@ -0,0 +1,434 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// statement is an account's activity and balances over one statement cycle.
+type statement struct {
+	ID        string `json:"id"`
+	AccountID string `json:"accountId"`
+
+	// Cycle is the month (YYYY-MM) the statement covers, from PeriodStart until (but excluding) PeriodEnd
+	Cycle       string    `json:"cycle"`
+	PeriodStart time.Time `json:"periodStart"`
+	PeriodEnd   time.Time `json:"periodEnd"`
+
+	OpeningBalance int64 `json:"openingBalance"`
+	ClosingBalance int64 `json:"closingBalance"`
+	Credits        int64 `json:"credits"`
+	Debits         int64 `json:"debits"`
+
+	// Transactions posted during the cycle, only including the account's own lines
+	Transactions []transaction `json:"transactions"`
+
+	// Disputes of the statement's transactions and their annotations, as of when it was (re)generated
+	Disputes []statementDispute `json:"disputes,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+
+	// RegeneratedAt is when the statement was last rebuilt, for example to include dispute annotations
+	RegeneratedAt *time.Time `json:"regeneratedAt,omitempty"`
+}
+
+type statementRunStatus string
+
+const (
+	statementRunRunning   statementRunStatus = "running"
+	statementRunCompleted statementRunStatus = "completed"
+	statementRunFailed    statementRunStatus = "failed"
+)
+
+// statementRun tracks the progress of generating statements for every account in a cycle.
+type statementRun struct {
+	ID     string             `json:"id"`
+	Cycle  string             `json:"cycle"`
+	Status statementRunStatus `json:"status"`
+
+	Processed int `json:"processed"`
+	Generated int `json:"generated"`
+	Skipped   int `json:"skipped"`
+	Failed    int `json:"failed"`
+
+	// LastAccountID is the last account processed, which a resumed run continues after
+	LastAccountID string `json:"lastAccountId"`
+
+	// Error is why the run stopped before processing every account
+	Error string `json:"error,omitempty"`
+
+	Failures []statementFailure `json:"failures,omitempty"`
+
+	StartedAt    time.Time  `json:"startedAt"`
+	FinishedAt   *time.Time `json:"finishedAt,omitempty"`
+	LastModified time.Time  `json:"lastModified"`
+}
+
+// statementFailure is an account whose statement couldn't be generated in a run.
+type statementFailure struct {
+	AccountID string    `json:"accountId"`
+	Error     string    `json:"error"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type statementRepository interface {
+	Ping() error
+	Close() error
+
+	// createStatement saves a statement, returning errDuplicateStatement if the account already has one for the cycle.
+	createStatement(stmt *statement) error
+
+	// updateStatement replaces the contents of a regenerated statement.
+	updateStatement(stmt *statement) error
+	getStatement(accountID, cycle string) (*statement, error)
+	getAccountStatements(accountID string) ([]*statement, error)
+
+	createRun(run *statementRun) error
+	updateRun(run *statementRun) error
+	getRun(runID string) (*statementRun, error)
+	getRuns(limit int) ([]*statementRun, error)
+
+	// getCycleRuns returns the runs of a cycle, newest first.
+	getCycleRuns(cycle string) ([]*statementRun, error)
+
+	recordFailure(runID string, failure statementFailure) error
+	clearFailure(runID, accountID string) error
+
+	// createDispute saves a dispute along with its annotations.
+	createDispute(dispute *statementDispute) error
+
+	// annotateDispute adds an annotation to a dispute and saves the dispute's status.
+	annotateDispute(dispute *statementDispute, annotation statementDisputeAnnotation) error
+
+	// getDispute returns nil if the dispute doesn't exist on accountID.
+	getDispute(accountID, disputeID string) (*statementDispute, error)
+	getStatementDisputes(accountID, cycle string) ([]*statementDispute, error)
+}
+
+var errDuplicateStatement = errors.New("statement already exists")
+
+type sqlStatementRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlStatementRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlStatementRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlStatementRepository) createStatement(stmt *statement) error {
+	transactions, err := json.Marshal(stmt.Transactions)
+	if err != nil {
+		return fmt.Errorf("createStatement: account=%s: %v", stmt.AccountID, err)
+	}
+	disputes, err := json.Marshal(stmt.Disputes)
+	if err != nil {
+		return fmt.Errorf("createStatement: account=%s: %v", stmt.AccountID, err)
+	}
+	query := `insert into account_statements (statement_id, account_id, cycle, period_start, period_end, opening_balance, closing_balance, credits, debits, transactions, disputes, created_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = r.db.Exec(query, stmt.ID, stmt.AccountID, stmt.Cycle, stmt.PeriodStart, stmt.PeriodEnd, stmt.OpeningBalance, stmt.ClosingBalance, stmt.Credits, stmt.Debits, string(transactions), string(disputes), stmt.CreatedAt)
+	if err != nil {
+		if classifyStorageError(err) == storageErrorConstraint {
+			return errDuplicateStatement
+		}
+		return fmt.Errorf("createStatement: account=%s cycle=%s: %v", stmt.AccountID, stmt.Cycle, err)
+	}
+	return nil
+}
+
+func (r *sqlStatementRepository) updateStatement(stmt *statement) error {
+	transactions, err := json.Marshal(stmt.Transactions)
+	if err != nil {
+		return fmt.Errorf("updateStatement: statement=%s: %v", stmt.ID, err)
+	}
+	disputes, err := json.Marshal(stmt.Disputes)
+	if err != nil {
+		return fmt.Errorf("updateStatement: statement=%s: %v", stmt.ID, err)
+	}
+	query := `update account_statements set opening_balance = ?, closing_balance = ?, credits = ?, debits = ?, transactions = ?, disputes = ?, regenerated_at = ? where statement_id = ?;`
+	_, err = r.db.Exec(query, stmt.OpeningBalance, stmt.ClosingBalance, stmt.Credits, stmt.Debits, string(transactions), string(disputes), stmt.RegeneratedAt, stmt.ID)
+	if err != nil {
+		return fmt.Errorf("updateStatement: statement=%s: %v", stmt.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlStatementRepository) getStatement(accountID, cycle string) (*statement, error) {
+	stmts, err := r.queryStatements(`account_id = ? and cycle = ?`, accountID, cycle)
+	if err != nil {
+		return nil, fmt.Errorf("getStatement: %v", err)
+	}
+	if len(stmts) == 0 {
+		return nil, nil
+	}
+	return stmts[0], nil
+}
+
+func (r *sqlStatementRepository) getAccountStatements(accountID string) ([]*statement, error) {
+	stmts, err := r.queryStatements(`account_id = ?`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getAccountStatements: %v", err)
+	}
+	return stmts, nil
+}
+
+func (r *sqlStatementRepository) queryStatements(where string, args ...interface{}) ([]*statement, error) {
+	query := fmt.Sprintf(`select statement_id, account_id, cycle, period_start, period_end, opening_balance, closing_balance, credits, debits, transactions, disputes, created_at, regenerated_at
+from account_statements where %s order by period_start desc;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*statement
+	for rows.Next() {
+		var stmt statement
+		var transactions string
+		var disputes *string
+		if err := rows.Scan(&stmt.ID, &stmt.AccountID, &stmt.Cycle, &stmt.PeriodStart, &stmt.PeriodEnd, &stmt.OpeningBalance, &stmt.ClosingBalance, &stmt.Credits, &stmt.Debits, &transactions, &disputes, &stmt.CreatedAt, &stmt.RegeneratedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		if err := json.Unmarshal([]byte(transactions), &stmt.Transactions); err != nil {
+			return nil, fmt.Errorf("statement=%s transactions: %v", stmt.ID, err)
+		}
+		if disputes != nil {
+			// statements generated before disputes were tracked have none
+			if err := json.Unmarshal([]byte(*disputes), &stmt.Disputes); err != nil {
+				return nil, fmt.Errorf("statement=%s disputes: %v", stmt.ID, err)
+			}
+		}
+		out = append(out, &stmt)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlStatementRepository) createRun(run *statementRun) error {
+	query := `insert into statement_runs (run_id, cycle, status, processed, generated, skipped, failed, last_account_id, error, started_at, last_modified) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err := r.db.Exec(query, run.ID, run.Cycle, run.Status, run.Processed, run.Generated, run.Skipped, run.Failed, run.LastAccountID, run.Error, run.StartedAt, run.LastModified)
+	if err != nil {
+		return fmt.Errorf("createRun: run=%s: %v", run.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlStatementRepository) updateRun(run *statementRun) error {
+	query := `update statement_runs set status = ?, processed = ?, generated = ?, skipped = ?, failed = ?, last_account_id = ?, error = ?, finished_at = ?, last_modified = ? where run_id = ?;`
+	_, err := r.db.Exec(query, run.Status, run.Processed, run.Generated, run.Skipped, run.Failed, run.LastAccountID, run.Error, run.FinishedAt, run.LastModified, run.ID)
+	if err != nil {
+		return fmt.Errorf("updateRun: run=%s: %v", run.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlStatementRepository) getRun(runID string) (*statementRun, error) {
+	runs, err := r.queryRuns(`where run_id = ?`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("getRun: %v", err)
+	}
+	if len(runs) == 0 {
+		return nil, nil
+	}
+	run := runs[0]
+
+	rows, err := r.db.Query(`select account_id, error, created_at from statement_run_failures where run_id = ? order by account_id;`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("getRun: failures: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var failure statementFailure
+		if err := rows.Scan(&failure.AccountID, &failure.Error, &failure.CreatedAt); err != nil {
+			return nil, fmt.Errorf("getRun: failures scan: %v", err)
+		}
+		run.Failures = append(run.Failures, failure)
+	}
+	return run, rows.Err()
+}
+
+func (r *sqlStatementRepository) getRuns(limit int) ([]*statementRun, error) {
+	runs, err := r.queryRuns(`order by started_at desc limit ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getRuns: %v", err)
+	}
+	return runs, nil
+}
+
+func (r *sqlStatementRepository) getCycleRuns(cycle string) ([]*statementRun, error) {
+	runs, err := r.queryRuns(`where cycle = ? order by started_at desc`, cycle)
+	if err != nil {
+		return nil, fmt.Errorf("getCycleRuns: %v", err)
+	}
+	return runs, nil
+}
+
+func (r *sqlStatementRepository) queryRuns(suffix string, args ...interface{}) ([]*statementRun, error) {
+	query := fmt.Sprintf(`select run_id, cycle, status, processed, generated, skipped, failed, last_account_id, error, started_at, finished_at, last_modified
+from statement_runs %s;`, suffix)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*statementRun
+	for rows.Next() {
+		var run statementRun
+		if err := rows.Scan(&run.ID, &run.Cycle, &run.Status, &run.Processed, &run.Generated, &run.Skipped, &run.Failed, &run.LastAccountID, &run.Error, &run.StartedAt, &run.FinishedAt, &run.LastModified); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		out = append(out, &run)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlStatementRepository) recordFailure(runID string, failure statementFailure) error {
+	// replace an earlier failure of the account so retries keep the latest error
+	if err := r.clearFailure(runID, failure.AccountID); err != nil {
+		return err
+	}
+	query := `insert into statement_run_failures (run_id, account_id, error, created_at) values (?, ?, ?, ?);`
+	if _, err := r.db.Exec(query, runID, failure.AccountID, failure.Error, failure.CreatedAt); err != nil {
+		return fmt.Errorf("recordFailure: run=%s account=%s: %v", runID, failure.AccountID, err)
+	}
+	return nil
+}
+
+func (r *sqlStatementRepository) clearFailure(runID, accountID string) error {
+	if _, err := r.db.Exec(`delete from statement_run_failures where run_id = ? and account_id = ?;`, runID, accountID); err != nil {
+		return fmt.Errorf("clearFailure: run=%s account=%s: %v", runID, accountID, err)
+	}
+	return nil
+}
+
+func (r *sqlStatementRepository) createDispute(dispute *statementDispute) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("createDispute: begin: %v", err)
+	}
+	query := `insert into statement_disputes (dispute_id, account_id, cycle, transaction_id, status, received_at, created_by, created_at, resolved_at, last_modified) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	_, err = tx.Exec(query, dispute.ID, dispute.AccountID, dispute.Cycle, dispute.TransactionID, dispute.Status, dispute.ReceivedAt, dispute.CreatedBy, dispute.CreatedAt, dispute.ResolvedAt, dispute.LastModified)
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("createDispute: dispute=%s: %v", dispute.ID, err)
+	}
+	for i := range dispute.Annotations {
+		if err := insertDisputeAnnotation(tx, dispute.ID, dispute.Annotations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("createDispute: %v", err)
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *sqlStatementRepository) annotateDispute(dispute *statementDispute, annotation statementDisputeAnnotation) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return fmt.Errorf("annotateDispute: begin: %v", err)
+	}
+	query := `update statement_disputes set status = ?, resolved_at = ?, last_modified = ? where dispute_id = ?;`
+	if _, err := tx.Exec(query, dispute.Status, dispute.ResolvedAt, dispute.LastModified, dispute.ID); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("annotateDispute: dispute=%s: %v", dispute.ID, err)
+	}
+	if err := insertDisputeAnnotation(tx, dispute.ID, annotation); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("annotateDispute: %v", err)
+	}
+	return tx.Commit()
+}
+
+func insertDisputeAnnotation(tx *sql.Tx, disputeID string, annotation statementDisputeAnnotation) error {
+	query := `insert into statement_dispute_annotations (annotation_id, dispute_id, note, status, created_by, created_at) values (?, ?, ?, ?, ?, ?);`
+	if _, err := tx.Exec(query, annotation.ID, disputeID, annotation.Note, annotation.Status, annotation.CreatedBy, annotation.CreatedAt); err != nil {
+		return fmt.Errorf("dispute=%s annotation: %v", disputeID, err)
+	}
+	return nil
+}
+
+func (r *sqlStatementRepository) getDispute(accountID, disputeID string) (*statementDispute, error) {
+	disputes, err := r.queryDisputes(`dispute_id = ? and account_id = ?`, disputeID, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getDispute: %v", err)
+	}
+	if len(disputes) == 0 {
+		return nil, nil
+	}
+	return disputes[0], nil
+}
+
+func (r *sqlStatementRepository) getStatementDisputes(accountID, cycle string) ([]*statementDispute, error) {
+	disputes, err := r.queryDisputes(`account_id = ? and cycle = ?`, accountID, cycle)
+	if err != nil {
+		return nil, fmt.Errorf("getStatementDisputes: %v", err)
+	}
+	return disputes, nil
+}
+
+func (r *sqlStatementRepository) queryDisputes(where string, args ...interface{}) ([]*statementDispute, error) {
+	query := fmt.Sprintf(`select dispute_id, account_id, cycle, transaction_id, status, received_at, created_by, created_at, resolved_at, last_modified
+from statement_disputes where %s order by created_at, dispute_id;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*statementDispute
+	for rows.Next() {
+		var dispute statementDispute
+		if err := rows.Scan(&dispute.ID, &dispute.AccountID, &dispute.Cycle, &dispute.TransactionID, &dispute.Status, &dispute.ReceivedAt, &dispute.CreatedBy,
+			&dispute.CreatedAt, &dispute.ResolvedAt, &dispute.LastModified); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		out = append(out, &dispute)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range out {
+		annotations, err := r.queryDisputeAnnotations(out[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		out[i].Annotations = annotations
+	}
+	return out, nil
+}
+
+func (r *sqlStatementRepository) queryDisputeAnnotations(disputeID string) ([]statementDisputeAnnotation, error) {
+	rows, err := r.db.Query(`select annotation_id, note, status, created_by, created_at from statement_dispute_annotations where dispute_id = ? order by created_at, annotation_id;`, disputeID)
+	if err != nil {
+		return nil, fmt.Errorf("dispute=%s annotations: %v", disputeID, err)
+	}
+	defer rows.Close()
+
+	var out []statementDisputeAnnotation
+	for rows.Next() {
+		var annotation statementDisputeAnnotation
+		var status *string
+		if err := rows.Scan(&annotation.ID, &annotation.Note, &status, &annotation.CreatedBy, &annotation.CreatedAt); err != nil {
+			return nil, fmt.Errorf("dispute=%s annotations scan: %v", disputeID, err)
+		}
+		if status != nil {
+			annotation.Status = statementDisputeStatus(*status)
+		}
+		out = append(out, annotation)
+	}
+	return out, rows.Err()
+}
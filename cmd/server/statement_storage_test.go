@@ -0,0 +1,159 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSqlStatementRepository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlStatementRepository) {
+		defer repo.Close()
+
+		start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+		stmt := &statement{
+			ID:             base.ID(),
+			AccountID:      base.ID(),
+			Cycle:          "2020-01",
+			PeriodStart:    start,
+			PeriodEnd:      start.AddDate(0, 1, 0),
+			OpeningBalance: 100,
+			ClosingBalance: 150,
+			Credits:        75,
+			Debits:         25,
+			Transactions: []transaction{
+				{ID: base.ID(), Timestamp: start.Add(time.Hour), Status: TransactionPosted, Lines: []transactionLine{{AccountID: "a", Purpose: ACHCredit, Amount: 75}}},
+			},
+			CreatedAt: time.Now().UTC().Truncate(time.Second),
+		}
+		if err := repo.createStatement(stmt); err != nil {
+			t.Fatal(err)
+		}
+		dup := *stmt
+		dup.ID = base.ID()
+		if err := repo.createStatement(&dup); err != errDuplicateStatement {
+			t.Errorf("expected duplicate statement error: %v", err)
+		}
+
+		found, err := repo.getStatement(stmt.AccountID, "2020-01")
+		if err != nil || found == nil {
+			t.Fatalf("statement=%#v error=%v", found, err)
+		}
+		if found.ID != stmt.ID || found.ClosingBalance != 150 || len(found.Transactions) != 1 || !found.PeriodStart.Equal(start) {
+			t.Errorf("unexpected statement: %#v", found)
+		}
+		if found, err := repo.getStatement(stmt.AccountID, "2020-02"); err != nil || found != nil {
+			t.Errorf("statement=%#v error=%v", found, err)
+		}
+		if stmts, err := repo.getAccountStatements(stmt.AccountID); err != nil || len(stmts) != 1 {
+			t.Errorf("statements=%#v error=%v", stmts, err)
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		dispute := &statementDispute{
+			ID:            base.ID(),
+			AccountID:     stmt.AccountID,
+			Cycle:         "2020-01",
+			TransactionID: stmt.Transactions[0].ID,
+			Status:        statementDisputeOpen,
+			ReceivedAt:    now,
+			Annotations:   []statementDisputeAnnotation{{ID: base.ID(), Note: "customer didn't authorize", Status: statementDisputeOpen, CreatedAt: now}},
+			CreatedAt:     now,
+			LastModified:  now,
+		}
+		if err := repo.createDispute(dispute); err != nil {
+			t.Fatal(err)
+		}
+		dispute.Status, dispute.ResolvedAt = statementDisputeResolved, &now
+		if err := repo.annotateDispute(dispute, statementDisputeAnnotation{ID: base.ID(), Note: "merchant refunded", Status: statementDisputeResolved, CreatedAt: now.Add(time.Second)}); err != nil {
+			t.Fatal(err)
+		}
+		found3, err := repo.getDispute(stmt.AccountID, dispute.ID)
+		if err != nil || found3 == nil {
+			t.Fatalf("dispute=%#v error=%v", found3, err)
+		}
+		if found3.Status != statementDisputeResolved || found3.ResolvedAt == nil || len(found3.Annotations) != 2 || found3.Annotations[1].Note != "merchant refunded" {
+			t.Errorf("unexpected dispute: %#v", found3)
+		}
+		if found3, err := repo.getDispute(base.ID(), dispute.ID); err != nil || found3 != nil {
+			t.Errorf("dispute=%#v error=%v", found3, err)
+		}
+		if disputes, err := repo.getStatementDisputes(stmt.AccountID, "2020-01"); err != nil || len(disputes) != 1 {
+			t.Errorf("disputes=%#v error=%v", disputes, err)
+		}
+
+		stmt.Disputes = []statementDispute{*found3}
+		stmt.ClosingBalance, stmt.RegeneratedAt = 125, &now
+		if err := repo.updateStatement(stmt); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := repo.getStatement(stmt.AccountID, "2020-01"); found.ClosingBalance != 125 || found.RegeneratedAt == nil || len(found.Disputes) != 1 || len(found.Disputes[0].Annotations) != 2 {
+			t.Errorf("unexpected statement: %#v", found)
+		}
+
+		run := &statementRun{
+			ID:           base.ID(),
+			Cycle:        "2020-01",
+			Status:       statementRunRunning,
+			StartedAt:    now,
+			LastModified: now,
+		}
+		if err := repo.createRun(run); err != nil {
+			t.Fatal(err)
+		}
+		failure := statementFailure{AccountID: base.ID(), Error: "timeout", CreatedAt: now}
+		if err := repo.recordFailure(run.ID, failure); err != nil {
+			t.Fatal(err)
+		}
+		failure.Error = "timeout again"
+		if err := repo.recordFailure(run.ID, failure); err != nil {
+			t.Fatal(err)
+		}
+		run.Status, run.Processed, run.Failed, run.LastAccountID = statementRunCompleted, 10, 1, failure.AccountID
+		run.FinishedAt = &now
+		if err := repo.updateRun(run); err != nil {
+			t.Fatal(err)
+		}
+
+		found2, err := repo.getRun(run.ID)
+		if err != nil || found2 == nil {
+			t.Fatalf("run=%#v error=%v", found2, err)
+		}
+		if found2.Status != statementRunCompleted || found2.Processed != 10 || found2.FinishedAt == nil || found2.LastAccountID != failure.AccountID {
+			t.Errorf("unexpected run: %#v", found2)
+		}
+		if len(found2.Failures) != 1 || found2.Failures[0].Error != "timeout again" {
+			t.Errorf("unexpected failures: %#v", found2.Failures)
+		}
+		if err := repo.clearFailure(run.ID, failure.AccountID); err != nil {
+			t.Fatal(err)
+		}
+		if found2, _ := repo.getRun(run.ID); len(found2.Failures) != 0 {
+			t.Errorf("unexpected failures: %#v", found2.Failures)
+		}
+		if runs, err := repo.getRuns(10); err != nil || len(runs) != 1 {
+			t.Errorf("runs=%#v error=%v", runs, err)
+		}
+		if run, err := repo.getRun(base.ID()); err != nil || run != nil {
+			t.Errorf("run=%#v error=%v", run, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlStatementRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlStatementRepository{mysqlDB.DB, log.NewNopLogger()})
+}
@@ -0,0 +1,616 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// statementBatchSize is how many accounts are read at once while generating statements
+var statementBatchSize = 100
+
+// statementCycle returns the period of a monthly statement cycle (formatted as YYYY-MM) in loc. An empty cycle
+// is the month before now. Cycles which haven't ended yet are rejected.
+func statementCycle(cycle string, now time.Time, loc *time.Location) (time.Time, time.Time, error) {
+	now = now.In(loc)
+	var start time.Time
+	if cycle == "" {
+		start = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc).AddDate(0, -1, 0)
+	} else {
+		t, err := time.ParseInLocation("2006-01", cycle, loc)
+		if err != nil {
+			return start, start, fmt.Errorf("invalid cycle %q, expected YYYY-MM", cycle)
+		}
+		start = t
+	}
+	end := start.AddDate(0, 1, 0)
+	if end.After(now) {
+		return start, end, fmt.Errorf("cycle %s hasn't ended", start.Format("2006-01"))
+	}
+	return start, end, nil
+}
+
+// buildStatement summarizes an account's posted transactions over [start, end). Each transaction only keeps
+// the account's own lines.
+func buildStatement(accountID string, start, end time.Time, transactions []transaction) *statement {
+	stmt := &statement{
+		AccountID:    accountID,
+		Cycle:        start.Format("2006-01"),
+		PeriodStart:  start,
+		PeriodEnd:    end,
+		Transactions: []transaction{},
+	}
+	for i := range transactions {
+		// Only posted transactions, and the originals of reversals, affected balances
+		if transactions[i].Status != TransactionPosted && transactions[i].Status != TransactionReversed {
+			continue
+		}
+		if !transactions[i].Timestamp.Before(end) {
+			continue
+		}
+		tx := transactions[i]
+		tx.Lines = nil
+		tx.Attachments = nil
+		var credits, debits int64
+		for _, line := range transactions[i].Lines {
+			if line.AccountID != accountID {
+				continue
+			}
+			tx.Lines = append(tx.Lines, line)
+			if amt := lineAmount(line); amt < 0 {
+				debits += int64(-1 * amt)
+			} else {
+				credits += int64(amt)
+			}
+		}
+		if tx.Timestamp.Before(start) {
+			stmt.OpeningBalance += credits - debits
+			continue
+		}
+		stmt.Credits += credits
+		stmt.Debits += debits
+		stmt.Transactions = append(stmt.Transactions, tx)
+	}
+	stmt.ClosingBalance = stmt.OpeningBalance + stmt.Credits - stmt.Debits
+	sort.SliceStable(stmt.Transactions, func(i, j int) bool {
+		return stmt.Transactions[i].Timestamp.Before(stmt.Transactions[j].Timestamp)
+	})
+	return stmt
+}
+
+// statementGenerator runs batch jobs which generate the statement of every account for a cycle. Progress is saved
+// after each account so an interrupted or failed run can be resumed where it stopped. Resuming also retries the
+// accounts which failed earlier. Accounts which already have a statement for the cycle are skipped.
+type statementGenerator struct {
+	logger          log.Logger
+	accountRepo     accountRepository
+	transactionRepo transactionRepository
+	repo            statementRepository
+
+	// products, when set, decides the statement cycle of accounts assigned a product
+	products *productCatalog
+
+	mu     sync.Mutex
+	active map[string]bool // run IDs being processed
+	wg     sync.WaitGroup
+}
+
+func newStatementGenerator(logger log.Logger, accountRepo accountRepository, transactionRepo transactionRepository, repo statementRepository) *statementGenerator {
+	return &statementGenerator{
+		logger:          logger,
+		accountRepo:     accountRepo,
+		transactionRepo: transactionRepo,
+		repo:            repo,
+		active:          make(map[string]bool),
+	}
+}
+
+// start begins generating statements for cycle in the background. Accounts' cycles follow their time zones, so
+// runs only start once the cycle has ended in every time zone.
+func (g *statementGenerator) start(cycle string) (*statementRun, error) {
+	start, _, err := statementCycle(cycle, time.Now(), lastTimeZone)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	run := &statementRun{
+		ID:           newID(),
+		Cycle:        start.Format("2006-01"),
+		Status:       statementRunRunning,
+		StartedAt:    now,
+		LastModified: now,
+	}
+	if err := g.repo.createRun(run); err != nil {
+		return nil, err
+	}
+	g.launch(run)
+	return run, nil
+}
+
+// resume continues an unfinished run or retries the failed accounts of a finished one.
+func (g *statementGenerator) resume(runID string) (*statementRun, error) {
+	run, err := g.repo.getRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, fmt.Errorf("statement run=%s not found", runID)
+	}
+	if run.Status == statementRunCompleted && run.Failed == 0 {
+		return nil, fmt.Errorf("statement run=%s already completed", runID)
+	}
+	g.mu.Lock()
+	active := g.active[runID]
+	g.mu.Unlock()
+	if active {
+		return nil, fmt.Errorf("statement run=%s is already running", runID)
+	}
+
+	run.Status = statementRunRunning
+	run.Error = ""
+	run.FinishedAt = nil
+	run.LastModified = time.Now()
+	if err := g.repo.updateRun(run); err != nil {
+		return nil, err
+	}
+	g.launch(run)
+	return run, nil
+}
+
+// statementAutoGenerate reads STATEMENT_AUTO_GENERATE, which defaults to false.
+func statementAutoGenerate() (bool, error) {
+	v := os.Getenv("STATEMENT_AUTO_GENERATE")
+	if v == "" {
+		return false, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid STATEMENT_AUTO_GENERATE %q: %v", v, err)
+	}
+	return enabled, nil
+}
+
+// monthEnd starts generating last month's statements unless a run for it was already started, in which case a
+// run which failed or was interrupted is resumed. Last month is the one which most recently ended in every time zone.
+func (g *statementGenerator) monthEnd(now time.Time) (*statementRun, error) {
+	start, _, err := statementCycle("", now, lastTimeZone)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := g.repo.getCycleRuns(start.Format("2006-01"))
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return g.start(start.Format("2006-01"))
+	}
+	g.mu.Lock()
+	active := g.active[runs[0].ID]
+	g.mu.Unlock()
+	if active || runs[0].Status == statementRunCompleted {
+		return nil, nil
+	}
+	return g.resume(runs[0].ID)
+}
+
+// setupStatementJob generates each month's statements once it ends.
+func setupStatementJob(ctx context.Context, logger log.Logger, g *statementGenerator, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				run, err := g.monthEnd(now)
+				if err != nil {
+					logger.Log("statements", fmt.Sprintf("problem generating month end statements: %v", err))
+				} else if run != nil {
+					logger.Log("statements", fmt.Sprintf("generating cycle=%s statements with run=%s", run.Cycle, run.ID))
+				}
+			}
+		}
+	}()
+}
+
+func (g *statementGenerator) launch(run *statementRun) {
+	g.mu.Lock()
+	g.active[run.ID] = true
+	g.mu.Unlock()
+
+	// process a copy as callers still hold run
+	progress := *run
+
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.process(&progress)
+
+		g.mu.Lock()
+		delete(g.active, progress.ID)
+		g.mu.Unlock()
+	}()
+}
+
+func (g *statementGenerator) process(run *statementRun) {
+	g.logger.Log("statements", fmt.Sprintf("generating cycle=%s statements for run=%s", run.Cycle, run.ID))
+
+	// Retry accounts which failed in an earlier attempt
+	failures := run.Failures
+	run.Failures = nil
+	for i := range failures {
+		accts, err := g.accountRepo.GetAccounts(context.Background(), []string{failures[i].AccountID})
+		if err != nil {
+			g.finish(run, err)
+			return
+		}
+		run.Failed--
+		if len(accts) == 0 {
+			// the account was deleted since, so there's nothing to generate
+			g.repo.clearFailure(run.ID, failures[i].AccountID)
+			continue
+		}
+		g.processAccount(run, accts[0])
+		if err := g.save(run); err != nil {
+			return
+		}
+	}
+
+	for {
+		accts, err := g.accountRepo.ListAccounts(run.LastAccountID, statementBatchSize)
+		if err != nil {
+			g.finish(run, err)
+			return
+		}
+		if len(accts) == 0 {
+			break
+		}
+		for i := range accts {
+			run.Processed++
+			g.processAccount(run, accts[i])
+			run.LastAccountID = accts[i].ID
+			if err := g.save(run); err != nil {
+				return
+			}
+		}
+	}
+	g.finish(run, nil)
+}
+
+// processAccount generates an account's statement and records the outcome onto run.
+func (g *statementGenerator) processAccount(run *statementRun, account *accounts.Account) {
+	generated, err := g.generate(account, run.Cycle)
+	switch {
+	case err != nil:
+		run.Failed++
+		g.logger.Log("statements", fmt.Sprintf("problem generating cycle=%s statement for account=%s: %v", run.Cycle, account.ID, err))
+		failure := statementFailure{AccountID: account.ID, Error: err.Error(), CreatedAt: time.Now()}
+		if err := g.repo.recordFailure(run.ID, failure); err != nil {
+			g.logger.Log("statements", fmt.Sprintf("problem recording failure of account=%s: %v", account.ID, err))
+		}
+		return
+	case generated:
+		run.Generated++
+	default:
+		run.Skipped++
+	}
+	if err := g.repo.clearFailure(run.ID, account.ID); err != nil {
+		g.logger.Log("statements", fmt.Sprintf("problem clearing failure of account=%s: %v", account.ID, err))
+	}
+}
+
+// generate creates the statement of account for cycle, which runs from midnight to midnight in the account's time
+// zone. It returns false if the account doesn't need one.
+func (g *statementGenerator) generate(account *accounts.Account, cycle string) (bool, error) {
+	settings, err := g.products.settingsOf(account.ID)
+	if err != nil {
+		return false, err
+	}
+	start, end, err := statementCycle(cycle, time.Now(), settings.location())
+	if err != nil {
+		return false, err
+	}
+	cycle = start.Format("2006-01")
+	switch settings.statementCycle() {
+	case statementsNone:
+		return false, nil
+	case statementsQuarterly:
+		if start.Month()%3 != 0 {
+			return false, nil // quarters end with March, June, September and December cycles
+		}
+		start = end.AddDate(0, -3, 0)
+	}
+	if !account.CreatedAt.Before(end) || (!account.ClosedAt.IsZero() && account.ClosedAt.Before(start)) {
+		return false, nil // account wasn't open during the cycle
+	}
+	existing, err := g.repo.getStatement(account.ID, cycle)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		return false, nil
+	}
+
+	// Only the cycle's transactions are read, as daily totals sum up everything before it. Daily totals are of UTC
+	// days, so transactions are read from the start of the UTC day the cycle starts in and buildStatement adds those
+	// before the cycle to the opening balance.
+	from := start.UTC().Truncate(24 * time.Hour)
+	transactions, _, err := g.transactionRepo.getAccountTransactions(context.Background(), account.ID, transactionPage{StartDate: from, EndDate: end})
+	if err != nil {
+		return false, err
+	}
+	totals, err := g.transactionRepo.getDailyTotals(account.ID, from)
+	if err != nil {
+		return false, err
+	}
+	stmt := buildStatement(account.ID, start, end, transactions)
+	stmt.OpeningBalance += sumDailyTotals(totals)
+	stmt.ClosingBalance = stmt.OpeningBalance + stmt.Credits - stmt.Debits
+	stmt.Cycle = cycle
+	stmt.ID = newID()
+	if stmt.Disputes, err = g.statementDisputes(account.ID, cycle); err != nil {
+		return false, err
+	}
+	stmt.CreatedAt = time.Now()
+	if err := g.repo.createStatement(stmt); err != nil {
+		if err == errDuplicateStatement {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// statement returns an account's statement for a cycle, generating it first if it hasn't been already.
+func (g *statementGenerator) statement(ctx context.Context, accountID, cycle string) (*statement, error) {
+	loc, err := g.products.locationOf(accountID)
+	if err != nil {
+		return nil, err
+	}
+	start, _, err := statementCycle(cycle, time.Now(), loc)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := g.repo.getStatement(accountID, start.Format("2006-01"))
+	if err != nil || stmt != nil {
+		return stmt, err
+	}
+	accts, err := g.accountRepo.GetAccounts(ctx, []string{accountID})
+	if err != nil {
+		return nil, err
+	}
+	if len(accts) == 0 {
+		return nil, errAccountNotFound
+	}
+	if _, err := g.generate(accts[0], start.Format("2006-01")); err != nil {
+		return nil, err
+	}
+	stmt, err = g.repo.getStatement(accountID, start.Format("2006-01"))
+	if err != nil {
+		return nil, err
+	}
+	if stmt == nil {
+		return nil, errStatementNotFound // the account wasn't open or doesn't get statements this cycle
+	}
+	return stmt, nil
+}
+
+// save records the progress of run. Runs which can't be saved stop, and stay resumable from their last saved account.
+func (g *statementGenerator) save(run *statementRun) error {
+	run.LastModified = time.Now()
+	if err := g.repo.updateRun(run); err != nil {
+		g.logger.Log("statements", fmt.Sprintf("problem saving run=%s progress, stopping: %v", run.ID, err))
+		return err
+	}
+	return nil
+}
+
+func (g *statementGenerator) finish(run *statementRun, err error) {
+	now := time.Now()
+	run.Status = statementRunCompleted
+	if err != nil {
+		run.Status = statementRunFailed
+		run.Error = err.Error()
+	}
+	run.FinishedAt = &now
+	g.logger.Log("statements", fmt.Sprintf("run=%s %s: processed=%d generated=%d skipped=%d failed=%d", run.ID, run.Status, run.Processed, run.Generated, run.Skipped, run.Failed))
+	g.save(run)
+}
+
+// statementRuns is an admin route which lists runs (GET) or starts one (POST) for the ?cycle=YYYY-MM query
+// parameter, which defaults to last month.
+func statementRuns(logger log.Logger, g *statementGenerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "GET":
+			limit := 20
+			if v := r.URL.Query().Get("limit"); v != "" {
+				n, err := strconv.Atoi(v)
+				if err != nil || n <= 0 {
+					moovhttp.Problem(w, fmt.Errorf("invalid limit %q", v))
+					return
+				}
+				limit = n
+			}
+			runs, err := g.repo.getRuns(limit)
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			if runs == nil {
+				runs = []*statementRun{}
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(runs)
+
+		case "POST":
+			run, err := g.start(r.URL.Query().Get("cycle"))
+			if err != nil {
+				logger.Log("statements", fmt.Sprintf("problem starting statement run: %v", err))
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(run)
+
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// getStatementRun is an admin route which returns the progress and failures of a run.
+func getStatementRun(logger log.Logger, repo statementRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		runID := mux.Vars(r)["runId"]
+		if runID == "" {
+			moovhttp.Problem(w, errors.New("no runId found"))
+			return
+		}
+		run, err := repo.getRun(runID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if run == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(run)
+	}
+}
+
+// resumeStatementRun is an admin route which continues a run that stopped and retries its failed accounts.
+func resumeStatementRun(logger log.Logger, g *statementGenerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		run, err := g.resume(mux.Vars(r)["runId"])
+		if err != nil {
+			logger.Log("statements", fmt.Sprintf("problem resuming statement run: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(run)
+	}
+}
+
+// getAccountStatements is an admin route which lists the statements of an account, newest first.
+func getAccountStatements(logger log.Logger, repo statementRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		accountID := getAccountID(w, r)
+		if accountID == "" {
+			return
+		}
+		stmts, err := repo.getAccountStatements(accountID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if stmts == nil {
+			stmts = []*statement{}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(stmts)
+	}
+}
+
+// getAccountStatement is an admin route which returns an account's statement for a cycle as JSON, or rendered with
+// ?format=csv, pdf, ofx or qfx. Statements which weren't generated yet are generated and saved.
+func getAccountStatement(logger log.Logger, g *statementGenerator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		vars := mux.Vars(r)
+		accountID, cycle := vars["accountId"], vars["cycle"]
+		format := strings.ToLower(r.URL.Query().Get("format"))
+		switch format {
+		case "", "json", "csv", "pdf", "ofx", "qfx":
+		default:
+			moovhttp.Problem(w, fmt.Errorf("unknown statement format %q", format))
+			return
+		}
+
+		stmt, err := g.statement(requestContext(r), accountID, cycle)
+		if err != nil {
+			if err == errStatementNotFound || err == errAccountNotFound {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			logger.Log("statements", fmt.Sprintf("problem reading account=%s cycle=%s statement: %v", accountID, cycle, err))
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		filename := fmt.Sprintf("statement-%s-%s.%s", stmt.AccountID, stmt.Cycle, format)
+		switch format {
+		case "csv":
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+			w.WriteHeader(http.StatusOK)
+			writeStatementCSV(w, stmt)
+		case "pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+			w.WriteHeader(http.StatusOK)
+			writeStatementPDF(w, stmt)
+		case "ofx", "qfx":
+			// OFX identifies the account by its routing and account numbers
+			accts, err := g.accountRepo.GetAccounts(requestContext(r), []string{stmt.AccountID})
+			if err != nil || len(accts) == 0 {
+				moovhttp.Problem(w, fmt.Errorf("problem reading account=%s: %v", stmt.AccountID, err))
+				return
+			}
+			bankID := ""
+			if format == "qfx" {
+				bankID = statementQFXBankID
+			}
+			w.Header().Set("Content-Type", "application/x-ofx")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+			w.WriteHeader(http.StatusOK)
+			writeStatementOFX(w, stmt, accts[0], time.Now(), bankID)
+		default:
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			json.NewEncoder(w).Encode(stmt)
+		}
+	}
+}
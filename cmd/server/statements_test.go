@@ -0,0 +1,313 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestStatementCycle(t *testing.T) {
+	now := time.Date(2020, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+	start, end, err := statementCycle("", now, time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.Equal(time.Date(2020, time.February, 1, 0, 0, 0, 0, time.UTC)) || !end.Equal(time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("start=%v end=%v", start, end)
+	}
+	if _, _, err := statementCycle("2020-03", now, time.UTC); err == nil {
+		t.Error("expected error for a cycle which hasn't ended")
+	}
+	if _, _, err := statementCycle("March", now, time.UTC); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestBuildStatement(t *testing.T) {
+	start := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	tx := func(ts time.Time, status TransactionStatus, purpose TransactionPurpose, amount int) transaction {
+		return transaction{
+			ID:          base.ID(),
+			Timestamp:   ts,
+			Status:      status,
+			Description: "January payroll",
+			Lines: []transactionLine{
+				{AccountID: "account", Purpose: purpose, Amount: amount, Description: "Payroll"},
+				{AccountID: "other", Purpose: ACHDebit, Amount: amount},
+			},
+		}
+	}
+	transactions := []transaction{
+		tx(end.Add(time.Hour), TransactionPosted, ACHCredit, 1000), // after the cycle
+		tx(start.Add(48*time.Hour), TransactionPosted, ACHDebit, 200),
+		tx(start.Add(24*time.Hour), TransactionReversed, ACHCredit, 300),
+		tx(start.Add(24*time.Hour), TransactionPending, ACHCredit, 400),
+		tx(start.Add(-24*time.Hour), TransactionPosted, ACHCredit, 500), // opening balance
+	}
+
+	stmt := buildStatement("account", start, end, transactions)
+	if stmt.Cycle != "2020-01" || stmt.OpeningBalance != 500 || stmt.Credits != 300 || stmt.Debits != 200 || stmt.ClosingBalance != 600 {
+		t.Errorf("unexpected statement: %#v", stmt)
+	}
+	if len(stmt.Transactions) != 2 || stmt.Transactions[0].ID != transactions[2].ID || len(stmt.Transactions[0].Lines) != 1 {
+		t.Errorf("unexpected transactions: %#v", stmt.Transactions)
+	}
+	if tx := stmt.Transactions[0]; tx.Description != "January payroll" || tx.Lines[0].Description != "Payroll" {
+		t.Errorf("unexpected descriptions: %#v", tx)
+	}
+}
+
+// flakyTransactionRepository fails reading the transactions of one account until fail is cleared
+type flakyTransactionRepository struct {
+	*mockTransactionRepository
+
+	fail string
+}
+
+func (r *flakyTransactionRepository) getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+	if accountID == r.fail {
+		return nil, "", errors.New("timeout")
+	}
+	return r.mockTransactionRepository.getAccountTransactions(context.Background(), accountID, page)
+}
+
+func TestStatementGenerator(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlStatementRepository{db.DB, log.NewNopLogger()}
+
+	accountRepo := createTestSqlAccountRepository(t, db.DB)
+	opened := time.Date(2019, time.December, 1, 0, 0, 0, 0, time.UTC)
+	for i, acct := range []*accounts.Account{
+		{ID: "account-1", CreatedAt: opened},
+		{ID: "account-2", CreatedAt: opened},
+		{ID: "account-3", CreatedAt: time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)}, // opened after the cycle
+	} {
+		acct.AccountNumber, acct.RoutingNumber, acct.Status, acct.Type = fmt.Sprintf("%d", i), defaultRoutingNumber, "open", "checking"
+		if err := accountRepo.CreateAccount(base.ID(), acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	transactionRepo := &flakyTransactionRepository{
+		mockTransactionRepository: &mockTransactionRepository{
+			transactions: []transaction{
+				{
+					ID:        base.ID(),
+					Timestamp: time.Date(2020, time.January, 5, 0, 0, 0, 0, time.UTC),
+					Status:    TransactionPosted,
+					Lines: []transactionLine{
+						{AccountID: "account-1", Purpose: ACHCredit, Amount: 100},
+						{AccountID: "account-2", Purpose: ACHDebit, Amount: 100},
+					},
+				},
+			},
+		},
+		fail: "account-2",
+	}
+
+	statementBatchSize = 2
+	defer func() { statementBatchSize = 100 }()
+
+	g := newStatementGenerator(log.NewNopLogger(), accountRepo, transactionRepo, repo)
+	router := mux.NewRouter()
+	router.Path("/statements/runs").HandlerFunc(statementRuns(log.NewNopLogger(), g))
+	router.Path("/statements/runs/{runId}").HandlerFunc(getStatementRun(log.NewNopLogger(), repo))
+	router.Path("/statements/runs/{runId}/resume").HandlerFunc(resumeStatementRun(log.NewNopLogger(), g))
+	router.Path("/accounts/{accountId}/statements").HandlerFunc(getAccountStatements(log.NewNopLogger(), repo))
+
+	readRun := func(w *httptest.ResponseRecorder) *statementRun {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		var run statementRun
+		if err := json.NewDecoder(w.Body).Decode(&run); err != nil {
+			t.Fatal(err)
+		}
+		return &run
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/statements/runs?cycle=2999-01", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected an unfinished cycle to be rejected: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/statements/runs?cycle=2020-01", nil))
+	run := readRun(w)
+	g.wg.Wait()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/statements/runs/"+run.ID, nil))
+	run = readRun(w)
+	if run.Status != statementRunCompleted || run.Processed != 3 || run.Generated != 1 || run.Skipped != 1 || run.Failed != 1 || run.LastAccountID != "account-3" {
+		t.Errorf("unexpected run: %#v", run)
+	}
+	if len(run.Failures) != 1 || run.Failures[0].AccountID != "account-2" {
+		t.Errorf("unexpected failures: %#v", run.Failures)
+	}
+
+	// resuming retries the failed account
+	transactionRepo.fail = ""
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/statements/runs/"+run.ID+"/resume", nil))
+	readRun(w)
+	g.wg.Wait()
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/statements/runs/"+run.ID, nil))
+	run = readRun(w)
+	if run.Status != statementRunCompleted || run.Processed != 3 || run.Generated != 2 || run.Failed != 0 || len(run.Failures) != 0 {
+		t.Errorf("unexpected run: %#v", run)
+	}
+
+	// completed runs can't be resumed
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/statements/runs/"+run.ID+"/resume", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/accounts/account-2/statements", nil))
+	var stmts []statement
+	if err := json.NewDecoder(w.Body).Decode(&stmts); err != nil {
+		t.Fatal(err)
+	}
+	if len(stmts) != 1 || stmts[0].ClosingBalance != -100 || stmts[0].Debits != 100 {
+		t.Errorf("unexpected statements: %#v", stmts)
+	}
+}
+
+func TestStatementGenerator__monthEnd(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlStatementRepository{db.DB, log.NewNopLogger()}
+	accountRepo := createTestSqlAccountRepository(t, db.DB)
+	g := newStatementGenerator(log.NewNopLogger(), accountRepo, &mockTransactionRepository{}, repo)
+
+	now := time.Now()
+	run, err := g.monthEnd(now)
+	if err != nil || run == nil {
+		t.Fatalf("run=%#v error=%v", run, err)
+	}
+	g.wg.Wait()
+	if again, err := g.monthEnd(now); err != nil || again != nil {
+		t.Errorf("run=%#v error=%v", again, err)
+	}
+
+	// interrupted runs are resumed
+	run.Status = statementRunFailed
+	if err := repo.updateRun(run); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := g.monthEnd(now); err != nil || again == nil || again.ID != run.ID {
+		t.Errorf("run=%#v error=%v", again, err)
+	}
+	g.wg.Wait()
+	if runs, err := repo.getCycleRuns(run.Cycle); err != nil || len(runs) != 1 || runs[0].Status != statementRunCompleted {
+		t.Errorf("runs=%#v error=%v", runs, err)
+	}
+}
+
+func TestGetAccountStatement(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlStatementRepository{db.DB, log.NewNopLogger()}
+
+	accountRepo := createTestSqlAccountRepository(t, db.DB)
+	acct := &accounts.Account{ID: "account", AccountNumber: "1", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking", CreatedAt: time.Date(2019, time.December, 1, 0, 0, 0, 0, time.UTC)}
+	if err := accountRepo.CreateAccount(base.ID(), acct); err != nil {
+		t.Fatal(err)
+	}
+	transactionRepo := &mockTransactionRepository{
+		transactions: []transaction{
+			{
+				ID:        "payroll",
+				Timestamp: time.Date(2020, time.January, 5, 0, 0, 0, 0, time.UTC),
+				Status:    TransactionPosted,
+				Lines: []transactionLine{
+					{AccountID: "account", Purpose: ACHCredit, Amount: 12345, Description: "Payroll"},
+				},
+			},
+		},
+	}
+	g := newStatementGenerator(log.NewNopLogger(), accountRepo, transactionRepo, repo)
+	router := mux.NewRouter()
+	router.Path("/accounts/{accountId}/statements/{cycle}").HandlerFunc(getAccountStatement(log.NewNopLogger(), g))
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		w.Flush()
+		return w
+	}
+
+	// statements are generated on first read
+	w := get("/accounts/account/statements/2020-01")
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var stmt statement
+	if err := json.NewDecoder(w.Body).Decode(&stmt); err != nil {
+		t.Fatal(err)
+	}
+	if stmt.ClosingBalance != 12345 || len(stmt.Transactions) != 1 {
+		t.Errorf("unexpected statement: %#v", stmt)
+	}
+	if saved, err := repo.getStatement("account", "2020-01"); err != nil || saved == nil || saved.ID != stmt.ID {
+		t.Errorf("statement=%#v error=%v", saved, err)
+	}
+
+	w = get("/accounts/account/statements/2020-01?format=csv")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "2020-01-05,payroll,Payroll,achcredit,123.45,123.45,") {
+		t.Errorf("unexpected CSV: %s", w.Body.String())
+	}
+	w = get("/accounts/account/statements/2020-01?format=PDF")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "%PDF-") {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	statementQFXBankID = "12345"
+	defer func() { statementQFXBankID = "" }()
+	w = get("/accounts/account/statements/2020-01?format=qfx")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ofx" || !strings.Contains(w.Body.String(), "<INTU.BID>12345\r\n") {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Body.String(), "<TRNAMT>123.45\r\n<FITID>payroll\r\n") {
+		t.Errorf("unexpected QFX: %s", w.Body.String())
+	}
+
+	for path, status := range map[string]int{
+		"/accounts/account/statements/2020-01?format=xml": http.StatusBadRequest,
+		"/accounts/account/statements/2999-01":            http.StatusBadRequest,
+		"/accounts/account/statements/2019-11":            http.StatusNotFound, // before the account was opened
+		"/accounts/missing/statements/2020-01":            http.StatusNotFound,
+	} {
+		if w := get(path); w.Code != status {
+			t.Errorf("%s: bogus HTTP status: %d", path, w.Code)
+		}
+	}
+}
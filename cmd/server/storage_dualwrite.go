@@ -0,0 +1,378 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	shadowWriteErrors = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "storage_shadow_write_errors",
+		Help: "Counter of writes which succeeded on the primary storage but failed on the shadow",
+	}, []string{"repository"})
+
+	shadowReadMismatches = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "storage_shadow_read_mismatches",
+		Help: "Counter of reads where the shadow storage disagreed with the primary",
+	}, []string{"repository"})
+)
+
+// shadowReport collects the results of mirroring writes and comparing reads against a shadow
+// storage backend. It's served on the admin HTTP server so operators can decide when a migration
+// is safe to cut over.
+type shadowReport struct {
+	mu sync.Mutex
+
+	Writes      int64 `json:"writes"`
+	WriteErrors int64 `json:"writeErrors"`
+	Reads       int64 `json:"reads"`
+	Mismatches  int64 `json:"mismatches"`
+
+	// Samples holds the most recent problems found, capped at maxShadowSamples.
+	Samples []shadowSample `json:"samples"`
+}
+
+type shadowSample struct {
+	Repository string    `json:"repository"`
+	ID         string    `json:"id"`
+	Problem    string    `json:"problem"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+const maxShadowSamples = 100
+
+func (r *shadowReport) wrote(repository, id string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Writes++
+	if err != nil {
+		r.WriteErrors++
+		r.sample(repository, id, fmt.Sprintf("write: %v", err))
+	}
+}
+
+func (r *shadowReport) compared(repository, id string, problem string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.Reads++
+	if problem != "" {
+		r.Mismatches++
+		r.sample(repository, id, problem)
+	}
+}
+
+func (r *shadowReport) sample(repository, id, problem string) {
+	r.Samples = append(r.Samples, shadowSample{
+		Repository: repository,
+		ID:         id,
+		Problem:    problem,
+		Timestamp:  time.Now(),
+	})
+	if n := len(r.Samples); n > maxShadowSamples {
+		r.Samples = r.Samples[n-maxShadowSamples:]
+	}
+}
+
+func (r *shadowReport) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(r)
+}
+
+// dualWriteAccountRepository sends writes to both primary and shadow repositories, but only ever reads
+// from the primary. Errors from the shadow are logged and counted instead of returned so a misbehaving
+// shadow can't affect callers.
+type dualWriteAccountRepository struct {
+	primary accountRepository
+	shadow  accountRepository
+
+	logger log.Logger
+	report *shadowReport
+}
+
+func (r *dualWriteAccountRepository) Ping() error {
+	if err := r.shadow.Ping(); err != nil {
+		r.logger.Log("shadow", fmt.Sprintf("account shadow ping: %v", err))
+	}
+	return r.primary.Ping()
+}
+
+func (r *dualWriteAccountRepository) Close() error {
+	if err := r.shadow.Close(); err != nil {
+		r.logger.Log("shadow", fmt.Sprintf("account shadow close: %v", err))
+	}
+	return r.primary.Close()
+}
+
+func (r *dualWriteAccountRepository) GetAccounts(ctx context.Context, accountIDs []string) ([]*accounts.Account, error) {
+	accts, err := r.primary.GetAccounts(ctx, accountIDs)
+	if err != nil {
+		return nil, err
+	}
+	r.compare(accts, func() ([]*accounts.Account, error) { return r.shadow.GetAccounts(ctx, accountIDs) })
+	return accts, nil
+}
+
+func (r *dualWriteAccountRepository) CreateAccount(customerID string, account *accounts.Account) error {
+	if err := r.primary.CreateAccount(customerID, account); err != nil {
+		return err
+	}
+	err := r.shadow.CreateAccount(customerID, account)
+	if err != nil {
+		shadowWriteErrors.With("repository", "accounts").Add(1)
+		r.logger.Log("shadow", fmt.Sprintf("problem creating account=%s in shadow: %v", account.ID, err))
+	}
+	r.report.wrote("accounts", account.ID, err)
+	return nil
+}
+
+func (r *dualWriteAccountRepository) UpdateAccount(account *accounts.Account) error {
+	if err := r.primary.UpdateAccount(account); err != nil {
+		return err
+	}
+	err := r.shadow.UpdateAccount(account)
+	if err != nil {
+		shadowWriteErrors.With("repository", "accounts").Add(1)
+		r.logger.Log("shadow", fmt.Sprintf("problem updating account=%s in shadow: %v", account.ID, err))
+	}
+	r.report.wrote("accounts", account.ID, err)
+	return nil
+}
+
+func (r *dualWriteAccountRepository) SearchAccountsByCustomerID(ctx context.Context, customerID string) ([]*accounts.Account, error) {
+	accts, err := r.primary.SearchAccountsByCustomerID(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	r.compare(accts, func() ([]*accounts.Account, error) { return r.shadow.SearchAccountsByCustomerID(ctx, customerID) })
+	return accts, nil
+}
+
+func (r *dualWriteAccountRepository) SearchAccountsByRoutingNumber(ctx context.Context, accountNumber, routingNumber, acctType string) (*accounts.Account, error) {
+	return r.primary.SearchAccountsByRoutingNumber(ctx, accountNumber, routingNumber, acctType)
+}
+
+func (r *dualWriteAccountRepository) ListAccounts(after string, limit int) ([]*accounts.Account, error) {
+	return r.primary.ListAccounts(after, limit)
+}
+
+// compare reads the same data from the shadow repository and records any differences from what
+// the primary returned.
+func (r *dualWriteAccountRepository) compare(primary []*accounts.Account, read func() ([]*accounts.Account, error)) {
+	shadow, err := read()
+	if err != nil {
+		for i := range primary {
+			r.mismatch(primary[i].ID, fmt.Sprintf("read: %v", err))
+		}
+		return
+	}
+	found := make(map[string]*accounts.Account)
+	for i := range shadow {
+		found[shadow[i].ID] = shadow[i]
+	}
+	for i := range primary {
+		r.mismatch(primary[i].ID, diffAccounts(primary[i], found[primary[i].ID]))
+	}
+}
+
+func (r *dualWriteAccountRepository) mismatch(accountID string, problem string) {
+	if problem != "" {
+		shadowReadMismatches.With("repository", "accounts").Add(1)
+		r.logger.Log("shadow", fmt.Sprintf("account=%s mismatch: %s", accountID, problem))
+	}
+	r.report.compared("accounts", accountID, problem)
+}
+
+// diffAccounts returns a description of the first difference found between two accounts or
+// an empty string when they match.
+func diffAccounts(primary, shadow *accounts.Account) string {
+	if shadow == nil {
+		return "missing from shadow"
+	}
+	fields := []struct {
+		name string
+		p, s interface{}
+	}{
+		{"customerID", primary.CustomerID, shadow.CustomerID},
+		{"name", primary.Name, shadow.Name},
+		{"accountNumber", primary.AccountNumber, shadow.AccountNumber},
+		{"routingNumber", primary.RoutingNumber, shadow.RoutingNumber},
+		{"status", primary.Status, shadow.Status},
+		{"type", primary.Type, shadow.Type},
+		{"balance", primary.Balance, shadow.Balance},
+	}
+	for i := range fields {
+		if fields[i].p != fields[i].s {
+			return fmt.Sprintf("%s primary=%v shadow=%v", fields[i].name, fields[i].p, fields[i].s)
+		}
+	}
+	return ""
+}
+
+// dualWriteTransactionRepository mirrors transactions into a shadow repository. See dualWriteAccountRepository.
+type dualWriteTransactionRepository struct {
+	primary transactionRepository
+	shadow  transactionRepository
+
+	logger log.Logger
+	report *shadowReport
+}
+
+func (r *dualWriteTransactionRepository) Ping() error {
+	if err := r.shadow.Ping(); err != nil {
+		r.logger.Log("shadow", fmt.Sprintf("transaction shadow ping: %v", err))
+	}
+	return r.primary.Ping()
+}
+
+func (r *dualWriteTransactionRepository) Close() error {
+	if err := r.shadow.Close(); err != nil {
+		r.logger.Log("shadow", fmt.Sprintf("transaction shadow close: %v", err))
+	}
+	return r.primary.Close()
+}
+
+func (r *dualWriteTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	if err := r.primary.createTransaction(ctx, tx, opts); err != nil {
+		return err
+	}
+	// The primary has already enforced balance checks and limits, and the shadow might be missing history
+	// from before the migration started, so don't reject the copy on an overdraft or limit.
+	opts.AllowOverdraft, opts.Check = true, nil
+
+	err := r.shadow.createTransaction(ctx, tx, opts)
+	if err != nil {
+		shadowWriteErrors.With("repository", "transactions").Add(1)
+		r.logger.Log("shadow", fmt.Sprintf("problem creating transaction=%s in shadow: %v", tx.ID, err))
+	}
+	r.report.wrote("transactions", tx.ID, err)
+	return nil
+}
+
+func (r *dualWriteTransactionRepository) getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+	return r.primary.getAccountTransactions(ctx, accountID, page)
+}
+
+func (r *dualWriteTransactionRepository) getTransaction(ctx context.Context, transactionID string) (*transaction, error) {
+	tx, err := r.primary.getTransaction(ctx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	shadow, err := r.shadow.getTransaction(ctx, transactionID)
+	if err != nil {
+		r.mismatch(transactionID, fmt.Sprintf("read: %v", err))
+	} else {
+		r.mismatch(transactionID, diffTransactions(tx, shadow))
+	}
+	return tx, nil
+}
+
+func (r *dualWriteTransactionRepository) updateTransactionStatus(transactionID string, status TransactionStatus) error {
+	if err := r.primary.updateTransactionStatus(transactionID, status); err != nil {
+		return err
+	}
+	err := r.shadow.updateTransactionStatus(transactionID, status)
+	if err != nil {
+		shadowWriteErrors.With("repository", "transactions").Add(1)
+		r.logger.Log("shadow", fmt.Sprintf("problem updating transaction=%s status in shadow: %v", transactionID, err))
+	}
+	r.report.wrote("transactions", transactionID, err)
+	return nil
+}
+
+func (r *dualWriteTransactionRepository) getTransactionByIdempotencyKey(ctx context.Context, key string) (*transaction, error) {
+	return r.primary.getTransactionByIdempotencyKey(ctx, key)
+}
+
+func (r *dualWriteTransactionRepository) getExpiredHolds(now time.Time) ([]string, error) {
+	return r.primary.getExpiredHolds(now)
+}
+
+func (r *dualWriteTransactionRepository) getSegmentTotals(q segmentTotalsQuery) ([]segmentTotal, error) {
+	return r.primary.getSegmentTotals(q)
+}
+
+func (r *dualWriteTransactionRepository) getMCCSpend(q mccSpendQuery) ([]mccSpend, error) {
+	return r.primary.getMCCSpend(q)
+}
+
+func (r *dualWriteTransactionRepository) getTrialBalance(q trialBalanceQuery) ([]trialBalanceAccount, error) {
+	return r.primary.getTrialBalance(q)
+}
+
+func (r *dualWriteTransactionRepository) compactTransactionLines(before time.Time) (*compactionResult, error) {
+	result, err := r.primary.compactTransactionLines(before)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.shadow.compactTransactionLines(before); err != nil {
+		r.logger.Log("shadow", fmt.Sprintf("problem compacting shadow transaction lines: %v", err))
+	}
+	return result, nil
+}
+
+func (r *dualWriteTransactionRepository) getDailyTotals(accountID string, end time.Time) ([]dailyTotal, error) {
+	return r.primary.getDailyTotals(accountID, end)
+}
+
+func (r *dualWriteTransactionRepository) repairAccountBalance(accountID string, repair bool) (*balanceRepair, error) {
+	result, err := r.primary.repairAccountBalance(accountID, repair)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.shadow.repairAccountBalance(accountID, repair); err != nil {
+		r.logger.Log("shadow", fmt.Sprintf("problem repairing shadow account=%s balance: %v", accountID, err))
+	}
+	return result, nil
+}
+
+func (r *dualWriteTransactionRepository) mismatch(transactionID string, problem string) {
+	if problem != "" {
+		shadowReadMismatches.With("repository", "transactions").Add(1)
+		r.logger.Log("shadow", fmt.Sprintf("transaction=%s mismatch: %s", transactionID, problem))
+	}
+	r.report.compared("transactions", transactionID, problem)
+}
+
+// diffTransactions returns a description of the first difference found between two transactions or
+// an empty string when they match. Lines are compared by accountID as storage doesn't preserve ordering.
+func diffTransactions(primary, shadow *transaction) string {
+	if shadow == nil {
+		return "missing from shadow"
+	}
+	if primary.Status != shadow.Status {
+		return fmt.Sprintf("status primary=%s shadow=%s", primary.Status, shadow.Status)
+	}
+	if len(primary.Lines) != len(shadow.Lines) {
+		return fmt.Sprintf("lines primary=%d shadow=%d", len(primary.Lines), len(shadow.Lines))
+	}
+	lines := make(map[string]transactionLine)
+	for i := range shadow.Lines {
+		lines[shadow.Lines[i].AccountID] = shadow.Lines[i]
+	}
+	for i := range primary.Lines {
+		if line, exists := lines[primary.Lines[i].AccountID]; !exists || line != primary.Lines[i] {
+			return fmt.Sprintf("line for account=%s primary=%#v shadow=%#v", primary.Lines[i].AccountID, primary.Lines[i], line)
+		}
+	}
+	return ""
+}
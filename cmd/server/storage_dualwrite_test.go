@@ -0,0 +1,136 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestDualWriteAccountRepository(t *testing.T) {
+	account := &accounts.Account{ID: base.ID(), Name: "example", Balance: 100}
+
+	primary := &testAccountRepository{accounts: []*accounts.Account{account}}
+	shadow := &testAccountRepository{err: errors.New("bad thing")}
+	report := &shadowReport{}
+	repo := &dualWriteAccountRepository{primary: primary, shadow: shadow, logger: log.NewNopLogger(), report: report}
+
+	// shadow errors aren't returned
+	if err := repo.CreateAccount("customerID", account); err != nil {
+		t.Fatal(err)
+	}
+	if report.Writes != 1 || report.WriteErrors != 1 {
+		t.Errorf("writes=%d writeErrors=%d", report.Writes, report.WriteErrors)
+	}
+
+	// reads come from the primary, but are compared against the shadow
+	accts, err := repo.GetAccounts(context.Background(), []string{account.ID})
+	if err != nil || len(accts) != 1 {
+		t.Fatalf("accounts=%#v error=%v", accts, err)
+	}
+	if report.Reads != 1 || report.Mismatches != 1 {
+		t.Errorf("reads=%d mismatches=%d", report.Reads, report.Mismatches)
+	}
+
+	// fix the shadow, but give it a different balance
+	shadow.err = nil
+	shadow.accounts = []*accounts.Account{{ID: account.ID, Name: "example", Balance: 50}}
+	if _, err := repo.SearchAccountsByCustomerID(context.Background(), "customerID"); err != nil {
+		t.Fatal(err)
+	}
+	if report.Mismatches != 2 {
+		t.Errorf("mismatches=%d", report.Mismatches)
+	}
+	shadow.accounts[0].Balance = 100
+	if _, err := repo.GetAccounts(context.Background(), []string{account.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if report.Reads != 3 || report.Mismatches != 2 {
+		t.Errorf("reads=%d mismatches=%d", report.Reads, report.Mismatches)
+	}
+
+	// primary errors are returned
+	primary.err = errors.New("bad thing")
+	if err := repo.CreateAccount("customerID", account); err == nil {
+		t.Error("expected error")
+	}
+	if report.Writes != 1 {
+		t.Errorf("writes=%d", report.Writes)
+	}
+}
+
+func TestDualWriteTransactionRepository(t *testing.T) {
+	tx := transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now(),
+		Lines: []transactionLine{
+			{AccountID: base.ID(), Purpose: ACHDebit, Amount: 500},
+			{AccountID: base.ID(), Purpose: ACHCredit, Amount: 500},
+		},
+	}
+	primary := &mockTransactionRepository{transactions: []transaction{tx}}
+	shadow := &mockTransactionRepository{err: errors.New("bad thing")}
+	report := &shadowReport{}
+	repo := &dualWriteTransactionRepository{primary: primary, shadow: shadow, logger: log.NewNopLogger(), report: report}
+
+	if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if primary.created.ID != tx.ID || shadow.created.ID != tx.ID {
+		t.Errorf("primary=%s shadow=%s", primary.created.ID, shadow.created.ID)
+	}
+	if report.WriteErrors != 1 {
+		t.Errorf("writeErrors=%d", report.WriteErrors)
+	}
+
+	// matching reads
+	shadow.err = nil
+	shadow.transactions = []transaction{tx}
+	if _, err := repo.getTransaction(context.Background(), tx.ID); err != nil {
+		t.Fatal(err)
+	}
+	if report.Reads != 1 || report.Mismatches != 0 {
+		t.Errorf("reads=%d mismatches=%d", report.Reads, report.Mismatches)
+	}
+
+	// render the report
+	w := httptest.NewRecorder()
+	report.ServeHTTP(w, httptest.NewRequest("GET", "/storage/shadow", nil))
+	w.Flush()
+
+	var resp shadowReport
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Writes != 1 || len(resp.Samples) != 1 {
+		t.Errorf("writes=%d samples=%d", resp.Writes, len(resp.Samples))
+	}
+}
+
+func TestDualWrite__diffTransactions(t *testing.T) {
+	accountID := base.ID()
+	primary := &transaction{Lines: []transactionLine{{AccountID: accountID, Purpose: ACHCredit, Amount: 100}}}
+	if v := diffTransactions(primary, nil); v == "" {
+		t.Error("expected difference")
+	}
+	if v := diffTransactions(primary, &transaction{}); v == "" {
+		t.Error("expected difference")
+	}
+	if v := diffTransactions(primary, &transaction{Lines: []transactionLine{{AccountID: accountID, Purpose: ACHDebit, Amount: 100}}}); v == "" {
+		t.Error("expected difference")
+	}
+	if v := diffTransactions(primary, primary); v != "" {
+		t.Errorf("unexpected difference: %s", v)
+	}
+}
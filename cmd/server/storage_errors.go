@@ -0,0 +1,216 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/metrics/prometheus"
+	stdprometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	storageErrors = prometheus.NewCounterFrom(stdprometheus.CounterOpts{
+		Name: "storage_errors",
+		Help: "Counter of classified storage errors",
+	}, []string{"repository", "kind"})
+)
+
+// Kinds of storage errors which are reported. Other errors (e.g. validation or insufficient funds) are
+// expected during normal operation and aren't reported.
+const (
+	storageErrorCommit     = "commit_failure"
+	storageErrorConstraint = "constraint_violation"
+	storageErrorIntegrity  = "integrity_check_failure"
+)
+
+// classifyStorageError returns the kind of systemic storage problem err represents, or an empty
+// string when it shouldn't be reported.
+func classifyStorageError(err error) string {
+	if err == nil {
+		return ""
+	}
+	switch {
+	case database.UniqueViolation(err), database.BalanceConstraintViolation(err):
+		return storageErrorConstraint
+	case strings.Contains(err.Error(), ": commit"), strings.Contains(err.Error(), sql.ErrTxDone.Error()):
+		return storageErrorCommit
+	}
+	return ""
+}
+
+// storageErrorReporter classifies errors from a repository and sends systemic problems to an errorReporter.
+type storageErrorReporter struct {
+	repository string
+	reporter   errorReporter
+}
+
+func (r *storageErrorReporter) check(operation string, err error) error {
+	if kind := classifyStorageError(err); kind != "" {
+		r.send(kind, operation, err, nil)
+	}
+	return err
+}
+
+func (r *storageErrorReporter) send(kind, operation string, err error, extra map[string]string) {
+	storageErrors.With("repository", r.repository, "kind", kind).Add(1)
+	r.reporter.report(errorEvent{
+		Err:         err,
+		Fingerprint: []string{"storage", r.repository, kind, operation},
+		Tags: map[string]string{
+			"repository": r.repository,
+			"kind":       kind,
+			"operation":  operation,
+		},
+		Extra: extra,
+	})
+}
+
+// reportingAccountRepository reports classified errors from the wrapped accountRepository.
+type reportingAccountRepository struct {
+	repo     accountRepository
+	reporter *storageErrorReporter
+}
+
+func newReportingAccountRepository(repo accountRepository, reporter errorReporter) *reportingAccountRepository {
+	return &reportingAccountRepository{
+		repo:     repo,
+		reporter: &storageErrorReporter{repository: "accounts", reporter: reporter},
+	}
+}
+
+func (r *reportingAccountRepository) Ping() error {
+	return r.repo.Ping()
+}
+
+func (r *reportingAccountRepository) Close() error {
+	return r.repo.Close()
+}
+
+func (r *reportingAccountRepository) GetAccounts(ctx context.Context, accountIDs []string) ([]*accounts.Account, error) {
+	accts, err := r.repo.GetAccounts(ctx, accountIDs)
+	return accts, r.reporter.check("GetAccounts", err)
+}
+
+func (r *reportingAccountRepository) CreateAccount(customerID string, account *accounts.Account) error {
+	return r.reporter.check("CreateAccount", r.repo.CreateAccount(customerID, account))
+}
+
+func (r *reportingAccountRepository) UpdateAccount(account *accounts.Account) error {
+	return r.reporter.check("UpdateAccount", r.repo.UpdateAccount(account))
+}
+
+func (r *reportingAccountRepository) SearchAccountsByCustomerID(ctx context.Context, customerID string) ([]*accounts.Account, error) {
+	accts, err := r.repo.SearchAccountsByCustomerID(ctx, customerID)
+	return accts, r.reporter.check("SearchAccountsByCustomerID", err)
+}
+
+func (r *reportingAccountRepository) SearchAccountsByRoutingNumber(ctx context.Context, accountNumber, routingNumber, acctType string) (*accounts.Account, error) {
+	acct, err := r.repo.SearchAccountsByRoutingNumber(ctx, accountNumber, routingNumber, acctType)
+	return acct, r.reporter.check("SearchAccountsByRoutingNumber", err)
+}
+
+func (r *reportingAccountRepository) ListAccounts(after string, limit int) ([]*accounts.Account, error) {
+	accts, err := r.repo.ListAccounts(after, limit)
+	return accts, r.reporter.check("ListAccounts", err)
+}
+
+// reportingTransactionRepository reports classified errors from the wrapped transactionRepository. Balance
+// checks which find drift are reported as integrity check failures.
+type reportingTransactionRepository struct {
+	repo     transactionRepository
+	reporter *storageErrorReporter
+}
+
+func newReportingTransactionRepository(repo transactionRepository, reporter errorReporter) *reportingTransactionRepository {
+	return &reportingTransactionRepository{
+		repo:     repo,
+		reporter: &storageErrorReporter{repository: "transactions", reporter: reporter},
+	}
+}
+
+func (r *reportingTransactionRepository) Ping() error {
+	return r.repo.Ping()
+}
+
+func (r *reportingTransactionRepository) Close() error {
+	return r.repo.Close()
+}
+
+func (r *reportingTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	return r.reporter.check("createTransaction", r.repo.createTransaction(ctx, tx, opts))
+}
+
+func (r *reportingTransactionRepository) getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+	transactions, next, err := r.repo.getAccountTransactions(ctx, accountID, page)
+	return transactions, next, r.reporter.check("getAccountTransactions", err)
+}
+
+func (r *reportingTransactionRepository) getTransaction(ctx context.Context, transactionID string) (*transaction, error) {
+	tx, err := r.repo.getTransaction(ctx, transactionID)
+	return tx, r.reporter.check("getTransaction", err)
+}
+
+func (r *reportingTransactionRepository) getTransactionByIdempotencyKey(ctx context.Context, key string) (*transaction, error) {
+	tx, err := r.repo.getTransactionByIdempotencyKey(ctx, key)
+	return tx, r.reporter.check("getTransactionByIdempotencyKey", err)
+}
+
+func (r *reportingTransactionRepository) updateTransactionStatus(transactionID string, status TransactionStatus) error {
+	return r.reporter.check("updateTransactionStatus", r.repo.updateTransactionStatus(transactionID, status))
+}
+
+func (r *reportingTransactionRepository) getExpiredHolds(now time.Time) ([]string, error) {
+	transactionIDs, err := r.repo.getExpiredHolds(now)
+	return transactionIDs, r.reporter.check("getExpiredHolds", err)
+}
+
+func (r *reportingTransactionRepository) getSegmentTotals(q segmentTotalsQuery) ([]segmentTotal, error) {
+	totals, err := r.repo.getSegmentTotals(q)
+	return totals, r.reporter.check("getSegmentTotals", err)
+}
+
+func (r *reportingTransactionRepository) getMCCSpend(q mccSpendQuery) ([]mccSpend, error) {
+	spend, err := r.repo.getMCCSpend(q)
+	return spend, r.reporter.check("getMCCSpend", err)
+}
+
+func (r *reportingTransactionRepository) getTrialBalance(q trialBalanceQuery) ([]trialBalanceAccount, error) {
+	totals, err := r.repo.getTrialBalance(q)
+	return totals, r.reporter.check("getTrialBalance", err)
+}
+
+func (r *reportingTransactionRepository) getDailyTotals(accountID string, end time.Time) ([]dailyTotal, error) {
+	totals, err := r.repo.getDailyTotals(accountID, end)
+	return totals, r.reporter.check("getDailyTotals", err)
+}
+
+func (r *reportingTransactionRepository) compactTransactionLines(before time.Time) (*compactionResult, error) {
+	result, err := r.repo.compactTransactionLines(before)
+	return result, r.reporter.check("compactTransactionLines", err)
+}
+
+func (r *reportingTransactionRepository) repairAccountBalance(accountID string, repair bool) (*balanceRepair, error) {
+	result, err := r.repo.repairAccountBalance(accountID, repair)
+	if err != nil {
+		return nil, r.reporter.check("repairAccountBalance", err)
+	}
+	if result.Drift != 0 {
+		r.reporter.send(storageErrorIntegrity, "repairAccountBalance", fmt.Errorf("account=%s balance drifted by %d", accountID, result.Drift), map[string]string{
+			"accountID": accountID,
+			"expected":  fmt.Sprintf("%d", result.Expected),
+			"actual":    fmt.Sprintf("%d", result.Actual),
+			"repaired":  fmt.Sprintf("%v", result.Repaired),
+		})
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/moov-io/base"
+)
+
+func TestStorageErrors__classify(t *testing.T) {
+	if kind := classifyStorageError(nil); kind != "" {
+		t.Errorf("got %q", kind)
+	}
+	if kind := classifyStorageError(errors.New("account=foo has insufficient funds")); kind != "" {
+		t.Errorf("got %q", kind)
+	}
+	if kind := classifyStorageError(errors.New("createTransaction: insert: error=UNIQUE constraint failed: transaction_lines.transaction_id rollback=<nil>")); kind != storageErrorConstraint {
+		t.Errorf("got %q", kind)
+	}
+	if kind := classifyStorageError(errors.New("createTransaction: commit: database is locked")); kind != storageErrorCommit {
+		t.Errorf("got %q", kind)
+	}
+	if kind := classifyStorageError(fmt.Errorf("getTransaction: %v", sql.ErrTxDone)); kind != storageErrorCommit {
+		t.Errorf("got %q", kind)
+	}
+}
+
+func TestStorageErrors__transactions(t *testing.T) {
+	reporter := &mockErrorReporter{}
+	repo := newReportingTransactionRepository(&mockTransactionRepository{}, reporter)
+
+	// expected errors aren't reported
+	tx := transaction{ID: base.ID()}
+	if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{}); err == nil {
+		t.Error("expected error")
+	}
+	if len(reporter.events) != 0 {
+		t.Errorf("unexpected events: %#v", reporter.events)
+	}
+
+	repo.repo.(*mockTransactionRepository).err = errors.New("createTransaction: commit: database is locked")
+	if _, _, err := repo.getAccountTransactions(context.Background(), base.ID(), transactionPage{}); err == nil {
+		t.Error("expected error")
+	}
+	if len(reporter.events) != 1 {
+		t.Fatalf("got %d events", len(reporter.events))
+	}
+	evt := reporter.events[0]
+	if evt.Tags["kind"] != storageErrorCommit || evt.Fingerprint[3] != "getAccountTransactions" {
+		t.Errorf("unexpected event: %#v", evt)
+	}
+}
+
+type driftingTransactionRepository struct {
+	mockTransactionRepository
+}
+
+func (r *driftingTransactionRepository) repairAccountBalance(accountID string, repair bool) (*balanceRepair, error) {
+	return &balanceRepair{AccountID: accountID, Expected: 100, Actual: 90, Drift: 10}, nil
+}
+
+func TestStorageErrors__integrity(t *testing.T) {
+	reporter := &mockErrorReporter{}
+	repo := newReportingTransactionRepository(&driftingTransactionRepository{}, reporter)
+
+	if _, err := repo.repairAccountBalance(base.ID(), false); err != nil {
+		t.Fatal(err)
+	}
+	if len(reporter.events) != 1 || reporter.events[0].Tags["kind"] != storageErrorIntegrity {
+		t.Errorf("unexpected events: %#v", reporter.events)
+	}
+}
@@ -0,0 +1,509 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+)
+
+// inMemoryLedger holds accounts, transactions and balances for the in-memory repositories, which share it so
+// postings can check balances and accounts can read them. Everything is lost when the process exits.
+type inMemoryLedger struct {
+	mu sync.RWMutex
+
+	accounts     map[string]*accounts.Account
+	transactions map[string]*transaction
+	balances     map[string]int32
+
+	// accountTransactions are the IDs of each account's transactions, oldest first
+	accountTransactions map[string][]string
+
+	// idempotencyKeys are the IDs of transactions created with an idempotency key
+	idempotencyKeys map[string]string
+}
+
+// memoryStorage returns true when accounts and transactions are kept in memory. Either both or neither are, as
+// transactions check the balances held alongside accounts.
+func memoryStorage() (bool, error) {
+	accountsInMemory := strings.EqualFold(os.Getenv("ACCOUNT_STORAGE_TYPE"), "memory")
+	transactionsInMemory := strings.EqualFold(os.Getenv("TRANSACTION_STORAGE_TYPE"), "memory")
+	if accountsInMemory != transactionsInMemory {
+		return false, errors.New("memory storage must be used for both accounts and transactions")
+	}
+	if accountsInMemory && storageShards() > 1 {
+		return false, errors.New("memory storage can't be sharded")
+	}
+	return accountsInMemory, nil
+}
+
+// newInMemoryRepositories returns account and transaction repositories which never touch disk, used with
+// -storage=memory for demos and in tests.
+func newInMemoryRepositories() (*inMemoryAccountRepository, *inMemoryTransactionRepository) {
+	ledger := &inMemoryLedger{
+		accounts:            make(map[string]*accounts.Account),
+		transactions:        make(map[string]*transaction),
+		balances:            make(map[string]int32),
+		accountTransactions: make(map[string][]string),
+		idempotencyKeys:     make(map[string]string),
+	}
+	return &inMemoryAccountRepository{ledger}, &inMemoryTransactionRepository{ledger}
+}
+
+// getAccounts returns copies of each account found, with their balances. The caller must hold a lock.
+func (l *inMemoryLedger) getAccounts(accountIDs []string) []*accounts.Account {
+	var out []*accounts.Account
+	for _, id := range accountIDs {
+		if acct, exists := l.accounts[id]; exists {
+			a := *acct
+			a.Balance = l.balances[id]
+
+			var pending pendingBalance
+			for _, txID := range l.accountTransactions[id] {
+				t := l.transactions[txID]
+				for _, line := range t.Lines {
+					if line.AccountID == id {
+						pending.add(line, t.Status)
+					}
+				}
+			}
+			pending.apply(&a)
+			out = append(out, &a)
+		}
+	}
+	return out
+}
+
+type inMemoryAccountRepository struct {
+	ledger *inMemoryLedger
+}
+
+func (r *inMemoryAccountRepository) Ping() error {
+	return nil
+}
+
+func (r *inMemoryAccountRepository) Close() error {
+	return nil
+}
+
+func (r *inMemoryAccountRepository) GetAccounts(ctx context.Context, accountIDs []string) ([]*accounts.Account, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	var out []*accounts.Account
+	for _, acct := range r.ledger.getAccounts(accountIDs) {
+		if inOrganization(ctx, acct.OrganizationID) {
+			out = append(out, acct)
+		}
+	}
+	return out, nil
+}
+
+func (r *inMemoryAccountRepository) CreateAccount(customerID string, a *accounts.Account) error {
+	if err := validateAccount(a); err != nil {
+		return fmt.Errorf("CreateAccount: %v", err)
+	}
+	r.ledger.mu.Lock()
+	defer r.ledger.mu.Unlock()
+
+	if _, exists := r.ledger.accounts[a.ID]; exists {
+		return fmt.Errorf("CreateAccount: account=%q already exists", a.ID)
+	}
+	for _, acct := range r.ledger.accounts {
+		if acct.AccountNumber == a.AccountNumber && acct.RoutingNumber == a.RoutingNumber {
+			return errAccountExists
+		}
+	}
+	acct := *a
+	acct.Balance = 0
+	r.ledger.accounts[a.ID] = &acct
+	return nil
+}
+
+func (r *inMemoryAccountRepository) UpdateAccount(a *accounts.Account) error {
+	r.ledger.mu.Lock()
+	defer r.ledger.mu.Unlock()
+
+	acct, exists := r.ledger.accounts[a.ID]
+	if !exists {
+		return errAccountNotFound
+	}
+	acct.Name, acct.Status, acct.Type, acct.OverdraftLimit = a.Name, a.Status, a.Type, a.OverdraftLimit
+	acct.ClosedAt, acct.LastModified = a.ClosedAt, a.LastModified
+	return nil
+}
+
+func (r *inMemoryAccountRepository) SearchAccountsByRoutingNumber(ctx context.Context, accountNumber, routingNumber, acctType string) (*accounts.Account, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	for id, acct := range r.ledger.accounts {
+		if acct.AccountNumber == accountNumber && acct.RoutingNumber == routingNumber && strings.EqualFold(acct.Type, acctType) && acct.Type != string(System) && inOrganization(ctx, acct.OrganizationID) {
+			return r.ledger.getAccounts([]string{id})[0], nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *inMemoryAccountRepository) SearchAccountsByCustomerID(ctx context.Context, customerID string) ([]*accounts.Account, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	var accountIDs []string
+	for id, acct := range r.ledger.accounts {
+		if acct.CustomerID == customerID && acct.Type != string(System) && inOrganization(ctx, acct.OrganizationID) {
+			accountIDs = append(accountIDs, id)
+		}
+	}
+	sort.Strings(accountIDs)
+	return r.ledger.getAccounts(accountIDs), nil
+}
+
+func (r *inMemoryAccountRepository) ListAccounts(after string, limit int) ([]*accounts.Account, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	var accountIDs []string
+	for id := range r.ledger.accounts {
+		if id > after {
+			accountIDs = append(accountIDs, id)
+		}
+	}
+	sort.Strings(accountIDs)
+	if len(accountIDs) > limit {
+		accountIDs = accountIDs[:limit]
+	}
+	return r.ledger.getAccounts(accountIDs), nil
+}
+
+type inMemoryTransactionRepository struct {
+	ledger *inMemoryLedger
+}
+
+func (r *inMemoryTransactionRepository) Ping() error {
+	return nil
+}
+
+func (r *inMemoryTransactionRepository) Close() error {
+	return nil
+}
+
+func copyTransaction(t *transaction) *transaction {
+	out := *t
+	out.Lines = make([]transactionLine, len(t.Lines))
+	copy(out.Lines, t.Lines)
+	return &out
+}
+
+func (r *inMemoryTransactionRepository) createTransaction(ctx context.Context, t transaction, opts createTransactionOpts) error {
+	if err := t.validate(); err != nil && !opts.InitialDeposit {
+		return fmt.Errorf("transaction=%q is invalid: %v", t.ID, err)
+	}
+
+	r.ledger.mu.Lock()
+	defer r.ledger.mu.Unlock()
+
+	if _, exists := r.ledger.transactions[t.ID]; exists {
+		return fmt.Errorf("createTransaction: transaction=%q: %v", t.ID, errDuplicateTransactionID)
+	}
+	var accts []*accounts.Account
+	for _, accountID := range grabAccountIDs(t.Lines) {
+		if acct, exists := r.ledger.accounts[accountID]; exists {
+			accts = append(accts, acct)
+		}
+	}
+	if _, err := organizeTransaction(ctx, &t, accts); err != nil {
+		return fmt.Errorf("createTransaction: transaction=%q: %v", t.ID, err)
+	}
+	if _, exists := r.ledger.idempotencyKeys[idempotencyKey(t.OrganizationID, t.IdempotencyKey)]; exists && t.IdempotencyKey != "" {
+		return fmt.Errorf("createTransaction: transaction=%q: idempotency key %q already exists", t.ID, t.IdempotencyKey)
+	}
+	if err := checkAccountStatuses(accts, t.Lines); err != nil {
+		return fmt.Errorf("createTransaction: transaction=%q: %v", t.ID, err)
+	}
+	var reversed *transaction
+	if opts.Reverses != "" {
+		reversed = r.ledger.transactions[opts.Reverses]
+		if reversed == nil || !inOrganization(ctx, reversed.OrganizationID) {
+			return fmt.Errorf("createTransaction: transaction=%q: reverses transaction=%q not found", t.ID, opts.Reverses)
+		}
+		if err := reversed.Status.transition(TransactionReversed); err != nil {
+			return fmt.Errorf("createTransaction: transaction=%q: reverses transaction=%q: %v", t.ID, opts.Reverses, err)
+		}
+	}
+	if opts.Check != nil {
+		if err := opts.Check(ctx, accountTransactionsFunc(r.accountTransactions)); err != nil {
+			return fmt.Errorf("createTransaction: %v", err)
+		}
+	}
+	if t.Status == "" {
+		t.Status = TransactionPosted
+	}
+	// Pending transactions don't affect balances until they're posted and held transactions only reserve their debits
+	switch t.Status {
+	case TransactionPosted:
+		if err := r.applyLines(t, opts); err != nil {
+			return fmt.Errorf("createTransaction: transaction=%q: %w", t.ID, err)
+		}
+	case TransactionHeld:
+		if err := r.applyLines(transaction{ID: t.ID, Lines: holdLines(t.Lines, TransactionHeld)}, opts); err != nil {
+			return fmt.Errorf("createTransaction: transaction=%q: %w", t.ID, err)
+		}
+	}
+	if reversed != nil {
+		reversed.Status = TransactionReversed
+	}
+	r.ledger.transactions[t.ID] = copyTransaction(&t)
+	if t.IdempotencyKey != "" {
+		r.ledger.idempotencyKeys[idempotencyKey(t.OrganizationID, t.IdempotencyKey)] = t.ID
+	}
+	for _, accountID := range grabAccountIDs(t.Lines) {
+		r.ledger.accountTransactions[accountID] = append(r.ledger.accountTransactions[accountID], t.ID)
+	}
+	return nil
+}
+
+// applyLines adds each line of a transaction onto its account's balance with the same insufficient funds checks
+// as sqlTransactionRepository.applyLines. Balances are only changed if every line is accepted. The caller must
+// hold the write lock.
+func (r *inMemoryTransactionRepository) applyLines(t transaction, opts createTransactionOpts) error {
+	accounts := r.ledger.getAccounts(grabAccountIDs(t.Lines))
+	balances := make(map[string]int32)
+	for i := range t.Lines {
+		accountID := t.Lines[i].AccountID
+		if _, exists := balances[accountID]; !exists {
+			balances[accountID] = r.ledger.balances[accountID]
+		}
+		balances[accountID] += int32(lineAmount(t.Lines[i]))
+
+		if opts.InitialDeposit {
+			if t.Lines[0].Purpose != ACHCredit {
+				return errors.New("InitialDeposit must be ACHCredit")
+			}
+			if len(t.Lines) == 1 && t.Lines[0].Amount > 100 {
+				continue
+			}
+		}
+		if opts.AllowOverdraft || lineAmount(t.Lines[i]) >= 0 || !isInternalDebit(accounts, t.Lines, defaultRoutingNumber) {
+			continue
+		}
+		if overdrawn(accounts, t.Lines[i], balances[accountID]) {
+			return fmt.Errorf("account=%q has %w", accountID, errInsufficientFunds)
+		}
+	}
+	for accountID, balance := range balances {
+		r.ledger.balances[accountID] = balance
+	}
+	return nil
+}
+
+func (r *inMemoryTransactionRepository) getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	return r.accountTransactions(ctx, accountID, page)
+}
+
+// accountTransactions reads a page of the account's transactions while the caller holds the ledger's lock.
+func (r *inMemoryTransactionRepository) accountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+	ids := r.ledger.accountTransactions[accountID]
+	start := len(ids) - 1
+	if page.Cursor != "" {
+		found := false
+		for i := range ids {
+			if ids[i] == page.Cursor {
+				start, found = i-1, true
+				break
+			}
+		}
+		if !found {
+			return nil, "", fmt.Errorf("getAccountTransactions: %v", errInvalidCursor)
+		}
+	}
+	transactions := make([]transaction, 0)
+	for i := start; i >= 0; i-- { // newest first
+		t := r.ledger.transactions[ids[i]]
+		if !page.matches(t, accountID) || !inOrganization(ctx, t.OrganizationID) {
+			continue
+		}
+		if page.Limit > 0 && len(transactions) == page.Limit {
+			return transactions, transactions[len(transactions)-1].ID, nil
+		}
+		transactions = append(transactions, *copyTransaction(t))
+	}
+	return transactions, "", nil
+}
+
+func (r *inMemoryTransactionRepository) getTransaction(ctx context.Context, transactionID string) (*transaction, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	t, exists := r.ledger.transactions[transactionID]
+	if !exists || !inOrganization(ctx, t.OrganizationID) {
+		return nil, fmt.Errorf("getTransaction: transaction=%q not found", transactionID)
+	}
+	return copyTransaction(t), nil
+}
+
+func (r *inMemoryTransactionRepository) getTransactionByIdempotencyKey(ctx context.Context, key string) (*transaction, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	organization, _ := organizationFrom(ctx)
+	if transactionID, exists := r.ledger.idempotencyKeys[idempotencyKey(organization, key)]; exists {
+		return copyTransaction(r.ledger.transactions[transactionID]), nil
+	}
+	return nil, nil
+}
+
+// idempotencyKey returns the key idempotencyKeys holds a transaction under, as keys are unique per organization.
+func idempotencyKey(organization, key string) string {
+	return organization + "/" + key
+}
+
+func (r *inMemoryTransactionRepository) updateTransactionStatus(transactionID string, status TransactionStatus) error {
+	r.ledger.mu.Lock()
+	defer r.ledger.mu.Unlock()
+
+	t, exists := r.ledger.transactions[transactionID]
+	if !exists {
+		return fmt.Errorf("updateTransactionStatus: transaction=%q not found", transactionID)
+	}
+	if err := t.Status.transition(status); err != nil {
+		return fmt.Errorf("updateTransactionStatus: transaction=%q: %v", transactionID, err)
+	}
+	switch {
+	case t.Status == TransactionPending && status == TransactionPosted:
+		if err := r.applyLines(*t, createTransactionOpts{}); err != nil {
+			return fmt.Errorf("updateTransactionStatus: transaction=%q: %v", transactionID, err)
+		}
+	case t.Status == TransactionHeld:
+		if status == TransactionPosted && t.holdExpired(time.Now()) {
+			return fmt.Errorf("updateTransactionStatus: transaction=%q: %v", transactionID, errHoldExpired)
+		}
+		lines := transaction{ID: t.ID, Lines: holdLines(t.Lines, status)}
+		if err := r.applyLines(lines, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			return fmt.Errorf("updateTransactionStatus: transaction=%q: %v", transactionID, err)
+		}
+	}
+	t.Status = status
+	return nil
+}
+
+func (r *inMemoryTransactionRepository) getExpiredHolds(now time.Time) ([]string, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	var transactionIDs []string
+	for id, t := range r.ledger.transactions {
+		if t.holdExpired(now) {
+			transactionIDs = append(transactionIDs, id)
+		}
+	}
+	sort.Strings(transactionIDs)
+	return transactionIDs, nil
+}
+
+func (r *inMemoryTransactionRepository) getSegmentTotals(q segmentTotalsQuery) ([]segmentTotal, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	var totals []segmentTotal
+	for _, t := range r.ledger.transactions {
+		for _, line := range t.Lines {
+			if q.includes(t, line) {
+				total := segmentTotal{Value: q.Segment.value(line)}
+				total.add(line)
+				totals = append(totals, total)
+			}
+		}
+	}
+	return mergeSegmentTotals(totals), nil
+}
+
+func (r *inMemoryTransactionRepository) getMCCSpend(q mccSpendQuery) ([]mccSpend, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	var spend []mccSpend
+	for _, t := range r.ledger.transactions {
+		if amount := q.spend(t); amount > 0 {
+			spend = append(spend, mccSpend{MCC: t.mcc(), Amount: amount, Transactions: 1})
+		}
+	}
+	return mergeMCCSpend(spend), nil
+}
+
+func (r *inMemoryTransactionRepository) getTrialBalance(q trialBalanceQuery) ([]trialBalanceAccount, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	var totals []trialBalanceAccount
+	for _, t := range r.ledger.transactions {
+		for _, line := range t.Lines {
+			if q.includes(t, line) {
+				total := trialBalanceAccount{AccountID: line.AccountID}
+				total.add(line)
+				totals = append(totals, total)
+			}
+		}
+	}
+	return mergeTrialBalance(totals), nil
+}
+
+func (r *inMemoryTransactionRepository) getDailyTotals(accountID string, end time.Time) ([]dailyTotal, error) {
+	r.ledger.mu.RLock()
+	defer r.ledger.mu.RUnlock()
+
+	var transactions []*transaction
+	for _, id := range r.ledger.accountTransactions[accountID] {
+		transactions = append(transactions, r.ledger.transactions[id])
+	}
+	return dailyTotalsOf(accountID, end, transactions), nil
+}
+
+// compactTransactionLines has nothing to do as in-memory transactions are never archived.
+func (r *inMemoryTransactionRepository) compactTransactionLines(before time.Time) (*compactionResult, error) {
+	return &compactionResult{Before: before}, nil
+}
+
+func (r *inMemoryTransactionRepository) repairAccountBalance(accountID string, repair bool) (*balanceRepair, error) {
+	r.ledger.mu.Lock()
+	defer r.ledger.mu.Unlock()
+
+	result := &balanceRepair{AccountID: accountID}
+	for _, id := range r.ledger.accountTransactions[accountID] {
+		t := r.ledger.transactions[id]
+		lines := t.Lines
+		switch t.Status {
+		case TransactionPosted, TransactionReversed:
+		case TransactionHeld:
+			lines = holdLines(t.Lines, TransactionHeld)
+		default:
+			continue
+		}
+		for i := range lines {
+			if lines[i].AccountID == accountID {
+				result.Expected += int64(lineAmount(lines[i]))
+			}
+		}
+	}
+	result.Actual = int64(r.ledger.balances[accountID])
+	result.Drift = result.Actual - result.Expected
+
+	if repair && result.Drift != 0 {
+		r.ledger.balances[accountID] = int32(result.Expected)
+		result.Repaired = true
+	}
+	return result, nil
+}
@@ -0,0 +1,168 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+)
+
+func TestInMemory__accounts(t *testing.T) {
+	repo, _ := newInMemoryRepositories()
+
+	acct := &accounts.Account{ID: "a", CustomerID: "customer", AccountNumber: "123", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "Checking"}
+	if err := repo.CreateAccount(acct.CustomerID, acct); err != nil {
+		t.Fatal(err)
+	}
+	other := &accounts.Account{ID: "b", CustomerID: "customer", AccountNumber: "123", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "Savings"}
+	if err := repo.CreateAccount(other.CustomerID, other); err != errAccountExists {
+		t.Errorf("expected error for duplicate account number: %v", err)
+	}
+	other.AccountNumber = "456"
+	if err := repo.CreateAccount(other.CustomerID, other); err != nil {
+		t.Fatal(err)
+	}
+
+	if accts, err := repo.GetAccounts(context.Background(), nil); err != nil || accts != nil {
+		t.Errorf("accounts=%#v error=%v", accts, err)
+	}
+	if accts, err := repo.GetAccounts(context.Background(), []string{"a", "missing"}); err != nil || len(accts) != 1 || accts[0].ID != "a" {
+		t.Errorf("accounts=%#v error=%v", accts, err)
+	}
+
+	if a, err := repo.SearchAccountsByRoutingNumber(context.Background(), "123", defaultRoutingNumber, "checking"); err != nil || a == nil || a.ID != "a" {
+		t.Errorf("account=%#v error=%v", a, err)
+	}
+	if a, err := repo.SearchAccountsByRoutingNumber(context.Background(), "999", defaultRoutingNumber, "checking"); err != nil || a != nil {
+		t.Errorf("account=%#v error=%v", a, err)
+	}
+	if accts, err := repo.SearchAccountsByCustomerID(context.Background(), "customer"); err != nil || len(accts) != 2 {
+		t.Errorf("accounts=%#v error=%v", accts, err)
+	}
+
+	if accts, err := repo.ListAccounts("", 1); err != nil || len(accts) != 1 || accts[0].ID != "a" {
+		t.Errorf("accounts=%#v error=%v", accts, err)
+	}
+	if accts, err := repo.ListAccounts("a", 10); err != nil || len(accts) != 1 || accts[0].ID != "b" {
+		t.Errorf("accounts=%#v error=%v", accts, err)
+	}
+}
+
+func TestInMemory__transactions(t *testing.T) {
+	accountRepo, repo := newInMemoryRepositories()
+	for _, id := range []string{"a", "b"} {
+		acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: id, RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+		if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	balance := func(accountID string) int32 {
+		t.Helper()
+		accts, err := accountRepo.GetAccounts(context.Background(), []string{accountID})
+		if err != nil || len(accts) != 1 {
+			t.Fatalf("accounts=%#v error=%v", accts, err)
+		}
+		return accts[0].Balance
+	}
+	transfer := func(id string, amount int, status TransactionStatus) transaction {
+		return transaction{
+			ID:        id,
+			Timestamp: time.Now(),
+			Status:    status,
+			Lines: []transactionLine{
+				{AccountID: "a", Purpose: ACHDebit, Amount: amount},
+				{AccountID: "b", Purpose: ACHCredit, Amount: amount},
+			},
+		}
+	}
+
+	deposit := transaction{
+		ID:        "deposit",
+		Timestamp: time.Now(),
+		Lines:     []transactionLine{{AccountID: "a", Purpose: ACHCredit, Amount: 1000}},
+	}
+	if err := repo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err == nil || !strings.Contains(err.Error(), errDuplicateTransactionID.Error()) {
+		t.Errorf("expected duplicate error: %v", err)
+	}
+
+	// overdrafts are rejected without touching either balance
+	if err := repo.createTransaction(context.Background(), transfer("overdraft", 5000, ""), createTransactionOpts{}); !errors.Is(err, errInsufficientFunds) {
+		t.Errorf("expected insufficient funds: %v", err)
+	}
+	if err := repo.createTransaction(context.Background(), transfer("posted", 400, ""), createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if a, b := balance("a"), balance("b"); a != 600 || b != 400 {
+		t.Errorf("a=%d b=%d", a, b)
+	}
+
+	// pending transactions are only applied once they're posted
+	if err := repo.createTransaction(context.Background(), transfer("pending", 100, TransactionPending), createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if a := balance("a"); a != 600 {
+		t.Errorf("a=%d", a)
+	}
+	if err := repo.updateTransactionStatus("pending", TransactionPosted); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.updateTransactionStatus("pending", TransactionVoided); err == nil {
+		t.Error("expected error voiding a posted transaction")
+	}
+	if a := balance("a"); a != 500 {
+		t.Errorf("a=%d", a)
+	}
+
+	transactions, _, err := repo.getAccountTransactions(context.Background(), "a", transactionPage{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 3 || transactions[0].ID != "pending" || transactions[2].ID != "deposit" {
+		t.Errorf("unexpected transactions: %#v", transactions)
+	}
+	if tx, err := repo.getTransaction(context.Background(), "posted"); err != nil || tx.Status != TransactionPosted {
+		t.Errorf("transaction=%#v error=%v", tx, err)
+	}
+	if tx, err := repo.getTransaction(context.Background(), "missing"); err == nil || tx != nil {
+		t.Errorf("transaction=%#v error=%v", tx, err)
+	}
+
+	// drift is found and repaired
+	accountRepo.ledger.balances["a"] = 1
+	if result, err := repo.repairAccountBalance("a", true); err != nil || result.Expected != 500 || result.Drift != -499 || !result.Repaired {
+		t.Errorf("result=%#v error=%v", result, err)
+	}
+	if a := balance("a"); a != 500 {
+		t.Errorf("a=%d", a)
+	}
+}
+
+func TestInMemory__memoryStorage(t *testing.T) {
+	check := func(accountType, transactionType string) (bool, error) {
+		os.Setenv("ACCOUNT_STORAGE_TYPE", accountType)
+		os.Setenv("TRANSACTION_STORAGE_TYPE", transactionType)
+		defer os.Unsetenv("ACCOUNT_STORAGE_TYPE")
+		defer os.Unsetenv("TRANSACTION_STORAGE_TYPE")
+		return memoryStorage()
+	}
+	if ok, err := check("", ""); ok || err != nil {
+		t.Errorf("ok=%v error=%v", ok, err)
+	}
+	if ok, err := check("memory", "Memory"); !ok || err != nil {
+		t.Errorf("ok=%v error=%v", ok, err)
+	}
+	if _, err := check("memory", "sqlite"); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,362 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+var (
+	errCrossShardTransaction = errors.New("transaction lines span multiple storage shards")
+)
+
+// storageShards returns how many database shards accounts are partitioned across. Deployments must not
+// change this once data has been written as accounts are not rebalanced between shards.
+func storageShards() int {
+	if v := os.Getenv("STORAGE_SHARDS"); v != "" {
+		if n, _ := strconv.ParseInt(v, 10, 32); n > 1 {
+			return int(n)
+		}
+	}
+	return 1
+}
+
+// systemAccountEngines are the settings of features which post between customer accounts and one system account,
+// such as fees credited to FEE_ACCOUNT_ID. A system account lives on a single shard, so those postings would fail
+// with errCrossShardTransaction for most accounts.
+var systemAccountEngines = []string{
+	"FEE_ACCOUNT_ID",
+	"INTEREST_EXPENSE_ACCOUNT_ID",
+	"REWARDS_RATES",
+	"PROMOTIONS_FUNDING_ACCOUNT_ID",
+	"NETTING_SETTLEMENT_ACCOUNT_ID",
+}
+
+// checkShardedEngines refuses to start sharded storage with any of the systemAccountEngines enabled.
+func checkShardedEngines(shards int) error {
+	if shards <= 1 {
+		return nil
+	}
+	for _, key := range systemAccountEngines {
+		if os.Getenv(key) != "" {
+			return fmt.Errorf("%s can't be used with STORAGE_SHARDS=%d as its system account is only on one shard", key, shards)
+		}
+	}
+	return nil
+}
+
+// shardFor returns the index of the shard which stores the given accountID.
+func shardFor(accountID string, shards int) int {
+	h := fnv.New32a()
+	h.Write([]byte(accountID))
+	return int(h.Sum32() % uint32(shards))
+}
+
+func setupShardedAccountStorage(ctx context.Context, logger log.Logger, _type string, shards int) (*shardedAccountRepository, error) {
+	repo := &shardedAccountRepository{}
+	for i := 0; i < shards; i++ {
+		db, err := database.NewShard(ctx, logger, _type, i)
+		if err != nil {
+			return nil, fmt.Errorf("account shard %d: %v", i, err)
+		}
+		shard, err := setupSqlAccountStorage(ctx, logger, db)
+		if err != nil {
+			return nil, fmt.Errorf("account shard %d: %v", i, err)
+		}
+		repo.shards = append(repo.shards, shard)
+	}
+	return repo, nil
+}
+
+// shardedAccountRepository partitions accounts across several repositories by the hash of their ID.
+// Searches which don't include an accountID are sent to every shard.
+type shardedAccountRepository struct {
+	shards []accountRepository
+}
+
+func (r *shardedAccountRepository) shard(accountID string) accountRepository {
+	return r.shards[shardFor(accountID, len(r.shards))]
+}
+
+func (r *shardedAccountRepository) Ping() error {
+	for i := range r.shards {
+		if err := r.shards[i].Ping(); err != nil {
+			return fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *shardedAccountRepository) Close() error {
+	var firstErr error
+	for i := range r.shards {
+		if err := r.shards[i].Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	return firstErr
+}
+
+func (r *shardedAccountRepository) GetAccounts(ctx context.Context, accountIDs []string) ([]*accounts.Account, error) {
+	ids := make(map[int][]string)
+	for i := range accountIDs {
+		shard := shardFor(accountIDs[i], len(r.shards))
+		ids[shard] = append(ids[shard], accountIDs[i])
+	}
+	var out []*accounts.Account
+	for shard, accountIDs := range ids {
+		accts, err := r.shards[shard].GetAccounts(ctx, accountIDs)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", shard, err)
+		}
+		out = append(out, accts...)
+	}
+	return out, nil
+}
+
+func (r *shardedAccountRepository) CreateAccount(customerID string, account *accounts.Account) error {
+	return r.shard(account.ID).CreateAccount(customerID, account)
+}
+
+func (r *shardedAccountRepository) UpdateAccount(account *accounts.Account) error {
+	return r.shard(account.ID).UpdateAccount(account)
+}
+
+func (r *shardedAccountRepository) SearchAccountsByCustomerID(ctx context.Context, customerID string) ([]*accounts.Account, error) {
+	var out []*accounts.Account
+	for i := range r.shards {
+		accts, err := r.shards[i].SearchAccountsByCustomerID(ctx, customerID)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", i, err)
+		}
+		out = append(out, accts...)
+	}
+	return out, nil
+}
+
+// ListAccounts reads a page from every shard and keeps the lowest account IDs across them.
+func (r *shardedAccountRepository) ListAccounts(after string, limit int) ([]*accounts.Account, error) {
+	var out []*accounts.Account
+	for i := range r.shards {
+		accts, err := r.shards[i].ListAccounts(after, limit)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", i, err)
+		}
+		out = append(out, accts...)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (r *shardedAccountRepository) SearchAccountsByRoutingNumber(ctx context.Context, accountNumber, routingNumber, acctType string) (*accounts.Account, error) {
+	for i := range r.shards {
+		acct, err := r.shards[i].SearchAccountsByRoutingNumber(ctx, accountNumber, routingNumber, acctType)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", i, err)
+		}
+		if acct != nil {
+			return acct, nil
+		}
+	}
+	return nil, nil
+}
+
+func setupShardedTransactionStorage(ctx context.Context, logger log.Logger, _type string, shards int) (*shardedTransactionRepository, error) {
+	repo := &shardedTransactionRepository{}
+	for i := 0; i < shards; i++ {
+		db, err := database.NewShard(ctx, logger, _type, i)
+		if err != nil {
+			return nil, fmt.Errorf("transaction shard %d: %v", i, err)
+		}
+		shard, err := setupSqlTransactionStorage(ctx, logger, db)
+		if err != nil {
+			return nil, fmt.Errorf("transaction shard %d: %v", i, err)
+		}
+		repo.shards = append(repo.shards, shard)
+	}
+	return repo, nil
+}
+
+// shardedTransactionRepository stores transactions on the same shard as the accounts they post against.
+//
+// Transactions whose lines belong to accounts on different shards are rejected with errCrossShardTransaction
+// rather than risking a partial commit, so callers should keep related accounts (e.g. a customer and their
+// settlement account) on the same shard or move funds in separate transactions.
+type shardedTransactionRepository struct {
+	shards []transactionRepository
+}
+
+func (r *shardedTransactionRepository) Ping() error {
+	for i := range r.shards {
+		if err := r.shards[i].Ping(); err != nil {
+			return fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (r *shardedTransactionRepository) Close() error {
+	var firstErr error
+	for i := range r.shards {
+		if err := r.shards[i].Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("shard %d: %v", i, err)
+		}
+	}
+	return firstErr
+}
+
+func (r *shardedTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	if len(tx.Lines) == 0 {
+		return fmt.Errorf("transaction=%s has no Lines", tx.ID)
+	}
+	shard := shardFor(tx.Lines[0].AccountID, len(r.shards))
+	for i := range tx.Lines {
+		if n := shardFor(tx.Lines[i].AccountID, len(r.shards)); n != shard {
+			return fmt.Errorf("transaction=%s: %v (account=%s shard=%d, account=%s shard=%d)",
+				tx.ID, errCrossShardTransaction, tx.Lines[0].AccountID, shard, tx.Lines[i].AccountID, n)
+		}
+	}
+	return r.shards[shard].createTransaction(ctx, tx, opts)
+}
+
+func (r *shardedTransactionRepository) getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+	return r.shards[shardFor(accountID, len(r.shards))].getAccountTransactions(ctx, accountID, page)
+}
+
+func (r *shardedTransactionRepository) getTransaction(ctx context.Context, transactionID string) (*transaction, error) {
+	_, tx, err := r.findTransaction(ctx, transactionID)
+	return tx, err
+}
+
+// findTransaction returns the transaction and the index of the shard holding it. We don't know which shard holds a
+// transaction from its ID alone, so each one is checked.
+func (r *shardedTransactionRepository) findTransaction(ctx context.Context, transactionID string) (int, *transaction, error) {
+	var lastErr error
+	for i := range r.shards {
+		tx, err := r.shards[i].getTransaction(ctx, transactionID)
+		if err == nil && tx != nil {
+			return i, tx, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("transaction=%s not found", transactionID)
+	}
+	return -1, nil, lastErr
+}
+
+func (r *shardedTransactionRepository) getTransactionByIdempotencyKey(ctx context.Context, key string) (*transaction, error) {
+	for i := range r.shards {
+		tx, err := r.shards[i].getTransactionByIdempotencyKey(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", i, err)
+		}
+		if tx != nil {
+			return tx, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *shardedTransactionRepository) updateTransactionStatus(transactionID string, status TransactionStatus) error {
+	shard, _, err := r.findTransaction(context.Background(), transactionID)
+	if err != nil {
+		return err
+	}
+	return r.shards[shard].updateTransactionStatus(transactionID, status)
+}
+
+func (r *shardedTransactionRepository) getExpiredHolds(now time.Time) ([]string, error) {
+	var out []string
+	for i := range r.shards {
+		transactionIDs, err := r.shards[i].getExpiredHolds(now)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", i, err)
+		}
+		out = append(out, transactionIDs...)
+	}
+	return out, nil
+}
+
+func (r *shardedTransactionRepository) compactTransactionLines(before time.Time) (*compactionResult, error) {
+	out := &compactionResult{Before: before}
+	for i := range r.shards {
+		result, err := r.shards[i].compactTransactionLines(before)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", i, err)
+		}
+		out.Lines += result.Lines
+		out.Summaries += result.Summaries
+	}
+	return out, nil
+}
+
+func (r *shardedTransactionRepository) getSegmentTotals(q segmentTotalsQuery) ([]segmentTotal, error) {
+	if q.AccountID != "" {
+		return r.shards[shardFor(q.AccountID, len(r.shards))].getSegmentTotals(q)
+	}
+	var out []segmentTotal
+	for i := range r.shards {
+		totals, err := r.shards[i].getSegmentTotals(q)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", i, err)
+		}
+		out = append(out, totals...)
+	}
+	return mergeSegmentTotals(out), nil
+}
+
+func (r *shardedTransactionRepository) getMCCSpend(q mccSpendQuery) ([]mccSpend, error) {
+	if q.AccountID != "" {
+		return r.shards[shardFor(q.AccountID, len(r.shards))].getMCCSpend(q)
+	}
+	var out []mccSpend
+	for i := range r.shards {
+		spend, err := r.shards[i].getMCCSpend(q)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", i, err)
+		}
+		out = append(out, spend...)
+	}
+	return mergeMCCSpend(out), nil
+}
+
+func (r *shardedTransactionRepository) getTrialBalance(q trialBalanceQuery) ([]trialBalanceAccount, error) {
+	if q.AccountID != "" {
+		return r.shards[shardFor(q.AccountID, len(r.shards))].getTrialBalance(q)
+	}
+	var out []trialBalanceAccount
+	for i := range r.shards {
+		totals, err := r.shards[i].getTrialBalance(q)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d: %v", i, err)
+		}
+		out = append(out, totals...)
+	}
+	return mergeTrialBalance(out), nil
+}
+
+func (r *shardedTransactionRepository) getDailyTotals(accountID string, end time.Time) ([]dailyTotal, error) {
+	return r.shards[shardFor(accountID, len(r.shards))].getDailyTotals(accountID, end)
+}
+
+func (r *shardedTransactionRepository) repairAccountBalance(accountID string, repair bool) (*balanceRepair, error) {
+	return r.shards[shardFor(accountID, len(r.shards))].repairAccountBalance(accountID, repair)
+}
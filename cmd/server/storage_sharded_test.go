@@ -0,0 +1,205 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+)
+
+func TestSharded__shardFor(t *testing.T) {
+	id := base.ID()
+	if a, b := shardFor(id, 4), shardFor(id, 4); a != b {
+		t.Errorf("shards differ: %d vs %d", a, b)
+	}
+	if n := shardFor(id, 1); n != 0 {
+		t.Errorf("got shard %d", n)
+	}
+	if n := storageShards(); n != 1 {
+		t.Errorf("got %d shards", n)
+	}
+}
+
+func TestSharded__checkEngines(t *testing.T) {
+	defer os.Unsetenv("FEE_ACCOUNT_ID")
+
+	if err := checkShardedEngines(4); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("FEE_ACCOUNT_ID", "fees-revenue")
+	if err := checkShardedEngines(1); err != nil {
+		t.Errorf("unsharded storage: %v", err)
+	}
+	if err := checkShardedEngines(4); err == nil || !strings.Contains(err.Error(), "FEE_ACCOUNT_ID") {
+		t.Errorf("expected error: %v", err)
+	}
+}
+
+// accountsOnShards returns two accountIDs which hash onto different shards
+func accountsOnShards(t *testing.T, shards int) (string, string) {
+	t.Helper()
+
+	first := base.ID()
+	for i := 0; i < 100; i++ {
+		if second := base.ID(); shardFor(first, shards) != shardFor(second, shards) {
+			return first, second
+		}
+	}
+	t.Fatal("unable to find accounts on different shards")
+	return "", ""
+}
+
+func TestShardedRepositories(t *testing.T) {
+	db1, db2 := database.CreateTestSqliteDB(t), database.CreateTestSqliteDB(t)
+	defer db1.Close()
+	defer db2.Close()
+
+	accountRepo := &shardedAccountRepository{
+		shards: []accountRepository{
+			createTestSqlAccountRepository(t, db1.DB),
+			createTestSqlAccountRepository(t, db2.DB),
+		},
+	}
+	transactionRepo := &shardedTransactionRepository{
+		shards: []transactionRepository{
+			createTestSqlTransactionRepository(t, db1.DB),
+			createTestSqlTransactionRepository(t, db2.DB),
+		},
+	}
+	defer accountRepo.Close()
+
+	customerID, now := base.ID(), time.Now()
+	id1, id2 := accountsOnShards(t, 2)
+	for i, id := range []string{id1, id2} {
+		acct := &accounts.Account{
+			ID:            id,
+			CustomerID:    customerID,
+			Name:          "example",
+			AccountNumber: strings.Repeat("1", i+1),
+			RoutingNumber: defaultRoutingNumber,
+			Status:        "open",
+			Type:          "checking",
+			CreatedAt:     now,
+			LastModified:  now,
+		}
+		if err := accountRepo.CreateAccount(customerID, acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := accountRepo.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Read accounts back from both shards
+	accts, err := accountRepo.GetAccounts(context.Background(), []string{id1, id2})
+	if err != nil || len(accts) != 2 {
+		t.Fatalf("accounts=%#v error=%v", accts, err)
+	}
+	accts, err = accountRepo.SearchAccountsByCustomerID(context.Background(), customerID)
+	if err != nil || len(accts) != 2 {
+		t.Fatalf("accounts=%#v error=%v", accts, err)
+	}
+	acct, err := accountRepo.SearchAccountsByRoutingNumber(context.Background(), "11", defaultRoutingNumber, "checking")
+	if err != nil || acct == nil || acct.ID != id2 {
+		t.Fatalf("account=%#v error=%v", acct, err)
+	}
+
+	// List accounts across shards in ID order
+	first, last := id1, id2
+	if last < first {
+		first, last = last, first
+	}
+	accts, err = accountRepo.ListAccounts("", 1)
+	if err != nil || len(accts) != 1 || accts[0].ID != first {
+		t.Fatalf("accounts=%#v error=%v", accts, err)
+	}
+	accts, err = accountRepo.ListAccounts(first, 10)
+	if err != nil || len(accts) != 1 || accts[0].ID != last {
+		t.Fatalf("accounts=%#v error=%v", accts, err)
+	}
+
+	// Post a deposit on one shard
+	tx := transaction{
+		ID:        base.ID(),
+		Timestamp: now,
+		Lines:     []transactionLine{{AccountID: id2, Purpose: ACHCredit, Amount: 1000}},
+	}
+	if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{InitialDeposit: true}); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := transactionRepo.getTransaction(context.Background(), tx.ID); err != nil || found.ID != tx.ID {
+		t.Fatalf("transaction=%#v error=%v", found, err)
+	}
+	if txs, _, err := transactionRepo.getAccountTransactions(context.Background(), id2, transactionPage{}); err != nil || len(txs) != 1 {
+		t.Fatalf("transactions=%#v error=%v", txs, err)
+	}
+
+	// Status changes go to the shard holding the transaction
+	pending := transaction{
+		ID:        base.ID(),
+		Timestamp: now,
+		Status:    TransactionPending,
+		Lines:     []transactionLine{{AccountID: id2, Purpose: ACHCredit, Amount: 1000}},
+	}
+	if err := transactionRepo.createTransaction(context.Background(), pending, createTransactionOpts{InitialDeposit: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := transactionRepo.updateTransactionStatus(pending.ID, TransactionVoided); err != nil {
+		t.Fatal(err)
+	}
+	if found, err := transactionRepo.getTransaction(context.Background(), pending.ID); err != nil || found.Status != TransactionVoided {
+		t.Fatalf("transaction=%#v error=%v", found, err)
+	}
+	if err := transactionRepo.updateTransactionStatus(base.ID(), TransactionVoided); err == nil {
+		t.Error("expected error")
+	}
+
+	// Transactions can't span shards
+	tx = transaction{
+		ID:        base.ID(),
+		Timestamp: now,
+		Lines: []transactionLine{
+			{AccountID: id2, Purpose: ACHDebit, Amount: 100},
+			{AccountID: id1, Purpose: ACHCredit, Amount: 100},
+		},
+	}
+	if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{}); err == nil {
+		t.Error("expected error")
+	} else if !strings.Contains(err.Error(), errCrossShardTransaction.Error()) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := transactionRepo.getTransaction(context.Background(), tx.ID); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestShardedAccountRepository__errors(t *testing.T) {
+	repo := &shardedAccountRepository{
+		shards: []accountRepository{
+			&testAccountRepository{},
+			&testAccountRepository{err: errors.New("bad thing")},
+		},
+	}
+	if err := repo.Ping(); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := repo.SearchAccountsByCustomerID(context.Background(), base.ID()); err == nil {
+		t.Error("expected error")
+	}
+	if _, err := repo.SearchAccountsByRoutingNumber(context.Background(), "1", "2", "checking"); err == nil {
+		t.Error("expected error")
+	}
+	if err := repo.Close(); err == nil {
+		t.Error("expected error")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+
+	"github.com/go-kit/kit/log"
+)
+
+const (
+	// systemCustomerID owns every system account
+	systemCustomerID = "system"
+
+	// systemAccountPrefix names a system account in place of its ID, such as "system:suspense"
+	systemAccountPrefix = "system:"
+)
+
+var systemAccountNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+
+// systemAccounts maps the well-known names of ledger accounts the bank owns, such as fees-revenue, interest-expense,
+// suspense or settlement, to their account IDs. They're kept apart from customer accounts: they're never returned by
+// account searches and customers can't create accounts of their type.
+type systemAccounts map[string]string
+
+// configuredSystemAccounts is bootstrapped from SYSTEM_ACCOUNTS on startup.
+var configuredSystemAccounts = systemAccounts{}
+
+// readSystemAccountNames reads SYSTEM_ACCOUNTS, a comma separated list of system account names.
+func readSystemAccountNames() ([]string, error) {
+	var out []string
+	for _, name := range strings.Split(os.Getenv("SYSTEM_ACCOUNTS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if !systemAccountNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("SYSTEM_ACCOUNTS: invalid name %q", name)
+		}
+		out = append(out, name)
+	}
+	return out, nil
+}
+
+// systemAccountID returns the ID of a system account, which is derived from its name so every instance (and region)
+// agrees on it without reading the database.
+func systemAccountID(name string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(systemAccountPrefix+name)))
+}
+
+// resolve returns the ID of the system account named by accountID (as "system:<name>"), otherwise accountID.
+func (s systemAccounts) resolve(accountID string) string {
+	if !strings.HasPrefix(accountID, systemAccountPrefix) {
+		return accountID
+	}
+	if id, ok := s[strings.TrimPrefix(accountID, systemAccountPrefix)]; ok {
+		return id
+	}
+	return accountID
+}
+
+// bootstrapSystemAccounts creates each named system account which doesn't exist yet. The returned accounts are
+// complete even when creating some failed, as their IDs don't depend on it.
+func bootstrapSystemAccounts(ctx context.Context, logger log.Logger, repo accountRepository, names []string) (systemAccounts, error) {
+	out := systemAccounts{}
+	for _, name := range names {
+		out[name] = systemAccountID(name)
+	}
+	for _, name := range names {
+		existing, err := repo.GetAccounts(ctx, []string{out[name]})
+		if err != nil {
+			return out, fmt.Errorf("system account %s: %v", name, err)
+		}
+		if len(existing) > 0 {
+			continue
+		}
+		now := time.Now()
+		account := &accounts.Account{
+			ID:            out[name],
+			CustomerID:    systemCustomerID,
+			Name:          name,
+			RoutingNumber: defaultRoutingNumber,
+			Status:        string(accountStatusOpen),
+			Type:          string(System),
+			CreatedAt:     now,
+			LastModified:  now,
+		}
+		// Account numbers are random, so retry the rare number which is already taken
+		for i := 0; ; i++ {
+			account.AccountNumber = createAccountNumber()
+			if err = repo.CreateAccount(systemCustomerID, account); err != errAccountExists || i == 10 {
+				break
+			}
+		}
+		if err != nil {
+			return out, fmt.Errorf("system account %s: %v", name, err)
+		}
+		logger.Log("accounts", fmt.Sprintf("created system account %s as account=%s", name, account.ID))
+	}
+	return out, nil
+}
+
+// systemAccount is a system account's name and ID.
+type systemAccount struct {
+	Name      string `json:"name"`
+	AccountID string `json:"accountId"`
+}
+
+// getSystemAccounts is an admin route which lists the system accounts, as account searches don't return them.
+func getSystemAccounts(logger log.Logger, s systemAccounts) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		out := []systemAccount{}
+		for name, id := range s {
+			out = append(out, systemAccount{Name: name, AccountID: id})
+		}
+		sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(out)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSystemAccounts__read(t *testing.T) {
+	if names, err := readSystemAccountNames(); err != nil || len(names) != 0 {
+		t.Errorf("names=%v error=%v", names, err)
+	}
+
+	os.Setenv("SYSTEM_ACCOUNTS", "fees-revenue, Suspense")
+	defer os.Unsetenv("SYSTEM_ACCOUNTS")
+	if names, err := readSystemAccountNames(); err != nil || len(names) != 2 || names[0] != "fees-revenue" || names[1] != "suspense" {
+		t.Errorf("names=%v error=%v", names, err)
+	}
+
+	os.Setenv("SYSTEM_ACCOUNTS", "fees revenue")
+	if _, err := readSystemAccountNames(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestSystemAccounts(t *testing.T) {
+	ctx := context.Background()
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	memoryRepo, _ := newInMemoryRepositories()
+
+	for _, repo := range []accountRepository{createTestSqlAccountRepository(t, db.DB), memoryRepo} {
+		system, err := bootstrapSystemAccounts(ctx, log.NewNopLogger(), repo, []string{"fees-revenue", "suspense"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// bootstrapping again keeps the existing accounts
+		if again, err := bootstrapSystemAccounts(ctx, log.NewNopLogger(), repo, []string{"fees-revenue", "suspense"}); err != nil || again["suspense"] != system["suspense"] {
+			t.Errorf("system=%v error=%v", again, err)
+		}
+
+		accts, err := repo.GetAccounts(ctx, []string{system["fees-revenue"], system["suspense"]})
+		if err != nil || len(accts) != 2 {
+			t.Fatalf("%T: accounts=%#v error=%v", repo, accts, err)
+		}
+		for i := range accts {
+			if accts[i].Type != string(System) || accts[i].CustomerID != systemCustomerID || accts[i].Status != string(accountStatusOpen) {
+				t.Errorf("%T: account=%#v", repo, accts[i])
+			}
+		}
+
+		// customer searches don't return system accounts
+		if found, err := repo.SearchAccountsByCustomerID(ctx, systemCustomerID); err != nil || len(found) != 0 {
+			t.Errorf("%T: accounts=%#v error=%v", repo, found, err)
+		}
+		if found, err := repo.SearchAccountsByRoutingNumber(ctx, accts[0].AccountNumber, accts[0].RoutingNumber, accts[0].Type); err != nil || found != nil {
+			t.Errorf("%T: account=%#v error=%v", repo, found, err)
+		}
+	}
+}
+
+func TestSystemAccounts__resolve(t *testing.T) {
+	configuredSystemAccounts = systemAccounts{"suspense": systemAccountID("suspense")}
+	defer func() { configuredSystemAccounts = systemAccounts{} }()
+
+	for id, expected := range map[string]string{
+		"system:suspense": systemAccountID("suspense"),
+		"system:other":    "system:other",
+		"acct":            "acct",
+		"":                "",
+	} {
+		if v := configuredSystemAccounts.resolve(id); v != expected {
+			t.Errorf("%s: got %s", id, v)
+		}
+	}
+
+	req := &createTransactionRequest{Lines: []transactionLine{{AccountID: "system:suspense", Purpose: ACHDebit, Amount: 100}, {AccountID: "acct", Purpose: ACHCredit, Amount: 100}}}
+	tx := req.asTransaction("id")
+	if tx.Lines[0].AccountID != systemAccountID("suspense") || tx.Lines[1].AccountID != "acct" || req.Lines[0].AccountID != "system:suspense" {
+		t.Errorf("lines=%#v", tx.Lines)
+	}
+
+	w := httptest.NewRecorder()
+	getSystemAccounts(log.NewNopLogger(), configuredSystemAccounts)(w, httptest.NewRequest("GET", "/system-accounts", nil))
+	var out []systemAccount
+	if err := json.NewDecoder(w.Body).Decode(&out); err != nil || w.Code != http.StatusOK || len(out) != 1 || out[0].Name != "suspense" {
+		t.Errorf("status=%d accounts=%#v error=%v", w.Code, out, err)
+	}
+}
+
+func TestSystemAccounts__replay(t *testing.T) {
+	configuredSystemAccounts = systemAccounts{"suspense": systemAccountID("suspense")}
+	defer func() { configuredSystemAccounts = systemAccounts{} }()
+
+	accountRepo, ledger := createTestLedger(t, map[string]int{systemAccountID("suspense"): 1000, "acct": 0})
+	svc := &transactionService{logger: log.NewNopLogger(), repo: ledger, events: &mockEventPublisher{}}
+	ctx := context.Background()
+
+	// replaying a request which names a system account returns the transaction created for it
+	req := createTransactionRequest{
+		IdempotencyKey: "suspense-1",
+		Lines:          []transactionLine{{AccountID: "system:suspense", Purpose: ACHDebit, Amount: 100}, {AccountID: "acct", Purpose: ACHCredit, Amount: 100}},
+	}
+	first, err := svc.CreateTransaction(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, err := svc.CreateTransaction(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.ID != first.ID {
+		t.Errorf("replayed transaction=%s, expected %s", replay.ID, first.ID)
+	}
+	checkBalances(t, accountRepo, map[string]int32{systemAccountID("suspense"): 900, "acct": 100})
+
+	// the key still can't be reused for other lines
+	req.Lines[0].Amount, req.Lines[1].Amount = 200, 200
+	if _, err := svc.CreateTransaction(ctx, req); err == nil {
+		t.Error("expected error")
+	}
+}
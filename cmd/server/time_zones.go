@@ -0,0 +1,57 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Days and months start at midnight where a program's customers are, not at midnight UTC. Otherwise a west coast
+// program's "daily" limits reset in the afternoon and its statements end at 4pm on the last day of the month.
+// LEDGER_TIME_ZONE is the program's time zone, which products (and accounts, through their overrides) replace with
+// their own. Stored timestamps stay in UTC; only the boundaries of days and months move.
+
+// ledgerLocation is the time zone of accounts without their own, read from LEDGER_TIME_ZONE.
+var ledgerLocation = time.UTC
+
+// readLedgerTimeZone reads LEDGER_TIME_ZONE, an IANA time zone such as America/Los_Angeles, which defaults to UTC.
+func readLedgerTimeZone() (*time.Location, error) {
+	v := strings.TrimSpace(os.Getenv("LEDGER_TIME_ZONE"))
+	if v == "" {
+		return time.UTC, nil
+	}
+	loc, err := loadTimeZone(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LEDGER_TIME_ZONE: %v", err)
+	}
+	return loc, nil
+}
+
+// loadTimeZone loads an IANA time zone. Local is refused as it depends on the host running the server.
+func loadTimeZone(name string) (*time.Location, error) {
+	if name == "" || strings.EqualFold(name, "local") {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// lastTimeZone is the last time zone on Earth to reach a new day. Once a day or month has ended there, it's ended
+// for every account.
+var lastTimeZone = time.FixedZone("UTC-12", -12*60*60)
+
+// dayIn returns the start and (exclusive) end of the day containing when in loc. Days are 23 or 25 hours long
+// when daylight saving time starts or ends.
+func dayIn(when time.Time, loc *time.Location) (time.Time, time.Time) {
+	when = when.In(loc)
+	start := time.Date(when.Year(), when.Month(), when.Day(), 0, 0, 0, 0, loc)
+	return start, start.AddDate(0, 0, 1)
+}
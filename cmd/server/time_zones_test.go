@@ -0,0 +1,44 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTimeZones__readLedgerTimeZone(t *testing.T) {
+	if loc, err := readLedgerTimeZone(); err != nil || loc != time.UTC {
+		t.Errorf("location=%v error=%v", loc, err)
+	}
+	os.Setenv("LEDGER_TIME_ZONE", "America/Los_Angeles")
+	defer os.Unsetenv("LEDGER_TIME_ZONE")
+	if loc, err := readLedgerTimeZone(); err != nil || loc.String() != "America/Los_Angeles" {
+		t.Errorf("location=%v error=%v", loc, err)
+	}
+	for _, v := range []string{"Local", "PST8PDT/Nowhere"} {
+		os.Setenv("LEDGER_TIME_ZONE", v)
+		if _, err := readLedgerTimeZone(); err == nil {
+			t.Errorf("%s: expected error", v)
+		}
+	}
+}
+
+func TestTimeZones__dayIn(t *testing.T) {
+	la, err := time.LoadLocation("America/Los_Angeles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Daylight saving time started at 2am on March 8th, 2020
+	start, end := dayIn(time.Date(2020, time.March, 9, 6, 0, 0, 0, time.UTC), la)
+	if !start.Equal(time.Date(2020, time.March, 8, 8, 0, 0, 0, time.UTC)) || end.Sub(start) != 23*time.Hour {
+		t.Errorf("start=%v end=%v", start, end)
+	}
+	start, end = dayIn(time.Date(2020, time.March, 9, 6, 0, 0, 0, time.UTC), time.UTC)
+	if !start.Equal(time.Date(2020, time.March, 9, 0, 0, 0, 0, time.UTC)) || end.Sub(start) != 24*time.Hour {
+		t.Errorf("start=%v end=%v", start, end)
+	}
+}
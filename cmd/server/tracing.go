@@ -0,0 +1,164 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+
+	accounts "github.com/moov-io/accounts/client"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates spans for requests, postings and the SQL beneath them. Until setupTracing configures an exporter
+// it's a no-op, so spans cost nothing when tracing is disabled.
+var tracer = otel.Tracer("github.com/moov-io/accounts")
+
+// traceSampleRate returns the fraction (0.0 to 1.0) of new traces which are recorded. Requests which arrive with a
+// sampled trace context are always recorded so traces from our callers aren't broken up.
+func traceSampleRate() float64 {
+	if v := os.Getenv("TRACE_SAMPLE_RATE"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+			return f
+		}
+	}
+	return 1.0
+}
+
+// setupTracing exports spans over OTLP/HTTP when OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT)
+// is set. The returned func flushes spans which haven't been exported yet.
+func setupTracing(ctx context.Context, logger log.Logger, version string) (func(context.Context) error, error) {
+	// Always read W3C trace context from incoming requests, even if we don't export spans ourselves.
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx) // reads the endpoint, headers and TLS settings from OTEL_EXPORTER_OTLP_*
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %v", err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(traceSampleRate()))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("accounts"),
+			semconv.ServiceVersionKey.String(version),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Log("tracing", err)
+	}))
+	logger.Log("tracing", "exporting spans over OTLP")
+	return provider.Shutdown, nil
+}
+
+// endSpan records err on span, if there was one, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// newTracing starts a server span for each request, continuing the trace of the caller when the request has a
+// traceparent header. The span covers every middleware inside of it, such as queueing in the bulkhead.
+func newTracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, "HTTP "+r.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			semconv.HTTPMethodKey.String(r.Method),
+			semconv.HTTPTargetKey.String(r.URL.Path),
+			attribute.String("http.route_group", routeGroup(r)),
+		))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(rec.code))
+		if rec.code >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.code))
+		}
+	})
+}
+
+// nameSpanByRoute is router middleware which names the request's span after the matched route, such as
+// "POST /accounts/transactions", since the path alone would give every account its own span name.
+func nameSpanByRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if tpl, err := route.GetPathTemplate(); err == nil {
+				span := trace.SpanFromContext(r.Context())
+				span.SetName(r.Method + " " + tpl)
+				span.SetAttributes(semconv.HTTPRouteKey.String(tpl))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// tracedTransactionRepository records spans for postings and reading an account's transactions.
+type tracedTransactionRepository struct {
+	transactionRepository
+}
+
+func (r *tracedTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	ctx, span := tracer.Start(ctx, "createTransaction", trace.WithAttributes(
+		attribute.String("transaction.id", tx.ID),
+		attribute.Int("transaction.lines", len(tx.Lines)),
+		attribute.String("transaction.status", string(tx.Status)),
+	))
+	err := r.transactionRepository.createTransaction(ctx, tx, opts)
+	endSpan(span, err)
+	return err
+}
+
+func (r *tracedTransactionRepository) getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+	ctx, span := tracer.Start(ctx, "getAccountTransactions", trace.WithAttributes(
+		attribute.String("account.id", accountID),
+		attribute.Int("page.limit", page.Limit),
+	))
+	transactions, next, err := r.transactionRepository.getAccountTransactions(ctx, accountID, page)
+	span.SetAttributes(attribute.Int("page.transactions", len(transactions)))
+	endSpan(span, err)
+	return transactions, next, err
+}
+
+// tracedAccountRepository records spans for reading accounts, which calculates their balances.
+type tracedAccountRepository struct {
+	accountRepository
+}
+
+func (r *tracedAccountRepository) GetAccounts(ctx context.Context, accountIDs []string) ([]*accounts.Account, error) {
+	ctx, span := tracer.Start(ctx, "GetAccounts", trace.WithAttributes(attribute.Int("accounts.requested", len(accountIDs))))
+	accts, err := r.accountRepository.GetAccounts(ctx, accountIDs)
+	endSpan(span, err)
+	return accts, err
+}
+
+// startSQLSpan starts a client span for work against the database, such as a query or committing a transaction.
+func startSQLSpan(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "sql."+operation, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.DBOperationKey.String(operation),
+	))
+}
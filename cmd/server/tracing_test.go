@@ -0,0 +1,126 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/gorilla/mux"
+	"github.com/moov-io/base"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var (
+	testSpansOnce sync.Once
+	testSpans     *tracetest.SpanRecorder
+)
+
+// recordTestSpans records every span tests create. The global provider can only be replaced once for tracers
+// which already exist, so every test shares the recorder and should filter spans by their trace ID.
+func recordTestSpans() *tracetest.SpanRecorder {
+	testSpansOnce.Do(func() {
+		testSpans = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(testSpans)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	return testSpans
+}
+
+func TestTracing__posting(t *testing.T) {
+	spans := recordTestSpans()
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+
+	sqlAccounts := createTestSqlAccountRepository(t, db.DB)
+	accountRepo := &tracedAccountRepository{sqlAccounts}
+	transactionRepo := &tracedTransactionRepository{sqlAccounts.transactionRepo}
+
+	// Debit an account at another FI so there's no balance to check
+	remote := &accounts.Account{ID: base.ID(), AccountNumber: "123", RoutingNumber: "121042882", Status: "open", Type: "Checking"}
+	local := &accounts.Account{ID: base.ID(), AccountNumber: "432", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "Checking"}
+	for _, acct := range []*accounts.Account{remote, local} {
+		if err := sqlAccounts.CreateAccount(base.ID(), acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := mux.NewRouter()
+	router.Use(nameSpanByRoute)
+	router.Methods("POST").Path("/accounts/{accountId}/postings").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tx := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{
+			{AccountID: remote.ID, Purpose: ACHDebit, Amount: 500},
+			{AccountID: local.ID, Purpose: ACHCredit, Amount: 500},
+		}}
+		if err := transactionRepo.createTransaction(r.Context(), tx, createTransactionOpts{}); err != nil {
+			t.Error(err)
+		}
+		if _, _, err := transactionRepo.getAccountTransactions(r.Context(), local.ID, transactionPage{Limit: 10}); err != nil {
+			t.Error(err)
+		}
+		if _, err := accountRepo.GetAccounts(r.Context(), []string{local.ID}); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	// Our caller's trace is continued from the traceparent header
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest("POST", "/accounts/"+local.ID+"/postings", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	newTracing(router).ServeHTTP(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("bogus HTTP status: %d", w.Code)
+	}
+
+	byName := make(map[string]sdktrace.ReadOnlySpan)
+	counts := make(map[string]int)
+	for _, span := range spans.Ended() {
+		if span.SpanContext().TraceID().String() == traceID {
+			byName[span.Name()] = span
+			counts[span.Name()]++
+		}
+	}
+	server, ok := byName["POST /accounts/{accountId}/postings"]
+	if !ok {
+		t.Fatalf("missing server span: %v", counts)
+	}
+	if server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("server span parent: %v", server.Parent().SpanID())
+	}
+	for _, name := range []string{"createTransaction", "getAccountTransactions", "GetAccounts"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("missing %s span: %v", name, counts)
+			continue
+		}
+		if span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("%s isn't a child of the server span", name)
+		}
+	}
+	for _, name := range []string{"sql.begin", "sql.insertTransaction", "sql.applyLines", "sql.selectTransactionIDs", "sql.loadTransactions", "sql.selectAccounts", "sql.readBalances", "sql.commit"} {
+		if counts[name] == 0 {
+			t.Errorf("missing %s span: %v", name, counts)
+		}
+	}
+	// createTransaction reads accounts and begins and commits its own transaction, as do the two reads
+	if counts["sql.begin"] != 4 || counts["sql.commit"] != 4 {
+		t.Errorf("unexpected SQL spans: %v", counts)
+	}
+	if span := byName["sql.applyLines"]; span.Parent().SpanID() != byName["createTransaction"].SpanContext().SpanID() {
+		t.Error("sql.applyLines isn't a child of createTransaction")
+	}
+}
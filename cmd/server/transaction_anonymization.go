@@ -0,0 +1,218 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+)
+
+// piiRetentionYears returns how many years counterparty details are kept on transactions, read from
+// TRANSACTION_PII_RETENTION_YEARS. Zero means details are kept forever.
+func piiRetentionYears() (int, error) {
+	v := os.Getenv("TRANSACTION_PII_RETENTION_YEARS")
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid TRANSACTION_PII_RETENTION_YEARS %q", v)
+	}
+	return n, nil
+}
+
+// anonymizationReport lists the attachments whose counterparty details were (or, for a preview, would be)
+// cleared and verifies the transactions they belong to are unchanged.
+type anonymizationReport struct {
+	RetentionYears int       `json:"retentionYears"`
+	Before         time.Time `json:"before"`
+	Applied        bool      `json:"applied"`
+
+	Attachments  []anonymizedAttachment `json:"attachments"`
+	Transactions int                    `json:"transactions"`
+
+	// Anonymized is how many attachments were changed, which matches len(Attachments) unless another run
+	// anonymized some of them first.
+	Anonymized int64 `json:"anonymized"`
+
+	Verification *anonymizationVerification `json:"verification,omitempty"`
+}
+
+// anonymizedAttachment is one affected row. It deliberately leaves out the details being removed.
+type anonymizedAttachment struct {
+	ID            string         `json:"id"`
+	TransactionID string         `json:"transactionId"`
+	Type          attachmentType `json:"type"`
+	CreatedAt     time.Time      `json:"createdAt"`
+}
+
+// anonymizationVerification is checked after attachments are anonymized.
+type anonymizationVerification struct {
+	// Remaining is how many attachments from before the cutoff still hold counterparty details, which should be zero.
+	Remaining int `json:"remaining"`
+
+	// TransactionsChecked had the same lines (accounts, purposes and amounts) before and after anonymizing.
+	// Changed lists any which didn't and Missing any which couldn't be read.
+	TransactionsChecked int      `json:"transactionsChecked"`
+	Changed             []string `json:"changed,omitempty"`
+	Missing             []string `json:"missing,omitempty"`
+
+	Passed bool `json:"passed"`
+}
+
+// anonymizationService strips counterparty PII from transactions once it's older than our retention period.
+// Transactions don't carry counterparty details themselves, but their attachments (check images, invoices and
+// receipts) reference documents naming the other party. Anonymizing clears an attachment's URL, object key and
+// description while keeping its type and content hash, so amounts, accounts and proof of which document was
+// attached are retained.
+type anonymizationService struct {
+	logger         log.Logger
+	attachments    attachmentRepository
+	transactions   transactionRepository
+	retentionYears int
+
+	now func() time.Time
+}
+
+// anonymize previews or, when apply is true, clears the counterparty details of attachments older than the
+// retention period.
+func (s *anonymizationService) anonymize(ctx context.Context, apply bool, userID string) (*anonymizationReport, error) {
+	if s.retentionYears <= 0 {
+		return nil, errors.New("anonymize: TRANSACTION_PII_RETENTION_YEARS isn't set")
+	}
+	now := time.Now()
+	if s.now != nil {
+		now = s.now()
+	}
+	report := &anonymizationReport{
+		RetentionYears: s.retentionYears,
+		Before:         now.AddDate(-1*s.retentionYears, 0, 0),
+		Applied:        apply,
+		Attachments:    []anonymizedAttachment{},
+	}
+	found, err := s.attachments.getIdentifiableAttachments(report.Before)
+	if err != nil {
+		return nil, err
+	}
+	var attachmentIDs, transactionIDs []string
+	seen := make(map[string]bool)
+	for _, a := range found {
+		report.Attachments = append(report.Attachments, anonymizedAttachment{ID: a.ID, TransactionID: a.TransactionID, Type: a.Type, CreatedAt: a.CreatedAt})
+		attachmentIDs = append(attachmentIDs, a.ID)
+		if !seen[a.TransactionID] {
+			seen[a.TransactionID] = true
+			transactionIDs = append(transactionIDs, a.TransactionID)
+		}
+	}
+	report.Transactions = len(transactionIDs)
+	if !apply || len(attachmentIDs) == 0 {
+		return report, nil
+	}
+
+	before, err := s.readLines(transactionIDs)
+	if err != nil {
+		return nil, err
+	}
+	report.Anonymized, err = s.attachments.anonymizeAttachments(attachmentIDs, now)
+	if err != nil {
+		return nil, err
+	}
+	report.Verification, err = s.verify(report.Before, before)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Log(
+		"audit", fmt.Sprintf("anonymized %d attachments on %d transactions created before %v", report.Anonymized, report.Transactions, report.Before.Format(time.RFC3339)),
+		"passed", report.Verification.Passed,
+		"requestID", requestIDFrom(ctx),
+		"userID", userID,
+	)
+	return report, nil
+}
+
+// readLines returns the lines of each transaction encoded as JSON for comparing, or nil when it can't be found.
+func (s *anonymizationService) readLines(transactionIDs []string) (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	for _, id := range transactionIDs {
+		tx, err := s.transactions.getTransaction(context.Background(), id)
+		if err != nil {
+			return nil, fmt.Errorf("anonymize: transaction=%s: %v", id, err)
+		}
+		if tx == nil {
+			out[id] = nil
+			continue
+		}
+		bs, err := json.Marshal(tx.Lines)
+		if err != nil {
+			return nil, fmt.Errorf("anonymize: transaction=%s: %v", id, err)
+		}
+		out[id] = bs
+	}
+	return out, nil
+}
+
+func (s *anonymizationService) verify(cutoff time.Time, before map[string][]byte) (*anonymizationVerification, error) {
+	remaining, err := s.attachments.getIdentifiableAttachments(cutoff)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(before))
+	for id := range before {
+		ids = append(ids, id)
+	}
+	after, err := s.readLines(ids)
+	if err != nil {
+		return nil, err
+	}
+	v := &anonymizationVerification{Remaining: len(remaining)}
+	for _, id := range ids {
+		switch {
+		case before[id] == nil || after[id] == nil:
+			v.Missing = append(v.Missing, id)
+		case string(before[id]) != string(after[id]):
+			v.Changed = append(v.Changed, id)
+		default:
+			v.TransactionsChecked++
+		}
+	}
+	v.Passed = v.Remaining == 0 && len(v.Changed) == 0 && len(v.Missing) == 0
+	return v, nil
+}
+
+// anonymizeTransactions is an admin route which previews (GET) or clears (POST) the counterparty details of
+// transaction attachments older than TRANSACTION_PII_RETENTION_YEARS.
+func anonymizeTransactions(logger log.Logger, svc *anonymizationService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var apply bool
+		switch r.Method {
+		case "GET":
+		case "POST":
+			apply = true
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		report, err := svc.anonymize(requestContext(r), apply, moovhttp.GetUserID(r))
+		if err != nil {
+			logger.Log("anonymize", fmt.Sprintf("problem anonymizing transactions: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(report)
+	}
+}
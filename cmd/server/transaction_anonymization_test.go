@@ -0,0 +1,129 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestAnonymization__piiRetentionYears(t *testing.T) {
+	if n, err := piiRetentionYears(); err != nil || n != 0 {
+		t.Errorf("years=%d error=%v", n, err)
+	}
+	os.Setenv("TRANSACTION_PII_RETENTION_YEARS", "7")
+	defer os.Unsetenv("TRANSACTION_PII_RETENTION_YEARS")
+	if n, err := piiRetentionYears(); err != nil || n != 7 {
+		t.Errorf("years=%d error=%v", n, err)
+	}
+	for _, v := range []string{"0", "-1", "seven"} {
+		os.Setenv("TRANSACTION_PII_RETENTION_YEARS", v)
+		if _, err := piiRetentionYears(); err == nil || !strings.Contains(err.Error(), "TRANSACTION_PII_RETENTION_YEARS") {
+			t.Errorf("%s: unexpected error: %v", v, err)
+		}
+	}
+}
+
+func TestAnonymization__route(t *testing.T) {
+	_, transactionRepo := createTestLedger(t, map[string]int{"checking": 0})
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	attachmentRepo := &sqlAttachmentRepository{db.DB, log.NewNopLogger()}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	createdAt := map[string]time.Time{
+		"old":    now.AddDate(-8, 0, 0),
+		"recent": now.AddDate(-1, 0, 0),
+	}
+	attachments := make(map[string]*attachment)
+	for name, ts := range createdAt {
+		tx := transaction{ID: base.ID(), Timestamp: ts, Lines: []transactionLine{{AccountID: "checking", Purpose: ACHCredit, Amount: 500}}}
+		if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+		a := &attachment{
+			ID:            base.ID(),
+			TransactionID: tx.ID,
+			Type:          attachmentCheckImage,
+			URL:           "https://checks.example.com/jane-doe.png",
+			ContentHash:   strings.Repeat("ab", 32),
+			Description:   "Check from Jane Doe",
+			CreatedAt:     ts,
+		}
+		if err := attachmentRepo.createAttachment(a); err != nil {
+			t.Fatal(err)
+		}
+		attachments[name] = a
+	}
+
+	svc := &anonymizationService{logger: log.NewNopLogger(), attachments: attachmentRepo, transactions: transactionRepo}
+	handler := anonymizeTransactions(log.NewNopLogger(), svc)
+	do := func(method string) (*anonymizationReport, int) {
+		req := httptest.NewRequest(method, "/transactions/anonymize", nil)
+		req.Header.Set("x-user-id", "operator")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		w.Flush()
+		if w.Code != http.StatusOK {
+			return nil, w.Code
+		}
+		var report anonymizationReport
+		if err := json.NewDecoder(w.Body).Decode(&report); err != nil {
+			t.Fatal(err)
+		}
+		return &report, w.Code
+	}
+
+	// retention has to be configured
+	if _, code := do("GET"); code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", code)
+	}
+	svc.retentionYears = 7
+
+	// GET previews the affected rows without changing them
+	report, _ := do("GET")
+	if report == nil || report.Applied || len(report.Attachments) != 1 || report.Attachments[0].ID != attachments["old"].ID || report.Verification != nil {
+		t.Fatalf("unexpected report: %#v", report)
+	}
+	if found, _ := attachmentRepo.getAttachments([]string{attachments["old"].TransactionID}); len(found) != 1 || found[0].URL == "" {
+		t.Errorf("preview changed attachments: %#v", found)
+	}
+
+	report, _ = do("POST")
+	if report == nil || !report.Applied || report.Anonymized != 1 || report.Transactions != 1 {
+		t.Fatalf("unexpected report: %#v", report)
+	}
+	if v := report.Verification; v == nil || !v.Passed || v.Remaining != 0 || v.TransactionsChecked != 1 {
+		t.Errorf("unexpected verification: %#v", v)
+	}
+
+	// counterparty details are gone, but the transaction and attachment's hash remain
+	found, _ := attachmentRepo.getAttachments([]string{attachments["old"].TransactionID})
+	if len(found) != 1 || found[0].URL != "" || found[0].Description != "" || found[0].ContentHash != attachments["old"].ContentHash || found[0].AnonymizedAt == nil {
+		t.Errorf("unexpected attachments: %#v", found)
+	}
+	if tx, err := transactionRepo.getTransaction(context.Background(), attachments["old"].TransactionID); err != nil || tx == nil || tx.Lines[0].Amount != 500 {
+		t.Errorf("transaction=%#v error=%v", tx, err)
+	}
+	if found, _ := attachmentRepo.getAttachments([]string{attachments["recent"].TransactionID}); len(found) != 1 || found[0].URL == "" {
+		t.Errorf("recent attachment was anonymized: %#v", found)
+	}
+
+	// running again finds nothing left to anonymize
+	if report, _ := do("POST"); report == nil || len(report.Attachments) != 0 || report.Anonymized != 0 {
+		t.Errorf("unexpected report: %#v", report)
+	}
+}
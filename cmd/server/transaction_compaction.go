@@ -0,0 +1,91 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// compactionDays returns how old (in days) transaction lines must be before they're compacted
+// into daily summaries. Zero disables compaction.
+func compactionDays() int {
+	if v := os.Getenv("TRANSACTION_COMPACTION_DAYS"); v != "" {
+		if n, _ := strconv.ParseInt(v, 10, 32); n > 0 {
+			return int(n)
+		}
+	}
+	return 0
+}
+
+type compactionResult struct {
+	Before    time.Time `json:"before"`
+	Lines     int       `json:"lines"`
+	Summaries int       `json:"summaries"`
+}
+
+type dailySummaryKey struct {
+	accountID string
+	day       string // YYYY-MM-DD in UTC
+}
+
+// dailySummary is the roll-up of every transaction line posted against an account in one day.
+type dailySummary struct {
+	credits int
+	debits  int
+	lines   int
+}
+
+func (s *dailySummary) add(line transactionLine) {
+	if amt := lineAmount(line); amt < 0 {
+		s.debits += -1 * amt
+	} else {
+		s.credits += amt
+	}
+	s.lines++
+}
+
+// compactionCutoff returns the start of the UTC day which is days before now. Lines created before the cutoff
+// are compacted so a day is never split between summaries and raw lines.
+func compactionCutoff(now time.Time, days int) time.Time {
+	day := now.UTC().Truncate(24*time.Hour).AddDate(0, 0, -1*days)
+	return day.In(now.Location())
+}
+
+// setupCompactionJob compacts transaction lines older than days every night until ctx is cancelled.
+func setupCompactionJob(ctx context.Context, logger log.Logger, repo transactionRepository, days int) {
+	if days <= 0 {
+		return
+	}
+	logger.Log("compaction", fmt.Sprintf("compacting transaction lines older than %d days nightly", days))
+
+	compact := func() {
+		result, err := repo.compactTransactionLines(compactionCutoff(time.Now(), days))
+		if err != nil {
+			logger.Log("compaction", fmt.Sprintf("problem compacting transaction lines: %v", err))
+			return
+		}
+		logger.Log("compaction", fmt.Sprintf("compacted %d lines into %d summaries before %v", result.Lines, result.Summaries, result.Before))
+	}
+	go func() {
+		compact()
+
+		t := time.NewTicker(24 * time.Hour)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				compact()
+			}
+		}
+	}()
+}
@@ -0,0 +1,31 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCompaction__compactionCutoff(t *testing.T) {
+	now := time.Date(2020, time.March, 12, 15, 30, 0, 0, time.UTC)
+	cutoff := compactionCutoff(now, 30)
+	if expected := time.Date(2020, time.February, 11, 0, 0, 0, 0, time.UTC); !cutoff.Equal(expected) {
+		t.Errorf("got %v", cutoff)
+	}
+	if n := compactionDays(); n != 0 {
+		t.Errorf("got %d days", n)
+	}
+}
+
+func TestCompaction__dailySummary(t *testing.T) {
+	var summary dailySummary
+	summary.add(transactionLine{Purpose: ACHDebit, Amount: 100})
+	summary.add(transactionLine{Purpose: ACHCredit, Amount: 250})
+	summary.add(transactionLine{Purpose: Fee, Amount: 25})
+	if summary.credits != 275 || summary.debits != 100 || summary.lines != 3 {
+		t.Errorf("%#v", summary)
+	}
+}
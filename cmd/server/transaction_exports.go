@@ -0,0 +1,94 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// transactionCSVColumn renders one column of an exported line.
+type transactionCSVColumn func(tx transaction, line transactionLine) string
+
+// transactionCSVColumns are the columns accepted by ?columns when exporting an account's transactions as CSV.
+var transactionCSVColumns = map[string]transactionCSVColumn{
+	"transactionId": func(tx transaction, _ transactionLine) string { return tx.ID },
+	"timestamp":     func(tx transaction, _ transactionLine) string { return tx.Timestamp.UTC().Format(time.RFC3339) },
+	"status":        func(tx transaction, _ transactionLine) string { return string(tx.Status) },
+	"accountId":     func(_ transaction, line transactionLine) string { return line.AccountID },
+	"purpose":       func(_ transaction, line transactionLine) string { return string(line.Purpose) },
+	"amount":        func(_ transaction, line transactionLine) string { return strconv.Itoa(lineAmount(line)) },
+	"description": func(tx transaction, line transactionLine) string {
+		if line.Description != "" {
+			return line.Description
+		}
+		return tx.Description
+	},
+	"department":      func(_ transaction, line transactionLine) string { return line.Department },
+	"product":         func(_ transaction, line transactionLine) string { return line.Product },
+	"region":          func(_ transaction, line transactionLine) string { return line.Region },
+	"mcc":             func(_ transaction, line transactionLine) string { return line.MCC },
+	"merchantCountry": func(_ transaction, line transactionLine) string { return line.MerchantCountry },
+	"tags":            func(tx transaction, _ transactionLine) string { return strings.Join(tx.Tags, ";") },
+}
+
+// defaultTransactionCSVColumns are exported when ?columns isn't set.
+var defaultTransactionCSVColumns = []string{"timestamp", "transactionId", "status", "purpose", "amount", "description"}
+
+// readTransactionCSVColumns reads ?columns, a comma separated list of the columns to export in order.
+func readTransactionCSVColumns(v string) ([]string, error) {
+	if v == "" {
+		return defaultTransactionCSVColumns, nil
+	}
+	var out []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := transactionCSVColumns[name]; !ok {
+			return nil, fmt.Errorf("unknown transaction column %q", name)
+		}
+		out = append(out, name)
+	}
+	return out, nil
+}
+
+// writeAccountTransactionsCSV writes a row for each of the account's lines in every transaction matching page, newest
+// first, starting after page.Cursor. Transactions are read a page at a time and flush is called after each, so an
+// account's whole history is exported without holding it in memory.
+func writeAccountTransactionsCSV(ctx context.Context, w io.Writer, flush func(), svc *transactionService, accountID string, page transactionPage, columns []string) error {
+	if accountID == "" {
+		return errNoAccountID
+	}
+	out := csv.NewWriter(w)
+	out.Write(columns)
+	out.Flush()
+
+	row := make([]string, len(columns))
+	return eachAccountTransactionPage(ctx, svc.repo, accountID, page, func(transactions []transaction) error {
+		for _, tx := range transactions {
+			for _, line := range tx.Lines {
+				if line.AccountID != accountID {
+					continue
+				}
+				for i := range columns {
+					row[i] = transactionCSVColumns[columns[i]](tx, line)
+				}
+				out.Write(row)
+			}
+		}
+		out.Flush()
+		if err := out.Error(); err != nil {
+			return err
+		}
+		if flush != nil {
+			flush()
+		}
+		return nil
+	})
+}
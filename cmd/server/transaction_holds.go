@@ -0,0 +1,283 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Holds let another system (a card network or another ledger) coordinate a posting with its own commit. Preparing a
+// transaction validates it and reserves funds from the debited accounts, then the caller either commits the hold
+// (posting the credits) or aborts it (releasing the debits). Holds which are neither are aborted once they expire.
+var (
+	defaultHoldTimeout = 5 * time.Minute
+	maxHoldTimeout     = 7 * 24 * time.Hour
+
+	errHoldExpired = errors.New("hold has expired")
+)
+
+func addTransactionHoldRoutes(logger log.Logger, router *mux.Router, svc *transactionService) {
+	router.Methods("POST").Path("/accounts/transactions/prepare").HandlerFunc(prepareTransaction(logger, svc))
+	router.Methods("POST").Path("/accounts/transactions/{transactionID}/commit").HandlerFunc(commitTransaction(logger, svc))
+	router.Methods("POST").Path("/accounts/transactions/{transactionID}/abort").HandlerFunc(abortTransaction(logger, svc))
+}
+
+// holdLines returns the lines a held transaction applies onto balances as it moves into status. Holding applies the
+// debits (reserving funds), committing (posted) applies everything else and aborting (voided) offsets the debits.
+func holdLines(lines []transactionLine, status TransactionStatus) []transactionLine {
+	var out []transactionLine
+	for _, line := range lines {
+		debit := line.Purpose == ACHDebit
+		switch {
+		case status == TransactionHeld && debit, status == TransactionPosted && !debit:
+			out = append(out, line)
+		case status == TransactionVoided && debit:
+			line.Purpose = ACHCredit
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+// pendingBalance sums an account's lines which aren't applied onto its balance yet: every line of pending
+// transactions and the credits of held transactions, whose debits are already reserved.
+type pendingBalance struct {
+	debits int32
+	net    int32
+}
+
+func (b *pendingBalance) add(line transactionLine, status TransactionStatus) {
+	switch {
+	case status == TransactionPending, status == TransactionHeld && line.Purpose != ACHDebit:
+		amt := int32(lineAmount(line))
+		if amt < 0 {
+			b.debits += -1 * amt
+		}
+		b.net += amt
+	}
+}
+
+// apply fills the account's available and pending balances. Available funds exclude pending debits, but not
+// pending credits which may still fail.
+func (b pendingBalance) apply(acct *accounts.Account) {
+	acct.BalanceAvailable = acct.Balance - b.debits
+	acct.BalancePending = b.net
+}
+
+// holdExpired returns true when t is held and can no longer be committed.
+func (t *transaction) holdExpired(now time.Time) bool {
+	return t.Status == TransactionHeld && t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+type prepareTransactionRequest struct {
+	// ID is an optional caller provided UUID for the transaction, see createTransactionRequest.
+	ID string `json:"id,omitempty"`
+
+	Lines []transactionLine `json:"lines"`
+
+	// Timeout is how long the hold lasts before it's aborted (e.g. 30s or 10m). Holds last five minutes by default.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+func (r *prepareTransactionRequest) timeout() (time.Duration, error) {
+	if r.Timeout == "" {
+		return defaultHoldTimeout, nil
+	}
+	d, err := time.ParseDuration(r.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout: %v", err)
+	}
+	if d <= 0 || d > maxHoldTimeout {
+		return 0, fmt.Errorf("timeout %v must be positive and at most %v", d, maxHoldTimeout)
+	}
+	return d, nil
+}
+
+// PrepareTransaction validates a transaction and reserves funds from its debited accounts until the hold is
+// committed, aborted or expires.
+func (s *transactionService) PrepareTransaction(ctx context.Context, req prepareTransactionRequest) (*transaction, error) {
+	requestID := requestIDFrom(ctx)
+
+	timeout, err := req.timeout()
+	if err != nil {
+		return nil, err
+	}
+	create := createTransactionRequest{ID: req.ID, Lines: req.Lines}
+	transactionID, err := create.transactionID()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := create.asTransaction(transactionID)
+	tx.Status = TransactionHeld
+	expiresAt := tx.Timestamp.Add(timeout).UTC()
+	tx.ExpiresAt = &expiresAt
+	if err := blockedMCCs.check(ctx, tx); err != nil {
+		s.logger.Log("transactions", err.Error(), "requestID", requestID)
+		return nil, err
+	}
+
+	if err := s.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: false}); err != nil {
+		s.logger.Log("transactions", fmt.Errorf("problem holding transaction: %v", err), "requestID", requestID)
+		return nil, err
+	}
+	s.logger.Log("transactions", fmt.Sprintf("held transaction=%s until %v", tx.ID, expiresAt), "requestID", requestID)
+	s.publish(ctx, tx.Status, &tx)
+
+	return &tx, nil
+}
+
+// CommitTransaction posts a held transaction, which applies its credits.
+func (s *transactionService) CommitTransaction(ctx context.Context, transactionID string) (*transaction, error) {
+	if err := s.checkHeld(ctx, transactionID); err != nil {
+		return nil, err
+	}
+	return s.UpdateTransactionStatus(ctx, transactionID, TransactionPosted)
+}
+
+// AbortTransaction voids a held transaction, which releases the funds it reserved.
+func (s *transactionService) AbortTransaction(ctx context.Context, transactionID string) (*transaction, error) {
+	if err := s.checkHeld(ctx, transactionID); err != nil {
+		return nil, err
+	}
+	return s.UpdateTransactionStatus(ctx, transactionID, TransactionVoided)
+}
+
+func (s *transactionService) checkHeld(ctx context.Context, transactionID string) error {
+	tx, err := s.GetTransaction(ctx, transactionID)
+	if err != nil {
+		return err
+	}
+	if tx == nil {
+		return fmt.Errorf("transaction=%s not found", transactionID)
+	}
+	if tx.Status != TransactionHeld {
+		return fmt.Errorf("transaction=%s is %s rather than held", transactionID, tx.Status)
+	}
+	return nil
+}
+
+// expireHolds aborts every held transaction which expired by now and returns how many were aborted.
+func (s *transactionService) expireHolds(ctx context.Context, now time.Time) (int, error) {
+	transactionIDs, err := s.repo.getExpiredHolds(now)
+	if err != nil {
+		return 0, fmt.Errorf("expireHolds: %v", err)
+	}
+	aborted := 0
+	for i := range transactionIDs {
+		// Holds can be committed or aborted by their caller while we're running, so keep going on errors.
+		if _, err := s.UpdateTransactionStatus(ctx, transactionIDs[i], TransactionVoided); err != nil {
+			s.logger.Log("holds", fmt.Sprintf("problem aborting expired transaction=%s: %v", transactionIDs[i], err))
+			continue
+		}
+		aborted++
+	}
+	return aborted, nil
+}
+
+// setupHoldExpiryJob periodically aborts held transactions which weren't committed or aborted before they expired.
+func setupHoldExpiryJob(ctx context.Context, logger log.Logger, svc *transactionService, interval time.Duration) {
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-t.C:
+				aborted, err := svc.expireHolds(ctx, now)
+				if err != nil {
+					logger.Log("holds", err)
+				} else if aborted > 0 {
+					logger.Log("holds", fmt.Sprintf("aborted %d expired holds", aborted))
+				}
+			}
+		}
+	}()
+}
+
+func prepareTransaction(logger log.Logger, svc *transactionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		var req prepareTransactionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		tx, err := svc.PrepareTransaction(requestContext(r), req)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(tx)
+	}
+}
+
+func commitTransaction(logger log.Logger, svc *transactionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		transactionID := getTransactionID(w, r)
+		if transactionID == "" {
+			return
+		}
+
+		tx, err := svc.CommitTransaction(requestContext(r), transactionID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(tx)
+	}
+}
+
+func abortTransaction(logger log.Logger, svc *transactionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		transactionID := getTransactionID(w, r)
+		if transactionID == "" {
+			return
+		}
+
+		tx, err := svc.AbortTransaction(requestContext(r), transactionID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(tx)
+	}
+}
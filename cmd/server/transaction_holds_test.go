@@ -0,0 +1,353 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestTransactionHolds__holdLines(t *testing.T) {
+	lines := []transactionLine{
+		{AccountID: "a", Purpose: ACHDebit, Amount: 100},
+		{AccountID: "b", Purpose: ACHCredit, Amount: 100},
+	}
+	if out := holdLines(lines, TransactionHeld); len(out) != 1 || out[0].AccountID != "a" || lineAmount(out[0]) != -100 {
+		t.Errorf("held: %#v", out)
+	}
+	if out := holdLines(lines, TransactionPosted); len(out) != 1 || out[0].AccountID != "b" || lineAmount(out[0]) != 100 {
+		t.Errorf("posted: %#v", out)
+	}
+	if out := holdLines(lines, TransactionVoided); len(out) != 1 || out[0].AccountID != "a" || lineAmount(out[0]) != 100 {
+		t.Errorf("voided: %#v", out)
+	}
+	if lines[0].Purpose != ACHDebit {
+		t.Errorf("lines were modified: %#v", lines)
+	}
+}
+
+func TestTransactionHolds__request(t *testing.T) {
+	req := prepareTransactionRequest{}
+	if d, err := req.timeout(); err != nil || d != defaultHoldTimeout {
+		t.Errorf("timeout=%v error=%v", d, err)
+	}
+	for _, v := range []string{"soon", "-1m", "8760h"} {
+		req.Timeout = v
+		if _, err := req.timeout(); err == nil {
+			t.Errorf("%s: expected error", v)
+		}
+	}
+}
+
+func TestTransactionHolds__repositories(t *testing.T) {
+	check := func(t *testing.T, accountRepo accountRepository, repo transactionRepository) {
+		account1, account2 := base.ID(), base.ID()
+		for i, id := range []string{account1, account2} {
+			acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: base.ID()[:9], RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+			if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+				t.Fatalf("account %d: %v", i, err)
+			}
+		}
+		deposit := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Lines:     []transactionLine{{AccountID: account1, Purpose: ACHCredit, Amount: 1000}},
+		}
+		if err := repo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+		balances := func(a, b int32) {
+			t.Helper()
+			accts, err := accountRepo.GetAccounts(context.Background(), []string{account1, account2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range accts {
+				if (accts[i].ID == account1 && accts[i].Balance != a) || (accts[i].ID == account2 && accts[i].Balance != b) {
+					t.Errorf("account=%s balance=%d", accts[i].ID, accts[i].Balance)
+				}
+			}
+			for _, id := range []string{account1, account2} {
+				if result, err := repo.repairAccountBalance(id, false); err != nil || result.Drift != 0 {
+					t.Errorf("result=%#v error=%v", result, err)
+				}
+			}
+		}
+		hold := func(amount int, expiresAt time.Time) transaction {
+			t.Helper()
+			tx := transaction{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Status:    TransactionHeld,
+				ExpiresAt: &expiresAt,
+				Lines: []transactionLine{
+					{AccountID: account1, Purpose: ACHDebit, Amount: amount},
+					{AccountID: account2, Purpose: ACHCredit, Amount: amount},
+				},
+			}
+			if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+				t.Fatal(err)
+			}
+			return tx
+		}
+		future := time.Now().Add(time.Hour).UTC()
+
+		// holds reserve funds from the debited account only
+		committed := hold(400, future)
+		balances(600, 0)
+		if tx, err := repo.getTransaction(context.Background(), committed.ID); err != nil || tx.Status != TransactionHeld || tx.ExpiresAt == nil || !tx.ExpiresAt.Equal(future) {
+			t.Fatalf("transaction=%#v error=%v", tx, err)
+		}
+		overdraft := committed
+		overdraft.ID = base.ID()
+		overdraft.Lines = []transactionLine{
+			{AccountID: account1, Purpose: ACHDebit, Amount: 800},
+			{AccountID: account2, Purpose: ACHCredit, Amount: 800},
+		}
+		if err := repo.createTransaction(context.Background(), overdraft, createTransactionOpts{}); err == nil {
+			t.Error("expected insufficient funds")
+		}
+		if err := repo.updateTransactionStatus(committed.ID, TransactionPosted); err != nil {
+			t.Fatal(err)
+		}
+		balances(600, 400)
+
+		aborted := hold(100, future)
+		balances(500, 400)
+		if err := repo.updateTransactionStatus(aborted.ID, TransactionVoided); err != nil {
+			t.Fatal(err)
+		}
+		balances(600, 400)
+
+		// expired holds can't be committed
+		expired := hold(200, time.Now().Add(-time.Minute))
+		if err := repo.updateTransactionStatus(expired.ID, TransactionPosted); err == nil || !strings.Contains(err.Error(), errHoldExpired.Error()) {
+			t.Errorf("expected expired error: %v", err)
+		}
+		if transactionIDs, err := repo.getExpiredHolds(time.Now()); err != nil || len(transactionIDs) != 1 || transactionIDs[0] != expired.ID {
+			t.Errorf("transactionIDs=%v error=%v", transactionIDs, err)
+		}
+		if err := repo.updateTransactionStatus(expired.ID, TransactionVoided); err != nil {
+			t.Fatal(err)
+		}
+		balances(600, 400)
+		if transactionIDs, err := repo.getExpiredHolds(time.Now()); err != nil || len(transactionIDs) != 0 {
+			t.Errorf("transactionIDs=%v error=%v", transactionIDs, err)
+		}
+
+		// holds must expire
+		noExpiry := committed
+		noExpiry.ID, noExpiry.ExpiresAt = base.ID(), nil
+		if err := repo.createTransaction(context.Background(), noExpiry, createTransactionOpts{}); err == nil {
+			t.Error("expected error")
+		}
+	}
+
+	memoryAccounts, memoryTransactions := newInMemoryRepositories()
+	check(t, memoryAccounts, memoryTransactions)
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	sqliteRepo := createTestSqlAccountRepository(t, sqliteDB.DB)
+	check(t, sqliteRepo, sqliteRepo.transactionRepo)
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	mysqlRepo := createTestSqlAccountRepository(t, mysqlDB.DB)
+	check(t, mysqlRepo, mysqlRepo.transactionRepo)
+}
+
+func TestTransactionHolds__pendingBalances(t *testing.T) {
+	check := func(t *testing.T, accountRepo accountRepository, repo transactionRepository) {
+		account1, account2 := base.ID(), base.ID()
+		for _, id := range []string{account1, account2} {
+			acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: base.ID()[:9], RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+			if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+				t.Fatal(err)
+			}
+		}
+		deposit := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{{AccountID: account1, Purpose: ACHCredit, Amount: 1000}}}
+		if err := repo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+		create := func(status TransactionStatus, amount int) transaction {
+			t.Helper()
+			expiresAt := time.Now().Add(time.Hour)
+			tx := transaction{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Status:    status,
+				Lines: []transactionLine{
+					{AccountID: account1, Purpose: ACHDebit, Amount: amount},
+					{AccountID: account2, Purpose: ACHCredit, Amount: amount},
+				},
+			}
+			if status == TransactionHeld {
+				tx.ExpiresAt = &expiresAt
+			}
+			if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+				t.Fatal(err)
+			}
+			return tx
+		}
+		balances := func(id string, balance, available, pending int32) {
+			t.Helper()
+			accts, err := accountRepo.GetAccounts(context.Background(), []string{id})
+			if err != nil || len(accts) != 1 {
+				t.Fatalf("accounts=%#v error=%v", accts, err)
+			}
+			if a := accts[0]; a.Balance != balance || a.BalanceAvailable != available || a.BalancePending != pending {
+				t.Errorf("account=%s balance=%d available=%d pending=%d", id, a.Balance, a.BalanceAvailable, a.BalancePending)
+			}
+		}
+
+		// held debits are already reserved, while held credits are pending
+		held := create(TransactionHeld, 400)
+		balances(account1, 600, 600, 0)
+		balances(account2, 0, 0, 400)
+
+		// pending debits aren't available and pending credits can't be drawn on yet
+		pending := create(TransactionPending, 100)
+		balances(account1, 600, 500, -100)
+		balances(account2, 0, 0, 500)
+
+		if err := repo.updateTransactionStatus(pending.ID, TransactionPosted); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.updateTransactionStatus(held.ID, TransactionPosted); err != nil {
+			t.Fatal(err)
+		}
+		balances(account1, 500, 500, 0)
+		balances(account2, 500, 500, 0)
+	}
+
+	memoryAccounts, memoryTransactions := newInMemoryRepositories()
+	check(t, memoryAccounts, memoryTransactions)
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	sqliteRepo := createTestSqlAccountRepository(t, sqliteDB.DB)
+	check(t, sqliteRepo, sqliteRepo.transactionRepo)
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	mysqlRepo := createTestSqlAccountRepository(t, mysqlDB.DB)
+	check(t, mysqlRepo, mysqlRepo.transactionRepo)
+}
+
+func TestTransactionHolds__routes(t *testing.T) {
+	accountRepo, transactionRepo := newInMemoryRepositories()
+	for _, id := range []string{"a", "b"} {
+		acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: id, RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+		if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deposit := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{{AccountID: "a", Purpose: ACHCredit, Amount: 1000}}}
+	if err := transactionRepo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &mockEventPublisher{}, nil, time.Now)
+
+	serve := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("x-user-id", base.ID())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+	prepare := func() transaction {
+		t.Helper()
+		w := serve("/accounts/transactions/prepare", `{"lines": [{"accountId": "a", "purpose": "achdebit", "amount": 250}, {"accountId": "b", "purpose": "achcredit", "amount": 250}], "timeout": "30s"}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		var tx transaction
+		if err := json.NewDecoder(w.Body).Decode(&tx); err != nil {
+			t.Fatal(err)
+		}
+		if tx.Status != TransactionHeld || tx.ExpiresAt == nil || tx.ExpiresAt.Sub(tx.Timestamp) != 30*time.Second {
+			t.Errorf("unexpected transaction: %#v", tx)
+		}
+		return tx
+	}
+
+	tx := prepare()
+	if w := serve("/accounts/transactions/"+tx.ID+"/commit", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"posted"`) {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	// only held transactions can be committed or aborted
+	if w := serve("/accounts/transactions/"+tx.ID+"/abort", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	tx = prepare()
+	if w := serve("/accounts/transactions/"+tx.ID+"/abort", ""); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"voided"`) {
+		t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	// holds can't be made through the regular endpoint
+	if w := serve("/accounts/transactions", `{"lines": [{"accountId": "a", "purpose": "achdebit", "amount": 1}, {"accountId": "b", "purpose": "achcredit", "amount": 1}], "status": "held"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+
+	accts, _ := accountRepo.GetAccounts(context.Background(), []string{"a", "b"})
+	if len(accts) != 2 || accts[0].Balance != 750 || accts[1].Balance != 250 {
+		t.Errorf("unexpected accounts: %#v", accts)
+	}
+}
+
+func TestTransactionHolds__expireHolds(t *testing.T) {
+	accountRepo, transactionRepo := newInMemoryRepositories()
+	acct := &accounts.Account{ID: "a", CustomerID: "customer", AccountNumber: "a", RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+	if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+		t.Fatal(err)
+	}
+	deposit := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{{AccountID: "a", Purpose: ACHCredit, Amount: 1000}}}
+	if err := transactionRepo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := &mockEventPublisher{}
+	svc := &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: events}
+	tx, err := svc.PrepareTransaction(context.Background(), prepareTransactionRequest{
+		Lines: []transactionLine{
+			{AccountID: "a", Purpose: ACHDebit, Amount: 300},
+			{AccountID: "external", Purpose: ACHCredit, Amount: 300},
+		},
+		Timeout: "1m",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := svc.expireHolds(context.Background(), time.Now()); err != nil || n != 0 {
+		t.Errorf("aborted=%d error=%v", n, err)
+	}
+	if n, err := svc.expireHolds(context.Background(), time.Now().Add(time.Minute)); err != nil || n != 1 {
+		t.Errorf("aborted=%d error=%v", n, err)
+	}
+	if tx, err := svc.GetTransaction(context.Background(), tx.ID); err != nil || tx.Status != TransactionVoided {
+		t.Errorf("transaction=%#v error=%v", tx, err)
+	}
+	if accts, _ := accountRepo.GetAccounts(context.Background(), []string{"a"}); len(accts) != 1 || accts[0].Balance != 1000 {
+		t.Errorf("unexpected accounts: %#v", accts)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type requestIDKey struct{}
+
+// withRequestID returns a context carrying the caller's request ID for logging.
+func withRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+func requestIDFrom(ctx context.Context) string {
+	v, _ := ctx.Value(requestIDKey{}).(string)
+	return v
+}
+
+// transactionService holds the business logic for transactions (validation, posting, reversals and events)
+// independent of any transport. HTTP handlers only decode requests and encode responses, so another transport
+// can call the same methods without validation or side-effects drifting between them.
+type transactionService struct {
+	logger      log.Logger
+	repo        transactionRepository
+	attachments attachmentRepository
+	events      eventPublisher
+
+	// fraud scores postings when a scoring service is configured, see fraud_scoring.go
+	fraud *fraudScreen
+
+	// clock is the time reversals are posted at, which is the virtual clock on sandbox instances
+	clock func() time.Time
+}
+
+func (s *transactionService) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock()
+}
+
+func (s *transactionService) GetAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+	if accountID == "" {
+		return nil, "", errNoAccountID
+	}
+	return s.repo.getAccountTransactions(ctx, accountID, page)
+}
+
+func (s *transactionService) GetTransaction(ctx context.Context, transactionID string) (*transaction, error) {
+	if transactionID == "" {
+		return nil, errNoTransactionID
+	}
+	return s.repo.getTransaction(ctx, transactionID)
+}
+
+func (s *transactionService) CreateTransaction(ctx context.Context, req createTransactionRequest) (*transaction, error) {
+	requestID := requestIDFrom(ctx)
+
+	transactionID, err := req.transactionID()
+	if err != nil {
+		return nil, err
+	}
+	if req.Status == TransactionHeld {
+		return nil, fmt.Errorf("transaction=%s: use the prepare endpoint to hold transactions", transactionID)
+	}
+	if len(req.IdempotencyKey) > maxIdempotencyKeyLength {
+		return nil, fmt.Errorf("idempotency key is longer than %d characters", maxIdempotencyKeyLength)
+	}
+	if err := req.checkReservedMetadata(); err != nil {
+		return nil, err
+	}
+
+	// Return the transaction an earlier request with the same idempotency key created
+	if replay, err := s.replayTransaction(ctx, req); replay != nil || err != nil {
+		return replay, err
+	}
+
+	tx := req.asTransaction(transactionID)
+	if err := blockedMCCs.check(ctx, tx); err != nil {
+		s.logger.Log("transactions", err.Error(), "requestID", requestID)
+		return nil, err
+	}
+
+	// Decline the transaction, or create it pending until it's reviewed, if the fraud scoring service says so
+	decision := s.fraud.screen(ctx, tx)
+	if decision != nil {
+		switch decision.Outcome {
+		case fraudOutcomeDecline:
+			s.logger.Log("fraud", fmt.Sprintf("declined transaction=%s with score %v: %s", tx.ID, decision.Score, decision.Reason), "requestID", requestID)
+			if decision.Reason != "" {
+				return nil, fmt.Errorf("transaction=%s: %v: %s", tx.ID, errTransactionDeclined, decision.Reason)
+			}
+			return nil, fmt.Errorf("transaction=%s: %v", tx.ID, errTransactionDeclined)
+		case fraudOutcomeReview:
+			tx.Status = TransactionPending
+		}
+	}
+
+	// Post the transaction
+	if err := s.repo.createTransaction(ctx, tx, createTransactionOpts{AllowOverdraft: false}); err != nil {
+		// A concurrent request with the same idempotency key could have created it first
+		if replay, _ := s.replayTransaction(ctx, req); replay != nil {
+			return replay, nil
+		}
+		s.logger.Log("transactions", fmt.Errorf("problem creating transaction: %v", err), "requestID", requestID)
+		return nil, err
+	}
+	if decision != nil && decision.Outcome == fraudOutcomeReview {
+		if err := s.fraud.hold(tx, decision); err != nil {
+			// Fail the transaction rather than leave it pending where it could be posted without a review
+			s.logger.Log("fraud", fmt.Sprintf("problem holding transaction=%s for review: %v", tx.ID, err), "requestID", requestID)
+			if err := s.repo.updateTransactionStatus(tx.ID, TransactionFailed); err != nil {
+				s.logger.Log("fraud", fmt.Sprintf("problem failing transaction=%s: %v", tx.ID, err), "requestID", requestID)
+			}
+			return nil, err
+		}
+		s.logger.Log("fraud", fmt.Sprintf("holding transaction=%s for review with score %v: %s", tx.ID, decision.Score, decision.Reason), "requestID", requestID)
+	}
+	// Respond and publish the transaction as it was stored, which includes any fees added to its lines
+	if stored, err := s.repo.getTransaction(ctx, tx.ID); err != nil {
+		s.logger.Log("transactions", fmt.Sprintf("problem reading created transaction=%s: %v", tx.ID, err), "requestID", requestID)
+	} else {
+		tx = *stored
+	}
+	s.logger.Log("transaction", fmt.Errorf("created transaction %s", tx.ID), "requestID", requestID)
+	s.publish(ctx, tx.Status, &tx)
+
+	return &tx, nil
+}
+
+// replayTransaction returns the transaction created with req's idempotency key, or nil if there isn't one. An error
+// is returned if the key was used for a different transaction.
+func (s *transactionService) replayTransaction(ctx context.Context, req createTransactionRequest) (*transaction, error) {
+	if req.IdempotencyKey == "" {
+		return nil, nil
+	}
+	tx, err := s.repo.getTransactionByIdempotencyKey(ctx, req.IdempotencyKey)
+	if err != nil || tx == nil {
+		return nil, err
+	}
+	if id, _ := req.transactionID(); (req.ID != "" && id != tx.ID) || !sameLines(req.lines(), tx.Lines) {
+		return nil, fmt.Errorf("idempotency key %q was used for a different transaction=%s", req.IdempotencyKey, tx.ID)
+	}
+	// Return the transaction as it was stored, which is what the first request responded with
+	return s.repo.getTransaction(ctx, tx.ID)
+}
+
+// sameLines returns true when both transactions have the same lines in any order.
+func sameLines(a, b []transactionLine) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[transactionLine]int)
+	for i := range a {
+		counts[a[i]]++
+	}
+	for i := range b {
+		if counts[b[i]]--; counts[b[i]] < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// ReverseTransaction posts a transaction which offsets transactionID and marks the original as reversed.
+func (s *transactionService) ReverseTransaction(ctx context.Context, transactionID string) (*transaction, error) {
+	requestID := requestIDFrom(ctx)
+	s.logger.Log("transaction", fmt.Sprintf("reversing transaction %s", transactionID), "requestID", requestID)
+
+	// reverse the transaction (after reading it from our database)
+	original, err := s.GetTransaction(ctx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := original.Status.transition(TransactionReversed); err != nil {
+		return nil, fmt.Errorf("transaction=%s: %v", transactionID, err)
+	}
+	reversal := &transaction{
+		ID:          newID(),
+		Timestamp:   s.now(),
+		Status:      TransactionPosted,
+		Description: fmt.Sprintf("Reversal of transaction %s", transactionID),
+		Lines:       make([]transactionLine, len(original.Lines)),
+	}
+	copy(reversal.Lines, original.Lines)
+	for i := range reversal.Lines {
+		// Swap Purpose back if Debit vs Credit
+		switch {
+		case reversal.Lines[i].Purpose == ACHCredit:
+			reversal.Lines[i].Purpose = ACHDebit
+		case reversal.Lines[i].Purpose == ACHDebit:
+			reversal.Lines[i].Purpose = ACHCredit
+		}
+	}
+	// the original is marked reversed along with posting the reversal, so concurrent reversals can't both post
+	if err := s.repo.createTransaction(ctx, *reversal, createTransactionOpts{Reversal: true, Reverses: transactionID}); err != nil {
+		s.logger.Log("transactions", fmt.Errorf("problem reversing transaction=%s: %v", transactionID, err), "requestID", requestID)
+		return nil, err
+	}
+	s.logger.Log("transactions", fmt.Sprintf("reversed (original transaction=%s) transaction=%s", transactionID, reversal.ID), "requestID", requestID)
+	s.publish(ctx, TransactionPosted, reversal)
+	if original, err := s.repo.getTransaction(ctx, transactionID); err == nil {
+		s.publish(ctx, TransactionReversed, original)
+	}
+
+	return reversal, nil
+}
+
+func (s *transactionService) UpdateTransactionStatus(ctx context.Context, transactionID string, status TransactionStatus) (*transaction, error) {
+	requestID := requestIDFrom(ctx)
+
+	if err := status.validate(); err != nil {
+		return nil, err
+	}
+	// Reversals create an offsetting transaction, so they must go through ReverseTransaction.
+	if status == TransactionReversed {
+		return nil, fmt.Errorf("transaction=%s: use the reversal endpoint to reverse transactions", transactionID)
+	}
+
+	// Only change transactions in the caller's organization
+	if _, err := s.GetTransaction(ctx, transactionID); err != nil {
+		return nil, err
+	}
+	// Transactions held for fraud review are only posted once they're approved
+	if status == TransactionPosted {
+		if err := s.fraud.checkReviewed(transactionID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.repo.updateTransactionStatus(transactionID, status); err != nil {
+		s.logger.Log("transactions", fmt.Errorf("problem updating transaction=%s status: %v", transactionID, err), "requestID", requestID)
+		return nil, err
+	}
+	tx, err := s.repo.getTransaction(ctx, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	s.logger.Log("transactions", fmt.Sprintf("transaction=%s is now %s", transactionID, tx.Status), "requestID", requestID)
+	s.publish(ctx, tx.Status, tx)
+
+	return tx, nil
+}
+
+// publish emits the event for a transaction entering status. Failures are logged as the
+// transaction has already been committed.
+func (s *transactionService) publish(ctx context.Context, status TransactionStatus, tx *transaction) {
+	if err := s.events.publish(transactionEvent(status, tx)); err != nil {
+		s.logger.Log("transactions", fmt.Sprintf("problem publishing %s event for transaction=%s: %v", status, tx.ID, err), "requestID", requestIDFrom(ctx))
+	}
+}
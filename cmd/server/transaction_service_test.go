@@ -0,0 +1,76 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+type mockEventPublisher struct {
+	err    error
+	events []event
+}
+
+func (p *mockEventPublisher) publish(evt event) error {
+	p.events = append(p.events, evt)
+	return p.err
+}
+
+func TestTransactionService(t *testing.T) {
+	repo := &mockTransactionRepository{
+		transactions: []transaction{
+			{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Status:    TransactionPosted,
+				Lines: []transactionLine{
+					{AccountID: base.ID(), Purpose: ACHDebit, Amount: 1000},
+					{AccountID: base.ID(), Purpose: ACHCredit, Amount: 1000},
+				},
+			},
+		},
+	}
+	events := &mockEventPublisher{}
+	svc := &transactionService{logger: log.NewNopLogger(), repo: repo, events: events}
+	ctx := withRequestID(context.Background(), "request")
+
+	if _, err := svc.GetTransaction(ctx, ""); err != errNoTransactionID {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// reversed statuses can only come from ReverseTransaction
+	originalID := repo.transactions[0].ID
+	if _, err := svc.UpdateTransactionStatus(ctx, originalID, TransactionReversed); err == nil {
+		t.Error("expected error")
+	}
+
+	reversal, err := svc.ReverseTransaction(ctx, originalID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reversal.ID == originalID || reversal.Lines[0].Purpose != ACHCredit || reversal.Lines[1].Purpose != ACHDebit {
+		t.Errorf("unexpected reversal: %#v", reversal)
+	}
+	if repo.transactions[0].Status != TransactionReversed || repo.transactions[0].Lines[0].Purpose != ACHDebit {
+		t.Errorf("unexpected original: %#v", repo.transactions[0])
+	}
+	if len(events.events) != 2 || events.events[0].Type != "transaction.posted" || events.events[1].Type != "transaction.reversed" {
+		t.Errorf("unexpected events: %#v", events.events)
+	}
+
+	// a reversed transaction can't be reversed again
+	if _, err := svc.ReverseTransaction(ctx, originalID); err == nil {
+		t.Error("expected error")
+	}
+	if len(events.events) != 2 {
+		t.Errorf("unexpected events: %#v", events.events)
+	}
+}
@@ -4,13 +4,105 @@
 
 package main
 
+import (
+	"context"
+	"time"
+)
+
 type transactionRepository interface {
 	Ping() error
 	Close() error
 
-	createTransaction(tx transaction, opts createTransactionOpts) error
-	getAccountTransactions(accountID string) ([]transaction, error) // TODO(adam): limit and/or pagination params
-	getTransaction(transactionID string) (*transaction, error)
+	createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error
+
+	// getAccountTransactions returns a page of the account's transactions, newest first, and the cursor of the
+	// next page which is empty after the last page.
+	getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error)
+
+	getTransaction(ctx context.Context, transactionID string) (*transaction, error)
+
+	// getTransactionByIdempotencyKey returns nil if no transaction was created with the key.
+	getTransactionByIdempotencyKey(ctx context.Context, key string) (*transaction, error)
+
+	// updateTransactionStatus moves a transaction into status, returning an error if the transition isn't allowed.
+	updateTransactionStatus(transactionID string, status TransactionStatus) error
+
+	// getExpiredHolds returns the IDs of held transactions which expired at or before now.
+	getExpiredHolds(now time.Time) ([]string, error)
+
+	// compactTransactionLines rolls up lines created before the cutoff into daily per-account summaries
+	// and archives the raw lines.
+	compactTransactionLines(before time.Time) (*compactionResult, error)
+
+	// repairAccountBalance recomputes an account's balance checkpoint from transaction lines, optionally
+	// correcting any drift found.
+	repairAccountBalance(accountID string, repair bool) (*balanceRepair, error)
+
+	// getSegmentTotals sums posted lines by the query's segment, ordered by segment value.
+	getSegmentTotals(q segmentTotalsQuery) ([]segmentTotal, error)
+
+	// getMCCSpend sums the debits of posted card transactions by MCC, largest first.
+	getMCCSpend(q mccSpendQuery) ([]mccSpend, error)
+
+	// getTrialBalance sums posted lines by account, ordered by account ID.
+	getTrialBalance(q trialBalanceQuery) ([]trialBalanceAccount, error)
+
+	// getDailyTotals returns the account's posted totals on each UTC day before end's, oldest first. See daily_totals.go
+	getDailyTotals(accountID string, end time.Time) ([]dailyTotal, error)
+}
+
+// transactionPage selects a page of an account's transactions, optionally filtered.
+type transactionPage struct {
+	// Limit is the most transactions returned, every transaction is returned when zero.
+	Limit int
+
+	// Cursor is the ID of the last transaction on the previous page.
+	Cursor string
+
+	// StartDate and EndDate bound the transaction timestamps included, when set. EndDate is exclusive.
+	StartDate, EndDate time.Time
+
+	// Purpose, MinAmount and MaxAmount filter on the account's line in each transaction, when set.
+	Purpose              TransactionPurpose
+	MinAmount, MaxAmount int
+
+	// Department, Product and Region filter on the segments of the account's line, when set.
+	Department, Product, Region string
+
+	// Posted only includes posted (and later reversed) transactions, which are the ones in account balances.
+	Posted bool
+
+	// Tags only includes transactions with every tag, when set.
+	Tags []string
+}
+
+// matches returns true if the transaction passes the page's filters.
+func (p transactionPage) matches(t *transaction, accountID string) bool {
+	if !p.StartDate.IsZero() && t.Timestamp.Before(p.StartDate) {
+		return false
+	}
+	if !p.EndDate.IsZero() && !t.Timestamp.Before(p.EndDate) {
+		return false
+	}
+	if p.Posted && t.Status != TransactionPosted && t.Status != TransactionReversed {
+		return false
+	}
+	if !t.hasTags(p.Tags) {
+		return false
+	}
+	for i := range t.Lines {
+		if t.Lines[i].AccountID != accountID {
+			continue
+		}
+		line := t.Lines[i]
+		for segment, v := range p.segments() {
+			if segment.value(line) != v {
+				return false
+			}
+		}
+		return (p.Purpose == "" || line.Purpose == p.Purpose) && (p.MinAmount == 0 || line.Amount >= p.MinAmount) && (p.MaxAmount == 0 || line.Amount <= p.MaxAmount)
+	}
+	return false
 }
 
 type createTransactionOpts struct {
@@ -22,6 +114,30 @@ type createTransactionOpts struct {
 	// to onboard on account. This is done to initially add funds into an account, but we don't track where the
 	// funds come from on the transaction level.
 	InitialDeposit bool
+
+	// Reversal is set when the transaction offsets an earlier one, which shouldn't be held to limits on new
+	// postings.
+	Reversal bool
+
+	// Reverses is the ID of the transaction this one reverses. It's marked reversed as the reversal is written,
+	// failing the reversal if its status doesn't allow it, so a transaction can only be reversed once.
+	Reverses string
+
+	// Check is called inside the posting once its debited accounts are locked, so it reads every transaction
+	// committed against them ahead of this one. An error rejects the transaction.
+	Check func(ctx context.Context, postings accountTransactionReader) error
+}
+
+// accountTransactionReader reads pages of an account's transactions, newest first.
+type accountTransactionReader interface {
+	getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error)
+}
+
+// accountTransactionsFunc reads an account's transactions inside a posting.
+type accountTransactionsFunc func(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error)
+
+func (f accountTransactionsFunc) getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+	return f(ctx, accountID, page)
 }
 
 // grabAccountIDs returns an []string of each accountID from an array of transactionLines.
@@ -33,3 +149,14 @@ func grabAccountIDs(lines []transactionLine) []string {
 	}
 	return out
 }
+
+// grabDebitedAccountIDs returns the accounts lines take funds from.
+func grabDebitedAccountIDs(lines []transactionLine) []string {
+	var out []string
+	for i := range lines {
+		if lineAmount(lines[i]) < 0 {
+			out = append(out, lines[i].AccountID)
+		}
+	}
+	return out
+}
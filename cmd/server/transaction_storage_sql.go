@@ -7,35 +7,74 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
 
 	"github.com/go-kit/kit/log"
 )
 
+var (
+	// balanceStripes is how many rows each account's balance is spread across. Postings update a random
+	// stripe and reads sum them, so hot accounts (GL or settlement accounts) don't serialize every posting
+	// on a single row.
+	balanceStripes = func() int {
+		if v := os.Getenv("BALANCE_STRIPES"); v != "" {
+			if n, _ := strconv.ParseInt(v, 10, 32); n > 0 {
+				return int(n)
+			}
+		}
+		return 1
+	}()
+)
+
 type sqlTransactionRepository struct {
 	db     *sql.DB
 	logger log.Logger
 
+	// postingDB serves createTransaction, status changes and the balance checks inside them. It's the
+	// same pool as db unless usePostingPool reserves separate connections for moving money.
+	postingDB *sql.DB
+
 	accountRepo accountRepository
+
+	// epoch returns the fencing token our region holds, which postings are checked against. It's nil unless
+	// REGION is set, see region_fencing.go.
+	epoch func() int64
 }
 
 func setupSqlTransactionStorage(ctx context.Context, logger log.Logger, db *sql.DB) (*sqlTransactionRepository, error) {
 	// Break the cyclic dependency between account and transaction repositories
-	repo := &sqlTransactionRepository{db: db, logger: logger}
+	repo := &sqlTransactionRepository{db: db, logger: logger, postingDB: db}
 	accountRepo := &sqlAccountRepository{db, logger, repo}
 	repo.accountRepo = accountRepo
 	return repo, nil
 }
 
+// usePostingPool moves postings (and the account reads they need) onto db so reads and reports
+// on the general pool can't take every connection away from them.
+func (r *sqlTransactionRepository) usePostingPool(db *sql.DB) {
+	r.postingDB = db
+	r.accountRepo = &sqlAccountRepository{db, r.logger, r}
+}
+
 func (r *sqlTransactionRepository) Ping() error {
 	return r.db.Ping()
 }
 
 func (r *sqlTransactionRepository) Close() error {
+	if r.postingDB != r.db {
+		r.postingDB.Close()
+	}
 	return r.db.Close()
 }
 
@@ -56,46 +95,187 @@ func isInternalDebit(accounts []*accounts.Account, lines []transactionLine, rout
 	return true // default to assuming we need to check/prevent an overdraft
 }
 
-func (r *sqlTransactionRepository) createTransaction(t transaction, opts createTransactionOpts) error {
+// overdrawn returns true if an account's balance, after line was applied onto it, leaves the account without
+// sufficient funds. Accounts with an overdraft limit can have debits take their balance down to -limit.
+func overdrawn(accounts []*accounts.Account, line transactionLine, balance int32) bool {
+	for i := range accounts {
+		if accounts[i].ID == line.AccountID && accounts[i].OverdraftLimit > 0 {
+			return lineAmount(line) < 0 && balance < -accounts[i].OverdraftLimit
+		}
+	}
+	return balance <= 0 || (balance <= int32(line.Amount) && line.Purpose == ACHDebit)
+}
+
+func (r *sqlTransactionRepository) createTransaction(ctx context.Context, t transaction, opts createTransactionOpts) error {
 	if err := t.validate(); err != nil && !opts.InitialDeposit {
 		return fmt.Errorf("transaction=%q is invalid: %v", t.ID, err)
 	}
 
-	accounts, err := r.accountRepo.GetAccounts(grabAccountIDs(t.Lines))
+	accounts, err := r.accountRepo.GetAccounts(unscoped(ctx), grabAccountIDs(t.Lines))
 	if err != nil {
 		return fmt.Errorf("createTransaction: problem reading accounts for transaction=%q: %v", t.ID, err)
 	}
+	organizations, err := organizeTransaction(ctx, &t, accounts)
+	if err != nil {
+		return fmt.Errorf("createTransaction: transaction=%q: %v", t.ID, err)
+	}
+	if err := checkAccountStatuses(accounts, t.Lines); err != nil {
+		return fmt.Errorf("createTransaction: transaction=%q: %v", t.ID, err)
+	}
 
-	tx, err := r.db.Begin()
+	_, span := startSQLSpan(ctx, "begin") // waits for a connection when the pool is busy
+	tx, err := r.postingDB.Begin()
+	endSpan(span, err)
 	if err != nil {
-		return fmt.Errorf("createTransaction: tx.Begin error=%v rollback=%v", err, tx.Rollback())
+		return fmt.Errorf("createTransaction: tx.Begin error=%v", err)
+	}
+	// Lock the debited balances before reading anything, so the insufficient funds check and opts.Check see every
+	// posting which held the lock ahead of ours. MySQL takes tx's snapshot on its first read, so locking after that
+	// would leave them reading a snapshot from before the other posting committed.
+	if opts.Check != nil || (!opts.AllowOverdraft && isInternalDebit(accounts, t.Lines, defaultRoutingNumber)) {
+		_, span = startSQLSpan(ctx, "lockAccountBalances") // includes waiting on balance locks held by other postings
+		err = r.lockAccountBalances(tx, grabDebitedAccountIDs(t.Lines))
+		endSpan(span, err)
+		if err != nil {
+			return fmt.Errorf("createTransaction: transaction=%q: %v rollback=%v", t.ID, err, tx.Rollback())
+		}
+	}
+	if err := r.checkEpoch(tx); err != nil {
+		return fmt.Errorf("createTransaction: transaction=%q: %v rollback=%v", t.ID, err, tx.Rollback())
+	}
+	if opts.Reverses != "" {
+		if err := r.markReversed(ctx, tx, opts.Reverses); err != nil {
+			return fmt.Errorf("createTransaction: transaction=%q: %v rollback=%v", t.ID, err, tx.Rollback())
+		}
+	}
+	if opts.Check != nil {
+		postings := accountTransactionsFunc(func(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+			return r.readAccountTransactions(ctx, tx, accountID, page)
+		})
+		if err := opts.Check(ctx, postings); err != nil {
+			return fmt.Errorf("createTransaction: %v rollback=%v", err, tx.Rollback())
+		}
+	}
+
+	if t.Status == "" {
+		t.Status = TransactionPosted
 	}
 
 	// insert transaction
-	query := `insert into transactions(transaction_id, timestamp, created_at) values (?, ?, ?);`
+	var idempotencyKey *string
+	if t.IdempotencyKey != "" {
+		idempotencyKey = &t.IdempotencyKey
+	}
+	var forcePostID *string
+	if t.ForcePostID != "" {
+		forcePostID = &t.ForcePostID
+	}
+	_, span = startSQLSpan(ctx, "insertTransaction")
+	err = r.insertTransaction(tx, t, organizations, idempotencyKey, forcePostID)
+	endSpan(span, err)
+	if err != nil {
+		return err
+	}
+
+	// Pending transactions don't affect balances until they're posted and held transactions only reserve their debits
+	_, span = startSQLSpan(ctx, "applyLines")
+	switch t.Status {
+	case TransactionPosted:
+		err = r.applyLines(tx, t, accounts, opts)
+	case TransactionHeld:
+		err = r.applyLines(tx, transaction{ID: t.ID, Lines: holdLines(t.Lines, TransactionHeld)}, accounts, opts)
+	}
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("createTransaction: transaction=%q: %w rollback=%v", t.ID, err, tx.Rollback())
+	}
+
+	_, span = startSQLSpan(ctx, "commit")
+	err = tx.Commit()
+	endSpan(span, err)
+	if err != nil {
+		return fmt.Errorf("createTransaction: commit: %v", err)
+	}
+	return nil
+}
+
+// markReversed moves the transaction a reversal offsets into reversed inside the reversal's tx. The update only
+// matches the status read, so of two concurrent reversals the second fails rather than posting again.
+func (r *sqlTransactionRepository) markReversed(ctx context.Context, tx *sql.Tx, transactionID string) error {
+	original, err := r.loadTransaction(ctx, tx, transactionID)
+	if err != nil {
+		return fmt.Errorf("reverses transaction=%q: %v", transactionID, err)
+	}
+	if err := original.Status.transition(TransactionReversed); err != nil {
+		return fmt.Errorf("reverses transaction=%q: %v", transactionID, err)
+	}
+	query := `update transactions set status = ? where transaction_id = ? and status = ? and deleted_at is null;`
+	res, err := tx.Exec(query, TransactionReversed, transactionID, original.Status)
+	if err != nil {
+		return fmt.Errorf("reverses transaction=%q: update: %v", transactionID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("reverses transaction=%q: modified concurrently", transactionID)
+	}
+	return nil
+}
+
+// insertTransaction writes a transaction and its lines inside tx, rolling tx back on an error. Each line is stored
+// with the organization of its account from organizations.
+func (r *sqlTransactionRepository) insertTransaction(tx *sql.Tx, t transaction, organizations map[string]string, idempotencyKey, forcePostID *string) error {
+	var metadata *string
+	if len(t.Metadata) > 0 {
+		bs, err := json.Marshal(t.Metadata)
+		if err != nil {
+			return fmt.Errorf("createTransaction: metadata: error=%v rollback=%v", err, tx.Rollback())
+		}
+		v := string(bs)
+		metadata = &v
+	}
+	query := `insert into transactions(transaction_id, organization_id, timestamp, created_at, status, expires_at, idempotency_key, force_post_id, metadata, description) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
 	stmt, err := tx.Prepare(query)
 	if err != nil {
 		return fmt.Errorf("createTransaction: prepare: error=%v rollback=%v", err, tx.Rollback())
 	}
-	if _, err := stmt.Exec(t.ID, t.Timestamp, time.Now()); err != nil {
+	if _, err := stmt.Exec(t.ID, t.OrganizationID, t.Timestamp, time.Now(), t.Status, t.ExpiresAt, idempotencyKey, forcePostID, metadata, nullableSegment(t.Description)); err != nil {
 		stmt.Close()
+		if database.UniqueViolation(err) {
+			return fmt.Errorf("createTransaction: transaction=%q: %v rollback=%v", t.ID, errDuplicateTransactionID, tx.Rollback())
+		}
 		return fmt.Errorf("createTransaction: insert: error=%v rollback=%v", err, tx.Rollback())
 	}
 	stmt.Close()
 
 	// insert each transactionLine
 	for i := range t.Lines {
-		query = `insert into transaction_lines(transaction_id, account_id, purpose, amount, created_at) values (?, ?, ?, ?, ?);`
+		query = `insert into transaction_lines(transaction_id, account_id, organization_id, purpose, amount, created_at, department, product, region, mcc, merchant_country, description) values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
 		stmt, err = tx.Prepare(query)
 		if err != nil {
 			stmt.Close()
 			return fmt.Errorf("createTransaction: transaction=%q account=%q prepare: error=%v rollback=%v", t.ID, t.Lines[i].AccountID, err, tx.Rollback())
 		}
-		if _, err := stmt.Exec(t.ID, t.Lines[i].AccountID, t.Lines[i].Purpose, t.Lines[i].Amount, time.Now()); err != nil {
+		line := t.Lines[i]
+		if _, err := stmt.Exec(t.ID, line.AccountID, organizations[line.AccountID], line.Purpose, line.Amount, time.Now(), nullableSegment(line.Department), nullableSegment(line.Product), nullableSegment(line.Region), nullableSegment(line.MCC), nullableSegment(line.MerchantCountry), nullableSegment(line.Description)); err != nil {
 			stmt.Close()
 			return fmt.Errorf("createTransaction: transaction=%q account=%q insert: error=%v rollback=%v", t.ID, t.Lines[i].AccountID, err, tx.Rollback())
 		}
 		stmt.Close()
+	}
+	for i := range t.Tags {
+		if _, err := tx.Exec(`insert into transaction_tags(transaction_id, tag) values (?, ?);`, t.ID, t.Tags[i]); err != nil {
+			return fmt.Errorf("createTransaction: transaction=%q tag=%q insert: error=%v rollback=%v", t.ID, t.Tags[i], err, tx.Rollback())
+		}
+	}
+	return nil
+}
+
+// applyLines adds each line of a transaction onto its account's balance, rejecting the transaction when an
+// internal account doesn't have sufficient funds. Callers lock the debited balances first.
+func (r *sqlTransactionRepository) applyLines(tx *sql.Tx, t transaction, accounts []*accounts.Account, opts createTransactionOpts) error {
+	for i := range t.Lines {
+		if err := r.addToBalance(tx, t.Lines[i].AccountID, lineAmount(t.Lines[i])); err != nil {
+			return fmt.Errorf("account=%q balance: %v", t.Lines[i].AccountID, err)
+		}
 
 		// Check account balance, and if we're negative by less than t.Lines[i].Amount then we need to rollback as that account
 		// didn't have sufficient funds to post the transaction.
@@ -104,51 +284,213 @@ func (r *sqlTransactionRepository) createTransaction(t transaction, opts createT
 		// to be done on an account-by-account basis.
 		if opts.InitialDeposit {
 			if t.Lines[0].Purpose != ACHCredit {
-				return fmt.Errorf("createTransaction: InitialDeposit must be ACHCredit rollback=%v", tx.Rollback())
+				return errors.New("InitialDeposit must be ACHCredit")
 			}
 			if len(t.Lines) == 1 && t.Lines[0].Amount > 100 {
 				// Ignore all other checks and just allow the deposit
 				continue
 			}
 		}
+		// If the debited account is external then allow the transfer. (That accounts system will send back a returned file on an insufficient balance.)
+		// Credits can't overdraw an account, so only debited balances are checked.
+		if opts.AllowOverdraft || lineAmount(t.Lines[i]) >= 0 || !isInternalDebit(accounts, t.Lines, defaultRoutingNumber) {
+			continue
+		}
 		// TODO(adam): I think we need to add a check (to bypass further validation) on external accounts
 		// since we won't have an accurate way to confirm their balance.
 		balance, err := r.getAccountBalance(tx, t.Lines[i].AccountID)
 		if err != nil {
-			return fmt.Errorf("createTransaction: getAccountBalance: transaction=%q account=%q: err=%v rollback=%v", t.ID, t.Lines[i].AccountID, err, tx.Rollback())
+			return fmt.Errorf("getAccountBalance: account=%q: %v", t.Lines[i].AccountID, err)
 		}
 		// The current account balance is negative, so if that balance is less negative than the transaction amount that means the
 		// account was overdrawn (i.e. insufficient funds). If the balances are equal then we also ran out of funds.
-		//
-		// If the debited account is external then allow the transfer. (That accounts system will send back a returned file on an insufficient balance.)
-		if opts.AllowOverdraft || !isInternalDebit(accounts, t.Lines, defaultRoutingNumber) {
-			continue
+		if overdrawn(accounts, t.Lines[i], balance) {
+			return fmt.Errorf("account=%q has %w", t.Lines[i].AccountID, errInsufficientFunds)
+		}
+	}
+	return nil
+}
+
+// updateTransactionStatus moves a transaction into another status if the transition is allowed. Pending transactions
+// which become posted have their lines applied onto account balances at that time.
+func (r *sqlTransactionRepository) updateTransactionStatus(transactionID string, status TransactionStatus) error {
+	existing, err := r.readTransaction(context.Background(), r.postingDB, transactionID)
+	if err != nil {
+		return fmt.Errorf("updateTransactionStatus: %v", err)
+	}
+	accounts, err := r.accountRepo.GetAccounts(context.Background(), grabAccountIDs(existing.Lines))
+	if err != nil {
+		return fmt.Errorf("updateTransactionStatus: problem reading accounts for transaction=%q: %v", transactionID, err)
+	}
+
+	tx, err := r.postingDB.Begin()
+	if err != nil {
+		return fmt.Errorf("updateTransactionStatus: begin: %v", err)
+	}
+	if err := r.checkEpoch(tx); err != nil {
+		return fmt.Errorf("updateTransactionStatus: transaction=%q: %v rollback=%v", transactionID, err, tx.Rollback())
+	}
+	t, err := r.loadTransaction(context.Background(), tx, transactionID)
+	if err != nil {
+		return fmt.Errorf("updateTransactionStatus: error=%v rollback=%v", err, tx.Rollback())
+	}
+	if err := t.Status.transition(status); err != nil {
+		return fmt.Errorf("updateTransactionStatus: transaction=%q: %v rollback=%v", transactionID, err, tx.Rollback())
+	}
+	switch {
+	case t.Status == TransactionPending && status == TransactionPosted:
+		if isInternalDebit(accounts, t.Lines, defaultRoutingNumber) {
+			if err := r.lockAccountBalances(tx, grabDebitedAccountIDs(t.Lines)); err != nil {
+				return fmt.Errorf("updateTransactionStatus: transaction=%q: %v rollback=%v", transactionID, err, tx.Rollback())
+			}
+		}
+		if err := r.applyLines(tx, *t, accounts, createTransactionOpts{}); err != nil {
+			return fmt.Errorf("updateTransactionStatus: transaction=%q: %v rollback=%v", transactionID, err, tx.Rollback())
 		}
-		if balance <= 0 || (balance <= int32(t.Lines[i].Amount) && t.Lines[i].Purpose == ACHDebit) {
-			return fmt.Errorf("acocunt=%q has insufficient funds: rollback=%v", t.Lines[i].AccountID, tx.Rollback())
+	case t.Status == TransactionHeld:
+		if status == TransactionPosted && t.holdExpired(time.Now()) {
+			return fmt.Errorf("updateTransactionStatus: transaction=%q: %v rollback=%v", transactionID, errHoldExpired, tx.Rollback())
+		}
+		// The debits were checked when the hold was created, so committing or aborting can't overdraw an account.
+		lines := transaction{ID: t.ID, Lines: holdLines(t.Lines, status)}
+		if err := r.applyLines(tx, lines, accounts, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			return fmt.Errorf("updateTransactionStatus: transaction=%q: %v rollback=%v", transactionID, err, tx.Rollback())
 		}
 	}
 
+	// Only update the row if nobody else changed the status since we read it
+	query := `update transactions set status = ? where transaction_id = ? and status = ? and deleted_at is null;`
+	res, err := tx.Exec(query, status, transactionID, t.Status)
+	if err != nil {
+		return fmt.Errorf("updateTransactionStatus: update: error=%v rollback=%v", err, tx.Rollback())
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("updateTransactionStatus: transaction=%q was modified concurrently rollback=%v", transactionID, tx.Rollback())
+	}
+
 	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("createTransaction: commit: %v", err)
+		return fmt.Errorf("updateTransactionStatus: commit: %v", err)
 	}
 	return nil
 }
 
-func (r *sqlTransactionRepository) getAccountTransactions(accountID string) ([]transaction, error) {
+func (r *sqlTransactionRepository) getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
+	_, span := startSQLSpan(ctx, "begin")
 	tx, err := r.db.Begin()
+	endSpan(span, err)
 	if err != nil {
-		return nil, fmt.Errorf("getAccountTransactions: %v", err)
+		return nil, "", fmt.Errorf("getAccountTransactions: %v", err)
 	}
 
-	query := `select distinct transaction_id from transaction_lines where account_id = ? order by created_at desc;`
-	stmt, err := tx.Prepare(query)
+	transactions, next, err := r.readAccountTransactions(ctx, tx, accountID, page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, span = startSQLSpan(ctx, "commit")
+	err = tx.Commit()
+	endSpan(span, err)
+	if err != nil {
+		return nil, "", fmt.Errorf("getAccountTransactions: commit: error=%v rollback=%v", err, tx.Rollback())
+	}
+	return transactions, next, nil
+}
+
+// readAccountTransactions reads a page of the account's transactions inside tx, rolling tx back on an error.
+func (r *sqlTransactionRepository) readAccountTransactions(ctx context.Context, tx *sql.Tx, accountID string, page transactionPage) ([]transaction, string, error) {
+	_, span := startSQLSpan(ctx, "selectTransactionIDs")
+	transactionIDs, err := r.selectAccountTransactionIDs(ctx, tx, accountID, page)
+	endSpan(span, err)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if page.Limit > 0 && len(transactionIDs) > page.Limit {
+		transactionIDs = transactionIDs[:page.Limit]
+		next = transactionIDs[page.Limit-1]
+	}
+
+	_, span = startSQLSpan(ctx, "loadTransactions")
+	transactions := make([]transaction, 0, len(transactionIDs))
+	for i := range transactionIDs {
+		t, err := r.loadTransaction(ctx, tx, transactionIDs[i])
+		if err != nil {
+			endSpan(span, err)
+			return nil, "", fmt.Errorf("getAccountTransactions: looping: error=%v rollback=%v", err, tx.Rollback())
+		}
+		transactions = append(transactions, *t)
+	}
+	span.End()
+	return transactions, next, nil
+}
+
+// selectAccountTransactionIDs returns the IDs of the page's transactions against an account in ctx's organization,
+// plus one more if there's another page, rolling tx back on an error.
+func (r *sqlTransactionRepository) selectAccountTransactionIDs(ctx context.Context, tx *sql.Tx, accountID string, page transactionPage) ([]string, error) {
+	// Each transaction has one line per account, which is either still in transaction_lines or has been compacted
+	// into transaction_lines_archive.
+	organization, organizationArgs := organizationFilter(ctx, "organization_id")
+	accountLines := fmt.Sprintf(`select transaction_id, purpose, amount, created_at, department, product, region from transaction_lines where account_id = ?%s
+  union all
+  select transaction_id, purpose, amount, created_at, department, product, region from transaction_lines_archive where account_id = ?%[1]s`, organization)
+	accountArgs := append(append([]interface{}{accountID}, organizationArgs...), append([]interface{}{accountID}, organizationArgs...)...)
+	query, args := fmt.Sprintf(`select l.transaction_id from (%s) as l`, accountLines), accountArgs
+	var where []string
+	if !page.StartDate.IsZero() || !page.EndDate.IsZero() || page.Posted {
+		query += ` inner join transactions t on t.transaction_id = l.transaction_id`
+	}
+	if page.Cursor != "" {
+		var n int
+		if err := tx.QueryRow(fmt.Sprintf(`select count(*) from (%s) as l where transaction_id = ?;`, accountLines), append(accountArgs, page.Cursor)...).Scan(&n); err != nil {
+			return nil, fmt.Errorf("getAccountTransactions: cursor: error=%v rollback=%v", err, tx.Rollback())
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("getAccountTransactions: %v (rollback=%v)", errInvalidCursor, tx.Rollback())
+		}
+		// Pages continue after the cursor's line, comparing IDs between lines created at the same time.
+		query += fmt.Sprintf(`, (select created_at, transaction_id from (%s) as c where transaction_id = ?) as c`, accountLines)
+		args = append(append(args, accountArgs...), page.Cursor)
+		where = append(where, `(l.created_at < c.created_at or (l.created_at = c.created_at and l.transaction_id < c.transaction_id))`)
+	}
+	// Dates are compared in UTC, as SQLite compares timestamps as text and days may start in another time zone
+	if !page.StartDate.IsZero() {
+		where, args = append(where, `t.timestamp >= ?`), append(args, page.StartDate.UTC())
+	}
+	if !page.EndDate.IsZero() {
+		where, args = append(where, `t.timestamp < ?`), append(args, page.EndDate.UTC())
+	}
+	if page.Purpose != "" {
+		where, args = append(where, `l.purpose = ?`), append(args, page.Purpose)
+	}
+	if page.MinAmount > 0 {
+		where, args = append(where, `l.amount >= ?`), append(args, page.MinAmount)
+	}
+	if page.MaxAmount > 0 {
+		where, args = append(where, `l.amount <= ?`), append(args, page.MaxAmount)
+	}
+	if page.Posted {
+		where = append(where, `t.status in ('posted', 'reversed')`)
+	}
+	for _, tag := range page.Tags {
+		where, args = append(where, `l.transaction_id in (select transaction_id from transaction_tags where tag = ?)`), append(args, tag)
+	}
+	segmentWhere, segmentArgs := trialBalanceSegmentFilters(page.segments())
+	where, args = append(where, segmentWhere...), append(args, segmentArgs...)
+	if len(where) > 0 {
+		query += ` where ` + strings.Join(where, ` and `)
+	}
+	query += ` order by l.created_at desc, l.transaction_id desc`
+	if page.Limit > 0 {
+		query += fmt.Sprintf(` limit %d`, page.Limit+1) // one extra line tells us if there's another page
+	}
+	stmt, err := tx.Prepare(query + ";")
 	if err != nil {
 		return nil, fmt.Errorf("getAccountTransactions: prepare: error=%v rollback=%v", err, tx.Rollback())
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(accountID)
+	rows, err := stmt.Query(args...)
 	if err != nil {
 		return nil, fmt.Errorf("getAccountTransactions: query: error=%v rollback=%v", err, tx.Rollback())
 	}
@@ -165,55 +507,73 @@ func (r *sqlTransactionRepository) getAccountTransactions(accountID string) ([]t
 	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("getAccountTransactions: err: error=%v rollback=%v", err, tx.Rollback())
 	}
+	return transactionIDs, nil
+}
 
-	var transactions []transaction
-	for i := range transactionIDs {
-		t, err := r.loadTransaction(tx, transactionIDs[i])
-		if err != nil {
-			return nil, fmt.Errorf("getAccountTransactions: looping: error=%v rollback=%v", err, tx.Rollback())
-		}
-		transactions = append(transactions, *t)
+func (r *sqlTransactionRepository) getTransaction(ctx context.Context, transactionID string) (*transaction, error) {
+	return r.readTransaction(ctx, r.db, transactionID)
+}
+
+func (r *sqlTransactionRepository) getTransactionByIdempotencyKey(ctx context.Context, key string) (*transaction, error) {
+	organization, organizationArgs := organizationFilter(ctx, "organization_id")
+	query := fmt.Sprintf(`select transaction_id from transactions where idempotency_key = ? and deleted_at is null%s limit 1;`, organization)
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return nil, fmt.Errorf("getTransactionByIdempotencyKey: prepare: %v", err)
 	}
+	defer stmt.Close()
 
-	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("getAccountTransactions: commit: error=%v rollback=%v", err, tx.Rollback())
+	var transactionID string
+	if err := stmt.QueryRow(append([]interface{}{key}, organizationArgs...)...).Scan(&transactionID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getTransactionByIdempotencyKey: %v", err)
 	}
-	return transactions, nil
+	return r.readTransaction(ctx, r.db, transactionID)
 }
 
-func (r *sqlTransactionRepository) getTransaction(transactionID string) (*transaction, error) {
-	tx, err := r.db.Begin()
+func (r *sqlTransactionRepository) readTransaction(ctx context.Context, db *sql.DB, transactionID string) (*transaction, error) {
+	tx, err := db.Begin()
 	if err != nil {
 		return nil, fmt.Errorf("getTransaction: %v", err)
 	}
-	transaction, err := r.loadTransaction(tx, transactionID)
+	transaction, err := r.loadTransaction(ctx, tx, transactionID)
 	if err != nil {
 		return nil, fmt.Errorf("getTransaction: error=%v rollback=%v", err, tx.Rollback())
 	}
 	return transaction, tx.Commit()
 }
 
-func (r *sqlTransactionRepository) loadTransaction(tx *sql.Tx, transactionID string) (*transaction, error) {
-	query := `select timestamp from transactions where transaction_id = ? and deleted_at is null limit 1;`
+// loadTransaction reads a transaction in ctx's organization, returning an error if it doesn't exist.
+func (r *sqlTransactionRepository) loadTransaction(ctx context.Context, tx *sql.Tx, transactionID string) (*transaction, error) {
+	organization, organizationArgs := organizationFilter(ctx, "organization_id")
+	query := fmt.Sprintf(`select organization_id, timestamp, status, expires_at, idempotency_key, force_post_id, metadata, description from transactions where transaction_id = ? and deleted_at is null%s limit 1;`, organization)
 	stmt, err := tx.Prepare(query)
 	if err != nil {
 		return nil, fmt.Errorf("loadTransaction: timestamp: %v", err)
 	}
+	var organizationID string
 	var timestamp time.Time
-	if err := stmt.QueryRow(transactionID).Scan(&timestamp); err != nil {
+	var status TransactionStatus
+	var expiresAt *time.Time
+	var idempotencyKey, forcePostID, metadata, description *string
+	if err := stmt.QueryRow(append([]interface{}{transactionID}, organizationArgs...)...).Scan(&organizationID, &timestamp, &status, &expiresAt, &idempotencyKey, &forcePostID, &metadata, &description); err != nil {
 		stmt.Close()
 		return nil, fmt.Errorf("loadTransaction: timestamp query: %v", err)
 	}
 	stmt.Close() // close to prevent leaks
 
-	query = `select account_id, purpose, amount from transaction_lines where transaction_id = ? and deleted_at is null`
+	query = `select account_id, purpose, amount, department, product, region, mcc, merchant_country, description from transaction_lines where transaction_id = ? and deleted_at is null
+union all
+select account_id, purpose, amount, department, product, region, mcc, merchant_country, description from transaction_lines_archive where transaction_id = ? and deleted_at is null;`
 	stmt, err = tx.Prepare(query)
 	if err != nil {
 		return nil, fmt.Errorf("loadTransaction: %v", err)
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(transactionID)
+	rows, err := stmt.Query(transactionID, transactionID)
 	if err != nil {
 		return nil, fmt.Errorf("loadTransaction: query: %v", err)
 	}
@@ -222,16 +582,135 @@ func (r *sqlTransactionRepository) loadTransaction(tx *sql.Tx, transactionID str
 	var lines []transactionLine
 	for rows.Next() {
 		var line transactionLine
-		if err := rows.Scan(&line.AccountID, &line.Purpose, &line.Amount); err != nil {
+		var department, product, region, mcc, merchantCountry, description *string
+		if err := rows.Scan(&line.AccountID, &line.Purpose, &line.Amount, &department, &product, &region, &mcc, &merchantCountry, &description); err != nil {
 			return nil, fmt.Errorf("loadTransaction: scan transaction=%q account=%q: %v", transactionID, line.AccountID, err)
 		}
+		if department != nil {
+			line.Department = *department
+		}
+		if product != nil {
+			line.Product = *product
+		}
+		if region != nil {
+			line.Region = *region
+		}
+		if mcc != nil {
+			line.MCC = *mcc
+		}
+		if merchantCountry != nil {
+			line.MerchantCountry = *merchantCountry
+		}
+		if description != nil {
+			line.Description = *description
+		}
 		lines = append(lines, line)
 	}
-	return &transaction{
-		ID:        transactionID,
-		Timestamp: timestamp,
-		Lines:     lines,
-	}, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("loadTransaction: lines: %v", err)
+	}
+	rows.Close()
+
+	out := &transaction{
+		ID:             transactionID,
+		Timestamp:      timestamp,
+		Status:         status,
+		Lines:          lines,
+		ExpiresAt:      expiresAt,
+		OrganizationID: organizationID,
+	}
+	if idempotencyKey != nil {
+		out.IdempotencyKey = *idempotencyKey
+	}
+	if forcePostID != nil {
+		out.ForcePostID = *forcePostID
+	}
+	if description != nil {
+		out.Description = *description
+	}
+	if metadata != nil {
+		if err := json.Unmarshal([]byte(*metadata), &out.Metadata); err != nil {
+			return nil, fmt.Errorf("loadTransaction: transaction=%q metadata: %v", transactionID, err)
+		}
+	}
+	if out.Tags, err = queryStrings(tx, `select tag from transaction_tags where transaction_id = ? order by tag;`, transactionID); err != nil {
+		return nil, fmt.Errorf("loadTransaction: transaction=%q tags: %v", transactionID, err)
+	}
+	return out, nil
+}
+
+// nullableSegment stores empty segment values (and MCCs, merchant countries and descriptions) as null.
+func nullableSegment(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// lineAmount returns how much a transactionLine changes its account's balance by.
+func lineAmount(line transactionLine) int {
+	if line.Purpose == ACHDebit {
+		return -1 * line.Amount
+	}
+	return line.Amount
+}
+
+// addToBalance applies amount onto one randomly chosen stripe of an account's balance.
+func (r *sqlTransactionRepository) addToBalance(tx *sql.Tx, accountID string, amount int) error {
+	if amount == 0 {
+		return nil
+	}
+	stripe := rand.Intn(balanceStripes)
+
+	query := `update account_balances set balance = balance + ?, last_modified = ? where account_id = ? and stripe = ?;`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("addToBalance: prepare: %v", err)
+	}
+	res, err := stmt.Exec(amount, time.Now(), accountID, stripe)
+	stmt.Close()
+	if err != nil {
+		return fmt.Errorf("addToBalance: update: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+
+	// This is the first posting onto this stripe, so create it.
+	query = `insert into account_balances(account_id, stripe, balance, last_modified) values (?, ?, ?, ?);`
+	stmt, err = tx.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("addToBalance: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(accountID, stripe, amount, time.Now()); err != nil {
+		if !database.UniqueViolation(err) {
+			return fmt.Errorf("addToBalance: insert: %v", err)
+		}
+		// Another posting created the stripe first, so add onto it.
+		query = `update account_balances set balance = balance + ?, last_modified = ? where account_id = ? and stripe = ?;`
+		if _, err := tx.Exec(query, amount, time.Now(), accountID, stripe); err != nil {
+			return fmt.Errorf("addToBalance: retry update: %v", err)
+		}
+	}
+	return nil
+}
+
+// lockAccountBalances holds a write lock on every stripe of the accounts' balances until tx finishes, so concurrent
+// postings debiting them wait on ours instead of each passing their checks against balances the other is lowering.
+// The no-op update locks the rows (and the gaps new stripes would be inserted into) on MySQL, while SQLite already
+// locks the whole database once a transaction writes. Accounts are locked in a consistent order so two postings
+// can't deadlock.
+func (r *sqlTransactionRepository) lockAccountBalances(tx *sql.Tx, accountIDs []string) error {
+	sort.Strings(accountIDs)
+	for i := range accountIDs {
+		query := `update account_balances set balance = balance where account_id = ?;`
+		if _, err := tx.Exec(query, accountIDs[i]); err != nil {
+			return fmt.Errorf("lockAccountBalances: account=%q: %v", accountIDs[i], err)
+		}
+	}
+	return nil
 }
 
 func (r *sqlTransactionRepository) getAccountBalance(tx *sql.Tx, accountID string) (int32, error) {
@@ -239,37 +718,340 @@ func (r *sqlTransactionRepository) getAccountBalance(tx *sql.Tx, accountID strin
 		return 0, nil
 	}
 
-	// TODO(adam): At some point we should probably checkpoint balances so we avoid an entire index scan on an account_id
-	query := `select amount, purpose from transaction_lines where account_id = ? and deleted_at is null;`
+	query := `select coalesce(sum(balance), 0) from account_balances where account_id = ?;`
 	stmt, err := tx.Prepare(query)
 	if err != nil {
 		return 0, err
 	}
 	defer stmt.Close()
 
+	var amount int32
+	if err := stmt.QueryRow(accountID).Scan(&amount); err != nil {
+		return 0, fmt.Errorf("problem getting account=%s balance: %v", accountID, err)
+	}
+	return amount, nil
+}
+
+func (r *sqlTransactionRepository) getPendingBalance(tx *sql.Tx, accountID string) (pendingBalance, error) {
+	var out pendingBalance
+	query := `select t.status, l.purpose, l.amount from transaction_lines l
+inner join transactions t on t.transaction_id = l.transaction_id
+where l.account_id = ? and l.deleted_at is null and t.status in ('pending', 'held') and t.deleted_at is null;`
+	stmt, err := tx.Prepare(query)
+	if err != nil {
+		return out, err
+	}
+	defer stmt.Close()
+
 	rows, err := stmt.Query(accountID)
 	if err != nil {
-		return 0, fmt.Errorf("problem querying account=%s balance: %v", accountID, err)
+		return out, fmt.Errorf("problem getting account=%s pending balance: %v", accountID, err)
 	}
 	defer rows.Close()
 
-	var amount int32
 	for rows.Next() {
-		var amt int32
-		var purpose string
-		if err := rows.Scan(&amt, &purpose); err != nil {
-			return 0, fmt.Errorf("error reading amount/purpose: %v", err)
+		var status TransactionStatus
+		var line transactionLine
+		if err := rows.Scan(&status, &line.Purpose, &line.Amount); err != nil {
+			return out, fmt.Errorf("problem scanning account=%s pending balance: %v", accountID, err)
 		}
-		if strings.EqualFold(purpose, "achdebit") {
-			amount -= amt
-		} else {
-			amount += amt
+		out.add(line, status)
+	}
+	return out, rows.Err()
+}
+
+func (r *sqlTransactionRepository) getExpiredHolds(now time.Time) ([]string, error) {
+	query := `select transaction_id from transactions where status = 'held' and expires_at <= ? and deleted_at is null;`
+	rows, err := r.db.Query(query, now.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("getExpiredHolds: query: %v", err)
+	}
+	defer rows.Close()
+
+	var transactionIDs []string
+	for rows.Next() {
+		var transactionID string
+		if err := rows.Scan(&transactionID); err != nil {
+			return nil, fmt.Errorf("getExpiredHolds: scan: %v", err)
 		}
+		transactionIDs = append(transactionIDs, transactionID)
+	}
+	return transactionIDs, rows.Err()
+}
+
+// compactionBatchSize keeps the placeholders of each archive and delete under SQLite's limit
+const compactionBatchSize = 100
+
+// compactTransactionLines rolls up every transaction line created before the cutoff into per-account daily
+// summaries and moves the raw lines into transaction_lines_archive. Balances are kept in account_balances so
+// they're unaffected by compaction.
+func (r *sqlTransactionRepository) compactTransactionLines(before time.Time) (*compactionResult, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("compactTransactionLines: begin: %v", err)
 	}
 
+	// Lines from pending and held transactions are left alone as they could still be posted. Voided and failed transactions
+	// never affected balances so their lines are archived without being summarized. Only the transactions read here are
+	// archived, so one posted after this read is left for the next compaction rather than archived unsummarized.
+	query := `select l.transaction_id, l.account_id, l.purpose, l.amount, l.created_at, l.deleted_at, t.status from transaction_lines l
+left join transactions t on t.transaction_id = l.transaction_id
+where l.created_at < ? and (t.status is null or t.status not in ('pending', 'held'));`
+	rows, err := tx.Query(query, before)
+	if err != nil {
+		return nil, fmt.Errorf("compactTransactionLines: query: error=%v rollback=%v", err, tx.Rollback())
+	}
+	summaries := make(map[dailySummaryKey]*dailySummary)
+	result := &compactionResult{Before: before}
+	var transactionIDs []string
+	read := make(map[string]bool)
+	for rows.Next() {
+		var transactionID string
+		var line transactionLine
+		var createdAt time.Time
+		var deletedAt *time.Time
+		var status *TransactionStatus
+		if err := rows.Scan(&transactionID, &line.AccountID, &line.Purpose, &line.Amount, &createdAt, &deletedAt, &status); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("compactTransactionLines: scan: error=%v rollback=%v", err, tx.Rollback())
+		}
+		if !read[transactionID] {
+			read[transactionID] = true
+			transactionIDs = append(transactionIDs, transactionID)
+		}
+		if deletedAt != nil || status == nil || (*status != TransactionPosted && *status != TransactionReversed) {
+			continue
+		}
+		key := dailySummaryKey{accountID: line.AccountID, day: createdAt.UTC().Format("2006-01-02")}
+		if summaries[key] == nil {
+			summaries[key] = &dailySummary{}
+		}
+		summaries[key].add(line)
+		result.Lines++
+	}
+	rows.Close()
 	if err := rows.Err(); err != nil {
-		return 0, fmt.Errorf("problem getting account=%s balance: %v", accountID, err)
+		return nil, fmt.Errorf("compactTransactionLines: rows: error=%v rollback=%v", err, tx.Rollback())
 	}
 
-	return amount, nil
+	for key, summary := range summaries {
+		query = `update account_daily_summaries set credits = credits + ?, debits = debits + ?, line_count = line_count + ? where account_id = ? and day = ?;`
+		res, err := tx.Exec(query, summary.credits, summary.debits, summary.lines, key.accountID, key.day)
+		if err != nil {
+			return nil, fmt.Errorf("compactTransactionLines: update summary account=%s day=%s: error=%v rollback=%v", key.accountID, key.day, err, tx.Rollback())
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			continue
+		}
+		query = `insert into account_daily_summaries(account_id, day, credits, debits, line_count) values (?, ?, ?, ?, ?);`
+		if _, err := tx.Exec(query, key.accountID, key.day, summary.credits, summary.debits, summary.lines); err != nil {
+			return nil, fmt.Errorf("compactTransactionLines: insert summary account=%s day=%s: error=%v rollback=%v", key.accountID, key.day, err, tx.Rollback())
+		}
+		result.Summaries++
+	}
+
+	archivedAt := time.Now()
+	for len(transactionIDs) > 0 {
+		n := len(transactionIDs)
+		if n > compactionBatchSize {
+			n = compactionBatchSize
+		}
+		args := []interface{}{before}
+		for i := range transactionIDs[:n] {
+			args = append(args, transactionIDs[i])
+		}
+		in := strings.Repeat(", ?", n-1)
+		query = fmt.Sprintf(`insert into transaction_lines_archive(transaction_id, account_id, organization_id, purpose, amount, created_at, deleted_at, archived_at, department, product, region, mcc, merchant_country, description)
+select transaction_id, account_id, organization_id, purpose, amount, created_at, deleted_at, ?, department, product, region, mcc, merchant_country, description from transaction_lines
+where created_at < ? and transaction_id in (?%s);`, in)
+		if _, err := tx.Exec(query, append([]interface{}{archivedAt}, args...)...); err != nil {
+			return nil, fmt.Errorf("compactTransactionLines: archive: error=%v rollback=%v", err, tx.Rollback())
+		}
+		query = fmt.Sprintf(`delete from transaction_lines where created_at < ? and transaction_id in (?%s);`, in)
+		if _, err := tx.Exec(query, args...); err != nil {
+			return nil, fmt.Errorf("compactTransactionLines: delete: error=%v rollback=%v", err, tx.Rollback())
+		}
+		transactionIDs = transactionIDs[n:]
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("compactTransactionLines: commit: %v", err)
+	}
+	return result, nil
+}
+
+// repairAccountBalance recomputes an account's balance from its daily summaries and raw transaction lines, then
+// compares that against the striped balance rows. When repair is true any drift is corrected by collapsing
+// the stripes into one row holding the recomputed balance.
+func (r *sqlTransactionRepository) repairAccountBalance(accountID string, repair bool) (*balanceRepair, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("repairAccountBalance: begin: %v", err)
+	}
+	result := &balanceRepair{AccountID: accountID}
+
+	var summarized, lines int64
+	query := `select coalesce(sum(credits - debits), 0) from account_daily_summaries where account_id = ?;`
+	if err := tx.QueryRow(query, accountID).Scan(&summarized); err != nil {
+		return nil, fmt.Errorf("repairAccountBalance: summaries: error=%v rollback=%v", err, tx.Rollback())
+	}
+	// Held transactions have only applied their debits
+	query = `select coalesce(sum(case when lower(purpose) = 'achdebit' then -amount else amount end), 0) from transaction_lines
+where account_id = ? and deleted_at is null and (transaction_id in (select transaction_id from transactions where status in ('posted', 'reversed'))
+or (lower(purpose) = 'achdebit' and transaction_id in (select transaction_id from transactions where status = 'held')));`
+	if err := tx.QueryRow(query, accountID).Scan(&lines); err != nil {
+		return nil, fmt.Errorf("repairAccountBalance: lines: error=%v rollback=%v", err, tx.Rollback())
+	}
+	result.Expected = summarized + lines
+
+	query = `select coalesce(sum(balance), 0) from account_balances where account_id = ?;`
+	if err := tx.QueryRow(query, accountID).Scan(&result.Actual); err != nil {
+		return nil, fmt.Errorf("repairAccountBalance: balances: error=%v rollback=%v", err, tx.Rollback())
+	}
+	result.Drift = result.Actual - result.Expected
+
+	if repair && result.Drift != 0 {
+		if _, err := tx.Exec(`delete from account_balances where account_id = ?;`, accountID); err != nil {
+			return nil, fmt.Errorf("repairAccountBalance: delete: error=%v rollback=%v", err, tx.Rollback())
+		}
+		query = `insert into account_balances(account_id, stripe, balance, last_modified) values (?, 0, ?, ?);`
+		if _, err := tx.Exec(query, accountID, result.Expected, time.Now()); err != nil {
+			return nil, fmt.Errorf("repairAccountBalance: insert: error=%v rollback=%v", err, tx.Rollback())
+		}
+		result.Repaired = true
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("repairAccountBalance: commit: %v", err)
+	}
+	return result, nil
+}
+
+func (r *sqlTransactionRepository) getSegmentTotals(q segmentTotalsQuery) ([]segmentTotal, error) {
+	if err := q.Segment.validate(); err != nil {
+		return nil, fmt.Errorf("getSegmentTotals: %v", err)
+	}
+	where, args := `t.status in ('posted', 'reversed') and l.deleted_at is null`, []interface{}{}
+	if q.AccountID != "" {
+		where, args = where+` and l.account_id = ?`, append(args, q.AccountID)
+	}
+	if !q.Since.IsZero() {
+		where, args = where+` and t.timestamp >= ?`, append(args, q.Since)
+	}
+	if !q.Until.IsZero() {
+		where, args = where+` and t.timestamp < ?`, append(args, q.Until)
+	}
+
+	// Archived lines are still raw lines, so they're totaled along with current lines
+	var totals []segmentTotal
+	for _, table := range []string{"transaction_lines", "transaction_lines_archive"} {
+		query := fmt.Sprintf(`select coalesce(l.%s, ''),
+  coalesce(sum(case when lower(l.purpose) = 'achdebit' then 0 else l.amount end), 0),
+  coalesce(sum(case when lower(l.purpose) = 'achdebit' then l.amount else 0 end), 0),
+  count(*)
+from %s l inner join transactions t on l.transaction_id = t.transaction_id
+where %s group by coalesce(l.%s, '');`, q.Segment, table, where, q.Segment)
+		rows, err := r.db.Query(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("getSegmentTotals: %s: %v", table, err)
+		}
+		for rows.Next() {
+			var total segmentTotal
+			if err := rows.Scan(&total.Value, &total.Credits, &total.Debits, &total.Lines); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("getSegmentTotals: %s scan: %v", table, err)
+			}
+			totals = append(totals, total)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("getSegmentTotals: %s: %v", table, err)
+		}
+	}
+	return mergeSegmentTotals(totals), nil
+}
+
+func (r *sqlTransactionRepository) getMCCSpend(q mccSpendQuery) ([]mccSpend, error) {
+	where, args := `t.status = 'posted' and l.deleted_at is null and lower(l.purpose) = 'achdebit'`, []interface{}{}
+	if q.AccountID != "" {
+		where, args = where+` and l.account_id = ?`, append(args, q.AccountID)
+	}
+	if !q.Since.IsZero() {
+		where, args = where+` and t.timestamp >= ?`, append(args, q.Since)
+	}
+	if !q.Until.IsZero() {
+		where, args = where+` and t.timestamp < ?`, append(args, q.Until)
+	}
+
+	// A transaction's lines are archived together, so its debits are matched with card lines in the same table
+	var spend []mccSpend
+	for _, table := range []string{"transaction_lines", "transaction_lines_archive"} {
+		query := fmt.Sprintf(`select c.mcc, coalesce(sum(l.amount), 0), count(distinct t.transaction_id)
+from %[1]s l inner join transactions t on l.transaction_id = t.transaction_id
+inner join (select distinct transaction_id, mcc from %[1]s where mcc is not null and deleted_at is null) c on c.transaction_id = l.transaction_id
+where %[2]s group by c.mcc;`, table, where)
+		rows, err := r.db.Query(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("getMCCSpend: %s: %v", table, err)
+		}
+		for rows.Next() {
+			var s mccSpend
+			if err := rows.Scan(&s.MCC, &s.Amount, &s.Transactions); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("getMCCSpend: %s scan: %v", table, err)
+			}
+			spend = append(spend, s)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("getMCCSpend: %s: %v", table, err)
+		}
+	}
+	return mergeMCCSpend(spend), nil
+}
+
+func (r *sqlTransactionRepository) getTrialBalance(q trialBalanceQuery) ([]trialBalanceAccount, error) {
+	where, args := []string{`t.status in ('posted', 'reversed')`, `l.deleted_at is null`}, []interface{}{}
+	if q.AccountID != "" {
+		where, args = append(where, `l.account_id = ?`), append(args, q.AccountID)
+	}
+	if !q.StartDate.IsZero() {
+		where, args = append(where, `t.timestamp >= ?`), append(args, q.StartDate)
+	}
+	if !q.EndDate.IsZero() {
+		where, args = append(where, `t.timestamp < ?`), append(args, q.EndDate)
+	}
+	if q.Purpose != "" {
+		where, args = append(where, `l.purpose = ?`), append(args, q.Purpose)
+	}
+	segmentWhere, segmentArgs := trialBalanceSegmentFilters(q.segments())
+	where, args = append(where, segmentWhere...), append(args, segmentArgs...)
+
+	// Archived lines are still raw lines, so they're totaled along with current lines
+	var totals []trialBalanceAccount
+	for _, table := range []string{"transaction_lines", "transaction_lines_archive"} {
+		query := fmt.Sprintf(`select l.account_id,
+  coalesce(sum(case when lower(l.purpose) = 'achdebit' then l.amount else 0 end), 0),
+  coalesce(sum(case when lower(l.purpose) = 'achdebit' then 0 else l.amount end), 0),
+  count(*)
+from %s l inner join transactions t on l.transaction_id = t.transaction_id
+where %s group by l.account_id;`, table, strings.Join(where, ` and `))
+		rows, err := r.db.Query(query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("getTrialBalance: %s: %v", table, err)
+		}
+		for rows.Next() {
+			var total trialBalanceAccount
+			if err := rows.Scan(&total.AccountID, &total.Debits, &total.Credits, &total.Lines); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("getTrialBalance: %s scan: %v", table, err)
+			}
+			totals = append(totals, total)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("getTrialBalance: %s: %v", table, err)
+		}
+	}
+	return mergeTrialBalance(totals), nil
 }
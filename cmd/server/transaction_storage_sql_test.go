@@ -7,7 +7,10 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -75,11 +78,11 @@ func TestSqlTransactionRepository(t *testing.T) {
 				{AccountID: account2, Purpose: ACHCredit, Amount: 500},
 			},
 		}
-		if err := repo.createTransaction(tx, createTransactionOpts{AllowOverdraft: false}); err != nil {
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: false}); err != nil {
 			t.Fatal(err)
 		}
 
-		transactions, err := repo.getAccountTransactions(account1)
+		transactions, _, err := repo.getAccountTransactions(context.Background(), account1, transactionPage{})
 		if err != nil {
 			t.Error(err)
 		}
@@ -103,7 +106,7 @@ func TestSqlTransactionRepository(t *testing.T) {
 		}
 
 		// Grab our transaction by its ID
-		transaction, err := repo.getTransaction(tx.ID)
+		transaction, err := repo.getTransaction(context.Background(), tx.ID)
 		if err != nil || transaction == nil {
 			t.Fatalf("transaction=%v error=%v", transaction, err)
 		}
@@ -146,7 +149,7 @@ func TestSqlTransactionRepository__Internal(t *testing.T) {
 				{AccountID: account1, Purpose: ACHCredit, Amount: 1000},
 			},
 		}
-		if err := repo.createTransaction(tx, createTransactionOpts{InitialDeposit: true}); err != nil {
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{InitialDeposit: true}); err != nil {
 			t.Fatal(err)
 		}
 		t.Logf("created transaction=%s", tx.ID)
@@ -170,13 +173,13 @@ func TestSqlTransactionRepository__Internal(t *testing.T) {
 			},
 		}
 		// Create the transaction and allow it to overdraft
-		if err := repo.createTransaction(tx, createTransactionOpts{}); err != nil {
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
 			t.Logf("account1=%s account2=%s", account1, account2)
 			t.Fatal(err)
 		}
 		t.Logf("created transaction=%s", tx.ID)
 
-		transactions, err := repo.getAccountTransactions(account1)
+		transactions, _, err := repo.getAccountTransactions(context.Background(), account1, transactionPage{})
 		if err != nil {
 			t.Error(err)
 		}
@@ -248,11 +251,11 @@ func TestSqlTransactionRepository__AllowOverdraft(t *testing.T) {
 			},
 		}
 		// Create the transaction and allow it to overdraft
-		if err := repo.createTransaction(tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
 			t.Fatal(err)
 		}
 
-		transactions, err := repo.getAccountTransactions(account1)
+		transactions, _, err := repo.getAccountTransactions(context.Background(), account1, transactionPage{})
 		if err != nil {
 			t.Error(err)
 		}
@@ -313,13 +316,164 @@ func TestSqlTransactionRepository__DisallowOverdraft(t *testing.T) {
 		}
 
 		// run the transfer without AllowOverdraft to encounter 'has insufficient funds' error
-		if err := repo.createTransaction(tx, createTransactionOpts{AllowOverdraft: false}); err == nil {
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: false}); err == nil {
 			t.Error("expected error")
 		} else {
-			if !strings.Contains(err.Error(), "has insufficient funds") {
+			if !errors.Is(err, errInsufficientFunds) {
 				t.Errorf("unknown error: %v", err)
 			}
 		}
+
+		// only debited accounts are checked, so crediting an overdrawn account is allowed
+		overdraft := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Lines: []transactionLine{
+				{AccountID: account2, Purpose: ACHDebit, Amount: 500},
+				{AccountID: account1, Purpose: ACHCredit, Amount: 500},
+			},
+		}
+		if err := repo.createTransaction(context.Background(), overdraft, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Fatal(err)
+		}
+		deposit := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Lines:     []transactionLine{{AccountID: account1, Purpose: ACHCredit, Amount: 1000}},
+		}
+		if err := repo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+		tx.ID, tx.Lines[0].Amount, tx.Lines[1].Amount = base.ID(), 100, 100
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+			t.Errorf("crediting an overdrawn account: %v", err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+// TestSqlTransactionRepository__OverdraftLimit overdraws an account down to its overdraft limit
+func TestSqlTransactionRepository__OverdraftLimit(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		account1, account2 := base.ID(), base.ID()
+		repo.accountRepo = &testAccountRepository{
+			accounts: []*accounts.Account{
+				{ID: account1, AccountNumber: "123", RoutingNumber: defaultRoutingNumber, OverdraftLimit: 1000},
+				{ID: account2, AccountNumber: "432", RoutingNumber: defaultRoutingNumber},
+			},
+		}
+		transfer := func(from, to string, amount int) error {
+			tx := transaction{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Lines: []transactionLine{
+					{AccountID: from, Purpose: ACHDebit, Amount: amount},
+					{AccountID: to, Purpose: ACHCredit, Amount: amount},
+				},
+			}
+			return repo.createTransaction(context.Background(), tx, createTransactionOpts{})
+		}
+		if err := transfer(account1, account2, 600); err != nil {
+			t.Fatal(err)
+		}
+		if err := transfer(account1, account2, 400); err != nil {
+			t.Fatal(err)
+		}
+		if err := transfer(account1, account2, 1); err == nil || !strings.Contains(err.Error(), "has insufficient funds") {
+			t.Errorf("unexpected error: %v", err)
+		}
+		// credits to an overdrawn account are posted
+		if err := transfer(account2, account1, 200); err != nil {
+			t.Fatal(err)
+		}
+
+		dbtx, _ := repo.db.Begin()
+		defer dbtx.Rollback()
+
+		if bal, err := repo.getAccountBalance(dbtx, account1); err != nil || bal != -800 {
+			t.Errorf("balance=%d error=%v", bal, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestSqlTransactionRepository__concurrentOverdraft(t *testing.T) {
+	stripes := balanceStripes
+	balanceStripes = 4
+	defer func() { balanceStripes = stripes }()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		account1, account2 := base.ID(), base.ID()
+		repo.accountRepo = &testAccountRepository{
+			accounts: []*accounts.Account{
+				{ID: account1, AccountNumber: "123", RoutingNumber: defaultRoutingNumber},
+				{ID: account2, AccountNumber: "432", RoutingNumber: defaultRoutingNumber},
+			},
+		}
+		deposit := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Lines:     []transactionLine{{AccountID: account1, Purpose: ACHCredit, Amount: 1000}},
+		}
+		if err := repo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Race more debits than the balance covers, some may fail from lock contention rather than
+		// insufficient funds but none can overdraw the account.
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		posted := 0
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				tx := transaction{
+					ID:        base.ID(),
+					Timestamp: time.Now(),
+					Lines: []transactionLine{
+						{AccountID: account1, Purpose: ACHDebit, Amount: 100},
+						{AccountID: account2, Purpose: ACHCredit, Amount: 100},
+					},
+				}
+				if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{}); err == nil {
+					mu.Lock()
+					posted++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		dbtx, _ := repo.db.Begin()
+		defer dbtx.Rollback()
+		balance, err := repo.getAccountBalance(dbtx, account1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance <= 0 || posted > 9 || balance != int32(1000-100*posted) {
+			t.Errorf("balance=%d after %d debits posted", balance, posted)
+		}
 	}
 
 	sqliteDB := database.CreateTestSqliteDB(t)
@@ -382,7 +536,7 @@ func TestSqlTransactions_unique(t *testing.T) {
 		}
 
 		// Attempt our (invalid) transaction
-		if err := repo.createTransaction(tx, createTransactionOpts{AllowOverdraft: true}); err == nil {
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err == nil {
 			t.Fatal("expected error")
 		} else {
 			if !database.UniqueViolation(err) {
@@ -399,3 +553,626 @@ func TestSqlTransactions_unique(t *testing.T) {
 	defer mysqlDB.Close()
 	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
 }
+
+func TestSqlTransactions_duplicateID(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		account1, account2 := base.ID(), base.ID()
+		tx := transaction{
+			ID:        "1c4d2e3f-aaaa-4bbb-8ccc-0123456789ab",
+			Timestamp: time.Now(),
+			Lines: []transactionLine{
+				{AccountID: account1, Purpose: ACHDebit, Amount: 500},
+				{AccountID: account2, Purpose: ACHCredit, Amount: 500},
+			},
+		}
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err == nil {
+			t.Fatal("expected error")
+		} else if !strings.Contains(err.Error(), errDuplicateTransactionID.Error()) {
+			t.Errorf("unexpected error: %v", err)
+		}
+
+		// the original transaction is still found
+		if found, err := repo.getTransaction(context.Background(), tx.ID); err != nil || found.ID != tx.ID {
+			t.Errorf("transaction=%#v error=%v", found, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestSqlTransactions_idempotencyKey(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		create := func(key string) (transaction, error) {
+			tx := transaction{
+				ID:             base.ID(),
+				Timestamp:      time.Now(),
+				IdempotencyKey: key,
+				Lines: []transactionLine{
+					{AccountID: base.ID(), Purpose: ACHDebit, Amount: 500},
+					{AccountID: base.ID(), Purpose: ACHCredit, Amount: 500},
+				},
+			}
+			return tx, repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true})
+		}
+
+		key := base.ID()
+		tx, err := create(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := create(key); err == nil {
+			t.Error("expected error for duplicate idempotency key")
+		}
+		// transactions without a key don't conflict
+		for i := 0; i < 2; i++ {
+			if _, err := create(""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		found, err := repo.getTransactionByIdempotencyKey(context.Background(), key)
+		if err != nil || found == nil {
+			t.Fatalf("transaction=%#v error=%v", found, err)
+		}
+		if found.ID != tx.ID || found.IdempotencyKey != key || len(found.Lines) != 2 {
+			t.Errorf("unexpected transaction: %#v", found)
+		}
+		if found, err := repo.getTransactionByIdempotencyKey(context.Background(), base.ID()); err != nil || found != nil {
+			t.Errorf("transaction=%#v error=%v", found, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestSqlTransactions_forcePostID(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		tx := transaction{
+			ID:          base.ID(),
+			Timestamp:   time.Now(),
+			ForcePostID: base.ID(),
+			Lines: []transactionLine{
+				{AccountID: base.ID(), Purpose: ACHDebit, Amount: 500},
+				{AccountID: base.ID(), Purpose: ACHCredit, Amount: 500},
+			},
+		}
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Fatal(err)
+		}
+		found, err := repo.getTransaction(context.Background(), tx.ID)
+		if err != nil || found == nil {
+			t.Fatalf("transaction=%#v error=%v", found, err)
+		}
+		if found.ForcePostID != tx.ForcePostID {
+			t.Errorf("unexpected transaction: %#v", found)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestSqlTransactions_description(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		account1, account2 := base.ID(), base.ID()
+		repo.accountRepo = &testAccountRepository{
+			accounts: []*accounts.Account{
+				{ID: account1, RoutingNumber: "121042882"},
+				{ID: account2, RoutingNumber: defaultRoutingNumber},
+			},
+		}
+		tx := transaction{
+			ID:          base.ID(),
+			Timestamp:   time.Now(),
+			Description: "Invoice 1234",
+			Lines: []transactionLine{
+				{AccountID: account1, Purpose: ACHDebit, Amount: 500, Description: "Payment to Acme Corp"},
+				{AccountID: account2, Purpose: ACHCredit, Amount: 500},
+			},
+		}
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		verify := func() {
+			t.Helper()
+			found, err := repo.getTransaction(context.Background(), tx.ID)
+			if err != nil || found == nil || len(found.Lines) != 2 {
+				t.Fatalf("transaction=%#v error=%v", found, err)
+			}
+			if found.Description != tx.Description {
+				t.Errorf("description=%q", found.Description)
+			}
+			for _, line := range found.Lines {
+				if (line.AccountID == account1 && line.Description != "Payment to Acme Corp") || (line.AccountID == account2 && line.Description != "") {
+					t.Errorf("unexpected line: %#v", line)
+				}
+			}
+		}
+		verify()
+
+		// Descriptions are kept when lines are archived
+		if _, err := repo.compactTransactionLines(time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		verify()
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestSqlTransactionRepository__balanceStripes(t *testing.T) {
+	stripes := balanceStripes
+	balanceStripes = 4
+	defer func() { balanceStripes = stripes }()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		accountID := base.ID()
+		repo.accountRepo = &testAccountRepository{
+			accounts: []*accounts.Account{{ID: accountID, RoutingNumber: defaultRoutingNumber}},
+		}
+		for i := 0; i < 20; i++ {
+			tx := transaction{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Lines:     []transactionLine{{AccountID: accountID, Purpose: ACHCredit, Amount: 1000}},
+			}
+			if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{InitialDeposit: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var rows int
+		if err := repo.db.QueryRow(`select count(*) from account_balances where account_id = ?`, accountID).Scan(&rows); err != nil {
+			t.Fatal(err)
+		}
+		if rows < 2 || rows > 4 {
+			t.Errorf("got %d stripes", rows)
+		}
+
+		dbtx, _ := repo.db.Begin()
+		defer dbtx.Rollback()
+		if bal, err := repo.getAccountBalance(dbtx, accountID); err != nil || bal != 20000 {
+			t.Errorf("balance=%d error=%v", bal, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestSqlTransactionRepository__compactTransactionLines(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		account1, account2 := base.ID(), base.ID()
+		repo.accountRepo = &testAccountRepository{
+			accounts: []*accounts.Account{
+				{ID: account1, RoutingNumber: "121042882"},
+				{ID: account2, RoutingNumber: defaultRoutingNumber},
+			},
+		}
+		tx := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Lines: []transactionLine{
+				{AccountID: account1, Purpose: ACHDebit, Amount: 500},
+				{AccountID: account2, Purpose: ACHCredit, Amount: 500},
+			},
+		}
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		pending, voided := tx, tx
+		pending.ID, pending.Status = base.ID(), TransactionPending
+		voided.ID, voided.Status = base.ID(), TransactionPending
+		for _, created := range []transaction{pending, voided} {
+			if err := repo.createTransaction(context.Background(), created, createTransactionOpts{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := repo.updateTransactionStatus(voided.ID, TransactionVoided); err != nil {
+			t.Fatal(err)
+		}
+
+		// pending lines are left for a later compaction and voided lines are archived without being summarized
+		result, err := repo.compactTransactionLines(time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Lines != 2 || result.Summaries != 2 {
+			t.Errorf("lines=%d summaries=%d", result.Lines, result.Summaries)
+		}
+		var hot int
+		if err := repo.db.QueryRow(`select count(*) from transaction_lines where transaction_id = ?`, pending.ID).Scan(&hot); err != nil || hot != 2 {
+			t.Errorf("found %d pending lines: %v", hot, err)
+		}
+		if err := repo.updateTransactionStatus(pending.ID, TransactionPosted); err != nil {
+			t.Fatal(err)
+		}
+		if result, err = repo.compactTransactionLines(time.Now().Add(time.Minute)); err != nil || result.Lines != 2 {
+			t.Errorf("result=%#v error=%v", result, err)
+		}
+
+		var credits, debits, count int
+		query := `select credits, debits, line_count from account_daily_summaries where account_id = ?`
+		if err := repo.db.QueryRow(query, account1).Scan(&credits, &debits, &count); err != nil {
+			t.Fatal(err)
+		}
+		if credits != 0 || debits != 1000 || count != 2 {
+			t.Errorf("credits=%d debits=%d count=%d", credits, debits, count)
+		}
+		if err := repo.db.QueryRow(`select count(*) from transaction_lines`).Scan(&count); err != nil || count != 0 {
+			t.Errorf("found %d hot lines: %v", count, err)
+		}
+
+		// Archived transactions are still readable
+		found, err := repo.getTransaction(context.Background(), tx.ID)
+		if err != nil || len(found.Lines) != 2 {
+			t.Fatalf("transaction=%#v error=%v", found, err)
+		}
+		transactions, _, err := repo.getAccountTransactions(context.Background(), account2, transactionPage{})
+		if err != nil || len(transactions) != 3 {
+			t.Fatalf("transactions=%#v error=%v", transactions, err)
+		}
+
+		// Balances are unchanged
+		dbtx, _ := repo.db.Begin()
+		defer dbtx.Rollback()
+		if bal, err := repo.getAccountBalance(dbtx, account2); err != nil || bal != 1000 {
+			t.Errorf("balance=%d error=%v", bal, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestSqlTransactionRepository__updateTransactionStatus(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		account1, account2 := base.ID(), base.ID()
+		repo.accountRepo = &testAccountRepository{
+			accounts: []*accounts.Account{
+				{ID: account1, AccountNumber: "123", RoutingNumber: defaultRoutingNumber},
+				{ID: account2, AccountNumber: "432", RoutingNumber: "121042882"},
+			},
+		}
+
+		tx := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Status:    TransactionPending,
+			Lines: []transactionLine{
+				{AccountID: account1, Purpose: ACHDebit, Amount: 500},
+				{AccountID: account2, Purpose: ACHCredit, Amount: 500},
+			},
+		}
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Fatal(err)
+		}
+		balance := func(accountID string) int32 {
+			dbtx, _ := repo.db.Begin()
+			defer dbtx.Rollback()
+			bal, err := repo.getAccountBalance(dbtx, accountID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return bal
+		}
+		if bal := balance(account2); bal != 0 {
+			t.Errorf("pending transaction changed balance to %d", bal)
+		}
+
+		// account1 has no funds, so posting should be rejected and leave the transaction pending
+		if err := repo.updateTransactionStatus(tx.ID, TransactionPosted); err == nil || !strings.Contains(err.Error(), "insufficient funds") {
+			t.Errorf("expected insufficient funds: %v", err)
+		}
+		if found, err := repo.getTransaction(context.Background(), tx.ID); err != nil || found.Status != TransactionPending {
+			t.Errorf("transaction=%#v error=%v", found, err)
+		}
+
+		// fund account1 and post
+		deposit := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Lines:     []transactionLine{{AccountID: account1, Purpose: ACHCredit, Amount: 2000}},
+		}
+		if err := repo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.updateTransactionStatus(tx.ID, TransactionPosted); err != nil {
+			t.Fatal(err)
+		}
+		if bal := balance(account1); bal != 1500 {
+			t.Errorf("got balance of %d", bal)
+		}
+		if bal := balance(account2); bal != 500 {
+			t.Errorf("got balance of %d", bal)
+		}
+
+		// posted transactions can't be voided
+		if err := repo.updateTransactionStatus(tx.ID, TransactionVoided); err == nil {
+			t.Error("expected error")
+		}
+		if err := repo.updateTransactionStatus(tx.ID, TransactionReversed); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := repo.getTransaction(context.Background(), tx.ID); err != nil || found.Status != TransactionReversed {
+			t.Errorf("transaction=%#v error=%v", found, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestSqlTransactionRepository__reverses(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		defer repo.Close()
+
+		account1, account2 := base.ID(), base.ID()
+		repo.accountRepo = &testAccountRepository{
+			accounts: []*accounts.Account{
+				{ID: account1, AccountNumber: "123", RoutingNumber: defaultRoutingNumber},
+				{ID: account2, AccountNumber: "432", RoutingNumber: "121042882"},
+			},
+		}
+		transfer := func(debit, credit string) transaction {
+			return transaction{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Lines: []transactionLine{
+					{AccountID: debit, Purpose: ACHDebit, Amount: 500},
+					{AccountID: credit, Purpose: ACHCredit, Amount: 500},
+				},
+			}
+		}
+		original := transfer(account1, account2)
+		if err := repo.createTransaction(context.Background(), original, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.createTransaction(context.Background(), transfer(account2, account1), createTransactionOpts{Reversal: true, Reverses: original.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if found, err := repo.getTransaction(context.Background(), original.ID); err != nil || found.Status != TransactionReversed {
+			t.Errorf("transaction=%#v error=%v", found, err)
+		}
+
+		// a second reversal fails without posting its lines
+		second := transfer(account2, account1)
+		if err := repo.createTransaction(context.Background(), second, createTransactionOpts{Reversal: true, Reverses: original.ID}); err == nil {
+			t.Error("expected error reversing twice")
+		}
+		if found, err := repo.getTransaction(context.Background(), second.ID); err == nil || found != nil {
+			t.Errorf("transaction=%#v error=%v", found, err)
+		}
+		dbtx, _ := repo.db.Begin()
+		defer dbtx.Rollback()
+		if bal, err := repo.getAccountBalance(dbtx, account2); err != nil || bal != 0 {
+			t.Errorf("balance=%d error=%v", bal, err)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
+
+func TestSqlTransactions__getAccountTransactionsPages(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, db *sql.DB) {
+		repo := createTestSqlTransactionRepository(t, db)
+		defer repo.Close()
+
+		accountID := base.ID()
+		var created []string
+		for i := 0; i < 5; i++ {
+			tx := transaction{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Status:    TransactionPosted,
+				Lines: []transactionLine{
+					{AccountID: accountID, Purpose: ACHDebit, Amount: 100},
+					{AccountID: base.ID(), Purpose: ACHCredit, Amount: 100},
+				},
+			}
+			if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+				t.Fatal(err)
+			}
+			created = append(created, tx.ID)
+		}
+		// the two oldest lines are archived and two others share a created_at
+		old := time.Now().Add(-72 * time.Hour)
+		if _, err := db.Exec(`update transaction_lines set created_at = ? where transaction_id in (?, ?)`, old, created[0], created[1]); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.compactTransactionLines(time.Now().Add(-24 * time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`update transaction_lines set created_at = ? where transaction_id in (?, ?)`, time.Now(), created[2], created[3]); err != nil {
+			t.Fatal(err)
+		}
+
+		var seen []string
+		page := transactionPage{Limit: 2}
+		for i := 0; i < 5; i++ {
+			transactions, next, err := repo.getAccountTransactions(context.Background(), accountID, page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for j := range transactions {
+				seen = append(seen, transactions[j].ID)
+			}
+			if next == "" {
+				break
+			}
+			page.Cursor = next
+		}
+		// the retimed lines are now the newest, the archived lines the oldest
+		if len(seen) != 5 || seen[2] != created[4] || seen[0] != created[2] && seen[0] != created[3] || seen[4] != created[0] && seen[4] != created[1] {
+			t.Errorf("unexpected pages: %v created=%v", seen, created)
+		}
+		unique := make(map[string]bool)
+		for i := range seen {
+			unique[seen[i]] = true
+		}
+		if len(unique) != 5 {
+			t.Errorf("transactions were repeated: %v", seen)
+		}
+
+		if _, _, err := repo.getAccountTransactions(context.Background(), accountID, transactionPage{Cursor: base.ID()}); err == nil {
+			t.Error("expected error")
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, sqliteDB.DB)
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, mysqlDB.DB)
+}
+
+func TestTransactions__getAccountTransactionsFilters(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo transactionRepository) {
+		accountID := base.ID()
+		start := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+		post := func(days int, purpose TransactionPurpose, amount int) string {
+			t.Helper()
+			other := ACHCredit
+			if purpose != ACHDebit {
+				other = ACHDebit
+			}
+			tx := transaction{
+				ID:        base.ID(),
+				Timestamp: start.AddDate(0, 0, days),
+				Status:    TransactionPosted,
+				Lines: []transactionLine{
+					{AccountID: accountID, Purpose: purpose, Amount: amount},
+					{AccountID: base.ID(), Purpose: other, Amount: amount},
+				},
+			}
+			if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+				t.Fatal(err)
+			}
+			return tx.ID
+		}
+		early := post(0, ACHCredit, 100)
+		fee := post(1, Fee, 25)
+		debit := post(2, ACHDebit, 5000)
+		late := post(3, ACHCredit, 700)
+
+		cases := []struct {
+			page     transactionPage
+			expected []string
+		}{
+			{transactionPage{}, []string{late, debit, fee, early}},
+			{transactionPage{StartDate: start.AddDate(0, 0, 1), EndDate: start.AddDate(0, 0, 3)}, []string{debit, fee}},
+			{transactionPage{Purpose: ACHCredit}, []string{late, early}},
+			{transactionPage{MinAmount: 100, MaxAmount: 700}, []string{late, early}},
+			{transactionPage{Purpose: ACHCredit, MinAmount: 200, Limit: 1}, []string{late}},
+			{transactionPage{Limit: 1, Cursor: debit, MaxAmount: 100}, []string{fee}},
+		}
+		for i := range cases {
+			transactions, next, err := repo.getAccountTransactions(context.Background(), accountID, cases[i].page)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for j := range transactions {
+				ids = append(ids, transactions[j].ID)
+			}
+			if !reflect.DeepEqual(ids, cases[i].expected) {
+				t.Errorf("%d: got %v expected %v", i, ids, cases[i].expected)
+			}
+			if i == 5 && next != fee || i == 4 && next != "" {
+				t.Errorf("%d: unexpected next=%q", i, next)
+			}
+		}
+	}
+
+	_, memoryRepo := newInMemoryRepositories()
+	check(t, memoryRepo)
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// eachAccountTransactionPage calls fn with each page of the account's transactions matching page, newest first,
+// starting after page.Cursor. Pages of maxAccountTransactionsLimit transactions are read by following the cursor,
+// so exports and jobs which walk an account's whole history only hold one page in memory rather than every line
+// of the account.
+func eachAccountTransactionPage(ctx context.Context, repo transactionRepository, accountID string, page transactionPage, fn func(transactions []transaction) error) error {
+	page.Limit = maxAccountTransactionsLimit
+	for {
+		transactions, next, err := repo.getAccountTransactions(ctx, accountID, page)
+		if err != nil {
+			return err
+		}
+		if len(transactions) > 0 {
+			if err := fn(transactions); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		page.Cursor = next
+	}
+}
+
+// writeAccountTransactionsNDJSON writes each of the account's transactions matching page as a line of JSON, newest
+// first, calling flush after every page so clients can read a large history as it's scanned.
+func writeAccountTransactionsNDJSON(ctx context.Context, w io.Writer, flush func(), svc *transactionService, accountID string, page transactionPage) error {
+	if accountID == "" {
+		return errNoAccountID
+	}
+	enc := json.NewEncoder(w)
+	return eachAccountTransactionPage(ctx, svc.repo, accountID, page, func(transactions []transaction) error {
+		for i := range transactions {
+			if err := enc.Encode(transactions[i]); err != nil {
+				return err
+			}
+		}
+		if flush != nil {
+			flush()
+		}
+		return nil
+	})
+}
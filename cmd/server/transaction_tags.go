@@ -0,0 +1,82 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Transactions can be labeled with metadata (string keys and values) and tags by their caller, such as the order or
+// batch IDs they belong to. Both are stored with the transaction and returned with it, and an account's
+// transactions can be filtered by tag.
+
+const (
+	maxMetadataKeys        = 50
+	maxMetadataKeyLength   = 40
+	maxMetadataValueLength = 500
+
+	maxTags      = 20
+	maxTagLength = 64
+)
+
+func validateMetadata(metadata map[string]string, tags []string) error {
+	if len(metadata) > maxMetadataKeys {
+		return fmt.Errorf("metadata has %d keys, at most %d are allowed", len(metadata), maxMetadataKeys)
+	}
+	for k, v := range metadata {
+		if k == "" || len(k) > maxMetadataKeyLength {
+			return fmt.Errorf("metadata key %q must be 1 to %d characters", k, maxMetadataKeyLength)
+		}
+		if len(v) > maxMetadataValueLength {
+			return fmt.Errorf("metadata %q value is longer than %d characters", k, maxMetadataValueLength)
+		}
+	}
+	if len(tags) > maxTags {
+		return fmt.Errorf("%d tags given, at most %d are allowed", len(tags), maxTags)
+	}
+	for _, tag := range tags {
+		if tag == "" || len(tag) > maxTagLength {
+			return fmt.Errorf("tag %q must be 1 to %d characters", tag, maxTagLength)
+		}
+	}
+	return nil
+}
+
+// uniqueTags trims tags, drops repeated ones and sorts them.
+func uniqueTags(tags []string) []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	seen := make(map[string]bool)
+	out := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if !seen[tag] {
+			seen[tag] = true
+			out = append(out, tag)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// hasTags returns true if the transaction has every tag.
+func (t *transaction) hasTags(tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for i := range t.Tags {
+			if t.Tags[i] == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
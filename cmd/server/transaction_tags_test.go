@@ -0,0 +1,122 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+)
+
+func TestTransactionTags__validate(t *testing.T) {
+	tooMany := make(map[string]string)
+	for i := 0; i <= maxMetadataKeys; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = "v"
+	}
+	cases := map[string]struct {
+		metadata map[string]string
+		tags     []string
+	}{
+		"at most 50 are allowed": {metadata: tooMany},
+		"must be 1 to 40":        {metadata: map[string]string{"": "v"}},
+		"longer than 500":        {metadata: map[string]string{"note": strings.Repeat("a", 501)}},
+		"at most 20 are allowed": {tags: strings.Split(strings.Repeat("a,", 21), ",")},
+		"must be 1 to 64":        {tags: []string{strings.Repeat("a", 65)}},
+		`tag "" must be 1 to 64`: {tags: []string{""}},
+	}
+	for expected, c := range cases {
+		if err := validateMetadata(c.metadata, c.tags); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q error: %v", expected, err)
+		}
+	}
+	if err := validateMetadata(map[string]string{"orderId": "1234"}, []string{"batch-1"}); err != nil {
+		t.Error(err)
+	}
+
+	req := createTransactionRequest{Tags: []string{" order-1", "batch-2", "order-1 "}}
+	if tx := req.asTransaction(base.ID()); !reflect.DeepEqual(tx.Tags, []string{"batch-2", "order-1"}) {
+		t.Errorf("tags=%#v", tx.Tags)
+	}
+}
+
+func TestTransactionTags__repositories(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo transactionRepository) {
+		accountID := base.ID()
+		post := func(metadata map[string]string, tags ...string) string {
+			t.Helper()
+			tx := transaction{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Status:    TransactionPosted,
+				Metadata:  metadata,
+				Tags:      tags,
+				Lines: []transactionLine{
+					{AccountID: accountID, Purpose: ACHDebit, Amount: 100},
+					{AccountID: base.ID(), Purpose: ACHCredit, Amount: 100},
+				},
+			}
+			if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+				t.Fatal(err)
+			}
+			return tx.ID
+		}
+		order := post(map[string]string{"orderId": "1234", "channel": "web"}, "batch-1", "order-1234")
+		batch := post(nil, "batch-1")
+		untagged := post(nil)
+
+		found, err := repo.getTransaction(context.Background(), order)
+		if err != nil || found == nil {
+			t.Fatalf("transaction=%#v error=%v", found, err)
+		}
+		if !reflect.DeepEqual(found.Metadata, map[string]string{"orderId": "1234", "channel": "web"}) || !reflect.DeepEqual(found.Tags, []string{"batch-1", "order-1234"}) {
+			t.Errorf("metadata=%#v tags=%#v", found.Metadata, found.Tags)
+		}
+		if found, err := repo.getTransaction(context.Background(), untagged); err != nil || found.Metadata != nil || len(found.Tags) != 0 {
+			t.Errorf("transaction=%#v error=%v", found, err)
+		}
+
+		cases := map[string][]string{
+			"batch-1":            {batch, order},
+			"order-1234":         {order},
+			"batch-1,order-1234": {order},
+			"missing":            nil,
+		}
+		for tags, expected := range cases {
+			transactions, _, err := repo.getAccountTransactions(context.Background(), accountID, transactionPage{Tags: strings.Split(tags, ",")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for i := range transactions {
+				ids = append(ids, transactions[i].ID)
+			}
+			sort.Strings(ids)
+			sort.Strings(expected)
+			if !reflect.DeepEqual(ids, expected) {
+				t.Errorf("%s: got %v expected %v", tags, ids, expected)
+			}
+		}
+	}
+
+	_, memoryRepo := newInMemoryRepositories()
+	check(t, memoryRepo)
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, createTestSqlTransactionRepository(t, sqliteDB.DB))
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, createTestSqlTransactionRepository(t, mysqlDB.DB))
+}
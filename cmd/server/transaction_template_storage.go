@@ -0,0 +1,156 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+)
+
+type transactionTemplateRepository interface {
+	Ping() error
+	Close() error
+
+	// createTemplate returns errTemplateExists if a template already has the name.
+	createTemplate(template *transactionTemplate) error
+
+	// updateTemplate replaces a template's description and lines, returning false if it doesn't exist.
+	updateTemplate(template *transactionTemplate) (bool, error)
+
+	// deleteTemplate returns false if the template doesn't exist.
+	deleteTemplate(name string) (bool, error)
+
+	// getTemplate returns nil if the template doesn't exist.
+	getTemplate(name string) (*transactionTemplate, error)
+
+	// getTemplates returns every template ordered by name.
+	getTemplates() ([]*transactionTemplate, error)
+}
+
+type sqlTransactionTemplateRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlTransactionTemplateRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlTransactionTemplateRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlTransactionTemplateRepository) createTemplate(template *transactionTemplate) error {
+	lines, err := json.Marshal(template.Lines)
+	if err != nil {
+		return fmt.Errorf("createTemplate: template=%s: %v", template.Name, err)
+	}
+
+	query := `insert into transaction_templates (name, description, template_lines, created_at, last_modified) values (?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createTemplate: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(template.Name, template.Description, string(lines), template.CreatedAt, template.LastModified); err != nil {
+		if database.UniqueViolation(err) {
+			return errTemplateExists
+		}
+		return fmt.Errorf("createTemplate: template=%s: %v", template.Name, err)
+	}
+	return nil
+}
+
+func (r *sqlTransactionTemplateRepository) updateTemplate(template *transactionTemplate) (bool, error) {
+	lines, err := json.Marshal(template.Lines)
+	if err != nil {
+		return false, fmt.Errorf("updateTemplate: template=%s: %v", template.Name, err)
+	}
+
+	query := `update transaction_templates set description = ?, template_lines = ?, last_modified = ? where name = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, fmt.Errorf("updateTemplate: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(template.Description, string(lines), template.LastModified, template.Name)
+	if err != nil {
+		return false, fmt.Errorf("updateTemplate: template=%s: %v", template.Name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("updateTemplate: template=%s: %v", template.Name, err)
+	}
+	return n == 1, nil
+}
+
+func (r *sqlTransactionTemplateRepository) deleteTemplate(name string) (bool, error) {
+	query := `delete from transaction_templates where name = ?;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return false, fmt.Errorf("deleteTemplate: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	res, err := stmt.Exec(name)
+	if err != nil {
+		return false, fmt.Errorf("deleteTemplate: template=%s: %v", name, err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("deleteTemplate: template=%s: %v", name, err)
+	}
+	return n == 1, nil
+}
+
+func (r *sqlTransactionTemplateRepository) getTemplate(name string) (*transactionTemplate, error) {
+	templates, err := r.queryTemplates(`where name = ?`, name)
+	if err != nil {
+		return nil, fmt.Errorf("getTemplate: %v", err)
+	}
+	if len(templates) == 0 {
+		return nil, nil
+	}
+	return templates[0], nil
+}
+
+func (r *sqlTransactionTemplateRepository) getTemplates() ([]*transactionTemplate, error) {
+	templates, err := r.queryTemplates(`order by name`)
+	if err != nil {
+		return nil, fmt.Errorf("getTemplates: %v", err)
+	}
+	return templates, nil
+}
+
+func (r *sqlTransactionTemplateRepository) queryTemplates(where string, args ...interface{}) ([]*transactionTemplate, error) {
+	query := fmt.Sprintf(`select name, description, template_lines, created_at, last_modified from transaction_templates %s;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*transactionTemplate
+	for rows.Next() {
+		var template transactionTemplate
+		var lines string
+		if err := rows.Scan(&template.Name, &template.Description, &lines, &template.CreatedAt, &template.LastModified); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		if err := json.Unmarshal([]byte(lines), &template.Lines); err != nil {
+			return nil, fmt.Errorf("template=%s lines: %v", template.Name, err)
+		}
+		template.Parameters = template.parameters()
+		out = append(out, &template)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,414 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Transaction templates save the lines of recurring manual entries (such as month-end journal entries) under a name.
+// Any line's accountId, purpose or amount can be a {{parameter}} placeholder which is filled in when a transaction is
+// posted from the template, so the same template can post each month's amounts.
+var (
+	templateNameRegex        = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,63}$`)
+	templatePlaceholderRegex = regexp.MustCompile(`^\{\{\s*([a-zA-Z][a-zA-Z0-9_]*)\s*\}\}$`)
+
+	errTemplateExists = errors.New("transaction template already exists")
+)
+
+// templateValue is a template field or parameter, which can be written as a JSON string or number.
+type templateValue string
+
+func (v *templateValue) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*v = templateValue(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return fmt.Errorf("template value %s must be a string or number", b)
+	}
+	*v = templateValue(n.String())
+	return nil
+}
+
+// placeholder returns the parameter name of a {{parameter}} value, or an empty string for literal values.
+func (v templateValue) placeholder() string {
+	if m := templatePlaceholderRegex.FindStringSubmatch(strings.TrimSpace(string(v))); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+type transactionTemplateLine struct {
+	AccountID templateValue `json:"accountId"`
+	Purpose   templateValue `json:"purpose"`
+	Amount    templateValue `json:"amount"`
+}
+
+func (line transactionTemplateLine) values() []templateValue {
+	return []templateValue{line.AccountID, line.Purpose, line.Amount}
+}
+
+type transactionTemplate struct {
+	Name        string                    `json:"name"`
+	Description string                    `json:"description,omitempty"`
+	Lines       []transactionTemplateLine `json:"lines"`
+
+	// Parameters are the placeholders in the template's lines, which are required to post from it.
+	Parameters []string `json:"parameters"`
+
+	CreatedAt    time.Time `json:"createdAt"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+func (t *transactionTemplate) validate() error {
+	if !templateNameRegex.MatchString(t.Name) {
+		return fmt.Errorf("invalid transaction template name %q", t.Name)
+	}
+	if len(t.Lines) == 0 {
+		return fmt.Errorf("transaction template=%s has no lines", t.Name)
+	}
+	for i, line := range t.Lines {
+		for _, v := range line.values() {
+			if v.placeholder() == "" && strings.Contains(string(v), "{{") {
+				return fmt.Errorf("transaction template=%s line[%d] has invalid placeholder %q", t.Name, i, v)
+			}
+		}
+		if line.AccountID == "" {
+			return fmt.Errorf("transaction template=%s line[%d] has no accountId", t.Name, i)
+		}
+		if _, _, err := templateLine(line, nil); err != nil {
+			return fmt.Errorf("transaction template=%s line[%d]: %v", t.Name, i, err)
+		}
+	}
+	return nil
+}
+
+// parameters returns the name of every placeholder in the template, sorted.
+func (t *transactionTemplate) parameters() []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, line := range t.Lines {
+		for _, v := range line.values() {
+			if p := v.placeholder(); p != "" && !seen[p] {
+				seen[p] = true
+				out = append(out, p)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// render substitutes parameters into the template's lines. Every parameter must be used so a mistyped name
+// isn't silently ignored.
+func (t *transactionTemplate) render(parameters map[string]templateValue) ([]transactionLine, error) {
+	used := make(map[string]bool)
+	for _, name := range t.parameters() {
+		used[name] = true
+	}
+	for name := range parameters {
+		if !used[name] {
+			return nil, fmt.Errorf("transaction template=%s has no parameter %q", t.Name, name)
+		}
+	}
+	var out []transactionLine
+	for i := range t.Lines {
+		line, missing, err := templateLine(t.Lines[i], parameters)
+		if err != nil {
+			return nil, fmt.Errorf("transaction template=%s line[%d]: %v", t.Name, i, err)
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("transaction template=%s is missing parameters: %s", t.Name, strings.Join(missing, ", "))
+		}
+		out = append(out, line)
+	}
+	return out, nil
+}
+
+// templateLine fills in a line's placeholders from parameters and parses its purpose and amount. The names of
+// placeholders without a parameter are returned, leaving their fields empty.
+func templateLine(line transactionTemplateLine, parameters map[string]templateValue) (transactionLine, []string, error) {
+	var missing []string
+	resolve := func(v templateValue) string {
+		p := v.placeholder()
+		if p == "" {
+			return strings.TrimSpace(string(v))
+		}
+		if value, ok := parameters[p]; ok {
+			return strings.TrimSpace(string(value))
+		}
+		missing = append(missing, p)
+		return ""
+	}
+	accountID, purpose, amount := resolve(line.AccountID), resolve(line.Purpose), resolve(line.Amount)
+
+	out := transactionLine{AccountID: accountID, Purpose: TransactionPurpose(strings.ToLower(purpose))}
+	if purpose != "" {
+		if err := out.Purpose.validate(); err != nil {
+			return out, missing, err
+		}
+	}
+	if amount != "" {
+		n, err := strconv.Atoi(amount)
+		if err != nil || n <= 0 {
+			return out, missing, fmt.Errorf("amount %q must be a positive number of cents", amount)
+		}
+		out.Amount = n
+	}
+	return out, missing, nil
+}
+
+type saveTransactionTemplateRequest struct {
+	Name        string                    `json:"name"`
+	Description string                    `json:"description,omitempty"`
+	Lines       []transactionTemplateLine `json:"lines"`
+}
+
+type postTransactionTemplateRequest struct {
+	// ID and Status are the same as on createTransactionRequest
+	ID     string            `json:"id,omitempty"`
+	Status TransactionStatus `json:"status,omitempty"`
+
+	Parameters map[string]templateValue `json:"parameters"`
+
+	IdempotencyKey string `json:"-"`
+}
+
+type transactionTemplateService struct {
+	logger       log.Logger
+	repo         transactionTemplateRepository
+	transactions *transactionService
+}
+
+func (s *transactionTemplateService) CreateTemplate(ctx context.Context, req saveTransactionTemplateRequest) (*transactionTemplate, error) {
+	now := time.Now()
+	template := &transactionTemplate{
+		Name:         req.Name,
+		Description:  req.Description,
+		Lines:        req.Lines,
+		CreatedAt:    now,
+		LastModified: now,
+	}
+	if err := template.validate(); err != nil {
+		return nil, err
+	}
+	if err := s.repo.createTemplate(template); err != nil {
+		return nil, err
+	}
+	template.Parameters = template.parameters()
+	return template, nil
+}
+
+// UpdateTemplate replaces a template's description and lines. It returns nil if the template doesn't exist.
+func (s *transactionTemplateService) UpdateTemplate(ctx context.Context, name string, req saveTransactionTemplateRequest) (*transactionTemplate, error) {
+	if req.Name != "" && req.Name != name {
+		return nil, fmt.Errorf("transaction template=%s can't be renamed", name)
+	}
+	template := &transactionTemplate{
+		Name:         name,
+		Description:  req.Description,
+		Lines:        req.Lines,
+		LastModified: time.Now(),
+	}
+	if err := template.validate(); err != nil {
+		return nil, err
+	}
+	if found, err := s.repo.updateTemplate(template); err != nil || !found {
+		return nil, err
+	}
+	return s.repo.getTemplate(name)
+}
+
+// PostTemplate creates a transaction from the template's lines with parameters filled in. The transaction is
+// checked and posted like any other. It returns nil if the template doesn't exist.
+func (s *transactionTemplateService) PostTemplate(ctx context.Context, name string, req postTransactionTemplateRequest) (*transaction, error) {
+	template, err := s.repo.getTemplate(name)
+	if err != nil || template == nil {
+		return nil, err
+	}
+	lines, err := template.render(req.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	return s.transactions.CreateTransaction(ctx, createTransactionRequest{
+		ID:             req.ID,
+		Lines:          lines,
+		Status:         req.Status,
+		IdempotencyKey: req.IdempotencyKey,
+	})
+}
+
+func addTransactionTemplateRoutes(logger log.Logger, router *mux.Router, svc *transactionTemplateService) {
+	router.Methods("GET").Path("/accounts/transaction-templates").HandlerFunc(getTransactionTemplates(logger, svc))
+	router.Methods("POST").Path("/accounts/transaction-templates").HandlerFunc(createTransactionTemplate(logger, svc))
+	router.Methods("GET").Path("/accounts/transaction-templates/{templateName}").HandlerFunc(getTransactionTemplate(logger, svc))
+	router.Methods("PUT").Path("/accounts/transaction-templates/{templateName}").HandlerFunc(updateTransactionTemplate(logger, svc))
+	router.Methods("DELETE").Path("/accounts/transaction-templates/{templateName}").HandlerFunc(deleteTransactionTemplate(logger, svc))
+	router.Methods("POST").Path("/accounts/transaction-templates/{templateName}/transactions").HandlerFunc(postTransactionTemplate(logger, svc))
+}
+
+func getTransactionTemplates(logger log.Logger, svc *transactionTemplateService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		templates, err := svc.repo.getTemplates()
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if templates == nil {
+			templates = []*transactionTemplate{}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(templates)
+	}
+}
+
+func createTransactionTemplate(logger log.Logger, svc *transactionTemplateService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		var req saveTransactionTemplateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		template, err := svc.CreateTemplate(requestContext(r), req)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(template)
+	}
+}
+
+func getTransactionTemplate(logger log.Logger, svc *transactionTemplateService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		template, err := svc.repo.getTemplate(mux.Vars(r)["templateName"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if template == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(template)
+	}
+}
+
+func updateTransactionTemplate(logger log.Logger, svc *transactionTemplateService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		var req saveTransactionTemplateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		template, err := svc.UpdateTemplate(requestContext(r), mux.Vars(r)["templateName"], req)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if template == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(template)
+	}
+}
+
+func deleteTransactionTemplate(logger log.Logger, svc *transactionTemplateService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		found, err := svc.repo.deleteTemplate(mux.Vars(r)["templateName"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func postTransactionTemplate(logger log.Logger, svc *transactionTemplateService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapIdempotentResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+
+		var req postTransactionTemplateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		req.IdempotencyKey = r.Header.Get("X-Idempotency-Key")
+
+		tx, err := svc.PostTemplate(requestContext(r), mux.Vars(r)["templateName"], req)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if tx == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(tx)
+	}
+}
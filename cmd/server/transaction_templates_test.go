@@ -0,0 +1,158 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestTransactionTemplates__render(t *testing.T) {
+	var template transactionTemplate
+	body := `{"name": "month-end", "lines": [
+  {"accountId": "expenses", "purpose": "achdebit", "amount": "{{ amount }}"},
+  {"accountId": "{{payable}}", "purpose": "achcredit", "amount": "{{amount}}"},
+  {"accountId": "fees", "purpose": "{{feePurpose}}", "amount": 25},
+  {"accountId": "expenses", "purpose": "achcredit", "amount": 25}
+]}`
+	if err := json.Unmarshal([]byte(body), &template); err != nil {
+		t.Fatal(err)
+	}
+	if err := template.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if params := template.parameters(); strings.Join(params, ",") != "amount,feePurpose,payable" {
+		t.Errorf("parameters=%v", params)
+	}
+
+	lines, err := template.render(map[string]templateValue{"amount": "1200", "payable": "vendor", "feePurpose": "achdebit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 4 || lines[1] != (transactionLine{AccountID: "vendor", Purpose: ACHCredit, Amount: 1200}) || lines[2].Purpose != ACHDebit {
+		t.Errorf("unexpected lines: %#v", lines)
+	}
+
+	for _, params := range []map[string]templateValue{
+		{"amount": "1200", "payable": "vendor"},                                        // missing
+		{"amount": "1200", "payable": "vendor", "feePurpose": "achdebit", "typo": "1"}, // unknown
+		{"amount": "-5", "payable": "vendor", "feePurpose": "achdebit"},                // negative amount
+		{"amount": "1200", "payable": "vendor", "feePurpose": "cash"},                  // unknown purpose
+		{"amount": "twelve", "payable": "vendor", "feePurpose": "achdebit"},            // not a number
+	} {
+		if _, err := template.render(params); err == nil {
+			t.Errorf("expected error for %v", params)
+		}
+	}
+
+	// literal values are checked when templates are saved
+	for _, line := range []transactionTemplateLine{
+		{AccountID: "a", Purpose: "cash", Amount: "{{amount}}"},
+		{AccountID: "a", Purpose: "achdebit", Amount: "0"},
+		{AccountID: "{{account", Purpose: "achdebit", Amount: "5"},
+		{AccountID: "", Purpose: "achdebit", Amount: "5"},
+	} {
+		invalid := transactionTemplate{Name: "invalid", Lines: []transactionTemplateLine{line}}
+		if err := invalid.validate(); err == nil {
+			t.Errorf("expected error for %#v", line)
+		}
+	}
+	if err := (&transactionTemplate{Name: "month end", Lines: template.Lines}).validate(); err == nil {
+		t.Error("expected error for invalid name")
+	}
+}
+
+func TestTransactionTemplates__routes(t *testing.T) {
+	check := func(t *testing.T, repo *sqlTransactionTemplateRepository) {
+		accountRepo, transactionRepo := createTestLedger(t, map[string]int{"expenses": 5000, "payroll": 0})
+
+		svc := &transactionTemplateService{
+			logger:       log.NewNopLogger(),
+			repo:         repo,
+			transactions: &transactionService{logger: log.NewNopLogger(), repo: transactionRepo, events: &mockEventPublisher{}},
+		}
+		router := mux.NewRouter()
+		addTransactionTemplateRoutes(log.NewNopLogger(), router, svc)
+		serve := func(method, path, body string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set("x-user-id", base.ID())
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			w.Flush()
+			return w
+		}
+
+		template := `{"name": "payroll", "description": "Semi-monthly payroll", "lines": [
+  {"accountId": "expenses", "purpose": "achdebit", "amount": "{{amount}}"},
+  {"accountId": "payroll", "purpose": "achcredit", "amount": "{{amount}}"}
+]}`
+		if w := serve("POST", "/accounts/transaction-templates", template); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"parameters":["amount"]`) {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		if w := serve("POST", "/accounts/transaction-templates", template); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+
+		w := serve("POST", "/accounts/transaction-templates/payroll/transactions", `{"parameters": {"amount": 1500}}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		var tx transaction
+		if err := json.NewDecoder(w.Body).Decode(&tx); err != nil {
+			t.Fatal(err)
+		}
+		if tx.Status != TransactionPosted || len(tx.Lines) != 2 {
+			t.Errorf("unexpected transaction: %#v", tx)
+		}
+		checkBalances(t, accountRepo, map[string]int32{"expenses": 3500, "payroll": 1500})
+
+		// templated transactions are checked like any other
+		if w := serve("POST", "/accounts/transaction-templates/payroll/transactions", `{"parameters": {"amount": 4000}}`); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if w := serve("POST", "/accounts/transaction-templates/payroll/transactions", `{"parameters": {}}`); w.Code != http.StatusBadRequest {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+
+		// update, list and delete
+		updated := strings.Replace(template, "Semi-monthly", "Monthly", 1)
+		if w := serve("PUT", "/accounts/transaction-templates/payroll", updated); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Monthly payroll") {
+			t.Errorf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		if w := serve("PUT", "/accounts/transaction-templates/other", strings.Replace(template, `"payroll", "desc`, `"other", "desc`, 1)); w.Code != http.StatusNotFound {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		var templates []*transactionTemplate
+		if err := json.NewDecoder(serve("GET", "/accounts/transaction-templates", "").Body).Decode(&templates); err != nil || len(templates) != 1 || templates[0].Description != "Monthly payroll" {
+			t.Errorf("templates=%#v error=%v", templates, err)
+		}
+		if w := serve("DELETE", "/accounts/transaction-templates/payroll", ""); w.Code != http.StatusOK {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if w := serve("GET", "/accounts/transaction-templates/payroll", ""); w.Code != http.StatusNotFound {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+		if w := serve("POST", "/accounts/transaction-templates/payroll/transactions", `{"parameters": {"amount": 100}}`); w.Code != http.StatusNotFound {
+			t.Errorf("bogus HTTP status: %d", w.Code)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlTransactionTemplateRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlTransactionTemplateRepository{mysqlDB.DB, log.NewNopLogger()})
+}
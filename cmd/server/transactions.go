@@ -5,14 +5,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/moov-io/base"
 	moovhttp "github.com/moov-io/base/http"
 
 	"github.com/go-kit/kit/log"
@@ -22,6 +24,12 @@ import (
 var (
 	errNoAccountID     = errors.New("no accountID found")
 	errNoTransactionID = errors.New("no transactionID found")
+
+	errDuplicateTransactionID = errors.New("transaction ID already exists")
+
+	errInvalidCursor = errors.New("cursor isn't one of the account's transactions")
+
+	errInsufficientFunds = errors.New("insufficient funds")
 )
 
 type TransactionPurpose string
@@ -29,6 +37,7 @@ type TransactionPurpose string
 var (
 	ACHCredit TransactionPurpose = "achcredit"
 	ACHDebit  TransactionPurpose = "achdebit"
+	Card      TransactionPurpose = "card"
 	Fee       TransactionPurpose = "fee"
 	Interest  TransactionPurpose = "interest"
 	Transfer  TransactionPurpose = "transfer"
@@ -49,42 +58,247 @@ func (p *TransactionPurpose) UnmarshalJSON(b []byte) error {
 
 func (p TransactionPurpose) validate() error {
 	switch p {
-	case ACHCredit, ACHDebit, Fee, Interest, Transfer, Wire:
+	case ACHCredit, ACHDebit, Card, Fee, Interest, Transfer, Wire:
 		return nil
 	default:
 		return fmt.Errorf("unknown TransactionPurpose %q", p)
 	}
 }
 
+// TransactionStatus is where a transaction is in its lifecycle. Only posted transactions (and posted transactions
+// which were later reversed) affect account balances, except held transactions reserve their debits until they're
+// committed (posted) or aborted (voided).
+type TransactionStatus string
+
+var (
+	TransactionPending  TransactionStatus = "pending"
+	TransactionPosted   TransactionStatus = "posted"
+	TransactionVoided   TransactionStatus = "voided"
+	TransactionReversed TransactionStatus = "reversed"
+	TransactionFailed   TransactionStatus = "failed"
+	TransactionHeld     TransactionStatus = "held"
+)
+
+// transactionTransitions lists the statuses a transaction is allowed to move into from each status.
+// Voided, reversed and failed are final.
+var transactionTransitions = map[TransactionStatus][]TransactionStatus{
+	TransactionPending: {TransactionPosted, TransactionVoided, TransactionFailed},
+	TransactionPosted:  {TransactionReversed},
+	TransactionHeld:    {TransactionPosted, TransactionVoided},
+}
+
+func (s *TransactionStatus) UnmarshalJSON(b []byte) error {
+	var v string
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	*s = TransactionStatus(strings.ToLower(v))
+	if *s == "" {
+		return nil // optional when creating transactions
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s TransactionStatus) validate() error {
+	switch s {
+	case TransactionPending, TransactionPosted, TransactionVoided, TransactionReversed, TransactionFailed, TransactionHeld:
+		return nil
+	default:
+		return fmt.Errorf("unknown TransactionStatus %q", s)
+	}
+}
+
+// transition returns an error if a transaction can't move from s into next.
+func (s TransactionStatus) transition(next TransactionStatus) error {
+	for _, allowed := range transactionTransitions[s] {
+		if allowed == next {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid transition from %s to %s", s, next)
+}
+
 type transactionLine struct {
 	AccountID string             `json:"accountId"`
 	Purpose   TransactionPurpose `json:"purpose"`
 	Amount    int                `json:"amount"`
+
+	// Description is an optional memo shown alongside the line, such as the counterparty or what it paid for
+	Description string `json:"description,omitempty"`
+
+	// Department, Product and Region are optional segments for allocating the line (see line_segments.go)
+	Department string `json:"department,omitempty"`
+	Product    string `json:"product,omitempty"`
+	Region     string `json:"region,omitempty"`
+
+	// MCC is the merchant category code of card lines (see mcc.go)
+	MCC string `json:"mcc,omitempty"`
+
+	// MerchantCountry is the ISO 3166-1 alpha-2 country of the merchant on card lines (see travel_notices.go)
+	MerchantCountry string `json:"merchantCountry,omitempty"`
 }
 
 func (line transactionLine) validate() error {
 	if line.AccountID == "" || line.Amount == 0 {
 		return fmt.Errorf("transactionLine: AccountID=%s Amount=%d is invalid", line.AccountID, line.Amount)
 	}
-	return line.Purpose.validate()
+	if err := line.Purpose.validate(); err != nil {
+		return err
+	}
+	if err := validateDescription(line.Description); err != nil {
+		return fmt.Errorf("transactionLine: AccountID=%s: %v", line.AccountID, err)
+	}
+	switch {
+	case line.Purpose == Card:
+		if err := validateMCC(line.MCC); err != nil {
+			return fmt.Errorf("transactionLine: AccountID=%s: %v", line.AccountID, err)
+		}
+		if line.MerchantCountry != "" && !countryRegex.MatchString(line.MerchantCountry) {
+			return fmt.Errorf("transactionLine: AccountID=%s has invalid merchantCountry %q", line.AccountID, line.MerchantCountry)
+		}
+	case line.MCC != "":
+		return fmt.Errorf("transactionLine: AccountID=%s has an MCC but isn't a card line", line.AccountID)
+	case line.MerchantCountry != "":
+		return fmt.Errorf("transactionLine: AccountID=%s has a merchantCountry but isn't a card line", line.AccountID)
+	}
+	return configuredSegments.check(line)
 }
 
 type createTransactionRequest struct {
+	// ID is an optional caller provided UUID for the transaction, which lets callers trace a transaction
+	// through their own systems. A random ID is generated when it's empty.
+	ID string `json:"id,omitempty"`
+
 	Lines []transactionLine `json:"lines"`
+
+	// Status can be set to pending to hold a transaction without affecting balances until it's posted.
+	// Transactions are posted by default.
+	Status TransactionStatus `json:"status,omitempty"`
+
+	// Description is an optional memo of what the transaction is for
+	Description string `json:"description,omitempty"`
+
+	// Metadata and Tags are caller defined, such as the order or batch a transaction belongs to, see
+	// transaction_tags.go
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+
+	// IdempotencyKey is read from the X-Idempotency-Key header. Requests replayed with the same key return the
+	// transaction created by the first one rather than posting it again.
+	IdempotencyKey string `json:"-"`
+
+	// Timestamp backdates transactions which are created internally, such as journal imports migrating another
+	// ledger's history. It's never read from requests, and transactions are timestamped when created without it.
+	Timestamp time.Time `json:"-"`
+}
+
+// maxIdempotencyKeyLength is the longest X-Idempotency-Key header accepted.
+const maxIdempotencyKeyLength = 255
+
+// maxDescriptionLength is the longest description of a transaction or line, in characters.
+const maxDescriptionLength = 255
+
+func validateDescription(description string) error {
+	if len(description) > maxDescriptionLength {
+		return fmt.Errorf("description is longer than %d characters", maxDescriptionLength)
+	}
+	return nil
+}
+
+var uuidRegex = regexp.MustCompile(`^[a-f0-9]{8}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{12}$`)
+
+// transactionID returns the caller provided ID (if it's a valid UUID) or generates a new ID.
+func (r *createTransactionRequest) transactionID() (string, error) {
+	if r.ID == "" {
+		return newID(), nil
+	}
+	id := strings.ToLower(strings.TrimSpace(r.ID))
+	if !uuidRegex.MatchString(id) {
+		return "", fmt.Errorf("transaction ID %q is not a UUID", r.ID)
+	}
+	return id, nil
+}
+
+// lines returns the request's lines with the system accounts they name, instead of giving their ID, resolved.
+func (r *createTransactionRequest) lines() []transactionLine {
+	lines := make([]transactionLine, len(r.Lines))
+	for i := range r.Lines {
+		lines[i] = r.Lines[i]
+		lines[i].AccountID = configuredSystemAccounts.resolve(lines[i].AccountID)
+	}
+	return lines
+}
+
+// checkReservedMetadata rejects metadata keys the ledger sets itself, such as the fees added to a transaction.
+func (r *createTransactionRequest) checkReservedMetadata() error {
+	if _, exists := r.Metadata[feesMetadataKey]; exists {
+		return fmt.Errorf("metadata key %q is reserved", feesMetadataKey)
+	}
+	return nil
 }
 
 func (r *createTransactionRequest) asTransaction(id string) transaction {
+	status := r.Status
+	if status == "" {
+		status = TransactionPosted
+	}
+	lines := r.lines()
+	timestamp := r.Timestamp
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
 	return transaction{
-		ID:        id,
-		Lines:     r.Lines,
-		Timestamp: time.Now(),
+		ID:             id,
+		Lines:          lines,
+		Timestamp:      timestamp,
+		Status:         status,
+		Description:    r.Description,
+		Metadata:       r.Metadata,
+		Tags:           uniqueTags(r.Tags),
+		IdempotencyKey: r.IdempotencyKey,
 	}
 }
 
 type transaction struct {
 	ID        string            `json:"id"`
 	Timestamp time.Time         `json:"timestamp"`
+	Status    TransactionStatus `json:"status"`
 	Lines     []transactionLine `json:"lines"`
+
+	// OrganizationID is the organization which owns the transaction, see organization.go
+	OrganizationID string `json:"organizationId,omitempty"`
+
+	// Description is an optional memo of what the transaction is for, which each line can add to
+	Description string `json:"description,omitempty"`
+
+	// Metadata and Tags are set by whoever created the transaction, see transaction_tags.go
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Tags     []string          `json:"tags,omitempty"`
+
+	// ExpiresAt is when a held transaction is aborted unless it's been committed
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	// IdempotencyKey is the X-Idempotency-Key the transaction was created with, which is unique across transactions
+	IdempotencyKey string `json:"-"`
+
+	// ForcePostID is the admin force post which created the transaction, bypassing balance checks
+	ForcePostID string `json:"forcePostId,omitempty"`
+
+	// Attachments are only included when requested with ?expand=attachments
+	Attachments []*attachment `json:"attachments,omitempty"`
+
+	// Formatting is included in responses for rendering the amounts of lines, see currency.go
+	Formatting *amountFormatting `json:"formatting,omitempty"`
+}
+
+// formatTransaction returns a copy of tx with the formatting of its amounts for the response to r.
+func formatTransaction(r *http.Request, tx *transaction) transaction {
+	out := *tx
+	out.Formatting = requestAmountFormatting(r, ledgerCurrency)
+	return out
 }
 
 func (t transaction) validate() error {
@@ -97,6 +311,21 @@ func (t transaction) validate() error {
 	if t.Timestamp.IsZero() {
 		return fmt.Errorf("transaction=%s has no Timestamp", t.ID)
 	}
+	switch t.Status {
+	case "", TransactionPending, TransactionPosted:
+	case TransactionHeld:
+		if t.ExpiresAt == nil {
+			return fmt.Errorf("transaction=%s is held without an expiry", t.ID)
+		}
+	default:
+		return fmt.Errorf("transaction=%s can't be created as %s", t.ID, t.Status)
+	}
+	if err := validateDescription(t.Description); err != nil {
+		return fmt.Errorf("transaction=%s: %v", t.ID, err)
+	}
+	if err := validateMetadata(t.Metadata, t.Tags); err != nil {
+		return fmt.Errorf("transaction=%s: %v", t.ID, err)
+	}
 
 	sum := 0
 	for i := range t.Lines {
@@ -111,6 +340,12 @@ func (t transaction) validate() error {
 		if err := t.Lines[i].validate(); err != nil {
 			return fmt.Errorf("transaction=%s has invalid line[%d]: %v", t.ID, i, err)
 		}
+		if mcc := t.mcc(); t.Lines[i].MCC != "" && t.Lines[i].MCC != mcc {
+			return fmt.Errorf("transaction=%s has card lines with different MCCs", t.ID)
+		}
+		if country := t.merchantCountry(); t.Lines[i].MerchantCountry != "" && t.Lines[i].MerchantCountry != country {
+			return fmt.Errorf("transaction=%s has card lines with different merchant countries", t.ID)
+		}
 	}
 	if sum == 0 {
 		return nil
@@ -118,10 +353,17 @@ func (t transaction) validate() error {
 	return fmt.Errorf("transaction=%s has %d invalid lines sum=%d", t.ID, len(t.Lines), sum)
 }
 
-func addTransactionRoutes(logger log.Logger, router *mux.Router, accountRepo accountRepository, transactionRepo transactionRepository) {
-	router.Methods("GET").Path("/accounts/{accountId}/transactions").HandlerFunc(getAccountTransactions(logger, transactionRepo))
-	router.Methods("POST").Path("/accounts/transactions").HandlerFunc(createTransaction(logger, accountRepo, transactionRepo))
-	router.Methods("POST").Path("/accounts/transactions/{transactionID}/reversal").HandlerFunc(createTransactionReversal(logger, accountRepo, transactionRepo))
+func addTransactionRoutes(logger log.Logger, router *mux.Router, accountRepo accountRepository, transactionRepo transactionRepository, attachmentRepo attachmentRepository, events eventPublisher, fraud *fraudScreen, clock func() time.Time) {
+	svc := &transactionService{logger: logger, repo: transactionRepo, attachments: attachmentRepo, events: events, fraud: fraud, clock: clock}
+
+	router.Methods("GET").Path("/accounts/{accountId}/transactions").HandlerFunc(getAccountTransactions(logger, svc))
+	router.Methods("POST").Path("/accounts/transactions").HandlerFunc(createTransaction(logger, svc))
+	router.Methods("GET").Path("/accounts/transactions/{transactionID}").HandlerFunc(getTransaction(logger, svc))
+	router.Methods("POST").Path("/accounts/transactions/{transactionID}/reversal").HandlerFunc(createTransactionReversal(logger, svc))
+	router.Methods("PUT").Path("/accounts/transactions/{transactionID}/status").HandlerFunc(updateTransactionStatus(logger, svc))
+
+	addTransactionHoldRoutes(logger, router, svc)
+	addAttachmentRoutes(logger, router, svc)
 }
 
 func getAccountID(w http.ResponseWriter, r *http.Request) string {
@@ -135,8 +377,73 @@ func getAccountID(w http.ResponseWriter, r *http.Request) string {
 	return v
 }
 
-func getAccountTransactions(logger log.Logger, transactionRepo transactionRepository) http.HandlerFunc {
+// requestContext returns the context passed into transactionService calls for r.
+func requestContext(r *http.Request) context.Context {
+	return withRequestID(r.Context(), moovhttp.GetRequestID(r))
+}
+
+// maxAccountTransactionsLimit is the largest page of transactions returned for an account.
+const maxAccountTransactionsLimit = 1000
+
+// nextCursorHeaderName is the response header with the cursor of an account's next page of transactions. It's passed
+// as ?cursor to get the following page and isn't sent on the last page.
+const nextCursorHeaderName = "X-Next-Cursor"
+
+// readTransactionPage reads the ?limit, ?cursor and filters of an account's transactions. Dates are RFC 3339
+// timestamps or YYYY-MM-DD days (in UTC), and an endDate day includes the whole day. ?tag can be repeated to only
+// include transactions with every tag.
+func readTransactionPage(r *http.Request) (transactionPage, error) {
+	q := r.URL.Query()
+	page := transactionPage{Limit: 100, Cursor: q.Get("cursor")}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAccountTransactionsLimit {
+			return page, fmt.Errorf("invalid limit %q, expected 1 to %d", v, maxAccountTransactionsLimit)
+		}
+		page.Limit = n
+	}
+	for param, when := range map[string]*time.Time{"startDate": &page.StartDate, "endDate": &page.EndDate} {
+		v := q.Get(param)
+		if v == "" {
+			continue
+		}
+		if t, err := time.Parse("2006-01-02", v); err == nil {
+			if param == "endDate" {
+				t = t.AddDate(0, 0, 1)
+			}
+			*when = t
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return page, fmt.Errorf("invalid %s %q, expected YYYY-MM-DD or an RFC 3339 timestamp", param, v)
+		}
+		*when = t
+	}
+	if v := q.Get("purpose"); v != "" {
+		page.Purpose = TransactionPurpose(strings.ToLower(v))
+		if err := page.Purpose.validate(); err != nil {
+			return page, err
+		}
+	}
+	page.Tags = q["tag"]
+	for param, amount := range map[string]*int{"minAmount": &page.MinAmount, "maxAmount": &page.MaxAmount} {
+		if v := q.Get(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return page, fmt.Errorf("invalid %s %q", param, v)
+			}
+			*amount = n
+		}
+	}
+	return page, nil
+}
+
+// getAccountTransactions returns a page of an account's transactions as JSON, or with ?format=csv or ?format=ndjson
+// streams every matching transaction (see transaction_exports.go and transaction_streams.go).
+func getAccountTransactions(logger log.Logger, svc *transactionService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, _ := w.(http.Flusher)
 		w, err := wrapResponseWriter(logger, w, r)
 		if err != nil {
 			return
@@ -144,50 +451,105 @@ func getAccountTransactions(logger log.Logger, transactionRepo transactionReposi
 
 		accountID := getAccountID(w, r)
 		if accountID == "" {
-			moovhttp.Problem(w, errNoAccountID)
 			return
 		}
 
-		transactions, err := transactionRepo.getAccountTransactions(accountID)
+		page, err := readTransactionPage(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		switch format := strings.ToLower(r.URL.Query().Get("format")); format {
+		case "", "json":
+		case "csv":
+			columns, err := readTransactionCSVColumns(r.URL.Query().Get("columns"))
+			if err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="transactions-%s.csv"`, accountID))
+			w.WriteHeader(http.StatusOK)
+			var flush func()
+			if flusher != nil {
+				flush = flusher.Flush
+			}
+			// The status is already sent, so an error part way through can only cut the export short
+			if err := writeAccountTransactionsCSV(requestContext(r), w, flush, svc, accountID, page, columns); err != nil {
+				logger.Log("transactions", fmt.Sprintf("problem exporting account=%s transactions: %v", accountID, err), "requestID", moovhttp.GetRequestID(r))
+			}
+			return
+		case "ndjson":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			var flush func()
+			if flusher != nil {
+				flush = flusher.Flush
+			}
+			if err := writeAccountTransactionsNDJSON(requestContext(r), w, flush, svc, accountID, page); err != nil {
+				logger.Log("transactions", fmt.Sprintf("problem streaming account=%s transactions: %v", accountID, err), "requestID", moovhttp.GetRequestID(r))
+			}
+			return
+		default:
+			moovhttp.Problem(w, fmt.Errorf("unknown transactions format %q", format))
+			return
+		}
+		transactions, next, err := svc.GetAccountTransactions(requestContext(r), accountID, page)
 		if err != nil {
 			moovhttp.Problem(w, err)
 			return
 		}
+		if expandAttachments(r) {
+			expand := make([]*transaction, len(transactions))
+			for i := range transactions {
+				expand[i] = &transactions[i]
+			}
+			if err := svc.ExpandAttachments(requestContext(r), expand); err != nil {
+				moovhttp.Problem(w, err)
+				return
+			}
+		}
 
+		formatting := requestAmountFormatting(r, ledgerCurrency)
+		for i := range transactions {
+			transactions[i].Formatting = formatting
+		}
+		if transactions == nil {
+			transactions = []transaction{}
+		}
+
+		if next != "" {
+			w.Header().Set(nextCursorHeaderName, next)
+		}
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(transactions)
-
 	}
 }
 
-func createTransaction(logger log.Logger, accountRepo accountRepository, transactionRepo transactionRepository) http.HandlerFunc {
+func createTransaction(logger log.Logger, svc *transactionService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w, err := wrapResponseWriter(logger, w, r)
+		w, err := wrapIdempotentResponseWriter(logger, w, r)
 		if err != nil {
 			return
 		}
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-		requestID := moovhttp.GetRequestID(r)
-
 		var req createTransactionRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			moovhttp.Problem(w, err)
 			return
 		}
+		req.IdempotencyKey = r.Header.Get("X-Idempotency-Key")
 
-		// Post the transaction
-		tx := req.asTransaction(base.ID())
-		if err := transactionRepo.createTransaction(tx, createTransactionOpts{AllowOverdraft: false}); err != nil {
-			logger.Log("transactions", fmt.Errorf("problem creating transaction: %v", err), "requestID", requestID)
+		tx, err := svc.CreateTransaction(requestContext(r), req)
+		if err != nil {
 			moovhttp.Problem(w, err)
 			return
 		}
-		logger.Log("transaction", fmt.Errorf("created transaction %s", tx.ID), "requestID", requestID)
 
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(tx)
+		json.NewEncoder(w).Encode(formatTransaction(r, tx))
 	}
 }
 
@@ -202,46 +564,90 @@ func getTransactionID(w http.ResponseWriter, r *http.Request) string {
 	return v
 }
 
-func createTransactionReversal(logger log.Logger, accountRepo accountRepository, transactionRepo transactionRepository) http.HandlerFunc {
+func getTransaction(logger log.Logger, svc *transactionService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w, err := wrapResponseWriter(logger, w, r)
 		if err != nil {
 			return
 		}
-		w.Header().Set("Content-Type", "application/json; charset=utf-8")
 
-		// Read our transactionID and do an info log
-		requestID, transactionID := moovhttp.GetRequestID(r), getTransactionID(w, r)
+		transactionID := getTransactionID(w, r)
 		if transactionID == "" {
 			return
 		}
-		logger.Log("transaction", fmt.Sprintf("reversing transaction %s", transactionID), "requestID", requestID)
 
-		// reverse the transaction (after reading it from our database)
-		transaction, err := transactionRepo.getTransaction(transactionID)
+		tx, err := svc.GetTransaction(requestContext(r), transactionID)
 		if err != nil {
 			moovhttp.Problem(w, err)
 			return
 		}
-		transaction.ID = base.ID()
-		transaction.Timestamp = time.Now()
-		for i := range transaction.Lines {
-			// Swap Purpose back if Debit vs Credit
-			switch {
-			case transaction.Lines[i].Purpose == ACHCredit:
-				transaction.Lines[i].Purpose = ACHDebit
-			case transaction.Lines[i].Purpose == ACHDebit:
-				transaction.Lines[i].Purpose = ACHCredit
+		if expandAttachments(r) {
+			if err := svc.ExpandAttachments(requestContext(r), []*transaction{tx}); err != nil {
+				moovhttp.Problem(w, err)
+				return
 			}
 		}
-		if err := transactionRepo.createTransaction(*transaction, createTransactionOpts{AllowOverdraft: false}); err != nil {
-			logger.Log("transactions", fmt.Errorf("problem creating transaction: %v", err), "requestID", requestID)
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(formatTransaction(r, tx))
+	}
+}
+
+func createTransactionReversal(logger log.Logger, svc *transactionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		transactionID := getTransactionID(w, r)
+		if transactionID == "" {
+			return
+		}
+
+		transaction, err := svc.ReverseTransaction(requestContext(r), transactionID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(formatTransaction(r, transaction))
+	}
+}
+
+type updateTransactionStatusRequest struct {
+	Status TransactionStatus `json:"status"`
+}
+
+func updateTransactionStatus(logger log.Logger, svc *transactionService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+		transactionID := getTransactionID(w, r)
+		if transactionID == "" {
+			return
+		}
+
+		var req updateTransactionStatusRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		tx, err := svc.UpdateTransactionStatus(requestContext(r), transactionID, req.Status)
+		if err != nil {
 			moovhttp.Problem(w, err)
 			return
 		}
-		logger.Log("transactions", fmt.Sprintf("reversed (original transaction=%s) transaction=%s", transactionID, transaction.ID), "requestID", requestID)
 
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(transaction)
+		json.NewEncoder(w).Encode(formatTransaction(r, tx))
 	}
 }
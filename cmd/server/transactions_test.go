@@ -6,11 +6,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -36,28 +39,231 @@ func (r *mockTransactionRepository) Close() error {
 	return r.err
 }
 
-func (r *mockTransactionRepository) createTransaction(tx transaction, opts createTransactionOpts) error {
+func (r *mockTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
 	if err := tx.validate(); err != nil && !opts.InitialDeposit {
 		return err
 	}
 	r.created = tx
+	if r.err == nil && opts.Reverses != "" {
+		return r.updateTransactionStatus(opts.Reverses, TransactionReversed)
+	}
 	return r.err
 }
 
-func (r *mockTransactionRepository) getAccountTransactions(accountID string) ([]transaction, error) {
+func (r *mockTransactionRepository) getAccountTransactions(ctx context.Context, accountID string, page transactionPage) ([]transaction, string, error) {
 	if r.err != nil {
-		return nil, r.err
+		return nil, "", r.err
 	}
-	return r.transactions, nil
+	return r.transactions, "", nil
 }
 
-func (r *mockTransactionRepository) getTransaction(transactionID string) (*transaction, error) {
+func (r *mockTransactionRepository) getTransaction(ctx context.Context, transactionID string) (*transaction, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
+	if r.created.ID == transactionID {
+		return &r.created, nil
+	}
+	if len(r.transactions) == 0 {
+		return nil, fmt.Errorf("transaction=%q not found", transactionID)
+	}
 	return &r.transactions[0], nil
 }
 
+func (r *mockTransactionRepository) getTransactionByIdempotencyKey(ctx context.Context, key string) (*transaction, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	for i := range r.transactions {
+		if r.transactions[i].IdempotencyKey == key {
+			return &r.transactions[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *mockTransactionRepository) updateTransactionStatus(transactionID string, status TransactionStatus) error {
+	if r.err != nil {
+		return r.err
+	}
+	for i := range r.transactions {
+		if r.transactions[i].ID == transactionID {
+			if err := r.transactions[i].Status.transition(status); err != nil {
+				return err
+			}
+			r.transactions[i].Status = status
+		}
+	}
+	return nil
+}
+
+func (r *mockTransactionRepository) getExpiredHolds(now time.Time) ([]string, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	var out []string
+	for i := range r.transactions {
+		if r.transactions[i].holdExpired(now) {
+			out = append(out, r.transactions[i].ID)
+		}
+	}
+	return out, nil
+}
+
+func (r *mockTransactionRepository) compactTransactionLines(before time.Time) (*compactionResult, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &compactionResult{Before: before}, nil
+}
+
+func (r *mockTransactionRepository) getDailyTotals(accountID string, end time.Time) ([]dailyTotal, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	var transactions []*transaction
+	for i := range r.transactions {
+		transactions = append(transactions, &r.transactions[i])
+	}
+	return dailyTotalsOf(accountID, end, transactions), nil
+}
+
+func (r *mockTransactionRepository) repairAccountBalance(accountID string, repair bool) (*balanceRepair, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	return &balanceRepair{AccountID: accountID, Repaired: repair}, nil
+}
+
+func (r *mockTransactionRepository) getSegmentTotals(q segmentTotalsQuery) ([]segmentTotal, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	var totals []segmentTotal
+	for i := range r.transactions {
+		for _, line := range r.transactions[i].Lines {
+			if q.includes(&r.transactions[i], line) {
+				total := segmentTotal{Value: q.Segment.value(line)}
+				total.add(line)
+				totals = append(totals, total)
+			}
+		}
+	}
+	return mergeSegmentTotals(totals), nil
+}
+
+func (r *mockTransactionRepository) getMCCSpend(q mccSpendQuery) ([]mccSpend, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	var spend []mccSpend
+	for i := range r.transactions {
+		if amount := q.spend(&r.transactions[i]); amount > 0 {
+			spend = append(spend, mccSpend{MCC: r.transactions[i].mcc(), Amount: amount, Transactions: 1})
+		}
+	}
+	return mergeMCCSpend(spend), nil
+}
+
+func (r *mockTransactionRepository) getTrialBalance(q trialBalanceQuery) ([]trialBalanceAccount, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	var totals []trialBalanceAccount
+	for i := range r.transactions {
+		for _, line := range r.transactions[i].Lines {
+			if q.includes(&r.transactions[i], line) {
+				total := trialBalanceAccount{AccountID: line.AccountID}
+				total.add(line)
+				totals = append(totals, total)
+			}
+		}
+	}
+	return mergeTrialBalance(totals), nil
+}
+
+func TestTransactionStatus(t *testing.T) {
+	if err := TransactionStatus("other").validate(); err == nil {
+		t.Error("expected error")
+	}
+	if err := TransactionPending.transition(TransactionPosted); err != nil {
+		t.Error(err)
+	}
+	if err := TransactionPending.transition(TransactionVoided); err != nil {
+		t.Error(err)
+	}
+	if err := TransactionPosted.transition(TransactionReversed); err != nil {
+		t.Error(err)
+	}
+	if err := TransactionPosted.transition(TransactionVoided); err == nil {
+		t.Error("expected error")
+	}
+	if err := TransactionReversed.transition(TransactionPosted); err == nil {
+		t.Error("expected error")
+	}
+
+	var status TransactionStatus
+	if err := json.Unmarshal([]byte(`"PENDING"`), &status); err != nil || status != TransactionPending {
+		t.Errorf("status=%s error=%v", status, err)
+	}
+	if err := json.Unmarshal([]byte(`"other"`), &status); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestTransactions__updateTransactionStatus(t *testing.T) {
+	accountRepo := &testAccountRepository{}
+	transactionRepo := &mockTransactionRepository{
+		transactions: []transaction{
+			{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Status:    TransactionPending,
+				Lines: []transactionLine{
+					{AccountID: base.ID(), Purpose: ACHDebit, Amount: 1000},
+					{AccountID: base.ID(), Purpose: ACHCredit, Amount: 1000},
+				},
+			},
+		},
+	}
+
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &loggingEventPublisher{log.NewNopLogger()}, nil, time.Now)
+
+	update := func(status string) *httptest.ResponseRecorder {
+		body := strings.NewReader(fmt.Sprintf(`{"status": %q}`, status))
+		req := httptest.NewRequest("PUT", fmt.Sprintf("/accounts/transactions/%s/status", transactionRepo.transactions[0].ID), body)
+		req.Header.Set("x-user-id", base.ID())
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	// reversals have their own endpoint
+	if w := update("reversed"); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d", w.Code)
+	}
+
+	w := update("posted")
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	var tx transaction
+	if err := json.NewDecoder(w.Body).Decode(&tx); err != nil {
+		t.Fatal(err)
+	}
+	if tx.Status != TransactionPosted {
+		t.Errorf("got %s", tx.Status)
+	}
+
+	// posted transactions can't be voided
+	if w := update("voided"); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
 func TestTransactionPurpose(t *testing.T) {
 	if err := TransactionPurpose("").validate(); err == nil {
 		t.Error("expected error")
@@ -137,6 +343,18 @@ func TestTransaction__validate(t *testing.T) {
 	if err := tx.validate(); err == nil {
 		t.Error("expected error")
 	}
+	tx.Lines[0].Purpose = ACHDebit
+
+	tx.Description = strings.Repeat("a", maxDescriptionLength+1)
+	if err := tx.validate(); err == nil || !strings.Contains(err.Error(), "description is longer") {
+		t.Errorf("expected error: %v", err)
+	}
+	tx.Description = ""
+	tx.Lines[1].Description = strings.Repeat("a", maxDescriptionLength+1)
+	if err := tx.validate(); err == nil || !strings.Contains(err.Error(), "line[1]") {
+		t.Errorf("expected error: %v", err)
+	}
+	tx.Lines[1].Description = "Rent"
 
 	tx.Lines = []transactionLine{}
 	if err := tx.validate(); err == nil {
@@ -211,7 +429,7 @@ func TestTransactions_Get(t *testing.T) {
 	}
 
 	router := mux.NewRouter()
-	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo)
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &loggingEventPublisher{log.NewNopLogger()}, nil, time.Now)
 
 	req := httptest.NewRequest("GET", fmt.Sprintf("/accounts/%s/transactions", accountID), nil)
 	req.Header.Set("x-user-id", base.ID())
@@ -228,7 +446,7 @@ func TestTransactions_Get(t *testing.T) {
 	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
 		t.Fatal(err)
 	}
-	if len(resp) != 2 {
+	if len(resp) != 2 || w.Header().Get(nextCursorHeaderName) != "" {
 		t.Errorf("got %d transactions: %#v", len(resp), resp)
 	}
 
@@ -244,6 +462,192 @@ func TestTransactions_Get(t *testing.T) {
 	}
 }
 
+func TestTransactions_GetPages(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"a": 1000, "b": 1000})
+	for i := 0; i < 3; i++ {
+		tx := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Status:    TransactionPosted,
+			Lines: []transactionLine{
+				{AccountID: "a", Purpose: ACHDebit, Amount: 10},
+				{AccountID: "b", Purpose: ACHCredit, Amount: 10},
+			},
+		}
+		if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &mockEventPublisher{}, nil, time.Now)
+	get := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/accounts/a/transactions"+query, nil)
+		req.Header.Set("x-user-id", base.ID())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	// the initial deposit and three transfers
+	var ids []string
+	query := "?limit=3"
+	for i := 0; i < 4 && query != ""; i++ {
+		w := get(query)
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+		var resp []transaction
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		for j := range resp {
+			ids = append(ids, resp[j].ID)
+		}
+		query = ""
+		if next := w.Header().Get(nextCursorHeaderName); next != "" {
+			query = "?limit=3&cursor=" + next
+		}
+	}
+	if len(ids) != 4 || query != "" {
+		t.Errorf("unexpected transactions: %v", ids)
+	}
+
+	if w := get("?limit=1001"); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := get("?cursor=" + base.ID()); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
+
+func TestTransactions__exportCSV(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"a": 1000, "b": 1000})
+	tx := transaction{
+		ID:          base.ID(),
+		Timestamp:   time.Date(2020, time.March, 4, 12, 0, 0, 0, time.UTC),
+		Status:      TransactionPosted,
+		Description: "rent, march",
+		Lines: []transactionLine{
+			{AccountID: "a", Purpose: ACHDebit, Amount: 250},
+			{AccountID: "b", Purpose: ACHCredit, Amount: 250, Description: "landlord"},
+		},
+	}
+	if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &mockEventPublisher{}, nil, time.Now)
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("x-user-id", base.ID())
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	w := get("/accounts/a/transactions?format=csv&purpose=achdebit")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/csv; charset=utf-8" {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	expected := "timestamp,transactionId,status,purpose,amount,description\n" +
+		"2020-03-04T12:00:00Z," + tx.ID + ",posted,achdebit,-250,\"rent, march\"\n"
+	if v := w.Body.String(); v != expected {
+		t.Errorf("unexpected CSV:\n%s", v)
+	}
+
+	// the initial deposit and the transfer, only including b's lines
+	w = get("/accounts/b/transactions?format=csv&columns=transactionId,accountId,amount,description")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	if len(lines) != 3 || lines[0] != "transactionId,accountId,amount,description" || lines[1] != tx.ID+",b,250,landlord" {
+		t.Errorf("unexpected CSV: %v", lines)
+	}
+
+	if w := get("/accounts/a/transactions?format=csv&columns=amount,balance"); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := get("/accounts/a/transactions?format=xml"); w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
+
+func TestTransactions__streamNDJSON(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"a": 5000, "b": 0})
+	start := time.Date(2020, time.March, 4, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < maxAccountTransactionsLimit+1; i++ {
+		tx := transaction{
+			ID:        base.ID(),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+			Status:    TransactionPosted,
+			Lines: []transactionLine{
+				{AccountID: "a", Purpose: ACHDebit, Amount: 1},
+				{AccountID: "b", Purpose: ACHCredit, Amount: 1},
+			},
+		}
+		if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &mockEventPublisher{}, nil, time.Now)
+	req := httptest.NewRequest("GET", "/accounts/b/transactions?format=ndjson", nil)
+	req.Header.Set("x-user-id", base.ID())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+
+	// every transaction is streamed across pages, one per line
+	dec := json.NewDecoder(w.Body)
+	seen := make(map[string]bool)
+	for dec.More() {
+		var tx transaction
+		if err := dec.Decode(&tx); err != nil {
+			t.Fatal(err)
+		}
+		seen[tx.ID] = true
+	}
+	if len(seen) != maxAccountTransactionsLimit+1 {
+		t.Errorf("streamed %d transactions", len(seen))
+	}
+}
+
+func TestTransactions__readTransactionPage(t *testing.T) {
+	req := httptest.NewRequest("GET", "/accounts/a/transactions?limit=10&startDate=2020-03-01&endDate=2020-03-31&purpose=ACHDebit&minAmount=5&maxAmount=100&tag=order-1&tag=batch-2", nil)
+	page, err := readTransactionPage(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := transactionPage{
+		Limit:     10,
+		StartDate: time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2020, time.April, 1, 0, 0, 0, 0, time.UTC),
+		Purpose:   ACHDebit,
+		MinAmount: 5,
+		MaxAmount: 100,
+		Tags:      []string{"order-1", "batch-2"},
+	}
+	if !reflect.DeepEqual(page, expected) {
+		t.Errorf("page=%#v", page)
+	}
+
+	req = httptest.NewRequest("GET", "/accounts/a/transactions?endDate=2020-03-31T08:00:00Z", nil)
+	if page, err := readTransactionPage(req); err != nil || page.Limit != 100 || !page.EndDate.Equal(time.Date(2020, time.March, 31, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("page=%#v error=%v", page, err)
+	}
+
+	for _, query := range []string{"limit=0", "startDate=March", "purpose=cash", "minAmount=-1", "maxAmount=ten"} {
+		if _, err := readTransactionPage(httptest.NewRequest("GET", "/accounts/a/transactions?"+query, nil)); err == nil {
+			t.Errorf("%s: expected error", query)
+		}
+	}
+}
+
 func TestTransactions_Create(t *testing.T) {
 	accountRepo := &testAccountRepository{
 		accounts: []*accounts.Account{
@@ -254,7 +658,7 @@ func TestTransactions_Create(t *testing.T) {
 	transactionRepo := &mockTransactionRepository{}
 
 	router := mux.NewRouter()
-	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo)
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &loggingEventPublisher{log.NewNopLogger()}, nil, time.Now)
 
 	var body bytes.Buffer
 	json.NewEncoder(&body).Encode(createTransactionRequest{
@@ -298,12 +702,166 @@ func TestTransactions_Create(t *testing.T) {
 	}
 }
 
+func TestTransactions_CreateWithID(t *testing.T) {
+	accountRepo := &testAccountRepository{}
+	transactionRepo := &mockTransactionRepository{}
+
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &loggingEventPublisher{log.NewNopLogger()}, nil, time.Now)
+
+	create := func(id string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(createTransactionRequest{
+			ID: id,
+			Lines: []transactionLine{
+				{AccountID: base.ID(), Purpose: ACHDebit, Amount: 4121},
+				{AccountID: base.ID(), Purpose: ACHCredit, Amount: 4121},
+			},
+		})
+		req := httptest.NewRequest("POST", "/accounts/transactions", &body)
+		req.Header.Set("x-user-id", base.ID())
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+
+	if w := create("1C4D2E3F-aaaa-4bbb-8ccc-0123456789ab"); w.Code != http.StatusOK {
+		t.Errorf("got %d: %s", w.Code, w.Body.String())
+	}
+	if transactionRepo.created.ID != "1c4d2e3f-aaaa-4bbb-8ccc-0123456789ab" {
+		t.Errorf("unexpected transaction ID %q", transactionRepo.created.ID)
+	}
+
+	if w := create("not-a-uuid"); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
+func TestTransactions_CreateIdempotencyKey(t *testing.T) {
+	accountRepo, transactionRepo := newInMemoryRepositories()
+	for _, id := range []string{"a", "b"} {
+		acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: id, RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+		if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deposit := transaction{ID: base.ID(), Timestamp: time.Now(), Lines: []transactionLine{{AccountID: "a", Purpose: ACHCredit, Amount: 1000}}}
+	if err := transactionRepo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &mockEventPublisher{}, nil, time.Now)
+
+	create := func(key string, amount int) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		json.NewEncoder(&body).Encode(createTransactionRequest{
+			Lines: []transactionLine{
+				{AccountID: "a", Purpose: ACHDebit, Amount: amount},
+				{AccountID: "b", Purpose: ACHCredit, Amount: amount},
+			},
+		})
+		req := httptest.NewRequest("POST", "/accounts/transactions", &body)
+		req.Header.Set("x-user-id", base.ID())
+		req.Header.Set("X-Idempotency-Key", key)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+	decode := func(w *httptest.ResponseRecorder) transaction {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", w.Code, w.Body.String())
+		}
+		var tx transaction
+		if err := json.NewDecoder(w.Body).Decode(&tx); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	// replaying the key returns the first transaction without posting it again
+	key := base.ID()
+	first := decode(create(key, 100))
+	if replay := decode(create(key, 100)); replay.ID != first.ID {
+		t.Errorf("replayed transaction=%s, expected %s", replay.ID, first.ID)
+	}
+	if other := decode(create(base.ID(), 100)); other.ID == first.ID {
+		t.Errorf("expected a new transaction for another key")
+	}
+
+	// a key can't be reused for another transaction
+	if w := create(key, 200); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d", w.Code)
+	}
+	if w := create(strings.Repeat("k", maxIdempotencyKeyLength+1), 100); w.Code != http.StatusBadRequest {
+		t.Errorf("got %d", w.Code)
+	}
+
+	accts, _ := accountRepo.GetAccounts(context.Background(), []string{"a"})
+	if len(accts) != 1 || accts[0].Balance != 800 {
+		t.Errorf("unexpected accounts: %#v", accts)
+	}
+}
+
+func TestTransactions_GetTransaction(t *testing.T) {
+	transactionRepo := &mockTransactionRepository{
+		transactions: []transaction{
+			{
+				ID:        base.ID(),
+				Timestamp: time.Now(),
+				Status:    TransactionPosted,
+				Lines: []transactionLine{
+					{AccountID: base.ID(), Purpose: ACHDebit, Amount: 1000},
+					{AccountID: base.ID(), Purpose: ACHCredit, Amount: 1000},
+				},
+			},
+		},
+	}
+
+	router := mux.NewRouter()
+	addTransactionRoutes(log.NewNopLogger(), router, &testAccountRepository{}, transactionRepo, nil, &loggingEventPublisher{log.NewNopLogger()}, nil, time.Now)
+
+	req := httptest.NewRequest("GET", fmt.Sprintf("/accounts/transactions/%s", transactionRepo.transactions[0].ID), nil)
+	req.Header.Set("x-user-id", base.ID())
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	w.Flush()
+
+	if w.Code != http.StatusOK {
+		t.Errorf("got %d", w.Code)
+	}
+	var tx transaction
+	if err := json.NewDecoder(w.Body).Decode(&tx); err != nil {
+		t.Fatal(err)
+	}
+	if tx.ID != transactionRepo.transactions[0].ID || len(tx.Lines) != 2 {
+		t.Errorf("unexpected transaction: %#v", tx)
+	}
+
+	// set an error and make sure we respond as such
+	transactionRepo.err = errors.New("bad thing")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	w.Flush()
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got %d", w.Code)
+	}
+}
+
 func TestTransactions_CreateInvalid(t *testing.T) {
 	accountRepo := &testAccountRepository{}
 	transactionRepo := &mockTransactionRepository{}
 
 	router := mux.NewRouter()
-	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo)
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &loggingEventPublisher{log.NewNopLogger()}, nil, time.Now)
 
 	var body bytes.Buffer
 	json.NewEncoder(&body).Encode(createTransactionRequest{
@@ -333,6 +891,7 @@ func TestTransactions__createTransactionReversal(t *testing.T) {
 			{
 				ID:        base.ID(),
 				Timestamp: time.Now(),
+				Status:    TransactionPosted,
 				Lines: []transactionLine{
 					{
 						AccountID: base.ID(),
@@ -350,7 +909,7 @@ func TestTransactions__createTransactionReversal(t *testing.T) {
 	}
 
 	router := mux.NewRouter()
-	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo)
+	addTransactionRoutes(log.NewNopLogger(), router, accountRepo, transactionRepo, nil, &loggingEventPublisher{log.NewNopLogger()}, nil, time.Now)
 
 	req := httptest.NewRequest("POST", fmt.Sprintf("/accounts/transactions/%s/reversal", transactionRepo.transactions[0].ID), nil)
 	req.Header.Set("x-user-id", base.ID())
@@ -376,6 +935,9 @@ func TestTransactions__createTransactionReversal(t *testing.T) {
 	if tx.ID != transactionRepo.created.ID {
 		t.Errorf("transactions don't match")
 	}
+	if expected := "Reversal of transaction " + transactionRepo.transactions[0].ID; tx.Description != expected {
+		t.Errorf("description=%q", tx.Description)
+	}
 
 	// set an error and ensure we fail
 	transactionRepo.err = errors.New("bad thing")
@@ -389,6 +951,62 @@ func TestTransactions__createTransactionReversal(t *testing.T) {
 	}
 }
 
+func TestTransactions__reverseTransactionTwice(t *testing.T) {
+	accountRepo, transactionRepo := newInMemoryRepositories()
+	for _, id := range []string{"a", "b"} {
+		acct := &accounts.Account{ID: id, CustomerID: "customer", AccountNumber: id, RoutingNumber: defaultRoutingNumber, Status: "open", Type: "checking"}
+		if err := accountRepo.CreateAccount(acct.CustomerID, acct); err != nil {
+			t.Fatal(err)
+		}
+		deposit := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Lines:     []transactionLine{{AccountID: id, Purpose: ACHCredit, Amount: 1000}},
+		}
+		if err := transactionRepo.createTransaction(context.Background(), deposit, createTransactionOpts{InitialDeposit: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	original := transaction{
+		ID:        base.ID(),
+		Timestamp: time.Now(),
+		Lines: []transactionLine{
+			{AccountID: "a", Purpose: ACHDebit, Amount: 300},
+			{AccountID: "b", Purpose: ACHCredit, Amount: 300},
+		},
+	}
+	if err := transactionRepo.createTransaction(context.Background(), original, createTransactionOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	sandboxNow := time.Date(2030, time.January, 1, 12, 0, 0, 0, time.UTC)
+	svc := &transactionService{
+		logger: log.NewNopLogger(),
+		repo:   transactionRepo,
+		events: &mockEventPublisher{},
+		clock:  func() time.Time { return sandboxNow },
+	}
+	reversal, err := svc.ReverseTransaction(context.Background(), original.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reversal.Timestamp.Equal(sandboxNow) {
+		t.Errorf("reversal posted at %v", reversal.Timestamp)
+	}
+	if _, err := svc.ReverseTransaction(context.Background(), original.ID); err == nil {
+		t.Error("expected error reversing twice")
+	}
+	accts, err := accountRepo.GetAccounts(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, acct := range accts {
+		if acct.Balance != 1000 {
+			t.Errorf("account=%s balance=%d", acct.ID, acct.Balance)
+		}
+	}
+}
+
 func TestTransactions_getTransactionID(t *testing.T) {
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest("GET", "/foo", nil)
@@ -0,0 +1,139 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// travelNotice is a customer telling us they'll be using an account's cards abroad. StartDate and EndDate are
+// inclusive UTC days formatted as YYYY-MM-DD.
+type travelNotice struct {
+	ID         string   `json:"id"`
+	AccountID  string   `json:"accountId"`
+	CustomerID string   `json:"customerId"`
+	StartDate  string   `json:"startDate"`
+	EndDate    string   `json:"endDate"`
+	Countries  []string `json:"countries"`
+
+	CreatedAt    time.Time `json:"createdAt"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+type travelNoticeRepository interface {
+	Ping() error
+	Close() error
+
+	createTravelNotice(notice *travelNotice) error
+	updateTravelNotice(notice *travelNotice) error
+	deleteTravelNotice(accountID, noticeID string) error
+
+	// getTravelNotice returns nil if the notice doesn't exist on accountID.
+	getTravelNotice(accountID, noticeID string) (*travelNotice, error)
+	getTravelNotices(accountID string) ([]*travelNotice, error)
+}
+
+type sqlTravelNoticeRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlTravelNoticeRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlTravelNoticeRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlTravelNoticeRepository) createTravelNotice(notice *travelNotice) error {
+	query := `insert into travel_notices (notice_id, account_id, customer_id, start_date, end_date, countries, created_at, last_modified) values (?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("createTravelNotice: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	countries := strings.Join(notice.Countries, ",")
+	if _, err := stmt.Exec(notice.ID, notice.AccountID, notice.CustomerID, notice.StartDate, notice.EndDate, countries, notice.CreatedAt, notice.LastModified); err != nil {
+		return fmt.Errorf("createTravelNotice: notice=%s: %v", notice.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlTravelNoticeRepository) updateTravelNotice(notice *travelNotice) error {
+	query := `update travel_notices set start_date = ?, end_date = ?, countries = ?, last_modified = ? where notice_id = ? and account_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("updateTravelNotice: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	countries := strings.Join(notice.Countries, ",")
+	if _, err := stmt.Exec(notice.StartDate, notice.EndDate, countries, notice.LastModified, notice.ID, notice.AccountID); err != nil {
+		return fmt.Errorf("updateTravelNotice: notice=%s: %v", notice.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlTravelNoticeRepository) deleteTravelNotice(accountID, noticeID string) error {
+	query := `update travel_notices set deleted_at = ? where notice_id = ? and account_id = ? and deleted_at is null;`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("deleteTravelNotice: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(time.Now(), noticeID, accountID); err != nil {
+		return fmt.Errorf("deleteTravelNotice: notice=%s: %v", noticeID, err)
+	}
+	return nil
+}
+
+func (r *sqlTravelNoticeRepository) getTravelNotice(accountID, noticeID string) (*travelNotice, error) {
+	notices, err := r.queryTravelNotices(`notice_id = ? and account_id = ?`, noticeID, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getTravelNotice: %v", err)
+	}
+	if len(notices) == 0 {
+		return nil, nil
+	}
+	return notices[0], nil
+}
+
+func (r *sqlTravelNoticeRepository) getTravelNotices(accountID string) ([]*travelNotice, error) {
+	notices, err := r.queryTravelNotices(`account_id = ?`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("getTravelNotices: %v", err)
+	}
+	return notices, nil
+}
+
+func (r *sqlTravelNoticeRepository) queryTravelNotices(where string, args ...interface{}) ([]*travelNotice, error) {
+	query := fmt.Sprintf(`select notice_id, account_id, customer_id, start_date, end_date, countries, created_at, last_modified
+from travel_notices where %s and deleted_at is null order by start_date, created_at;`, where)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*travelNotice
+	for rows.Next() {
+		var notice travelNotice
+		var countries string
+		if err := rows.Scan(&notice.ID, &notice.AccountID, &notice.CustomerID, &notice.StartDate, &notice.EndDate, &countries, &notice.CreatedAt, &notice.LastModified); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		notice.Countries = strings.Split(countries, ",")
+		out = append(out, &notice)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,370 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// Card lines can carry the country of their merchant. When CARD_HOME_COUNTRY is set, card transactions from
+// merchants in other countries are declined unless each debited account has a travel notice covering the country on
+// the transaction's (UTC) day. Travel notices are also sent to the fraud scoring service so it can relax its own
+// location rules.
+
+var (
+	errGeoBlocked = errors.New("card transaction is outside the home country")
+
+	// countryRegex matches ISO 3166-1 alpha-2 country codes
+	countryRegex = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+const (
+	travelNoticeDateFormat = "2006-01-02"
+
+	// maxTravelNoticeDays is the longest a travel notice can cover
+	maxTravelNoticeDays = 366
+)
+
+// merchantCountry returns the merchant country of tx's card lines, which validate requires to agree.
+func (t transaction) merchantCountry() string {
+	for i := range t.Lines {
+		if t.Lines[i].MerchantCountry != "" {
+			return t.Lines[i].MerchantCountry
+		}
+	}
+	return ""
+}
+
+// readCardHomeCountry reads CARD_HOME_COUNTRY, which is empty when card transactions aren't blocked by country.
+func readCardHomeCountry() (string, error) {
+	v := strings.ToUpper(strings.TrimSpace(os.Getenv("CARD_HOME_COUNTRY")))
+	if v != "" && !countryRegex.MatchString(v) {
+		return "", fmt.Errorf("invalid CARD_HOME_COUNTRY %q, expected an ISO 3166-1 alpha-2 code", v)
+	}
+	return v, nil
+}
+
+// covers returns true if the notice includes country on the UTC day containing when.
+func (n *travelNotice) covers(when time.Time, country string) bool {
+	day := when.UTC().Format(travelNoticeDateFormat)
+	if day < n.StartDate || day > n.EndDate {
+		return false
+	}
+	for i := range n.Countries {
+		if n.Countries[i] == country {
+			return true
+		}
+	}
+	return false
+}
+
+// activeTravelNotices returns the notices of accounts debited by tx which cover its day. Countries aren't matched so
+// the fraud scoring service sees every trip in progress.
+func activeTravelNotices(repo travelNoticeRepository, tx transaction) ([]*travelNotice, error) {
+	var out []*travelNotice
+	checked := make(map[string]bool)
+	for i := range tx.Lines {
+		accountID := tx.Lines[i].AccountID
+		if tx.Lines[i].Purpose != ACHDebit || checked[accountID] {
+			continue
+		}
+		checked[accountID] = true
+
+		notices, err := repo.getTravelNotices(accountID)
+		if err != nil {
+			return nil, fmt.Errorf("account=%s: %v", accountID, err)
+		}
+		day := tx.Timestamp.UTC().Format(travelNoticeDateFormat)
+		for j := range notices {
+			if notices[j].StartDate <= day && day <= notices[j].EndDate {
+				out = append(out, notices[j])
+			}
+		}
+	}
+	return out, nil
+}
+
+// geoBlockingTransactionRepository declines card transactions from merchants outside homeCountry unless every
+// debited account has a travel notice for the merchant's country. Force posts, initial deposits and reversals
+// aren't checked.
+type geoBlockingTransactionRepository struct {
+	transactionRepository
+
+	homeCountry string
+	notices     travelNoticeRepository
+}
+
+func (r *geoBlockingTransactionRepository) createTransaction(ctx context.Context, tx transaction, opts createTransactionOpts) error {
+	if !opts.AllowOverdraft && !opts.InitialDeposit && !opts.Reversal {
+		if err := r.checkCountry(tx); err != nil {
+			return err
+		}
+	}
+	return r.transactionRepository.createTransaction(ctx, tx, opts)
+}
+
+func (r *geoBlockingTransactionRepository) checkCountry(tx transaction) error {
+	country := tx.merchantCountry()
+	if country == "" || country == r.homeCountry {
+		return nil
+	}
+	checked := make(map[string]bool)
+	for i := range tx.Lines {
+		accountID := tx.Lines[i].AccountID
+		if tx.Lines[i].Purpose != ACHDebit || checked[accountID] {
+			continue
+		}
+		checked[accountID] = true
+
+		notices, err := r.notices.getTravelNotices(accountID)
+		if err != nil {
+			return fmt.Errorf("checkCountry: account=%s: %v", accountID, err)
+		}
+		covered := false
+		for j := range notices {
+			if notices[j].covers(tx.Timestamp, country) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return fmt.Errorf("transaction=%s: %v: account=%s has no travel notice for %s", tx.ID, errGeoBlocked, accountID, country)
+		}
+	}
+	return nil
+}
+
+func addTravelNoticeRoutes(logger log.Logger, router *mux.Router, accountRepo accountRepository, repo travelNoticeRepository) {
+	router.Methods("GET").Path("/accounts/{accountId}/travel-notices").HandlerFunc(getTravelNotices(logger, accountRepo, repo))
+	router.Methods("POST").Path("/accounts/{accountId}/travel-notices").HandlerFunc(createTravelNotice(logger, accountRepo, repo))
+	router.Methods("GET").Path("/accounts/{accountId}/travel-notices/{noticeId}").HandlerFunc(getTravelNotice(logger, accountRepo, repo))
+	router.Methods("PUT").Path("/accounts/{accountId}/travel-notices/{noticeId}").HandlerFunc(updateTravelNotice(logger, accountRepo, repo))
+	router.Methods("DELETE").Path("/accounts/{accountId}/travel-notices/{noticeId}").HandlerFunc(deleteTravelNotice(logger, accountRepo, repo))
+}
+
+type travelNoticeRequest struct {
+	StartDate string   `json:"startDate"`
+	EndDate   string   `json:"endDate"`
+	Countries []string `json:"countries"`
+}
+
+// normalize uppercases and sorts the request's countries, dropping duplicates, and checks its dates.
+func (req *travelNoticeRequest) normalize() error {
+	start, err := time.Parse(travelNoticeDateFormat, req.StartDate)
+	if err != nil {
+		return fmt.Errorf("invalid startDate %q, expected YYYY-MM-DD", req.StartDate)
+	}
+	end, err := time.Parse(travelNoticeDateFormat, req.EndDate)
+	if err != nil {
+		return fmt.Errorf("invalid endDate %q, expected YYYY-MM-DD", req.EndDate)
+	}
+	if end.Before(start) {
+		return errors.New("endDate is before startDate")
+	}
+	if end.Sub(start) >= maxTravelNoticeDays*24*time.Hour {
+		return fmt.Errorf("travel notices can't cover more than %d days", maxTravelNoticeDays)
+	}
+	if len(req.Countries) == 0 {
+		return errors.New("travel notices need at least one country")
+	}
+	seen := make(map[string]bool)
+	var countries []string
+	for _, c := range req.Countries {
+		c = strings.ToUpper(strings.TrimSpace(c))
+		if !countryRegex.MatchString(c) {
+			return fmt.Errorf("invalid country %q, expected an ISO 3166-1 alpha-2 code", c)
+		}
+		if !seen[c] {
+			seen[c] = true
+			countries = append(countries, c)
+		}
+	}
+	sort.Strings(countries)
+	req.Countries = countries
+	return nil
+}
+
+func getTravelNotices(logger log.Logger, accountRepo accountRepository, repo travelNoticeRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		account := customerAccount(w, r, accountRepo)
+		if account == nil {
+			return
+		}
+
+		notices, err := repo.getTravelNotices(account.ID)
+		if err != nil {
+			logger.Log("travel", fmt.Sprintf("problem reading account=%s travel notices: %v", account.ID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if notices == nil {
+			notices = []*travelNotice{}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(notices)
+	}
+}
+
+func createTravelNotice(logger log.Logger, accountRepo accountRepository, repo travelNoticeRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		account := customerAccount(w, r, accountRepo)
+		if account == nil {
+			return
+		}
+
+		var req travelNoticeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := req.normalize(); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		now := time.Now()
+		notice := &travelNotice{
+			ID:           newID(),
+			AccountID:    account.ID,
+			CustomerID:   account.CustomerID,
+			StartDate:    req.StartDate,
+			EndDate:      req.EndDate,
+			Countries:    req.Countries,
+			CreatedAt:    now,
+			LastModified: now,
+		}
+		if err := repo.createTravelNotice(notice); err != nil {
+			logger.Log("travel", fmt.Sprintf("problem creating account=%s travel notice: %v", account.ID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		logger.Log("travel", fmt.Sprintf("created travel notice=%s for account=%s", notice.ID, account.ID), "requestID", moovhttp.GetRequestID(r))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(notice)
+	}
+}
+
+// readTravelNotice returns the notice from the route if it's on an account of the calling customer.
+func readTravelNotice(w http.ResponseWriter, r *http.Request, accountRepo accountRepository, repo travelNoticeRepository) *travelNotice {
+	account := customerAccount(w, r, accountRepo)
+	if account == nil {
+		return nil
+	}
+	notice, err := repo.getTravelNotice(account.ID, mux.Vars(r)["noticeId"])
+	if err != nil {
+		moovhttp.Problem(w, err)
+		return nil
+	}
+	if notice == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return nil
+	}
+	return notice
+}
+
+func getTravelNotice(logger log.Logger, accountRepo accountRepository, repo travelNoticeRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		notice := readTravelNotice(w, r, accountRepo, repo)
+		if notice == nil {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(notice)
+	}
+}
+
+func updateTravelNotice(logger log.Logger, accountRepo accountRepository, repo travelNoticeRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		notice := readTravelNotice(w, r, accountRepo, repo)
+		if notice == nil {
+			return
+		}
+
+		var req travelNoticeRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if req.StartDate == "" {
+			req.StartDate = notice.StartDate
+		}
+		if req.EndDate == "" {
+			req.EndDate = notice.EndDate
+		}
+		if len(req.Countries) == 0 {
+			req.Countries = notice.Countries
+		}
+		if err := req.normalize(); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		notice.StartDate, notice.EndDate, notice.Countries = req.StartDate, req.EndDate, req.Countries
+		notice.LastModified = time.Now()
+		if err := repo.updateTravelNotice(notice); err != nil {
+			logger.Log("travel", fmt.Sprintf("problem updating travel notice=%s: %v", notice.ID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(notice)
+	}
+}
+
+func deleteTravelNotice(logger log.Logger, accountRepo accountRepository, repo travelNoticeRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w, err := wrapResponseWriter(logger, w, r)
+		if err != nil {
+			return
+		}
+		notice := readTravelNotice(w, r, accountRepo, repo)
+		if notice == nil {
+			return
+		}
+		if err := repo.deleteTravelNotice(notice.AccountID, notice.ID); err != nil {
+			logger.Log("travel", fmt.Sprintf("problem deleting travel notice=%s: %v", notice.ID, err), "requestID", moovhttp.GetRequestID(r))
+			moovhttp.Problem(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
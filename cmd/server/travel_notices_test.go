@@ -0,0 +1,277 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	accounts "github.com/moov-io/accounts/client"
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestTravelNotices__lines(t *testing.T) {
+	tx := func(lines ...transactionLine) transaction {
+		return transaction{ID: base.ID(), Timestamp: time.Now(), Lines: lines}
+	}
+	debit := transactionLine{AccountID: "cardholder", Purpose: ACHDebit, Amount: 100}
+	card := func(country string) transactionLine {
+		return transactionLine{AccountID: "settlement", Purpose: Card, Amount: 100, MCC: "5812", MerchantCountry: country}
+	}
+
+	if err := tx(debit, card("FR")).validate(); err != nil {
+		t.Error(err)
+	}
+	cases := map[string]transaction{
+		"invalid merchantCountry":                      tx(debit, card("fr")),
+		"isn't a card line":                            tx(debit, transactionLine{AccountID: "settlement", Purpose: ACHCredit, Amount: 100, MerchantCountry: "FR"}),
+		"card lines with different merchant countries": tx(debit, debit, card("FR"), card("DE")),
+	}
+	for expected, tx := range cases {
+		if err := tx.validate(); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q error: %v", expected, err)
+		}
+	}
+}
+
+func TestTravelNotices__homeCountry(t *testing.T) {
+	defer os.Unsetenv("CARD_HOME_COUNTRY")
+
+	os.Setenv("CARD_HOME_COUNTRY", " us ")
+	if country, err := readCardHomeCountry(); err != nil || country != "US" {
+		t.Errorf("country=%q error=%v", country, err)
+	}
+	os.Setenv("CARD_HOME_COUNTRY", "USA")
+	if _, err := readCardHomeCountry(); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestTravelNotices__routes(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlTravelNoticeRepository{db.DB, log.NewNopLogger()}
+
+	account := &accounts.Account{ID: base.ID(), CustomerID: "customer"}
+	router := mux.NewRouter()
+	addTravelNoticeRoutes(log.NewNopLogger(), router, &testAccountRepository{accounts: []*accounts.Account{account}}, repo)
+
+	do := func(method, path, customerID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("x-user-id", "test")
+		req.Header.Set("X-Customer-ID", customerID)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		w.Flush()
+		return w
+	}
+	path := "/accounts/" + account.ID + "/travel-notices"
+
+	// other customers can't manage the account's notices
+	if w := do("POST", path, "other", `{"startDate":"2020-06-01","endDate":"2020-06-14","countries":["FR"]}`); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	for _, body := range []string{
+		`{"startDate":"2020-06-14","endDate":"2020-06-01","countries":["FR"]}`,
+		`{"startDate":"2020-06-01","endDate":"2021-06-14","countries":["FR"]}`,
+		`{"startDate":"June 1","endDate":"2020-06-14","countries":["FR"]}`,
+		`{"startDate":"2020-06-01","endDate":"2020-06-14","countries":["France"]}`,
+		`{"startDate":"2020-06-01","endDate":"2020-06-14"}`,
+	} {
+		if w := do("POST", path, "customer", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: bogus HTTP status: %d", body, w.Code)
+		}
+	}
+
+	w := do("POST", path, "customer", `{"startDate":"2020-06-01","endDate":"2020-06-14","countries":["it","FR","fr"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+	}
+	var created travelNotice
+	if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+		t.Fatal(err)
+	}
+	if created.ID == "" || created.AccountID != account.ID || !reflect.DeepEqual(created.Countries, []string{"FR", "IT"}) {
+		t.Fatalf("unexpected notice: %#v", created)
+	}
+
+	w = do("PUT", path+"/"+created.ID, "customer", `{"endDate":"2020-06-21"}`)
+	var updated travelNotice
+	if err := json.NewDecoder(w.Body).Decode(&updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.StartDate != "2020-06-01" || updated.EndDate != "2020-06-21" || !reflect.DeepEqual(updated.Countries, created.Countries) {
+		t.Errorf("unexpected notice: %#v", updated)
+	}
+
+	w = do("GET", path, "customer", "")
+	var notices []travelNotice
+	if err := json.NewDecoder(w.Body).Decode(&notices); err != nil {
+		t.Fatal(err)
+	}
+	if len(notices) != 1 || notices[0].EndDate != "2020-06-21" || !reflect.DeepEqual(notices[0].Countries, []string{"FR", "IT"}) {
+		t.Errorf("unexpected notices: %#v", notices)
+	}
+
+	if w := do("DELETE", path+"/"+created.ID, "customer", ""); w.Code != http.StatusOK {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+	if w := do("GET", path+"/"+created.ID, "customer", ""); w.Code != http.StatusNotFound {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
+
+func TestTravelNotices__geoBlock(t *testing.T) {
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"alice": 100000, "settlement": 0})
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	notices := &sqlTravelNoticeRepository{db.DB, log.NewNopLogger()}
+
+	repo := &geoBlockingTransactionRepository{transactionRepository: transactionRepo, homeCountry: "US", notices: notices}
+	purchase := func(country string, when time.Time) error {
+		return repo.createTransaction(context.Background(), transaction{
+			ID:        base.ID(),
+			Timestamp: when,
+			Status:    TransactionPosted,
+			Lines: []transactionLine{
+				{AccountID: "alice", Purpose: ACHDebit, Amount: 100},
+				{AccountID: "settlement", Purpose: Card, Amount: 100, MCC: "5812", MerchantCountry: country},
+			},
+		}, createTransactionOpts{})
+	}
+
+	now := time.Now()
+	if err := purchase("US", now); err != nil {
+		t.Error(err)
+	}
+	if err := purchase("", now); err != nil {
+		t.Error(err)
+	}
+	if err := purchase("FR", now); err == nil || !strings.Contains(err.Error(), errGeoBlocked.Error()) {
+		t.Errorf("expected error: %v", err)
+	}
+
+	err := notices.createTravelNotice(&travelNotice{
+		ID:        base.ID(),
+		AccountID: "alice",
+		StartDate: now.UTC().Format(travelNoticeDateFormat),
+		EndDate:   now.UTC().AddDate(0, 0, 7).Format(travelNoticeDateFormat),
+		Countries: []string{"FR", "IT"},
+		CreatedAt: now,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := purchase("FR", now); err != nil {
+		t.Error(err)
+	}
+	// the notice doesn't cover other countries or days
+	if err := purchase("DE", now); err == nil {
+		t.Error("expected error")
+	}
+	if err := purchase("IT", now.AddDate(0, 0, 8)); err == nil {
+		t.Error("expected error")
+	}
+	checkBalances(t, accountRepo, map[string]int32{"alice": 99700, "settlement": 300})
+}
+
+type capturingFraudScorer struct {
+	requests []fraudScoreRequest
+}
+
+func (s *capturingFraudScorer) score(ctx context.Context, req fraudScoreRequest) (*fraudDecision, error) {
+	s.requests = append(s.requests, req)
+	return &fraudDecision{Outcome: fraudOutcomeApprove}, nil
+}
+
+func TestTravelNotices__fraudScoring(t *testing.T) {
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	notices := &sqlTravelNoticeRepository{db.DB, log.NewNopLogger()}
+
+	now := time.Now()
+	for _, n := range []*travelNotice{
+		{ID: "current", AccountID: "alice", StartDate: now.UTC().AddDate(0, 0, -1).Format(travelNoticeDateFormat), EndDate: now.UTC().Format(travelNoticeDateFormat), Countries: []string{"FR"}},
+		{ID: "future", AccountID: "alice", StartDate: now.UTC().AddDate(0, 1, 0).Format(travelNoticeDateFormat), EndDate: now.UTC().AddDate(0, 1, 5).Format(travelNoticeDateFormat), Countries: []string{"JP"}},
+		{ID: "creditor", AccountID: "bob", StartDate: now.UTC().Format(travelNoticeDateFormat), EndDate: now.UTC().Format(travelNoticeDateFormat), Countries: []string{"MX"}},
+	} {
+		n.CreatedAt = now
+		if err := notices.createTravelNotice(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scorer := &capturingFraudScorer{}
+	screen := &fraudScreen{logger: log.NewNopLogger(), scorer: scorer, timeout: time.Second, travel: notices}
+	tx := transaction{ID: "tx", Timestamp: now, Lines: []transactionLine{
+		{AccountID: "alice", Purpose: ACHDebit, Amount: 100},
+		{AccountID: "bob", Purpose: ACHCredit, Amount: 100},
+	}}
+	if decision := screen.screen(context.Background(), tx); decision == nil || decision.Outcome != fraudOutcomeApprove {
+		t.Fatalf("unexpected decision: %#v", decision)
+	}
+	if len(scorer.requests) != 1 {
+		t.Fatalf("got %d requests", len(scorer.requests))
+	}
+	req := scorer.requests[0]
+	if req.ID != "tx" || len(req.TravelNotices) != 1 || req.TravelNotices[0].ID != "current" {
+		t.Errorf("unexpected request: %#v", req)
+	}
+
+	// notices are sent alongside the transaction's fields
+	bs, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(bs, &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["id"] != "tx" || body["lines"] == nil || body["travelNotices"] == nil {
+		t.Errorf("unexpected body: %s", bs)
+	}
+}
+
+func TestTravelNotices__merchantCountryStorage(t *testing.T) {
+	check := func(t *testing.T, repo *sqlTransactionRepository) {
+		tx := transaction{ID: base.ID(), Timestamp: time.Now(), Status: TransactionPosted, Lines: []transactionLine{
+			{AccountID: "alice", Purpose: ACHDebit, Amount: 100},
+			{AccountID: "settlement", Purpose: Card, Amount: 100, MCC: "5812", MerchantCountry: "FR"},
+		}}
+		if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Fatal(err)
+		}
+		found, err := repo.getTransaction(context.Background(), tx.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found == nil || found.merchantCountry() != "FR" {
+			t.Errorf("transaction=%#v", found)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	repo := createTestSqlTransactionRepository(t, sqliteDB.DB)
+	defer repo.Close()
+	check(t, repo)
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	repo = createTestSqlTransactionRepository(t, mysqlDB.DB)
+	defer repo.Close()
+	check(t, repo)
+}
@@ -0,0 +1,324 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// trialBalanceQuery selects the posted lines totaled in a trial balance.
+type trialBalanceQuery struct {
+	// AccountID optionally limits the trial balance to one account.
+	AccountID string
+
+	// StartDate and EndDate bound the transaction timestamps included, when set. EndDate is exclusive.
+	StartDate, EndDate time.Time
+
+	// Purpose, Department, Product and Region filter lines, when set.
+	Purpose                     TransactionPurpose
+	Department, Product, Region string
+}
+
+// segments returns the segment values lines must have.
+func (q trialBalanceQuery) segments() map[lineSegment]string {
+	out := make(map[lineSegment]string)
+	for segment, v := range map[lineSegment]string{segmentDepartment: q.Department, segmentProduct: q.Product, segmentRegion: q.Region} {
+		if v != "" {
+			out[segment] = v
+		}
+	}
+	return out
+}
+
+// matches returns true if the line passes the query's purpose and segment filters.
+func (q trialBalanceQuery) matches(line transactionLine) bool {
+	if q.AccountID != "" && line.AccountID != q.AccountID {
+		return false
+	}
+	if q.Purpose != "" && line.Purpose != q.Purpose {
+		return false
+	}
+	for segment, v := range q.segments() {
+		if segment.value(line) != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (q trialBalanceQuery) includes(t *transaction, line transactionLine) bool {
+	period := segmentTotalsQuery{Since: q.StartDate, Until: q.EndDate}
+	return period.includes(t, line) && q.matches(line)
+}
+
+// page returns the transactionPage which lists the transactions behind an account's trial balance totals.
+func (q trialBalanceQuery) page(limit int, cursor string) transactionPage {
+	return transactionPage{
+		Limit:      limit,
+		Cursor:     cursor,
+		StartDate:  q.StartDate,
+		EndDate:    q.EndDate,
+		Purpose:    q.Purpose,
+		Department: q.Department,
+		Product:    q.Product,
+		Region:     q.Region,
+		Posted:     true,
+	}
+}
+
+// trialBalanceAccount is the sum of an account's posted lines.
+type trialBalanceAccount struct {
+	AccountID string `json:"accountId"`
+	Name      string `json:"name,omitempty"`
+	Debits    int64  `json:"debits"`
+	Credits   int64  `json:"credits"`
+	Net       int64  `json:"net"`
+	Lines     int64  `json:"lines"`
+}
+
+func (a *trialBalanceAccount) add(line transactionLine) {
+	if line.Purpose == ACHDebit {
+		a.Debits += int64(line.Amount)
+	} else {
+		a.Credits += int64(line.Amount)
+	}
+	a.Net = a.Credits - a.Debits
+	a.Lines++
+}
+
+// mergeTrialBalance combines totals of the same account and orders them by account ID.
+func mergeTrialBalance(totals []trialBalanceAccount) []trialBalanceAccount {
+	byAccount := make(map[string]*trialBalanceAccount)
+	for i := range totals {
+		a, ok := byAccount[totals[i].AccountID]
+		if !ok {
+			a = &trialBalanceAccount{AccountID: totals[i].AccountID}
+			byAccount[a.AccountID] = a
+		}
+		a.Credits += totals[i].Credits
+		a.Debits += totals[i].Debits
+		a.Lines += totals[i].Lines
+		a.Net = a.Credits - a.Debits
+	}
+	out := make([]trialBalanceAccount, 0, len(byAccount))
+	for _, a := range byAccount {
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AccountID < out[j].AccountID })
+	return out
+}
+
+// trialBalance lists every account's debits and credits over a period. Total debits equal total credits unless
+// lines are filtered out or some postings (such as initial deposits) have only one side.
+type trialBalance struct {
+	Accounts []trialBalanceAccount `json:"accounts"`
+	Debits   int64                 `json:"debits"`
+	Credits  int64                 `json:"credits"`
+	Balanced bool                  `json:"balanced"`
+}
+
+// accountActivity drills into one account of a trial balance, listing the transactions behind its totals.
+type accountActivity struct {
+	trialBalanceAccount
+
+	Transactions []transaction `json:"transactions"`
+	Next         string        `json:"next,omitempty"`
+}
+
+// transactionDetail drills into one transaction of an account's activity. Lines are the transaction's lines
+// which pass the filters and so are counted in the trial balance.
+type transactionDetail struct {
+	Transaction *transaction      `json:"transaction"`
+	Lines       []transactionLine `json:"lines"`
+	Debits      int64             `json:"debits"`
+	Credits     int64             `json:"credits"`
+}
+
+// readTrialBalanceQuery reads the ?startDate, ?endDate, ?purpose, ?department, ?product and ?region filters along
+// with the ?limit and ?cursor of drill-downs. Dates are read as they are for an account's transactions.
+func readTrialBalanceQuery(r *http.Request) (trialBalanceQuery, transactionPage, error) {
+	page, err := readTransactionPage(r)
+	if err != nil {
+		return trialBalanceQuery{}, page, err
+	}
+	q := trialBalanceQuery{
+		StartDate:  page.StartDate,
+		EndDate:    page.EndDate,
+		Purpose:    page.Purpose,
+		Department: r.URL.Query().Get("department"),
+		Product:    r.URL.Query().Get("product"),
+		Region:     r.URL.Query().Get("region"),
+	}
+	return q, q.page(page.Limit, page.Cursor), nil
+}
+
+// getTrialBalance is an admin route which totals each account's posted lines.
+func getTrialBalance(logger log.Logger, accountRepo accountRepository, repo transactionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q, _, err := readTrialBalanceQuery(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		totals, err := repo.getTrialBalance(q)
+		if err != nil {
+			logger.Log("trialBalance", fmt.Sprintf("problem reading trial balance: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if err := nameTrialBalanceAccounts(accountRepo, totals); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		tb := trialBalance{Accounts: totals}
+		for i := range totals {
+			tb.Debits += totals[i].Debits
+			tb.Credits += totals[i].Credits
+		}
+		tb.Balanced = tb.Debits == tb.Credits
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(tb)
+	}
+}
+
+// nameTrialBalanceAccounts fills in the name of each account which still exists.
+func nameTrialBalanceAccounts(accountRepo accountRepository, totals []trialBalanceAccount) error {
+	var accountIDs []string
+	for i := range totals {
+		accountIDs = append(accountIDs, totals[i].AccountID)
+	}
+	accts, err := accountRepo.GetAccounts(context.Background(), accountIDs)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]string)
+	for i := range accts {
+		names[accts[i].ID] = accts[i].Name
+	}
+	for i := range totals {
+		totals[i].Name = names[totals[i].AccountID]
+	}
+	return nil
+}
+
+// getTrialBalanceAccount is an admin route which returns an account's trial balance totals along with a page of
+// the transactions behind them, newest first.
+func getTrialBalanceAccount(logger log.Logger, accountRepo accountRepository, repo transactionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q, page, err := readTrialBalanceQuery(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		q.AccountID = mux.Vars(r)["accountId"]
+
+		totals, err := repo.getTrialBalance(q)
+		if err != nil {
+			logger.Log("trialBalance", fmt.Sprintf("problem reading account=%s trial balance: %v", q.AccountID, err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if len(totals) == 0 {
+			totals = []trialBalanceAccount{{AccountID: q.AccountID}}
+		}
+		if err := nameTrialBalanceAccounts(accountRepo, totals); err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		activity := accountActivity{trialBalanceAccount: totals[0]}
+		activity.Transactions, activity.Next, err = repo.getAccountTransactions(r.Context(), q.AccountID, page)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if activity.Transactions == nil {
+			activity.Transactions = []transaction{}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(activity)
+	}
+}
+
+// getTrialBalanceTransaction is an admin route which returns a transaction along with its lines which pass the
+// filters and ?accountId, when set.
+func getTrialBalanceTransaction(logger log.Logger, repo transactionRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q, _, err := readTrialBalanceQuery(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		q.AccountID = r.URL.Query().Get("accountId")
+
+		tx, err := repo.getTransaction(r.Context(), mux.Vars(r)["transactionId"])
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if tx == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		detail := transactionDetail{Transaction: tx, Lines: []transactionLine{}}
+		for _, line := range tx.Lines {
+			if !q.includes(tx, line) {
+				continue
+			}
+			detail.Lines = append(detail.Lines, line)
+			if line.Purpose == ACHDebit {
+				detail.Debits += int64(line.Amount)
+			} else {
+				detail.Credits += int64(line.Amount)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(detail)
+	}
+}
+
+// trialBalanceSegmentFilters returns the SQL conditions and arguments for the query's segment filters on lines l.
+func trialBalanceSegmentFilters(segments map[lineSegment]string) ([]string, []interface{}) {
+	var where []string
+	var args []interface{}
+	for _, segment := range []lineSegment{segmentDepartment, segmentProduct, segmentRegion} {
+		if v, ok := segments[segment]; ok {
+			where, args = append(where, fmt.Sprintf("l.%s = ?", segment)), append(args, v)
+		}
+	}
+	return where, args
+}
+
+// segments returns the segment values the account's line must have, like trialBalanceQuery.segments.
+func (p transactionPage) segments() map[lineSegment]string {
+	return trialBalanceQuery{Department: p.Department, Product: p.Product, Region: p.Region}.segments()
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/moov-io/base"
+)
+
+func TestTrialBalance__repository(t *testing.T) {
+	defer func(values segmentValues) { configuredSegments = values }(configuredSegments)
+	configuredSegments = segmentValues{segmentDepartment: {"sales": true, "ops": true}}
+
+	check := func(t *testing.T, repo transactionRepository) {
+		now := time.Now()
+		post := func(status TransactionStatus, when time.Time, lines ...transactionLine) {
+			t.Helper()
+			tx := transaction{ID: base.ID(), Timestamp: when, Status: status, Lines: lines}
+			if err := repo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		post(TransactionPosted, now,
+			transactionLine{AccountID: "expenses", Purpose: ACHDebit, Amount: 500, Department: "sales"},
+			transactionLine{AccountID: "cash", Purpose: ACHCredit, Amount: 500, Department: "sales"},
+		)
+		post(TransactionPosted, now.Add(-48*time.Hour),
+			transactionLine{AccountID: "expenses", Purpose: ACHDebit, Amount: 200, Department: "ops"},
+			transactionLine{AccountID: "cash", Purpose: ACHCredit, Amount: 200},
+		)
+		post(TransactionPending, now,
+			transactionLine{AccountID: "expenses", Purpose: ACHDebit, Amount: 1000},
+			transactionLine{AccountID: "cash", Purpose: ACHCredit, Amount: 1000},
+		)
+
+		totals, err := repo.getTrialBalance(trialBalanceQuery{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []trialBalanceAccount{
+			{AccountID: "cash", Credits: 700, Net: 700, Lines: 2},
+			{AccountID: "expenses", Debits: 700, Net: -700, Lines: 2},
+		}
+		if !reflect.DeepEqual(totals, expected) {
+			t.Errorf("totals=%#v", totals)
+		}
+
+		totals, err = repo.getTrialBalance(trialBalanceQuery{AccountID: "expenses", StartDate: now.Add(-time.Hour)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != 1 || totals[0] != (trialBalanceAccount{AccountID: "expenses", Debits: 500, Net: -500, Lines: 1}) {
+			t.Errorf("totals=%#v", totals)
+		}
+
+		totals, err = repo.getTrialBalance(trialBalanceQuery{Department: "ops", Purpose: ACHDebit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != 1 || totals[0] != (trialBalanceAccount{AccountID: "expenses", Debits: 200, Net: -200, Lines: 1}) {
+			t.Errorf("totals=%#v", totals)
+		}
+
+		// the account's activity only lists posted transactions matching the filters
+		txs, _, err := repo.getAccountTransactions(context.Background(), "cash", transactionPage{Limit: 10, Posted: true})
+		if err != nil || len(txs) != 2 {
+			t.Errorf("transactions=%d error=%v", len(txs), err)
+		}
+		txs, _, err = repo.getAccountTransactions(context.Background(), "cash", transactionPage{Limit: 10, Posted: true, Department: "sales"})
+		if err != nil || len(txs) != 1 || txs[0].Lines[0].Amount != 500 {
+			t.Errorf("transactions=%#v error=%v", txs, err)
+		}
+	}
+
+	_, transactionRepo := createTestLedger(t, map[string]int{})
+	check(t, transactionRepo)
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	repo := createTestSqlTransactionRepository(t, sqliteDB.DB)
+	defer repo.Close()
+	check(t, repo)
+}
+
+func TestTrialBalance__drillDown(t *testing.T) {
+	defer func(values segmentValues) { configuredSegments = values }(configuredSegments)
+	configuredSegments = segmentValues{segmentRegion: {"emea": true, "apac": true}}
+
+	accountRepo, transactionRepo := createTestLedger(t, map[string]int{"cash": 0, "revenue": 0})
+	var txIDs []string
+	for _, region := range []string{"emea", "apac", "emea"} {
+		tx := transaction{
+			ID:        base.ID(),
+			Timestamp: time.Now(),
+			Status:    TransactionPosted,
+			Lines: []transactionLine{
+				{AccountID: "cash", Purpose: ACHDebit, Amount: 100, Region: region},
+				{AccountID: "revenue", Purpose: ACHCredit, Amount: 100, Region: region},
+			},
+		}
+		if err := transactionRepo.createTransaction(context.Background(), tx, createTransactionOpts{AllowOverdraft: true}); err != nil {
+			t.Fatal(err)
+		}
+		txIDs = append(txIDs, tx.ID)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/trial-balance", getTrialBalance(log.NewNopLogger(), accountRepo, transactionRepo))
+	router.HandleFunc("/trial-balance/accounts/{accountId}", getTrialBalanceAccount(log.NewNopLogger(), accountRepo, transactionRepo))
+	router.HandleFunc("/trial-balance/transactions/{transactionId}", getTrialBalanceTransaction(log.NewNopLogger(), transactionRepo))
+	get := func(path string, out interface{}) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		w.Flush()
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: bogus HTTP status: %d: %s", path, w.Code, w.Body.String())
+		}
+		if err := json.NewDecoder(w.Body).Decode(out); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tb trialBalance
+	get("/trial-balance?region=emea", &tb)
+	if !tb.Balanced || tb.Debits != 200 || len(tb.Accounts) != 2 {
+		t.Errorf("unexpected trial balance: %#v", tb)
+	}
+
+	var activity accountActivity
+	get("/trial-balance/accounts/cash?region=emea&limit=1", &activity)
+	if activity.Debits != 200 || activity.Lines != 2 || len(activity.Transactions) != 1 || activity.Next == "" {
+		t.Errorf("unexpected activity: %#v", activity)
+	}
+	get(fmt.Sprintf("/trial-balance/accounts/cash?region=emea&limit=1&cursor=%s", activity.Next), &activity)
+	if len(activity.Transactions) != 1 || activity.Transactions[0].ID != txIDs[0] {
+		t.Errorf("unexpected activity: %#v", activity)
+	}
+	get("/trial-balance/accounts/missing", &activity)
+	if activity.AccountID != "missing" || activity.Lines != 0 || len(activity.Transactions) != 0 {
+		t.Errorf("unexpected activity: %#v", activity)
+	}
+
+	var detail transactionDetail
+	get(fmt.Sprintf("/trial-balance/transactions/%s?accountId=revenue", txIDs[1]), &detail)
+	if detail.Transaction.ID != txIDs[1] || len(detail.Lines) != 1 || detail.Credits != 100 || detail.Debits != 0 {
+		t.Errorf("unexpected detail: %#v", detail)
+	}
+	get(fmt.Sprintf("/trial-balance/transactions/%s?region=emea", txIDs[1]), &detail)
+	if len(detail.Lines) != 0 {
+		t.Errorf("unexpected detail: %#v", detail)
+	}
+}
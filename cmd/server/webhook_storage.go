@@ -0,0 +1,150 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+type webhookDeliveryStatus string
+
+const (
+	webhookDelivered webhookDeliveryStatus = "delivered"
+	webhookFailed    webhookDeliveryStatus = "failed"
+)
+
+// webhookDelivery is one attempt at sending an event to the webhook endpoint.
+type webhookDelivery struct {
+	ID        string                `json:"id"`
+	EventID   string                `json:"eventId"`
+	EventType string                `json:"eventType"`
+	URL       string                `json:"url"`
+	Status    webhookDeliveryStatus `json:"status"`
+
+	// ResponseCode is the HTTP status returned by the endpoint, or zero if no response was read.
+	ResponseCode int    `json:"responseCode"`
+	Error        string `json:"error,omitempty"`
+
+	// Payload is the event body which was sent, kept so the event can be redelivered.
+	Payload json.RawMessage `json:"payload"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// webhookDeliveryFilter limits which deliveries are returned. Empty fields match every delivery.
+type webhookDeliveryFilter struct {
+	EventID   string
+	EventType string
+	Status    webhookDeliveryStatus
+
+	Since time.Time
+	Until time.Time
+
+	Limit int
+}
+
+type webhookRepository interface {
+	Ping() error
+	Close() error
+
+	recordDelivery(delivery *webhookDelivery) error
+	getDelivery(deliveryID string) (*webhookDelivery, error)
+
+	// getDeliveries returns deliveries matching filter, newest first.
+	getDeliveries(filter webhookDeliveryFilter) ([]*webhookDelivery, error)
+}
+
+type sqlWebhookRepository struct {
+	db     *sql.DB
+	logger log.Logger
+}
+
+func (r *sqlWebhookRepository) Ping() error {
+	return r.db.Ping()
+}
+
+func (r *sqlWebhookRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *sqlWebhookRepository) recordDelivery(d *webhookDelivery) error {
+	query := `insert into webhook_deliveries (delivery_id, event_id, event_type, url, status, response_code, error, payload, created_at) values (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	stmt, err := r.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("recordDelivery: prepare: %v", err)
+	}
+	defer stmt.Close()
+
+	if _, err := stmt.Exec(d.ID, d.EventID, d.EventType, d.URL, d.Status, d.ResponseCode, d.Error, string(d.Payload), d.CreatedAt); err != nil {
+		return fmt.Errorf("recordDelivery: delivery=%s: %v", d.ID, err)
+	}
+	return nil
+}
+
+func (r *sqlWebhookRepository) getDelivery(deliveryID string) (*webhookDelivery, error) {
+	deliveries, err := r.queryDeliveries(`delivery_id = ?`, []interface{}{deliveryID}, 1)
+	if err != nil {
+		return nil, fmt.Errorf("getDelivery: %v", err)
+	}
+	if len(deliveries) == 0 {
+		return nil, fmt.Errorf("getDelivery: delivery=%s not found", deliveryID)
+	}
+	return deliveries[0], nil
+}
+
+func (r *sqlWebhookRepository) getDeliveries(filter webhookDeliveryFilter) ([]*webhookDelivery, error) {
+	conditions, args := []string{"1 = 1"}, []interface{}{}
+	if filter.EventID != "" {
+		conditions, args = append(conditions, "event_id = ?"), append(args, filter.EventID)
+	}
+	if filter.EventType != "" {
+		conditions, args = append(conditions, "event_type = ?"), append(args, filter.EventType)
+	}
+	if filter.Status != "" {
+		conditions, args = append(conditions, "status = ?"), append(args, filter.Status)
+	}
+	if !filter.Since.IsZero() {
+		conditions, args = append(conditions, "created_at >= ?"), append(args, filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		conditions, args = append(conditions, "created_at < ?"), append(args, filter.Until.UTC())
+	}
+	deliveries, err := r.queryDeliveries(strings.Join(conditions, " and "), args, filter.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("getDeliveries: %v", err)
+	}
+	return deliveries, nil
+}
+
+func (r *sqlWebhookRepository) queryDeliveries(where string, args []interface{}, limit int) ([]*webhookDelivery, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	query := fmt.Sprintf(`select delivery_id, event_id, event_type, url, status, response_code, error, payload, created_at
+from webhook_deliveries where %s order by created_at desc limit %d;`, where, limit)
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*webhookDelivery
+	for rows.Next() {
+		var d webhookDelivery
+		var payload string
+		if err := rows.Scan(&d.ID, &d.EventID, &d.EventType, &d.URL, &d.Status, &d.ResponseCode, &d.Error, &payload, &d.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan: %v", err)
+		}
+		d.Payload = json.RawMessage(payload)
+		out = append(out, &d)
+	}
+	return out, rows.Err()
+}
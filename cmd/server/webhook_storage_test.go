@@ -0,0 +1,103 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+	"github.com/moov-io/base"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestSqlWebhookRepository(t *testing.T) {
+	t.Parallel()
+
+	check := func(t *testing.T, repo *sqlWebhookRepository) {
+		defer repo.Close()
+
+		now := time.Now().UTC().Truncate(time.Second)
+		failed := &webhookDelivery{
+			ID:           base.ID(),
+			EventID:      base.ID(),
+			EventType:    "transaction.posted",
+			URL:          "http://localhost/webhook",
+			Status:       webhookFailed,
+			ResponseCode: 503,
+			Error:        "unexpected HTTP status 503",
+			Payload:      []byte(`{"id":"event"}`),
+			CreatedAt:    now.Add(-1 * time.Hour),
+		}
+		delivered := &webhookDelivery{
+			ID:           base.ID(),
+			EventID:      failed.EventID,
+			EventType:    failed.EventType,
+			URL:          failed.URL,
+			Status:       webhookDelivered,
+			ResponseCode: 200,
+			Payload:      failed.Payload,
+			CreatedAt:    now,
+		}
+		for _, d := range []*webhookDelivery{failed, delivered} {
+			if err := repo.recordDelivery(d); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		d, err := repo.getDelivery(failed.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.EventID != failed.EventID || d.ResponseCode != 503 || string(d.Payload) != `{"id":"event"}` || !d.CreatedAt.Equal(failed.CreatedAt) {
+			t.Errorf("unexpected delivery: %#v", d)
+		}
+		if _, err := repo.getDelivery(base.ID()); err == nil {
+			t.Error("expected error")
+		}
+
+		// newest first
+		deliveries, err := repo.getDeliveries(webhookDeliveryFilter{EventID: failed.EventID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 2 || deliveries[0].ID != delivered.ID {
+			t.Errorf("unexpected deliveries: %#v", deliveries)
+		}
+
+		deliveries, err = repo.getDeliveries(webhookDeliveryFilter{Status: webhookFailed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 || deliveries[0].ID != failed.ID {
+			t.Errorf("unexpected deliveries: %#v", deliveries)
+		}
+
+		deliveries, err = repo.getDeliveries(webhookDeliveryFilter{Since: now.Add(-time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 || deliveries[0].ID != delivered.ID {
+			t.Errorf("unexpected deliveries: %#v", deliveries)
+		}
+
+		deliveries, err = repo.getDeliveries(webhookDeliveryFilter{Until: now.Add(-time.Minute), EventType: "transaction.posted"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(deliveries) != 1 || deliveries[0].ID != failed.ID {
+			t.Errorf("unexpected deliveries: %#v", deliveries)
+		}
+	}
+
+	sqliteDB := database.CreateTestSqliteDB(t)
+	defer sqliteDB.Close()
+	check(t, &sqlWebhookRepository{sqliteDB.DB, log.NewNopLogger()})
+
+	mysqlDB := database.CreateTestMySQLDB(t)
+	defer mysqlDB.Close()
+	check(t, &sqlWebhookRepository{mysqlDB.DB, log.NewNopLogger()})
+}
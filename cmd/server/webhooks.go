@@ -0,0 +1,256 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	moovhttp "github.com/moov-io/base/http"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+// webhookPublisher POSTs each event to url and records every attempt so failed
+// deliveries can be found and redelivered after the consumer recovers.
+type webhookPublisher struct {
+	logger log.Logger
+	client *http.Client
+	url    string
+	repo   webhookRepository
+
+	encoding *eventEncoding
+}
+
+func newWebhookPublisher(logger log.Logger, url string, repo webhookRepository, encoding *eventEncoding) *webhookPublisher {
+	return &webhookPublisher{
+		logger:   logger,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		url:      url,
+		repo:     repo,
+		encoding: encoding,
+	}
+}
+
+func (p *webhookPublisher) publish(evt event) error {
+	bs, err := p.encoding.marshal(evt)
+	if err != nil {
+		return fmt.Errorf("publish: event=%s: %v", evt.ID, err)
+	}
+	delivery, err := p.deliver(evt.ID, evt.Type, bs)
+	if err != nil {
+		return err
+	}
+	if delivery.Status != webhookDelivered {
+		return fmt.Errorf("publish: event=%s delivery=%s failed: %s", evt.ID, delivery.ID, delivery.Error)
+	}
+	return nil
+}
+
+// deliver sends payload to the webhook endpoint and records the attempt. An error is only returned
+// when the attempt couldn't be recorded, failed deliveries are described by the returned delivery.
+func (p *webhookPublisher) deliver(eventID, eventType string, payload []byte) (*webhookDelivery, error) {
+	delivery := &webhookDelivery{
+		ID:        newID(),
+		EventID:   eventID,
+		EventType: eventType,
+		URL:       p.url,
+		Status:    webhookFailed,
+		Payload:   json.RawMessage(payload),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	req, err := http.NewRequest("POST", p.url, bytes.NewReader(payload))
+	if err != nil {
+		delivery.Error = err.Error()
+	} else {
+		req.Header.Set("Content-Type", p.encoding.contentType)
+		resp, err := p.client.Do(req)
+		if err != nil {
+			delivery.Error = err.Error()
+		} else {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+
+			delivery.ResponseCode = resp.StatusCode
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				delivery.Status = webhookDelivered
+			} else {
+				delivery.Error = fmt.Sprintf("unexpected HTTP status %s", resp.Status)
+			}
+		}
+	}
+
+	if err := p.repo.recordDelivery(delivery); err != nil {
+		return nil, fmt.Errorf("deliver: event=%s: %v", eventID, err)
+	}
+	if delivery.Status != webhookDelivered {
+		p.logger.Log("webhooks", fmt.Sprintf("delivery=%s of event=%s failed: %s", delivery.ID, eventID, delivery.Error))
+	}
+	return delivery, nil
+}
+
+// redeliver sends each event matching filter to the webhook endpoint again. When filtering on failed
+// deliveries, events which have since been delivered are skipped.
+func (p *webhookPublisher) redeliver(filter webhookDeliveryFilter) ([]*webhookDelivery, error) {
+	deliveries, err := p.repo.getDeliveries(filter)
+	if err != nil {
+		return nil, fmt.Errorf("redeliver: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	var out []*webhookDelivery
+	for i := range deliveries {
+		d := deliveries[i]
+		if seen[d.EventID] {
+			continue
+		}
+		seen[d.EventID] = true
+
+		if filter.Status == webhookFailed {
+			delivered, err := p.repo.getDeliveries(webhookDeliveryFilter{EventID: d.EventID, Status: webhookDelivered, Limit: 1})
+			if err != nil {
+				return out, fmt.Errorf("redeliver: event=%s: %v", d.EventID, err)
+			}
+			if len(delivered) > 0 {
+				continue
+			}
+		}
+
+		delivery, err := p.deliver(d.EventID, d.EventType, d.Payload)
+		if err != nil {
+			return out, fmt.Errorf("redeliver: %v", err)
+		}
+		out = append(out, delivery)
+	}
+	return out, nil
+}
+
+// readWebhookDeliveryFilter reads the eventID, eventType, status, since, until and limit query parameters.
+// since and until are RFC 3339 timestamps.
+func readWebhookDeliveryFilter(r *http.Request) (webhookDeliveryFilter, error) {
+	q := r.URL.Query()
+	filter := webhookDeliveryFilter{
+		EventID:   q.Get("eventID"),
+		EventType: q.Get("eventType"),
+		Status:    webhookDeliveryStatus(q.Get("status")),
+	}
+	switch filter.Status {
+	case "", webhookDelivered, webhookFailed:
+	default:
+		return filter, fmt.Errorf("unknown webhook delivery status %q", filter.Status)
+	}
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(param); v != "" {
+			tt, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: %v", param, err)
+			}
+			*t = tt
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return filter, fmt.Errorf("invalid limit %q", v)
+		}
+		filter.Limit = n
+	}
+	return filter, nil
+}
+
+// getWebhookDeliveries is an admin route which lists delivery attempts, newest first.
+func getWebhookDeliveries(logger log.Logger, repo webhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		filter, err := readWebhookDeliveryFilter(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		deliveries, err := repo.getDeliveries(filter)
+		if err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem listing deliveries: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if deliveries == nil {
+			deliveries = []*webhookDelivery{}
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
+
+func getWebhookDelivery(logger log.Logger, repo webhookRepository) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		deliveryID := mux.Vars(r)["deliveryId"]
+		if deliveryID == "" {
+			moovhttp.Problem(w, errors.New("no deliveryId found"))
+			return
+		}
+		delivery, err := repo.getDelivery(deliveryID)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(delivery)
+	}
+}
+
+// redeliverWebhooks is an admin route which sends events again, selected by the same query parameters
+// as listing deliveries. Either eventID or a time range is required so every event isn't resent by accident.
+func redeliverWebhooks(logger log.Logger, publisher *webhookPublisher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		filter, err := readWebhookDeliveryFilter(r)
+		if err != nil {
+			moovhttp.Problem(w, err)
+			return
+		}
+		if filter.EventID == "" && filter.Since.IsZero() && filter.Until.IsZero() {
+			moovhttp.Problem(w, errors.New("eventID, since or until is required to redeliver events"))
+			return
+		}
+
+		deliveries, err := publisher.redeliver(filter)
+		if err != nil {
+			logger.Log("webhooks", fmt.Sprintf("problem redelivering events: %v", err))
+			moovhttp.Problem(w, err)
+			return
+		}
+		if deliveries == nil {
+			deliveries = []*webhookDelivery{}
+		}
+		logger.Log("webhooks", fmt.Sprintf("redelivered %d events", len(deliveries)))
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(deliveries)
+	}
+}
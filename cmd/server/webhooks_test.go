@@ -0,0 +1,102 @@
+// Copyright 2020 The Moov Authors
+// Use of this source code is governed by an Apache License
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/moov-io/accounts/cmd/server/database"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+)
+
+func TestWebhookPublisher(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var evt event
+		if err := json.NewDecoder(r.Body).Decode(&evt); err != nil || evt.Type != "transaction.posted" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received++
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	db := database.CreateTestSqliteDB(t)
+	defer db.Close()
+	repo := &sqlWebhookRepository{db.DB, log.NewNopLogger()}
+	publisher := newWebhookPublisher(log.NewNopLogger(), server.URL, repo, jsonEventEncoding)
+
+	evt := newEvent("transaction.posted", map[string]string{"id": "transaction"})
+	if err := publisher.publish(evt); err == nil {
+		t.Fatal("expected error")
+	}
+	deliveries, err := repo.getDeliveries(webhookDeliveryFilter{EventID: evt.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != webhookFailed || deliveries[0].ResponseCode != 503 {
+		t.Fatalf("unexpected deliveries: %#v", deliveries)
+	}
+
+	// redeliver over the admin route once the consumer recovers
+	status = http.StatusOK
+	router := mux.NewRouter()
+	router.Methods("POST").Path("/webhooks/redeliver").HandlerFunc(redeliverWebhooks(log.NewNopLogger(), publisher))
+	router.Methods("GET").Path("/webhooks/deliveries").HandlerFunc(getWebhookDeliveries(log.NewNopLogger(), repo))
+	router.Methods("GET").Path("/webhooks/deliveries/{deliveryId}").HandlerFunc(getWebhookDelivery(log.NewNopLogger(), repo))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/redeliver?status=failed", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected a time range or eventID to be required: %d", w.Code)
+	}
+
+	since := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	for i := 0; i < 2; i++ {
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/webhooks/redeliver?status=failed&since="+since, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("bogus HTTP status: %d: %s", w.Code, w.Body.String())
+		}
+	}
+	// the second redelivery is skipped as the event was delivered
+	if received != 2 {
+		t.Errorf("received %d events", received)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("/webhooks/deliveries?eventID=%s&status=delivered", evt.ID), nil))
+	if err := json.NewDecoder(w.Body).Decode(&deliveries); err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].ResponseCode != 200 {
+		t.Fatalf("unexpected deliveries: %#v", deliveries)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks/deliveries/"+deliveries[0].ID, nil))
+	var delivery webhookDelivery
+	if err := json.NewDecoder(w.Body).Decode(&delivery); err != nil {
+		t.Fatal(err)
+	}
+	if delivery.EventID != evt.ID || delivery.Status != webhookDelivered {
+		t.Errorf("unexpected delivery: %#v", delivery)
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/webhooks/deliveries?status=other", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("bogus HTTP status: %d", w.Code)
+	}
+}
@@ -5,3 +5,9 @@
 package accounts
 
 const Version = "v0.5.0-dev"
+
+// GitCommit and BuildTime describe the build, and are set with -ldflags by 'make build'.
+var (
+	GitCommit string
+	BuildTime string
+)